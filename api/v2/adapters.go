@@ -0,0 +1,171 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/errs"
+)
+
+var (
+	_ Authenticator = &providerAuthenticator{}
+	_ TokenVerifier = &tokenVerifier{}
+	_ Enforcer      = &checkerEnforcer{}
+)
+
+// NewAuthenticator returns an Authenticator of the identity provider p, the context reaches
+// the provider when it is an idprovider.ContextProvider.
+func NewAuthenticator(p idprovider.Provider) Authenticator {
+	return &providerAuthenticator{provider: p}
+}
+
+type providerAuthenticator struct {
+	provider idprovider.Provider
+}
+
+func (a *providerAuthenticator) Authenticate(ctx context.Context, username, password string) (*Identity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	identity, err := idprovider.Authenticate(ctx, a.provider, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return fromIdentity(identity), nil
+}
+
+func (a *providerAuthenticator) AuthenticateCode(ctx context.Context, code string) (*Identity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	identity, err := idprovider.AuthenticateCode(ctx, a.provider, code)
+	if err != nil {
+		return nil, err
+	}
+	return fromIdentity(identity), nil
+}
+
+// AuthCodeURL recovers the panic of the providers without a login redirect, such as LDAP.
+func (a *providerAuthenticator) AuthCodeURL(ctx context.Context, state, nonce string) (url string, err error) {
+	if err = ctx.Err(); err != nil {
+		return "", err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s provider has no login redirect: %v", a.provider.Type(), r)
+		}
+	}()
+	return a.provider.AuthCodeURL(state, nonce), nil
+}
+
+func fromIdentity(identity idprovider.Identity) *Identity {
+	return &Identity{
+		UserID:     identity.GetUserID(),
+		TenantID:   identity.GetTenantID(),
+		Username:   identity.GetUsername(),
+		Email:      identity.GetEmail(),
+		ExternalID: identity.GetExternalID(),
+		Groups:     idprovider.Groups(identity),
+		Extra:      identity.GetExtra(),
+	}
+}
+
+// NewTokenVerifier returns a TokenVerifier of the tokens verified by v.
+func NewTokenVerifier(v token.Verifier) TokenVerifier {
+	return &tokenVerifier{verifier: v}
+}
+
+type tokenVerifier struct {
+	verifier token.Verifier
+}
+
+func (v *tokenVerifier) VerifyToken(ctx context.Context, raw string) (*Claims, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	claims, err := v.verifier.Verify(raw)
+	if err != nil {
+		return nil, err
+	}
+	return fromClaims(claims), nil
+}
+
+func fromClaims(claims *token.Claims) *Claims {
+	c := &Claims{
+		ID:       claims.ID,
+		Issuer:   claims.Issuer,
+		Subject:  claims.Subject,
+		TenantID: claims.TenantID,
+		Username: claims.Username,
+		Scopes:   strings.Fields(claims.Scope),
+		Extra:    claims.Extra,
+	}
+	if claims.Actor != nil {
+		c.ActorSubject = claims.Actor.Subject
+	}
+	if claims.IssuedAt != 0 {
+		c.IssuedAt = time.Unix(claims.IssuedAt, 0)
+	}
+	if claims.ExpiresAt != 0 {
+		c.ExpiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+	return c
+}
+
+// NewEnforcer returns an Enforcer deciding with checker, decisions carry the deciding rules
+// when checker is an authorizer.Explainer.
+func NewEnforcer(checker authorizer.Checker) Enforcer {
+	return &checkerEnforcer{checker: checker}
+}
+
+type checkerEnforcer struct {
+	checker authorizer.Checker
+}
+
+func (e *checkerEnforcer) Enforce(ctx context.Context, req *Request) (*Decision, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if explainer, ok := e.checker.(authorizer.Explainer); ok {
+		allowed, rules, err := explainer.Explain(req.Subject, req.TenantID, req.Resource, req.Action)
+		if err != nil {
+			return nil, err
+		}
+		return &Decision{Allowed: allowed, Rules: rules}, nil
+	}
+	allowed, err := e.checker.Check(req.Subject, req.TenantID, req.Resource, req.Action)
+	if err != nil {
+		return nil, err
+	}
+	return &Decision{Allowed: allowed}, nil
+}
+
+// Require returns an error matching errs.ErrPermissionDenied when e denies req.
+func Require(ctx context.Context, e Enforcer, req *Request) error {
+	d, err := e.Enforce(ctx, req)
+	if err != nil {
+		return fmt.Errorf("enforce %w", err)
+	}
+	if !d.Allowed {
+		return errs.New(errs.ErrPermissionDenied, "authz.enforce", fmt.Errorf("%s %s %s", req.Subject, req.Action, req.Resource))
+	}
+	return nil
+}
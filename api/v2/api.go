@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package api the stable, context-first contracts of the security library for downstream
+// tkeel components, version v2. The types only carry what the contracts promise, so the
+// implementations behind the adapters may change without breaking callers, e.g.
+//
+//	authn := api.NewAuthenticator(provider)
+//	verifier := api.NewTokenVerifier(tokenManager)
+//	enforcer := api.NewEnforcer(roleOperator)
+//
+// Errors match the kinds of package errs with errors.Is.
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// Version of the contracts.
+const Version = "v2"
+
+// Identity the user an Authenticator authenticated.
+type Identity struct {
+	UserID   string
+	TenantID string
+	Username string
+	Email    string
+	// ExternalID the id of the user at the identity provider.
+	ExternalID string
+	Groups     []string
+	// Extra other claims of the identity provider.
+	Extra map[string]interface{}
+}
+
+// Claims the validated claims of an access token.
+type Claims struct {
+	// ID unique token identifier.
+	ID       string
+	Issuer   string
+	Subject  string
+	TenantID string
+	Username string
+	Scopes   []string
+	// ActorSubject the subject acting as Subject, set on impersonation and delegation tokens.
+	ActorSubject string
+	IssuedAt     time.Time
+	ExpiresAt    time.Time
+	// Extra other claims.
+	Extra map[string]interface{}
+}
+
+// Request a permission request, may Subject perform Action on Resource in the tenant.
+type Request struct {
+	Subject  string
+	TenantID string
+	Resource string
+	Action   string
+}
+
+// Decision the answer to a Request.
+type Decision struct {
+	Allowed bool
+	// Rules the policy rules that decided the request, when the enforcer explains its decisions.
+	Rules []string
+}
+
+// Authenticator authenticates users with an identity provider.
+type Authenticator interface {
+	// Authenticate authenticates username and password, failing with errs.ErrCodeExchangeFailed
+	// or errs.ErrIdentityNotFound when the provider rejects them.
+	Authenticate(ctx context.Context, username, password string) (*Identity, error)
+	// AuthenticateCode exchanges the authorization code or ticket the provider redirected with.
+	AuthenticateCode(ctx context.Context, code string) (*Identity, error)
+	// AuthCodeURL returns the URL of the provider login redirecting back with state and nonce.
+	AuthCodeURL(ctx context.Context, state, nonce string) (string, error)
+}
+
+// TokenVerifier verifies access tokens.
+type TokenVerifier interface {
+	// VerifyToken returns the claims of token, failing with errs.ErrInvalidToken or
+	// errs.ErrTokenExpired when it is rejected.
+	VerifyToken(ctx context.Context, token string) (*Claims, error)
+}
+
+// Enforcer decides permission requests.
+type Enforcer interface {
+	// Enforce returns the decision of req, an error only when it could not be decided.
+	Enforce(ctx context.Context, req *Request) (*Decision, error)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/idprovider/fake"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/errs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type explainer struct{}
+
+func (explainer) Check(subject, tenantID, resource, action string) (bool, error) {
+	return subject == "admin", nil
+}
+
+func (e explainer) Explain(subject, tenantID, resource, action string) (bool, []string, error) {
+	allowed, _ := e.Check(subject, tenantID, resource, action)
+	if !allowed {
+		return false, nil, nil
+	}
+	return true, []string{"p, admin, " + tenantID + ", *, *, allow"}, nil
+}
+
+func TestAuthenticator(t *testing.T) {
+	p := fake.NewProvider().
+		AddUser("alice", "pa55", fake.NewIdentity("u-1").WithTenant("t-1").WithGroups("admins")).
+		AddCode("code", fake.NewIdentity("u-2"))
+	authn := NewAuthenticator(p)
+
+	identity, err := authn.Authenticate(context.Background(), "alice", "pa55")
+	assert.NoError(t, err)
+	assert.Equal(t, &Identity{UserID: "u-1", TenantID: "t-1", Username: "u-1", ExternalID: "u-1", Groups: []string{"admins"},
+		Extra: map[string]interface{}{"groups": []string{"admins"}}}, identity)
+
+	identity, err = authn.AuthenticateCode(context.Background(), "code")
+	assert.NoError(t, err)
+	assert.Equal(t, "u-2", identity.UserID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = authn.AuthenticateCode(ctx, "code")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTokenVerifier(t *testing.T) {
+	m, err := token.NewJWTManager(&token.Config{Issuer: "tkeel", SigningKey: "secret"})
+	assert.NoError(t, err)
+	raw, err := m.Issue(&token.Claims{Subject: "u-1", TenantID: "t-1", Scope: "read write", Actor: &token.Actor{Subject: "admin"}})
+	assert.NoError(t, err)
+
+	claims, err := NewTokenVerifier(m).VerifyToken(context.Background(), raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "u-1", claims.Subject)
+	assert.Equal(t, "tkeel", claims.Issuer)
+	assert.Equal(t, []string{"read", "write"}, claims.Scopes)
+	assert.Equal(t, "admin", claims.ActorSubject)
+	assert.True(t, claims.ExpiresAt.After(claims.IssuedAt))
+
+	_, err = NewTokenVerifier(m).VerifyToken(context.Background(), raw+"x")
+	assert.ErrorIs(t, err, errs.ErrInvalidToken)
+}
+
+func TestEnforcer(t *testing.T) {
+	e := NewEnforcer(explainer{})
+	tests := []struct {
+		name    string
+		subject string
+		want    *Decision
+		wantErr error
+	}{
+		{"allowed", "admin", &Decision{Allowed: true, Rules: []string{"p, admin, t-1, *, *, allow"}}, nil},
+		{"denied", "guest", &Decision{}, errs.ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Subject: tt.subject, TenantID: "t-1", Resource: "device", Action: "read"}
+			d, err := e.Enforce(context.Background(), req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, d)
+			err = Require(context.Background(), e, req)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
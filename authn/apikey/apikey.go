@@ -0,0 +1,372 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package apikey issues long-lived credentials for machine clients. A key reads
+// tk_<id>_<secret><checksum>: the id locates the key, only the hash of the secret is stored
+// and the CRC-32 checksum lets scanners and the verifier reject mistyped keys offline.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	_defaultPrefix = "tk"
+	_idLength      = 12
+	_secretLength  = 32
+	_checksumLen   = 6
+	_alphabet      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// _touchInterval how often verifications record the last use, so not every request writes.
+	_touchInterval = time.Minute
+
+	// TokenType the token_type extra claim of verified keys.
+	TokenType = "api_key"
+)
+
+// ErrKeyNotFound the store does not know the key.
+var ErrKeyNotFound = errors.New("api key not found")
+
+// Key the stored part of an API key.
+type Key struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	TenantID string   `json:"tenant_id"`
+	Owner    string   `json:"owner"`
+	Scopes   []string `json:"scopes"`
+	// Hash hex SHA-256 of the secret, the secret has enough entropy for a fast hash.
+	Hash string `json:"-"`
+	// ExpiresAt zero for keys without expiry.
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	RevokedAt  time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Active reports whether the key is neither revoked nor expired.
+func (k *Key) Active() bool {
+	now := time.Now()
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// Store persists keys.
+type Store interface {
+	Create(ctx context.Context, k *Key) error
+	// Get returns the key with id or ErrKeyNotFound.
+	Get(ctx context.Context, id string) (*Key, error)
+	// List returns the keys of tenantID, of owner unless it is empty.
+	List(ctx context.Context, tenantID, owner string) ([]*Key, error)
+	Update(ctx context.Context, k *Key) error
+	// Touch records the use of the key with id at, only while it exists and is not revoked, so a
+	// concurrent Revoke is never undone.
+	Touch(ctx context.Context, id string, at time.Time) error
+}
+
+// Config of the keys.
+type Config struct {
+	// Prefix of the keys, identifying their issuer to secret scanners. Default to tk.
+	Prefix string `mapstructure:"prefix" json:"prefix" yaml:"prefix"`
+}
+
+// CreateOptions the attributes of a new key.
+type CreateOptions struct {
+	Name     string
+	TenantID string
+	// Owner the subject the key acts as, e.g. a service account.
+	Owner  string
+	Scopes []string
+	// TTL zero for a key without expiry.
+	TTL time.Duration
+}
+
+// Manager creates, verifies and revokes keys.
+type Manager struct {
+	conf   Config
+	store  Store
+	logger *log.Helper
+}
+
+var _ token.Verifier = &Manager{}
+
+func NewManager(conf Config, store Store) *Manager {
+	if conf.Prefix == "" {
+		conf.Prefix = _defaultPrefix
+	}
+	return &Manager{conf: conf, store: store}
+}
+
+// SetLogger writes the entries of the key verification to l instead of the global logger.
+func (m *Manager) SetLogger(l log.Logger) {
+	m.logger = log.NewHelper(l)
+}
+
+// Create issues a key, the returned raw key is shown once and can not be recovered.
+func (m *Manager) Create(opts CreateOptions) (string, *Key, error) {
+	return m.CreateContext(context.Background(), opts)
+}
+
+// CreateContext is Create bounded by ctx.
+func (m *Manager) CreateContext(ctx context.Context, opts CreateOptions) (string, *Key, error) {
+	id, err := randString(_idLength)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randString(_secretLength)
+	if err != nil {
+		return "", nil, err
+	}
+	k := &Key{
+		ID:        id,
+		Name:      opts.Name,
+		TenantID:  opts.TenantID,
+		Owner:     opts.Owner,
+		Scopes:    opts.Scopes,
+		Hash:      hashSecret(secret),
+		CreatedAt: time.Now(),
+	}
+	if opts.TTL > 0 {
+		k.ExpiresAt = k.CreatedAt.Add(opts.TTL)
+	}
+	ctx, cancel := utils.WithTimeout(ctx, 0)
+	defer cancel()
+	if err = m.store.Create(ctx, k); err != nil {
+		return "", nil, fmt.Errorf("create api key %w", err)
+	}
+	body := m.conf.Prefix + "_" + id + "_" + secret
+	return body + checksum(body), k, nil
+}
+
+// List returns the keys of tenantID, of owner unless it is empty.
+func (m *Manager) List(tenantID, owner string) ([]*Key, error) {
+	return m.ListContext(context.Background(), tenantID, owner)
+}
+
+// ListContext is List bounded by ctx.
+func (m *Manager) ListContext(ctx context.Context, tenantID, owner string) ([]*Key, error) {
+	ctx, cancel := utils.WithTimeout(ctx, 0)
+	defer cancel()
+	return m.store.List(ctx, tenantID, owner)
+}
+
+// Revoke ends the key with id.
+func (m *Manager) Revoke(id string) error {
+	return m.RevokeContext(context.Background(), id)
+}
+
+// RevokeContext is Revoke bounded by ctx.
+func (m *Manager) RevokeContext(ctx context.Context, id string) error {
+	ctx, cancel := utils.WithTimeout(ctx, 0)
+	defer cancel()
+	k, err := m.store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !k.RevokedAt.IsZero() {
+		return nil
+	}
+	k.RevokedAt = time.Now()
+	return m.store.Update(ctx, k)
+}
+
+// RevokeTenant ends all keys of the tenant, e.g. when it is offboarded.
+func (m *Manager) RevokeTenant(tenantID string) error {
+	return m.RevokeTenantContext(context.Background(), tenantID)
+}
+
+// RevokeTenantContext is RevokeTenant bounded by ctx.
+func (m *Manager) RevokeTenantContext(ctx context.Context, tenantID string) error {
+	ctx, cancel := utils.WithTimeout(ctx, 0)
+	defer cancel()
+	keys, err := m.store.List(ctx, tenantID, "")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if !k.RevokedAt.IsZero() {
+			continue
+		}
+		k.RevokedAt = time.Now()
+		if err = m.store.Update(ctx, k); err != nil {
+			return fmt.Errorf("revoke key %s %w", k.ID, err)
+		}
+	}
+	return nil
+}
+
+// Rotate issues a replacement of the key with id carrying its attributes. The old key keeps
+// working for grace so clients can switch over, a zero grace revokes it at once.
+func (m *Manager) Rotate(id string, grace time.Duration) (string, *Key, error) {
+	return m.RotateContext(context.Background(), id, grace)
+}
+
+// RotateContext is Rotate bounded by ctx.
+func (m *Manager) RotateContext(ctx context.Context, id string, grace time.Duration) (string, *Key, error) {
+	ctx, cancel := utils.WithTimeout(ctx, 0)
+	defer cancel()
+	old, err := m.store.Get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if !old.Active() {
+		return "", nil, fmt.Errorf("rotate inactive key %s: %w", id, token.ErrTokenRevoked)
+	}
+	opts := CreateOptions{Name: old.Name, TenantID: old.TenantID, Owner: old.Owner, Scopes: old.Scopes}
+	if !old.ExpiresAt.IsZero() {
+		opts.TTL = old.ExpiresAt.Sub(old.CreatedAt)
+	}
+	raw, k, err := m.CreateContext(ctx, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	if grace <= 0 {
+		old.RevokedAt = time.Now()
+	} else if end := time.Now().Add(grace); old.ExpiresAt.IsZero() || end.Before(old.ExpiresAt) {
+		old.ExpiresAt = end
+	}
+	if err = m.store.Update(ctx, old); err != nil {
+		return "", nil, fmt.Errorf("retire rotated api key %w", err)
+	}
+	return raw, k, nil
+}
+
+// IsKey reports whether raw has the shape of a key of m, without checking it.
+func (m *Manager) IsKey(raw string) bool {
+	return strings.HasPrefix(raw, m.conf.Prefix+"_")
+}
+
+// KeyID returns the id of the key raw without looking it up, ok is false when raw is not a well
+// formed key of m. The id is not authenticated, verify raw before trusting it.
+func (m *Manager) KeyID(raw string) (id string, ok bool) {
+	id, _, ok = m.parse(raw)
+	return id, ok
+}
+
+// Verify checks raw and returns claims acting as the owner of the key, satisfies token.Verifier.
+func (m *Manager) Verify(raw string) (*token.Claims, error) {
+	id, secret, ok := m.parse(raw)
+	if !ok {
+		return nil, token.ErrInvalidToken
+	}
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	k, err := m.store.Get(ctx, id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, token.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load api key %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashSecret(secret))) != 1 {
+		return nil, token.ErrInvalidToken
+	}
+	if !k.RevokedAt.IsZero() {
+		return nil, token.ErrTokenRevoked
+	}
+	now := time.Now()
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return nil, token.ErrTokenExpired
+	}
+	if now.Sub(k.LastUsedAt) >= _touchInterval {
+		if err = m.store.Touch(ctx, k.ID, now); err != nil {
+			m.logger.Warnf("record use of api key %s: %s", k.ID, err)
+		}
+	}
+	claims := &token.Claims{
+		ID:       k.ID,
+		Subject:  k.Owner,
+		TenantID: k.TenantID,
+		Scope:    strings.Join(k.Scopes, " "),
+		IssuedAt: k.CreatedAt.Unix(),
+		Extra:    map[string]interface{}{"token_type": TokenType, "key_name": k.Name},
+	}
+	if !k.ExpiresAt.IsZero() {
+		claims.ExpiresAt = k.ExpiresAt.Unix()
+	}
+	return claims, nil
+}
+
+func (m *Manager) parse(raw string) (id, secret string, ok bool) {
+	if !m.IsKey(raw) || len(raw) <= _checksumLen {
+		return "", "", false
+	}
+	body, sum := raw[:len(raw)-_checksumLen], raw[len(raw)-_checksumLen:]
+	if subtle.ConstantTimeCompare([]byte(checksum(body)), []byte(sum)) != 1 {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(body, m.conf.Prefix+"_"), "_")
+	if len(parts) != 2 || len(parts[0]) != _idLength || len(parts[1]) != _secretLength {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+type verifier struct {
+	keys     *Manager
+	fallback token.Verifier
+}
+
+// Verifier returns a token.Verifier checking API keys with keys and all other tokens with
+// fallback, so middleware accepts both.
+func Verifier(keys *Manager, fallback token.Verifier) token.Verifier {
+	return &verifier{keys: keys, fallback: fallback}
+}
+
+func (v *verifier) Verify(raw string) (*token.Claims, error) {
+	// the checksum tells keys from other tokens that happen to start with the prefix.
+	if _, _, ok := v.keys.parse(raw); ok {
+		return v.keys.Verify(raw)
+	}
+	return v.fallback.Verify(raw)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// checksum the base62 CRC-32 of body, zero padded.
+func checksum(body string) string {
+	n := crc32.ChecksumIEEE([]byte(body))
+	sum := make([]byte, _checksumLen)
+	for i := _checksumLen - 1; i >= 0; i-- {
+		sum[i] = _alphabet[n%62]
+		n /= 62
+	}
+	return string(sum)
+}
+
+func randString(length int) (string, error) {
+	buf := make([]byte, length)
+	max := big.NewInt(int64(len(_alphabet)))
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("api key %w", err)
+		}
+		buf[i] = _alphabet[n.Int64()]
+	}
+	return string(buf), nil
+}
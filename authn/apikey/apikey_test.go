@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apikey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestManager(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormStore(db)
+	assert.NoError(t, err)
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			m := NewManager(Config{}, store)
+			raw, k, err := m.Create(CreateOptions{Name: "ci", TenantID: "tnt-1", Owner: "svc-ci", Scopes: []string{"read", "write"}, TTL: time.Hour})
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(raw, "tk_"+k.ID+"_"))
+			assert.Len(t, raw, len("tk__")+_idLength+_secretLength+_checksumLen)
+
+			claims, err := m.Verify(raw)
+			assert.NoError(t, err)
+			assert.Equal(t, "svc-ci", claims.Subject)
+			assert.Equal(t, "tnt-1", claims.TenantID)
+			assert.Equal(t, "read write", claims.Scope)
+			assert.Equal(t, TokenType, claims.Extra["token_type"])
+			stored, err := store.Get(context.Background(), k.ID)
+			assert.NoError(t, err)
+			assert.False(t, stored.LastUsedAt.IsZero())
+
+			// a typo fails the checksum, a forged secret with a valid checksum the hash.
+			_, err = m.Verify(raw[:len(raw)-1] + "x")
+			assert.ErrorIs(t, err, token.ErrInvalidToken)
+			body := "tk_" + k.ID + "_" + strings.Repeat("A", _secretLength)
+			_, err = m.Verify(body + checksum(body))
+			assert.ErrorIs(t, err, token.ErrInvalidToken)
+
+			rotated, k2, err := m.Rotate(k.ID, time.Minute)
+			assert.NoError(t, err)
+			assert.NotEqual(t, k.ID, k2.ID)
+			_, err = m.Verify(raw)
+			assert.NoError(t, err)
+			_, err = m.Verify(rotated)
+			assert.NoError(t, err)
+			keys, err := m.List("tnt-1", "svc-ci")
+			assert.NoError(t, err)
+			assert.Len(t, keys, 2)
+			keys, err = m.List("tnt-2", "")
+			assert.NoError(t, err)
+			assert.Empty(t, keys)
+
+			assert.NoError(t, m.Revoke(k.ID))
+			_, err = m.Verify(raw)
+			assert.ErrorIs(t, err, token.ErrTokenRevoked)
+			_, _, err = m.Rotate(k.ID, 0)
+			assert.ErrorIs(t, err, token.ErrTokenRevoked)
+			assert.ErrorIs(t, m.Revoke("unknown"), ErrKeyNotFound)
+			// recording a use never brings back a key revoked in between.
+			assert.NoError(t, store.Touch(context.Background(), k.ID, time.Now()))
+			_, err = m.Verify(raw)
+			assert.ErrorIs(t, err, token.ErrTokenRevoked)
+		})
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	keys := NewManager(Config{Prefix: "tkeel"}, NewMemoryStore())
+	raw, _, err := keys.Create(CreateOptions{TenantID: "tnt-1", Owner: "svc-ci"})
+	assert.NoError(t, err)
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	access, err := tokens.Issue(&token.Claims{Subject: "alice"})
+	assert.NoError(t, err)
+
+	h := middleware.NewAuthenticator(Verifier(keys, tokens), middleware.Config{}).Middleware(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(middleware.SubjectFromContext(r.Context())))
+		}))
+	for credential, subject := range map[string]string{raw: "svc-ci", access: "alice"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+credential)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, subject, w.Body.String())
+	}
+}
+
+// ctxStore records the contexts of the writes it receives.
+type ctxStore struct {
+	*MemoryStore
+	ctxs []context.Context
+}
+
+func (s *ctxStore) Create(ctx context.Context, k *Key) error {
+	s.ctxs = append(s.ctxs, ctx)
+	return s.MemoryStore.Create(ctx, k)
+}
+
+func (s *ctxStore) Update(ctx context.Context, k *Key) error {
+	s.ctxs = append(s.ctxs, ctx)
+	return s.MemoryStore.Update(ctx, k)
+}
+
+func TestContext(t *testing.T) {
+	store := &ctxStore{MemoryStore: NewMemoryStore()}
+	m := NewManager(Config{}, store)
+
+	// without a ctx the store calls are bounded by the default timeout.
+	_, k, err := m.Create(CreateOptions{TenantID: "tnt-1", Owner: "svc-ci"})
+	assert.NoError(t, err)
+	_, ok := store.ctxs[0].Deadline()
+	assert.True(t, ok)
+
+	// the deadline and cancellation of the caller reach the store.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = m.RotateContext(ctx, k.ID, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, m.RevokeTenantContext(ctx, "tnt-1"))
+	assert.Len(t, store.ctxs, 4)
+	for _, c := range store.ctxs[1:] {
+		assert.ErrorIs(t, c.Err(), context.Canceled)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+var (
+	_ Store = &MemoryStore{}
+	_ Store = &GormStore{}
+)
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock sync.RWMutex
+	keys map[string]Key
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]Key)}
+}
+
+func (s *MemoryStore) Create(_ context.Context, k *Key) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.keys[k.ID]; ok {
+		return fmt.Errorf("duplicate api key id %s", k.ID)
+	}
+	s.keys[k.ID] = *k
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, id string) (*Key, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &k, nil
+}
+
+func (s *MemoryStore) List(_ context.Context, tenantID, owner string) ([]*Key, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := make([]*Key, 0)
+	for _, k := range s.keys {
+		if k.TenantID == tenantID && (owner == "" || k.Owner == owner) {
+			k := k
+			keys = append(keys, &k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *MemoryStore) Update(_ context.Context, k *Key) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.keys[k.ID]; !ok {
+		return ErrKeyNotFound
+	}
+	s.keys[k.ID] = *k
+	return nil
+}
+
+func (s *MemoryStore) Touch(_ context.Context, id string, at time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if k, ok := s.keys[id]; ok && k.RevokedAt.IsZero() {
+		k.LastUsedAt = at
+		s.keys[id] = k
+	}
+	return nil
+}
+
+// GormStore keeps the keys next to the users.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore migrates the table and returns a GormStore.
+func NewGormStore(db *gorm.DB) (*GormStore, error) {
+	if err := db.AutoMigrate(&model.APIKey{}); err != nil {
+		return nil, fmt.Errorf("migrate api keys %w", err)
+	}
+	return &GormStore{db: db}, nil
+}
+
+func (s *GormStore) Create(ctx context.Context, k *Key) error {
+	return toModel(k).Create(s.db.WithContext(ctx))
+}
+
+func (s *GormStore) Get(ctx context.Context, id string) (*Key, error) {
+	row := &model.APIKey{ID: id}
+	found, err := row.Get(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	return fromModel(row), nil
+}
+
+func (s *GormStore) List(ctx context.Context, tenantID, owner string) ([]*Key, error) {
+	rows, err := model.ListAPIKeys(s.db.WithContext(ctx), tenantID, owner)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*Key, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, fromModel(row))
+	}
+	return keys, nil
+}
+
+func (s *GormStore) Update(ctx context.Context, k *Key) error {
+	return toModel(k).Save(s.db.WithContext(ctx))
+}
+
+func (s *GormStore) Touch(ctx context.Context, id string, at time.Time) error {
+	return model.TouchAPIKey(s.db.WithContext(ctx), id, at)
+}
+
+func toModel(k *Key) *model.APIKey {
+	return &model.APIKey{
+		ID:         k.ID,
+		Name:       k.Name,
+		TenantID:   k.TenantID,
+		Owner:      k.Owner,
+		Scopes:     strings.Join(k.Scopes, " "),
+		Hash:       k.Hash,
+		ExpiresAt:  timePtr(k.ExpiresAt),
+		RevokedAt:  timePtr(k.RevokedAt),
+		LastUsedAt: timePtr(k.LastUsedAt),
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
+func fromModel(row *model.APIKey) *Key {
+	k := &Key{
+		ID:        row.ID,
+		Name:      row.Name,
+		TenantID:  row.TenantID,
+		Owner:     row.Owner,
+		Scopes:    strings.Fields(row.Scopes),
+		Hash:      row.Hash,
+		CreatedAt: row.CreatedAt,
+	}
+	if row.ExpiresAt != nil {
+		k.ExpiresAt = *row.ExpiresAt
+	}
+	if row.RevokedAt != nil {
+		k.RevokedAt = *row.RevokedAt
+	}
+	if row.LastUsedAt != nil {
+		k.LastUsedAt = *row.LastUsedAt
+	}
+	return k
+}
+
+// timePtr maps the zero time to NULL.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,374 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package deviceca issues short-lived X.509 client certificates to registered devices, so the
+// identity of a device rests on a key that never leaves it.
+package deviceca
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/tkeel-io/security/log"
+)
+
+const (
+	_defaultCertTTL  = 24 * time.Hour
+	_defaultMaxTTL   = 30 * 24 * time.Hour
+	_defaultCRLTTL   = time.Hour
+	_defaultMinRSA   = 2048
+	_backdate        = time.Minute
+	_defaultCAYears  = 10
+	_serialBits      = 128
+	_uriScheme       = "device"
+	_pemTypeCert     = "CERTIFICATE"
+	_pemTypeCSR      = "CERTIFICATE REQUEST"
+	_pemTypeNewCSR   = "NEW CERTIFICATE REQUEST"
+	_pemTypeECKey    = "EC PRIVATE KEY"
+	_pemTypePKCS8Key = "PRIVATE KEY"
+)
+
+var (
+	// ErrInvalidCSR the request does not parse or its signature does not verify.
+	ErrInvalidCSR = errors.New("invalid certificate signing request")
+	// ErrPolicy the request violates the issuance policy.
+	ErrPolicy = errors.New("certificate request violates policy")
+	// ErrDeviceNotFound the device is not registered, or not in the tenant of the caller.
+	ErrDeviceNotFound = errors.New("device not registered")
+	// ErrDeviceDisabled the device is disabled and gets no certificates.
+	ErrDeviceDisabled = errors.New("device disabled")
+	// ErrCertificateRevoked the presented certificate was revoked.
+	ErrCertificateRevoked = errors.New("certificate revoked")
+	// ErrUntrustedCertificate the certificate was not issued by this CA or expired.
+	ErrUntrustedCertificate = errors.New("untrusted device certificate")
+)
+
+// Device a registered device.
+type Device struct {
+	ID       string
+	TenantID string
+	// Disabled devices get no certificates.
+	Disabled bool
+}
+
+// Registry knows the registered devices.
+type Registry interface {
+	// Device returns the device with id, or ErrDeviceNotFound.
+	Device(ctx context.Context, id string) (*Device, error)
+}
+
+// RegistryFunc adapts a function to a Registry.
+type RegistryFunc func(ctx context.Context, id string) (*Device, error)
+
+func (f RegistryFunc) Device(ctx context.Context, id string) (*Device, error) {
+	return f(ctx, id)
+}
+
+// Config of the issuance.
+type Config struct {
+	// CertTTL lifetime of issued certificates. Default to 24h.
+	CertTTL time.Duration `mapstructure:"cert_ttl" json:"cert_ttl" yaml:"certTtl"`
+	// CRLTTL how long a published CRL is valid. Default to 1h.
+	CRLTTL time.Duration `mapstructure:"crl_ttl" json:"crl_ttl" yaml:"crlTtl"`
+	// MinRSABits smallest RSA key accepted. Default to 2048.
+	MinRSABits int `mapstructure:"min_rsa_bits" json:"min_rsa_bits" yaml:"minRsaBits"`
+}
+
+// CA signs device certificates.
+type CA struct {
+	conf     Config
+	cert     *x509.Certificate
+	key      crypto.Signer
+	registry Registry
+	store    Store
+	logger   *log.Helper
+}
+
+// New returns a CA issuing under cert and key.
+func New(conf Config, cert *x509.Certificate, key crypto.Signer, registry Registry, store Store) (*CA, error) {
+	if conf.CertTTL <= 0 {
+		conf.CertTTL = _defaultCertTTL
+	}
+	if conf.CertTTL > _defaultMaxTTL {
+		return nil, fmt.Errorf("device certificate ttl %s exceeds %s", conf.CertTTL, _defaultMaxTTL)
+	}
+	if conf.CRLTTL <= 0 {
+		conf.CRLTTL = _defaultCRLTTL
+	}
+	if conf.MinRSABits <= 0 {
+		conf.MinRSABits = _defaultMinRSA
+	}
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("ca certificate %s can not sign certificates", cert.Subject)
+	}
+	return &CA{conf: conf, cert: cert, key: key, registry: registry, store: store}, nil
+}
+
+// SetLogger writes the entries of the device endpoints to l instead of the global logger.
+func (ca *CA) SetLogger(l log.Logger) {
+	ca.logger = log.NewHelper(l)
+}
+
+// Certificate returns the CA certificate, the trust anchor of the verifiers.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// Issue signs the PEM or DER encoded CSR of the device with id registered in the tenant of the
+// caller. The subject common name must be the device id and the request may carry no names of
+// its own.
+func (ca *CA) Issue(ctx context.Context, tenantID, deviceID string, csr []byte) (*x509.Certificate, error) {
+	device, err := ca.registry.Device(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device.TenantID != tenantID {
+		return nil, fmt.Errorf("%s: %w", deviceID, ErrDeviceNotFound)
+	}
+	if device.Disabled {
+		return nil, fmt.Errorf("%s: %w", deviceID, ErrDeviceDisabled)
+	}
+	req, err := ca.checkCSR(csr, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), _serialBits))
+	if err != nil {
+		return nil, fmt.Errorf("certificate serial %w", err)
+	}
+	now := time.Now()
+	notAfter := now.Add(ca.conf.CertTTL)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: device.ID, Organization: []string{device.TenantID}},
+		URIs:         []*url.URL{DeviceURI(device.TenantID, device.ID)},
+		NotBefore:    now.Add(-_backdate),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, req.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("sign device certificate %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("parse device certificate %w", err)
+	}
+	if err = ca.store.Save(&Issued{Serial: serialString(serial), DeviceID: device.ID, TenantID: device.TenantID, NotAfter: notAfter}); err != nil {
+		return nil, fmt.Errorf("record device certificate %w", err)
+	}
+	return cert, nil
+}
+
+// Renew issues a fresh certificate to the device presenting the still valid current, as long as
+// the device is still enabled and registered in the tenant current was issued for.
+func (ca *CA) Renew(ctx context.Context, current *x509.Certificate, csr []byte) (*x509.Certificate, error) {
+	device, err := ca.Verify(current)
+	if err != nil {
+		return nil, err
+	}
+	return ca.Issue(ctx, device.TenantID, device.ID, csr)
+}
+
+// Verify checks that cert was issued by the CA, is valid and not revoked, and returns its device.
+func (ca *CA) Verify(cert *x509.Certificate) (*Device, error) {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUntrustedCertificate, err)
+	}
+	issued, err := ca.store.Get(serialString(cert.SerialNumber))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUntrustedCertificate, err)
+	}
+	if !issued.RevokedAt.IsZero() {
+		return nil, ErrCertificateRevoked
+	}
+	return &Device{ID: issued.DeviceID, TenantID: issued.TenantID}, nil
+}
+
+// Revoke revokes the certificate with the hex serial.
+func (ca *CA) Revoke(serial string) error {
+	return ca.store.Revoke("", serial)
+}
+
+// RevokeDevice revokes all certificates of the device with id, e.g. when it is decommissioned.
+func (ca *CA) RevokeDevice(id string) error {
+	return ca.store.Revoke(id, "")
+}
+
+// CRL returns the DER certificate revocation list of the unexpired revoked certificates.
+func (ca *CA) CRL() ([]byte, error) {
+	revoked, err := ca.store.Revoked()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]pkix.RevokedCertificate, 0, len(revoked))
+	for _, r := range revoked {
+		serial, ok := new(big.Int).SetString(r.Serial, 16)
+		if !ok {
+			continue
+		}
+		entries = append(entries, pkix.RevokedCertificate{SerialNumber: serial, RevocationTime: r.RevokedAt})
+	}
+	now := time.Now()
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificates: entries,
+		Number:              big.NewInt(now.Unix()),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(ca.conf.CRLTTL),
+	}, ca.cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("sign crl %w", err)
+	}
+	return crl, nil
+}
+
+// DeviceURI the URI SAN naming the device in its certificate.
+func DeviceURI(tenantID, deviceID string) *url.URL {
+	return &url.URL{Scheme: _uriScheme, Host: tenantID, Path: "/" + deviceID}
+}
+
+func (ca *CA) checkCSR(data []byte, deviceID string) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != _pemTypeCSR && block.Type != _pemTypeNewCSR {
+			return nil, fmt.Errorf("%w: pem type %s", ErrInvalidCSR, block.Type)
+		}
+		data = block.Bytes
+	}
+	req, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCSR, err)
+	}
+	if err = req.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCSR, err)
+	}
+	if req.Subject.CommonName != deviceID {
+		return nil, fmt.Errorf("%w: common name %q is not the device id", ErrPolicy, req.Subject.CommonName)
+	}
+	if len(req.DNSNames)+len(req.IPAddresses)+len(req.EmailAddresses)+len(req.URIs) > 0 {
+		return nil, fmt.Errorf("%w: subject alternative names are assigned by the ca", ErrPolicy)
+	}
+	switch key := req.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() && key.Curve != elliptic.P384() {
+			return nil, fmt.Errorf("%w: ecdsa curve %s", ErrPolicy, key.Curve.Params().Name)
+		}
+	case *rsa.PublicKey:
+		if key.N.BitLen() < ca.conf.MinRSABits {
+			return nil, fmt.Errorf("%w: rsa key of %d bits", ErrPolicy, key.N.BitLen())
+		}
+	case ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("%w: key type %T", ErrPolicy, key)
+	}
+	return req, nil
+}
+
+func serialString(serial *big.Int) string {
+	return serial.Text(16)
+}
+
+// GenerateCA returns a self-signed ECDSA P-256 root for the device CA, valid for 10 years.
+func GenerateCA(commonName string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate ca key %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), _serialBits))
+	if err != nil {
+		return nil, nil, fmt.Errorf("ca serial %w", err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-_backdate),
+		NotAfter:              now.AddDate(_defaultCAYears, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sign ca certificate %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse ca certificate %w", err)
+	}
+	return cert, key, nil
+}
+
+// EncodeCertificate returns cert as PEM.
+func EncodeCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: _pemTypeCert, Bytes: cert.Raw})
+}
+
+// EncodeKey returns the PKCS #8 PEM of key, for persisting a generated CA key.
+func EncodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal ca key %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: _pemTypePKCS8Key, Bytes: der}), nil
+}
+
+// LoadCA parses the PEM certificate and PKCS #8 or SEC 1 PEM key of the CA.
+func LoadCA(certPEM, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != _pemTypeCert {
+		return nil, nil, errors.New("ca certificate pem not found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse ca certificate %w", err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, errors.New("ca key pem not found")
+	}
+	var key interface{}
+	switch block.Type {
+	case _pemTypeECKey:
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse ca key %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("ca key %T can not sign", key)
+	}
+	return cert, signer, nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deviceca
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newCSR(t *testing.T, key crypto.Signer, template *x509.CertificateRequest) []byte {
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestCA(t *testing.T) {
+	caCert, caKey, err := GenerateCA("tkeel device ca")
+	assert.NoError(t, err)
+	keyPEM, err := EncodeKey(caKey)
+	assert.NoError(t, err)
+	caCert, caKey, err = LoadCA(EncodeCertificate(caCert), keyPEM)
+	assert.NoError(t, err)
+
+	registry := RegistryFunc(func(ctx context.Context, id string) (*Device, error) {
+		switch id {
+		case "dev-1":
+			return &Device{ID: id, TenantID: "tnt-1"}, nil
+		case "dev-off":
+			return &Device{ID: id, TenantID: "tnt-1", Disabled: true}, nil
+		}
+		return nil, ErrDeviceNotFound
+	})
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormStore(db)
+	assert.NoError(t, err)
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			ca, err := New(Config{}, caCert, caKey, registry, store)
+			assert.NoError(t, err)
+			deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			assert.NoError(t, err)
+			csr := newCSR(t, deviceKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "dev-1"}})
+
+			cert, err := ca.Issue(context.Background(), "tnt-1", "dev-1", csr)
+			assert.NoError(t, err)
+			assert.Equal(t, "dev-1", cert.Subject.CommonName)
+			assert.Equal(t, "device://tnt-1/dev-1", cert.URIs[0].String())
+			device, err := ca.Verify(cert)
+			assert.NoError(t, err)
+			assert.Equal(t, &Device{ID: "dev-1", TenantID: "tnt-1"}, device)
+
+			weak, err := rsa.GenerateKey(rand.Reader, 1024)
+			assert.NoError(t, err)
+			offCSR := newCSR(t, deviceKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "dev-off"}})
+			policies := []struct {
+				name     string
+				tenantID string
+				deviceID string
+				csr      []byte
+				err      error
+			}{
+				{"garbage", "tnt-1", "dev-1", []byte("garbage"), ErrInvalidCSR},
+				{"other device", "tnt-1", "dev-2", csr, ErrDeviceNotFound},
+				{"other tenant", "tnt-2", "dev-1", csr, ErrDeviceNotFound},
+				{"disabled", "tnt-1", "dev-off", offCSR, ErrDeviceDisabled},
+				{"common name", "tnt-1", "dev-1", newCSR(t, deviceKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "dev-2"}}), ErrPolicy},
+				{"san", "tnt-1", "dev-1", newCSR(t, deviceKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "dev-1"}, DNSNames: []string{"evil.example"}}), ErrPolicy},
+				{"weak key", "tnt-1", "dev-1", newCSR(t, weak, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "dev-1"}}), ErrPolicy},
+			}
+			for _, tt := range policies {
+				_, err := ca.Issue(context.Background(), tt.tenantID, tt.deviceID, tt.csr)
+				assert.ErrorIs(t, err, tt.err, tt.name)
+			}
+
+			// renewal over mutual tls, then the old certificate is revoked.
+			r := httptest.NewRequest(http.MethodPost, "/renew", bytes.NewReader(csr))
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			w := httptest.NewRecorder()
+			ca.RenewHandler().ServeHTTP(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+			block, rest := pem.Decode(w.Body.Bytes())
+			renewed, err := x509.ParseCertificate(block.Bytes)
+			assert.NoError(t, err)
+			assert.NotEqual(t, cert.SerialNumber, renewed.SerialNumber)
+			block, _ = pem.Decode(rest)
+			assert.Equal(t, caCert.Raw, block.Bytes)
+
+			assert.NoError(t, ca.Revoke(serialString(cert.SerialNumber)))
+			_, err = ca.Verify(cert)
+			assert.ErrorIs(t, err, ErrCertificateRevoked)
+			_, err = ca.Verify(renewed)
+			assert.NoError(t, err)
+			crlDER, err := ca.CRL()
+			assert.NoError(t, err)
+			crl, err := x509.ParseCRL(crlDER)
+			assert.NoError(t, err)
+			assert.NoError(t, caCert.CheckCRLSignature(crl))
+			assert.Len(t, crl.TBSCertList.RevokedCertificates, 1)
+			assert.Equal(t, cert.SerialNumber, crl.TBSCertList.RevokedCertificates[0].SerialNumber)
+
+			assert.NoError(t, ca.RevokeDevice("dev-1"))
+			_, err = ca.Verify(renewed)
+			assert.ErrorIs(t, err, ErrCertificateRevoked)
+		})
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	caCert, caKey, err := GenerateCA("tkeel device ca")
+	assert.NoError(t, err)
+	var disabled bool
+	ca, err := New(Config{}, caCert, caKey, RegistryFunc(func(ctx context.Context, id string) (*Device, error) {
+		return &Device{ID: id, TenantID: "tnt-1", Disabled: disabled}, nil
+	}), NewMemoryStore())
+	assert.NoError(t, err)
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	csr := newCSR(t, deviceKey, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "dev-1"}})
+
+	enroll := func(subject, tenantID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/enroll", bytes.NewReader(csr))
+		if subject != "" {
+			r = r.WithContext(middleware.WithClaims(r.Context(), &token.Claims{Subject: subject, TenantID: tenantID}))
+		}
+		w := httptest.NewRecorder()
+		ca.EnrollHandler().ServeHTTP(w, r)
+		return w
+	}
+	assert.Equal(t, http.StatusUnauthorized, enroll("", "").Code)
+	assert.Equal(t, http.StatusBadRequest, enroll("dev-2", "tnt-1").Code)
+	assert.Equal(t, http.StatusForbidden, enroll("dev-1", "tnt-2").Code)
+	w := enroll("dev-1", "tnt-1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	block, _ := pem.Decode(w.Body.Bytes())
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+
+	h := ca.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(middleware.SubjectFromContext(r.Context()) + "@" + middleware.TenantFromContext(r.Context())))
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, "dev-1@tnt-1", w.Body.String())
+
+	disabled = true
+	assert.Equal(t, http.StatusForbidden, enroll("dev-1", "tnt-1").Code)
+	renew := httptest.NewRequest(http.MethodPost, "/renew", bytes.NewReader(csr))
+	renew.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	w = httptest.NewRecorder()
+	ca.RenewHandler().ServeHTTP(w, renew)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	otherCA, _, err := GenerateCA("other")
+	assert.NoError(t, err)
+	r.TLS.PeerCertificates = []*x509.Certificate{otherCA}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	ca.CRLHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/crl", nil))
+	assert.Equal(t, "application/pkix-crl", w.Header().Get("Content-Type"))
+}
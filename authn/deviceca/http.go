@@ -0,0 +1,135 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deviceca
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+)
+
+// _maxCSRSize bounds the request bodies, CSRs are a few KiB at most.
+const _maxCSRSize = 64 << 10
+
+// EnrollHandler signs the CSR in the request body for the device the request authenticated as,
+// mount it behind middleware establishing the claims of the device, e.g. with a bootstrap token.
+// The tenant of the claims must be the tenant the device is registered in.
+// The answer is the PEM certificate followed by the CA certificate.
+func (ca *CA) EnrollHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.ClaimsFromContext(r.Context())
+		if !ok || claims.Subject == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		ca.serveIssue(w, r, func(csr []byte) ([]byte, error) {
+			cert, err := ca.Issue(r.Context(), claims.TenantID, claims.Subject, csr)
+			if err != nil {
+				return nil, err
+			}
+			return EncodeCertificate(cert), nil
+		})
+	})
+}
+
+// RenewHandler signs the CSR in the request body for the device authenticating with its current
+// certificate over mutual TLS.
+func (ca *CA) RenewHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		ca.serveIssue(w, r, func(csr []byte) ([]byte, error) {
+			cert, err := ca.Renew(r.Context(), r.TLS.PeerCertificates[0], csr)
+			if err != nil {
+				return nil, err
+			}
+			return EncodeCertificate(cert), nil
+		})
+	})
+}
+
+// CRLHandler serves the DER revocation list.
+func (ca *CA) CRLHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		crl, err := ca.CRL()
+		if err != nil {
+			ca.logger.Errorf("device ca crl: %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		_, _ = w.Write(crl)
+	})
+}
+
+// Middleware authenticates devices by their client certificate, the claims carry the device id
+// as subject and are available through middleware.ClaimsFromContext.
+func (ca *CA) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		leaf := r.TLS.PeerCertificates[0]
+		device, err := ca.Verify(leaf)
+		if err != nil {
+			ca.logger.Debugf("device certificate %s: %s", leaf.Subject, err)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		claims := &token.Claims{
+			Subject:   device.ID,
+			TenantID:  device.TenantID,
+			ExpiresAt: leaf.NotAfter.Unix(),
+			Extra:     map[string]interface{}{"auth_method": "x509"},
+		}
+		next.ServeHTTP(w, r.WithContext(middleware.WithClaims(r.Context(), claims)))
+	})
+}
+
+func (ca *CA) serveIssue(w http.ResponseWriter, r *http.Request, issue func(csr []byte) ([]byte, error)) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	csr, err := io.ReadAll(io.LimitReader(r.Body, _maxCSRSize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	cert, err := issue(csr)
+	switch {
+	case errors.Is(err, ErrInvalidCSR), errors.Is(err, ErrPolicy):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrDeviceNotFound), errors.Is(err, ErrDeviceDisabled):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	case errors.Is(err, ErrUntrustedCertificate), errors.Is(err, ErrCertificateRevoked):
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	case err != nil:
+		ca.logger.Errorf("device ca issue: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	_, _ = w.Write(append(cert, EncodeCertificate(ca.cert)...))
+}
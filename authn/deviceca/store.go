@@ -0,0 +1,167 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deviceca
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+var (
+	_ Store = &MemoryStore{}
+	_ Store = &GormStore{}
+
+	// ErrCertificateNotFound the store knows no certificate with the serial.
+	ErrCertificateNotFound = errors.New("certificate not found")
+)
+
+// Issued the record of an issued certificate.
+type Issued struct {
+	// Serial hex serial number.
+	Serial    string
+	DeviceID  string
+	TenantID  string
+	NotAfter  time.Time
+	RevokedAt time.Time
+}
+
+// Store records issued certificates for revocation.
+type Store interface {
+	Save(c *Issued) error
+	// Get returns the certificate with serial, or ErrCertificateNotFound.
+	Get(serial string) (*Issued, error)
+	// Revoke revokes the certificate with serial, or all of deviceID when serial is empty.
+	Revoke(deviceID, serial string) error
+	// Revoked returns the revoked certificates not expired yet.
+	Revoked() ([]*Issued, error)
+}
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock  sync.RWMutex
+	certs map[string]Issued
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{certs: make(map[string]Issued)}
+}
+
+func (s *MemoryStore) Save(c *Issued) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for serial, issued := range s.certs {
+		if now.After(issued.NotAfter) {
+			delete(s.certs, serial)
+		}
+	}
+	s.certs[c.Serial] = *c
+	return nil
+}
+
+func (s *MemoryStore) Get(serial string) (*Issued, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	c, ok := s.certs[serial]
+	if !ok {
+		return nil, ErrCertificateNotFound
+	}
+	return &c, nil
+}
+
+func (s *MemoryStore) Revoke(deviceID, serial string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for k, c := range s.certs {
+		if c.RevokedAt.IsZero() && ((serial != "" && k == serial) || (serial == "" && c.DeviceID == deviceID)) {
+			c.RevokedAt = now
+			s.certs[k] = c
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Revoked() ([]*Issued, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	now := time.Now()
+	revoked := make([]*Issued, 0)
+	for _, c := range s.certs {
+		if !c.RevokedAt.IsZero() && now.Before(c.NotAfter) {
+			c := c
+			revoked = append(revoked, &c)
+		}
+	}
+	return revoked, nil
+}
+
+// GormStore keeps the records in the database.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore migrates the table and returns a GormStore.
+func NewGormStore(db *gorm.DB) (*GormStore, error) {
+	if err := db.AutoMigrate(&model.DeviceCertificate{}); err != nil {
+		return nil, fmt.Errorf("migrate device certificates %w", err)
+	}
+	return &GormStore{db: db}, nil
+}
+
+func (s *GormStore) Save(c *Issued) error {
+	return (&model.DeviceCertificate{Serial: c.Serial, DeviceID: c.DeviceID, TenantID: c.TenantID, NotAfter: c.NotAfter}).Create(s.db)
+}
+
+func (s *GormStore) Get(serial string) (*Issued, error) {
+	row := &model.DeviceCertificate{Serial: serial}
+	found, err := row.Get(s.db)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrCertificateNotFound
+	}
+	return fromModel(row), nil
+}
+
+func (s *GormStore) Revoke(deviceID, serial string) error {
+	return model.RevokeDeviceCertificates(s.db, deviceID, serial)
+}
+
+func (s *GormStore) Revoked() ([]*Issued, error) {
+	rows, err := model.ListRevokedDeviceCertificates(s.db)
+	if err != nil {
+		return nil, err
+	}
+	revoked := make([]*Issued, 0, len(rows))
+	for _, row := range rows {
+		revoked = append(revoked, fromModel(row))
+	}
+	return revoked, nil
+}
+
+func fromModel(row *model.DeviceCertificate) *Issued {
+	c := &Issued{Serial: row.Serial, DeviceID: row.DeviceID, TenantID: row.TenantID, NotAfter: row.NotAfter}
+	if row.RevokedAt != nil {
+		c.RevokedAt = *row.RevokedAt
+	}
+	return c
+}
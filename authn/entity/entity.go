@@ -0,0 +1,360 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package entity issues the tokens of devices and other entities: long-lived, bound to one
+// entity of one tenant and narrowly scoped. A token reads <id>.<secret>, the id locates it
+// and only the hash of the secret is stored.
+package entity
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	_idPrefix      = "et"
+	_idLength      = 8
+	_secretLength  = 32
+	_defaultTTL    = 365 * 24 * time.Hour
+	_touchInterval = time.Minute
+
+	// TokenType the token_type extra claim of verified entity tokens.
+	TokenType = "entity"
+)
+
+var (
+	// ErrTokenNotFound the store does not know the token.
+	ErrTokenNotFound = errors.New("entity token not found")
+	// ErrEntityRequired the token is not bound to an entity and a tenant.
+	ErrEntityRequired = errors.New("entity id and tenant id required")
+	// ErrScopeRequired the token has no scope.
+	ErrScopeRequired = errors.New("entity token scope required")
+	// ErrScopeNotAllowed a scope is not among the scopes entity tokens may carry.
+	ErrScopeNotAllowed = errors.New("entity token scope not allowed")
+	// ErrTokenDisabled the token is disabled, it can be enabled again.
+	ErrTokenDisabled = errors.New("entity token disabled")
+)
+
+// Token the stored part of an entity token.
+type Token struct {
+	ID         string `json:"id"`
+	EntityID   string `json:"entity_id"`
+	EntityType string `json:"entity_type"`
+	TenantID   string `json:"tenant_id"`
+	// Owner the user the entity belongs to, if any.
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+	// Hash hex SHA-256 of the secret, the secret has enough entropy for a fast hash.
+	Hash     string `json:"-"`
+	Disabled bool   `json:"disabled"`
+	// ExpiresAt zero for tokens without expiry.
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Store persists entity tokens.
+type Store interface {
+	// Create creates all tokens or none.
+	Create(tokens ...*Token) error
+	// Get returns the token with id or ErrTokenNotFound.
+	Get(id string) (*Token, error)
+	// List returns the tokens of tenantID, of entityID unless it is empty.
+	List(tenantID, entityID string) ([]*Token, error)
+	Update(t *Token) error
+	// Touch records the use of the token with id at, only while it exists and is enabled, so a
+	// concurrent Disable or Delete is never undone.
+	Touch(id string, at time.Time) error
+	Delete(id string) error
+}
+
+// Config of the entity tokens.
+type Config struct {
+	// TTL lifetime of tokens issued without one, negative for no expiry. Default to 1 year.
+	TTL time.Duration `mapstructure:"ttl" json:"ttl" yaml:"ttl"`
+	// AllowedScopes the scopes entity tokens may carry, empty allows any.
+	AllowedScopes []string `mapstructure:"allowed_scopes" json:"allowed_scopes" yaml:"allowedScopes"`
+}
+
+// IssueOptions the attributes of a new token.
+type IssueOptions struct {
+	EntityID   string   `json:"entity_id"`
+	EntityType string   `json:"entity_type"`
+	TenantID   string   `json:"tenant_id"`
+	Owner      string   `json:"owner"`
+	Scopes     []string `json:"scopes"`
+	// TTL zero for the configured TTL.
+	TTL time.Duration `json:"ttl"`
+}
+
+// Issued a token and its raw value, shown once.
+type Issued struct {
+	Raw   string `json:"token"`
+	Token *Token `json:"info"`
+}
+
+// Manager issues, verifies and manages entity tokens.
+type Manager struct {
+	conf   Config
+	store  Store
+	logger *log.Helper
+}
+
+var _ token.Verifier = &Manager{}
+
+func NewManager(conf Config, store Store) *Manager {
+	if conf.TTL == 0 {
+		conf.TTL = _defaultTTL
+	}
+	return &Manager{conf: conf, store: store}
+}
+
+// SetLogger writes the entries of the entity token verification to l instead of the global logger.
+func (m *Manager) SetLogger(l log.Logger) {
+	m.logger = log.NewHelper(l)
+}
+
+// Issue issues a token, the returned raw token can not be recovered.
+func (m *Manager) Issue(opts IssueOptions) (string, *Token, error) {
+	issued, err := m.IssueBatch([]IssueOptions{opts})
+	if err != nil {
+		return "", nil, err
+	}
+	return issued[0].Raw, issued[0].Token, nil
+}
+
+// IssueBatch issues a token per options, e.g. for a fleet of devices. Either all are issued or,
+// when one options is invalid or the store fails, none.
+func (m *Manager) IssueBatch(opts []IssueOptions) ([]*Issued, error) {
+	issued := make([]*Issued, 0, len(opts))
+	tokens := make([]*Token, 0, len(opts))
+	now := time.Now()
+	for i := range opts {
+		if err := m.validate(&opts[i]); err != nil {
+			return nil, fmt.Errorf("entity %s: %w", opts[i].EntityID, err)
+		}
+		id, err := utils.RandStringWithPrefix(_idPrefix, _idLength)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := utils.RandBase64String(_secretLength)
+		if err != nil {
+			return nil, err
+		}
+		t := &Token{
+			ID:         id,
+			EntityID:   opts[i].EntityID,
+			EntityType: opts[i].EntityType,
+			TenantID:   opts[i].TenantID,
+			Owner:      opts[i].Owner,
+			Scopes:     opts[i].Scopes,
+			Hash:       hashSecret(secret),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		ttl := opts[i].TTL
+		if ttl == 0 {
+			ttl = m.conf.TTL
+		}
+		if ttl > 0 {
+			t.ExpiresAt = now.Add(ttl)
+		}
+		tokens = append(tokens, t)
+		issued = append(issued, &Issued{Raw: id + "." + secret, Token: t})
+	}
+	if len(tokens) == 0 {
+		return issued, nil
+	}
+	if err := m.store.Create(tokens...); err != nil {
+		return nil, fmt.Errorf("create entity tokens %w", err)
+	}
+	return issued, nil
+}
+
+func (m *Manager) Get(id string) (*Token, error) {
+	return m.store.Get(id)
+}
+
+// List returns the tokens of tenantID, of entityID unless it is empty.
+func (m *Manager) List(tenantID, entityID string) ([]*Token, error) {
+	return m.store.List(tenantID, entityID)
+}
+
+// Enable lets a disabled token verify again.
+func (m *Manager) Enable(id string) error {
+	return m.update(id, func(t *Token) { t.Disabled = false })
+}
+
+// Disable suspends the token without deleting it, e.g. while a device is in maintenance.
+func (m *Manager) Disable(id string) error {
+	return m.update(id, func(t *Token) { t.Disabled = true })
+}
+
+// Extend sets the expiry of the token ttl from now, a negative ttl removes the expiry.
+func (m *Manager) Extend(id string, ttl time.Duration) error {
+	return m.update(id, func(t *Token) {
+		if ttl < 0 {
+			t.ExpiresAt = time.Time{}
+			return
+		}
+		t.ExpiresAt = time.Now().Add(ttl)
+	})
+}
+
+// Delete revokes the token for good.
+func (m *Manager) Delete(id string) error {
+	if _, err := m.store.Get(id); err != nil {
+		return err
+	}
+	return m.store.Delete(id)
+}
+
+// RevokeTenant deletes all tokens of the tenant, e.g. when it is offboarded.
+func (m *Manager) RevokeTenant(tenantID string) error {
+	tokens, err := m.store.List(tenantID, "")
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if err = m.store.Delete(t.ID); err != nil {
+			return fmt.Errorf("delete entity token %s %w", t.ID, err)
+		}
+	}
+	return nil
+}
+
+// IsToken reports whether raw has the shape of an entity token, without checking it.
+func (m *Manager) IsToken(raw string) bool {
+	_, _, ok := parse(raw)
+	return ok
+}
+
+// Verify checks raw and returns claims with the entity as subject, satisfies token.Verifier.
+func (m *Manager) Verify(raw string) (*token.Claims, error) {
+	id, secret, ok := parse(raw)
+	if !ok {
+		return nil, token.ErrInvalidToken
+	}
+	t, err := m.store.Get(id)
+	if errors.Is(err, ErrTokenNotFound) {
+		return nil, token.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load entity token %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hashSecret(secret))) != 1 {
+		return nil, token.ErrInvalidToken
+	}
+	if t.Disabled {
+		return nil, ErrTokenDisabled
+	}
+	now := time.Now()
+	if !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt) {
+		return nil, token.ErrTokenExpired
+	}
+	if now.Sub(t.LastUsedAt) >= _touchInterval {
+		if err = m.store.Touch(t.ID, now); err != nil {
+			m.logger.Warnf("record use of entity token %s: %s", t.ID, err)
+		}
+	}
+	claims := &token.Claims{
+		ID:       t.ID,
+		Subject:  t.EntityID,
+		TenantID: t.TenantID,
+		Scope:    strings.Join(t.Scopes, " "),
+		IssuedAt: t.CreatedAt.Unix(),
+		Extra: map[string]interface{}{
+			"token_type":  TokenType,
+			"entity_type": t.EntityType,
+			"owner":       t.Owner,
+		},
+	}
+	if !t.ExpiresAt.IsZero() {
+		claims.ExpiresAt = t.ExpiresAt.Unix()
+	}
+	return claims, nil
+}
+
+func (m *Manager) validate(opts *IssueOptions) error {
+	if opts.EntityID == "" || opts.TenantID == "" {
+		return ErrEntityRequired
+	}
+	if len(opts.Scopes) == 0 {
+		return ErrScopeRequired
+	}
+	if len(m.conf.AllowedScopes) == 0 {
+		return nil
+	}
+	for _, s := range opts.Scopes {
+		if !utils.StringsInclude(m.conf.AllowedScopes, s) {
+			return fmt.Errorf("%w: %s", ErrScopeNotAllowed, s)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) update(id string, fn func(t *Token)) error {
+	t, err := m.store.Get(id)
+	if err != nil {
+		return err
+	}
+	fn(t)
+	t.UpdatedAt = time.Now()
+	return m.store.Update(t)
+}
+
+type verifier struct {
+	entities *Manager
+	fallback token.Verifier
+}
+
+// Verifier returns a token.Verifier checking entity tokens with entities and all other tokens
+// with fallback, so middleware accepts both.
+func Verifier(entities *Manager, fallback token.Verifier) token.Verifier {
+	return &verifier{entities: entities, fallback: fallback}
+}
+
+func (v *verifier) Verify(raw string) (*token.Claims, error) {
+	if v.entities.IsToken(raw) {
+		return v.entities.Verify(raw)
+	}
+	return v.fallback.Verify(raw)
+}
+
+// parse splits raw into the id and the secret.
+func parse(raw string) (id, secret string, ok bool) {
+	i := strings.IndexByte(raw, '.')
+	if i < 0 || !strings.HasPrefix(raw, _idPrefix+"-") {
+		return "", "", false
+	}
+	id, secret = raw[:i], raw[i+1:]
+	if len(id) != len(_idPrefix)+1+2*_idLength || secret == "" || strings.Contains(secret, ".") {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
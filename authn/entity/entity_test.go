@@ -0,0 +1,140 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestManager(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormStore(db)
+	assert.NoError(t, err)
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			m := NewManager(Config{AllowedScopes: []string{"telemetry:write", "attributes:read"}}, store)
+
+			invalid := []struct {
+				opts IssueOptions
+				err  error
+			}{
+				{IssueOptions{TenantID: "t1", Scopes: []string{"telemetry:write"}}, ErrEntityRequired},
+				{IssueOptions{EntityID: "dev-1", TenantID: "t1"}, ErrScopeRequired},
+				{IssueOptions{EntityID: "dev-1", TenantID: "t1", Scopes: []string{"admin"}}, ErrScopeNotAllowed},
+			}
+			for _, tt := range invalid {
+				_, _, err := m.Issue(tt.opts)
+				assert.ErrorIs(t, err, tt.err)
+			}
+
+			raw, tok, err := m.Issue(IssueOptions{EntityID: "dev-1", EntityType: "device", TenantID: "t1", Scopes: []string{"telemetry:write"}})
+			assert.NoError(t, err)
+			assert.True(t, m.IsToken(raw))
+			assert.WithinDuration(t, time.Now().Add(_defaultTTL), tok.ExpiresAt, time.Minute)
+			claims, err := m.Verify(raw)
+			assert.NoError(t, err)
+			assert.Equal(t, "dev-1", claims.Subject)
+			assert.Equal(t, "t1", claims.TenantID)
+			assert.Equal(t, "telemetry:write", claims.Scope)
+			assert.Equal(t, "device", claims.Extra["entity_type"])
+			_, err = m.Verify(tok.ID + ".wrong")
+			assert.ErrorIs(t, err, token.ErrInvalidToken)
+
+			assert.NoError(t, m.Disable(tok.ID))
+			_, err = m.Verify(raw)
+			assert.ErrorIs(t, err, ErrTokenDisabled)
+			// recording a use never enables a token disabled in between.
+			assert.NoError(t, store.Touch(tok.ID, time.Now()))
+			_, err = m.Verify(raw)
+			assert.ErrorIs(t, err, ErrTokenDisabled)
+			assert.NoError(t, m.Enable(tok.ID))
+			_, err = m.Verify(raw)
+			assert.NoError(t, err)
+
+			assert.NoError(t, m.Extend(tok.ID, -1))
+			got, err := m.Get(tok.ID)
+			assert.NoError(t, err)
+			assert.True(t, got.ExpiresAt.IsZero())
+			assert.NoError(t, m.Extend(tok.ID, time.Nanosecond))
+			time.Sleep(time.Millisecond)
+			_, err = m.Verify(raw)
+			assert.ErrorIs(t, err, token.ErrTokenExpired)
+			assert.NoError(t, m.Extend(tok.ID, time.Hour))
+			_, err = m.Verify(raw)
+			assert.NoError(t, err)
+
+			_, err = m.IssueBatch([]IssueOptions{
+				{EntityID: "dev-2", TenantID: "t1", Scopes: []string{"telemetry:write"}},
+				{EntityID: "dev-3", TenantID: "t1"},
+			})
+			assert.ErrorIs(t, err, ErrScopeRequired)
+			issued, err := m.IssueBatch([]IssueOptions{
+				{EntityID: "dev-2", TenantID: "t1", Scopes: []string{"telemetry:write"}},
+				{EntityID: "dev-3", TenantID: "t1", Scopes: []string{"attributes:read"}, TTL: -1},
+			})
+			assert.NoError(t, err)
+			assert.Len(t, issued, 2)
+			assert.True(t, issued[1].Token.ExpiresAt.IsZero())
+			tokens, err := m.List("t1", "")
+			assert.NoError(t, err)
+			assert.Len(t, tokens, 3)
+			tokens, err = m.List("t1", "dev-3")
+			assert.NoError(t, err)
+			assert.Len(t, tokens, 1)
+
+			assert.NoError(t, m.Delete(tok.ID))
+			_, err = m.Verify(raw)
+			assert.ErrorIs(t, err, token.ErrInvalidToken)
+			assert.ErrorIs(t, m.Delete(tok.ID), ErrTokenNotFound)
+			// nor brings back a token deleted in between.
+			assert.NoError(t, store.Touch(tok.ID, time.Now()))
+			_, err = m.Get(tok.ID)
+			assert.ErrorIs(t, err, ErrTokenNotFound)
+		})
+	}
+}
+
+func TestVerifierMiddleware(t *testing.T) {
+	entities := NewManager(Config{}, NewMemoryStore())
+	raw, _, err := entities.Issue(IssueOptions{EntityID: "dev-1", TenantID: "t1", Scopes: []string{"telemetry:write"}})
+	assert.NoError(t, err)
+	users, err := token.NewJWTManager(&token.Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	jwt, err := users.Issue(&token.Claims{Subject: "usr-1", TenantID: "t1"})
+	assert.NoError(t, err)
+
+	a := middleware.NewAuthenticator(Verifier(entities, users), middleware.Config{})
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(middleware.SubjectFromContext(r.Context())))
+	}))
+	for raw, subject := range map[string]string{raw: "dev-1", jwt: "usr-1"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+raw)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, subject, w.Body.String())
+	}
+}
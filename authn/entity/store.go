@@ -0,0 +1,210 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+var (
+	_ Store = &MemoryStore{}
+	_ Store = &GormStore{}
+)
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock   sync.RWMutex
+	tokens map[string]Token
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]Token)}
+}
+
+func (s *MemoryStore) Create(tokens ...*Token) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, t := range tokens {
+		if _, ok := s.tokens[t.ID]; ok {
+			return fmt.Errorf("duplicate entity token id %s", t.ID)
+		}
+	}
+	for _, t := range tokens {
+		s.tokens[t.ID] = *t
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (*Token, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return &t, nil
+}
+
+func (s *MemoryStore) List(tenantID, entityID string) ([]*Token, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	tokens := make([]*Token, 0)
+	for _, t := range s.tokens {
+		if t.TenantID == tenantID && (entityID == "" || t.EntityID == entityID) {
+			t := t
+			tokens = append(tokens, &t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (s *MemoryStore) Update(t *Token) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.tokens[t.ID]; !ok {
+		return ErrTokenNotFound
+	}
+	s.tokens[t.ID] = *t
+	return nil
+}
+
+func (s *MemoryStore) Touch(id string, at time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if t, ok := s.tokens[id]; ok && !t.Disabled {
+		t.LastUsedAt = at
+		s.tokens[id] = t
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.tokens, id)
+	return nil
+}
+
+// GormStore keeps the tokens in the sys_t_entity_token table.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore migrates the table and returns a GormStore.
+func NewGormStore(db *gorm.DB) (*GormStore, error) {
+	if err := db.AutoMigrate(&model.EntityToken{}); err != nil {
+		return nil, fmt.Errorf("migrate entity tokens %w", err)
+	}
+	return &GormStore{db: db}, nil
+}
+
+func (s *GormStore) Create(tokens ...*Token) error {
+	rows := make([]*model.EntityToken, 0, len(tokens))
+	for _, t := range tokens {
+		rows = append(rows, toModel(t))
+	}
+	return model.CreateEntityTokens(s.db, rows)
+}
+
+func (s *GormStore) Get(id string) (*Token, error) {
+	row := &model.EntityToken{ID: id}
+	found, err := row.Get(s.db)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrTokenNotFound
+	}
+	return fromModel(row), nil
+}
+
+func (s *GormStore) List(tenantID, entityID string) ([]*Token, error) {
+	rows, err := model.ListEntityTokens(s.db, tenantID, entityID)
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*Token, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, fromModel(row))
+	}
+	return tokens, nil
+}
+
+func (s *GormStore) Update(t *Token) error {
+	return toModel(t).Save(s.db)
+}
+
+func (s *GormStore) Touch(id string, at time.Time) error {
+	return model.TouchEntityToken(s.db, id, at)
+}
+
+func (s *GormStore) Delete(id string) error {
+	return (&model.EntityToken{ID: id}).Delete(s.db)
+}
+
+func toModel(t *Token) *model.EntityToken {
+	return &model.EntityToken{
+		ID:         t.ID,
+		EntityID:   t.EntityID,
+		EntityType: t.EntityType,
+		TenantID:   t.TenantID,
+		Owner:      t.Owner,
+		Scopes:     strings.Join(t.Scopes, " "),
+		Hash:       t.Hash,
+		Disabled:   t.Disabled,
+		ExpiresAt:  timePtr(t.ExpiresAt),
+		LastUsedAt: timePtr(t.LastUsedAt),
+		CreatedAt:  t.CreatedAt,
+		UpdatedAt:  t.UpdatedAt,
+	}
+}
+
+func fromModel(row *model.EntityToken) *Token {
+	t := &Token{
+		ID:         row.ID,
+		EntityID:   row.EntityID,
+		EntityType: row.EntityType,
+		TenantID:   row.TenantID,
+		Owner:      row.Owner,
+		Scopes:     strings.Fields(row.Scopes),
+		Hash:       row.Hash,
+		Disabled:   row.Disabled,
+		CreatedAt:  row.CreatedAt,
+		UpdatedAt:  row.UpdatedAt,
+	}
+	if row.ExpiresAt != nil {
+		t.ExpiresAt = *row.ExpiresAt
+	}
+	if row.LastUsedAt != nil {
+		t.LastUsedAt = *row.LastUsedAt
+	}
+	return t
+}
+
+// timePtr maps the zero time to NULL.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
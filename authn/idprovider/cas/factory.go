@@ -19,6 +19,8 @@ import (
 	"net/url"
 
 	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/utils"
+	"github.com/tkeel-io/security/validation"
 
 	"github.com/mitchellh/mapstructure"
 	gocas "gopkg.in/cas.v2"
@@ -41,23 +43,45 @@ func (f casProviderFactory) Create(options map[string]interface{}) (idprovider.P
 	if err := mapstructure.Decode(options, &cas); err != nil {
 		return nil, err
 	}
-	casURL, err := url.Parse(cas.CASServerURL)
-	if err != nil {
+	if err := cas.init(); err != nil {
 		return nil, err
 	}
-	redirectURL, err := url.Parse(cas.RedirectURL)
+	return &cas, nil
+}
+
+// Validate reports all the problems of the configuration.
+func (c *casProvider) Validate() error {
+	r := validation.New()
+	r.Required("casServerURL", c.CASServerURL, "set the base URL of the CAS server, e.g. https://cas.example.com/cas")
+	r.URL("casServerURL", c.CASServerURL)
+	r.Required("redirectURL", c.RedirectURL, "set the service URL the CAS server redirects to with the ticket")
+	r.URL("redirectURL", c.RedirectURL)
+	return r.Err()
+}
+
+// init validates the configuration and creates the CAS REST client.
+func (c *casProvider) init() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	casURL, err := url.Parse(c.CASServerURL)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	cas.client = gocas.NewRestClient(&gocas.RestOptions{
+	redirectURL, err := url.Parse(c.RedirectURL)
+	if err != nil {
+		return err
+	}
+	c.client = gocas.NewRestClient(&gocas.RestOptions{
 		CasURL:     casURL,
 		ServiceURL: redirectURL,
 		Client: &http.Client{
 			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: cas.InsecureSkipVerify},
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify},
 			},
+			Timeout: utils.DefaultHTTPTimeout,
 		},
 		URLScheme: nil,
 	})
-	return &cas, nil
+	return nil
 }
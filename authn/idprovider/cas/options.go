@@ -0,0 +1,41 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cas
+
+import (
+	"github.com/tkeel-io/security/authn/idprovider"
+)
+
+// Option configures a provider created by NewCASProvider.
+type Option func(c *casProvider)
+
+// NewCASProvider returns a provider validating the service tickets of redirectURL at the CAS
+// server at serverURL.
+func NewCASProvider(serverURL, redirectURL string, opts ...Option) (idprovider.Provider, error) {
+	c := &casProvider{CASServerURL: serverURL, RedirectURL: redirectURL}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// WithInsecureSkipVerify turns off the TLS certificate checks of the CAS server.
+func WithInsecureSkipVerify() Option {
+	return func(c *casProvider) {
+		c.InsecureSkipVerify = true
+	}
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cas
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCASProvider(t *testing.T) {
+	p, err := NewCASProvider("https://cas.example.com/cas", "https://app.example.com/callback")
+	assert.NoError(t, err)
+	c := p.(*casProvider)
+	assert.Equal(t, "https://cas.example.com/cas", c.CASServerURL)
+	assert.Equal(t, "https://app.example.com/callback", c.RedirectURL)
+	assert.False(t, c.InsecureSkipVerify)
+	assert.NotNil(t, c.client)
+	assert.Equal(t, _casIdentityProvider, p.Type())
+
+	p, err = NewCASProvider("https://cas.example.com/cas", "https://app.example.com/callback", WithInsecureSkipVerify())
+	assert.NoError(t, err)
+	assert.True(t, p.(*casProvider).InsecureSkipVerify)
+
+	_, err = NewCASProvider("://cas", "https://app.example.com/callback")
+	assert.Error(t, err)
+	_, err = NewCASProvider("https://cas.example.com/cas", "://callback")
+	assert.Error(t, err)
+}
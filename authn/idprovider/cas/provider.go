@@ -15,9 +15,9 @@ package cas
 
 import (
 	"errors"
-	"fmt"
 
 	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/errs"
 
 	gocas "gopkg.in/cas.v2"
 )
@@ -46,7 +46,7 @@ func (c casProvider) Type() string {
 func (c casProvider) AuthenticateCode(ticket string) (idprovider.Identity, error) {
 	resp, err := c.client.ValidateServiceTicket(gocas.ServiceTicket(ticket))
 	if err != nil {
-		return nil, fmt.Errorf("cas validate service ticket failed: %w", err)
+		return nil, errs.New(errs.ErrCodeExchangeFailed, "cas validate service ticket", err)
 	}
 	return &casIdentity{User: resp.User}, nil
 }
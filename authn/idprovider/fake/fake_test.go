@@ -0,0 +1,80 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/errs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvider(t *testing.T) {
+	alice := NewIdentity("u-1").WithTenant("t1").WithUsername("alice").WithGroups("admins")
+	p := NewProvider().AddUser("alice", "secret", alice).AddCode("code-1", alice)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"valid", "alice", "secret", nil},
+		{"wrong password", "alice", "guess", ErrInvalidCredentials},
+		{"unknown user", "bob", "secret", errs.ErrIdentityNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := idprovider.Authenticate(context.Background(), p, tt.username, tt.password)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "t1", identity.GetTenantID())
+			assert.Equal(t, []string{"admins"}, idprovider.Groups(identity))
+		})
+	}
+
+	identity, err := p.AuthenticateCode("code-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "u-1", identity.GetUserID())
+	_, err = p.AuthenticateCode("code-1")
+	assert.ErrorIs(t, err, errs.ErrCodeExchangeFailed, "codes are single use")
+
+	unavailable := errs.New(errs.ErrProviderUnavailable, "fake", nil)
+	p.FailNext(unavailable)
+	_, err = p.Authenticate("alice", "secret")
+	assert.ErrorIs(t, err, errs.ErrProviderUnavailable)
+	_, err = p.Authenticate("alice", "secret")
+	assert.NoError(t, err)
+	p.FailWith(unavailable)
+	assert.Error(t, p.Test(context.Background()))
+	p.FailWith(nil)
+
+	p.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = p.AuthenticateContext(ctx, "alice", "secret")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	assert.Len(t, p.Calls(), 9)
+	assert.Equal(t, Call{Method: MethodAuthenticateCode, Arg: "code-1"}, p.Calls()[3])
+	assert.Contains(t, p.AuthCodeURL("s", "n"), "state=s")
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import "github.com/tkeel-io/security/authn/idprovider"
+
+var _ idprovider.Identity = &Identity{}
+
+// Identity an idprovider.Identity fixture, NewIdentity builds one.
+type Identity struct {
+	UserID     string
+	TenantID   string
+	Username   string
+	Email      string
+	ExternalID string
+	Extra      map[string]interface{}
+}
+
+// NewIdentity returns an identity of userID, which is also its username and external id.
+func NewIdentity(userID string) *Identity {
+	return &Identity{UserID: userID, Username: userID, ExternalID: userID}
+}
+
+// WithTenant sets the tenant of the identity.
+func (i *Identity) WithTenant(tenantID string) *Identity {
+	i.TenantID = tenantID
+	return i
+}
+
+// WithUsername sets the username of the identity.
+func (i *Identity) WithUsername(username string) *Identity {
+	i.Username = username
+	return i
+}
+
+// WithEmail sets the email of the identity.
+func (i *Identity) WithEmail(email string) *Identity {
+	i.Email = email
+	return i
+}
+
+// WithExternalID sets the id of the identity at the provider.
+func (i *Identity) WithExternalID(externalID string) *Identity {
+	i.ExternalID = externalID
+	return i
+}
+
+// WithGroups sets the groups reported for the identity, see idprovider.Groups.
+func (i *Identity) WithGroups(groups ...string) *Identity {
+	return i.WithExtra(idprovider.ExtraGroups, groups)
+}
+
+// WithExtra sets the extension key of the identity.
+func (i *Identity) WithExtra(key string, value interface{}) *Identity {
+	if i.Extra == nil {
+		i.Extra = make(map[string]interface{})
+	}
+	i.Extra[key] = value
+	return i
+}
+
+func (i *Identity) GetUserID() string {
+	return i.UserID
+}
+
+func (i *Identity) GetTenantID() string {
+	return i.TenantID
+}
+
+func (i *Identity) GetUsername() string {
+	return i.Username
+}
+
+func (i *Identity) GetEmail() string {
+	return i.Email
+}
+
+func (i *Identity) GetExternalID() string {
+	return i.ExternalID
+}
+
+func (i *Identity) GetExtra() map[string]interface{} {
+	return i.Extra
+}
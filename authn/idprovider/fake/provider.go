@@ -0,0 +1,213 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake scriptable identity providers and identity fixtures, so services unit-test their
+// login flows without an upstream.
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/errs"
+)
+
+// ProviderType the type of the providers of the package.
+const ProviderType = "FakeIdentityProvider"
+
+var (
+	_ idprovider.Provider        = &Provider{}
+	_ idprovider.ContextProvider = &Provider{}
+	_ idprovider.Tester          = &Provider{}
+
+	// ErrInvalidCredentials the password does not match the user.
+	ErrInvalidCredentials = errors.New("fake: invalid credentials")
+)
+
+const (
+	// MethodAuthenticate a username password login.
+	MethodAuthenticate = "Authenticate"
+	// MethodAuthenticateCode a code login.
+	MethodAuthenticateCode = "AuthenticateCode"
+	// MethodTest a check of the configuration.
+	MethodTest = "Test"
+)
+
+// Call a call made to a Provider.
+type Call struct {
+	Method string
+	// Arg the username or the code.
+	Arg string
+}
+
+type user struct {
+	password string
+	identity idprovider.Identity
+}
+
+// Provider an idprovider.Provider answering from scripted users and codes. It fails the calls
+// with the scripted errors and delays them by the scripted latency, and records them. Codes are
+// single use as at a real OP.
+type Provider struct {
+	lock     sync.Mutex
+	users    map[string]user
+	codes    map[string]idprovider.Identity
+	err      error
+	next     []error
+	latency  time.Duration
+	loginURL string
+	calls    []Call
+}
+
+// NewProvider returns a Provider without users nor codes.
+func NewProvider() *Provider {
+	return &Provider{
+		users:    make(map[string]user),
+		codes:    make(map[string]idprovider.Identity),
+		loginURL: "https://idp.example/authorize",
+	}
+}
+
+// AddUser lets username log in with password as identity.
+func (p *Provider) AddUser(username, password string, identity idprovider.Identity) *Provider {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.users[username] = user{password: password, identity: identity}
+	return p
+}
+
+// AddCode lets code log in once as identity.
+func (p *Provider) AddCode(code string, identity idprovider.Identity) *Provider {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.codes[code] = identity
+	return p
+}
+
+// FailWith fails every call with err until FailWith(nil).
+func (p *Provider) FailWith(err error) *Provider {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.err = err
+	return p
+}
+
+// FailNext fails the next calls with failures, one call each.
+func (p *Provider) FailNext(failures ...error) *Provider {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.next = append(p.next, failures...)
+	return p
+}
+
+// SetLatency delays every call by latency, a call whose context is done meanwhile fails.
+func (p *Provider) SetLatency(latency time.Duration) *Provider {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.latency = latency
+	return p
+}
+
+// SetLoginURL sets the URL AuthCodeURL adds the state and nonce to.
+func (p *Provider) SetLoginURL(loginURL string) *Provider {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.loginURL = loginURL
+	return p
+}
+
+// Calls returns the calls made so far.
+func (p *Provider) Calls() []Call {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]Call(nil), p.calls...)
+}
+
+func (p *Provider) Type() string {
+	return ProviderType
+}
+
+func (p *Provider) AuthCodeURL(state, nonce string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.loginURL + "?" + url.Values{"state": {state}, "nonce": {nonce}}.Encode()
+}
+
+func (p *Provider) AuthenticateCode(code string) (idprovider.Identity, error) {
+	return p.AuthenticateCodeContext(context.Background(), code)
+}
+
+func (p *Provider) AuthenticateCodeContext(ctx context.Context, code string) (idprovider.Identity, error) {
+	if err := p.call(ctx, MethodAuthenticateCode, code); err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	identity, ok := p.codes[code]
+	if !ok {
+		return nil, errs.New(errs.ErrCodeExchangeFailed, "fake", fmt.Errorf("unknown code %q", code))
+	}
+	delete(p.codes, code)
+	return identity, nil
+}
+
+func (p *Provider) Authenticate(username, password string) (idprovider.Identity, error) {
+	return p.AuthenticateContext(context.Background(), username, password)
+}
+
+func (p *Provider) AuthenticateContext(ctx context.Context, username, password string) (idprovider.Identity, error) {
+	if err := p.call(ctx, MethodAuthenticate, username); err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	u, ok := p.users[username]
+	if !ok {
+		return nil, errs.New(errs.ErrIdentityNotFound, "fake", fmt.Errorf("unknown user %q", username))
+	}
+	if u.password != password {
+		return nil, ErrInvalidCredentials
+	}
+	return u.identity, nil
+}
+
+// Test fails with the scripted errors only.
+func (p *Provider) Test(ctx context.Context) error {
+	return p.call(ctx, MethodTest, "")
+}
+
+// call records the call, waits for the latency and returns the scripted error of the call.
+func (p *Provider) call(ctx context.Context, method, arg string) error {
+	p.lock.Lock()
+	p.calls = append(p.calls, Call{Method: method, Arg: arg})
+	latency, err := p.latency, p.err
+	if err == nil && len(p.next) > 0 {
+		err, p.next = p.next[0], p.next[1:]
+	}
+	p.lock.Unlock()
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return err
+}
@@ -28,3 +28,27 @@ type Identity interface {
 	// Other extensions.
 	GetExtra() map[string]interface{}
 }
+
+// ExtraGroups key of the groups of the End-User in the extensions of an Identity.
+const ExtraGroups = "groups"
+
+// Groups returns the groups the provider reported for identity.
+func Groups(identity Identity) []string {
+	switch groups := identity.GetExtra()[ExtraGroups].(type) {
+	case []string:
+		return groups
+	case []interface{}:
+		out := make([]string, 0, len(groups))
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case string:
+		if groups != "" {
+			return []string{groups}
+		}
+	}
+	return nil
+}
@@ -13,26 +13,60 @@ limitations under the License.
 
 package idprovider
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/tkeel-io/security/errs"
+)
 
 // tenantID:provider.
 var (
+	_lock sync.RWMutex
 	// _providers all providers with tenantID:provider.
 	_providers = make(map[string]Provider)
 	// _providerFactories all provider factory with type:factory.
 	_providerFactories = make(map[string]ProviderFactory)
 	// ErrIdentityProviderNotFound error in not found identity provider.
 	ErrIdentityProviderNotFound = errors.New("identity provider not found")
+	// ErrProviderFactoryNotFound error in not found provider factory of a type.
+	ErrProviderFactoryNotFound = errors.New("identity provider factory not found")
+	// ErrInvalidConfig error in a missing or malformed option of a provider.
+	ErrInvalidConfig = errs.ErrInvalidConfig
 )
 
 // RegisterProviderFactory  registers ProviderFactory with the specified type.
 func RegisterProviderFactory(factory ProviderFactory) {
+	_lock.Lock()
+	defer _lock.Unlock()
 	_providerFactories[factory.Type()] = factory
 }
 
+// GetProviderFactory returns the ProviderFactory of the type.
+func GetProviderFactory(typ string) (ProviderFactory, error) {
+	_lock.RLock()
+	defer _lock.RUnlock()
+	if factory, ok := _providerFactories[typ]; ok {
+		return factory, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrProviderFactoryNotFound, typ)
+}
+
+// CreateProvider creates a Provider of the type from options with its registered factory.
+func CreateProvider(typ string, options map[string]interface{}) (Provider, error) {
+	factory, err := GetProviderFactory(typ)
+	if err != nil {
+		return nil, err
+	}
+	return factory.Create(options)
+}
+
 //nolint
 // GetIdentityProvider returns identity Provider with key.
 func GetIdentityProvider(key string) (Provider, error) {
+	_lock.RLock()
+	defer _lock.RUnlock()
 	if provider, ok := _providers[key]; ok {
 		return provider, nil
 	}
@@ -42,5 +76,25 @@ func GetIdentityProvider(key string) (Provider, error) {
 //nolint
 // RegisterIdentityProvider register Provider with key.
 func RegisterIdentityProvider(key string, provider Provider) {
+	_lock.Lock()
+	defer _lock.Unlock()
 	_providers[key] = provider
 }
+
+// IdentityProviders returns the registered providers by key.
+func IdentityProviders() map[string]Provider {
+	_lock.RLock()
+	defer _lock.RUnlock()
+	providers := make(map[string]Provider, len(_providers))
+	for key, p := range _providers {
+		providers[key] = p
+	}
+	return providers
+}
+
+// UnregisterIdentityProvider removes the Provider with key.
+func UnregisterIdentityProvider(key string) {
+	_lock.Lock()
+	defer _lock.Unlock()
+	delete(_providers, key)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package idpconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/middleware"
+)
+
+const _redacted = "******"
+
+// _sensitiveOptions option names containing one of these have their literal values redacted.
+var _sensitiveOptions = []string{"secret", "password", "token", "private"}
+
+type handlerRequest struct {
+	Type    string                 `json:"type"`
+	Options map[string]interface{} `json:"options"`
+}
+
+// Handler serves the identity provider configuration of the tenant the request authenticated
+// as: GET returns it with literal secrets redacted, PUT with a JSON {type, options} body tests
+// and activates it, or only tests it with ?dry_run=true, and DELETE removes it. Mount it behind
+// the authentication and authorization middlewares.
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID := middleware.TenantFromContext(r.Context())
+		if tenantID == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			p, err := m.Get(tenantID)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, m.redact(p))
+		case http.MethodPut:
+			body := &handlerRequest{}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(body); err != nil {
+				http.Error(w, "malformed identity provider configuration", http.StatusBadRequest)
+				return
+			}
+			p := &IdentityProvider{TenantID: tenantID, Type: body.Type, Options: body.Options}
+			if r.URL.Query().Get("dry_run") == "true" {
+				if err := m.Test(r.Context(), p); err != nil {
+					writeError(w, err)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if err := m.Put(r.Context(), p); err != nil {
+				writeError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, m.redact(p))
+		case http.MethodDelete:
+			if err := m.Delete(tenantID); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// redact returns a copy of p with the literal values of sensitive options replaced, secret
+// references are kept as they reveal nothing.
+func (m *Manager) redact(p *IdentityProvider) *IdentityProvider {
+	out := *p
+	out.Options = make(map[string]interface{}, len(p.Options))
+	for k, v := range p.Options {
+		out.Options[k] = v
+		if !isSensitive(k) {
+			continue
+		}
+		if s, ok := v.(string); ok && m.resolver != nil && m.resolver.IsReference(s) {
+			continue
+		}
+		out.Options[k] = _redacted
+	}
+	return &out
+}
+
+func isSensitive(option string) bool {
+	option = strings.ToLower(option)
+	for _, s := range _sensitiveOptions {
+		if strings.Contains(option, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrProviderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrForbiddenReference), errors.Is(err, ErrForbiddenTarget), errors.Is(err, ErrTenantRequired):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrInvalidProvider):
+		// the cause, e.g. why a connection failed, would make the test a probe of the network.
+		log.Warnf("identity provider configuration: %s", err)
+		http.Error(w, ErrInvalidProvider.Error(), http.StatusUnprocessableEntity)
+	default:
+		log.Errorf("identity provider configuration: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,347 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package idpconfig manages the identity provider configurations of tenants at runtime. A
+// configuration is only stored after the provider it creates passed its live test, and every
+// stored configuration is registered as the tenant's provider.
+package idpconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/secrets"
+)
+
+const _defaultTestTimeout = 10 * time.Second
+
+var (
+	// ErrProviderNotFound the tenant has no identity provider configuration.
+	ErrProviderNotFound = errors.New("identity provider configuration not found")
+	// ErrTenantRequired the configuration has no tenant.
+	ErrTenantRequired = errors.New("tenant required")
+	// ErrInvalidProvider the provider could not be created from the configuration or failed its test.
+	ErrInvalidProvider = errors.New("invalid identity provider configuration")
+	// ErrForbiddenReference an option refers to a secret outside the namespace of the tenant.
+	ErrForbiddenReference = errors.New("secret reference outside the tenant namespace")
+	// ErrForbiddenTarget an option points at a loopback, link-local or private address.
+	ErrForbiddenTarget = errors.New("identity provider address not allowed")
+)
+
+// _tenantPlaceholder the tenant id in Config.SecretNamespace.
+const _tenantPlaceholder = "{tenant}"
+
+// _privateNetworks the RFC 1918, shared address space and unique local networks.
+var _privateNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isPrivate(ip net.IP) bool {
+	for _, network := range _privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// _targetSchemes the URL schemes of options that providers connect to.
+var _targetSchemes = []string{"http", "https", "ldap", "ldaps"}
+
+// IdentityProvider the identity provider configuration of a tenant.
+type IdentityProvider struct {
+	TenantID string `json:"tenant_id"`
+	// Type the type of the registered idprovider.ProviderFactory, e.g. "OIDCAuthProvider".
+	Type string `json:"type"`
+	// Options the options of the factory, string values may be secret references.
+	Options   map[string]interface{} `json:"options"`
+	CreatedAt time.Time              `json:"created_at"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// Store persists the configurations by tenant.
+type Store interface {
+	Get(tenantID string) (*IdentityProvider, error)
+	List() ([]*IdentityProvider, error)
+	Save(p *IdentityProvider) error
+	Delete(tenantID string) error
+}
+
+type Config struct {
+	// TestTimeout bounds the live test of a provider, default 10s.
+	TestTimeout time.Duration `mapstructure:"test_timeout" json:"test_timeout" yaml:"testTimeout"`
+	// SecretNamespace the prefix the secret references of a tenant must start with, {tenant} is
+	// replaced by the tenant id, e.g. vault://secret/data/tenants/{tenant}/. The options are
+	// supplied by tenants, so without it secret references are rejected.
+	SecretNamespace string `mapstructure:"secret_namespace" json:"secret_namespace" yaml:"secretNamespace"`
+	// AllowPrivateNetworks lets providers be tested at and connect to loopback, link-local and
+	// private addresses, for deployments whose tenants are trusted with the internal network.
+	AllowPrivateNetworks bool `mapstructure:"allow_private_networks" json:"allow_private_networks" yaml:"allowPrivateNetworks"`
+}
+
+// Manager validates, stores and activates the configurations.
+type Manager struct {
+	conf     Config
+	store    Store
+	resolver *secrets.Resolver
+	lookupIP func(ctx context.Context, host string) ([]net.IPAddr, error)
+	logger   *log.Helper
+}
+
+// NewManager returns a Manager, resolver resolves secret references in the options and may be nil.
+func NewManager(conf *Config, store Store, resolver *secrets.Resolver) *Manager {
+	m := &Manager{store: store, resolver: resolver, lookupIP: net.DefaultResolver.LookupIPAddr}
+	if conf != nil {
+		m.conf = *conf
+	}
+	if m.conf.TestTimeout <= 0 {
+		m.conf.TestTimeout = _defaultTestTimeout
+	}
+	return m
+}
+
+// SetLogger writes the entries of the configuration loading to l instead of the global logger.
+func (m *Manager) SetLogger(l log.Logger) {
+	m.logger = log.NewHelper(l)
+}
+
+// Test creates the provider of p and runs its live test, such as the OIDC discovery or the
+// LDAP bind, without storing or activating it.
+func (m *Manager) Test(ctx context.Context, p *IdentityProvider) error {
+	_, err := m.create(ctx, p)
+	return err
+}
+
+// Put tests p and, when it passed, stores it and makes it the tenant's provider.
+func (m *Manager) Put(ctx context.Context, p *IdentityProvider) error {
+	provider, err := m.create(ctx, p)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	p.CreatedAt, p.UpdatedAt = now, now
+	if old, err := m.store.Get(p.TenantID); err == nil {
+		p.CreatedAt = old.CreatedAt
+	} else if !errors.Is(err, ErrProviderNotFound) {
+		return fmt.Errorf("get identity provider of %s %w", p.TenantID, err)
+	}
+	if err := m.store.Save(p); err != nil {
+		return fmt.Errorf("save identity provider of %s %w", p.TenantID, err)
+	}
+	idprovider.RegisterIdentityProvider(p.TenantID, provider)
+	return nil
+}
+
+func (m *Manager) Get(tenantID string) (*IdentityProvider, error) {
+	return m.store.Get(tenantID)
+}
+
+func (m *Manager) List() ([]*IdentityProvider, error) {
+	return m.store.List()
+}
+
+// Delete removes the configuration and deactivates the tenant's provider.
+func (m *Manager) Delete(tenantID string) error {
+	if _, err := m.store.Get(tenantID); err != nil {
+		return err
+	}
+	if err := m.store.Delete(tenantID); err != nil {
+		return fmt.Errorf("delete identity provider of %s %w", tenantID, err)
+	}
+	idprovider.UnregisterIdentityProvider(tenantID)
+	return nil
+}
+
+// RevokeTenant removes the configuration of the tenant if it has one, e.g. when it is offboarded.
+func (m *Manager) RevokeTenant(tenantID string) error {
+	if err := m.Delete(tenantID); err != nil && !errors.Is(err, ErrProviderNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Load activates all stored configurations, call it on startup. Configurations that fail are
+// logged and skipped, their tests are not run so an unreachable provider does not block startup.
+func (m *Manager) Load(ctx context.Context) error {
+	providers, err := m.store.List()
+	if err != nil {
+		return fmt.Errorf("list identity providers %w", err)
+	}
+	for _, p := range providers {
+		provider, err := m.build(ctx, p)
+		if err != nil {
+			m.logger.Errorf("load identity provider of %s: %s", p.TenantID, err)
+			continue
+		}
+		idprovider.RegisterIdentityProvider(p.TenantID, provider)
+	}
+	return nil
+}
+
+func (m *Manager) create(ctx context.Context, p *IdentityProvider) (idprovider.Provider, error) {
+	if !m.conf.AllowPrivateNetworks {
+		if err := m.checkTargets(ctx, "", p.Options); err != nil {
+			return nil, err
+		}
+	}
+	provider, err := m.build(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if tester, ok := provider.(idprovider.Tester); ok {
+		ctx, cancel := context.WithTimeout(ctx, m.conf.TestTimeout)
+		defer cancel()
+		if err := tester.Test(ctx); err != nil {
+			return nil, fmt.Errorf("%w: test %s: %s", ErrInvalidProvider, p.Type, err)
+		}
+	}
+	return provider, nil
+}
+
+func (m *Manager) build(ctx context.Context, p *IdentityProvider) (idprovider.Provider, error) {
+	if p.TenantID == "" {
+		return nil, ErrTenantRequired
+	}
+	options := p.Options
+	if m.resolver != nil {
+		if err := m.checkReferences(p.TenantID, options); err != nil {
+			return nil, err
+		}
+		resolved, err := m.resolver.ResolveOptions(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("resolve options of %s %w", p.TenantID, err)
+		}
+		options = resolved
+	}
+	provider, err := idprovider.CreateProvider(p.Type, options)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProvider, err)
+	}
+	return provider, nil
+}
+
+// checkReferences rejects secret references in v outside the namespace of tenantID, so a tenant
+// can not make the provider use the secrets of the platform or of other tenants.
+func (m *Manager) checkReferences(tenantID string, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		if m.resolver.IsReference(v) && !m.inNamespace(tenantID, v) {
+			return ErrForbiddenReference
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if err := m.checkReferences(tenantID, item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := m.checkReferences(tenantID, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Manager) inNamespace(tenantID, ref string) bool {
+	if m.conf.SecretNamespace == "" || tenantID == "" || strings.ContainsAny(tenantID, "/%") || tenantID == ".." {
+		return false
+	}
+	// escapes and dot segments could climb out of the namespace once the path is unescaped.
+	if strings.Contains(ref, "%") || strings.Contains(ref, "..") {
+		return false
+	}
+	namespace := strings.ReplaceAll(m.conf.SecretNamespace, _tenantPlaceholder, tenantID)
+	if !strings.HasSuffix(namespace, "/") {
+		namespace += "/"
+	}
+	return strings.HasPrefix(ref, namespace)
+}
+
+// checkTargets rejects options, the URLs and the host option, resolving to loopback, link-local,
+// private or unspecified addresses, so the live test can not probe the internal network.
+func (m *Manager) checkTargets(ctx context.Context, name string, v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		host := ""
+		if u, err := url.Parse(v); err == nil && u.Host != "" {
+			for _, scheme := range _targetSchemes {
+				if strings.EqualFold(u.Scheme, scheme) {
+					host = u.Hostname()
+				}
+			}
+		} else if strings.EqualFold(name, "host") {
+			host = v
+			if h, _, err := net.SplitHostPort(v); err == nil {
+				host = h
+			}
+		}
+		if host == "" {
+			return nil
+		}
+		return m.checkHost(ctx, host)
+	case map[string]interface{}:
+		for k, item := range v {
+			if err := m.checkTargets(ctx, k, item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := m.checkTargets(ctx, name, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Manager) checkHost(ctx context.Context, host string) error {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		addrs, err := m.lookupIP(ctx, host)
+		if err != nil {
+			return fmt.Errorf("%w: lookup %s: %s", ErrInvalidProvider, host, err)
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() ||
+			ip.IsInterfaceLocalMulticast() || isPrivate(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenTarget, host)
+		}
+	}
+	return nil
+}
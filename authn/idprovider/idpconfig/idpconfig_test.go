@@ -0,0 +1,189 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package idpconfig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/secrets"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const _fakeType = "idpconfig-fake"
+
+type fakeProvider struct {
+	url string
+}
+
+func (p *fakeProvider) Type() string { return _fakeType }
+func (p *fakeProvider) AuthenticateCode(code string) (idprovider.Identity, error) {
+	return nil, errors.New("unsupported")
+}
+func (p *fakeProvider) Authenticate(username, password string) (idprovider.Identity, error) {
+	return nil, errors.New("unsupported")
+}
+func (p *fakeProvider) AuthCodeURL(state, nonce string) string { return p.url }
+
+func (p *fakeProvider) Test(ctx context.Context) error {
+	if p.url != "https://idp.example.com" {
+		return errors.New("unreachable")
+	}
+	return nil
+}
+
+type fakeFactory struct{}
+
+func (fakeFactory) Type() string { return _fakeType }
+func (fakeFactory) Create(options map[string]interface{}) (idprovider.Provider, error) {
+	url, _ := options["url"].(string)
+	if url == "" {
+		return nil, errors.New("url required")
+	}
+	return &fakeProvider{url: url}, nil
+}
+
+func init() {
+	idprovider.RegisterProviderFactory(fakeFactory{})
+}
+
+// publicLookup resolves every host to a public address, so the tests do not depend on DNS.
+func publicLookup(_ context.Context, host string) ([]net.IPAddr, error) {
+	if host == "internal.example.com" {
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.7")}}, nil
+	}
+	return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+}
+
+func TestManager(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.Nil(t, err)
+	gormStore, err := NewGormStore(db)
+	assert.Nil(t, err)
+
+	stores := map[string]Store{"memory": NewMemoryStore(), "gorm": gormStore}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			tenantID := "tnt-" + name
+			m := NewManager(nil, store, nil)
+			m.lookupIP = publicLookup
+			ctx := context.Background()
+
+			err := m.Put(ctx, &IdentityProvider{TenantID: tenantID, Type: _fakeType, Options: map[string]interface{}{"url": "https://down.example.com"}})
+			assert.ErrorIs(t, err, ErrInvalidProvider)
+			err = m.Put(ctx, &IdentityProvider{TenantID: tenantID, Type: "unknown", Options: map[string]interface{}{}})
+			assert.ErrorIs(t, err, ErrInvalidProvider)
+			_, err = idprovider.GetIdentityProvider(tenantID)
+			assert.ErrorIs(t, err, idprovider.ErrIdentityProviderNotFound)
+
+			err = m.Put(ctx, &IdentityProvider{TenantID: tenantID, Type: _fakeType, Options: map[string]interface{}{"url": "https://idp.example.com"}})
+			assert.Nil(t, err)
+			provider, err := idprovider.GetIdentityProvider(tenantID)
+			assert.Nil(t, err)
+			assert.Equal(t, "https://idp.example.com", provider.AuthCodeURL("", ""))
+
+			p, err := m.Get(tenantID)
+			assert.Nil(t, err)
+			assert.Equal(t, "https://idp.example.com", p.Options["url"])
+
+			// a failing update keeps the active provider.
+			err = m.Put(ctx, &IdentityProvider{TenantID: tenantID, Type: _fakeType, Options: map[string]interface{}{"url": "https://down.example.com"}})
+			assert.ErrorIs(t, err, ErrInvalidProvider)
+			p, _ = m.Get(tenantID)
+			assert.Equal(t, "https://idp.example.com", p.Options["url"])
+
+			idprovider.UnregisterIdentityProvider(tenantID)
+			assert.Nil(t, m.Load(ctx))
+			_, err = idprovider.GetIdentityProvider(tenantID)
+			assert.Nil(t, err)
+
+			assert.Nil(t, m.Delete(tenantID))
+			assert.ErrorIs(t, m.Delete(tenantID), ErrProviderNotFound)
+			_, err = idprovider.GetIdentityProvider(tenantID)
+			assert.ErrorIs(t, err, idprovider.ErrIdentityProviderNotFound)
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	resolver := secrets.NewResolver(0)
+	resolver.Register("mem", secrets.ProviderFunc(func(_ context.Context, path string) (map[string]string, error) {
+		return map[string]string{"password": "secret"}, nil
+	}))
+	m := NewManager(&Config{SecretNamespace: "mem://tenants/{tenant}/"}, NewMemoryStore(), resolver)
+	m.lookupIP = publicLookup
+	h := m.Handler()
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		r = r.WithContext(middleware.WithClaims(r.Context(), &token.Claims{TenantID: "tnt-http"}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	defer idprovider.UnregisterIdentityProvider("tnt-http")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"not configured", http.MethodGet, "/idp", "", http.StatusNotFound},
+		{"malformed", http.MethodPut, "/idp", "{", http.StatusBadRequest},
+		{"test fails", http.MethodPut, "/idp?dry_run=true", `{"type":"idpconfig-fake","options":{"url":"https://down.example.com"}}`, http.StatusUnprocessableEntity},
+		{"dry run", http.MethodPut, "/idp?dry_run=true", `{"type":"idpconfig-fake","options":{"url":"https://idp.example.com"}}`, http.StatusNoContent},
+		{"dry run not stored", http.MethodGet, "/idp", "", http.StatusNotFound},
+		{"env reference", http.MethodPut, "/idp", `{"type":"idpconfig-fake","options":{"url":"https://idp.example.com","password":"env://HOME"}}`, http.StatusUnprocessableEntity},
+		{"file reference", http.MethodPut, "/idp", `{"type":"idpconfig-fake","options":{"url":"https://idp.example.com","password":"file:///etc/passwd"}}`, http.StatusUnprocessableEntity},
+		{"other tenant reference", http.MethodPut, "/idp", `{"type":"idpconfig-fake","options":{"url":"https://idp.example.com","password":"mem://tenants/tnt-other/ldap#password"}}`, http.StatusUnprocessableEntity},
+		{"escaping reference", http.MethodPut, "/idp", `{"type":"idpconfig-fake","options":{"url":"https://idp.example.com","password":"mem://tenants/tnt-http/../tnt-other/ldap"}}`, http.StatusUnprocessableEntity},
+		{"loopback target", http.MethodPut, "/idp?dry_run=true", `{"type":"idpconfig-fake","options":{"url":"http://127.0.0.1:8500"}}`, http.StatusUnprocessableEntity},
+		{"metadata target", http.MethodPut, "/idp?dry_run=true", `{"type":"idpconfig-fake","options":{"url":"http://169.254.169.254/latest"}}`, http.StatusUnprocessableEntity},
+		{"private host", http.MethodPut, "/idp?dry_run=true", `{"type":"idpconfig-fake","options":{"url":"https://idp.example.com","host":"internal.example.com:389"}}`, http.StatusUnprocessableEntity},
+		{"put", http.MethodPut, "/idp", `{"type":"idpconfig-fake","options":{"url":"https://idp.example.com","client_secret":"s3cret","password":"mem://tenants/tnt-http/ldap#password"}}`, http.StatusOK},
+		{"get", http.MethodGet, "/idp", "", http.StatusOK},
+		{"method", http.MethodPost, "/idp", "", http.StatusMethodNotAllowed},
+		{"delete", http.MethodDelete, "/idp", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.method, tt.target, tt.body)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.name == "get" {
+				assert.NotContains(t, w.Body.String(), "s3cret")
+				assert.Contains(t, w.Body.String(), "mem://tenants/tnt-http/ldap#password")
+			}
+			if tt.name == "test fails" {
+				assert.NotContains(t, w.Body.String(), "unreachable")
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/idp", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
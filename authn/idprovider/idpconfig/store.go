@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package idpconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+var (
+	_ Store = &MemoryStore{}
+	_ Store = &GormStore{}
+)
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock      sync.RWMutex
+	providers map[string]IdentityProvider
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{providers: make(map[string]IdentityProvider)}
+}
+
+func (s *MemoryStore) Get(tenantID string) (*IdentityProvider, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	p, ok := s.providers[tenantID]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+	return &p, nil
+}
+
+func (s *MemoryStore) List() ([]*IdentityProvider, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	providers := make([]*IdentityProvider, 0, len(s.providers))
+	for _, p := range s.providers {
+		p := p
+		providers = append(providers, &p)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].TenantID < providers[j].TenantID })
+	return providers, nil
+}
+
+func (s *MemoryStore) Save(p *IdentityProvider) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.providers[p.TenantID] = *p
+	return nil
+}
+
+func (s *MemoryStore) Delete(tenantID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.providers, tenantID)
+	return nil
+}
+
+// GormStore keeps the configurations in the sys_t_identity_provider table.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore migrates the table and returns a GormStore.
+func NewGormStore(db *gorm.DB) (*GormStore, error) {
+	if err := db.AutoMigrate(&model.IdentityProvider{}); err != nil {
+		return nil, fmt.Errorf("migrate identity providers %w", err)
+	}
+	return &GormStore{db: db}, nil
+}
+
+func (s *GormStore) Get(tenantID string) (*IdentityProvider, error) {
+	row := &model.IdentityProvider{TenantID: tenantID}
+	found, err := row.Get(s.db)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrProviderNotFound
+	}
+	return fromModel(row)
+}
+
+func (s *GormStore) List() ([]*IdentityProvider, error) {
+	rows, err := model.ListIdentityProviders(s.db)
+	if err != nil {
+		return nil, err
+	}
+	providers := make([]*IdentityProvider, 0, len(rows))
+	for _, row := range rows {
+		p, err := fromModel(row)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+func (s *GormStore) Save(p *IdentityProvider) error {
+	options, err := json.Marshal(p.Options)
+	if err != nil {
+		return fmt.Errorf("marshal options %w", err)
+	}
+	return (&model.IdentityProvider{
+		TenantID:  p.TenantID,
+		Type:      p.Type,
+		Options:   string(options),
+		CreatedAt: p.CreatedAt,
+		UpdatedAt: p.UpdatedAt,
+	}).Save(s.db)
+}
+
+func (s *GormStore) Delete(tenantID string) error {
+	return (&model.IdentityProvider{TenantID: tenantID}).Delete(s.db)
+}
+
+func fromModel(row *model.IdentityProvider) (*IdentityProvider, error) {
+	p := &IdentityProvider{
+		TenantID:  row.TenantID,
+		Type:      row.Type,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+	}
+	if row.Options != "" {
+		if err := json.Unmarshal([]byte(row.Options), &p.Options); err != nil {
+			return nil, fmt.Errorf("unmarshal options of %s %w", row.TenantID, err)
+		}
+	}
+	return p, nil
+}
@@ -16,6 +16,7 @@ package ldap
 import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/validation"
 )
 
 func init() {
@@ -35,8 +36,41 @@ func (l *ldapProviderFactory) Create(options map[string]interface{}) (idprovider
 	if err := mapstructure.Decode(options, &ldapProvider); err != nil {
 		return nil, err
 	}
-	if ldapProvider.ReadTimeout <= 0 {
-		ldapProvider.ReadTimeout = _defaultReadTimeout
+	if err := ldapProvider.init(); err != nil {
+		return nil, err
 	}
 	return &ldapProvider, nil
 }
+
+// init validates the configuration, applies the defaults and creates the connection pool.
+func (l *ldapProvider) init() error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	if l.ReadTimeout <= 0 {
+		l.ReadTimeout = _defaultReadTimeout
+	}
+	if l.PoolSize <= 0 {
+		l.PoolSize = _defaultPoolSize
+	}
+	l.pool = newConnPool(l.PoolSize, l.newConn)
+	return nil
+}
+
+// Validate reports all the problems of the configuration.
+func (l *ldapProvider) Validate() error {
+	r := validation.New()
+	r.Required("host", l.Host, "set the host:port of the LDAP server")
+	r.Required("userSearchBase", l.UserSearchBase, "set the DN users are searched under, e.g. ou=people,dc=example,dc=com")
+	r.Required("loginAttribute", l.LoginAttribute, "set the attribute matching the login name, e.g. uid")
+	if l.ManagerDN != "" && l.ManagerPassword == "" {
+		r.Add("managerPassword", "required with managerDN", "set the password of the manager DN")
+	}
+	if l.RootCA != "" && l.RootCAData != "" {
+		r.Add("rootCAData", "conflicts with rootCA", "set either the root CA file or its inline data")
+	}
+	if l.InsecureSkipVerify && (l.RootCA != "" || l.RootCAData != "") {
+		r.Add("insecureSkipVerify", "disables the configured root CA", "remove insecureSkipVerify to verify the server with the root CA")
+	}
+	return r.Err()
+}
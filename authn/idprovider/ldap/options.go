@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ldap
+
+import (
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+)
+
+// Option configures a provider created by NewLDAPProvider.
+type Option func(l *ldapProvider)
+
+// NewLDAPProvider returns a provider of the LDAP server at host authenticating the users found
+// under userSearchBase by loginAttribute.
+func NewLDAPProvider(host, userSearchBase, loginAttribute string, opts ...Option) (idprovider.Provider, error) {
+	l := &ldapProvider{Host: host, UserSearchBase: userSearchBase, LoginAttribute: loginAttribute}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if err := l.init(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// WithStartTLS upgrades the connections with StartTLS.
+func WithStartTLS() Option {
+	return func(l *ldapProvider) {
+		l.StartTLS = true
+	}
+}
+
+// WithInsecureSkipVerify turns off the TLS certificate checks of the server.
+func WithInsecureSkipVerify() Option {
+	return func(l *ldapProvider) {
+		l.InsecureSkipVerify = true
+	}
+}
+
+// WithRootCA trusts the root certificate of the file at path.
+func WithRootCA(path string) Option {
+	return func(l *ldapProvider) {
+		l.RootCA = path
+	}
+}
+
+// WithRootCAData trusts the base64 encoded PEM root certificate.
+func WithRootCAData(data string) Option {
+	return func(l *ldapProvider) {
+		l.RootCAData = data
+	}
+}
+
+// WithManager binds as the manager dn to search the users.
+func WithManager(dn, password string) Option {
+	return func(l *ldapProvider) {
+		l.ManagerDN, l.ManagerPassword = dn, password
+	}
+}
+
+// WithUserSearchFilter the filter identifying users, e.g. (objectClass=person).
+func WithUserSearchFilter(filter string) Option {
+	return func(l *ldapProvider) {
+		l.UserSearchFilter = filter
+	}
+}
+
+// WithGroupSearch the scope and the filter of the groups.
+func WithGroupSearch(base, filter string) Option {
+	return func(l *ldapProvider) {
+		l.GroupSearchBase, l.GroupSearchFilter = base, filter
+	}
+}
+
+// WithMemberAttributes the attributes holding the groups of a user and the members of a group.
+func WithMemberAttributes(user, group string) Option {
+	return func(l *ldapProvider) {
+		l.UserMemberAttribute, l.GroupMemberAttribute = user, group
+	}
+}
+
+// WithMailAttribute the attribute holding the email of a user.
+func WithMailAttribute(attribute string) Option {
+	return func(l *ldapProvider) {
+		l.MailAttribute = attribute
+	}
+}
+
+// WithReadTimeout bounds the reads from the server, default to 15s.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(l *ldapProvider) {
+		l.ReadTimeout = int(timeout.Milliseconds())
+	}
+}
+
+// WithPoolSize the idle connections kept for reuse, default to 4.
+func WithPoolSize(size int) Option {
+	return func(l *ldapProvider) {
+		l.PoolSize = size
+	}
+}
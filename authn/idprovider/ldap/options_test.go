@@ -0,0 +1,69 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ldap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLDAPProvider(t *testing.T) {
+	p, err := NewLDAPProvider("ldap.example.com:389", "ou=users,dc=example,dc=com", "uid")
+	assert.NoError(t, err)
+	l := p.(*ldapProvider)
+	assert.Equal(t, "ldap.example.com:389", l.Host)
+	assert.Equal(t, "ou=users,dc=example,dc=com", l.UserSearchBase)
+	assert.Equal(t, "uid", l.LoginAttribute)
+	assert.Equal(t, _defaultReadTimeout, l.ReadTimeout)
+	assert.Equal(t, _defaultPoolSize, l.PoolSize)
+	assert.NotNil(t, l.pool)
+	assert.Equal(t, _ldapIdentityProvider, p.Type())
+
+	p, err = NewLDAPProvider("ldap.example.com:636", "ou=users,dc=example,dc=com", "uid",
+		WithStartTLS(),
+		WithRootCAData("cm9vdA=="),
+		WithManager("cn=admin,dc=example,dc=com", "secret"),
+		WithUserSearchFilter("(objectClass=person)"),
+		WithGroupSearch("ou=groups,dc=example,dc=com", "(objectClass=group)"),
+		WithMemberAttributes("memberOf", "member"),
+		WithMailAttribute("mail"),
+		WithReadTimeout(3*time.Second),
+		WithPoolSize(8),
+	)
+	assert.NoError(t, err)
+	l = p.(*ldapProvider)
+	assert.True(t, l.StartTLS)
+	assert.Equal(t, "cm9vdA==", l.RootCAData)
+	assert.Equal(t, "cn=admin,dc=example,dc=com", l.ManagerDN)
+	assert.Equal(t, "secret", l.ManagerPassword)
+	assert.Equal(t, "(objectClass=person)", l.UserSearchFilter)
+	assert.Equal(t, "ou=groups,dc=example,dc=com", l.GroupSearchBase)
+	assert.Equal(t, "(objectClass=group)", l.GroupSearchFilter)
+	assert.Equal(t, "memberOf", l.UserMemberAttribute)
+	assert.Equal(t, "member", l.GroupMemberAttribute)
+	assert.Equal(t, "mail", l.MailAttribute)
+	assert.Equal(t, 3000, l.ReadTimeout)
+	assert.Equal(t, 8, l.PoolSize)
+	assert.Equal(t, 8, cap(l.pool.idle))
+
+	p, err = NewLDAPProvider("ldap.example.com:636", "ou=users,dc=example,dc=com", "uid", WithInsecureSkipVerify())
+	assert.NoError(t, err)
+	assert.True(t, p.(*ldapProvider).InsecureSkipVerify)
+
+	_, err = NewLDAPProvider("ldap.example.com:636", "ou=users,dc=example,dc=com", "uid",
+		WithInsecureSkipVerify(), WithRootCAData("cm9vdA=="))
+	assert.Error(t, err)
+}
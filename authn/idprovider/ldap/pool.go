@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/go-ldap/ldap"
+)
+
+const (
+	_defaultPoolSize = 4
+	_dialAttempts    = 3
+	_dialBackoff     = 100 * time.Millisecond
+)
+
+// connPool keeps idle connections to the LDAP server for reuse. Every use binds first, so a
+// connection a login left bound as its user is rebound before the next use.
+type connPool struct {
+	dial func() (*ldap.Conn, error)
+	idle chan *ldap.Conn
+}
+
+// newConnPool returns a connPool keeping up to size idle connections, none when size <= 0.
+func newConnPool(size int, dial func() (*ldap.Conn, error)) *connPool {
+	if size < 0 {
+		size = 0
+	}
+	return &connPool{dial: dial, idle: make(chan *ldap.Conn, size)}
+}
+
+// do runs fn on a pooled connection, each request of fn bounded by timeout or the deadline of ctx.
+// A reused connection the server closed meanwhile is replaced once by a fresh one.
+func (p *connPool) do(ctx context.Context, timeout time.Duration, fn func(conn *ldap.Conn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timeout = utils.Timeout(ctx, timeout)
+	conn, reused, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	conn.SetTimeout(timeout)
+	err = fn(conn)
+	if reused && isNetworkError(err) {
+		conn.Close()
+		if conn, err = p.connect(ctx); err != nil {
+			return err
+		}
+		conn.SetTimeout(timeout)
+		err = fn(conn)
+	}
+	p.put(conn, err)
+	return err
+}
+
+func (p *connPool) get(ctx context.Context) (*ldap.Conn, bool, error) {
+	for {
+		select {
+		case conn := <-p.idle:
+			if conn.IsClosing() {
+				conn.Close()
+				continue
+			}
+			return conn, true, nil
+		default:
+			conn, err := p.connect(ctx)
+			return conn, false, err
+		}
+	}
+}
+
+// connect dials the server, retrying with backoff so a short outage does not fail the login.
+func (p *connPool) connect(ctx context.Context) (*ldap.Conn, error) {
+	var conn *ldap.Conn
+	err := utils.Retry(ctx, _dialAttempts, _dialBackoff, func() (err error) {
+		conn, err = p.dial()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ldap: dial %w", err)
+	}
+	return conn, nil
+}
+
+// put keeps conn for reuse unless the pool is full or err is not an answer of the server, e.g.
+// a timeout, after which the state of the connection is unknown.
+func (p *connPool) put(conn *ldap.Conn, err error) {
+	var lerr *ldap.Error
+	if err != nil && (!errors.As(err, &lerr) || lerr.ResultCode == ldap.ErrorNetwork) {
+		conn.Close()
+		return
+	}
+	select {
+	case p.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func isNetworkError(err error) bool {
+	var lerr *ldap.Error
+	return errors.As(err, &lerr) && lerr.ResultCode == ldap.ErrorNetwork
+}
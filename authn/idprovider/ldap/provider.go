@@ -14,6 +14,7 @@ limitations under the License.
 package ldap
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
@@ -23,11 +24,17 @@ import (
 	"time"
 
 	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/tracing"
 
 	"github.com/go-ldap/ldap"
 )
 
-var _ idprovider.Provider = &ldapProvider{}
+var (
+	_ idprovider.Provider        = &ldapProvider{}
+	_ idprovider.Tester          = &ldapProvider{}
+	_ idprovider.ContextProvider = &ldapProvider{}
+)
 
 const (
 	_ldapIdentityProvider = "LDAPIdentityProvider"
@@ -68,6 +75,10 @@ type ldapProvider struct {
 	// login attribute used for comparing user entries.
 	LoginAttribute string `json:"login_attribute" yaml:"loginAttribute"`
 	MailAttribute  string `json:"mail_attribute" yaml:"mailAttribute"`
+	// Idle connections kept for reuse by later logins. Default to 4.
+	PoolSize int `json:"pool_size" yaml:"poolSize"`
+
+	pool *connPool
 }
 
 func (l ldapProvider) AuthCodeURL(state, nonce string) string {
@@ -84,49 +95,62 @@ func (l ldapProvider) AuthenticateCode(code string) (idprovider.Identity, error)
 	return nil, errors.New("unsupported authenticate with code")
 }
 
-//nolint
+func (l ldapProvider) AuthenticateCodeContext(ctx context.Context, code string) (idprovider.Identity, error) {
+	return l.AuthenticateCode(code)
+}
+
 func (l ldapProvider) Authenticate(username string, password string) (idprovider.Identity, error) {
-	conn, err := l.newConn()
-	if err != nil {
-		return nil, err
-	}
+	return l.AuthenticateContext(context.Background(), username, password)
+}
 
-	conn.SetTimeout(time.Duration(l.ReadTimeout) * time.Millisecond)
-	defer conn.Close()
-	if err = conn.Bind(l.ManagerDN, l.ManagerPassword); err != nil {
-		return nil, err
-	}
+//nolint
+func (l ldapProvider) AuthenticateContext(ctx context.Context, username string, password string) (_ idprovider.Identity, err error) {
+	ctx, span := tracing.Start(ctx, "ldap.authenticate", tracing.String("net.peer.name", l.Host))
+	defer func() { tracing.End(span, err) }()
 	filter := fmt.Sprintf("(%s=%s)", l.LoginAttribute, ldap.EscapeFilter(username))
 	if l.UserSearchFilter != "" {
 		filter = fmt.Sprintf("(&%s%s)", filter, l.UserSearchFilter)
 	}
-	result, err := conn.Search(&ldap.SearchRequest{
-		BaseDN:       l.UserSearchBase,
-		Scope:        ldap.ScopeWholeSubtree,
-		DerefAliases: ldap.NeverDerefAliases,
-		SizeLimit:    1,
-		TimeLimit:    0,
-		TypesOnly:    false,
-		Filter:       filter,
-		Attributes:   []string{l.LoginAttribute, l.MailAttribute},
+	var entries []*ldap.Entry
+	err = l.connPool().do(ctx, time.Duration(l.ReadTimeout)*time.Millisecond, func(conn *ldap.Conn) error {
+		if err := bind(ctx, conn, l.ManagerDN, l.ManagerPassword); err != nil {
+			return err
+		}
+		result, err := conn.Search(&ldap.SearchRequest{
+			BaseDN:       l.UserSearchBase,
+			Scope:        ldap.ScopeWholeSubtree,
+			DerefAliases: ldap.NeverDerefAliases,
+			SizeLimit:    1,
+			TimeLimit:    0,
+			TypesOnly:    false,
+			Filter:       filter,
+			Attributes:   []string{l.LoginAttribute, l.MailAttribute},
+		})
+		if err != nil {
+			return err
+		}
+		if entries = result.Entries; len(entries) != 1 {
+			return nil
+		}
+		return bind(ctx, conn, entries[0].DN, password)
 	})
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) && len(entries) == 1 {
+		return nil, errors.New("ldap: incorrect password")
+	}
 	if err != nil {
+		var lerr *ldap.Error
+		if !errors.As(err, &lerr) || lerr.ResultCode == ldap.ErrorNetwork {
+			return nil, errs.New(errs.ErrProviderUnavailable, "ldap: authenticate", err)
+		}
 		return nil, err
 	}
-	if len(result.Entries) == 0 {
-		return nil, fmt.Errorf("ldap: no results returned for filter: %v", filter)
+	if len(entries) == 0 {
+		return nil, errs.New(errs.ErrIdentityNotFound, "ldap", fmt.Errorf("no results returned for filter: %v", filter))
 	}
-	if len(result.Entries) > 1 {
+	if len(entries) > 1 {
 		return nil, fmt.Errorf("ldap: filter returned multiple results: %v", filter)
 	}
-	// len(result.Entries) == 1
-	entry := result.Entries[0]
-	if err = conn.Bind(entry.DN, password); err != nil {
-		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
-			return nil, errors.New("ldap: incorrect password")
-		}
-		return nil, err
-	}
+	entry := entries[0]
 	email := entry.GetAttributeValue(l.MailAttribute)
 	uid := entry.GetAttributeValue(l.LoginAttribute)
 	return &ldapIdentity{
@@ -136,6 +160,48 @@ func (l ldapProvider) Authenticate(username string, password string) (idprovider
 	// todo map in internal user&tenant
 }
 
+// Test binds as the manager DN and searches the user base, which checks the connection, the
+// manager credentials and the search settings.
+func (l *ldapProvider) Test(ctx context.Context) error {
+	timeout := time.Duration(l.ReadTimeout) * time.Millisecond
+	filter := "(objectClass=*)"
+	if l.UserSearchFilter != "" {
+		filter = l.UserSearchFilter
+	}
+	return l.connPool().do(ctx, timeout, func(conn *ldap.Conn) error {
+		if err := bind(ctx, conn, l.ManagerDN, l.ManagerPassword); err != nil {
+			return fmt.Errorf("ldap: bind manager %w", err)
+		}
+		_, err := conn.Search(&ldap.SearchRequest{
+			BaseDN:     l.UserSearchBase,
+			Scope:      ldap.ScopeBaseObject,
+			Filter:     filter,
+			Attributes: []string{"dn"},
+		})
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return fmt.Errorf("ldap: user search base %s not found: %w", l.UserSearchBase, err)
+		}
+		if err != nil {
+			return fmt.Errorf("ldap: search user base %w", err)
+		}
+		return nil
+	})
+}
+
+// connPool returns the pool of the provider, a provider not made by the factory dials per use.
+func (l *ldapProvider) connPool() *connPool {
+	if l.pool == nil {
+		return newConnPool(0, l.newConn)
+	}
+	return l.pool
+}
+
+func bind(ctx context.Context, conn *ldap.Conn, dn, password string) (err error) {
+	_, span := tracing.Start(ctx, "ldap.bind", tracing.String("ldap.dn", dn))
+	defer func() { tracing.End(span, err) }()
+	return conn.Bind(dn, password)
+}
+
 func (l *ldapProvider) newConn() (*ldap.Conn, error) {
 	if !l.StartTLS {
 		return ldap.Dial("tcp", l.Host)
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tkeel-io/security/utils"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// _defaultIDTokenEncryptionEnc the content encryption of id tokens when none is registered.
+// See also, https://openid.net/specs/openid-connect-registration-1_0.html#ClientMetadata
+const _defaultIDTokenEncryptionEnc = string(jose.A128CBC_HS256)
+
+var (
+	// RSA1_5 is left out, its PKCS #1 v1.5 padding is open to Bleichenbacher padding oracles.
+	_rsaKeyAlgorithms = []string{string(jose.RSA_OAEP), string(jose.RSA_OAEP_256)}
+	_ecKeyAlgorithms  = []string{
+		string(jose.ECDH_ES), string(jose.ECDH_ES_A128KW), string(jose.ECDH_ES_A192KW), string(jose.ECDH_ES_A256KW),
+	}
+	_contentEncryptions = []string{
+		string(jose.A128CBC_HS256), string(jose.A192CBC_HS384), string(jose.A256CBC_HS512),
+		string(jose.A128GCM), string(jose.A192GCM), string(jose.A256GCM),
+	}
+)
+
+// idTokenDecrypter decrypts id tokens encrypted to the public key of the client.
+type idTokenDecrypter struct {
+	key interface{}
+	alg string
+	enc string
+}
+
+// newIDTokenDecrypter parses the PEM encoded RSA or EC private key, alg defaults to RSA-OAEP or
+// ECDH-ES by key type and enc to A128CBC-HS256.
+func newIDTokenDecrypter(pemKey, alg, enc string) (*idTokenDecrypter, error) {
+	signer, err := parsePrivateKey(pemKey)
+	if err != nil {
+		return nil, err
+	}
+	var algs []string
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		algs = _rsaKeyAlgorithms
+		if alg == "" {
+			alg = string(jose.RSA_OAEP)
+		}
+	case *ecdsa.PrivateKey:
+		algs = _ecKeyAlgorithms
+		if alg == "" {
+			alg = string(jose.ECDH_ES)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encryption key type %T", signer)
+	}
+	if !utils.StringsInclude(algs, alg) {
+		return nil, fmt.Errorf("algorithm %q does not match the %T encryption key", alg, signer)
+	}
+	if enc == "" {
+		enc = _defaultIDTokenEncryptionEnc
+	}
+	if !utils.StringsInclude(_contentEncryptions, enc) {
+		return nil, fmt.Errorf("unsupported content encryption %q", enc)
+	}
+	return &idTokenDecrypter{key: signer, alg: alg, enc: enc}, nil
+}
+
+// decrypt returns the signed id token nested in the compact JWE rawIDToken, rejecting tokens
+// not encrypted with the configured algorithms so the OP can't be downgraded to plain tokens.
+func (d *idTokenDecrypter) decrypt(rawIDToken string) (string, error) {
+	if strings.Count(rawIDToken, ".") != 4 {
+		return "", errors.New("oidc: id token is not encrypted")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Enc string `json:"enc"`
+	}
+	data, err := base64.RawURLEncoding.DecodeString(rawIDToken[:strings.IndexByte(rawIDToken, '.')])
+	if err != nil || json.Unmarshal(data, &header) != nil {
+		return "", errors.New("oidc: malformed id token encryption header")
+	}
+	if header.Alg != d.alg || header.Enc != d.enc {
+		return "", fmt.Errorf("oidc: id token encrypted with %s/%s, expected %s/%s", header.Alg, header.Enc, d.alg, d.enc)
+	}
+	object, err := jose.ParseEncrypted(rawIDToken)
+	if err != nil {
+		return "", fmt.Errorf("oidc: parse encrypted id token %w", err)
+	}
+	payload, err := object.Decrypt(d.key)
+	if err != nil {
+		return "", fmt.Errorf("oidc: decrypt id token %w", err)
+	}
+	return string(payload), nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"fmt"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/validation"
+)
+
+// endpointField a configurable endpoint, its name in the discovery document and its configuration key.
+type endpointField struct {
+	name  string
+	key   string
+	value *string
+}
+
+func (e *endpoint) fields() []endpointField {
+	return []endpointField{
+		{"authorization_endpoint", "authURL", &e.AuthURL},
+		{"token_endpoint", "tokenURL", &e.TokenURL},
+		{"userinfo_endpoint", "userInfoURL", &e.UserInfoURL},
+		{"jwks_uri", "jwksurl", &e.JWKSURL},
+		{"end_session_endpoint", "endsessionurl", &e.EndSessionURL},
+		{"pushed_authorization_request_endpoint", "parurl", &e.PARURL},
+	}
+}
+
+// validateEndpoints checks the configured endpoints are absolute http(s) URLs, and that the
+// endpoints in use are configured when the issuer is not discovered.
+func (o *OIDCProvider) validateEndpoints(r *validation.Report) {
+	r = r.At("endpoint")
+	for _, f := range o.Endpoint.fields() {
+		r.URL(f.key, *f.value)
+	}
+	if o.Issuer != "" {
+		return
+	}
+	const hint = "required when issuer discovery is disabled, set issuer or configure the endpoint"
+	r.Required("authURL", o.Endpoint.AuthURL, hint)
+	r.Required("tokenURL", o.Endpoint.TokenURL, hint)
+	if o.GetUserInfo {
+		r.Required("userInfoURL", o.Endpoint.UserInfoURL, "required by getUserInfo when issuer discovery is disabled")
+	}
+	if o.UsePAR {
+		r.Required("parurl", o.Endpoint.PARURL, "required by usePAR when issuer discovery is disabled")
+	}
+}
+
+// mergeEndpoints fills the endpoints not configured from the discovered ones. A configured
+// endpoint differing from the discovered one overrides it with a warning, or fails the discovery
+// with StrictEndpoints, so the configuration can not silently drift from the OP.
+func (o *OIDCProvider) mergeEndpoints(discovered endpoint) error {
+	configured, found := o.Endpoint.fields(), discovered.fields()
+	for i, f := range configured {
+		value := *found[i].value
+		switch {
+		case *f.value == "":
+			*f.value = value
+		case value != "" && *f.value != value:
+			if o.StrictEndpoints {
+				return fmt.Errorf("%w: oidc %s %s differs from the discovered %s", idprovider.ErrInvalidConfig, f.name, *f.value, value)
+			}
+			o.logger.Warnf("oidc: configured %s %s overrides the discovered %s of %s", f.name, *f.value, value, o.Issuer)
+		}
+	}
+	o.discovered = discovered
+	return nil
+}
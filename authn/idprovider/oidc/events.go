@@ -0,0 +1,426 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authtoken "github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/coreos/go-oidc"
+)
+
+// Event types of the security event tokens handled by EventReceiver, see
+// https://openid.net/specs/openid-caep-specification-1_0.html and
+// https://openid.net/specs/openid-sharedsignals-framework-1_0.html
+const (
+	EventSessionRevoked   = "https://schemas.openid.net/secevent/caep/event-type/session-revoked"
+	EventCredentialChange = "https://schemas.openid.net/secevent/caep/event-type/credential-change"
+	EventVerification     = "https://schemas.openid.net/secevent/ssf/event-type/verification"
+)
+
+const (
+	_defaultEventMaxAge = time.Hour
+	_replaySweepEvery   = time.Minute
+	_eventLeeway        = time.Minute
+	_maxEventSize       = 64 << 10
+)
+
+var (
+	// ErrInvalidEvent the security event token is malformed or not valid.
+	ErrInvalidEvent = errors.New("invalid security event token")
+	// ErrEventSignature the signature of the security event token does not verify with the keys of the issuer.
+	ErrEventSignature = errors.New("security event token signature invalid")
+	// ErrEventIssuer the security event token was issued by another transmitter.
+	ErrEventIssuer = errors.New("security event token issuer invalid")
+	// ErrEventAudience the security event token is not meant for this receiver.
+	ErrEventAudience = errors.New("security event token audience invalid")
+	// ErrUnknownSubject the subject of the event can not be mapped to a local subject.
+	ErrUnknownSubject = errors.New("security event subject unknown")
+	// ErrEventReplayed the security event token was received before.
+	ErrEventReplayed = errors.New("security event token replayed")
+)
+
+// SubjectRevoker ends everything issued to a subject of a tenant, e.g. a *session.MemoryStore,
+// *token.MemoryStore or *server.MemoryStorage.
+type SubjectRevoker interface {
+	RevokeSubject(tenantID, subject string) error
+}
+
+// SubjectResolver maps a subject identifier of a format other than iss_sub, such as email, to
+// the local subject in the tenant of the receiver, ErrUnknownSubject when there is none.
+type SubjectResolver func(ctx context.Context, id *SubjectID) (string, error)
+
+// ReplayCache remembers the jti of received events.
+type ReplayCache interface {
+	// Seen records jti until expiresAt and reports whether it was recorded before.
+	Seen(jti string, expiresAt time.Time) bool
+	// Forget removes jti, so that the transmitter can redeliver an event that failed.
+	Forget(jti string)
+}
+
+// MemoryReplayCache in-process ReplayCache, expired events are swept at most once a minute.
+type MemoryReplayCache struct {
+	lock      sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{seen: make(map[string]time.Time), lastSweep: time.Now()}
+}
+
+func (c *MemoryReplayCache) Seen(jti string, expiresAt time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if exp, ok := c.seen[jti]; ok && now.Before(exp) {
+		return true
+	}
+	c.sweep(now)
+	c.seen[jti] = expiresAt
+	return false
+}
+
+func (c *MemoryReplayCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < _replaySweepEvery {
+		return
+	}
+	c.lastSweep = now
+	for k, exp := range c.seen {
+		if !now.Before(exp) {
+			delete(c.seen, k)
+		}
+	}
+}
+
+func (c *MemoryReplayCache) Forget(jti string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.seen, jti)
+}
+
+// SubjectID a subject identifier of RFC 9493, a complex subject carries the user and the session
+// as members.
+type SubjectID struct {
+	Format  string     `json:"format,omitempty"`
+	Iss     string     `json:"iss,omitempty"`
+	Sub     string     `json:"sub,omitempty"`
+	Email   string     `json:"email,omitempty"`
+	ID      string     `json:"id,omitempty"`
+	User    *SubjectID `json:"user,omitempty"`
+	Session *SubjectID `json:"session,omitempty"`
+}
+
+// EventReceiverConfig of an EventReceiver.
+type EventReceiverConfig struct {
+	// TenantID the issuer is the identity provider of, the subjects of the events are users of
+	// this tenant only.
+	TenantID string `mapstructure:"tenant_id" json:"tenant_id" yaml:"tenantID"`
+	// Issuer of the events, the upstream OP.
+	Issuer string `mapstructure:"issuer" json:"issuer" yaml:"issuer"`
+	// Audience the receiver was registered with at the transmitter, usually the client id.
+	Audience string `mapstructure:"audience" json:"audience" yaml:"audience"`
+	// MaxAge rejects events issued longer ago. Default to 1h.
+	MaxAge time.Duration `mapstructure:"max_age" json:"max_age" yaml:"maxAge"`
+}
+
+// EventReceiver receives the security event tokens the upstream OP pushes about its sessions
+// and users (RFC 8935), and ends the local sessions and tokens on session-revoked and
+// credential-change events. An event is accepted once, its jti is remembered until it is
+// older than MaxAge.
+type EventReceiver struct {
+	conf     EventReceiverConfig
+	keys     oidc.KeySet
+	revokers []SubjectRevoker
+	revoke   SessionRevoker
+	resolve  SubjectResolver
+	replay   ReplayCache
+	logger   *log.Helper
+}
+
+// NewEventReceiver returns a receiver of the events of conf.Issuer signed with keys, e.g. the
+// KeySet of the provider. The revokers end the sessions and tokens of a subject of conf.TenantID.
+func NewEventReceiver(conf EventReceiverConfig, keys oidc.KeySet, revokers ...SubjectRevoker) (*EventReceiver, error) {
+	if conf.Issuer == "" || conf.Audience == "" {
+		return nil, errors.New("oidc: event issuer and audience required")
+	}
+	if conf.TenantID == "" {
+		return nil, errors.New("oidc: event tenant required")
+	}
+	if keys == nil {
+		return nil, errors.New("oidc: event keys required")
+	}
+	if conf.MaxAge <= 0 {
+		conf.MaxAge = _defaultEventMaxAge
+	}
+	return &EventReceiver{conf: conf, keys: keys, revokers: revokers, replay: NewMemoryReplayCache()}, nil
+}
+
+// SetLogger writes the entries of the received security events to l instead of the global logger.
+func (e *EventReceiver) SetLogger(l log.Logger) {
+	e.logger = log.NewHelper(l)
+}
+
+// SetReplayCache remembers the jti of received events in replay, shared by the replicas of the
+// receiver. Default to a MemoryReplayCache.
+func (e *EventReceiver) SetReplayCache(replay ReplayCache) {
+	e.replay = replay
+}
+
+// SetSessionRevoker ends the sessions of the OP session of session-revoked events with revoke,
+// without it the event ends all sessions of the user.
+func (e *EventReceiver) SetSessionRevoker(revoke SessionRevoker) {
+	e.revoke = revoke
+}
+
+// SetSubjectResolver maps subject identifiers other than iss_sub of the issuer with resolve.
+func (e *EventReceiver) SetSubjectResolver(resolve SubjectResolver) {
+	e.resolve = resolve
+}
+
+// KeySet returns the signing keys of the OP, to verify the security events it transmits.
+func (o *OIDCProvider) KeySet(ctx context.Context) (oidc.KeySet, error) {
+	if err := o.ensureDiscovered(ctx); err != nil {
+		return nil, err
+	}
+	if o.Endpoint.JWKSURL == "" {
+		return nil, errors.New("oidc: jwks endpoint required")
+	}
+	// the key set keeps the context to fetch keys, it must outlive the request starting it.
+	clientCtx := oidc.ClientContext(context.Background(), &http.Client{Transport: o.transport(), Timeout: utils.DefaultHTTPTimeout})
+	return oidc.NewRemoteKeySet(clientCtx, o.Endpoint.JWKSURL), nil
+}
+
+type securityEvent struct {
+	Iss    string                     `json:"iss"`
+	Aud    interface{}                `json:"aud"`
+	Iat    *int64                     `json:"iat"`
+	Exp    *int64                     `json:"exp"`
+	Jti    string                     `json:"jti"`
+	SubID  *SubjectID                 `json:"sub_id"`
+	Events map[string]json.RawMessage `json:"events"`
+}
+
+type eventPayload struct {
+	Subject    *SubjectID `json:"subject"`
+	ChangeType string     `json:"change_type"`
+}
+
+// Receive validates the security event token set and acts on its events, unknown event types
+// are ignored. A set received before is rejected with ErrEventReplayed.
+func (e *EventReceiver) Receive(ctx context.Context, set string) (err error) {
+	event, err := e.verify(ctx, set)
+	if err != nil {
+		return err
+	}
+	if e.replay.Seen(event.Jti, time.Unix(*event.Iat, 0).Add(e.conf.MaxAge)) {
+		return ErrEventReplayed
+	}
+	defer func() {
+		if err != nil {
+			e.replay.Forget(event.Jti)
+		}
+	}()
+	for typ, raw := range event.Events {
+		var payload eventPayload
+		if err = json.Unmarshal(raw, &payload); err != nil {
+			return fmt.Errorf("%w: event %s %s", ErrInvalidEvent, typ, err)
+		}
+		subject := payload.Subject
+		if subject == nil {
+			subject = event.SubID
+		}
+		switch typ {
+		case EventSessionRevoked:
+			err = e.sessionRevoked(ctx, subject)
+		case EventCredentialChange:
+			err = e.credentialChanged(ctx, subject, payload.ChangeType)
+		case EventVerification:
+			e.logger.Debugf("oidc: security event stream of %s verified", e.conf.Issuer)
+		default:
+			e.logger.Debugf("oidc: ignore security event %s of %s", typ, e.conf.Issuer)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify checks the signature and the claims of set, see https://www.rfc-editor.org/rfc/rfc8417.
+func (e *EventReceiver) verify(ctx context.Context, set string) (*securityEvent, error) {
+	parts := strings.Split(set, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a signed jwt", ErrInvalidEvent)
+	}
+	if err := checkEventType(parts[0]); err != nil {
+		return nil, err
+	}
+	payload, err := e.keys.VerifySignature(ctx, set)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrEventSignature, err)
+	}
+	event := &securityEvent{}
+	if err = json.Unmarshal(payload, event); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEvent, err)
+	}
+	if event.Iss != e.conf.Issuer {
+		return nil, ErrEventIssuer
+	}
+	if !utils.StringsInclude(authtoken.ClaimStrings(map[string]interface{}{"aud": event.Aud}, "aud"), e.conf.Audience) {
+		return nil, ErrEventAudience
+	}
+	now := time.Now()
+	switch {
+	case event.Jti == "":
+		return nil, fmt.Errorf("%w: jti required", ErrInvalidEvent)
+	case len(event.Events) == 0:
+		return nil, fmt.Errorf("%w: events required", ErrInvalidEvent)
+	case event.Iat == nil:
+		return nil, fmt.Errorf("%w: iat required", ErrInvalidEvent)
+	case time.Unix(*event.Iat, 0).After(now.Add(_eventLeeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidEvent)
+	case time.Unix(*event.Iat, 0).Before(now.Add(-e.conf.MaxAge)):
+		return nil, fmt.Errorf("%w: too old", ErrInvalidEvent)
+	case event.Exp != nil && now.After(time.Unix(*event.Exp, 0).Add(_eventLeeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidEvent)
+	}
+	return event, nil
+}
+
+// checkEventType rejects jwts explicitly typed as something else, such as id tokens.
+func checkEventType(header string) error {
+	raw, err := base64.RawURLEncoding.DecodeString(header)
+	if err != nil {
+		return fmt.Errorf("%w: header %s", ErrInvalidEvent, err)
+	}
+	var h struct {
+		Typ string `json:"typ"`
+	}
+	if err = json.Unmarshal(raw, &h); err != nil {
+		return fmt.Errorf("%w: header %s", ErrInvalidEvent, err)
+	}
+	typ := strings.TrimPrefix(strings.ToLower(h.Typ), "application/")
+	if typ != "" && typ != "secevent+jwt" {
+		return fmt.Errorf("%w: typ %s", ErrInvalidEvent, h.Typ)
+	}
+	return nil
+}
+
+// sessionRevoked ends the OP session of a complex subject with a session, otherwise all sessions
+// of the user.
+func (e *EventReceiver) sessionRevoked(ctx context.Context, id *SubjectID) error {
+	if id != nil && id.Session != nil && e.revoke != nil {
+		sid := id.Session.ID
+		if sid == "" {
+			sid = id.Session.Sub
+		}
+		if sid != "" {
+			if err := e.revoke(ctx, e.conf.Issuer, sid); err != nil {
+				return fmt.Errorf("oidc: revoke session %s %w", sid, err)
+			}
+			return nil
+		}
+	}
+	return e.revokeSubject(ctx, id)
+}
+
+// credentialChanged ends the sessions and tokens of the user, whatever the change type: a
+// credential added by an attacker is as much a reason to log out as a revoked one.
+func (e *EventReceiver) credentialChanged(ctx context.Context, id *SubjectID, changeType string) error {
+	e.logger.Debugf("oidc: credential change %s of %s", changeType, e.conf.Issuer)
+	return e.revokeSubject(ctx, id)
+}
+
+func (e *EventReceiver) revokeSubject(ctx context.Context, id *SubjectID) error {
+	subject, err := e.subject(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, r := range e.revokers {
+		if err = r.RevokeSubject(e.conf.TenantID, subject); err != nil {
+			return fmt.Errorf("oidc: revoke %s of tenant %s %w", subject, e.conf.TenantID, err)
+		}
+	}
+	return nil
+}
+
+// subject returns the local subject of the user of id.
+func (e *EventReceiver) subject(ctx context.Context, id *SubjectID) (string, error) {
+	if id != nil && id.User != nil {
+		id = id.User
+	}
+	switch {
+	case id == nil:
+		return "", fmt.Errorf("%w: subject required", ErrInvalidEvent)
+	case id.Format == "iss_sub" && id.Iss == e.conf.Issuer && id.Sub != "":
+		return id.Sub, nil
+	case e.resolve != nil:
+		return e.resolve(ctx, id)
+	}
+	return "", fmt.Errorf("%w: format %s", ErrUnknownSubject, id.Format)
+}
+
+// ServeHTTP serves the push delivery endpoint registered at the transmitter, see
+// https://www.rfc-editor.org/rfc/rfc8935.
+func (e *EventReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/secevent+jwt") {
+		writeEventError(w, "invalid_request", "content type must be application/secevent+jwt")
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, _maxEventSize))
+	if err != nil {
+		writeEventError(w, "invalid_request", "request body too large")
+		return
+	}
+	err = e.Receive(r.Context(), strings.TrimSpace(string(body)))
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusAccepted)
+	case errors.Is(err, ErrEventSignature):
+		writeEventError(w, "invalid_key", err.Error())
+	case errors.Is(err, ErrEventIssuer):
+		writeEventError(w, "invalid_issuer", err.Error())
+	case errors.Is(err, ErrEventAudience):
+		writeEventError(w, "invalid_audience", err.Error())
+	case errors.Is(err, ErrInvalidEvent), errors.Is(err, ErrUnknownSubject), errors.Is(err, ErrEventReplayed):
+		writeEventError(w, "invalid_request", err.Error())
+	default:
+		// the transmitter retries the delivery.
+		e.logger.Errorf("oidc: security event of %s %s", e.conf.Issuer, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func writeEventError(w http.ResponseWriter, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = json.NewEncoder(w).Encode(map[string]string{"err": code, "description": description})
+}
@@ -15,12 +15,20 @@ package oidc
 
 import (
 	"context"
-	"crypto/tls"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token/dpop"
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/tracing"
 	"github.com/tkeel-io/security/utils"
+	"github.com/tkeel-io/security/validation"
 
 	"github.com/coreos/go-oidc"
 	"github.com/mitchellh/mapstructure"
@@ -44,59 +52,176 @@ func (f *oidcProviderFactory) Create(options map[string]interface{}) (idprovider
 	if err := mapstructure.Decode(options, &oidcProvider); err != nil {
 		return nil, fmt.Errorf("mapstructure decode provider options %w", err)
 	}
-	if oidcProvider.Issuer != "" {
-		ctx := context.TODO()
-		if oidcProvider.InsecureSkipVerify {
-			client := &http.Client{
-				Transport: &http.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: true, // nolint
-					},
-				},
-			}
-			ctx = oidc.ClientContext(ctx, client)
-		}
-		provider, err := oidc.NewProvider(ctx, oidcProvider.Issuer)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create oidc provider: %w", err)
-		}
-		var providerJSON map[string]interface{}
-		if err = provider.Claims(&providerJSON); err != nil {
-			return nil, fmt.Errorf("failed to decode oidc provider claims: %w", err)
-		}
-		oidcProvider.Endpoint.AuthURL, _ = providerJSON["authorization_endpoint"].(string)
-		oidcProvider.Endpoint.TokenURL, _ = providerJSON["token_endpoint"].(string)
-		oidcProvider.Endpoint.UserInfoURL, _ = providerJSON["userinfo_endpoint"].(string)
-		oidcProvider.Endpoint.JWKSURL, _ = providerJSON["jwks_uri"].(string)
-		oidcProvider.Endpoint.EndSessionURL, _ = providerJSON["end_session_endpoint"].(string)
-		oidcProvider.Provider = provider
-		oidcProvider.Verifier = provider.Verifier(&oidc.Config{
-			// TODO: support HS256.
-			ClientID: oidcProvider.ClientID,
-		})
+	if err := oidcProvider.init(context.TODO()); err != nil {
+		return nil, err
+	}
+	if oidcProvider.Provider != nil {
 		options["endpoint"] = map[string]interface{}{
 			"auth_url":        oidcProvider.Endpoint.AuthURL,
 			"token_url":       oidcProvider.Endpoint.TokenURL,
 			"user_info_url":   oidcProvider.Endpoint.UserInfoURL,
 			"jwksurl":         oidcProvider.Endpoint.JWKSURL,
 			"end_session_url": oidcProvider.Endpoint.EndSessionURL,
+			"par_url":         oidcProvider.Endpoint.PARURL,
+		}
+	}
+	return &oidcProvider, nil
+}
+
+// init wires the provider from its configuration: discovers the issuer unless LazyInit is set,
+// then builds the DPoP proofer, the id token decrypter, the userinfo cache and the OAuth2 config.
+func (o *OIDCProvider) init(ctx context.Context) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	if o.Issuer != "" && !o.LazyInit {
+		if err := o.ensureDiscovered(ctx); err != nil {
+			return err
+		}
+	}
+	if o.UserInfoCacheSize > 0 && o.UserInfoCache == nil {
+		o.UserInfoCache = cache.NewLRU(o.UserInfoCacheSize)
+	}
+	if o.DPoPKey != "" && o.DPoP == nil {
+		key, err := parsePrivateKey(o.DPoPKey)
+		if err != nil {
+			return fmt.Errorf("failed to parse dpop key: %w", err)
+		}
+		if o.DPoP, err = dpop.NewProofer(key); err != nil {
+			return fmt.Errorf("failed to create dpop proofer: %w", err)
+		}
+	}
+	if o.IDTokenEncryptionKey != "" && o.decrypter == nil {
+		decrypter, err := newIDTokenDecrypter(o.IDTokenEncryptionKey, o.IDTokenEncryptionAlg, o.IDTokenEncryptionEnc)
+		if err != nil {
+			return fmt.Errorf("oidc: id token encryption key %w", err)
 		}
+		o.decrypter = decrypter
 	}
+	// openid is always requested. Scopes already listing it are kept as configured, earlier
+	// versions replaced them with openid alone and dropped e.g. profile and email.
 	scopes := []string{oidc.ScopeOpenID}
-	if !utils.StringsInclude(oidcProvider.Scopes, oidc.ScopeOpenID) {
-		scopes = append(scopes, oidcProvider.Scopes...)
+	if !utils.StringsInclude(o.Scopes, oidc.ScopeOpenID) {
+		scopes = append(scopes, o.Scopes...)
+	} else {
+		scopes = o.Scopes
 	}
 
-	oidcProvider.Scopes = scopes
-	oidcProvider.OAuth2Config = &oauth2.Config{
-		ClientID:     oidcProvider.ClientID,
-		ClientSecret: oidcProvider.ClientSecret,
+	o.Scopes = scopes
+	o.OAuth2Config = &oauth2.Config{
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
 		Endpoint: oauth2.Endpoint{
-			TokenURL: oidcProvider.Endpoint.TokenURL,
-			AuthURL:  oidcProvider.Endpoint.AuthURL,
+			TokenURL: o.Endpoint.TokenURL,
+			AuthURL:  o.Endpoint.AuthURL,
 		},
-		RedirectURL: oidcProvider.RedirectURL,
-		Scopes:      oidcProvider.Scopes,
+		RedirectURL: o.RedirectURL,
+		Scopes:      o.Scopes,
 	}
-	return &oidcProvider, nil
+	return nil
+}
+
+// Validate reports all the problems of the configuration, it is run before the provider is initialized.
+func (o *OIDCProvider) Validate() error {
+	r := validation.New()
+	r.Required("clientID", o.ClientID, "set the client id registered at the OP")
+	r.URL("issuer", o.Issuer)
+	r.URL("redirectURL", o.RedirectURL)
+	o.validateEndpoints(r)
+	if o.UserInfoCacheTTL < 0 {
+		r.Add("userInfoCacheTTL", "must not be negative", "leave it unset for the 5m default")
+	}
+	if o.UserInfoCacheSize < 0 {
+		r.Add("userInfoCacheSize", "must not be negative", "leave it unset to disable the in process cache")
+	}
+	if o.RequestObjectSigningKey != "" {
+		if _, _, err := parseRequestObjectKey(o.RequestObjectSigningKey, o.RequestObjectSigningAlg); err != nil {
+			r.Add("requestObjectSigningKey", err.Error(), "use a PEM encoded RSA or EC private key matching requestObjectSigningAlg")
+		}
+	}
+	if o.DPoPKey != "" {
+		if _, err := parsePrivateKey(o.DPoPKey); err != nil {
+			r.Add("dpopKey", err.Error(), "use a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key")
+		}
+	}
+	if o.IDTokenEncryptionKey != "" {
+		if _, err := newIDTokenDecrypter(o.IDTokenEncryptionKey, o.IDTokenEncryptionAlg, o.IDTokenEncryptionEnc); err != nil {
+			r.Add("idTokenEncryptionKey", err.Error(), "use a PEM encoded RSA or EC private key matching idTokenEncryptionAlg and idTokenEncryptionEnc")
+		}
+	}
+	return r.Err()
+}
+
+// ensureDiscovered discovers the endpoints and keys of the issuer once it succeeds, retrying
+// with backoff, so an OP unavailable at startup is picked up by a later login.
+func (o *OIDCProvider) ensureDiscovered(ctx context.Context) error {
+	if o.Issuer == "" {
+		return nil
+	}
+	return o.discovery.Do(func() error {
+		// the provider keeps the context to fetch keys, it must outlive the request starting it.
+		clientCtx := oidc.ClientContext(context.Background(), &http.Client{Transport: o.transport(), Timeout: utils.DefaultHTTPTimeout})
+		var provider *oidc.Provider
+		err := utils.Retry(ctx, _discoveryAttempts, _discoveryBackoff, func() (err error) {
+			provider, err = discover(clientCtx, o.Issuer)
+			return err
+		})
+		if err != nil {
+			return errs.New(errs.ErrProviderUnavailable, "oidc: failed to create oidc provider", err)
+		}
+		var providerJSON map[string]interface{}
+		if err = provider.Claims(&providerJSON); err != nil {
+			return fmt.Errorf("failed to decode oidc provider claims: %w", err)
+		}
+		var discovered endpoint
+		for _, f := range discovered.fields() {
+			*f.value, _ = providerJSON[f.name].(string)
+		}
+		if err = o.mergeEndpoints(discovered); err != nil {
+			return err
+		}
+		if required, _ := providerJSON["require_pushed_authorization_requests"].(bool); required {
+			o.UsePAR = true
+		}
+		o.Provider = provider
+		verifierConfig := &oidc.Config{
+			// TODO: support HS256.
+			ClientID:             o.ClientID,
+			SupportedSigningAlgs: o.SupportedSigningAlgs,
+		}
+		if o.Endpoint.JWKSURL != discovered.JWKSURL {
+			o.Verifier = oidc.NewVerifier(o.Issuer, oidc.NewRemoteKeySet(clientCtx, o.Endpoint.JWKSURL), verifierConfig)
+		} else {
+			o.Verifier = provider.Verifier(verifierConfig)
+		}
+		if o.OAuth2Config != nil {
+			o.OAuth2Config.Endpoint.AuthURL = o.Endpoint.AuthURL
+			o.OAuth2Config.Endpoint.TokenURL = o.Endpoint.TokenURL
+		}
+		return nil
+	})
+}
+
+func discover(ctx context.Context, issuer string) (_ *oidc.Provider, err error) {
+	ctx, span := tracing.Start(ctx, "oidc.discovery", tracing.String("oidc.issuer", issuer))
+	defer func() { tracing.End(span, err) }()
+	return oidc.NewProvider(ctx, issuer)
+}
+
+// parsePrivateKey parses a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key.
+func parsePrivateKey(pemKey string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
 }
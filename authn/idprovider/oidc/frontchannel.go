@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tkeel-io/security/authn/session"
+	authtoken "github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/log"
+)
+
+const _frontChannelLoggedOut = "<!DOCTYPE html><html><head><title>Logged out</title></head><body></body></html>"
+
+// SessionRevoker ends the local sessions of the OP session sid of issuer, for sessions kept
+// server side and indexed by sid.
+type SessionRevoker func(ctx context.Context, issuer, sid string) error
+
+// FrontChannelLogout serves the frontchannel_logout_uri registered at the OP, which loads it in
+// an iframe when the end-user logs out there, see https://openid.net/specs/openid-connect-frontchannel-1_0.html.
+// The session of the request is ended when it belongs to the OP session of the sid parameter,
+// logouts without iss and sid are rejected unless AllowMissingSessionID is set.
+// Browsers only send the session cookie to the iframe with SameSite=None, otherwise register a
+// SessionRevoker ending the sessions by sid.
+type FrontChannelLogout struct {
+	issuer     string
+	sessions   *session.Manager
+	allowNoSID bool
+	revoke     SessionRevoker
+	logger     *log.Helper
+}
+
+// NewFrontChannelLogout returns the logout endpoint of the sessions of the OP at issuer.
+func NewFrontChannelLogout(issuer string, sessions *session.Manager) *FrontChannelLogout {
+	return &FrontChannelLogout{issuer: issuer, sessions: sessions}
+}
+
+// SetLogger writes the entries of the logout requests to l instead of the global logger.
+func (f *FrontChannelLogout) SetLogger(l log.Logger) {
+	f.logger = log.NewHelper(l)
+}
+
+// AllowMissingSessionID accepts logouts without iss and sid, for OPs not supporting
+// frontchannel_logout_session_required. Such a logout still only ends sessions without a
+// recorded sid, so a cross-site request cannot log out a session of a sid aware OP.
+func (f *FrontChannelLogout) AllowMissingSessionID() {
+	f.allowNoSID = true
+}
+
+// SetSessionRevoker ends the sessions of the validated sid with revoke, also when the iframe
+// does not carry the session cookie.
+func (f *FrontChannelLogout) SetSessionRevoker(revoke SessionRevoker) {
+	f.revoke = revoke
+}
+
+func (f *FrontChannelLogout) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	iss, sid := r.URL.Query().Get("iss"), r.URL.Query().Get("sid")
+	switch {
+	case (iss == "") != (sid == ""):
+		http.Error(w, "iss and sid must be sent together", http.StatusBadRequest)
+		return
+	case !f.allowNoSID && sid == "":
+		http.Error(w, "iss and sid required", http.StatusBadRequest)
+		return
+	case iss != "" && iss != f.issuer:
+		http.Error(w, "unknown issuer", http.StatusBadRequest)
+		return
+	}
+	if s, err := f.sessions.Load(r); err == nil && s.Claims != nil && f.matches(s.Claims, sid) {
+		if err = f.sessions.Logout(w, r); err != nil {
+			f.logger.Errorf("oidc: front-channel logout %s", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	if sid != "" && f.revoke != nil {
+		if err := f.revoke(r.Context(), iss, sid); err != nil {
+			f.logger.Errorf("oidc: front-channel logout of sid %s %s", sid, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(_frontChannelLoggedOut))
+}
+
+// matches reports whether the session of claims belongs to the OP session sid, without sid any
+// session without a recorded sid and not known to belong to another OP matches.
+func (f *FrontChannelLogout) matches(claims *authtoken.Claims, sid string) bool {
+	iss := authtoken.ClaimString(claims.Extra, ExtraIssuer)
+	if sid == "" {
+		return authtoken.ClaimString(claims.Extra, ExtraSessionID) == "" && (iss == "" || iss == f.issuer)
+	}
+	return authtoken.ClaimString(claims.Extra, ExtraSessionID) == sid && iss == f.issuer
+}
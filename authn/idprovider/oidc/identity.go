@@ -13,6 +13,14 @@ limitations under the License.
 
 package oidc
 
+import "github.com/tkeel-io/security/authn/idprovider"
+
+// Keys of the OP session in the extensions of an identity, front-channel logout matches them.
+const (
+	ExtraIssuer    = "iss"
+	ExtraSessionID = "sid"
+)
+
 type oidcIdentity struct {
 	// TenantID tenant id.
 	TenantID string `json:"tenant_id"`
@@ -26,6 +34,12 @@ type oidcIdentity struct {
 	// Its value MUST conform to the RFC 5322 [RFC5322] addr-spec syntax.
 	// The RP MUST NOT rely upon this value being unique.
 	Email string `json:"email"`
+	// Groups the End-User is a member of.
+	Groups []string `json:"groups,omitempty"`
+	// Issuer of the id token.
+	Issuer string `json:"iss,omitempty"`
+	// SessionID sid of the session at the OP, see https://openid.net/specs/openid-connect-frontchannel-1_0.html#ClaimsContents
+	SessionID string `json:"sid,omitempty"`
 }
 
 func (o oidcIdentity) GetTenantID() string {
@@ -37,7 +51,18 @@ func (o oidcIdentity) GetExternalID() string {
 }
 
 func (o oidcIdentity) GetExtra() map[string]interface{} {
-	return nil
+	extra := make(map[string]interface{})
+	if len(o.Groups) > 0 {
+		extra[idprovider.ExtraGroups] = o.Groups
+	}
+	if o.SessionID != "" {
+		extra[ExtraIssuer] = o.Issuer
+		extra[ExtraSessionID] = o.SessionID
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	return extra
 }
 
 func (o oidcIdentity) GetUserID() string {
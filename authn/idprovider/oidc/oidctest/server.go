@@ -0,0 +1,406 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oidctest an in-memory OpenID provider for integration tests, serving discovery, JWKS,
+// authorization, token, userinfo and end session endpoints with controllable claims and failures.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// PathDiscovery the discovery document.
+	PathDiscovery = "/.well-known/openid-configuration"
+	// PathJWKS the signing keys of the id tokens.
+	PathJWKS = "/jwks"
+	// PathAuthorize the authorization endpoint, it logs the end-user in without a prompt.
+	PathAuthorize = "/authorize"
+	// PathToken the token endpoint.
+	PathToken = "/token"
+	// PathUserInfo the userinfo endpoint.
+	PathUserInfo = "/userinfo"
+	// PathEndSession the RP-initiated logout endpoint.
+	PathEndSession = "/logout"
+
+	_keyID = "oidctest"
+)
+
+// Server an OpenID provider listening on a random local port. The id tokens carry the claims
+// of SetClaims, signed with a key generated by NewServer.
+type Server struct {
+	// Issuer the issuer URL, give it to the provider under test.
+	Issuer   string
+	ClientID string
+	// ClientSecret required from the client when not empty.
+	ClientSecret string
+
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	lock     sync.Mutex
+	claims   map[string]interface{}
+	userInfo map[string]interface{}
+	ttl      time.Duration
+	// codes the nonce of each issued code.
+	codes map[string]string
+	// challenges the PKCE challenge of the codes of authorization requests carrying one.
+	challenges map[string]string
+	tokens     map[string]bool
+	failures   map[string]int
+	hits       map[string]int
+	logouts    []string
+	extraMeta  map[string]interface{}
+	encrypter  jose.Encrypter
+}
+
+// NewServer starts a Server for the client clientID, call Close when done.
+func NewServer(clientID string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic("oidctest: generate key " + err.Error())
+	}
+	s := &Server{
+		ClientID: clientID,
+		key:      key,
+		claims: map[string]interface{}{
+			"sub":                "user-1",
+			"email":              "user-1@example.com",
+			"preferred_username": "user1",
+		},
+		userInfo:   make(map[string]interface{}),
+		ttl:        time.Hour,
+		codes:      make(map[string]string),
+		challenges: make(map[string]string),
+		tokens:     make(map[string]bool),
+		failures:   make(map[string]int),
+		hits:       make(map[string]int),
+		extraMeta:  make(map[string]interface{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathDiscovery, s.handleDiscovery)
+	mux.HandleFunc(PathJWKS, s.handleJWKS)
+	mux.HandleFunc(PathAuthorize, s.handleAuthorize)
+	mux.HandleFunc(PathToken, s.handleToken)
+	mux.HandleFunc(PathUserInfo, s.handleUserInfo)
+	mux.HandleFunc(PathEndSession, s.handleEndSession)
+	s.server = httptest.NewServer(s.intercept(mux))
+	s.Issuer = s.server.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetClaims replaces the claims of the id tokens issued from now on, sub included.
+func (s *Server) SetClaims(claims map[string]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.claims = claims
+}
+
+// SetUserInfo sets the claims the userinfo endpoint adds to the sub of the id token.
+func (s *Server) SetUserInfo(claims map[string]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.userInfo = claims
+}
+
+// SetIDTokenEncryption encrypts the id tokens issued from now on to key with alg and enc, a nil
+// key issues plain signed id tokens again.
+func (s *Server) SetIDTokenEncryption(key interface{}, alg jose.KeyAlgorithm, enc jose.ContentEncryption) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if key == nil {
+		s.encrypter = nil
+		return nil
+	}
+	encrypter, err := jose.NewEncrypter(enc, jose.Recipient{Algorithm: alg, Key: key}, (&jose.EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		return err
+	}
+	s.encrypter = encrypter
+	return nil
+}
+
+// SetIDTokenTTL sets the lifetime of the id tokens issued from now on, a negative ttl issues
+// expired tokens. Default to 1h.
+func (s *Server) SetIDTokenTTL(ttl time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ttl = ttl
+}
+
+// SetMetadata adds key to the discovery document, e.g. require_pushed_authorization_requests.
+func (s *Server) SetMetadata(key string, value interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.extraMeta[key] = value
+}
+
+// Fail answers the requests to path with status until Fail(path, 0).
+func (s *Server) Fail(path string, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if status == 0 {
+		delete(s.failures, path)
+		return
+	}
+	s.failures[path] = status
+}
+
+// Hits returns the number of requests made to path.
+func (s *Server) Hits(path string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.hits[path]
+}
+
+// Logouts returns the id_token_hint of the requests to the end session endpoint.
+func (s *Server) Logouts() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.logouts...)
+}
+
+// IssueCode returns an authorization code for the current claims, as the authorization endpoint
+// would after a login, nonce is copied into the id token.
+func (s *Server) IssueCode(nonce string) string {
+	code := randomString()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.codes[code] = nonce
+	return code
+}
+
+// IDToken returns an id token of the current claims signed by the server.
+func (s *Server) IDToken(nonce string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.idToken(nonce)
+}
+
+func (s *Server) idToken(nonce string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": s.Issuer,
+		"aud": s.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(s.ttl).Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	for k, v := range s.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = _keyID
+	signed, err := token.SignedString(s.key)
+	if err != nil || s.encrypter == nil {
+		return signed, err
+	}
+	object, err := s.encrypter.Encrypt([]byte(signed))
+	if err != nil {
+		return "", err
+	}
+	return object.CompactSerialize()
+}
+
+// SecurityEvent returns a security event token for audience carrying events, signed by the
+// server as a Shared Signals transmitter would. claims are added to the token.
+func (s *Server) SecurityEvent(audience string, events map[string]interface{}, claims map[string]interface{}) (string, error) {
+	c := jwt.MapClaims{
+		"iss":    s.Issuer,
+		"aud":    audience,
+		"iat":    time.Now().Unix(),
+		"jti":    randomString(),
+		"events": events,
+	}
+	for k, v := range claims {
+		c[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	token.Header["kid"] = _keyID
+	token.Header["typ"] = "secevent+jwt"
+	return token.SignedString(s.key)
+}
+
+// intercept counts the requests and answers the failing paths with their status.
+func (s *Server) intercept(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.hits[r.URL.Path]++
+		status := s.failures[r.URL.Path]
+		s.lock.Unlock()
+		if status != 0 {
+			writeJSON(w, status, map[string]string{"error": "server_error", "error_description": "oidctest failure"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	doc := map[string]interface{}{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + PathAuthorize,
+		"token_endpoint":                        s.Issuer + PathToken,
+		"userinfo_endpoint":                     s.Issuer + PathUserInfo,
+		"jwks_uri":                              s.Issuer + PathJWKS,
+		"end_session_endpoint":                  s.Issuer + PathEndSession,
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	}
+	for k, v := range s.extraMeta {
+		doc[k] = v
+	}
+	writeJSON(w, http.StatusOK, doc)
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &s.key.PublicKey, KeyID: _keyID, Algorithm: "RS256", Use: "sig"},
+	}})
+}
+
+// handleAuthorize logs the end-user in at once and redirects back with a code.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() || q.Get("client_id") != s.ClientID {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	params := redirectURI.Query()
+	code := s.IssueCode(q.Get("nonce"))
+	if challenge := q.Get("code_challenge"); challenge != "" {
+		s.lock.Lock()
+		s.challenges[code] = challenge
+		s.lock.Unlock()
+	}
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.ClientID || (s.ClientSecret != "" && secret != s.ClientSecret) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	code := r.PostForm.Get("code")
+	nonce, ok := s.codes[code]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	delete(s.codes, code)
+	if challenge, ok := s.challenges[code]; ok {
+		delete(s.challenges, code)
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+	}
+	idToken, err := s.idToken(nonce)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	accessToken := randomString()
+	s.tokens[accessToken] = true
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	claims := map[string]interface{}{"sub": s.claims["sub"]}
+	for k, v := range s.userInfo {
+		claims[k] = v
+	}
+	writeJSON(w, http.StatusOK, claims)
+}
+
+func (s *Server) handleEndSession(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.lock.Lock()
+	s.logouts = append(s.logouts, q.Get("id_token_hint"))
+	s.lock.Unlock()
+	redirectURI, err := url.Parse(q.Get("post_logout_redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if state := q.Get("state"); state != "" {
+		params := redirectURI.Query()
+		params.Set("state", state)
+		redirectURI.RawQuery = params.Encode()
+	}
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("oidctest: random " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
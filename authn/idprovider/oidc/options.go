@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/log"
+)
+
+// Option configures an OIDCProvider created by NewOIDCProvider.
+type Option func(o *OIDCProvider)
+
+// NewOIDCProvider returns a provider of the OP at issuer for the client, discovering the issuer
+// and building the id token verifier and the OAuth2 config, unless WithLazyInit defers the
+// discovery to the first login. The discovery document is the source of the endpoints, see
+// WithEndpoints to override them.
+func NewOIDCProvider(ctx context.Context, issuer, clientID string, opts ...Option) (*OIDCProvider, error) {
+	if issuer == "" || clientID == "" {
+		return nil, fmt.Errorf("%w: oidc issuer and client id are required", idprovider.ErrInvalidConfig)
+	}
+	o := &OIDCProvider{Issuer: issuer, ClientID: clientID}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.init(ctx); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// WithLogger writes the entries of the provider to l instead of the global logger.
+func WithLogger(l log.Logger) Option {
+	return func(o *OIDCProvider) {
+		o.logger = log.NewHelper(l)
+	}
+}
+
+// WithClientSecret authenticates the client with secret at the token endpoint.
+func WithClientSecret(secret string) Option {
+	return func(o *OIDCProvider) {
+		o.ClientSecret = secret
+	}
+}
+
+// WithRedirectURL the redirect uri registered at the OP.
+func WithRedirectURL(redirectURL string) Option {
+	return func(o *OIDCProvider) {
+		o.RedirectURL = redirectURL
+	}
+}
+
+// WithScopes requests scopes in addition to openid.
+func WithScopes(scopes ...string) Option {
+	return func(o *OIDCProvider) {
+		o.Scopes = append(o.Scopes, scopes...)
+	}
+}
+
+// WithUserInfo merges the claims of the userinfo endpoint into the identity, cached in c for ttl
+// when c is not nil.
+func WithUserInfo(c cache.Cache, ttl time.Duration) Option {
+	return func(o *OIDCProvider) {
+		o.GetUserInfo = true
+		o.UserInfoCache, o.UserInfoCacheTTL = c, ttl
+	}
+}
+
+// WithEndpoints overrides the discovered endpoints, empty ones are discovered. The overrides are
+// checked against the discovery document, see WithStrictEndpoints.
+func WithEndpoints(authURL, tokenURL, userInfoURL, jwksURL string) Option {
+	return func(o *OIDCProvider) {
+		o.Endpoint.AuthURL, o.Endpoint.TokenURL = authURL, tokenURL
+		o.Endpoint.UserInfoURL, o.Endpoint.JWKSURL = userInfoURL, jwksURL
+	}
+}
+
+// WithStrictEndpoints fails the discovery when an endpoint of WithEndpoints differs from the
+// discovered one instead of logging the mismatch.
+func WithStrictEndpoints() Option {
+	return func(o *OIDCProvider) {
+		o.StrictEndpoints = true
+	}
+}
+
+// WithPAR pushes the authorization requests to the PAR endpoint.
+func WithPAR() Option {
+	return func(o *OIDCProvider) {
+		o.UsePAR = true
+	}
+}
+
+// WithRequestObject sends the authorization parameters as a request object signed with the
+// PEM encoded pemKey, registered at the OP under keyID.
+func WithRequestObject(pemKey, keyID string) Option {
+	return func(o *OIDCProvider) {
+		o.RequestObjectSigningKey, o.RequestObjectKeyID = pemKey, keyID
+	}
+}
+
+// WithDPoPKey requests tokens bound to the PEM encoded pemKey.
+func WithDPoPKey(pemKey string) Option {
+	return func(o *OIDCProvider) {
+		o.DPoPKey = pemKey
+	}
+}
+
+// WithIDTokenEncryption decrypts the id tokens with the PEM encoded pemKey registered at the OP,
+// empty alg and enc keep the defaults.
+func WithIDTokenEncryption(pemKey, alg, enc string) Option {
+	return func(o *OIDCProvider) {
+		o.IDTokenEncryptionKey, o.IDTokenEncryptionAlg, o.IDTokenEncryptionEnc = pemKey, alg, enc
+	}
+}
+
+// WithSupportedSigningAlgs the JWS algorithms the id token may be signed with.
+func WithSupportedSigningAlgs(algs ...string) Option {
+	return func(o *OIDCProvider) {
+		o.SupportedSigningAlgs = algs
+	}
+}
+
+// WithClaimKeys the claims holding the email, the preferred username and the groups, empty
+// keys keep the defaults.
+func WithClaimKeys(email, preferredUsername, groups string) Option {
+	return func(o *OIDCProvider) {
+		o.EmailKey, o.PreferredUsernameKey, o.GroupsKey = email, preferredUsername, groups
+	}
+}
+
+// WithInsecureSkipVerify turns off the TLS certificate checks of the OP.
+func WithInsecureSkipVerify() Option {
+	return func(o *OIDCProvider) {
+		o.InsecureSkipVerify = true
+	}
+}
+
+// WithLazyInit defers the discovery of the issuer to the first login.
+func WithLazyInit() Option {
+	return func(o *OIDCProvider) {
+		o.LazyInit = true
+	}
+}
@@ -15,21 +15,47 @@ package oidc
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/tkeel-io/security/authn/idprovider"
+	authtoken "github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authn/token/dpop"
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/tracing"
+	"github.com/tkeel-io/security/utils"
 
 	"github.com/coreos/go-oidc"
 	"github.com/golang-jwt/jwt"
 	"golang.org/x/oauth2"
 )
 
-var _ idprovider.Provider = &OIDCProvider{}
+var (
+	_ idprovider.Provider        = &OIDCProvider{}
+	_ idprovider.Tester          = &OIDCProvider{}
+	_ idprovider.ContextProvider = &OIDCProvider{}
+	_ idprovider.PKCEProvider    = &OIDCProvider{}
+)
+
+const _requestObjectTTL = 5 * time.Minute
+
+const (
+	_discoveryAttempts = 3
+	_discoveryBackoff  = 200 * time.Millisecond
+)
+
+const _defaultUserInfoCacheTTL = 5 * time.Minute
 
 const _oidcIdentityType string = "OIDCIdentityProvider"
 
@@ -61,22 +87,267 @@ type OIDCProvider struct {
 	// See also, https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
 	GetUserInfo bool `json:"get_user_info" yaml:"getUserInfo"`
 
+	// UserInfoCacheTTL how long the userinfo of a subject and access token is reused from UserInfoCache. Default to 5m.
+	UserInfoCacheTTL time.Duration `json:"user_info_cache_ttl" yaml:"userInfoCacheTTL"`
+
+	// UserInfoCacheSize when positive and UserInfoCache is unset, userinfo is cached in process
+	// for at most that many subject and access token pairs.
+	UserInfoCacheSize int `json:"user_info_cache_size" yaml:"userInfoCacheSize"`
+
+	// UsePAR pushes the authorization request to the PAR endpoint and redirects with the returned request_uri.
+	// See also, https://datatracker.ietf.org/doc/html/rfc9126
+	UsePAR bool `json:"use_par" yaml:"usePAR"`
+
+	// RequestObjectSigningKey PEM encoded RSA or EC private key, when set the authorization
+	// parameters are sent as a signed request object.
+	// See also, https://datatracker.ietf.org/doc/html/rfc9101
+	RequestObjectSigningKey string `json:"-" yaml:"requestObjectSigningKey"`
+
+	// RequestObjectSigningAlg JWS algorithm of request objects. Default to RS256 or ES256 by key type.
+	RequestObjectSigningAlg string `json:"request_object_signing_alg" yaml:"requestObjectSigningAlg"`
+
+	// RequestObjectKeyID kid of the request object signing key registered at the OP.
+	RequestObjectKeyID string `json:"request_object_key_id" yaml:"requestObjectKeyID"`
+
+	// DPoPKey PEM encoded private key, when set tokens are requested bound to the key with DPoP proofs.
+	// See also, https://datatracker.ietf.org/doc/html/rfc9449
+	DPoPKey string `json:"-" yaml:"dpopKey"`
+
+	// IDTokenEncryptionKey PEM encoded RSA or EC private key of the client, when set the id
+	// tokens are expected encrypted to its public key and are decrypted before verification.
+	// See also, https://openid.net/specs/openid-connect-core-1_0.html#Encryption
+	IDTokenEncryptionKey string `json:"-" yaml:"idTokenEncryptionKey"`
+
+	// IDTokenEncryptionAlg JWE algorithm of the id token key. Default to RSA-OAEP or ECDH-ES by key type.
+	IDTokenEncryptionAlg string `json:"id_token_encryption_alg" yaml:"idTokenEncryptionAlg"`
+
+	// IDTokenEncryptionEnc JWE content encryption of the id tokens. Default to A128CBC-HS256.
+	IDTokenEncryptionEnc string `json:"id_token_encryption_enc" yaml:"idTokenEncryptionEnc"`
+
 	// Used to turn off TLS certificate checks.
 	InsecureSkipVerify bool `json:"insecure_skip_verify" yaml:"insecureSkipVerify"`
 
+	// StrictEndpoints fails the discovery when a configured endpoint differs from the discovered
+	// one, by default the configured endpoint is used and the mismatch logged.
+	StrictEndpoints bool `json:"strict_endpoints" yaml:"strictEndpoints"`
+
+	// LazyInit defers the discovery of the issuer to the first login, so an OP unavailable at
+	// startup does not fail the creation of the provider.
+	LazyInit bool `json:"lazy_init" yaml:"lazyInit"`
+
+	// JWS algorithms the id token may be signed with, e.g. RS256, ES256.
+	// Default to the algorithms advertised by the discovery document, or RS256.
+	SupportedSigningAlgs []string `json:"supported_signing_algs" yaml:"supportedSigningAlgs"`
+
 	// Configurable key which contains the email claims.
 	EmailKey string `json:"email_key" yaml:"emailKey"`
 
 	// Configurable key which contains the preferred username claims.
 	PreferredUsernameKey string `json:"preferred_username_key" yaml:"preferredUsernameKey"`
 
-	Provider     *oidc.Provider        `json:"-" yaml:"-"`
-	OAuth2Config *oauth2.Config        `json:"-" yaml:"-"`
-	Verifier     *oidc.IDTokenVerifier `json:"-" yaml:"-"`
+	// Configurable key which contains the groups claims. Default to groups.
+	GroupsKey string `json:"groups_key" yaml:"groupsKey"`
+
+	DPoP          *dpop.Proofer         `json:"-" yaml:"-"`
+	UserInfoCache cache.Cache           `json:"-" yaml:"-"`
+	Provider      *oidc.Provider        `json:"-" yaml:"-"`
+	OAuth2Config  *oauth2.Config        `json:"-" yaml:"-"`
+	Verifier      *oidc.IDTokenVerifier `json:"-" yaml:"-"`
+
+	discovery     utils.Once
+	discovered    endpoint
+	transportOnce sync.Once
+	baseTransport http.RoundTripper
+	clientOnce    sync.Once
+	client        *http.Client
+	decrypter     *idTokenDecrypter
+	logger        *log.Helper
 }
 
 func (o *OIDCProvider) AuthCodeURL(state, nonce string) string {
-	return o.OAuth2Config.AuthCodeURL(state, oidc.Nonce(nonce))
+	return o.authCodeURL(state, oidc.Nonce(nonce))
+}
+
+// AuthCodeURLPKCE is AuthCodeURL with the S256 challenge of verifier.
+func (o *OIDCProvider) AuthCodeURLPKCE(state, nonce, verifier string) string {
+	return o.authCodeURL(state, oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", idprovider.CodeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+}
+
+func (o *OIDCProvider) authCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	ctx, cancel := utils.WithTimeout(context.Background(), utils.DefaultHTTPTimeout)
+	defer cancel()
+	if err := o.ensureDiscovered(ctx); err != nil {
+		o.logger.Errorf("oidc: %s", err)
+		return ""
+	}
+	authURL := o.OAuth2Config.AuthCodeURL(state, opts...)
+	if o.RequestObjectSigningKey == "" && !o.pushesRequests() {
+		return authURL
+	}
+	secured, err := o.secureAuthCodeURL(authURL)
+	if err != nil {
+		o.logger.Errorf("oidc: secure authorization request %s", err)
+		return authURL
+	}
+	return secured
+}
+
+func (o *OIDCProvider) pushesRequests() bool {
+	return o.UsePAR && o.Endpoint.PARURL != ""
+}
+
+// secureAuthCodeURL moves the authorization parameters into a signed request object
+// and/or pushes them, the returned url only references them.
+func (o *OIDCProvider) secureAuthCodeURL(authURL string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", fmt.Errorf("parse auth url %w", err)
+	}
+	params := u.Query()
+	if o.RequestObjectSigningKey != "" {
+		object, err := o.signRequestObject(params)
+		if err != nil {
+			return "", err
+		}
+		params = url.Values{
+			"client_id":     {o.ClientID},
+			"response_type": {params.Get("response_type")},
+			"scope":         {params.Get("scope")},
+			"request":       {object},
+		}
+	}
+	if o.pushesRequests() {
+		requestURI, err := o.pushAuthorizationRequest(params)
+		if err != nil {
+			return "", err
+		}
+		params = url.Values{"client_id": {o.ClientID}, "request_uri": {requestURI}}
+	}
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+// signRequestObject returns the parameters as a request object signed with RequestObjectSigningKey.
+// See also, https://datatracker.ietf.org/doc/html/rfc9101
+func (o *OIDCProvider) signRequestObject(params url.Values) (string, error) {
+	method, key, err := parseRequestObjectKey(o.RequestObjectSigningKey, o.RequestObjectSigningAlg)
+	if err != nil {
+		return "", err
+	}
+	jti, err := utils.RandBase64String(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": o.ClientID,
+		"aud": o.Issuer,
+		"iat": now.Unix(),
+		"exp": now.Add(_requestObjectTTL).Unix(),
+		"jti": jti,
+	}
+	for k := range params {
+		claims[k] = params.Get(k)
+	}
+	t := jwt.NewWithClaims(method, claims)
+	t.Header["typ"] = "oauth-authz-req+jwt"
+	if o.RequestObjectKeyID != "" {
+		t.Header["kid"] = o.RequestObjectKeyID
+	}
+	signed, err := t.SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("sign request object %w", err)
+	}
+	return signed, nil
+}
+
+// parseRequestObjectKey parses a PEM RSA or EC private key, alg defaults to RS256 or ES256 by key type.
+func parseRequestObjectKey(pemKey, alg string) (jwt.SigningMethod, interface{}, error) {
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(pemKey)); err == nil {
+		if alg == "" {
+			alg = jwt.SigningMethodRS256.Alg()
+		}
+		if method, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodRSA); ok {
+			return method, key, nil
+		}
+		if method, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodRSAPSS); ok {
+			return method, key, nil
+		}
+		return nil, nil, fmt.Errorf("oidc: algorithm %q does not match the rsa request object key", alg)
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(pemKey))
+	if err != nil {
+		return nil, nil, errors.New("oidc: request object key must be a PEM encoded RSA or EC private key")
+	}
+	if alg == "" {
+		alg = jwt.SigningMethodES256.Alg()
+	}
+	if method, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodECDSA); ok {
+		return method, key, nil
+	}
+	return nil, nil, fmt.Errorf("oidc: algorithm %q does not match the ec request object key", alg)
+}
+
+// pushAuthorizationRequest pushes the authorization parameters and returns the request_uri referencing them.
+// See also, https://datatracker.ietf.org/doc/html/rfc9126
+func (o *OIDCProvider) pushAuthorizationRequest(params url.Values) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, o.Endpoint.PARURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("new par request %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if o.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	}
+	resp, err := o.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("post par request %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("par endpoint returned %s: %s", resp.Status, body)
+	}
+	var pushed struct {
+		RequestURI string `json:"request_uri"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&pushed); err != nil {
+		return "", fmt.Errorf("decode par response %w", err)
+	}
+	if pushed.RequestURI == "" {
+		return "", errors.New("par response without request_uri")
+	}
+	return pushed.RequestURI, nil
+}
+
+// httpClient returns the client of requests to the OP, token requests carry a DPoP proof when DPoP is set.
+// It is created once, so the connections to the OP are pooled across logins.
+func (o *OIDCProvider) httpClient() *http.Client {
+	o.clientOnce.Do(func() {
+		transport := o.transport()
+		if o.DPoP != nil {
+			transport = &dpop.Transport{Base: transport, Proofer: o.DPoP}
+		}
+		o.client = &http.Client{Transport: transport, Timeout: utils.DefaultHTTPTimeout}
+	})
+	return o.client
+}
+
+// transport returns the transport shared by all requests to the OP.
+func (o *OIDCProvider) transport() http.RoundTripper {
+	o.transportOnce.Do(func() {
+		o.baseTransport = http.DefaultTransport
+		if o.InsecureSkipVerify {
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.TLSClientConfig = &tls.Config{
+				InsecureSkipVerify: true, // nolint
+			}
+			o.baseTransport = t
+		}
+	})
+	return o.baseTransport
 }
 
 // endpoint represents an OAuth 2.0 provider's authorization and token
@@ -96,29 +367,39 @@ type endpoint struct {
 	// This URL MUST use the https scheme and MAY contain port, path, and query parameter components.
 	// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#OPMetadata
 	EndSessionURL string `json:"end_session_url"`
+	// URL of the pushed authorization request endpoint.
+	// https://datatracker.ietf.org/doc/html/rfc9126#section-5
+	PARURL string `json:"par_url"`
 }
 
-// nolint
 func (o *OIDCProvider) AuthenticateCode(code string) (idprovider.Identity, error) {
-	ctx := context.TODO()
-	if o.InsecureSkipVerify {
-		client := &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true, // nolint
-				},
-			},
-		}
-		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
-	}
-	token, err := o.OAuth2Config.Exchange(ctx, code)
+	return o.AuthenticateCodeContext(context.Background(), code)
+}
+
+// nolint
+func (o *OIDCProvider) AuthenticateCodeContext(ctx context.Context, code string) (_ idprovider.Identity, err error) {
+	ctx, span := tracing.Start(ctx, "oidc.authenticate_code", tracing.String("oidc.issuer", o.Issuer))
+	defer func() { tracing.End(span, err) }()
+	// discovery, code exchange and userinfo together, a stalled OP fails the login.
+	ctx, cancel := utils.WithTimeout(ctx, utils.DefaultHTTPTimeout)
+	defer cancel()
+	if err = o.ensureDiscovered(ctx); err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, o.httpClient())
+	token, err := o.exchange(ctx, code)
 	if err != nil {
-		return nil, fmt.Errorf("oidc: failed to get token: %w", err)
+		return nil, exchangeError(err)
 	}
 	rawIDToken, ok := token.Extra("id_token").(string)
 	if !ok {
 		return nil, errors.New("no id_token in token response")
 	}
+	if o.decrypter != nil {
+		if rawIDToken, err = o.decrypter.decrypt(rawIDToken); err != nil {
+			return nil, err
+		}
+	}
 	var claims jwt.MapClaims
 	if o.Verifier != nil {
 		idToken, err := o.Verifier.Verify(ctx, rawIDToken)
@@ -129,41 +410,31 @@ func (o *OIDCProvider) AuthenticateCode(code string) (idprovider.Identity, error
 			return nil, fmt.Errorf("failed to decode id token claims: %w", err)
 		}
 	} else {
-		_, _, err := new(jwt.Parser).ParseUnverified(rawIDToken, &claims)
+		idToken, _, err := new(jwt.Parser).ParseUnverified(rawIDToken, &claims)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode id token claims: %w", err)
 		}
+		if err := o.checkSigningAlg(idToken.Method.Alg()); err != nil {
+			return nil, err
+		}
 		if err := claims.Valid(); err != nil {
 			return nil, fmt.Errorf("failed to verify id token: %w", err)
 		}
 	}
+	// the id token of a login must be the answer to its own authorization request.
+	if login, ok := idprovider.LoginFromContext(ctx); ok && login.Nonce != "" {
+		if nonce := authtoken.ClaimString(claims, "nonce"); subtle.ConstantTimeCompare([]byte(nonce), []byte(login.Nonce)) != 1 {
+			return nil, errors.New("oidc: id token nonce mismatch")
+		}
+	}
 	if o.GetUserInfo {
-		if o.Provider != nil {
-			userInfo, err := o.Provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
-			if err != nil {
-				return nil, fmt.Errorf("failed to fetch userinfo: %w", err)
-			}
-			if err := userInfo.Claims(&claims); err != nil {
-				return nil, fmt.Errorf("failed to decode userinfo claims: %w", err)
-			}
-		} else {
-			resp, err := oauth2.NewClient(ctx, oauth2.StaticTokenSource(token)).Get(o.Endpoint.UserInfoURL)
-			if err != nil {
-				return nil, fmt.Errorf("failed to fetch userinfo: %w", err)
-			}
-			data, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return nil, fmt.Errorf("failed to fetch userinfo: %w", err)
-			}
-			_ = resp.Body.Close()
-			if err := json.Unmarshal(data, &claims); err != nil {
-				return nil, fmt.Errorf("failed to decode userinfo claims: %w", err)
-			}
+		if err = o.userInfo(ctx, token, &claims); err != nil {
+			return nil, err
 		}
 	}
 
-	subject, ok := claims["sub"].(string)
-	if !ok {
+	subject := authtoken.ClaimString(claims, "sub")
+	if subject == "" {
 		return nil, errors.New("missing required claim \"sub\"")
 	}
 
@@ -172,7 +443,7 @@ func (o *OIDCProvider) AuthenticateCode(code string) (idprovider.Identity, error
 	if o.EmailKey != "" {
 		emailKey = o.EmailKey
 	}
-	email, _ = claims[emailKey].(string)
+	email = authtoken.ClaimString(claims, emailKey)
 
 	var preferredUsername string
 	preferredUsernameKey := "preferred_username"
@@ -180,24 +451,159 @@ func (o *OIDCProvider) AuthenticateCode(code string) (idprovider.Identity, error
 		preferredUsernameKey = o.PreferredUsernameKey
 	}
 
-	preferredUsername, _ = claims[preferredUsernameKey].(string)
+	preferredUsername = authtoken.ClaimString(claims, preferredUsernameKey)
 	if preferredUsername == "" {
-		preferredUsername, _ = claims["name"].(string)
+		preferredUsername = authtoken.ClaimString(claims, "name")
+	}
+
+	groupsKey := idprovider.ExtraGroups
+	if o.GroupsKey != "" {
+		groupsKey = o.GroupsKey
 	}
+	groups := authtoken.ClaimFields(claims, groupsKey)
 
 	return &oidcIdentity{
 		Sub:               subject,
 		PreferredUsername: preferredUsername,
 		Email:             email,
+		Groups:            groups,
+		Issuer:            authtoken.ClaimString(claims, "iss"),
+		SessionID:         authtoken.ClaimString(claims, "sid"),
 	}, nil
 	// todo  creat in internal user.
 }
 
+// exchangeError classifies a failed code exchange: the OP rejecting the code, or the OP
+// not answering or failing with a server error, which may succeed when retried.
+func exchangeError(err error) error {
+	var rerr *oauth2.RetrieveError
+	if errors.As(err, &rerr) && rerr.Response != nil && rerr.Response.StatusCode < http.StatusInternalServerError {
+		return errs.New(errs.ErrCodeExchangeFailed, "oidc: failed to get token", err)
+	}
+	return errs.New(errs.ErrProviderUnavailable, "oidc: failed to get token", err)
+}
+
+func (o *OIDCProvider) exchange(ctx context.Context, code string) (_ *oauth2.Token, err error) {
+	ctx, span := tracing.Start(ctx, "oidc.token_exchange", tracing.String("http.url", o.Endpoint.TokenURL))
+	defer func() { tracing.End(span, err) }()
+	if login, ok := idprovider.LoginFromContext(ctx); ok && login.CodeVerifier != "" {
+		return o.OAuth2Config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", login.CodeVerifier))
+	}
+	return o.OAuth2Config.Exchange(ctx, code)
+}
+
+// userInfo merges the claims of the userinfo endpoint into claims, reusing the userinfo of the
+// subject of the id token and the access token from UserInfoCache when set.
+func (o *OIDCProvider) userInfo(ctx context.Context, token *oauth2.Token, claims *jwt.MapClaims) error {
+	var key string
+	if sub, ok := (*claims)["sub"].(string); ok && sub != "" && o.UserInfoCache != nil {
+		key = "oidc:userinfo:" + o.Issuer + "\x00" + sub + "\x00" + authtoken.HashToken(token.AccessToken)
+		if data, err := o.UserInfoCache.Get(ctx, key); err == nil && json.Unmarshal(data, claims) == nil {
+			return nil
+		}
+	}
+	data, err := o.fetchUserInfo(ctx, token)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, claims); err != nil {
+		return fmt.Errorf("failed to decode userinfo claims: %w", err)
+	}
+	if key != "" {
+		ttl := o.UserInfoCacheTTL
+		if ttl <= 0 {
+			ttl = _defaultUserInfoCacheTTL
+		}
+		if err = o.UserInfoCache.Set(ctx, key, data, ttl); err != nil {
+			o.logger.Warnf("oidc: cache userinfo %s", err)
+		}
+	}
+	return nil
+}
+
+// fetchUserInfo returns the raw claims of the userinfo endpoint.
+func (o *OIDCProvider) fetchUserInfo(ctx context.Context, token *oauth2.Token) (_ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "oidc.userinfo", tracing.String("http.url", o.Endpoint.UserInfoURL))
+	defer func() { tracing.End(span, err) }()
+	if o.Provider != nil && o.Endpoint.UserInfoURL == o.discovered.UserInfoURL {
+		userInfo, err := o.Provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch userinfo: %w", err)
+		}
+		var raw json.RawMessage
+		if err := userInfo.Claims(&raw); err != nil {
+			return nil, fmt.Errorf("failed to decode userinfo claims: %w", err)
+		}
+		return raw, nil
+	}
+	resp, err := oauth2.NewClient(ctx, oauth2.StaticTokenSource(token)).Get(o.Endpoint.UserInfoURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch userinfo: %w", err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch userinfo: %w", err)
+	}
+	_ = resp.Body.Close()
+	return data, nil
+}
+
+// checkSigningAlg rejects unsecured tokens and algorithms outside SupportedSigningAlgs.
+func (o *OIDCProvider) checkSigningAlg(alg string) error {
+	if alg == "" || alg == "none" {
+		return errors.New("oidc: unsecured id token rejected")
+	}
+	if len(o.SupportedSigningAlgs) > 0 && !utils.StringsInclude(o.SupportedSigningAlgs, alg) {
+		return fmt.Errorf("oidc: id token signed with unsupported algorithm %q", alg)
+	}
+	return nil
+}
+
 //nolint
 func (o *OIDCProvider) Authenticate(username string, password string) (idprovider.Identity, error) {
 	return nil, errors.New("unsupported authenticate with username password")
 }
 
+func (o *OIDCProvider) AuthenticateContext(ctx context.Context, username string, password string) (idprovider.Identity, error) {
+	return o.Authenticate(username, password)
+}
+
 func (o *OIDCProvider) Type() string {
 	return _oidcIdentityType
 }
+
+// Test fetches the JWKS of the OP, which checks the discovered or configured endpoints are
+// reachable and serve keys the id tokens can be verified with.
+func (o *OIDCProvider) Test(ctx context.Context) error {
+	if err := o.ensureDiscovered(ctx); err != nil {
+		return err
+	}
+	if o.Endpoint.AuthURL == "" || o.Endpoint.TokenURL == "" {
+		return errors.New("oidc: authorization and token endpoints required")
+	}
+	if o.Endpoint.JWKSURL == "" {
+		return errors.New("oidc: jwks endpoint required")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.Endpoint.JWKSURL, nil)
+	if err != nil {
+		return fmt.Errorf("oidc: jwks request %w", err)
+	}
+	resp, err := o.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("oidc: fetch jwks %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: fetch jwks: status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("oidc: decode jwks %w", err)
+	}
+	if len(set.Keys) == 0 {
+		return errors.New("oidc: jwks without keys")
+	}
+	return nil
+}
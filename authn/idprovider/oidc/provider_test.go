@@ -0,0 +1,376 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/idprovider/oidc/oidctest"
+	"github.com/tkeel-io/security/authn/session"
+	authtoken "github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/validation"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestAuthenticateCode(t *testing.T) {
+	op := oidctest.NewServer("plugin")
+	defer op.Close()
+	op.SetUserInfo(map[string]interface{}{"groups": []string{"admins"}})
+	p, err := NewOIDCProvider(context.Background(), op.Issuer, "plugin",
+		WithRedirectURL("https://rp.example/cb"), WithUserInfo(cache.NewLRU(10), 0))
+	assert.NoError(t, err)
+	assert.Contains(t, p.AuthCodeURL("s", "n"), op.Issuer+oidctest.PathAuthorize)
+
+	identity, err := p.AuthenticateCode(op.IssueCode("n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", identity.GetUserID())
+	assert.Equal(t, "user1", identity.GetUsername())
+	assert.Equal(t, "user-1@example.com", identity.GetEmail())
+	assert.Equal(t, []string{"admins"}, idprovider.Groups(identity))
+	assert.Equal(t, 1, op.Hits(oidctest.PathUserInfo))
+
+	_, err = p.AuthenticateCode("unknown")
+	assert.ErrorIs(t, err, errs.ErrCodeExchangeFailed)
+	op.Fail(oidctest.PathToken, http.StatusServiceUnavailable)
+	_, err = p.AuthenticateCode(op.IssueCode(""))
+	assert.ErrorIs(t, err, errs.ErrProviderUnavailable)
+	op.Fail(oidctest.PathToken, 0)
+
+	op.SetIDTokenTTL(-time.Minute)
+	_, err = p.AuthenticateCode(op.IssueCode(""))
+	assert.Error(t, err, "expired id tokens are rejected")
+}
+
+func TestLazyInit(t *testing.T) {
+	op := oidctest.NewServer("plugin")
+	defer op.Close()
+	op.Fail(oidctest.PathDiscovery, http.StatusBadGateway)
+	p, err := NewOIDCProvider(context.Background(), op.Issuer, "plugin", WithLazyInit())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, op.Hits(oidctest.PathDiscovery))
+
+	op.Fail(oidctest.PathDiscovery, 0)
+	identity, err := p.AuthenticateCode(op.IssueCode(""))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", identity.GetUserID())
+}
+
+func TestScopes(t *testing.T) {
+	op := oidctest.NewServer("plugin")
+	defer op.Close()
+	tests := []struct {
+		scopes []string
+		want   []string
+	}{
+		{nil, []string{"openid"}},
+		{[]string{"profile", "email"}, []string{"openid", "profile", "email"}},
+		{[]string{"profile", "openid", "email"}, []string{"profile", "openid", "email"}},
+	}
+	for _, tt := range tests {
+		p, err := NewOIDCProvider(context.Background(), op.Issuer, "plugin", WithScopes(tt.scopes...))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, p.OAuth2Config.Scopes)
+	}
+}
+
+func TestEndpointOverrides(t *testing.T) {
+	op := oidctest.NewServer("plugin")
+	defer op.Close()
+	tests := []struct {
+		name      string
+		opts      []Option
+		tokenURL  string
+		wantError error
+	}{
+		{"discovered", nil, op.Issuer + oidctest.PathToken, nil},
+		{"override", []Option{WithEndpoints("", "https://proxy.example/token", "", "")}, "https://proxy.example/token", nil},
+		{"same as discovered", []Option{WithEndpoints("", op.Issuer+oidctest.PathToken, "", ""), WithStrictEndpoints()}, op.Issuer + oidctest.PathToken, nil},
+		{"strict mismatch", []Option{WithEndpoints("", "https://proxy.example/token", "", ""), WithStrictEndpoints()}, "", idprovider.ErrInvalidConfig},
+		{"relative", []Option{WithEndpoints("", "/token", "", "")}, "", idprovider.ErrInvalidConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewOIDCProvider(context.Background(), op.Issuer, "plugin", tt.opts...)
+			if tt.wantError != nil {
+				assert.ErrorIs(t, err, tt.wantError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.tokenURL, p.OAuth2Config.Endpoint.TokenURL)
+			assert.Equal(t, op.Issuer+oidctest.PathAuthorize, p.OAuth2Config.Endpoint.AuthURL)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p := &OIDCProvider{GetUserInfo: true, RedirectURL: "callback", UserInfoCacheTTL: -time.Minute, DPoPKey: "not a key"}
+	err := p.Validate()
+	assert.ErrorIs(t, err, idprovider.ErrInvalidConfig)
+	var verr *validation.Error
+	assert.True(t, errors.As(err, &verr))
+	fields := make([]string, 0, len(verr.Problems))
+	for _, problem := range verr.Problems {
+		fields = append(fields, problem.Field)
+	}
+	assert.Equal(t, []string{"clientID", "redirectURL", "endpoint.authURL", "endpoint.tokenURL", "endpoint.userInfoURL", "userInfoCacheTTL", "dpopKey"}, fields)
+	assert.Contains(t, err.Error(), "endpoint.tokenURL: required (required when issuer discovery is disabled")
+
+	p = &OIDCProvider{ClientID: "plugin", Endpoint: endpoint{AuthURL: "https://idp.example.com/auth", TokenURL: "https://idp.example.com/token"}}
+	assert.NoError(t, p.Validate())
+}
+
+func TestFrontChannelLogout(t *testing.T) {
+	op := oidctest.NewServer("plugin")
+	defer op.Close()
+	op.SetClaims(map[string]interface{}{"sub": "user-1", "sid": "sid-1"})
+	p, err := NewOIDCProvider(context.Background(), op.Issuer, "plugin")
+	assert.NoError(t, err)
+	identity, err := p.AuthenticateCode(op.IssueCode(""))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{ExtraIssuer: op.Issuer, ExtraSessionID: "sid-1"}, identity.GetExtra())
+
+	sessions, err := session.NewManager(session.Config{Secret: "secret", Insecure: true}, nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	_, err = sessions.Login(w, httptest.NewRequest(http.MethodGet, "/", nil),
+		&authtoken.Claims{Subject: "user-1", Extra: identity.GetExtra()})
+	assert.NoError(t, err)
+	cookie := w.Result().Cookies()[0]
+
+	var revoked []string
+	logout := NewFrontChannelLogout(op.Issuer, sessions)
+	logout.SetSessionRevoker(func(_ context.Context, issuer, sid string) error {
+		revoked = append(revoked, sid)
+		return nil
+	})
+	tests := []struct {
+		name    string
+		query   url.Values
+		status  int
+		cleared bool
+	}{
+		{"other session", url.Values{"iss": {op.Issuer}, "sid": {"sid-2"}}, http.StatusOK, false},
+		{"other issuer", url.Values{"iss": {"https://other.example"}, "sid": {"sid-1"}}, http.StatusBadRequest, false},
+		{"sid without iss", url.Values{"sid": {"sid-1"}}, http.StatusBadRequest, false},
+		{"without sid", url.Values{}, http.StatusBadRequest, false},
+		{"session", url.Values{"iss": {op.Issuer}, "sid": {"sid-1"}}, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/logout/frontchannel?"+tt.query.Encode(), nil)
+			r.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			logout.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			cleared := len(w.Result().Cookies()) == 1 && w.Result().Cookies()[0].MaxAge < 0
+			assert.Equal(t, tt.cleared, cleared)
+		})
+	}
+	assert.Equal(t, []string{"sid-2", "sid-1"}, revoked)
+
+	// without sid only sessions without a recorded sid are ended.
+	logout.AllowMissingSessionID()
+	for _, extra := range []map[string]interface{}{identity.GetExtra(), {ExtraIssuer: op.Issuer}} {
+		w = httptest.NewRecorder()
+		_, err = sessions.Login(w, httptest.NewRequest(http.MethodGet, "/", nil), &authtoken.Claims{Subject: "user-1", Extra: extra})
+		assert.NoError(t, err)
+		r := httptest.NewRequest(http.MethodGet, "/logout/frontchannel", nil)
+		r.AddCookie(w.Result().Cookies()[0])
+		w = httptest.NewRecorder()
+		logout.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		cleared := len(w.Result().Cookies()) == 1 && w.Result().Cookies()[0].MaxAge < 0
+		assert.Equal(t, extra[ExtraSessionID] == nil, cleared)
+	}
+}
+
+func TestIDTokenEncryption(t *testing.T) {
+	op := oidctest.NewServer("plugin")
+	defer op.Close()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		key       interface{}
+		alg       jose.KeyAlgorithm
+		enc       jose.ContentEncryption
+		clientKey interface{}
+		opts      []string
+		wantError bool
+	}{
+		{"rsa defaults", &rsaKey.PublicKey, jose.RSA_OAEP, jose.A128CBC_HS256, rsaKey, []string{"", ""}, false},
+		{"ec", &ecKey.PublicKey, jose.ECDH_ES_A256KW, jose.A256GCM, ecKey, []string{"ECDH-ES+A256KW", "A256GCM"}, false},
+		{"unexpected enc", &rsaKey.PublicKey, jose.RSA_OAEP, jose.A256GCM, rsaKey, []string{"", ""}, true},
+		{"other key", &otherKey.PublicKey, jose.RSA_OAEP, jose.A128CBC_HS256, rsaKey, []string{"", ""}, true},
+		{"plain id token", nil, "", "", rsaKey, []string{"", ""}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, op.SetIDTokenEncryption(tt.key, tt.alg, tt.enc))
+			der, err := x509.MarshalPKCS8PrivateKey(tt.clientKey)
+			assert.NoError(t, err)
+			pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+			p, err := NewOIDCProvider(context.Background(), op.Issuer, "plugin", WithIDTokenEncryption(pemKey, tt.opts[0], tt.opts[1]))
+			assert.NoError(t, err)
+			identity, err := p.AuthenticateCode(op.IssueCode(""))
+			if tt.wantError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "user-1", identity.GetUserID())
+		})
+	}
+
+	_, err = NewOIDCProvider(context.Background(), op.Issuer, "plugin", WithIDTokenEncryption("not a key", "", ""))
+	assert.ErrorIs(t, err, idprovider.ErrInvalidConfig)
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	assert.NoError(t, err)
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	_, err = NewOIDCProvider(context.Background(), op.Issuer, "plugin", WithIDTokenEncryption(pemKey, string(jose.RSA1_5), ""))
+	assert.ErrorIs(t, err, idprovider.ErrInvalidConfig)
+}
+
+type subjectRevokerFunc func(tenantID, subject string) error
+
+func (f subjectRevokerFunc) RevokeSubject(tenantID, subject string) error {
+	return f(tenantID, subject)
+}
+
+func TestEventReceiver(t *testing.T) {
+	op := oidctest.NewServer("plugin")
+	defer op.Close()
+	other := oidctest.NewServer("plugin")
+	defer other.Close()
+	p, err := NewOIDCProvider(context.Background(), op.Issuer, "plugin")
+	assert.NoError(t, err)
+	keys, err := p.KeySet(context.Background())
+	assert.NoError(t, err)
+
+	var revoked []string
+	_, err = NewEventReceiver(EventReceiverConfig{Issuer: op.Issuer, Audience: "plugin"}, keys)
+	assert.Error(t, err, "tenant required")
+	receiver, err := NewEventReceiver(EventReceiverConfig{TenantID: "t1", Issuer: op.Issuer, Audience: "plugin"}, keys,
+		subjectRevokerFunc(func(tenantID, subject string) error {
+			revoked = append(revoked, "subject "+tenantID+"/"+subject)
+			return nil
+		}))
+	assert.NoError(t, err)
+	receiver.SetSessionRevoker(func(_ context.Context, issuer, sid string) error {
+		revoked = append(revoked, "session "+sid)
+		return nil
+	})
+
+	user := map[string]interface{}{"format": "iss_sub", "iss": op.Issuer, "sub": "user-1"}
+	idToken, err := op.IDToken("")
+	assert.NoError(t, err)
+	forged, err := other.SecurityEvent("plugin", map[string]interface{}{EventCredentialChange: map[string]interface{}{}},
+		map[string]interface{}{"iss": op.Issuer, "sub_id": user})
+	assert.NoError(t, err)
+	event := func(aud, typ string, payload, claims map[string]interface{}) string {
+		set, err := op.SecurityEvent(aud, map[string]interface{}{typ: payload}, claims)
+		assert.NoError(t, err)
+		return set
+	}
+	replayed := event("plugin", EventCredentialChange, map[string]interface{}{}, map[string]interface{}{"sub_id": user})
+	tests := []struct {
+		name    string
+		set     string
+		status  int
+		err     string
+		revoked []string
+	}{
+		{"session revoked", event("plugin", EventSessionRevoked, map[string]interface{}{
+			"subject": map[string]interface{}{"format": "complex", "user": user, "session": map[string]interface{}{"format": "opaque", "id": "sid-1"}},
+		}, nil), http.StatusAccepted, "", []string{"session sid-1"}},
+		{"session revoked without session", event("plugin", EventSessionRevoked, map[string]interface{}{},
+			map[string]interface{}{"sub_id": user}), http.StatusAccepted, "", []string{"subject t1/user-1"}},
+		{"credential change", event("plugin", EventCredentialChange, map[string]interface{}{"change_type": "update", "subject": user},
+			nil), http.StatusAccepted, "", []string{"subject t1/user-1"}},
+		{"first delivery", replayed, http.StatusAccepted, "", []string{"subject t1/user-1"}},
+		{"replayed", replayed, http.StatusBadRequest, "invalid_request", nil},
+		{"verification", event("plugin", EventVerification, map[string]interface{}{"state": "x"}, nil), http.StatusAccepted, "", nil},
+		{"unknown subject format", event("plugin", EventCredentialChange, map[string]interface{}{},
+			map[string]interface{}{"sub_id": map[string]interface{}{"format": "email", "email": "user-1@example.com"}}), http.StatusBadRequest, "invalid_request", nil},
+		{"subject of other issuer", event("plugin", EventCredentialChange, map[string]interface{}{},
+			map[string]interface{}{"sub_id": map[string]interface{}{"format": "iss_sub", "iss": other.Issuer, "sub": "user-1"}}), http.StatusBadRequest, "invalid_request", nil},
+		{"other audience", event("other", EventCredentialChange, map[string]interface{}{}, map[string]interface{}{"sub_id": user}),
+			http.StatusBadRequest, "invalid_audience", nil},
+		{"too old", event("plugin", EventCredentialChange, map[string]interface{}{},
+			map[string]interface{}{"sub_id": user, "iat": time.Now().Add(-2 * time.Hour).Unix()}), http.StatusBadRequest, "invalid_request", nil},
+		{"forged", forged, http.StatusBadRequest, "invalid_key", nil},
+		{"id token", idToken, http.StatusBadRequest, "invalid_request", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked = nil
+			r := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(tt.set))
+			r.Header.Set("Content-Type", "application/secevent+jwt")
+			w := httptest.NewRecorder()
+			receiver.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			if tt.err != "" {
+				var body map[string]string
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+				assert.Equal(t, tt.err, body["err"])
+			}
+			assert.Equal(t, tt.revoked, revoked)
+		})
+	}
+
+	receiver.SetSubjectResolver(func(_ context.Context, id *SubjectID) (string, error) {
+		if id.Format == "email" && id.Email == "user-1@example.com" {
+			return "user-1", nil
+		}
+		return "", ErrUnknownSubject
+	})
+	revoked = nil
+	assert.NoError(t, receiver.Receive(context.Background(), event("plugin", EventCredentialChange, map[string]interface{}{},
+		map[string]interface{}{"sub_id": map[string]interface{}{"format": "email", "email": "user-1@example.com"}})))
+	assert.Equal(t, []string{"subject t1/user-1"}, revoked)
+
+	// a failed delivery can be retried.
+	failing, err := NewEventReceiver(EventReceiverConfig{TenantID: "t1", Issuer: op.Issuer, Audience: "plugin"}, keys,
+		subjectRevokerFunc(func(tenantID, subject string) error {
+			return errors.New("store unavailable")
+		}))
+	assert.NoError(t, err)
+	set := event("plugin", EventCredentialChange, map[string]interface{}{}, map[string]interface{}{"sub_id": user})
+	assert.Error(t, failing.Receive(context.Background(), set))
+	assert.NotErrorIs(t, failing.Receive(context.Background(), set), ErrEventReplayed)
+}
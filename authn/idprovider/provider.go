@@ -13,6 +13,12 @@ limitations under the License.
 
 package idprovider
 
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
 type Provider interface {
 	// Type unique type of the provider.
 	Type() string
@@ -23,6 +29,81 @@ type Provider interface {
 	AuthCodeURL(state, nonce string) string
 }
 
+// Tester is a Provider able to check its configuration against the live upstream, e.g. by
+// fetching the keys of an OP or binding to an LDAP server.
+type Tester interface {
+	Test(ctx context.Context) error
+}
+
+// ContextProvider is a Provider taking the context of the login, so its calls to the upstream
+// are cancelled with the request and traced as its children.
+type ContextProvider interface {
+	AuthenticateCodeContext(ctx context.Context, code string) (Identity, error)
+	AuthenticateContext(ctx context.Context, username string, password string) (Identity, error)
+}
+
+// PKCEProvider is a Provider protecting its authorization codes with PKCE, see
+// https://www.rfc-editor.org/rfc/rfc7636.
+type PKCEProvider interface {
+	// AuthCodeURLPKCE is AuthCodeURL with the S256 challenge of verifier, AuthenticateCode must
+	// then be called with the verifier in the Login of the context.
+	AuthCodeURLPKCE(state, nonce, verifier string) string
+}
+
+// Login the secrets of an authorization request the relying party keeps from the redirect to
+// the callback, the code exchange must match them.
+type Login struct {
+	// Nonce passed to AuthCodeURL, the id token must carry it.
+	Nonce string
+	// CodeVerifier passed to AuthCodeURLPKCE.
+	CodeVerifier string
+}
+
+type loginContextKey struct{}
+
+// WithLogin returns a context for AuthenticateCode carrying login, providers supporting them
+// check the nonce and send the code verifier.
+func WithLogin(ctx context.Context, login *Login) context.Context {
+	return context.WithValue(ctx, loginContextKey{}, login)
+}
+
+// LoginFromContext returns the Login of WithLogin.
+func LoginFromContext(ctx context.Context) (*Login, bool) {
+	login, ok := ctx.Value(loginContextKey{}).(*Login)
+	return login, ok && login != nil
+}
+
+// AuthCodeURL returns the login url of p, protected by the S256 challenge of verifier when p is
+// a PKCEProvider and verifier is not empty.
+func AuthCodeURL(p Provider, state, nonce, verifier string) string {
+	if pp, ok := p.(PKCEProvider); ok && verifier != "" {
+		return pp.AuthCodeURLPKCE(state, nonce, verifier)
+	}
+	return p.AuthCodeURL(state, nonce)
+}
+
+// CodeChallenge returns the S256 challenge of verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthenticateCode authenticates code with p, passing ctx when p is a ContextProvider.
+func AuthenticateCode(ctx context.Context, p Provider, code string) (Identity, error) {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.AuthenticateCodeContext(ctx, code)
+	}
+	return p.AuthenticateCode(code)
+}
+
+// Authenticate authenticates username and password with p, passing ctx when p is a ContextProvider.
+func Authenticate(ctx context.Context, p Provider, username string, password string) (Identity, error) {
+	if cp, ok := p.(ContextProvider); ok {
+		return cp.AuthenticateContext(ctx, username, password)
+	}
+	return p.Authenticate(username, password)
+}
+
 type ProviderFactory interface {
 	// Type unique type of the provider.
 	Type() string
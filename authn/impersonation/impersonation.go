@@ -0,0 +1,315 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package impersonation lets privileged operators act as another user for support and
+// debugging. Impersonation tokens name the operator in the act claim, carry the mandatory
+// reason, live shortly and every issuance, refusal and request made with them is audited.
+package impersonation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/model"
+	"github.com/tkeel-io/security/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	_defaultTTL      = 15 * time.Minute
+	_defaultMaxTTL   = time.Hour
+	_defaultResource = "impersonation"
+	_defaultAction   = "create"
+
+	// EventStarted an impersonation token was issued.
+	EventStarted = "impersonation.started"
+	// EventDenied an impersonation was refused.
+	EventDenied = "impersonation.denied"
+	// EventFailed an allowed impersonation failed, e.g. the token could not be issued.
+	EventFailed = "impersonation.failed"
+	// EventRequest a request was made with an impersonation token.
+	EventRequest = "impersonation.request"
+
+	// ReasonClaim the extra claim carrying the reason.
+	ReasonClaim = "impersonation_reason"
+)
+
+var (
+	// ErrReasonRequired the impersonation has no reason.
+	ErrReasonRequired = errors.New("impersonation reason required")
+	// ErrTTLTooLong the impersonation would last longer than allowed.
+	ErrTTLTooLong = errors.New("impersonation ttl too long")
+	// ErrNotAllowed the operator may not impersonate in the tenant.
+	ErrNotAllowed = errors.New("impersonation not allowed")
+	// ErrInvalidTarget the target is missing, unknown, the operator themself or the operator is
+	// impersonating already.
+	ErrInvalidTarget = errors.New("invalid impersonation target")
+)
+
+// Config of the impersonation.
+type Config struct {
+	// TTL of tokens requested without one. Default to 15m.
+	TTL time.Duration `mapstructure:"ttl" json:"ttl" yaml:"ttl"`
+	// MaxTTL longest impersonation allowed. Default to 1h.
+	MaxTTL time.Duration `mapstructure:"max_ttl" json:"max_ttl" yaml:"maxTtl"`
+	// Resource and Action the operator needs in the target tenant. Default to impersonation/create.
+	Resource string `mapstructure:"resource" json:"resource" yaml:"resource"`
+	Action   string `mapstructure:"action" json:"action" yaml:"action"`
+}
+
+// Request of an impersonation.
+type Request struct {
+	Subject  string `json:"subject"`
+	TenantID string `json:"tenant_id"`
+	// Scope of the token, empty for none. Scopes the operator does not hold are dropped.
+	Scope  string `json:"scope"`
+	Reason string `json:"reason"`
+	// TTL zero for the configured TTL.
+	TTL time.Duration `json:"ttl"`
+}
+
+// UsernameLoader returns the username of the user subject in the tenant, or ErrInvalidTarget
+// when there is no such user.
+type UsernameLoader func(ctx context.Context, tenantID, subject string) (string, error)
+
+// NewUsernameLoader returns a UsernameLoader reading the users of db.
+func NewUsernameLoader(db *gorm.DB) UsernameLoader {
+	return func(ctx context.Context, tenantID, subject string) (string, error) {
+		user := &model.User{}
+		err := db.WithContext(ctx).Model(user).Where("id = ? and tenant_id = ?", subject, tenantID).First(user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("%w: unknown user %s", ErrInvalidTarget, subject)
+		}
+		if err != nil {
+			return "", fmt.Errorf("load user %s %w", subject, err)
+		}
+		return user.UserName, nil
+	}
+}
+
+// Manager issues impersonation tokens.
+type Manager struct {
+	conf    Config
+	issuer  token.Issuer
+	checker authorizer.Checker
+	users   UsernameLoader
+	sink    audit.EventSink
+	logger  *log.Helper
+}
+
+// NewManager returns a Manager issuing tokens with issuer to operators checker allows, the
+// username of the target is read with users, events go to sink.
+func NewManager(conf Config, issuer token.Issuer, checker authorizer.Checker, users UsernameLoader, sink audit.EventSink) *Manager {
+	if conf.TTL <= 0 {
+		conf.TTL = _defaultTTL
+	}
+	if conf.MaxTTL <= 0 {
+		conf.MaxTTL = _defaultMaxTTL
+	}
+	if conf.Resource == "" {
+		conf.Resource = _defaultResource
+	}
+	if conf.Action == "" {
+		conf.Action = _defaultAction
+	}
+	return &Manager{conf: conf, issuer: issuer, checker: checker, users: users, sink: sink}
+}
+
+// SetLogger writes the entries of the impersonation handler to l instead of the global logger.
+func (m *Manager) SetLogger(l log.Logger) {
+	m.logger = log.NewHelper(l)
+}
+
+// Impersonate returns a token of the requested subject acting on behalf of the operator,
+// limited to the scopes the operator holds.
+func (m *Manager) Impersonate(ctx context.Context, operator *token.Claims, req *Request) (string, *token.Claims, error) {
+	if err := m.check(operator, req); err != nil {
+		m.event(ctx, EventDenied, operator, req, map[string]interface{}{"error": err.Error()})
+		return "", nil, err
+	}
+	username, err := m.users(ctx, req.TenantID, req.Subject)
+	if err != nil {
+		m.event(ctx, EventDenied, operator, req, map[string]interface{}{"error": err.Error()})
+		return "", nil, err
+	}
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = m.conf.TTL
+	}
+	now := time.Now()
+	claims := &token.Claims{
+		Subject:   req.Subject,
+		TenantID:  req.TenantID,
+		Username:  username,
+		Scope:     narrowScope(req.Scope, operator.Scope),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		Actor:     &token.Actor{Subject: operator.Subject, TenantID: operator.TenantID},
+		Extra:     map[string]interface{}{ReasonClaim: req.Reason},
+	}
+	raw, err := m.issuer.Issue(claims)
+	if err != nil {
+		err = fmt.Errorf("issue impersonation token %w", err)
+		m.event(ctx, EventFailed, operator, req, map[string]interface{}{"error": err.Error()})
+		return "", nil, err
+	}
+	m.event(ctx, EventStarted, operator, req, map[string]interface{}{
+		"jti":        claims.ID,
+		"expires_at": claims.ExpiresAt,
+		"scope":      claims.Scope,
+	})
+	return raw, claims, nil
+}
+
+// narrowScope returns the scopes of requested that held includes.
+func narrowScope(requested, held string) string {
+	heldScopes := strings.Fields(held)
+	scopes := make([]string, 0)
+	for _, scope := range strings.Fields(requested) {
+		if utils.StringsInclude(heldScopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+func (m *Manager) check(operator *token.Claims, req *Request) error {
+	req.Reason = strings.TrimSpace(req.Reason)
+	switch {
+	case req.Reason == "":
+		return ErrReasonRequired
+	case req.TTL < 0 || req.TTL > m.conf.MaxTTL:
+		return fmt.Errorf("%w: at most %s", ErrTTLTooLong, m.conf.MaxTTL)
+	case req.Subject == "" || req.TenantID == "":
+		return fmt.Errorf("%w: subject and tenant required", ErrInvalidTarget)
+	case operator.Actor != nil:
+		return fmt.Errorf("%w: already impersonating", ErrInvalidTarget)
+	case operator.Subject == req.Subject && operator.TenantID == req.TenantID:
+		return fmt.Errorf("%w: impersonating oneself", ErrInvalidTarget)
+	}
+	allowed, err := m.checker.Check(operator.Subject, req.TenantID, m.conf.Resource, m.conf.Action)
+	if err != nil {
+		return fmt.Errorf("check impersonation permission %w", err)
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s in %s", ErrNotAllowed, operator.Subject, req.TenantID)
+	}
+	return nil
+}
+
+func (m *Manager) event(ctx context.Context, typ string, operator *token.Claims, req *Request, detail map[string]interface{}) {
+	detail["actor_tenant_id"] = operator.TenantID
+	audit.WriteEvent(ctx, m.sink, &audit.Event{
+		Time:     time.Now(),
+		Type:     typ,
+		Actor:    operator.Subject,
+		Subject:  req.Subject,
+		TenantID: req.TenantID,
+		Reason:   req.Reason,
+		Detail:   detail,
+	})
+}
+
+type handlerRequest struct {
+	Subject    string `json:"subject"`
+	TenantID   string `json:"tenant_id"`
+	Scope      string `json:"scope"`
+	Reason     string `json:"reason"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
+
+type handlerResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// Handler serves impersonation requests posted as JSON by the operator the request
+// authenticated as, mount it behind the authentication middleware.
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operator, ok := middleware.ClaimsFromContext(r.Context())
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		body := &handlerRequest{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(body); err != nil {
+			http.Error(w, "malformed impersonation request", http.StatusBadRequest)
+			return
+		}
+		raw, claims, err := m.Impersonate(r.Context(), operator, &Request{
+			Subject:  body.Subject,
+			TenantID: body.TenantID,
+			Scope:    body.Scope,
+			Reason:   body.Reason,
+			TTL:      time.Duration(body.TTLSeconds) * time.Second,
+		})
+		switch {
+		case errors.Is(err, ErrNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, ErrReasonRequired), errors.Is(err, ErrTTLTooLong), errors.Is(err, ErrInvalidTarget):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			m.logger.Errorf("impersonate %s: %s", body.Subject, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(&handlerResponse{
+			AccessToken: raw,
+			TokenType:   "Bearer",
+			ExpiresIn:   claims.ExpiresAt - claims.IssuedAt,
+		})
+	})
+}
+
+// AuditMiddleware records every request made with an impersonation token to sink, it must run
+// after authentication.
+func AuditMiddleware(sink audit.EventSink) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims, ok := middleware.ClaimsFromContext(r.Context()); ok && claims.Actor != nil {
+				reason := token.ClaimString(claims.Extra, ReasonClaim)
+				sink.WriteEvent(&audit.Event{
+					Time:     time.Now(),
+					Type:     EventRequest,
+					Actor:    claims.Actor.Subject,
+					Subject:  claims.Subject,
+					TenantID: claims.TenantID,
+					Reason:   reason,
+					Detail:   map[string]interface{}{"method": r.Method, "path": r.URL.Path, "jti": claims.ID},
+				})
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
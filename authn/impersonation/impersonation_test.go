@@ -0,0 +1,185 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package impersonation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type checkerFunc func(subject, tenantID, resource, action string) (bool, error)
+
+func (f checkerFunc) Check(subject, tenantID, resource, action string) (bool, error) {
+	return f(subject, tenantID, resource, action)
+}
+
+type issuerFunc func(claims *token.Claims) (string, error)
+
+func (f issuerFunc) Issue(claims *token.Claims) (string, error) {
+	return f(claims)
+}
+
+// contextSink records the events with the context they were written with.
+type contextSink struct {
+	*audit.MemorySink
+	ctxs []context.Context
+}
+
+func (s *contextSink) WriteEventContext(ctx context.Context, e *audit.Event) {
+	s.ctxs = append(s.ctxs, ctx)
+	s.WriteEvent(e)
+}
+
+type ctxKey struct{}
+
+// users knows usr-1 and usr-2 of t1.
+func users(_ context.Context, tenantID, subject string) (string, error) {
+	usernames := map[string]string{"usr-1": "alice", "usr-2": "bob"}
+	if username, ok := usernames[subject]; ok && tenantID == "t1" {
+		return username, nil
+	}
+	return "", ErrInvalidTarget
+}
+
+func TestImpersonate(t *testing.T) {
+	tokens, err := token.NewJWTManager(&token.Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	sink := audit.NewMemorySink(10)
+	m := NewManager(Config{}, tokens, checkerFunc(func(subject, tenantID, resource, action string) (bool, error) {
+		return subject == "ops" && tenantID == "t1" && resource == "impersonation" && action == "create", nil
+	}), users, sink)
+	operator := &token.Claims{Subject: "ops", TenantID: "sys", Scope: "devices:read users:read"}
+
+	raw, claims, err := m.Impersonate(context.Background(), operator,
+		&Request{Subject: "usr-1", TenantID: "t1", Scope: "devices:read devices:write", Reason: " ticket 42 "})
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(_defaultTTL), time.Unix(claims.ExpiresAt, 0), time.Minute)
+	verified, err := tokens.Verify(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "usr-1", verified.Subject)
+	assert.Equal(t, "alice", verified.Username)
+	// the operator can not grant a scope it does not hold.
+	assert.Equal(t, "devices:read", verified.Scope)
+	assert.Equal(t, &token.Actor{Subject: "ops", TenantID: "sys"}, verified.Actor)
+	assert.Equal(t, "ticket 42", verified.Extra[ReasonClaim])
+
+	tests := []struct {
+		name     string
+		operator *token.Claims
+		req      Request
+		err      error
+	}{
+		{"reason", operator, Request{Subject: "usr-1", TenantID: "t1"}, ErrReasonRequired},
+		{"ttl", operator, Request{Subject: "usr-1", TenantID: "t1", Reason: "r", TTL: 2 * time.Hour}, ErrTTLTooLong},
+		{"target", operator, Request{TenantID: "t1", Reason: "r"}, ErrInvalidTarget},
+		{"nested", verified, Request{Subject: "usr-2", TenantID: "t1", Reason: "r"}, ErrInvalidTarget},
+		{"tenant", operator, Request{Subject: "usr-1", TenantID: "t2", Reason: "r"}, ErrNotAllowed},
+		{"unknown", operator, Request{Subject: "usr-3", TenantID: "t1", Reason: "r"}, ErrInvalidTarget},
+	}
+	for _, tt := range tests {
+		_, _, err := m.Impersonate(context.Background(), tt.operator, &tt.req)
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+
+	events := sink.Events()
+	assert.Len(t, events, 1+len(tests))
+	assert.Equal(t, EventStarted, events[0].Type)
+	assert.Equal(t, "ops", events[0].Actor)
+	assert.Equal(t, "usr-1", events[0].Subject)
+	assert.Equal(t, "ticket 42", events[0].Reason)
+	for _, e := range events[1:] {
+		assert.Equal(t, EventDenied, e.Type)
+	}
+}
+
+func TestIssueFailure(t *testing.T) {
+	sink := &contextSink{MemorySink: audit.NewMemorySink(10)}
+	var loaded context.Context
+	loader := func(ctx context.Context, tenantID, subject string) (string, error) {
+		loaded = ctx
+		return users(ctx, tenantID, subject)
+	}
+	m := NewManager(Config{}, issuerFunc(func(*token.Claims) (string, error) {
+		return "", errors.New("signing key unavailable")
+	}), checkerFunc(func(subject, tenantID, resource, action string) (bool, error) {
+		return true, nil
+	}), loader, sink)
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request-1")
+	_, _, err := m.Impersonate(ctx, &token.Claims{Subject: "ops", TenantID: "sys"},
+		&Request{Subject: "usr-1", TenantID: "t1", Reason: "ticket 42"})
+	assert.Error(t, err)
+	// the failed issuance is audited and ctx reaches the loader and the sink.
+	events := sink.Events()
+	assert.Len(t, events, 1)
+	assert.Equal(t, EventFailed, events[0].Type)
+	assert.Contains(t, events[0].Detail["error"], "signing key unavailable")
+	assert.Equal(t, "request-1", loaded.Value(ctxKey{}))
+	assert.Len(t, sink.ctxs, 1)
+	assert.Equal(t, "request-1", sink.ctxs[0].Value(ctxKey{}))
+}
+
+func TestHandler(t *testing.T) {
+	tokens, err := token.NewJWTManager(&token.Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	sink := audit.NewMemorySink(10)
+	m := NewManager(Config{}, tokens, checkerFunc(func(subject, tenantID, resource, action string) (bool, error) {
+		return subject == "ops", nil
+	}), users, sink)
+	a := middleware.NewAuthenticator(tokens, middleware.Config{})
+	ops, err := tokens.Issue(&token.Claims{Subject: "ops", TenantID: "sys"})
+	assert.NoError(t, err)
+	user, err := tokens.Issue(&token.Claims{Subject: "usr-9", TenantID: "t1"})
+	assert.NoError(t, err)
+
+	post := func(bearer, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/impersonate", bytes.NewBufferString(body))
+		r.Header.Set("Authorization", "Bearer "+bearer)
+		w := httptest.NewRecorder()
+		a.Middleware(m.Handler()).ServeHTTP(w, r)
+		return w
+	}
+	assert.Equal(t, http.StatusBadRequest, post(ops, `{"subject":"usr-1","tenant_id":"t1"}`).Code)
+	assert.Equal(t, http.StatusForbidden, post(user, `{"subject":"usr-1","tenant_id":"t1","reason":"r"}`).Code)
+	w := post(ops, `{"subject":"usr-1","tenant_id":"t1","reason":"debug","ttl_seconds":60}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := &handlerResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+	assert.Equal(t, int64(60), resp.ExpiresIn)
+
+	// requests made with the token are audited.
+	h := a.Middleware(AuditMiddleware(sink)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	r := httptest.NewRequest(http.MethodDelete, "/v1/devices/dev-1", nil)
+	r.Header.Set("Authorization", "Bearer "+resp.AccessToken)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	events := sink.Events()
+	last := events[len(events)-1]
+	assert.Equal(t, EventRequest, last.Type)
+	assert.Equal(t, "ops", last.Actor)
+	assert.Equal(t, "/v1/devices/dev-1", last.Detail["path"])
+}
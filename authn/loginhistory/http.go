@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loginhistory
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/model"
+)
+
+type listResponse struct {
+	Total    int64     `json:"total"`
+	PageNum  int       `json:"page_num"`
+	PageSize int       `json:"page_size"`
+	Records  []*Record `json:"records"`
+}
+
+// Handler serves the login history of the subject the request authenticated as, for recent
+// activity pages. ?page_num= and ?page_size= select the page.
+func (h *History) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, middleware.SubjectFromContext(r.Context()))
+	})
+}
+
+// AdminHandler serves the login history of the ?subject= in the tenant the request
+// authenticated as, for incident response. Mount it behind the authorization middleware.
+func (h *History) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject := r.URL.Query().Get("subject")
+		if subject == "" {
+			http.Error(w, "subject required", http.StatusBadRequest)
+			return
+		}
+		h.serve(w, r, subject)
+	})
+}
+
+func (h *History) serve(w http.ResponseWriter, r *http.Request, subject string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	tenantID := middleware.TenantFromContext(r.Context())
+	if subject == "" || tenantID == "" {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	page := &model.Page{}
+	page.PageNum, _ = strconv.Atoi(r.URL.Query().Get("page_num"))
+	page.PageSize, _ = strconv.Atoi(r.URL.Query().Get("page_size"))
+	page = normalizePage(page)
+	total, records, err := h.List(tenantID, subject, page)
+	if err != nil {
+		h.logger.Errorf("login history of %s: %s", subject, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(&listResponse{Total: total, PageNum: page.PageNum, PageSize: page.PageSize, Records: records})
+}
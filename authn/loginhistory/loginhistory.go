@@ -0,0 +1,198 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package loginhistory keeps the login attempts of subjects, for "recent activity" pages and
+// incident response. The History records the authn.login audit events it is given as sink,
+// e.g. by the authorization server or the session manager.
+package loginhistory
+
+import (
+	"context"
+	"time"
+
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/model"
+)
+
+const (
+	_defaultRetention     = 90 * 24 * time.Hour
+	_defaultPurgeInterval = time.Hour
+	_defaultPageSize      = 20
+	_maxPageSize          = 100
+)
+
+var (
+	_ audit.EventSink = &History{}
+	_ audit.Publisher = &History{}
+)
+
+// Record a login attempt.
+type Record struct {
+	ID       uint64 `json:"id"`
+	TenantID string `json:"tenant_id"`
+	Subject  string `json:"subject"`
+	// Provider key of the identity provider, empty for logins it did not record.
+	Provider  string `json:"provider"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Success   bool   `json:"success"`
+	// Reason the attempt failed.
+	Reason string `json:"reason,omitempty"`
+	// MFA the attempt presented a second factor.
+	MFA  bool      `json:"mfa"`
+	Time time.Time `json:"time"`
+}
+
+// Store persists records.
+type Store interface {
+	// Add stores r and sets its ID.
+	Add(r *Record) error
+	// List returns a page of the records of subject in tenantID newest first, with their total.
+	List(tenantID, subject string, page *model.Page) (int64, []*Record, error)
+	// Purge deletes the records older than before.
+	Purge(before time.Time) (int64, error)
+}
+
+// Config of the History.
+type Config struct {
+	// Retention how long records are kept. Default to 90 days.
+	Retention time.Duration `mapstructure:"retention" json:"retention" yaml:"retention"`
+	// PurgeInterval how often records past retention are deleted once started. Default to 1h.
+	PurgeInterval time.Duration `mapstructure:"purge_interval" json:"purge_interval" yaml:"purgeInterval"`
+}
+
+// History records login events and answers queries on them.
+type History struct {
+	conf   Config
+	store  Store
+	cancel context.CancelFunc
+	logger *log.Helper
+}
+
+func New(conf Config, store Store) *History {
+	if conf.Retention <= 0 {
+		conf.Retention = _defaultRetention
+	}
+	if conf.PurgeInterval <= 0 {
+		conf.PurgeInterval = _defaultPurgeInterval
+	}
+	return &History{conf: conf, store: store}
+}
+
+// SetLogger writes the entries of the login history to l instead of the global logger.
+func (h *History) SetLogger(l log.Logger) {
+	h.logger = log.NewHelper(l)
+}
+
+// WriteEvent records e when it is a login, other events are ignored.
+func (h *History) WriteEvent(e *audit.Event) {
+	if err := h.record(e); err != nil {
+		h.logger.Errorf("login history record %s: %s", e.Subject, err)
+	}
+}
+
+// Write ignores authorization decisions.
+func (h *History) Write(*audit.Decision) {}
+
+// Publish records the logins among events, so the History can sit behind an audit.BufferedSink.
+func (h *History) Publish(_ context.Context, events []*audit.Event) error {
+	for _, e := range events {
+		if err := h.record(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *History) record(e *audit.Event) error {
+	if e.Type != audit.EventLogin || e.Subject == "" {
+		return nil
+	}
+	r := &Record{
+		TenantID:  e.TenantID,
+		Subject:   e.Subject,
+		IP:        e.IP,
+		UserAgent: e.UserAgent,
+		Success:   e.Outcome == "" || e.Outcome == audit.OutcomeSuccess,
+		Reason:    e.Reason,
+		Time:      e.Time,
+	}
+	if provider, ok := e.Detail["provider"].(string); ok {
+		r.Provider = provider
+	}
+	if mfa, ok := e.Detail["mfa"].(bool); ok {
+		r.MFA = mfa
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	return h.store.Add(r)
+}
+
+// List returns a page of the records of subject in tenantID newest first, with their total.
+// The page size defaults to 20 and is capped at 100.
+func (h *History) List(tenantID, subject string, page *model.Page) (int64, []*Record, error) {
+	return h.store.List(tenantID, subject, normalizePage(page))
+}
+
+// normalizePage returns the page numbered from 1 with the default and capped size.
+func normalizePage(page *model.Page) *model.Page {
+	p := &model.Page{PageNum: 1, PageSize: _defaultPageSize}
+	if page != nil {
+		if page.PageNum > 0 {
+			p.PageNum = page.PageNum
+		}
+		if page.PageSize > 0 {
+			p.PageSize = page.PageSize
+		}
+	}
+	if p.PageSize > _maxPageSize {
+		p.PageSize = _maxPageSize
+	}
+	return p
+}
+
+// Purge deletes the records past retention.
+func (h *History) Purge() (int64, error) {
+	return h.store.Purge(time.Now().Add(-h.conf.Retention))
+}
+
+// Start purges the records past retention on schedule until Stop is called.
+func (h *History) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	go func() {
+		ticker := time.NewTicker(h.conf.PurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if n, err := h.Purge(); err != nil {
+					h.logger.Errorf("login history purge %s", err)
+				} else if n > 0 {
+					h.logger.Debugf("login history purged %d records", n)
+				}
+			}
+		}
+	}()
+}
+
+// Stop the scheduled purge.
+func (h *History) Stop() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+}
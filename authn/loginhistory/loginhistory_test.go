@@ -0,0 +1,119 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loginhistory
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/model"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func login(subject string, at time.Time, outcome string, mfa bool) *audit.Event {
+	return &audit.Event{
+		Time: at, Type: audit.EventLogin, Subject: subject, TenantID: "t1", Outcome: outcome,
+		IP: "10.0.0.1", UserAgent: "firefox", Detail: map[string]interface{}{"provider": "ldap", "mfa": mfa},
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormStore(db)
+	assert.NoError(t, err)
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			h := New(Config{Retention: 24 * time.Hour}, store)
+			now := time.Now().Truncate(time.Second)
+			h.WriteEvent(login("alice", now.Add(-48*time.Hour), audit.OutcomeSuccess, false))
+			h.WriteEvent(login("alice", now.Add(-2*time.Minute), audit.OutcomeFailure, false))
+			h.WriteEvent(login("alice", now.Add(-time.Minute), audit.OutcomeSuccess, true))
+			h.WriteEvent(login("bob", now, audit.OutcomeSuccess, false))
+			h.WriteEvent(&audit.Event{Type: audit.EventLogout, Subject: "alice", TenantID: "t1", Time: now})
+			assert.NoError(t, h.Publish(context.Background(), []*audit.Event{login("alice", now, audit.OutcomeDenied, false)}))
+
+			total, records, err := h.List("t1", "alice", &model.Page{PageNum: 1, PageSize: 2})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(4), total)
+			assert.Len(t, records, 2)
+			assert.False(t, records[0].Success)
+			assert.True(t, records[1].Success)
+			assert.True(t, records[1].MFA)
+			assert.Equal(t, "ldap", records[1].Provider)
+			assert.Equal(t, "firefox", records[1].UserAgent)
+
+			_, records, err = h.List("t1", "alice", &model.Page{PageNum: 2, PageSize: 2})
+			assert.NoError(t, err)
+			assert.Len(t, records, 2)
+			assert.False(t, records[0].Success)
+			assert.True(t, records[1].Success)
+
+			n, err := h.Purge()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), n)
+			total, _, err = h.List("t1", "alice", nil)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(3), total)
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := New(Config{}, NewMemoryStore())
+	for i := 0; i < 3; i++ {
+		h.WriteEvent(login("alice", time.Now(), audit.OutcomeSuccess, false))
+	}
+	h.WriteEvent(login("bob", time.Now(), audit.OutcomeSuccess, false))
+	serve := func(handler http.Handler, target string, subject string) (*httptest.ResponseRecorder, *listResponse) {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if subject != "" {
+			r = r.WithContext(middleware.WithClaims(r.Context(), &token.Claims{Subject: subject, TenantID: "t1"}))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		resp := &listResponse{}
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+		}
+		return w, resp
+	}
+
+	w, _ := serve(h.Handler(), "/", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, resp := serve(h.Handler(), "/?page_size=2", "alice")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(3), resp.Total)
+	assert.Equal(t, 1, resp.PageNum)
+	assert.Len(t, resp.Records, 2)
+
+	w, _ = serve(h.AdminHandler(), "/", "admin")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, resp = serve(h.AdminHandler(), "/?subject=bob", "admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(1), resp.Total)
+	assert.Equal(t, "bob", resp.Records[0].Subject)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loginhistory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+var (
+	_ Store = &MemoryStore{}
+	_ Store = &GormStore{}
+)
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock    sync.RWMutex
+	seq     uint64
+	records []Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Add(r *Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	r.ID = s.seq
+	s.records = append(s.records, *r)
+	return nil
+}
+
+func (s *MemoryStore) List(tenantID, subject string, page *model.Page) (int64, []*Record, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	matched := make([]*Record, 0)
+	for i := range s.records {
+		if s.records[i].TenantID == tenantID && s.records[i].Subject == subject {
+			r := s.records[i]
+			matched = append(matched, &r)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].Time.Equal(matched[j].Time) {
+			return matched[i].ID > matched[j].ID
+		}
+		return matched[i].Time.After(matched[j].Time)
+	})
+	total := int64(len(matched))
+	if page == nil || page.PageSize <= 0 {
+		return total, matched, nil
+	}
+	start := (page.PageNum - 1) * page.PageSize
+	if page.PageNum <= 0 || start >= len(matched) {
+		return total, []*Record{}, nil
+	}
+	end := start + page.PageSize
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return total, matched[start:end], nil
+}
+
+func (s *MemoryStore) Purge(before time.Time) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	kept := s.records[:0]
+	for _, r := range s.records {
+		if !r.Time.Before(before) {
+			kept = append(kept, r)
+		}
+	}
+	n := int64(len(s.records) - len(kept))
+	s.records = kept
+	return n, nil
+}
+
+// GormStore persists records in the sys_t_login_record table.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore returns a GormStore, migrating its table.
+func NewGormStore(db *gorm.DB) (*GormStore, error) {
+	if err := db.AutoMigrate(&model.LoginRecord{}); err != nil {
+		return nil, err
+	}
+	return &GormStore{db: db}, nil
+}
+
+func (s *GormStore) Add(r *Record) error {
+	row := toModel(r)
+	if err := row.Create(s.db); err != nil {
+		return err
+	}
+	r.ID = row.ID
+	return nil
+}
+
+func (s *GormStore) List(tenantID, subject string, page *model.Page) (int64, []*Record, error) {
+	total, rows, err := model.ListLoginRecords(s.db, tenantID, subject, page)
+	if err != nil {
+		return 0, nil, err
+	}
+	records := make([]*Record, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, fromModel(row))
+	}
+	return total, records, nil
+}
+
+func (s *GormStore) Purge(before time.Time) (int64, error) {
+	return model.PurgeLoginRecords(s.db, before)
+}
+
+func toModel(r *Record) *model.LoginRecord {
+	return &model.LoginRecord{
+		ID:        r.ID,
+		TenantID:  r.TenantID,
+		Subject:   r.Subject,
+		Provider:  r.Provider,
+		IP:        r.IP,
+		UserAgent: truncate(r.UserAgent, 512),
+		Success:   r.Success,
+		Reason:    truncate(r.Reason, 255),
+		MFA:       r.MFA,
+		CreatedAt: r.Time,
+	}
+}
+
+func fromModel(row *model.LoginRecord) *Record {
+	return &Record{
+		ID:        row.ID,
+		TenantID:  row.TenantID,
+		Subject:   row.Subject,
+		Provider:  row.Provider,
+		IP:        row.IP,
+		UserAgent: row.UserAgent,
+		Success:   row.Success,
+		Reason:    row.Reason,
+		MFA:       row.MFA,
+		Time:      row.CreatedAt,
+	}
+}
+
+// truncate cuts s to the n bytes of its column, user agents and reasons come from clients.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
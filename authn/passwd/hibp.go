@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passwd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1" //nolint:gosec
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+const _defaultHIBPURL = "https://api.pwnedpasswords.com"
+
+var _ BreachChecker = &HIBP{}
+
+// HIBP looks passwords up in the Have I Been Pwned Pwned Passwords range API. Only the first
+// five hex characters of the SHA-1 of a password leave the process (k-anonymity), and the
+// responses are padded so their size does not reveal the prefix either.
+type HIBP struct {
+	// BaseURL default to https://api.pwnedpasswords.com.
+	BaseURL string `mapstructure:"base_url" json:"base_url" yaml:"baseUrl"`
+	// UserAgent the API rejects requests without one. Default to tkeel-security.
+	UserAgent string       `mapstructure:"user_agent" json:"user_agent" yaml:"userAgent"`
+	Client    *http.Client `mapstructure:"-" json:"-" yaml:"-"`
+}
+
+// Breached returns how often password appears in the breach corpus.
+func (h *HIBP) Breached(ctx context.Context, password string) (int, error) {
+	base, agent, client := h.BaseURL, h.UserAgent, h.Client
+	if base == "" {
+		base = _defaultHIBPURL
+	}
+	if agent == "" {
+		agent = "tkeel-security"
+	}
+	if client == nil {
+		client = utils.DefaultHTTPClient
+	}
+	sum := fmt.Sprintf("%X", sha1.Sum([]byte(password))) //nolint:gosec
+	prefix, suffix := sum[:5], sum[5:]
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+"/range/"+prefix, nil)
+	if err != nil {
+		return 0, fmt.Errorf("hibp request %w", err)
+	}
+	req.Header.Set("User-Agent", agent)
+	req.Header.Set("Add-Padding", "true")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("hibp lookup %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("hibp lookup: status %d", resp.StatusCode)
+	}
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1<<21))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.IndexByte(line, ':')
+		if i < 0 || !strings.EqualFold(line[:i], suffix) {
+			continue
+		}
+		// padding entries carry a count of 0.
+		count, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return 0, fmt.Errorf("hibp response %w", err)
+		}
+		return count, nil
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, fmt.Errorf("hibp response %w", err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,363 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package passwd hashes passwords with argon2id, bcrypt or scrypt in PHC string format, see
+// https://github.com/P-H-C/phc-string-format/blob/master/phc-sf-spec.md
+package passwd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// AlgorithmArgon2id the memory-hard winner of the Password Hashing Competition.
+	AlgorithmArgon2id = "argon2id"
+	// AlgorithmBcrypt for compatibility with existing bcrypt hashes.
+	AlgorithmBcrypt = "bcrypt"
+	// AlgorithmScrypt memory-hard, where argon2 is not approved.
+	AlgorithmScrypt = "scrypt"
+
+	_defaultArgon2Memory      = 64 * 1024
+	_defaultArgon2Iterations  = 3
+	_defaultArgon2Parallelism = 2
+	_defaultBcryptCost        = 12
+	_defaultScryptLogN        = 15
+	_defaultScryptR           = 8
+	_defaultScryptP           = 1
+	_defaultSaltLength        = 16
+	_defaultKeyLength         = 32
+)
+
+var (
+	// ErrMismatchedPassword the password does not match the hash.
+	ErrMismatchedPassword = errors.New("mismatched password")
+	// ErrInvalidHash the hash is not in a known format.
+	ErrInvalidHash = errors.New("invalid password hash")
+	// ErrUnsupportedAlgorithm the configured algorithm is unknown.
+	ErrUnsupportedAlgorithm = errors.New("unsupported password hash algorithm")
+)
+
+// Argon2Params of argon2id.
+type Argon2Params struct {
+	// Memory KiB. Default to 64 MiB.
+	Memory uint32 `mapstructure:"memory" json:"memory" yaml:"memory"`
+	// Iterations default to 3.
+	Iterations uint32 `mapstructure:"iterations" json:"iterations" yaml:"iterations"`
+	// Parallelism default to 2.
+	Parallelism uint8 `mapstructure:"parallelism" json:"parallelism" yaml:"parallelism"`
+}
+
+// ScryptParams of scrypt.
+type ScryptParams struct {
+	// LogN base 2 logarithm of the CPU/memory cost. Default to 15.
+	LogN uint8 `mapstructure:"log_n" json:"log_n" yaml:"logN"`
+	// R block size. Default to 8.
+	R int `mapstructure:"r" json:"r" yaml:"r"`
+	// P parallelism. Default to 1.
+	P int `mapstructure:"p" json:"p" yaml:"p"`
+}
+
+// Config of new hashes.
+type Config struct {
+	// Algorithm argon2id, bcrypt or scrypt. Default to argon2id.
+	Algorithm string       `mapstructure:"algorithm" json:"algorithm" yaml:"algorithm"`
+	Argon2    Argon2Params `mapstructure:"argon2" json:"argon2" yaml:"argon2"`
+	// BcryptCost default to 12.
+	BcryptCost int          `mapstructure:"bcrypt_cost" json:"bcrypt_cost" yaml:"bcryptCost"`
+	Scrypt     ScryptParams `mapstructure:"scrypt" json:"scrypt" yaml:"scrypt"`
+	// SaltLength and KeyLength bytes of argon2id and scrypt hashes. Default to 16 and 32.
+	SaltLength int `mapstructure:"salt_length" json:"salt_length" yaml:"saltLength"`
+	KeyLength  int `mapstructure:"key_length" json:"key_length" yaml:"keyLength"`
+}
+
+// Hasher hashes new passwords with the configured algorithm and verifies hashes of any.
+type Hasher struct {
+	conf Config
+}
+
+func New(conf Config) (*Hasher, error) {
+	if conf.Algorithm == "" {
+		conf.Algorithm = AlgorithmArgon2id
+	}
+	switch conf.Algorithm {
+	case AlgorithmArgon2id, AlgorithmBcrypt, AlgorithmScrypt:
+	default:
+		return nil, fmt.Errorf("%s: %w", conf.Algorithm, ErrUnsupportedAlgorithm)
+	}
+	if conf.Argon2.Memory == 0 {
+		conf.Argon2.Memory = _defaultArgon2Memory
+	}
+	if conf.Argon2.Iterations == 0 {
+		conf.Argon2.Iterations = _defaultArgon2Iterations
+	}
+	if conf.Argon2.Parallelism == 0 {
+		conf.Argon2.Parallelism = _defaultArgon2Parallelism
+	}
+	if conf.BcryptCost == 0 {
+		conf.BcryptCost = _defaultBcryptCost
+	}
+	if conf.BcryptCost < bcrypt.MinCost || conf.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost %d out of range", conf.BcryptCost)
+	}
+	if conf.Scrypt.LogN == 0 {
+		conf.Scrypt.LogN = _defaultScryptLogN
+	}
+	if conf.Scrypt.R == 0 {
+		conf.Scrypt.R = _defaultScryptR
+	}
+	if conf.Scrypt.P == 0 {
+		conf.Scrypt.P = _defaultScryptP
+	}
+	if conf.SaltLength <= 0 {
+		conf.SaltLength = _defaultSaltLength
+	}
+	if conf.KeyLength <= 0 {
+		conf.KeyLength = _defaultKeyLength
+	}
+	return &Hasher{conf: conf}, nil
+}
+
+// Hash returns the encoded hash of password.
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.conf.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.conf.BcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("bcrypt %w", err)
+		}
+		return string(hash), nil
+	}
+	salt := make([]byte, h.conf.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("password salt %w", err)
+	}
+	p := &phc{salt: salt}
+	var err error
+	if h.conf.Algorithm == AlgorithmScrypt {
+		p.id, p.params = AlgorithmScrypt, scryptParams(h.conf.Scrypt)
+		p.hash, err = scrypt.Key([]byte(password), salt, 1<<h.conf.Scrypt.LogN, h.conf.Scrypt.R, h.conf.Scrypt.P, h.conf.KeyLength)
+		if err != nil {
+			return "", fmt.Errorf("scrypt %w", err)
+		}
+		return p.String(), nil
+	}
+	a := h.conf.Argon2
+	p.id, p.version, p.params = AlgorithmArgon2id, argon2.Version, argon2Params(a)
+	p.hash = argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, uint32(h.conf.KeyLength))
+	return p.String(), nil
+}
+
+// Verify checks password against encoded, a hash of any supported algorithm.
+func (h *Hasher) Verify(password, encoded string) error {
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrMismatchedPassword
+		}
+		if err != nil {
+			return fmt.Errorf("bcrypt %w", ErrInvalidHash)
+		}
+		return nil
+	}
+	p, err := parsePHC(encoded)
+	if err != nil {
+		return err
+	}
+	var key []byte
+	switch p.id {
+	case AlgorithmArgon2id:
+		a, err := p.argon2()
+		if err != nil {
+			return err
+		}
+		key = argon2.IDKey([]byte(password), p.salt, a.Iterations, a.Memory, a.Parallelism, uint32(len(p.hash)))
+	case AlgorithmScrypt:
+		s, err := p.scrypt()
+		if err != nil {
+			return err
+		}
+		if key, err = scrypt.Key([]byte(password), p.salt, 1<<s.LogN, s.R, s.P, len(p.hash)); err != nil {
+			return fmt.Errorf("scrypt %w", ErrInvalidHash)
+		}
+	default:
+		return fmt.Errorf("%s: %w", p.id, ErrUnsupportedAlgorithm)
+	}
+	if subtle.ConstantTimeCompare(key, p.hash) != 1 {
+		return ErrMismatchedPassword
+	}
+	return nil
+}
+
+// NeedsRehash reports whether encoded was produced with another algorithm or weaker parameters
+// than configured, rehash the password after it verified.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	if isBcrypt(encoded) {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return h.conf.Algorithm != AlgorithmBcrypt || err != nil || cost < h.conf.BcryptCost
+	}
+	p, err := parsePHC(encoded)
+	if err != nil || p.id != h.conf.Algorithm || len(p.hash) < h.conf.KeyLength || len(p.salt) < h.conf.SaltLength {
+		return true
+	}
+	switch p.id {
+	case AlgorithmArgon2id:
+		a, err := p.argon2()
+		return err != nil || p.version != argon2.Version || a.Memory < h.conf.Argon2.Memory ||
+			a.Iterations < h.conf.Argon2.Iterations || a.Parallelism < h.conf.Argon2.Parallelism
+	case AlgorithmScrypt:
+		s, err := p.scrypt()
+		return err != nil || s.LogN < h.conf.Scrypt.LogN || s.R < h.conf.Scrypt.R || s.P < h.conf.Scrypt.P
+	}
+	return true
+}
+
+var (
+	_defaultLock      sync.RWMutex
+	_defaultHasher, _ = New(Config{})
+)
+
+// SetDefault replaces the Hasher of the package level functions.
+func SetDefault(h *Hasher) {
+	_defaultLock.Lock()
+	defer _defaultLock.Unlock()
+	_defaultHasher = h
+}
+
+func defaultHasher() *Hasher {
+	_defaultLock.RLock()
+	defer _defaultLock.RUnlock()
+	return _defaultHasher
+}
+
+// Hash hashes password with the default Hasher.
+func Hash(password string) (string, error) {
+	return defaultHasher().Hash(password)
+}
+
+// Verify checks password against encoded.
+func Verify(password, encoded string) error {
+	return defaultHasher().Verify(password, encoded)
+}
+
+// NeedsRehash reports whether encoded falls behind the default Hasher.
+func NeedsRehash(encoded string) bool {
+	return defaultHasher().NeedsRehash(encoded)
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// phc a parsed $id[$v=version][$params]$salt$hash string.
+type phc struct {
+	id      string
+	version int
+	params  string
+	salt    []byte
+	hash    []byte
+}
+
+func (p *phc) String() string {
+	b64 := base64.RawStdEncoding
+	parts := []string{"", p.id}
+	if p.version != 0 {
+		parts = append(parts, "v="+strconv.Itoa(p.version))
+	}
+	parts = append(parts, p.params, b64.EncodeToString(p.salt), b64.EncodeToString(p.hash))
+	return strings.Join(parts, "$")
+}
+
+func parsePHC(encoded string) (*phc, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 5 || parts[0] != "" {
+		return nil, ErrInvalidHash
+	}
+	p := &phc{id: parts[1]}
+	rest := parts[2:]
+	if strings.HasPrefix(rest[0], "v=") {
+		v, err := strconv.Atoi(strings.TrimPrefix(rest[0], "v="))
+		if err != nil {
+			return nil, ErrInvalidHash
+		}
+		p.version, rest = v, rest[1:]
+	}
+	if len(rest) != 3 {
+		return nil, ErrInvalidHash
+	}
+	var err error
+	p.params = rest[0]
+	if p.salt, err = base64.RawStdEncoding.DecodeString(rest[1]); err != nil {
+		return nil, ErrInvalidHash
+	}
+	if p.hash, err = base64.RawStdEncoding.DecodeString(rest[2]); err != nil || len(p.hash) == 0 {
+		return nil, ErrInvalidHash
+	}
+	return p, nil
+}
+
+// values parses the comma separated name=value parameters.
+func (p *phc) values() (map[string]uint64, error) {
+	values := make(map[string]uint64)
+	for _, pair := range strings.Split(p.params, ",") {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return nil, ErrInvalidHash
+		}
+		v, err := strconv.ParseUint(pair[i+1:], 10, 32)
+		if err != nil {
+			return nil, ErrInvalidHash
+		}
+		values[pair[:i]] = v
+	}
+	return values, nil
+}
+
+func (p *phc) argon2() (Argon2Params, error) {
+	v, err := p.values()
+	if err != nil {
+		return Argon2Params{}, err
+	}
+	if v["m"] == 0 || v["t"] == 0 || v["p"] == 0 || v["p"] > 255 {
+		return Argon2Params{}, ErrInvalidHash
+	}
+	return Argon2Params{Memory: uint32(v["m"]), Iterations: uint32(v["t"]), Parallelism: uint8(v["p"])}, nil
+}
+
+func argon2Params(a Argon2Params) string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", a.Memory, a.Iterations, a.Parallelism)
+}
+
+func (p *phc) scrypt() (ScryptParams, error) {
+	v, err := p.values()
+	if err != nil {
+		return ScryptParams{}, err
+	}
+	if v["ln"] == 0 || v["ln"] > 63 || v["r"] == 0 || v["p"] == 0 {
+		return ScryptParams{}, ErrInvalidHash
+	}
+	return ScryptParams{LogN: uint8(v["ln"]), R: int(v["r"]), P: int(v["p"])}, nil
+}
+
+func scryptParams(s ScryptParams) string {
+	return fmt.Sprintf("ln=%d,r=%d,p=%d", s.LogN, s.R, s.P)
+}
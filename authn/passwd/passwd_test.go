@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passwd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fast parameters, the defaults take a noticeable time per hash by design.
+var _fast = Config{
+	Argon2:     Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1},
+	BcryptCost: bcrypt.MinCost,
+	Scrypt:     ScryptParams{LogN: 10},
+}
+
+func TestHasher(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt, AlgorithmScrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			conf := _fast
+			conf.Algorithm = algorithm
+			h, err := New(conf)
+			assert.NoError(t, err)
+			hash, err := h.Hash("correct horse")
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(hash, map[string]string{
+				AlgorithmArgon2id: "$argon2id$v=19$m=1024,t=1,p=1$",
+				AlgorithmBcrypt:   "$2a$04$",
+				AlgorithmScrypt:   "$scrypt$ln=10,r=8,p=1$",
+			}[algorithm]), hash)
+			assert.NoError(t, h.Verify("correct horse", hash))
+			assert.ErrorIs(t, h.Verify("battery staple", hash), ErrMismatchedPassword)
+			assert.False(t, h.NeedsRehash(hash))
+
+			other, err := h.Hash("correct horse")
+			assert.NoError(t, err)
+			assert.NotEqual(t, hash, other)
+		})
+	}
+
+	_, err := New(Config{Algorithm: "md5"})
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestNeedsRehash(t *testing.T) {
+	weak, err := New(_fast)
+	assert.NoError(t, err)
+	hash, err := weak.Hash("secret")
+	assert.NoError(t, err)
+
+	stronger := _fast
+	stronger.Argon2.Iterations = 2
+	strong, err := New(stronger)
+	assert.NoError(t, err)
+	assert.True(t, strong.NeedsRehash(hash))
+	assert.NoError(t, strong.Verify("secret", hash))
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	assert.True(t, strong.NeedsRehash(string(legacy)))
+	assert.NoError(t, strong.Verify("secret", string(legacy)))
+	assert.True(t, strong.NeedsRehash("garbage"))
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	h, err := New(_fast)
+	assert.NoError(t, err)
+	tests := []struct {
+		encoded string
+		err     error
+	}{
+		{"", ErrInvalidHash},
+		{"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA", ErrInvalidHash},
+		{"$argon2id$v=19$m=x,t=1,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$argon2id$v=19$m=1024,t=1,p=1$!$aGFzaA", ErrInvalidHash},
+		{"$pbkdf2$i=1$c2FsdA$aGFzaA", ErrUnsupportedAlgorithm},
+		{"$2a$04$short", ErrInvalidHash},
+	}
+	for _, tt := range tests {
+		assert.ErrorIs(t, h.Verify("secret", tt.encoded), tt.err, tt.encoded)
+	}
+}
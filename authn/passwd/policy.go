@@ -0,0 +1,295 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passwd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tkeel-io/security/log"
+)
+
+const (
+	_defaultMinLength = 8
+	// _defaultMaxLength bounds the hashing work an attacker can cause with one request.
+	_defaultMaxLength = 128
+	// _minUserInputLength shorter user inputs are too likely to occur by chance.
+	_minUserInputLength = 3
+)
+
+var (
+	ErrTooShort          = errors.New("password too short")
+	ErrTooLong           = errors.New("password too long")
+	ErrMissingUpper      = errors.New("password needs an upper case letter")
+	ErrMissingLower      = errors.New("password needs a lower case letter")
+	ErrMissingDigit      = errors.New("password needs a digit")
+	ErrMissingSymbol     = errors.New("password needs a symbol")
+	ErrTooWeak           = errors.New("password too easy to guess")
+	ErrContainsUserInput = errors.New("password contains the username or email")
+	ErrBreached          = errors.New("password appeared in a data breach")
+)
+
+// ValidationError lists the rules a password violates.
+type ValidationError struct {
+	Violations []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.Error())
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// Is reports whether any violation is target.
+func (e *ValidationError) Is(target error) bool {
+	for _, v := range e.Violations {
+		if errors.Is(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// BreachChecker counts the occurrences of a password in known breaches.
+type BreachChecker interface {
+	Breached(ctx context.Context, password string) (int, error)
+}
+
+// PolicyConfig of the password rules.
+type PolicyConfig struct {
+	// MinLength characters. Default to 8.
+	MinLength int `mapstructure:"min_length" json:"min_length" yaml:"minLength"`
+	// MaxLength characters. Default to 128.
+	MaxLength     int  `mapstructure:"max_length" json:"max_length" yaml:"maxLength"`
+	RequireUpper  bool `mapstructure:"require_upper" json:"require_upper" yaml:"requireUpper"`
+	RequireLower  bool `mapstructure:"require_lower" json:"require_lower" yaml:"requireLower"`
+	RequireDigit  bool `mapstructure:"require_digit" json:"require_digit" yaml:"requireDigit"`
+	RequireSymbol bool `mapstructure:"require_symbol" json:"require_symbol" yaml:"requireSymbol"`
+	// MinScore strength from 0 (too guessable) to 4 (very unguessable), see Score.
+	MinScore int `mapstructure:"min_score" json:"min_score" yaml:"minScore"`
+	// BreachThreshold breach occurrences rejecting a password. Default to 1.
+	BreachThreshold int `mapstructure:"breach_threshold" json:"breach_threshold" yaml:"breachThreshold"`
+}
+
+// Policy validates new passwords.
+type Policy struct {
+	conf     PolicyConfig
+	breaches BreachChecker
+	logger   *log.Helper
+}
+
+// NewPolicy returns a Policy, breaches may be nil to skip the breach lookup.
+func NewPolicy(conf PolicyConfig, breaches BreachChecker) *Policy {
+	if conf.MinLength <= 0 {
+		conf.MinLength = _defaultMinLength
+	}
+	if conf.MaxLength <= 0 {
+		conf.MaxLength = _defaultMaxLength
+	}
+	if conf.BreachThreshold <= 0 {
+		conf.BreachThreshold = 1
+	}
+	return &Policy{conf: conf, breaches: breaches}
+}
+
+// SetLogger writes the entries of the breach checks to l instead of the global logger.
+func (p *Policy) SetLogger(l log.Logger) {
+	p.logger = log.NewHelper(l)
+}
+
+// Validate checks password against the rules, userInputs such as the username and email must not
+// appear in it. It returns a *ValidationError listing all violations. A failing breach lookup is
+// logged and does not reject the password.
+func (p *Policy) Validate(ctx context.Context, password string, userInputs ...string) error {
+	var violations []error
+	length := utf8.RuneCountInString(password)
+	if length < p.conf.MinLength {
+		violations = append(violations, ErrTooShort)
+	}
+	if length > p.conf.MaxLength {
+		violations = append(violations, ErrTooLong)
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	for _, class := range []struct {
+		required, present bool
+		err               error
+	}{
+		{p.conf.RequireUpper, upper, ErrMissingUpper},
+		{p.conf.RequireLower, lower, ErrMissingLower},
+		{p.conf.RequireDigit, digit, ErrMissingDigit},
+		{p.conf.RequireSymbol, symbol, ErrMissingSymbol},
+	} {
+		if class.required && !class.present {
+			violations = append(violations, class.err)
+		}
+	}
+	if containsUserInput(password, userInputs) {
+		violations = append(violations, ErrContainsUserInput)
+	}
+	if p.conf.MinScore > 0 && Score(password, userInputs...) < p.conf.MinScore {
+		violations = append(violations, ErrTooWeak)
+	}
+	if len(violations) == 0 && p.breaches != nil {
+		count, err := p.breaches.Breached(ctx, password)
+		if err != nil {
+			p.logger.Warnf("password breach lookup: %s", err)
+		} else if count >= p.conf.BreachThreshold {
+			violations = append(violations, ErrBreached)
+		}
+	}
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+func containsUserInput(password string, userInputs []string) bool {
+	lower := strings.ToLower(password)
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		candidates := []string{input}
+		// the local part of an email address is as guessable as the whole.
+		if i := strings.IndexByte(input, '@'); i > 0 {
+			candidates = append(candidates, input[:i])
+		}
+		for _, c := range candidates {
+			if utf8.RuneCountInString(c) >= _minUserInputLength && strings.Contains(lower, c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// _commonPasswords a few of the most used passwords, matched after stripping digits and symbols
+// from the ends, the breach lookup covers the long tail.
+var _commonPasswords = map[string]bool{
+	"password": true, "passw0rd": true, "qwerty": true, "letmein": true, "welcome": true,
+	"admin": true, "iloveyou": true, "monkey": true, "dragon": true, "football": true,
+	"baseball": true, "sunshine": true, "princess": true, "master": true, "shadow": true,
+	"superman": true, "trustno": true, "abc": true, "login": true, "starwars": true,
+}
+
+// _sequences ordered runs, a password walking them is guessed early.
+var _sequences = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"0123456789",
+	"qwertyuiop", "asdfghjkl", "zxcvbnm",
+	"1qaz2wsx3edc", "qazwsxedc",
+}
+
+// Score estimates how hard password is to guess, in the spirit of zxcvbn: 0 below 10^3
+// guesses, 1 below 10^6, 2 below 10^8, 3 below 10^10 and 4 above. Repeats, sequences,
+// keyboard walks, common passwords and userInputs contribute almost nothing.
+func Score(password string, userInputs ...string) int {
+	log10 := guesses(password, userInputs)
+	switch {
+	case log10 < 3:
+		return 0
+	case log10 < 6:
+		return 1
+	case log10 < 8:
+		return 2
+	case log10 < 10:
+		return 3
+	}
+	return 4
+}
+
+// guesses returns the log10 of the estimated guesses.
+func guesses(password string, userInputs []string) float64 {
+	lower := strings.ToLower(password)
+	core := strings.TrimFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })
+	if _commonPasswords[core] || _commonPasswords[lower] {
+		return 1
+	}
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		if len(input) >= _minUserInputLength {
+			lower = strings.ReplaceAll(lower, input, "\x00")
+		}
+	}
+	var pool float64
+	var upper, lowerCase, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r < utf8.RuneSelf && unicode.IsUpper(r):
+			upper = true
+		case r < utf8.RuneSelf && unicode.IsLower(r):
+			lowerCase = true
+		case r < utf8.RuneSelf && unicode.IsDigit(r):
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	for _, class := range []struct {
+		present bool
+		size    float64
+	}{{upper, 26}, {lowerCase, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.present {
+			pool += class.size
+		}
+	}
+	runes := []rune(lower)
+	perChar := math.Log2(pool)
+	var bits float64
+	for i := 0; i < len(runes); {
+		if runes[i] == 0 {
+			// a user input counts as a single guess among a handful.
+			bits += 2
+			i++
+			continue
+		}
+		// a run of repeats or a sequence costs its first character and its length.
+		j := i + 1
+		for j < len(runes) && runes[j] != 0 && (runes[j] == runes[j-1] || inSequence(runes[j-1], runes[j])) {
+			j++
+		}
+		bits += perChar + math.Log2(float64(j-i))
+		i = j
+	}
+	return bits * math.Log10(2)
+}
+
+// inSequence reports whether b follows a, forwards or backwards, in a known sequence.
+func inSequence(a, b rune) bool {
+	pair, reversed := string([]rune{a, b}), string([]rune{b, a})
+	for _, seq := range _sequences {
+		if strings.Contains(seq, pair) || strings.Contains(seq, reversed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package passwd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		password string
+		score    int
+	}{
+		{"password", 0},
+		{"Password1!", 0},
+		{"aaaaaaaaaaaa", 0},
+		{"abcdefgh", 0},
+		{"qwertyuiop123", 1},
+		{"x9!", 1},
+		{"kq7z", 2},
+		{"kq7zr4", 3},
+		{"Tr0ub4dor&3", 4},
+		{"correct horse battery staple", 4},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.score, Score(tt.password), tt.password)
+	}
+	assert.Less(t, Score("alice.smith2021", "alice.smith"), Score("alice.smith2021"))
+}
+
+func TestPolicy(t *testing.T) {
+	p := NewPolicy(PolicyConfig{MinLength: 10, RequireUpper: true, RequireDigit: true, RequireSymbol: true, MinScore: 3}, nil)
+	tests := []struct {
+		password   string
+		userInputs []string
+		violations []error
+	}{
+		{"Tr0ub4dor&3xyz", nil, nil},
+		{"short", nil, []error{ErrTooShort, ErrMissingUpper, ErrMissingDigit, ErrMissingSymbol, ErrTooWeak}},
+		{"Alice#2021xyzw", []string{"alice@example.com"}, []error{ErrContainsUserInput}},
+		{"Password#2021", nil, []error{ErrTooWeak}},
+	}
+	for _, tt := range tests {
+		err := p.Validate(context.Background(), tt.password, tt.userInputs...)
+		if tt.violations == nil {
+			assert.NoError(t, err, tt.password)
+			continue
+		}
+		var verr *ValidationError
+		assert.ErrorAs(t, err, &verr, tt.password)
+		assert.Equal(t, tt.violations, verr.Violations, tt.password)
+		assert.ErrorIs(t, err, tt.violations[0])
+	}
+}
+
+func TestHIBP(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.Header.Get("Add-Padding"))
+		assert.NotEmpty(t, r.Header.Get("User-Agent"))
+		if r.URL.Path != "/range/5BAA6" {
+			fmt.Fprint(w, "0018A45C4D1DEF81644B54AB7F969B88D65:0\r\n")
+			return
+		}
+		fmt.Fprint(w, "003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\r\n")
+	}))
+	defer srv.Close()
+
+	h := &HIBP{BaseURL: srv.URL}
+	count, err := h.Breached(context.Background(), "password")
+	assert.NoError(t, err)
+	assert.Equal(t, 9659365, count)
+	count, err = h.Breached(context.Background(), "a rather unusual passphrase")
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	p := NewPolicy(PolicyConfig{}, h)
+	assert.ErrorIs(t, p.Validate(context.Background(), "password"), ErrBreached)
+	assert.NoError(t, NewPolicy(PolicyConfig{}, &HIBP{BaseURL: "http://127.0.0.1:1"}).Validate(context.Background(), "kitten42kitten"))
+}
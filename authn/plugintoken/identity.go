@@ -0,0 +1,178 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugintoken authenticates calls between tkeel plugins. Each plugin holds an Ed25519
+// identity key whose public half the platform collects when the plugin registers, calls carry
+// short-lived JWTs naming the calling plugin, the callee and the tenant they act for. Callees
+// verify them against the registry, which also holds the addons the platform granted.
+package plugintoken
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/golang-jwt/jwt"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	_defaultTTL = time.Minute
+	// _reuseFraction of the lifetime a cached token is handed out for.
+	_reuseFraction = 0.5
+
+	// TokenType the token_type extra claim of verified plugin tokens.
+	TokenType = "plugin"
+)
+
+// ErrPluginIDRequired the identity has no plugin id.
+var ErrPluginIDRequired = errors.New("plugin id required")
+
+// Document the identity a plugin presents to the platform on registration.
+type Document struct {
+	PluginID string          `json:"plugin_id"`
+	Key      jose.JSONWebKey `json:"key"`
+}
+
+// IdentityConfig of the calling plugin.
+type IdentityConfig struct {
+	// PluginID id of the plugin, the iss and sub of its tokens.
+	PluginID string `mapstructure:"plugin_id" json:"plugin_id" yaml:"pluginId"`
+	// TTL of the tokens. Default to 1m.
+	TTL time.Duration `mapstructure:"ttl" json:"ttl" yaml:"ttl"`
+}
+
+type cached struct {
+	token   string
+	renewAt time.Time
+}
+
+// Identity the key of a plugin, it signs the tokens of its calls.
+type Identity struct {
+	conf IdentityConfig
+	key  ed25519.PrivateKey
+
+	lock  sync.Mutex
+	cache map[string]cached
+}
+
+// NewIdentity returns the Identity of key, a nil key generates one. A generated key lives as
+// long as the process, the plugin registers again after a restart.
+func NewIdentity(conf IdentityConfig, key ed25519.PrivateKey) (*Identity, error) {
+	if conf.PluginID == "" {
+		return nil, ErrPluginIDRequired
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = _defaultTTL
+	}
+	if key == nil {
+		var err error
+		if _, key, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			return nil, fmt.Errorf("generate plugin key %w", err)
+		}
+	}
+	return &Identity{conf: conf, key: key, cache: make(map[string]cached)}, nil
+}
+
+// Document returns the identity document carrying the public key.
+func (i *Identity) Document() *Document {
+	return &Document{
+		PluginID: i.conf.PluginID,
+		Key: jose.JSONWebKey{
+			Key:       i.key.Public(),
+			KeyID:     i.conf.PluginID,
+			Algorithm: token.AlgorithmEdDSA,
+			Use:       "sig",
+		},
+	}
+}
+
+// IdentifyHandler serves the identity document the platform fetches on registration.
+func (i *Identity) IdentifyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(i.Document())
+	})
+}
+
+// Issue returns a token for calls to the audience plugin on behalf of tenantID, which is empty
+// for calls outside any tenant.
+func (i *Identity) Issue(audience, tenantID string) (string, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	now := time.Now()
+	cacheKey := audience + "\n" + tenantID
+	if c, ok := i.cache[cacheKey]; ok && now.Before(c.renewAt) {
+		return c.token, nil
+	}
+	id, err := utils.RandBase64String(16)
+	if err != nil {
+		return "", err
+	}
+	claims := &token.Claims{
+		ID:        id,
+		Issuer:    i.conf.PluginID,
+		Subject:   i.conf.PluginID,
+		Audience:  audience,
+		TenantID:  tenantID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(i.conf.TTL).Unix(),
+	}
+	t := jwt.NewWithClaims(jwt.GetSigningMethod(token.AlgorithmEdDSA), claims)
+	t.Header["kid"] = i.conf.PluginID
+	signed, err := t.SignedString(i.key)
+	if err != nil {
+		return "", fmt.Errorf("sign plugin token %w", err)
+	}
+	i.cache[cacheKey] = cached{token: signed, renewAt: now.Add(time.Duration(float64(i.conf.TTL) * _reuseFraction))}
+	return signed, nil
+}
+
+// Transport returns a RoundTripper authenticating requests to the audience plugin with
+// tokens of i, the tenant of a request is the one of tenantOf. base defaults to
+// http.DefaultTransport, a nil tenantOf issues tokens outside any tenant.
+func (i *Identity) Transport(audience string, tenantOf func(r *http.Request) string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{identity: i, audience: audience, tenantOf: tenantOf, base: base}
+}
+
+type transport struct {
+	identity *Identity
+	audience string
+	tenantOf func(r *http.Request) string
+	base     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var tenantID string
+	if t.tenantOf != nil {
+		tenantID = t.tenantOf(r)
+	}
+	raw, err := t.identity.Issue(t.audience, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+raw)
+	return t.base.RoundTrip(r)
+}
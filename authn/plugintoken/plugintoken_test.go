@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugintoken
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tenantsFunc func(tenantID, pluginID string) (bool, error)
+
+func (f tenantsFunc) TenantPluginPermissible(tenantID, pluginID string) (bool, error) {
+	return f(tenantID, pluginID)
+}
+
+func TestPluginTokens(t *testing.T) {
+	_, err := NewIdentity(IdentityConfig{}, nil)
+	assert.ErrorIs(t, err, ErrPluginIDRequired)
+	caller, err := NewIdentity(IdentityConfig{PluginID: "iothub"}, nil)
+	assert.NoError(t, err)
+	impostor, err := NewIdentity(IdentityConfig{PluginID: "iothub"}, nil)
+	assert.NoError(t, err)
+
+	srv := httptest.NewServer(caller.IdentifyHandler())
+	defer srv.Close()
+	registry := NewMemoryRegistry()
+	registrar := NewRegistrar(registry, nil)
+	_, err = registrar.Register(context.Background(), "rule-manager", srv.URL)
+	assert.ErrorIs(t, err, ErrInvalidDocument)
+	_, err = registrar.Register(context.Background(), "iothub", srv.URL)
+	assert.NoError(t, err)
+	assert.NoError(t, registrar.GrantAddons("iothub", "device-create", "device-delete"))
+	assert.NoError(t, registrar.RevokeAddons("iothub", "device-delete"))
+
+	v := NewVerifier(VerifierConfig{PluginID: "core-broker"}, registry, tenantsFunc(func(tenantID, pluginID string) (bool, error) {
+		return tenantID == "t1" && pluginID == "iothub", nil
+	}))
+	raw, err := caller.Issue("core-broker", "t1")
+	assert.NoError(t, err)
+	again, err := caller.Issue("core-broker", "t1")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, again)
+	claims, err := v.Verify(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "iothub", claims.Subject)
+	assert.Equal(t, "t1", claims.TenantID)
+	assert.Equal(t, []string{"device-create"}, Addons(claims))
+	assert.NoError(t, CheckAddon(claims, "device-create"))
+	assert.ErrorIs(t, CheckAddon(claims, "device-delete"), ErrAddonNotGranted)
+
+	forged, err := impostor.Issue("core-broker", "t1")
+	assert.NoError(t, err)
+	other, err := caller.Issue("rule-manager", "t1")
+	assert.NoError(t, err)
+	disabled, err := caller.Issue("core-broker", "t2")
+	assert.NoError(t, err)
+	long, err := NewIdentity(IdentityConfig{PluginID: "iothub", TTL: time.Hour}, caller.key)
+	assert.NoError(t, err)
+	tooLong, err := long.Issue("core-broker", "")
+	assert.NoError(t, err)
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"forged", forged, token.ErrInvalidToken},
+		{"audience", other, ErrAudienceMismatch},
+		{"tenant", disabled, ErrTenantNotEnabled},
+		{"lifetime", tooLong, ErrLifetimeTooLong},
+	}
+	for _, tt := range tests {
+		_, err := v.Verify(tt.token)
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+
+	// re-registration replaces the key and keeps the grants.
+	_, err = registrar.RegisterDocument("iothub", impostor.Document())
+	assert.NoError(t, err)
+	claims, err = v.Verify(forged)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"device-create"}, Addons(claims))
+	assert.NoError(t, registrar.Unregister("iothub"))
+	_, err = v.Verify(forged)
+	assert.ErrorIs(t, err, token.ErrInvalidToken)
+}
+
+func TestTransport(t *testing.T) {
+	caller, err := NewIdentity(IdentityConfig{PluginID: "iothub"}, nil)
+	assert.NoError(t, err)
+	registry := NewMemoryRegistry()
+	_, err = NewRegistrar(registry, nil).RegisterDocument("iothub", caller.Document())
+	assert.NoError(t, err)
+	v := NewVerifier(VerifierConfig{PluginID: "core-broker"}, registry, nil)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := v.Verify(r.Header.Get("Authorization")[len("Bearer "):])
+		assert.NoError(t, err)
+		_, _ = w.Write([]byte(claims.TenantID))
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: caller.Transport("core-broker", func(r *http.Request) string {
+		return r.Header.Get("X-Tenant-ID")
+	}, nil)}
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Tenant-ID", "t1")
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
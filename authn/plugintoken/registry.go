@@ -0,0 +1,181 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugintoken
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+var (
+	_ Registry = &MemoryRegistry{}
+
+	// ErrPluginNotRegistered the registry does not know the plugin.
+	ErrPluginNotRegistered = errors.New("plugin not registered")
+	// ErrInvalidDocument the identity document does not name the plugin or carries no Ed25519 public key.
+	ErrInvalidDocument = errors.New("invalid plugin identity document")
+)
+
+// Registration the identity key of a plugin and the addons the platform granted it.
+type Registration struct {
+	PluginID string    `json:"plugin_id"`
+	Identity *Document `json:"identity"`
+	// Addons the addons (extension points) of other plugins the plugin may call.
+	Addons       []string  `json:"addons"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// Registry persists registrations.
+type Registry interface {
+	Save(r *Registration) error
+	// Get returns the registration of pluginID or ErrPluginNotRegistered.
+	Get(pluginID string) (*Registration, error)
+	Delete(pluginID string) error
+}
+
+// MemoryRegistry in-process Registry, suitable for a single replica or tests.
+type MemoryRegistry struct {
+	lock    sync.RWMutex
+	plugins map[string]Registration
+}
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{plugins: make(map[string]Registration)}
+}
+
+func (m *MemoryRegistry) Save(r *Registration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.plugins[r.PluginID] = *r
+	return nil
+}
+
+func (m *MemoryRegistry) Get(pluginID string) (*Registration, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	r, ok := m.plugins[pluginID]
+	if !ok {
+		return nil, ErrPluginNotRegistered
+	}
+	return &r, nil
+}
+
+func (m *MemoryRegistry) Delete(pluginID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.plugins, pluginID)
+	return nil
+}
+
+// Registrar the platform side of the registration, it collects the identity keys of plugins
+// and manages their grants.
+type Registrar struct {
+	registry Registry
+	client   *http.Client
+}
+
+// NewRegistrar returns a Registrar, client defaults to utils.DefaultHTTPClient.
+func NewRegistrar(registry Registry, client *http.Client) *Registrar {
+	if client == nil {
+		client = utils.DefaultHTTPClient
+	}
+	return &Registrar{registry: registry, client: client}
+}
+
+// Register fetches the identity document of pluginID from the IdentifyHandler at identifyURL
+// and registers its key, replacing the key of an earlier registration but keeping its addons.
+func (r *Registrar) Register(ctx context.Context, pluginID, identifyURL string) (*Registration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, identifyURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("identify request %w", err)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("identify plugin %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identify plugin: status %d", resp.StatusCode)
+	}
+	doc := &Document{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(doc); err != nil {
+		return nil, fmt.Errorf("decode identity document %w", err)
+	}
+	return r.RegisterDocument(pluginID, doc)
+}
+
+// RegisterDocument registers the key of doc, for plugins handing their document over another
+// authenticated channel.
+func (r *Registrar) RegisterDocument(pluginID string, doc *Document) (*Registration, error) {
+	if doc.PluginID != pluginID {
+		return nil, fmt.Errorf("%w: document of %s", ErrInvalidDocument, doc.PluginID)
+	}
+	if _, ok := doc.Key.Key.(ed25519.PublicKey); !ok {
+		return nil, fmt.Errorf("%w: Ed25519 public key required", ErrInvalidDocument)
+	}
+	reg := &Registration{PluginID: pluginID, Identity: doc, RegisteredAt: time.Now()}
+	old, err := r.registry.Get(pluginID)
+	switch {
+	case err == nil:
+		reg.Addons = old.Addons
+	case !errors.Is(err, ErrPluginNotRegistered):
+		return nil, err
+	}
+	if err = r.registry.Save(reg); err != nil {
+		return nil, fmt.Errorf("save plugin registration %w", err)
+	}
+	return reg, nil
+}
+
+// Unregister removes the plugin, its tokens stop verifying.
+func (r *Registrar) Unregister(pluginID string) error {
+	return r.registry.Delete(pluginID)
+}
+
+// GrantAddons lets the plugin call addons.
+func (r *Registrar) GrantAddons(pluginID string, addons ...string) error {
+	reg, err := r.registry.Get(pluginID)
+	if err != nil {
+		return err
+	}
+	reg.Addons = utils.StringsUniqueAppend(reg.Addons, addons...)
+	sort.Strings(reg.Addons)
+	return r.registry.Save(reg)
+}
+
+// RevokeAddons withdraws addons from the plugin.
+func (r *Registrar) RevokeAddons(pluginID string, addons ...string) error {
+	reg, err := r.registry.Get(pluginID)
+	if err != nil {
+		return err
+	}
+	kept := make([]string, 0, len(reg.Addons))
+	for _, a := range reg.Addons {
+		if !utils.StringsInclude(addons, a) {
+			kept = append(kept, a)
+		}
+	}
+	reg.Addons = kept
+	return r.registry.Save(reg)
+}
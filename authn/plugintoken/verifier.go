@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugintoken
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	_defaultMaxTTL = 5 * time.Minute
+	_defaultLeeway = 5 * time.Second
+)
+
+var (
+	_ token.Verifier = &Verifier{}
+
+	// ErrAudienceMismatch the token was issued for another plugin.
+	ErrAudienceMismatch = errors.New("plugin token audience mismatch")
+	// ErrLifetimeTooLong the token lives longer than plugin tokens may.
+	ErrLifetimeTooLong = errors.New("plugin token lifetime too long")
+	// ErrTenantNotEnabled the calling plugin is not enabled for the tenant of the token.
+	ErrTenantNotEnabled = errors.New("plugin not enabled for tenant")
+	// ErrAddonNotGranted the calling plugin was not granted the addon.
+	ErrAddonNotGranted = errors.New("plugin addon not granted")
+)
+
+// TenantChecker tells whether a plugin is enabled for a tenant, satisfied by rbac.TenantPluginMgr.
+type TenantChecker interface {
+	TenantPluginPermissible(tenantID, pluginID string) (bool, error)
+}
+
+// VerifierConfig of the called plugin.
+type VerifierConfig struct {
+	// PluginID id of the called plugin, tokens must carry it as audience.
+	PluginID string `mapstructure:"plugin_id" json:"plugin_id" yaml:"pluginId"`
+	// MaxTTL longest lifetime accepted. Default to 5m.
+	MaxTTL time.Duration `mapstructure:"max_ttl" json:"max_ttl" yaml:"maxTtl"`
+	// Leeway clock skew tolerated. Default to 5s.
+	Leeway time.Duration `mapstructure:"leeway" json:"leeway" yaml:"leeway"`
+}
+
+// Verifier checks the tokens of calling plugins against the registry, satisfies token.Verifier.
+type Verifier struct {
+	conf     VerifierConfig
+	registry Registry
+	tenants  TenantChecker
+}
+
+// NewVerifier returns a Verifier, tenants may be nil when the tenant of tokens is not checked.
+func NewVerifier(conf VerifierConfig, registry Registry, tenants TenantChecker) *Verifier {
+	if conf.MaxTTL <= 0 {
+		conf.MaxTTL = _defaultMaxTTL
+	}
+	if conf.Leeway <= 0 {
+		conf.Leeway = _defaultLeeway
+	}
+	return &Verifier{conf: conf, registry: registry, tenants: tenants}
+}
+
+// Verify returns the claims of raw, the subject is the calling plugin and the addons extra
+// claim lists the addons it was granted.
+func (v *Verifier) Verify(raw string) (*token.Claims, error) {
+	claims := &token.Claims{}
+	var reg *Registration
+	parser := &jwt.Parser{ValidMethods: []string{token.AlgorithmEdDSA}, SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		// the claims are decoded but not trusted yet, the issuer only selects the key.
+		if kid, _ := t.Header["kid"].(string); kid != claims.Issuer {
+			return nil, fmt.Errorf("kid %q of issuer %q", kid, claims.Issuer)
+		}
+		var err error
+		if reg, err = v.registry.Get(claims.Issuer); err != nil {
+			return nil, err
+		}
+		return reg.Identity.Key.Key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", token.ErrInvalidToken, err)
+	}
+	now := time.Now()
+	leeway := int64(v.conf.Leeway.Seconds())
+	switch {
+	case claims.ExpiresAt == 0 || claims.IssuedAt == 0:
+		return nil, fmt.Errorf("%w: iat and exp required", token.ErrInvalidToken)
+	case now.Unix() >= claims.ExpiresAt+leeway:
+		return nil, token.ErrTokenExpired
+	case claims.IssuedAt > now.Unix()+leeway:
+		return nil, fmt.Errorf("%w: issued in the future", token.ErrInvalidToken)
+	case time.Duration(claims.ExpiresAt-claims.IssuedAt)*time.Second > v.conf.MaxTTL:
+		return nil, ErrLifetimeTooLong
+	case claims.Subject != claims.Issuer:
+		return nil, fmt.Errorf("%w: subject is not the issuer", token.ErrInvalidToken)
+	case claims.Audience != v.conf.PluginID:
+		return nil, ErrAudienceMismatch
+	}
+	if claims.TenantID != "" && v.tenants != nil {
+		ok, err := v.tenants.TenantPluginPermissible(claims.TenantID, claims.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("check tenant plugin %w", err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s in %s: %w", claims.Issuer, claims.TenantID, ErrTenantNotEnabled)
+		}
+	}
+	addons := make([]interface{}, 0, len(reg.Addons))
+	for _, a := range reg.Addons {
+		addons = append(addons, a)
+	}
+	claims.Extra = map[string]interface{}{"token_type": TokenType, "addons": addons}
+	return claims, nil
+}
+
+// Addons returns the addons granted to the plugin of claims.
+func Addons(claims *token.Claims) []string {
+	values, _ := claims.Extra["addons"].([]interface{})
+	addons := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			addons = append(addons, s)
+		}
+	}
+	return addons
+}
+
+// CheckAddon returns ErrAddonNotGranted unless the plugin of claims was granted addon.
+func CheckAddon(claims *token.Claims, addon string) error {
+	if !utils.StringsInclude(Addons(claims), addon) {
+		return fmt.Errorf("%s: %w", addon, ErrAddonNotGranted)
+	}
+	return nil
+}
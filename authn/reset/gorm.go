@@ -0,0 +1,71 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reset
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tkeel-io/security/authn/passwd"
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+var _ Accounts = &GormAccounts{}
+
+// GormAccounts the users of the model package.
+type GormAccounts struct {
+	db *gorm.DB
+}
+
+func NewGormAccounts(db *gorm.DB) *GormAccounts {
+	return &GormAccounts{db: db}
+}
+
+func (a *GormAccounts) Find(ctx context.Context, tenantID, login string) (*Account, error) {
+	user := &model.User{}
+	err := a.db.WithContext(ctx).Where("tenant_id = ? AND (username = ? OR email = ?)", tenantID, login, login).First(user).Error
+	return account(user, err)
+}
+
+func (a *GormAccounts) Load(ctx context.Context, id string) (*Account, error) {
+	user := &model.User{}
+	err := a.db.WithContext(ctx).Where("id = ?", id).First(user).Error
+	return account(user, err)
+}
+
+func (a *GormAccounts) SetPassword(ctx context.Context, id, password string) error {
+	hash, err := passwd.Hash(password)
+	if err != nil {
+		return err
+	}
+	return a.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", id).Update("password", hash).Error
+}
+
+func account(user *model.User, err error) (*Account, error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		ID:           user.ID,
+		TenantID:     user.TenantID,
+		Username:     user.UserName,
+		Email:        user.Email,
+		PasswordHash: user.Password,
+	}, nil
+}
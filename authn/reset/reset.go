@@ -0,0 +1,226 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package reset lets users who forgot their password set a new one through a link sent to them.
+package reset
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/passwd"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+)
+
+const _defaultTTL = 30 * time.Minute
+
+var (
+	// ErrInvalidToken the reset token is malformed, forged, expired or was used.
+	ErrInvalidToken = errors.New("invalid password reset token")
+	// ErrAccountNotFound the account store knows no such account.
+	ErrAccountNotFound = errors.New("account not found")
+	// ErrSecretRequired the tokens are configured without a signing secret.
+	ErrSecretRequired = errors.New("password reset secret required")
+)
+
+// Account the part of a user the reset needs.
+type Account struct {
+	ID       string
+	TenantID string
+	Username string
+	Email    string
+	// PasswordHash the stored hash, tokens are bound to it.
+	PasswordHash string
+}
+
+// Accounts looks up accounts and changes their passwords.
+type Accounts interface {
+	// Find returns the account of tenantID with the username or email login, or ErrAccountNotFound.
+	Find(ctx context.Context, tenantID, login string) (*Account, error)
+	// Load returns the account with id, or ErrAccountNotFound.
+	Load(ctx context.Context, id string) (*Account, error)
+	// SetPassword hashes and stores the new password of the account with id.
+	SetPassword(ctx context.Context, id, password string) error
+}
+
+// Sender delivers reset links.
+type Sender interface {
+	Send(ctx context.Context, account *Account, link string, ttl time.Duration) error
+}
+
+// SenderFunc adapts a function to a Sender.
+type SenderFunc func(ctx context.Context, account *Account, link string, ttl time.Duration) error
+
+func (f SenderFunc) Send(ctx context.Context, account *Account, link string, ttl time.Duration) error {
+	return f(ctx, account, link, ttl)
+}
+
+// SubjectRevoker ends everything issued to a subject of a tenant, e.g. a *session.MemoryStore,
+// *token.MemoryStore or *server.MemoryStorage.
+type SubjectRevoker interface {
+	RevokeSubject(tenantID, subject string) error
+}
+
+// Config of the reset.
+type Config struct {
+	// Secret signs the tokens.
+	Secret string `mapstructure:"secret" json:"secret" yaml:"secret"`
+	// TTL how long a link is valid. Default to 30m.
+	TTL time.Duration `mapstructure:"ttl" json:"ttl" yaml:"ttl"`
+	// URL of the reset page, the token is added as token query parameter.
+	URL string `mapstructure:"url" json:"url" yaml:"url"`
+}
+
+// Service issues reset links and resets passwords.
+type Service struct {
+	conf     Config
+	accounts Accounts
+	sender   Sender
+	policy   *passwd.Policy
+	revokers []SubjectRevoker
+	logger   *log.Helper
+}
+
+// New returns a Service, policy may be nil to accept any new password. The revokers end the
+// sessions and refresh tokens of the account after a reset.
+func New(conf Config, accounts Accounts, sender Sender, policy *passwd.Policy, revokers ...SubjectRevoker) (*Service, error) {
+	if conf.Secret == "" {
+		return nil, ErrSecretRequired
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = _defaultTTL
+	}
+	return &Service{conf: conf, accounts: accounts, sender: sender, policy: policy, revokers: revokers}, nil
+}
+
+// SetLogger writes the entries of the password resets to l instead of the global logger.
+func (s *Service) SetLogger(l log.Logger) {
+	s.logger = log.NewHelper(l)
+}
+
+// Request sends a reset link to the account of login. Unknown logins succeed silently, so the
+// answer does not reveal which accounts exist.
+func (s *Service) Request(ctx context.Context, tenantID, login string) error {
+	account, err := s.accounts.Find(ctx, tenantID, login)
+	if errors.Is(err, ErrAccountNotFound) {
+		s.logger.Debugf("password reset for unknown login %s in tenant %s", login, tenantID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("find account %w", err)
+	}
+	t, err := s.Issue(account)
+	if err != nil {
+		return err
+	}
+	link, err := url.Parse(s.conf.URL)
+	if err != nil {
+		return fmt.Errorf("password reset url %w", err)
+	}
+	q := link.Query()
+	q.Set("token", t)
+	link.RawQuery = q.Encode()
+	if err = s.sender.Send(ctx, account, link.String(), s.conf.TTL); err != nil {
+		return fmt.Errorf("send password reset %w", err)
+	}
+	return nil
+}
+
+type payload struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+	Nonce     string `json:"nonce"`
+}
+
+// Issue returns a reset token of account. The signature covers the current password hash, so
+// the token is single use and every outstanding token dies once the password changes.
+func (s *Service) Issue(account *Account) (string, error) {
+	nonce, err := utils.RandBase64String(12)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&payload{Subject: account.ID, ExpiresAt: time.Now().Add(s.conf.TTL).Unix(), Nonce: nonce})
+	if err != nil {
+		return "", fmt.Errorf("marshal password reset token %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return encoded + "." + s.sign(encoded, account.PasswordHash), nil
+}
+
+// Verify returns the account of a valid token, e.g. to render the new password form.
+func (s *Service) Verify(ctx context.Context, t string) (*Account, error) {
+	i := strings.IndexByte(t, '.')
+	if i < 0 {
+		return nil, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(t[:i])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	p := &payload{}
+	if err = json.Unmarshal(data, p); err != nil || time.Now().Unix() >= p.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+	account, err := s.accounts.Load(ctx, p.Subject)
+	if errors.Is(err, ErrAccountNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load account %w", err)
+	}
+	if !hmac.Equal([]byte(t[i+1:]), []byte(s.sign(t[:i], account.PasswordHash))) {
+		return nil, ErrInvalidToken
+	}
+	return account, nil
+}
+
+// Reset sets the password of the account of t after checking it against the policy, then ends
+// all sessions and refresh tokens of the account. Revocation failures are logged, the password
+// is changed by then.
+func (s *Service) Reset(ctx context.Context, t, password string) error {
+	account, err := s.Verify(ctx, t)
+	if err != nil {
+		return err
+	}
+	if s.policy != nil {
+		if err = s.policy.Validate(ctx, password, account.Username, account.Email); err != nil {
+			return err
+		}
+	}
+	if err = s.accounts.SetPassword(ctx, account.ID, password); err != nil {
+		return fmt.Errorf("set password %w", err)
+	}
+	for _, r := range s.revokers {
+		if err = r.RevokeSubject(account.TenantID, account.ID); err != nil {
+			s.logger.Errorf("revoke %s after password reset: %s", account.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) sign(encoded, passwordHash string) string {
+	mac := hmac.New(sha256.New, []byte(s.conf.Secret))
+	mac.Write([]byte(encoded))
+	mac.Write([]byte{0})
+	mac.Write([]byte(passwordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reset
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/passwd"
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/model"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestService(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&model.User{}))
+	user := &model.User{TenantID: "tnt-1", UserName: "alice", Email: "alice@example.com", Password: "old password"}
+	assert.NoError(t, user.Create(db))
+
+	sessions := session.NewMemoryStore()
+	assert.NoError(t, sessions.Save(context.Background(), &session.Session{ID: "s1", Claims: &token.Claims{Subject: user.ID, TenantID: "tnt-1"}}, time.Hour))
+	assert.NoError(t, sessions.Save(context.Background(), &session.Session{ID: "s2", Claims: &token.Claims{Subject: "usr-other", TenantID: "tnt-1"}}, time.Hour))
+
+	var link string
+	sender := SenderFunc(func(ctx context.Context, account *Account, l string, ttl time.Duration) error {
+		assert.Equal(t, "alice@example.com", account.Email)
+		link = l
+		return nil
+	})
+	_, err = New(Config{}, NewGormAccounts(db), sender, nil)
+	assert.ErrorIs(t, err, ErrSecretRequired)
+	s, err := New(Config{Secret: "secret", URL: "https://tkeel.io/reset?lang=en"}, NewGormAccounts(db), sender,
+		passwd.NewPolicy(passwd.PolicyConfig{}, nil), sessions)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, s.Request(ctx, "tnt-1", "nobody"))
+	assert.Empty(t, link)
+	assert.NoError(t, s.Request(ctx, "tnt-1", "alice@example.com"))
+	u, err := url.Parse(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "en", u.Query().Get("lang"))
+	first := u.Query().Get("token")
+	assert.NoError(t, s.Request(ctx, "tnt-1", "alice"))
+	u, _ = url.Parse(link)
+	second := u.Query().Get("token")
+
+	account, err := s.Verify(ctx, first)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, account.ID)
+	_, err = s.Verify(ctx, first[:len(first)-2]+"xx")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	assert.ErrorIs(t, s.Reset(ctx, first, "alice123"), passwd.ErrContainsUserInput)
+	assert.NoError(t, s.Reset(ctx, first, "a much better passphrase"))
+	_, err = model.AuthenticateUser(db, "tnt-1", "alice", "a much better passphrase")
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, s.Reset(ctx, first, "yet another passphrase"), ErrInvalidToken)
+	assert.ErrorIs(t, s.Reset(ctx, second, "yet another passphrase"), ErrInvalidToken)
+	_, err = sessions.Load(context.Background(), "s1")
+	assert.ErrorIs(t, err, session.ErrSessionNotFound)
+	_, err = sessions.Load(context.Background(), "s2")
+	assert.NoError(t, err)
+
+	expired, err := New(Config{Secret: "secret", TTL: time.Nanosecond}, NewGormAccounts(db), sender, nil)
+	assert.NoError(t, err)
+	account, err = NewGormAccounts(db).Load(ctx, user.ID)
+	assert.NoError(t, err)
+	t2, err := expired.Issue(account)
+	assert.NoError(t, err)
+	time.Sleep(time.Second)
+	_, err = expired.Verify(ctx, t2)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
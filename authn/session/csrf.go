@@ -0,0 +1,183 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	_defaultCSRFHeader = "X-CSRF-Token"
+	_defaultCSRFField  = "csrf_token"
+	_defaultCSRFCookie = "tkeel_csrf"
+	// _valueCSRF the session value holding the synchronizer token.
+	_valueCSRF = "csrf"
+)
+
+var (
+	// ErrCSRFTokenMismatch the request lacks the CSRF token of its session.
+	ErrCSRFTokenMismatch = errors.New("csrf token mismatch")
+	// ErrCSRFOrigin the request comes from an untrusted origin.
+	ErrCSRFOrigin = errors.New("csrf untrusted origin")
+)
+
+// CSRFConfig of the CSRF protection.
+type CSRFConfig struct {
+	// HeaderName request header carrying the token. Default to X-CSRF-Token.
+	HeaderName string `mapstructure:"header_name" json:"header_name" yaml:"headerName"`
+	// FieldName form field carrying the token. Default to csrf_token.
+	FieldName string `mapstructure:"field_name" json:"field_name" yaml:"fieldName"`
+	// CookieName double-submit cookie of requests without a session. Default to tkeel_csrf.
+	CookieName string `mapstructure:"cookie_name" json:"cookie_name" yaml:"cookieName"`
+	// Exempt path prefixes not protected, e.g. webhooks authenticated otherwise.
+	Exempt []string `mapstructure:"exempt" json:"exempt" yaml:"exempt"`
+	// TrustedOrigins origins besides the request host unsafe requests may come from.
+	TrustedOrigins []string `mapstructure:"trusted_origins" json:"trusted_origins" yaml:"trustedOrigins"`
+}
+
+// CSRF protects unsafe requests with the synchronizer token of the session, or a double-submit
+// cookie for requests without a session. The cookie has the SameSite and Secure attributes of the
+// session cookie, which stops most cross-site requests before the token is checked.
+type CSRF struct {
+	m    *Manager
+	conf CSRFConfig
+}
+
+// CSRF returns the CSRF protection of the sessions of m.
+func (m *Manager) CSRF(conf CSRFConfig) *CSRF {
+	if conf.HeaderName == "" {
+		conf.HeaderName = _defaultCSRFHeader
+	}
+	if conf.FieldName == "" {
+		conf.FieldName = _defaultCSRFField
+	}
+	if conf.CookieName == "" {
+		conf.CookieName = _defaultCSRFCookie
+	}
+	return &CSRF{m: m, conf: conf}
+}
+
+// Middleware issues tokens on safe requests and rejects unsafe requests without the expected token
+// with 403, it must run after Manager.Middleware.
+func (c *CSRF) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		expected, err := c.token(w, r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !isSafeMethod(r.Method) {
+			if err = c.verify(r, expected); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, expected)))
+	})
+}
+
+// Token returns the token to embed in forms or send in the header of the request r Middleware passed.
+func Token(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// TemplateField returns the hidden form field carrying the token of r.
+func (c *CSRF) TemplateField(r *http.Request) template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, //nolint:gosec
+		template.HTMLEscapeString(c.conf.FieldName), template.HTMLEscapeString(Token(r))))
+}
+
+// SetHeader sends the token of r in the response header, for single page applications.
+func (c *CSRF) SetHeader(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(c.conf.HeaderName, Token(r))
+}
+
+// token returns the expected token of r, issuing one when there is none yet.
+func (c *CSRF) token(w http.ResponseWriter, r *http.Request) (string, error) {
+	if s, ok := FromContext(r.Context()); ok {
+		if t := s.Values[_valueCSRF]; t != "" {
+			return t, nil
+		}
+		t, err := utils.RandBase64String(32)
+		if err != nil {
+			return "", err
+		}
+		if s.Values == nil {
+			s.Values = make(map[string]string)
+		}
+		s.Values[_valueCSRF] = t
+		return t, c.m.Save(r.Context(), w, s)
+	}
+	if cookie, err := r.Cookie(c.conf.CookieName); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	t, err := utils.RandBase64String(32)
+	if err != nil {
+		return "", err
+	}
+	cookie := c.m.cookie(t, 0)
+	cookie.Name = c.conf.CookieName
+	http.SetCookie(w, cookie)
+	return t, nil
+}
+
+func (c *CSRF) verify(r *http.Request, expected string) error {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Host != r.Host && !utils.StringsInclude(c.conf.TrustedOrigins, origin)) {
+			return ErrCSRFOrigin
+		}
+	}
+	presented := r.Header.Get(c.conf.HeaderName)
+	if presented == "" {
+		presented = r.PostFormValue(c.conf.FieldName)
+	}
+	if presented == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(expected)) != 1 {
+		return ErrCSRFTokenMismatch
+	}
+	return nil
+}
+
+func (c *CSRF) exempt(r *http.Request) bool {
+	for _, prefix := range c.conf.Exempt {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type csrfContextKey struct{}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+var (
+	_ BatchStore = &GormStore{}
+	_ Exporter   = &GormStore{}
+)
+
+const _exportBatch = 500
+
+// GormStore persists sessions in the sys_t_session table, expired rows are skipped on load and
+// deleted by Purge.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore returns a GormStore, migrating its table.
+func NewGormStore(db *gorm.DB) (*GormStore, error) {
+	if err := db.AutoMigrate(&model.Session{}); err != nil {
+		return nil, err
+	}
+	return &GormStore{db: db}, nil
+}
+
+func (s *GormStore) Save(ctx context.Context, session *Session, ttl time.Duration) error {
+	row, err := toModel(session, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+	return row.Save(s.db.WithContext(ctx))
+}
+
+func (s *GormStore) Update(ctx context.Context, session *Session, ttl time.Duration) error {
+	row, err := toModel(session, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+	updated, err := row.Update(s.db.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *GormStore) Load(ctx context.Context, id string) (*Session, error) {
+	row := &model.Session{ID: id}
+	found, err := row.Get(s.db.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("load session %w", err)
+	}
+	if !found {
+		return nil, ErrSessionNotFound
+	}
+	return fromModel(row)
+}
+
+func (s *GormStore) Delete(ctx context.Context, id string) error {
+	return model.DeleteSession(s.db.WithContext(ctx), id)
+}
+
+func (s *GormStore) RevokeSubject(tenantID, subject string) error {
+	return model.DeleteSubjectSessions(s.db, tenantID, subject)
+}
+
+func (s *GormStore) RevokeTenant(tenantID string) error {
+	return model.DeleteTenantSessions(s.db, tenantID)
+}
+
+func (s *GormStore) ListSessions(tenantID, subject string) ([]*Session, error) {
+	rows, err := model.ListSessions(s.db, tenantID, subject)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(rows))
+	for _, row := range rows {
+		session, err := fromModel(row)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (s *GormStore) Export(fn func(s *Session, expireAt time.Time) error) error {
+	return model.ScanSessions(s.db, _exportBatch, func(rows []*model.Session) error {
+		for _, row := range rows {
+			session, err := fromModel(row)
+			if err != nil {
+				return err
+			}
+			if err = fn(session, row.ExpireAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Purge deletes the expired sessions, run it periodically.
+func (s *GormStore) Purge() (int64, error) {
+	return model.PurgeSessions(s.db, time.Now())
+}
+
+func toModel(s *Session, expireAt time.Time) (*model.Session, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("marshal session %w", err)
+	}
+	row := &model.Session{ID: s.ID, Data: string(data), ExpireAt: expireAt, CreatedAt: time.Unix(s.CreatedAt, 0)}
+	if s.Claims != nil {
+		row.TenantID, row.Subject = s.Claims.TenantID, s.Claims.Subject
+	}
+	return row, nil
+}
+
+func fromModel(row *model.Session) (*Session, error) {
+	s := &Session{}
+	if err := json.Unmarshal([]byte(row.Data), s); err != nil {
+		return nil, fmt.Errorf("unmarshal session %w", err)
+	}
+	return s, nil
+}
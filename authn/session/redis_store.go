@@ -0,0 +1,297 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	_ BatchStore = &RedisStore{}
+	_ Exporter   = &RedisStore{}
+)
+
+const (
+	_defaultRedisPrefix = "session:"
+	_scanBatch          = 500
+)
+
+// _saveSession sets the session KEYS[1] to ARGV[1] for ARGV[2] milliseconds and adds its id ARGV[3]
+// to the index sets KEYS[2:], which live as long as their longest lived session.
+var _saveSession = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+for i = 2, #KEYS do
+  redis.call('SADD', KEYS[i], ARGV[3])
+  if redis.call('PTTL', KEYS[i]) < ttl then
+    redis.call('PEXPIRE', KEYS[i], ttl)
+  end
+end
+return 1
+`)
+
+// RedisStore Store shared by all replicas through redis, sessions expire with their keys. The
+// sessions of a subject and of a tenant are indexed in sets for batch revocation. With redis
+// cluster the prefix must carry a hash tag, e.g. {session}:, as the keys are updated together.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore returns a RedisStore keeping the sessions under prefix, default to session:.
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = _defaultRedisPrefix
+	}
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Save(ctx context.Context, session *Session, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("marshal session %w", err)
+	}
+	keys := append([]string{s.key(session.ID)}, s.indexes(session)...)
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	if err = _saveSession.Run(ctx, s.client, keys, data, ms, session.ID).Err(); err != nil {
+		return fmt.Errorf("save session %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Update(ctx context.Context, session *Session, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("marshal session %w", err)
+	}
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	// XX only replaces a live session, a revoked one is not set again. The indexes already hold
+	// the id as the claims of a session do not change.
+	set, err := s.client.SetXX(ctx, s.key(session.ID), data, ttl).Result()
+	if err != nil {
+		return fmt.Errorf("update session %w", err)
+	}
+	if !set {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *RedisStore) Load(ctx context.Context, id string) (*Session, error) {
+	data, err := s.client.Get(ctx, s.key(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load session %w", err)
+	}
+	session := &Session{}
+	if err = json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("unmarshal session %w", err)
+	}
+	return session, nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	session, err := s.Load(ctx, id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.key(id))
+		for _, index := range s.indexes(session) {
+			pipe.SRem(ctx, index, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete session %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) RevokeSubject(tenantID, subject string) error {
+	return s.revoke(s.subjectIndex(tenantID, subject))
+}
+
+func (s *RedisStore) RevokeTenant(tenantID string) error {
+	return s.revoke(s.prefix + "tenant:" + tenantID)
+}
+
+// revoke deletes the sessions of the index and the index, the other index holding a revoked
+// session drops it when listed.
+func (s *RedisStore) revoke(index string) error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	ids, err := s.client.SMembers(ctx, index).Result()
+	if err != nil {
+		return fmt.Errorf("revoke sessions %w", err)
+	}
+	keys := []string{index}
+	for _, id := range ids {
+		keys = append(keys, s.key(id))
+	}
+	if err = s.client.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("revoke sessions %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) ListSessions(tenantID, subject string) ([]*Session, error) {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	var (
+		index string
+		ids   []string
+		err   error
+	)
+	switch {
+	case subject != "" && tenantID != "":
+		index = s.subjectIndex(tenantID, subject)
+	case tenantID != "":
+		index = s.prefix + "tenant:" + tenantID
+	}
+	if index != "" {
+		ids, err = s.client.SMembers(ctx, index).Result()
+	} else {
+		ids, err = s.scanIDs(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list sessions %w", err)
+	}
+	sessions := make([]*Session, 0, len(ids))
+	if len(ids) == 0 {
+		return sessions, nil
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, s.key(id))
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list sessions %w", err)
+	}
+	stale := make([]interface{}, 0)
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			stale = append(stale, ids[i])
+			continue
+		}
+		session := &Session{}
+		if err = json.Unmarshal([]byte(data), session); err != nil {
+			return nil, fmt.Errorf("unmarshal session %w", err)
+		}
+		c := session.Claims
+		if c == nil || (tenantID != "" && c.TenantID != tenantID) || (subject != "" && c.Subject != subject) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if index != "" && len(stale) > 0 {
+		_ = s.client.SRem(ctx, index, stale...).Err()
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt < sessions[j].CreatedAt })
+	return sessions, nil
+}
+
+func (s *RedisStore) Export(fn func(s *Session, expireAt time.Time) error) error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	ids, err := s.scanIDs(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("export sessions %w", err)
+	}
+	for _, id := range ids {
+		// each session gets its own timeout, fn may take a while.
+		ctx, cancel = utils.WithTimeout(context.Background(), 0)
+		ttl, err := s.client.PTTL(ctx, s.key(id)).Result()
+		if err != nil {
+			cancel()
+			return fmt.Errorf("export sessions %w", err)
+		}
+		session, err := s.Load(ctx, id)
+		cancel()
+		if errors.Is(err, ErrSessionNotFound) || ttl <= 0 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(session, time.Now().Add(ttl)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisStore) scanIDs(ctx context.Context) ([]string, error) {
+	idPrefix := s.prefix + "id:"
+	ids := make([]string, 0)
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(ctx, cursor, idPrefix+"*", _scanBatch).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			ids = append(ids, key[len(idPrefix):])
+		}
+		if cursor = next; cursor == 0 {
+			return ids, nil
+		}
+	}
+}
+
+func (s *RedisStore) key(id string) string {
+	return s.prefix + "id:" + id
+}
+
+// indexes returns the index sets of session, none before login.
+func (s *RedisStore) indexes(session *Session) []string {
+	if session.Claims == nil {
+		return nil
+	}
+	indexes := make([]string, 0, 2)
+	if session.Claims.Subject != "" {
+		indexes = append(indexes, s.subjectIndex(session.Claims.TenantID, session.Claims.Subject))
+	}
+	if session.Claims.TenantID != "" {
+		indexes = append(indexes, s.prefix+"tenant:"+session.Claims.TenantID)
+	}
+	return indexes
+}
+
+// subjectIndex the index set of the sessions of subject in the tenant, subjects of different
+// tenants are different users.
+func (s *RedisStore) subjectIndex(tenantID, subject string) string {
+	return s.prefix + "subject:" + tenantID + "/" + subject
+}
@@ -0,0 +1,404 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package session keeps browser sessions in encrypted cookies or a server side store.
+package session
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	_defaultCookieName      = "tkeel_session"
+	_defaultIdleTimeout     = 30 * time.Minute
+	_defaultAbsoluteTimeout = 12 * time.Hour
+	// _touchInterval how often a request moves the idle deadline, so not every request rewrites the session.
+	_touchInterval = time.Minute
+	// _maxCookieSize the size browsers are required to accept.
+	_maxCookieSize = 4096
+)
+
+var (
+	// ErrNoSession the request carries no session cookie.
+	ErrNoSession = errors.New("no session")
+	// ErrSessionExpired the session passed its idle or absolute timeout.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrSessionNotFound the store does not know the session.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionRevoked the cookie session was logged out.
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrInvalidCookie the session cookie was not issued by this manager or was tampered with.
+	ErrInvalidCookie = errors.New("invalid session cookie")
+	// ErrSecretRequired cookie sessions are configured without a secret.
+	ErrSecretRequired = errors.New("session secret required")
+	// ErrCookieTooLarge the sealed session does not fit in a cookie, use a server side store.
+	ErrCookieTooLarge = errors.New("session cookie too large")
+)
+
+// Session the state of a logged in browser.
+type Session struct {
+	// ID random identifier, rotated on login.
+	ID string `json:"id"`
+	// Claims of the logged in subject, nil before login.
+	Claims *token.Claims `json:"claims,omitempty"`
+	// Values application data of the session.
+	Values map[string]string `json:"values,omitempty"`
+	// CreatedAt unix time of the login, the absolute timeout counts from it.
+	CreatedAt int64 `json:"created_at"`
+	// AccessedAt unix time of the last request, the idle timeout counts from it.
+	AccessedAt int64 `json:"accessed_at"`
+}
+
+// Store keeps sessions server side, the cookie only carries the session id.
+type Store interface {
+	// Save stores s under its id, expiring after ttl.
+	Save(ctx context.Context, s *Session, ttl time.Duration) error
+	// Update replaces the stored session s, expiring after ttl, or returns ErrSessionNotFound when
+	// it was ended meanwhile. It never stores s again, so a revoked session stays revoked.
+	Update(ctx context.Context, s *Session, ttl time.Duration) error
+	// Load returns the session with id or ErrSessionNotFound.
+	Load(ctx context.Context, id string) (*Session, error)
+	// Delete removes the session with id, deleting a missing session is not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// Config of the sessions.
+type Config struct {
+	// CookieName name of the session cookie. Default to tkeel_session.
+	CookieName string `mapstructure:"cookie_name" json:"cookie_name" yaml:"cookieName"`
+	// Secret encryption secret of cookie sessions, not needed with a Store.
+	Secret string `mapstructure:"secret" json:"secret" yaml:"secret"`
+	// IdleTimeout ends sessions without requests for this long. Default to 30m.
+	IdleTimeout time.Duration `mapstructure:"idle_timeout" json:"idle_timeout" yaml:"idleTimeout"`
+	// AbsoluteTimeout ends sessions this long after login. Default to 12h.
+	AbsoluteTimeout time.Duration `mapstructure:"absolute_timeout" json:"absolute_timeout" yaml:"absoluteTimeout"`
+	// Domain and Path of the cookie, Path default to /.
+	Domain string `mapstructure:"domain" json:"domain" yaml:"domain"`
+	Path   string `mapstructure:"path" json:"path" yaml:"path"`
+	// Insecure drops the Secure attribute of the cookie, for local development over http only.
+	Insecure bool `mapstructure:"insecure" json:"insecure" yaml:"insecure"`
+	// SameSite attribute of the cookie. Default to lax.
+	SameSite http.SameSite `mapstructure:"same_site" json:"same_site" yaml:"sameSite"`
+}
+
+// Manager issues, loads and ends sessions.
+type Manager struct {
+	conf  Config
+	store Store
+	aead  cipher.AEAD
+	// revoked ids of logged out cookie sessions, a copied cookie stays valid otherwise.
+	revoked token.RevocationList
+	events  audit.EventSink
+}
+
+// NewManager returns a Manager keeping sessions in store, or in encrypted cookies when store is nil.
+func NewManager(conf Config, store Store) (*Manager, error) {
+	if conf.CookieName == "" {
+		conf.CookieName = _defaultCookieName
+	}
+	if conf.IdleTimeout <= 0 {
+		conf.IdleTimeout = _defaultIdleTimeout
+	}
+	if conf.AbsoluteTimeout <= 0 {
+		conf.AbsoluteTimeout = _defaultAbsoluteTimeout
+	}
+	if conf.Path == "" {
+		conf.Path = "/"
+	}
+	if conf.SameSite == 0 {
+		conf.SameSite = http.SameSiteLaxMode
+	}
+	m := &Manager{conf: conf, store: store}
+	if store == nil {
+		if conf.Secret == "" {
+			return nil, ErrSecretRequired
+		}
+		key := sha256.Sum256([]byte(conf.Secret))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, fmt.Errorf("session cipher %w", err)
+		}
+		if m.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("session cipher %w", err)
+		}
+		m.revoked = token.NewMemoryRevocationList()
+	}
+	return m, nil
+}
+
+// SetRevocationList replaces the in-process list logged out cookie sessions are recorded in,
+// replicas must share it, e.g. a token.RedisRevocationList.
+func (m *Manager) SetRevocationList(list token.RevocationList) {
+	m.revoked = list
+}
+
+// SetEventSink writes the authn.login and authn.logout events of the sessions to sink.
+func (m *Manager) SetEventSink(sink audit.EventSink) {
+	m.events = sink
+}
+
+func (m *Manager) emit(r *http.Request, typ string, s *Session) {
+	if m.events == nil || s.Claims == nil {
+		return
+	}
+	e := audit.RequestEvent(r, typ)
+	e.Actor, e.Subject, e.TenantID = s.Claims.Subject, s.Claims.Subject, s.Claims.TenantID
+	e.Target = audit.Fingerprint(s.ID)
+	e.Outcome = audit.OutcomeSuccess
+	m.events.WriteEvent(e)
+}
+
+// Load returns the valid session of r.
+func (m *Manager) Load(r *http.Request) (*Session, error) {
+	c, err := r.Cookie(m.conf.CookieName)
+	if err != nil || c.Value == "" {
+		return nil, ErrNoSession
+	}
+	ctx, cancel := utils.WithTimeout(r.Context(), 0)
+	defer cancel()
+	var s *Session
+	if m.store != nil {
+		s, err = m.store.Load(ctx, c.Value)
+	} else {
+		s, err = m.open(c.Value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.store == nil {
+		revoked, err := m.revoked.Contains(s.ID)
+		if err != nil {
+			return nil, fmt.Errorf("check session revocation %w", err)
+		}
+		if revoked {
+			return nil, ErrSessionRevoked
+		}
+	}
+	now := time.Now()
+	if now.After(time.Unix(s.AccessedAt, 0).Add(m.conf.IdleTimeout)) ||
+		now.After(time.Unix(s.CreatedAt, 0).Add(m.conf.AbsoluteTimeout)) {
+		if m.store != nil {
+			_ = m.store.Delete(ctx, s.ID)
+		}
+		return nil, ErrSessionExpired
+	}
+	return s, nil
+}
+
+// Login starts a session for claims. The session of r is ended first and the new session gets
+// a fresh id, so an id planted before login can not be used to ride the logged in session.
+func (m *Manager) Login(w http.ResponseWriter, r *http.Request, claims *token.Claims) (*Session, error) {
+	ctx, cancel := utils.WithTimeout(r.Context(), 0)
+	defer cancel()
+	if old, err := m.Load(r); err == nil {
+		if err = m.end(ctx, old); err != nil {
+			return nil, fmt.Errorf("end previous session %w", err)
+		}
+	}
+	id, err := utils.RandBase64String(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	s := &Session{ID: id, Claims: claims, Values: make(map[string]string), CreatedAt: now, AccessedAt: now}
+	if err = m.save(ctx, w, s, true); err != nil {
+		return nil, err
+	}
+	m.emit(r, audit.EventLogin, s)
+	return s, nil
+}
+
+// Save persists s and sets its cookie, call it after changing the values of s. A session ended
+// since it was loaded, e.g. by Logout or a revocation, is not stored again: Save returns
+// ErrSessionNotFound.
+func (m *Manager) Save(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	return m.save(ctx, w, s, false)
+}
+
+// save persists s, storing it when create is true and only replacing the stored session otherwise.
+func (m *Manager) save(ctx context.Context, w http.ResponseWriter, s *Session, create bool) error {
+	remaining := time.Until(time.Unix(s.CreatedAt, 0).Add(m.conf.AbsoluteTimeout))
+	if remaining <= 0 {
+		return ErrSessionExpired
+	}
+	value := s.ID
+	if m.store != nil {
+		ctx, cancel := utils.WithTimeout(ctx, 0)
+		defer cancel()
+		store := m.store.Update
+		if create {
+			store = m.store.Save
+		}
+		if err := store(ctx, s, remaining); err != nil {
+			return fmt.Errorf("save session %w", err)
+		}
+	} else {
+		sealed, err := m.seal(s)
+		if err != nil {
+			return err
+		}
+		value = sealed
+	}
+	http.SetCookie(w, m.cookie(value, int(remaining.Seconds())))
+	return nil
+}
+
+// Logout ends the session of r and clears its cookie, a copy of a cookie session is
+// rejected until the session would have expired.
+func (m *Manager) Logout(w http.ResponseWriter, r *http.Request) error {
+	s, err := m.Load(r)
+	if err == nil {
+		ctx, cancel := utils.WithTimeout(r.Context(), 0)
+		defer cancel()
+		if err = m.end(ctx, s); err != nil {
+			return err
+		}
+	}
+	http.SetCookie(w, m.cookie("", -1))
+	if s != nil {
+		m.emit(r, audit.EventLogout, s)
+	}
+	return nil
+}
+
+// end deletes the stored session s, or revokes it until its absolute timeout for cookie sessions.
+func (m *Manager) end(ctx context.Context, s *Session) error {
+	if m.store != nil {
+		if err := m.store.Delete(ctx, s.ID); err != nil {
+			return fmt.Errorf("delete session %w", err)
+		}
+		return nil
+	}
+	if err := m.revoked.Add(s.ID, time.Unix(s.CreatedAt, 0).Add(m.conf.AbsoluteTimeout)); err != nil {
+		return fmt.Errorf("revoke session %w", err)
+	}
+	return nil
+}
+
+// Middleware loads the session of requests, moves its idle deadline and makes the claims of
+// logged in sessions available through middleware.ClaimsFromContext. Requests without a valid
+// session pass unauthenticated, guard handlers with Require.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.Load(r)
+		if err != nil {
+			if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrInvalidCookie) || errors.Is(err, ErrSessionRevoked) {
+				http.SetCookie(w, m.cookie("", -1))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if now := time.Now(); now.Sub(time.Unix(s.AccessedAt, 0)) >= _touchInterval {
+			s.AccessedAt = now.Unix()
+			err = m.Save(r.Context(), w, s)
+			if errors.Is(err, ErrSessionNotFound) {
+				// revoked since it was loaded.
+				http.SetCookie(w, m.cookie("", -1))
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), sessionContextKey{}, s)
+		if s.Claims != nil {
+			ctx = middleware.WithClaims(ctx, s.Claims)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Require rejects requests without a logged in session with 401, it must run after Middleware.
+func Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s, ok := FromContext(r.Context()); !ok || s.Claims == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type sessionContextKey struct{}
+
+// FromContext returns the session Middleware loaded.
+func FromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionContextKey{}).(*Session)
+	return s, ok
+}
+
+func (m *Manager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.conf.CookieName,
+		Value:    value,
+		Path:     m.conf.Path,
+		Domain:   m.conf.Domain,
+		MaxAge:   maxAge,
+		Secure:   !m.conf.Insecure,
+		HttpOnly: true,
+		SameSite: m.conf.SameSite,
+	}
+}
+
+// seal encrypts s with AES-256-GCM, the authentication tag signs it. The cookie name is the
+// additional data, so a sealed session can not be replayed under another cookie.
+func (m *Manager) seal(s *Session) (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", fmt.Errorf("marshal session %w", err)
+	}
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("session nonce %w", err)
+	}
+	sealed := base64.RawURLEncoding.EncodeToString(m.aead.Seal(nonce, nonce, data, []byte(m.conf.CookieName)))
+	if len(m.conf.CookieName)+len(sealed)+1 > _maxCookieSize {
+		return "", ErrCookieTooLarge
+	}
+	return sealed, nil
+}
+
+func (m *Manager) open(value string) (*Session, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < m.aead.NonceSize() {
+		return nil, ErrInvalidCookie
+	}
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	data, err := m.aead.Open(nil, nonce, ciphertext, []byte(m.conf.CookieName))
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+	s := &Session{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, ErrInvalidCookie
+	}
+	return s, nil
+}
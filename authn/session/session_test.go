@@ -0,0 +1,290 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestManager(t *testing.T) {
+	cookieManager, err := NewManager(Config{Secret: "secret"}, nil)
+	assert.NoError(t, err)
+	storeManager, err := NewManager(Config{}, NewMemoryStore())
+	assert.NoError(t, err)
+	_, err = NewManager(Config{}, nil)
+	assert.ErrorIs(t, err, ErrSecretRequired)
+
+	for name, m := range map[string]*Manager{"cookie": cookieManager, "store": storeManager} {
+		t.Run(name, func(t *testing.T) {
+			h := m.Middleware(Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "alice", middleware.SubjectFromContext(r.Context()))
+				w.WriteHeader(http.StatusNoContent)
+			})))
+			serve := func(c *http.Cookie) int {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				if c != nil {
+					r.AddCookie(c)
+				}
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w.Code
+			}
+
+			w := httptest.NewRecorder()
+			s, err := m.Login(w, httptest.NewRequest(http.MethodPost, "/login", nil), &token.Claims{Subject: "alice"})
+			assert.NoError(t, err)
+			cookie := w.Result().Cookies()[0]
+			assert.True(t, cookie.HttpOnly)
+			assert.True(t, cookie.Secure)
+			assert.Equal(t, http.StatusNoContent, serve(cookie))
+			assert.Equal(t, http.StatusUnauthorized, serve(nil))
+			assert.Equal(t, http.StatusUnauthorized, serve(&http.Cookie{Name: cookie.Name, Value: cookie.Value + "x"}))
+
+			// a new login rotates the id and ends the previous session.
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+			r.AddCookie(cookie)
+			w = httptest.NewRecorder()
+			renewed, err := m.Login(w, r, &token.Claims{Subject: "alice"})
+			assert.NoError(t, err)
+			assert.NotEqual(t, s.ID, renewed.ID)
+			assert.Equal(t, http.StatusUnauthorized, serve(cookie))
+			cookie = w.Result().Cookies()[0]
+
+			idle := &Session{ID: "idle", Claims: &token.Claims{Subject: "alice"},
+				CreatedAt: time.Now().Unix(), AccessedAt: time.Now().Add(-time.Hour).Unix()}
+			absolute := &Session{ID: "absolute", Claims: &token.Claims{Subject: "alice"},
+				CreatedAt: time.Now().Add(-13 * time.Hour).Unix(), AccessedAt: time.Now().Unix()}
+			for _, expired := range []*Session{idle, absolute} {
+				value := expired.ID
+				if m.store != nil {
+					assert.NoError(t, m.store.Save(context.Background(), expired, time.Hour))
+				} else {
+					value, err = m.seal(expired)
+					assert.NoError(t, err)
+				}
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				r.AddCookie(&http.Cookie{Name: cookie.Name, Value: value})
+				_, err = m.Load(r)
+				assert.ErrorIs(t, err, ErrSessionExpired, expired.ID)
+			}
+
+			r = httptest.NewRequest(http.MethodPost, "/logout", nil)
+			r.AddCookie(cookie)
+			w = httptest.NewRecorder()
+			assert.NoError(t, m.Logout(w, r))
+			assert.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+			// a copy of the cookie kept after logout is rejected.
+			assert.Equal(t, http.StatusUnauthorized, serve(cookie))
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	m, err := NewManager(Config{}, NewMemoryStore())
+	assert.NoError(t, err)
+	sink := audit.NewMemorySink(10)
+	m.SetEventSink(sink)
+
+	w := httptest.NewRecorder()
+	s, err := m.Login(w, httptest.NewRequest(http.MethodPost, "/login", nil), &token.Claims{Subject: "alice", TenantID: "t1"})
+	assert.NoError(t, err)
+	r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	r.AddCookie(w.Result().Cookies()[0])
+	assert.NoError(t, m.Logout(httptest.NewRecorder(), r))
+	// without a session there is nothing to log out.
+	assert.NoError(t, m.Logout(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/logout", nil)))
+
+	events := sink.Events()
+	assert.Len(t, events, 2)
+	assert.Equal(t, audit.EventLogin, events[0].Type)
+	assert.Equal(t, audit.EventLogout, events[1].Type)
+	for _, e := range events {
+		assert.Equal(t, "alice", e.Actor)
+		assert.Equal(t, "t1", e.TenantID)
+		assert.Equal(t, "192.0.2.1", e.IP)
+		assert.Equal(t, audit.Fingerprint(s.ID), e.Target)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	m, err := NewManager(Config{Secret: "secret"}, nil)
+	assert.NoError(t, err)
+	csrf := m.CSRF(CSRFConfig{Exempt: []string{"/hooks/"}})
+	h := m.Middleware(csrf.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		csrf.SetHeader(w, r)
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// double-submit cookie without a session.
+	w := serve(httptest.NewRequest(http.MethodGet, "/", nil))
+	csrfCookie := w.Result().Cookies()[0]
+	assert.Equal(t, "tkeel_csrf", csrfCookie.Name)
+	assert.Equal(t, csrfCookie.Value, w.Header().Get("X-CSRF-Token"))
+
+	// synchronizer token of a session.
+	w = httptest.NewRecorder()
+	_, err = m.Login(w, httptest.NewRequest(http.MethodPost, "/login", nil), &token.Claims{Subject: "alice"})
+	assert.NoError(t, err)
+	sessionCookie := w.Result().Cookies()[0]
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(sessionCookie)
+	w = serve(r)
+	sessionToken := w.Header().Get("X-CSRF-Token")
+	assert.NotEmpty(t, sessionToken)
+	sessionCookie = w.Result().Cookies()[0]
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		header map[string]string
+		path   string
+		status int
+	}{
+		{"cookie", csrfCookie, map[string]string{"X-CSRF-Token": csrfCookie.Value}, "/", http.StatusNoContent},
+		{"session", sessionCookie, map[string]string{"X-CSRF-Token": sessionToken}, "/", http.StatusNoContent},
+		{"missing", sessionCookie, nil, "/", http.StatusForbidden},
+		{"other token", sessionCookie, map[string]string{"X-CSRF-Token": csrfCookie.Value}, "/", http.StatusForbidden},
+		{"origin", sessionCookie, map[string]string{"X-CSRF-Token": sessionToken, "Origin": "https://evil.example"},
+			"/", http.StatusForbidden},
+		{"exempt", sessionCookie, nil, "/hooks/github", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			r.AddCookie(tt.cookie)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.status, serve(r).Code)
+		})
+	}
+}
+
+func TestStores(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormStore(db)
+	assert.NoError(t, err)
+	redisStore := NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "")
+
+	now := time.Now().Unix()
+	newSession := func(id, subject, tenantID string, created int64) *Session {
+		return &Session{ID: id, Claims: &token.Claims{Subject: subject, TenantID: tenantID},
+			Values: map[string]string{"k": "v"}, CreatedAt: created, AccessedAt: created}
+	}
+	stores := map[string]BatchStore{"memory": NewMemoryStore(), "gorm": gormStore, "redis": redisStore}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			for _, s := range []*Session{
+				newSession("a1", "alice", "t1", now-2),
+				newSession("a2", "alice", "t1", now-1),
+				newSession("b1", "bob", "t2", now),
+				{ID: "anonymous", CreatedAt: now, AccessedAt: now},
+			} {
+				assert.NoError(t, store.Save(context.Background(), s, time.Hour))
+			}
+			loaded, err := store.Load(context.Background(), "a1")
+			assert.NoError(t, err)
+			assert.Equal(t, "alice", loaded.Claims.Subject)
+			assert.Equal(t, "v", loaded.Values["k"])
+			loaded.Values["k"] = "w"
+			assert.NoError(t, store.Save(context.Background(), loaded, time.Hour))
+			loaded, err = store.Load(context.Background(), "a1")
+			assert.NoError(t, err)
+			assert.Equal(t, "w", loaded.Values["k"])
+			_, err = store.Load(context.Background(), "missing")
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+
+			all, err := store.ListSessions("", "")
+			assert.NoError(t, err)
+			assert.Len(t, all, 3, "sessions before login are not listed")
+			alice, err := store.ListSessions("t1", "alice")
+			assert.NoError(t, err)
+			if assert.Len(t, alice, 2) {
+				assert.Equal(t, "a1", alice[0].ID)
+			}
+
+			assert.NoError(t, store.Update(context.Background(), loaded, time.Hour))
+			a2, err := store.Load(context.Background(), "a2")
+			assert.NoError(t, err)
+			assert.NoError(t, store.Delete(context.Background(), "a2"))
+			assert.NoError(t, store.Delete(context.Background(), "a2"))
+			// an update after a revocation does not bring the session back.
+			assert.ErrorIs(t, store.Update(context.Background(), a2, time.Hour), ErrSessionNotFound)
+			_, err = store.Load(context.Background(), "a2")
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+			// the same subject in another tenant is another user.
+			assert.NoError(t, store.RevokeSubject("t2", "alice"))
+			_, err = store.Load(context.Background(), "a1")
+			assert.NoError(t, err)
+			assert.NoError(t, store.RevokeSubject("t1", "alice"))
+			_, err = store.Load(context.Background(), "a1")
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+			assert.NoError(t, store.RevokeTenant("t2"))
+			_, err = store.Load(context.Background(), "b1")
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+			_, err = store.Load(context.Background(), "anonymous")
+			assert.NoError(t, err)
+		})
+	}
+
+	// sessions expire with their ttl.
+	assert.NoError(t, redisStore.Save(context.Background(), newSession("short", "carol", "t1", now), time.Second))
+	mr.FastForward(2 * time.Second)
+	_, err = redisStore.Load(context.Background(), "short")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	assert.NoError(t, gormStore.Save(context.Background(), newSession("short", "carol", "t1", now), -time.Second))
+	_, err = gormStore.Load(context.Background(), "short")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	purged, err := gormStore.Purge()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	// move the sessions of the memory store to redis keeping their expiry.
+	src := NewMemoryStore()
+	assert.NoError(t, src.Save(context.Background(), newSession("m1", "dave", "t1", now), time.Hour))
+	assert.NoError(t, src.Save(context.Background(), newSession("m2", "erin", "t1", now), time.Hour))
+	n, err := Migrate(context.Background(), redisStore, src)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	migrated, err := redisStore.ListSessions("t1", "")
+	assert.NoError(t, err)
+	assert.Len(t, migrated, 2)
+	assert.InDelta(t, time.Hour.Seconds(), mr.TTL("session:id:m1").Seconds(), 5)
+	n, err = Migrate(context.Background(), gormStore, redisStore)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n, "the anonymous session moves too")
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package session
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authz/audit"
+)
+
+var (
+	_ BatchStore = &MemoryStore{}
+	_ Exporter   = &MemoryStore{}
+)
+
+// BatchStore a Store ending and listing the sessions of subjects and tenants.
+type BatchStore interface {
+	Store
+	// RevokeSubject deletes all sessions of subject in the tenant, e.g. after a password reset.
+	RevokeSubject(tenantID, subject string) error
+	// RevokeTenant deletes all sessions of the tenant, e.g. when it is offboarded.
+	RevokeTenant(tenantID string) error
+	// ListSessions returns the live logged in sessions of the tenant, of subject unless it is
+	// empty, oldest first. Empty tenantID lists all tenants.
+	ListSessions(tenantID, subject string) ([]*Session, error)
+}
+
+// Exporter a Store enumerating its live sessions with their expiry, the source of Migrate.
+type Exporter interface {
+	Export(fn func(s *Session, expireAt time.Time) error) error
+}
+
+// Migrate copies the live sessions of src to dst keeping their expiry and returns how many were
+// copied. Run it while switching backends, before the replicas move to dst.
+func Migrate(ctx context.Context, dst Store, src Exporter) (int, error) {
+	n := 0
+	err := src.Export(func(s *Session, expireAt time.Time) error {
+		ttl := time.Until(expireAt)
+		if ttl <= 0 {
+			return nil
+		}
+		if err := dst.Save(ctx, s, ttl); err != nil {
+			return fmt.Errorf("migrate session %s %w", audit.Fingerprint(s.ID), err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+type memoryEntry struct {
+	session  Session
+	expireAt time.Time
+}
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock    sync.RWMutex
+	entries map[string]memoryEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry)}
+}
+
+func (s *MemoryStore) Save(_ context.Context, session *Session, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for id, entry := range s.entries {
+		if now.After(entry.expireAt) {
+			delete(s.entries, id)
+		}
+	}
+	s.entries[session.ID] = memoryEntry{session: copySession(session), expireAt: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Update(_ context.Context, session *Session, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if entry, ok := s.entries[session.ID]; !ok || now.After(entry.expireAt) {
+		return ErrSessionNotFound
+	}
+	s.entries[session.ID] = memoryEntry{session: copySession(session), expireAt: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Load(_ context.Context, id string) (*Session, error) {
+	s.lock.RLock()
+	entry, ok := s.entries[id]
+	s.lock.RUnlock()
+	if !ok || time.Now().After(entry.expireAt) {
+		return nil, ErrSessionNotFound
+	}
+	session := copySession(&entry.session)
+	return &session, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+// RevokeSubject deletes all sessions of subject in the tenant, e.g. after a password reset.
+func (s *MemoryStore) RevokeSubject(tenantID, subject string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, entry := range s.entries {
+		if c := entry.session.Claims; c != nil && c.TenantID == tenantID && c.Subject == subject {
+			delete(s.entries, id)
+		}
+	}
+	return nil
+}
+
+// RevokeTenant deletes all sessions of the tenant, e.g. when it is offboarded.
+func (s *MemoryStore) RevokeTenant(tenantID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, entry := range s.entries {
+		if entry.session.Claims != nil && entry.session.Claims.TenantID == tenantID {
+			delete(s.entries, id)
+		}
+	}
+	return nil
+}
+
+// ListSessions returns the live sessions of the tenant, of subject unless it is empty,
+// oldest first. Empty tenantID lists all tenants.
+func (s *MemoryStore) ListSessions(tenantID, subject string) ([]*Session, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	now := time.Now()
+	sessions := make([]*Session, 0)
+	for _, entry := range s.entries {
+		c := entry.session.Claims
+		if c == nil || now.After(entry.expireAt) ||
+			(tenantID != "" && c.TenantID != tenantID) || (subject != "" && c.Subject != subject) {
+			continue
+		}
+		session := copySession(&entry.session)
+		sessions = append(sessions, &session)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt < sessions[j].CreatedAt })
+	return sessions, nil
+}
+
+func (s *MemoryStore) Export(fn func(s *Session, expireAt time.Time) error) error {
+	s.lock.RLock()
+	entries := make([]memoryEntry, 0, len(s.entries))
+	now := time.Now()
+	for _, entry := range s.entries {
+		if now.Before(entry.expireAt) {
+			entries = append(entries, entry)
+		}
+	}
+	s.lock.RUnlock()
+	for _, entry := range entries {
+		session := copySession(&entry.session)
+		if err := fn(&session, entry.expireAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySession copies the values of s, so callers changing them do not race the store.
+func copySession(s *Session) Session {
+	c := *s
+	c.Values = make(map[string]string, len(s.Values))
+	for k, v := range s.Values {
+		c.Values[k] = v
+	}
+	return c
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package signing signs and verifies HMAC-SHA256 signed requests, for webhooks and devices
+// that can not use OAuth.
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a signed request.
+const (
+	HeaderKeyID     = "X-Signature-Key-Id"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderNonce     = "X-Signature-Nonce"
+	HeaderSignature = "X-Signature"
+
+	_defaultWindow      = 5 * time.Minute
+	_defaultMaxBodySize = 10 << 20
+	_replaySweepEvery   = time.Minute
+)
+
+var (
+	// ErrMissingSignature the request is not signed.
+	ErrMissingSignature = errors.New("missing request signature")
+	// ErrInvalidSignature the signature does not match the request.
+	ErrInvalidSignature = errors.New("invalid request signature")
+	// ErrUnknownKey no secret is known for the key id.
+	ErrUnknownKey = errors.New("unknown signing key")
+	// ErrStaleSignature the timestamp is outside the replay window.
+	ErrStaleSignature = errors.New("request signature outside the replay window")
+	// ErrReplayed the nonce was used before.
+	ErrReplayed = errors.New("request signature replayed")
+	// ErrBodyTooLarge the body exceeds the size signatures are verified for.
+	ErrBodyTooLarge = errors.New("signed request body too large")
+)
+
+// KeyStore looks up the shared secrets of key ids.
+type KeyStore interface {
+	// Secret returns the secret of keyID or ErrUnknownKey.
+	Secret(keyID string) ([]byte, error)
+}
+
+// StaticKeys KeyStore of configured secrets by key id.
+type StaticKeys map[string]string
+
+func (k StaticKeys) Secret(keyID string) ([]byte, error) {
+	secret, ok := k[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return []byte(secret), nil
+}
+
+// ReplayCache remembers the nonces of accepted requests.
+type ReplayCache interface {
+	// Seen records key until expiresAt and reports whether it was recorded before.
+	Seen(key string, expiresAt time.Time) bool
+}
+
+// MemoryReplayCache in-process ReplayCache, expired nonces are swept at most once a minute.
+type MemoryReplayCache struct {
+	lock      sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{seen: make(map[string]time.Time), lastSweep: time.Now()}
+}
+
+func (c *MemoryReplayCache) Seen(key string, expiresAt time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if exp, ok := c.seen[key]; ok && now.Before(exp) {
+		return true
+	}
+	c.sweep(now)
+	c.seen[key] = expiresAt
+	return false
+}
+
+func (c *MemoryReplayCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < _replaySweepEvery {
+		return
+	}
+	c.lastSweep = now
+	for k, exp := range c.seen {
+		if !now.Before(exp) {
+			delete(c.seen, k)
+		}
+	}
+}
+
+// Config of the verification.
+type Config struct {
+	// Window tolerated difference between the timestamp and the server clock. Default to 5m.
+	Window time.Duration `mapstructure:"window" json:"window" yaml:"window"`
+	// MaxBodySize largest body verified. Default to 10MiB.
+	MaxBodySize int64 `mapstructure:"max_body_size" json:"max_body_size" yaml:"maxBodySize"`
+}
+
+// Verifier checks the signatures of requests.
+type Verifier struct {
+	keys   KeyStore
+	conf   Config
+	replay ReplayCache
+}
+
+// NewVerifier returns a Verifier, replay defaults to a MemoryReplayCache.
+func NewVerifier(keys KeyStore, conf Config, replay ReplayCache) *Verifier {
+	if conf.Window <= 0 {
+		conf.Window = _defaultWindow
+	}
+	if conf.MaxBodySize <= 0 {
+		conf.MaxBodySize = _defaultMaxBodySize
+	}
+	if replay == nil {
+		replay = NewMemoryReplayCache()
+	}
+	return &Verifier{keys: keys, conf: conf, replay: replay}
+}
+
+// Verify checks the signature of r and returns its key id, the body of r stays readable.
+func (v *Verifier) Verify(r *http.Request) (string, error) {
+	keyID, timestamp := r.Header.Get(HeaderKeyID), r.Header.Get(HeaderTimestamp)
+	nonce, signature := r.Header.Get(HeaderNonce), r.Header.Get(HeaderSignature)
+	if keyID == "" || timestamp == "" || nonce == "" || signature == "" {
+		return "", ErrMissingSignature
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+	}
+	signedAt := time.Unix(sec, 0)
+	if d := time.Since(signedAt); d > v.conf.Window || d < -v.conf.Window {
+		return "", ErrStaleSignature
+	}
+	secret, err := v.keys.Secret(keyID)
+	if err != nil {
+		return "", err
+	}
+	body, err := readBody(r, v.conf.MaxBodySize)
+	if err != nil {
+		return "", err
+	}
+	expected := Sign(secret, CanonicalRequest(r.Method, r.URL, timestamp, nonce, body))
+	got, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, expected) {
+		return "", ErrInvalidSignature
+	}
+	// only verified signatures reach the cache, so forged requests can not burn nonces.
+	if v.replay.Seen(keyID+":"+nonce, signedAt.Add(v.conf.Window)) {
+		return "", ErrReplayed
+	}
+	return keyID, nil
+}
+
+// Middleware rejects requests without a valid signature with 401, the key id is available to
+// next through KeyIDFromContext.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyID, err := v.Verify(r)
+		switch {
+		case errors.Is(err, ErrBodyTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		default:
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), keyIDContextKey{}, keyID)))
+		}
+	})
+}
+
+type keyIDContextKey struct{}
+
+// KeyIDFromContext returns the key id of the request Middleware verified.
+func KeyIDFromContext(ctx context.Context) (string, bool) {
+	keyID, ok := ctx.Value(keyIDContextKey{}).(string)
+	return keyID, ok
+}
+
+// CanonicalRequest returns the signed string of a request: the method, the escaped path, the
+// query sorted by key, the timestamp, the nonce and the hex SHA-256 of the body, joined by newlines.
+func CanonicalRequest(method string, u *url.URL, timestamp, nonce string, body []byte) string {
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, url.QueryEscape(k)+"="+url.QueryEscape(value))
+		}
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method), path, strings.Join(pairs, "&"), timestamp, nonce, hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+// Sign returns the HMAC-SHA256 of canonical under secret.
+func Sign(secret []byte, canonical string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(canonical))
+	return mac.Sum(nil)
+}
+
+// readBody returns the body of r and replaces it with a reader of the returned bytes.
+func readBody(r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read body %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
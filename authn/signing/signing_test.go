@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signing
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	v := NewVerifier(StaticKeys{"device-1": "secret"}, Config{MaxBodySize: 64}, nil)
+	signer := NewSigner("device-1", "secret")
+	signed := func(body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/v1/telemetry?b=2&a=1", strings.NewReader(body))
+		assert.NoError(t, signer.Sign(r))
+		return r
+	}
+
+	r := signed(`{"temp":21}`)
+	keyID, err := v.Verify(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "device-1", keyID)
+	body, err := ioutil.ReadAll(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"temp":21}`, string(body))
+
+	tests := []struct {
+		name   string
+		tamper func(r *http.Request)
+		err    error
+	}{
+		{"replayed", func(r *http.Request) { _, _ = v.Verify(r) }, ErrReplayed},
+		{"body", func(r *http.Request) { r.Body = ioutil.NopCloser(strings.NewReader(`{"temp":99}`)) }, ErrInvalidSignature},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "a=1" }, ErrInvalidSignature},
+		{"method", func(r *http.Request) { r.Method = http.MethodPut }, ErrInvalidSignature},
+		{"stale", func(r *http.Request) {
+			r.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+		}, ErrStaleSignature},
+		{"key", func(r *http.Request) { r.Header.Set(HeaderKeyID, "device-2") }, ErrUnknownKey},
+		{"unsigned", func(r *http.Request) { r.Header.Del(HeaderSignature) }, ErrMissingSignature},
+		{"too large", func(r *http.Request) {
+			r.Body = ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 65)))
+		}, ErrBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := signed(`{"temp":21}`)
+			tt.tamper(r)
+			_, err := v.Verify(r)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	c := NewMemoryReplayCache()
+	assert.False(t, c.Seen("k1:a", time.Now().Add(-time.Second)))
+	assert.False(t, c.Seen("k1:a", time.Now().Add(time.Minute)), "expired nonces are not replays")
+	assert.True(t, c.Seen("k1:a", time.Now().Add(time.Minute)))
+	assert.False(t, c.Seen("k1:b", time.Now().Add(-time.Second)))
+	assert.Len(t, c.seen, 2, "no sweep within a minute")
+
+	c.lastSweep = time.Now().Add(-_replaySweepEvery)
+	assert.False(t, c.Seen("k1:c", time.Now().Add(time.Minute)))
+	assert.Len(t, c.seen, 2)
+}
+
+func TestTransport(t *testing.T) {
+	v := NewVerifier(StaticKeys{"hook": "secret"}, Config{}, nil)
+	srv := httptest.NewServer(v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyID, _ := KeyIDFromContext(r.Context())
+		_, _ = w.Write([]byte(keyID))
+	})))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{Signer: NewSigner("hook", "secret")}}
+	resp, err := client.Post(srv.URL+"/hooks/device", "application/json", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hook", string(body))
+
+	resp, err = http.Post(srv.URL+"/hooks/device", "application/json", strings.NewReader(`{}`))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
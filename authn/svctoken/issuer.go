@@ -0,0 +1,147 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package svctoken issues short-lived, audience-bound tokens for calls between plugins, where
+// running an OAuth flow is overkill. Tokens are JWTs signed with a shared HMAC secret or with the
+// Ed25519 keys of a keyset, whose public half callees fetch from the JWKS of the caller.
+package svctoken
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authn/token/keyset"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	_defaultTTL    = time.Minute
+	_defaultMaxTTL = 5 * time.Minute
+	_defaultLeeway = 5 * time.Second
+	// _reuseFraction of the lifetime a cached token is handed out for.
+	_reuseFraction = 0.5
+)
+
+var (
+	// ErrAudienceMismatch the token was issued for another service.
+	ErrAudienceMismatch = errors.New("service token audience mismatch")
+	// ErrIssuerNotAllowed the calling service is not allowed.
+	ErrIssuerNotAllowed = errors.New("service token issuer not allowed")
+	// ErrLifetimeTooLong the token lives longer than service tokens may.
+	ErrLifetimeTooLong = errors.New("service token lifetime too long")
+	// ErrSecretOrKeysRequired neither a secret nor a keyset is configured.
+	ErrSecretOrKeysRequired = errors.New("service token secret or keyset required")
+)
+
+// IssuerConfig of the calling service.
+type IssuerConfig struct {
+	// Service name of the calling service, the iss and sub of its tokens.
+	Service string `mapstructure:"service" json:"service" yaml:"service"`
+	// Secret shared HMAC secret, ignored when issuing with a keyset.
+	Secret string `mapstructure:"secret" json:"secret" yaml:"secret"`
+	// TTL of the tokens. Default to 1m.
+	TTL time.Duration `mapstructure:"ttl" json:"ttl" yaml:"ttl"`
+}
+
+type cached struct {
+	token   string
+	renewAt time.Time
+}
+
+// Issuer signs the tokens of the calling service, reusing a token per audience for half its life.
+type Issuer struct {
+	conf IssuerConfig
+	keys *keyset.Manager
+
+	lock  sync.Mutex
+	cache map[string]cached
+}
+
+// NewIssuer returns an Issuer signing with the active key of keys, or with conf.Secret when keys
+// is nil. Create keys with the EdDSA algorithm and serve keyset.JWKSHandler for the callees.
+func NewIssuer(conf IssuerConfig, keys *keyset.Manager) (*Issuer, error) {
+	if keys == nil && conf.Secret == "" {
+		return nil, ErrSecretOrKeysRequired
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = _defaultTTL
+	}
+	return &Issuer{conf: conf, keys: keys, cache: make(map[string]cached)}, nil
+}
+
+// Issue returns a token for calls to the audience service.
+func (i *Issuer) Issue(audience string) (string, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	now := time.Now()
+	if c, ok := i.cache[audience]; ok && now.Before(c.renewAt) {
+		return c.token, nil
+	}
+	id, err := utils.RandBase64String(16)
+	if err != nil {
+		return "", err
+	}
+	claims := &token.Claims{
+		ID:        id,
+		Issuer:    i.conf.Service,
+		Subject:   i.conf.Service,
+		Audience:  audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(i.conf.TTL).Unix(),
+	}
+	var signed string
+	if i.keys == nil {
+		signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(i.conf.Secret))
+	} else {
+		key := i.keys.SigningKey()
+		t := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+		t.Header["kid"] = key.ID
+		signed, err = t.SignedString(key.Signer)
+	}
+	if err != nil {
+		return "", fmt.Errorf("sign service token %w", err)
+	}
+	i.cache[audience] = cached{token: signed, renewAt: now.Add(time.Duration(float64(i.conf.TTL) * _reuseFraction))}
+	return signed, nil
+}
+
+// Transport returns a RoundTripper authenticating requests to the audience service with
+// tokens of i, base defaults to http.DefaultTransport.
+func (i *Issuer) Transport(audience string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{issuer: i, audience: audience, base: base}
+}
+
+type transport struct {
+	issuer   *Issuer
+	audience string
+	base     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	raw, err := t.issuer.Issue(t.audience)
+	if err != nil {
+		return nil, err
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+raw)
+	return t.base.RoundTrip(r)
+}
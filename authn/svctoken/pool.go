@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package svctoken
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/tkeel-io/security/authn/token"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var _ token.Verifier = &Pool{}
+
+// Pool verifies the tokens of many calling services, each signed with the keys published at the
+// JWKS URL of its issuer. The verifier of an issuer is created on its first token and shared by
+// the later ones, so a burst of logins fetches the keys of an issuer once.
+type Pool struct {
+	conf   VerifierConfig
+	urls   map[string]string
+	client *http.Client
+
+	lock      sync.RWMutex
+	verifiers map[string]*Verifier
+}
+
+// NewPool returns a Pool of the issuers of jwksURLs, keyed by service name. Tokens of other
+// issuers are rejected with ErrIssuerNotAllowed.
+func NewPool(conf VerifierConfig, jwksURLs map[string]string, client *http.Client) *Pool {
+	urls := make(map[string]string, len(jwksURLs))
+	for issuer, url := range jwksURLs {
+		urls[issuer] = url
+	}
+	return &Pool{conf: conf, urls: urls, client: client, verifiers: make(map[string]*Verifier)}
+}
+
+// Verify checks raw with the keys of the issuer it names, so a service can not sign for another.
+func (p *Pool) Verify(raw string) (*token.Claims, error) {
+	claims := &token.Claims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(raw, claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", token.ErrInvalidToken, err)
+	}
+	v, err := p.verifier(claims.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return v.Verify(raw)
+}
+
+func (p *Pool) verifier(issuer string) (*Verifier, error) {
+	p.lock.RLock()
+	v, ok := p.verifiers[issuer]
+	p.lock.RUnlock()
+	if ok {
+		return v, nil
+	}
+	url, ok := p.urls[issuer]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", issuer, ErrIssuerNotAllowed)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if v, ok = p.verifiers[issuer]; !ok {
+		v = NewVerifier(p.conf, NewRemoteKeySet(url, p.client))
+		p.verifiers[issuer] = v
+	}
+	return v, nil
+}
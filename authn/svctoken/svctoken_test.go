@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package svctoken
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authn/token/keyset"
+	"github.com/tkeel-io/security/cache"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHMAC(t *testing.T) {
+	_, err := NewIssuer(IssuerConfig{Service: "rule"}, nil)
+	assert.ErrorIs(t, err, ErrSecretOrKeysRequired)
+	issuer, err := NewIssuer(IssuerConfig{Service: "rule", Secret: "shared"}, nil)
+	assert.NoError(t, err)
+	raw, err := issuer.Issue("device")
+	assert.NoError(t, err)
+	again, err := issuer.Issue("device")
+	assert.NoError(t, err)
+	assert.Equal(t, raw, again)
+
+	claims, err := NewVerifier(VerifierConfig{Service: "device", AllowedIssuers: []string{"rule"}}, HMACSecret("shared")).Verify(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "rule", claims.Subject)
+
+	tests := []struct {
+		name     string
+		verifier *Verifier
+		err      error
+	}{
+		{"wrong audience", NewVerifier(VerifierConfig{Service: "core"}, HMACSecret("shared")), ErrAudienceMismatch},
+		{"wrong issuer", NewVerifier(VerifierConfig{Service: "device", AllowedIssuers: []string{"auth"}}, HMACSecret("shared")), ErrIssuerNotAllowed},
+		{"wrong secret", NewVerifier(VerifierConfig{Service: "device"}, HMACSecret("other")), token.ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.verifier.Verify(raw)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	now := time.Now()
+	long, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &token.Claims{
+		Issuer: "rule", Audience: "device", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix(),
+	}).SignedString([]byte("shared"))
+	assert.NoError(t, err)
+	_, err = NewVerifier(VerifierConfig{Service: "device"}, HMACSecret("shared")).Verify(long)
+	assert.ErrorIs(t, err, ErrLifetimeTooLong)
+}
+
+func TestKeySet(t *testing.T) {
+	keys, err := keyset.New(keyset.Config{Algorithm: keyset.AlgorithmEdDSA}, nil)
+	assert.NoError(t, err)
+	issuer, err := NewIssuer(IssuerConfig{Service: "rule"}, keys)
+	assert.NoError(t, err)
+	jwks := httptest.NewServer(keyset.JWKSHandler(keys))
+	defer jwks.Close()
+
+	var seen string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get("Authorization")
+	}))
+	defer api.Close()
+	resp, err := (&http.Client{Transport: issuer.Transport("device", nil)}).Get(api.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	raw := seen[len("Bearer "):]
+
+	for name, source := range map[string]KeySource{"local": &KeySetSource{Keys: keys}, "remote": NewRemoteKeySet(jwks.URL, nil)} {
+		t.Run(name, func(t *testing.T) {
+			claims, err := NewVerifier(VerifierConfig{Service: "device"}, source).Verify(raw)
+			assert.NoError(t, err)
+			assert.Equal(t, "rule", claims.Issuer)
+		})
+	}
+
+	// an HS256 token signed with the public key bytes must not pass as EdDSA.
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &token.Claims{Audience: "device"}).SignedString([]byte("x"))
+	assert.NoError(t, err)
+	_, err = NewVerifier(VerifierConfig{Service: "device"}, &KeySetSource{Keys: keys}).Verify(forged)
+	assert.ErrorIs(t, err, token.ErrInvalidToken)
+}
+
+func TestRemoteKeySetCache(t *testing.T) {
+	keys, err := keyset.New(keyset.Config{Algorithm: keyset.AlgorithmEdDSA}, nil)
+	assert.NoError(t, err)
+	kid := keys.SigningKey().ID
+	fetches := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		keyset.JWKSHandler(keys).ServeHTTP(w, r)
+	}))
+	defer jwks.Close()
+
+	shared := cache.NewLRU(0)
+	first := NewRemoteKeySet(jwks.URL, nil)
+	first.SetCache(shared, 0)
+	_, _, err = first.Key(context.Background(), kid)
+	assert.NoError(t, err)
+
+	// another replica finds the key set in the cache.
+	second := NewRemoteKeySet(jwks.URL, nil)
+	second.SetCache(shared, 0)
+	_, _, err = second.Key(context.Background(), kid)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fetches)
+}
+
+func TestPool(t *testing.T) {
+	keys, err := keyset.New(keyset.Config{Algorithm: keyset.AlgorithmEdDSA}, nil)
+	assert.NoError(t, err)
+	issuer, err := NewIssuer(IssuerConfig{Service: "rule"}, keys)
+	assert.NoError(t, err)
+	raw, err := issuer.Issue("device")
+	assert.NoError(t, err)
+	var fetches int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(20 * time.Millisecond)
+		keyset.JWKSHandler(keys).ServeHTTP(w, r)
+	}))
+	defer jwks.Close()
+
+	pool := NewPool(VerifierConfig{Service: "device"}, map[string]string{"rule": jwks.URL}, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claims, err := pool.Verify(raw)
+			assert.NoError(t, err)
+			assert.Equal(t, "rule", claims.Issuer)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "a burst fetches the keys once")
+
+	other, err := NewIssuer(IssuerConfig{Service: "alarm"}, keys)
+	assert.NoError(t, err)
+	raw, err = other.Issue("device")
+	assert.NoError(t, err)
+	_, err = pool.Verify(raw)
+	assert.ErrorIs(t, err, ErrIssuerNotAllowed)
+	_, err = pool.Verify("garbage")
+	assert.ErrorIs(t, err, token.ErrInvalidToken)
+}
@@ -0,0 +1,294 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package svctoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authn/token/keyset"
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/golang-jwt/jwt"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// _minRefreshInterval bounds the JWKS fetches tokens with unknown kids can cause.
+const _minRefreshInterval = 30 * time.Second
+
+const _defaultJWKSCacheTTL = 10 * time.Minute
+
+var (
+	_ token.Verifier = &Verifier{}
+
+	_ KeySource = HMACSecret("")
+	_ KeySource = &KeySetSource{}
+	_ KeySource = &RemoteKeySet{}
+
+	// ErrUnknownKey no key with the kid of the token.
+	ErrUnknownKey = errors.New("unknown service token key")
+)
+
+// KeySource resolves the verification key of a token.
+type KeySource interface {
+	// Key returns the key of kid and the algorithm it verifies.
+	Key(ctx context.Context, kid string) (key interface{}, alg string, err error)
+}
+
+// HMACSecret the secret shared by all services.
+type HMACSecret string
+
+func (s HMACSecret) Key(_ context.Context, _ string) (interface{}, string, error) {
+	return []byte(s), jwt.SigningMethodHS256.Alg(), nil
+}
+
+// KeySetSource the keys of a keyset of this process.
+type KeySetSource struct {
+	Keys *keyset.Manager
+}
+
+func (s *KeySetSource) Key(_ context.Context, kid string) (interface{}, string, error) {
+	key, err := s.Keys.Key(kid)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", kid, ErrUnknownKey)
+	}
+	return key.Public(), key.Algorithm, nil
+}
+
+// RemoteKeySet the keys a calling service publishes at its JWKS URL, refetched when a token
+// names an unknown key. Concurrent tokens with unknown keys share a single fetch.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+	flight utils.SingleFlight
+
+	lock      sync.RWMutex
+	keys      map[string]jose.JSONWebKey
+	fetchedAt time.Time
+
+	cache    cache.Cache
+	cacheTTL time.Duration
+	logger   *log.Helper
+}
+
+// NewRemoteKeySet returns a RemoteKeySet of url, client defaults to utils.DefaultHTTPClient.
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = utils.DefaultHTTPClient
+	}
+	return &RemoteKeySet{url: url, client: client}
+}
+
+// SetLogger writes the entries of the key fetches to l instead of the global logger.
+func (s *RemoteKeySet) SetLogger(l log.Logger) {
+	s.logger = log.NewHelper(l)
+}
+
+// SetCache shares the fetched key set through c for ttl, so replicas and restarts look it up
+// there before fetching it again. ttl <= 0 defaults to 10m.
+func (s *RemoteKeySet) SetCache(c cache.Cache, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = _defaultJWKSCacheTTL
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cache, s.cacheTTL = c, ttl
+}
+
+func (s *RemoteKeySet) Key(ctx context.Context, kid string) (interface{}, string, error) {
+	if key, ok := s.lookup(kid); ok {
+		return key.Key, key.Algorithm, nil
+	}
+	if _, err := s.flight.Do(s.url, func() (interface{}, error) {
+		return nil, s.refresh(ctx, kid)
+	}); err != nil {
+		return nil, "", err
+	}
+	if key, ok := s.lookup(kid); ok {
+		return key.Key, key.Algorithm, nil
+	}
+	return nil, "", fmt.Errorf("%s: %w", kid, ErrUnknownKey)
+}
+
+func (s *RemoteKeySet) lookup(kid string) (jose.JSONWebKey, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// refresh loads the key set from the cache when it holds kid, or fetches it at most once per
+// _minRefreshInterval.
+func (s *RemoteKeySet) refresh(ctx context.Context, kid string) error {
+	s.lock.Lock()
+	c := s.cache
+	s.lock.Unlock()
+	if c != nil {
+		if data, err := c.Get(ctx, s.cacheKey()); err == nil {
+			if keys, err := parseKeySet(data); err == nil {
+				if _, ok := keys[kid]; ok {
+					s.setKeys(keys)
+					return nil
+				}
+			}
+		}
+	}
+	s.lock.Lock()
+	if time.Since(s.fetchedAt) < _minRefreshInterval {
+		s.lock.Unlock()
+		return nil
+	}
+	s.fetchedAt = time.Now()
+	s.lock.Unlock()
+	return s.fetch(ctx)
+}
+
+func (s *RemoteKeySet) setKeys(keys map[string]jose.JSONWebKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys = keys
+}
+
+func (s *RemoteKeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("jwks request %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch jwks %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("read jwks %w", err)
+	}
+	keys, err := parseKeySet(data)
+	if err != nil {
+		return err
+	}
+	s.setKeys(keys)
+	s.lock.RLock()
+	c, ttl := s.cache, s.cacheTTL
+	s.lock.RUnlock()
+	if c != nil {
+		if err = c.Set(ctx, s.cacheKey(), data, ttl); err != nil {
+			s.logger.Warnf("cache jwks of %s %s", s.url, err)
+		}
+	}
+	return nil
+}
+
+func (s *RemoteKeySet) cacheKey() string {
+	return "jwks:" + s.url
+}
+
+func parseKeySet(data []byte) (map[string]jose.JSONWebKey, error) {
+	var set jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("decode jwks %w", err)
+	}
+	keys := make(map[string]jose.JSONWebKey, len(set.Keys))
+	for _, k := range set.Keys {
+		// only public signing keys, a published secret key would be a misconfiguration.
+		if k.IsPublic() && (k.Use == "" || k.Use == "sig") {
+			keys[k.KeyID] = k
+		}
+	}
+	return keys, nil
+}
+
+// VerifierConfig of the called service.
+type VerifierConfig struct {
+	// Service name of the called service, tokens must carry it as audience.
+	Service string `mapstructure:"service" json:"service" yaml:"service"`
+	// AllowedIssuers calling services accepted, empty accepts any service holding a valid key.
+	AllowedIssuers []string `mapstructure:"allowed_issuers" json:"allowed_issuers" yaml:"allowedIssuers"`
+	// MaxTTL longest lifetime accepted. Default to 5m.
+	MaxTTL time.Duration `mapstructure:"max_ttl" json:"max_ttl" yaml:"maxTtl"`
+	// Leeway clock skew tolerated. Default to 5s.
+	Leeway time.Duration `mapstructure:"leeway" json:"leeway" yaml:"leeway"`
+}
+
+// Verifier checks the tokens of calling services, satisfies token.Verifier.
+type Verifier struct {
+	conf VerifierConfig
+	keys KeySource
+}
+
+func NewVerifier(conf VerifierConfig, keys KeySource) *Verifier {
+	if conf.MaxTTL <= 0 {
+		conf.MaxTTL = _defaultMaxTTL
+	}
+	if conf.Leeway <= 0 {
+		conf.Leeway = _defaultLeeway
+	}
+	return &Verifier{conf: conf, keys: keys}
+}
+
+// Verify returns the claims of raw, the subject is the calling service.
+func (v *Verifier) Verify(raw string) (*token.Claims, error) {
+	claims := &token.Claims{}
+	parser := &jwt.Parser{
+		ValidMethods:         []string{jwt.SigningMethodHS256.Alg(), token.AlgorithmEdDSA, token.AlgorithmES256, token.AlgorithmRS256},
+		SkipClaimsValidation: true,
+	}
+	_, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		ctx, cancel := utils.WithTimeout(context.Background(), 0)
+		defer cancel()
+		key, alg, err := v.keys.Key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		// the key is bound to its algorithm, a token naming another one is a downgrade attempt.
+		if t.Method.Alg() != alg {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", token.ErrInvalidToken, err)
+	}
+	now := time.Now()
+	leeway := int64(v.conf.Leeway.Seconds())
+	switch {
+	case claims.ExpiresAt == 0 || claims.IssuedAt == 0:
+		return nil, fmt.Errorf("%w: iat and exp required", token.ErrInvalidToken)
+	case now.Unix() >= claims.ExpiresAt+leeway:
+		return nil, token.ErrTokenExpired
+	case claims.IssuedAt > now.Unix()+leeway:
+		return nil, fmt.Errorf("%w: issued in the future", token.ErrInvalidToken)
+	case time.Duration(claims.ExpiresAt-claims.IssuedAt)*time.Second > v.conf.MaxTTL:
+		return nil, ErrLifetimeTooLong
+	case claims.Audience != v.conf.Service:
+		return nil, ErrAudienceMismatch
+	case len(v.conf.AllowedIssuers) > 0 && !utils.StringsInclude(v.conf.AllowedIssuers, claims.Issuer):
+		return nil, fmt.Errorf("%s: %w", claims.Issuer, ErrIssuerNotAllowed)
+	}
+	return claims, nil
+}
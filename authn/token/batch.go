@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// BatchResult the outcome of the verification of one token of a batch.
+type BatchResult struct {
+	Claims *Claims
+	Err    error
+}
+
+// VerifyBatch verifies tokens concurrently on up to workers goroutines, default to GOMAXPROCS,
+// and returns their results in order. A token repeated in the batch, as the buffered messages of
+// a device carry, is verified once. Tokens not verified when ctx is done fail with its error.
+func VerifyBatch(ctx context.Context, v Verifier, tokens []string, workers int) []BatchResult {
+	results := make([]BatchResult, len(tokens))
+	positions := make(map[string][]int, len(tokens))
+	unique := make([]string, 0, len(tokens))
+	for i, raw := range tokens {
+		if _, ok := positions[raw]; !ok {
+			unique = append(unique, raw)
+		}
+		positions[raw] = append(positions[raw], i)
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(unique) {
+		workers = len(unique)
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for raw := range jobs {
+				var res BatchResult
+				if err := ctx.Err(); err != nil {
+					res.Err = err
+				} else {
+					res.Claims, res.Err = v.Verify(raw)
+				}
+				for n, i := range positions[raw] {
+					results[i] = res
+					// every position gets its own claims, so callers may change them.
+					if n > 0 && res.Claims != nil {
+						copied := *res.Claims
+						results[i].Claims = &copied
+					}
+				}
+			}
+		}()
+	}
+	for _, raw := range unique {
+		jobs <- raw
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
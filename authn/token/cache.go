@@ -0,0 +1,167 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+)
+
+var _ Verifier = &CachedVerifier{}
+
+const (
+	_defaultCacheSize   = 10000
+	_defaultCacheMaxTTL = 5 * time.Minute
+	_cacheKeyPrefix     = "token:"
+)
+
+// CacheStats counters of a CachedVerifier.
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// HitRate fraction of verifications served from the cache.
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// CachedVerifier remembers the claims of verified tokens until they expire, at most for its max
+// ttl. Tokens without expiry, e.g. entity tokens and API keys, and revoked tokens that are not
+// invalidated therefore verify for at most the max ttl.
+type CachedVerifier struct {
+	verifier Verifier
+	cache    cache.Cache
+	maxTTL   time.Duration
+	hits     uint64
+	misses   uint64
+	logger   *log.Helper
+}
+
+// NewCachedVerifier caches the results of verifier in an LRU for at most 5m, size <= 0 defaults
+// to 10000 entries.
+func NewCachedVerifier(verifier Verifier, size int) *CachedVerifier {
+	if size <= 0 {
+		size = _defaultCacheSize
+	}
+	return NewCachedVerifierWith(verifier, cache.NewLRU(size), 0)
+}
+
+// SetLogger writes the entries of the cache failures to l instead of the global logger.
+func (c *CachedVerifier) SetLogger(l log.Logger) {
+	c.logger = log.NewHelper(l)
+}
+
+// NewCachedVerifierWith caches the results of verifier in c, e.g. a cache.Redis shared by replicas,
+// for at most maxTTL, maxTTL <= 0 defaults to 5m.
+func NewCachedVerifierWith(verifier Verifier, c cache.Cache, maxTTL time.Duration) *CachedVerifier {
+	if maxTTL <= 0 {
+		maxTTL = _defaultCacheMaxTTL
+	}
+	return &CachedVerifier{verifier: verifier, cache: c, maxTTL: maxTTL}
+}
+
+func (c *CachedVerifier) Verify(token string) (*Claims, error) {
+	key := _cacheKeyPrefix + HashToken(token)
+	if claims, ok := c.get(key); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return claims, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+	claims, err := c.verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	c.add(key, claims)
+	return claims, nil
+}
+
+// Invalidate drops token from the cache, call it when the token is revoked.
+func (c *CachedVerifier) Invalidate(token string) {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if err := c.cache.Delete(ctx, _cacheKeyPrefix+HashToken(token)); err != nil {
+		c.logger.Warnf("invalidate cached token %s", err)
+	}
+}
+
+// Purge drops all cached tokens, cache.ErrPurgeUnsupported when the cache can not purge.
+func (c *CachedVerifier) Purge() error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	return cache.Purge(ctx, c.cache)
+}
+
+// Stats returns the hit and miss counters, Size is known for caches reporting their length only.
+func (c *CachedVerifier) Stats() CacheStats {
+	size := 0
+	if l, ok := c.cache.(interface{ Len() int }); ok {
+		size = l.Len()
+	}
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Size:   size,
+	}
+}
+
+func (c *CachedVerifier) get(key string) (*Claims, bool) {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	data, err := c.cache.Get(ctx, key)
+	if err != nil {
+		return nil, false
+	}
+	var claims Claims
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return nil, false
+	}
+	if claims.ExpiresAt != 0 && time.Now().Unix() >= claims.ExpiresAt {
+		return nil, false
+	}
+	return &claims, true
+}
+
+func (c *CachedVerifier) add(key string, claims *Claims) {
+	ttl := c.maxTTL
+	if claims.ExpiresAt != 0 {
+		untilExpiry := time.Until(time.Unix(claims.ExpiresAt, 0))
+		if untilExpiry <= 0 {
+			return
+		}
+		if untilExpiry < ttl {
+			ttl = untilExpiry
+		}
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return
+	}
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if err = c.cache.Set(ctx, key, data, ttl); err != nil {
+		c.logger.Warnf("cache verified token %s", err)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// The module targets Go 1.16, without type parameters, so claims are read with one getter per
+// type, e.g. ClaimString(claims, "email"), or bound to a struct with BindClaims.
+
+// ClaimMode how BindClaims treats claims not matching the fields of the target.
+type ClaimMode int
+
+const (
+	// ClaimsLenient converts claims of compatible types, e.g. "42" to 42 or a string to a one
+	// element slice, and leaves the fields of missing claims unset.
+	ClaimsLenient ClaimMode = iota
+	// ClaimsStrict fails on claims of another type and on missing claims of fields whose json
+	// tag has no omitempty.
+	ClaimsStrict
+)
+
+var (
+	// ErrMissingClaim a required claim is absent.
+	ErrMissingClaim = errors.New("missing claim")
+	// ErrClaimType a claim is not of the expected type.
+	ErrClaimType = errors.New("unexpected claim type")
+)
+
+var _timeType = reflect.TypeOf(time.Time{})
+
+// ClaimString returns the string claim key of c, empty when it is absent or not a string.
+func ClaimString(c map[string]interface{}, key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+
+// ClaimStrings returns the string array claim key of c, a single string is returned as a one
+// element array and non string elements are skipped.
+func ClaimStrings(c map[string]interface{}, key string) []string {
+	switch v := c[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	}
+	return nil
+}
+
+// ClaimFields returns the claim key of c, either a space separated string, such as scope, or a
+// string array.
+func ClaimFields(c map[string]interface{}, key string) []string {
+	if s, ok := c[key].(string); ok {
+		return strings.Fields(s)
+	}
+	return ClaimStrings(c, key)
+}
+
+// ClaimInt64 returns the numeric claim key of c, ok is false when it is absent or not a number.
+func ClaimInt64(c map[string]interface{}, key string) (n int64, ok bool) {
+	switch v := c[key].(type) {
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// ClaimBool returns the boolean claim key of c, false when it is absent or not a boolean.
+func ClaimBool(c map[string]interface{}, key string) bool {
+	b, _ := c[key].(bool)
+	return b
+}
+
+// ClaimTime returns the NumericDate claim key of c, such as auth_time, the zero time when it is
+// absent or not a number.
+func ClaimTime(c map[string]interface{}, key string) time.Time {
+	sec, ok := ClaimInt64(c, key)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// BindClaims decodes c into the struct out points to, matching the claims by the json names of
+// the fields. NumericDate claims decode into time.Time fields.
+func BindClaims(c map[string]interface{}, out interface{}, mode ClaimMode) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       numericDateHook,
+		WeaklyTypedInput: mode == ClaimsLenient,
+		TagName:          "json",
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+	if mode == ClaimsStrict {
+		if err = requireClaims(c, out); err != nil {
+			return err
+		}
+	}
+	if err = decoder.Decode(c); err != nil {
+		return fmt.Errorf("%w: %s", ErrClaimType, err)
+	}
+	return nil
+}
+
+func numericDateHook(from, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != _timeType {
+		return data, nil
+	}
+	switch v := data.(type) {
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case json.Number:
+		sec, err := v.Int64()
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(sec, 0), nil
+	}
+	return data, nil
+}
+
+// requireClaims checks c has the claims of the fields of out whose json tag has no omitempty.
+func requireClaims(c map[string]interface{}, out interface{}) error {
+	t := reflect.TypeOf(out)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	t = t.Elem()
+	var missing []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")
+		if f.PkgPath != "" || tag[0] == "-" || utils.StringsInclude(tag[1:], "omitempty") {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := c[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingClaim, strings.Join(missing, ", "))
+	}
+	return nil
+}
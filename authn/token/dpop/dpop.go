@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dpop implements OAuth 2.0 Demonstrating Proof of Possession, see https://datatracker.ietf.org/doc/html/rfc9449
+package dpop
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// HeaderDPoP request header carrying the proof.
+	HeaderDPoP = "DPoP"
+	// HeaderNonce response header carrying a server provided nonce.
+	HeaderNonce = "DPoP-Nonce"
+	// SchemeDPoP authorization scheme of DPoP bound access tokens.
+	SchemeDPoP = "DPoP"
+
+	// ErrorInvalidProof token endpoint error of a rejected proof.
+	ErrorInvalidProof = "invalid_dpop_proof"
+	// ErrorUseNonce error asking the client to retry with the nonce of HeaderNonce.
+	ErrorUseNonce = "use_dpop_nonce"
+
+	_proofType = "dpop+jwt"
+)
+
+var (
+	// ErrInvalidProof the proof is malformed, not signed by its key or does not match the request.
+	ErrInvalidProof = errors.New("invalid dpop proof")
+	// ErrUseNonce the proof lacks a valid server nonce, retry with the nonce of HeaderNonce.
+	ErrUseNonce = errors.New("dpop proof must carry the server nonce")
+	// ErrProofReplayed the jti of the proof was already used.
+	ErrProofReplayed = errors.New("dpop proof replayed")
+	// ErrBindingMismatch the proof key is not the key the access token is bound to.
+	ErrBindingMismatch = errors.New("dpop key does not match the token binding")
+)
+
+// proofClaims the payload of a proof.
+type proofClaims struct {
+	ID       string `json:"jti"`
+	Method   string `json:"htm"`
+	URL      string `json:"htu"`
+	IssuedAt int64  `json:"iat"`
+	// AccessTokenHash base64url sha256 of the access token presented with the proof.
+	AccessTokenHash string `json:"ath,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+}
+
+// Valid the time window is checked by the Validator, satisfies jwt.Claims.
+func (c *proofClaims) Valid() error {
+	return nil
+}
+
+// Thumbprint returns the base64url SHA-256 JWK thumbprint of key, the jkt a token is bound to.
+func Thumbprint(key crypto.PublicKey) (string, error) {
+	sum, err := (&jose.JSONWebKey{Key: key}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("jwk thumbprint %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(sum), nil
+}
+
+// AccessTokenHash returns the ath of accessToken.
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// normalizeURL drops query and fragment, lower cases scheme and host and strips default ports.
+func normalizeURL(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	scheme, host := strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	if (scheme == "https" && strings.HasSuffix(host, ":443")) || (scheme == "http" && strings.HasSuffix(host, ":80")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/token"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newProofer(t *testing.T) *Proofer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	p, err := NewProofer(key)
+	assert.NoError(t, err)
+	return p
+}
+
+func TestValidate(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	edProofer, err := NewProofer(edKey)
+	assert.NoError(t, err)
+	v, err := NewValidator(Config{}, nil)
+	assert.NoError(t, err)
+
+	for _, p := range []*Proofer{newProofer(t), edProofer} {
+		proof, err := p.Proof("POST", "https://as.example/oauth/token?x=1", "")
+		assert.NoError(t, err)
+		jkt, err := v.Validate(proof, "POST", "https://AS.example:443/oauth/token", "")
+		assert.NoError(t, err)
+		assert.Equal(t, p.Thumbprint(), jkt)
+
+		_, err = v.Validate(proof, "POST", "https://as.example/oauth/token", "")
+		assert.ErrorIs(t, err, ErrProofReplayed)
+	}
+
+	p := newProofer(t)
+	tests := []struct {
+		name        string
+		method, uri string
+		accessToken string
+	}{
+		{"htm", "GET", "https://as.example/oauth/token", ""},
+		{"htu", "POST", "https://as.example/oauth/revoke", ""},
+		{"ath", "POST", "https://as.example/oauth/token", "other-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof, err := p.Proof("POST", "https://as.example/oauth/token", "access-token")
+			assert.NoError(t, err)
+			_, err = v.Validate(proof, tt.method, tt.uri, tt.accessToken)
+			assert.ErrorIs(t, err, ErrInvalidProof)
+		})
+	}
+
+	_, err = NewValidator(Config{Algorithms: []string{"HS256"}}, nil)
+	assert.ErrorIs(t, err, token.ErrUnsupportedAlgorithm)
+}
+
+func TestVerifyRequest(t *testing.T) {
+	p := newProofer(t)
+	v, err := NewValidator(Config{PublicURL: "https://api.example"}, nil)
+	assert.NoError(t, err)
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	bound, err := tokens.Issue(&token.Claims{Subject: "admin", Confirmation: &token.Confirmation{JKT: p.Thumbprint()}})
+	assert.NoError(t, err)
+
+	request := func(scheme, accessToken string, proofer *Proofer) *http.Request {
+		r := httptest.NewRequest("GET", "http://10.0.0.1:8080/v1/things?page=2", nil)
+		r.Header.Set("Authorization", scheme+" "+accessToken)
+		if proofer != nil {
+			proof, err := proofer.Proof("GET", "https://api.example/v1/things", accessToken)
+			assert.NoError(t, err)
+			r.Header.Set(HeaderDPoP, proof)
+		}
+		return r
+	}
+
+	claims, err := v.VerifyRequest(request(SchemeDPoP, bound, p), tokens)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", claims.Subject)
+
+	_, err = v.VerifyRequest(request("Bearer", bound, nil), tokens)
+	assert.ErrorIs(t, err, token.ErrInvalidToken)
+	_, err = v.VerifyRequest(request(SchemeDPoP, bound, newProofer(t)), tokens)
+	assert.ErrorIs(t, err, ErrBindingMismatch)
+}
+
+func TestTransportRetriesWithNonce(t *testing.T) {
+	p := newProofer(t)
+	v, err := NewValidator(Config{}, nil)
+	assert.NoError(t, err)
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, err := v.Validate(r.Header.Get(HeaderDPoP), r.Method, "http://"+r.Host+r.URL.Path, "")
+		assert.NoError(t, err)
+		if calls == 1 {
+			w.Header().Set(HeaderNonce, "server-nonce")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"use_dpop_nonce"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &Transport{Proofer: p}}
+	resp, err := client.Post(srv.URL+"/oauth/token", "application/x-www-form-urlencoded", nil)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, calls)
+}
+
+func TestNonce(t *testing.T) {
+	v, err := NewValidator(Config{NonceSecret: "nonce-secret"}, nil)
+	assert.NoError(t, err)
+	p := newProofer(t)
+	validate := func() error {
+		proof, err := p.Proof("POST", "https://as.example/oauth/token", "")
+		assert.NoError(t, err)
+		_, err = v.Validate(proof, "POST", "https://as.example/oauth/token", "")
+		return err
+	}
+
+	assert.ErrorIs(t, validate(), ErrUseNonce)
+	p.SetNonce(v.Nonce())
+	assert.NoError(t, validate())
+
+	// nonces of a server with another secret are not accepted.
+	other, err := NewValidator(Config{NonceSecret: "other-secret"}, nil)
+	assert.NoError(t, err)
+	p.SetNonce(other.Nonce())
+	assert.ErrorIs(t, validate(), ErrUseNonce)
+	p.SetNonce("forged")
+	assert.ErrorIs(t, validate(), ErrUseNonce)
+
+	disabled, err := NewValidator(Config{}, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, disabled.Nonce())
+}
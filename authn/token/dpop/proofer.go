@@ -0,0 +1,180 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dpop
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/golang-jwt/jwt"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Proofer signs DPoP proofs with the private key of a client.
+type Proofer struct {
+	key    crypto.Signer
+	method jwt.SigningMethod
+	jwk    jose.JSONWebKey
+	jkt    string
+
+	lock sync.RWMutex
+	// nonce last nonce provided by the server.
+	nonce string
+}
+
+// NewProofer returns a Proofer for an ECDSA P-256, RSA or Ed25519 key.
+func NewProofer(key crypto.Signer) (*Proofer, error) {
+	var method jwt.SigningMethod
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("dpop: unsupported curve %s", k.Curve.Params().Name)
+		}
+		method = jwt.SigningMethodES256
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		method = token.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("dpop: unsupported key type %T", key)
+	}
+	jkt, err := Thumbprint(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Proofer{
+		key:    key,
+		method: method,
+		jwk:    jose.JSONWebKey{Key: key.Public(), Algorithm: method.Alg()},
+		jkt:    jkt,
+	}, nil
+}
+
+// Thumbprint returns the jkt tokens issued to this Proofer are bound to.
+func (p *Proofer) Thumbprint() string {
+	return p.jkt
+}
+
+// SetNonce sets the server provided nonce included in subsequent proofs.
+func (p *Proofer) SetNonce(nonce string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.nonce = nonce
+}
+
+// Proof returns a proof for a request of method to uri, accessToken is empty for token requests.
+func (p *Proofer) Proof(method, uri, accessToken string) (string, error) {
+	jti, err := utils.RandBase64String(16)
+	if err != nil {
+		return "", err
+	}
+	htu, err := normalizeURL(uri)
+	if err != nil {
+		return "", fmt.Errorf("dpop: parse htu %w", err)
+	}
+	p.lock.RLock()
+	claims := &proofClaims{ID: jti, Method: method, URL: htu, IssuedAt: time.Now().Unix(), Nonce: p.nonce}
+	p.lock.RUnlock()
+	if accessToken != "" {
+		claims.AccessTokenHash = AccessTokenHash(accessToken)
+	}
+	t := jwt.NewWithClaims(p.method, claims)
+	t.Header["typ"] = _proofType
+	t.Header["jwk"] = p.jwk
+	signed, err := t.SignedString(p.key)
+	if err != nil {
+		return "", fmt.Errorf("dpop: sign proof %w", err)
+	}
+	return signed, nil
+}
+
+// Transport adds a DPoP proof to every request, for requests carrying a DPoP access token the
+// proof covers the token. Requests rejected with use_dpop_nonce are retried once with the nonce.
+type Transport struct {
+	// Base the underlying RoundTripper, default to http.DefaultTransport.
+	Base    http.RoundTripper
+	Proofer *Proofer
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.roundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	nonce := resp.Header.Get(HeaderNonce)
+	if nonce == "" {
+		return resp, nil
+	}
+	t.Proofer.SetNonce(nonce)
+	// the body of the retried request must be replayable.
+	if (req.Body != nil && req.GetBody == nil) || !isUseNonce(resp) {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	return t.roundTrip(req)
+}
+
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	var accessToken string
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, SchemeDPoP+" ") {
+		accessToken = strings.TrimPrefix(auth, SchemeDPoP+" ")
+	}
+	proof, err := t.Proofer.Proof(req.Method, req.URL.String(), accessToken)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(HeaderDPoP, proof)
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// isUseNonce reports whether the server rejected the proof for lack of its nonce,
+// token endpoints answer with an error body, resource servers with a challenge.
+func isUseNonce(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return strings.Contains(resp.Header.Get("WWW-Authenticate"), ErrorUseNonce)
+	case http.StatusBadRequest:
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return err == nil && bytes.Contains(body, []byte(ErrorUseNonce))
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dpop
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+
+	"github.com/golang-jwt/jwt"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	_defaultMaxAge    = time.Minute
+	_defaultLeeway    = 5 * time.Second
+	_defaultNonceTTL  = 5 * time.Minute
+	_replaySweepEvery = time.Minute
+	// _nonceMACLength bytes of the HMAC kept in a nonce.
+	_nonceMACLength = 16
+)
+
+// ReplayCache remembers the jti of accepted proofs.
+type ReplayCache interface {
+	// Seen records jti until expiresAt and reports whether it was recorded before.
+	Seen(jti string, expiresAt time.Time) bool
+}
+
+// MemoryReplayCache in-process ReplayCache.
+type MemoryReplayCache struct {
+	lock      sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{seen: make(map[string]time.Time), lastSweep: time.Now()}
+}
+
+func (c *MemoryReplayCache) Seen(jti string, expiresAt time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if exp, ok := c.seen[jti]; ok && now.Before(exp) {
+		return true
+	}
+	c.sweep(now)
+	c.seen[jti] = expiresAt
+	return false
+}
+
+func (c *MemoryReplayCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < _replaySweepEvery {
+		return
+	}
+	c.lastSweep = now
+	for k, exp := range c.seen {
+		if !now.Before(exp) {
+			delete(c.seen, k)
+		}
+	}
+}
+
+// Config of the proof validation.
+type Config struct {
+	// MaxAge how long after iat a proof is accepted. Default to 1m.
+	MaxAge time.Duration `mapstructure:"max_age" json:"max_age" yaml:"maxAge"`
+	// Leeway tolerated clock skew of clients. Default to 5s.
+	Leeway time.Duration `mapstructure:"leeway" json:"leeway" yaml:"leeway"`
+	// Algorithms proofs may be signed with. Default to ES256, RS256, PS256 and EdDSA.
+	Algorithms []string `mapstructure:"algorithms" json:"algorithms" yaml:"algorithms"`
+	// PublicURL scheme and host the service is reached at, used for htu behind proxies.
+	// Default to the scheme and host of the request.
+	PublicURL string `mapstructure:"public_url" json:"public_url" yaml:"publicURL"`
+	// NonceSecret enables server nonces, proofs must then carry a nonce of HeaderNonce. Nonces
+	// are only accepted by servers sharing the secret, give every server its own secret unless
+	// they share the ReplayCache, so a proof can not be replayed at another server.
+	NonceSecret string `mapstructure:"nonce_secret" json:"nonce_secret" yaml:"nonceSecret"`
+	// NonceTTL how long a nonce is accepted. Default to 5m.
+	NonceTTL time.Duration `mapstructure:"nonce_ttl" json:"nonce_ttl" yaml:"nonceTTL"`
+}
+
+// Validator checks DPoP proofs and the binding of access tokens.
+type Validator struct {
+	conf   Config
+	parser *jwt.Parser
+	replay ReplayCache
+	public *url.URL
+}
+
+// NewValidator returns a Validator, replay defaults to a MemoryReplayCache.
+func NewValidator(conf Config, replay ReplayCache) (*Validator, error) {
+	if conf.MaxAge <= 0 {
+		conf.MaxAge = _defaultMaxAge
+	}
+	if conf.Leeway <= 0 {
+		conf.Leeway = _defaultLeeway
+	}
+	if conf.NonceTTL <= 0 {
+		conf.NonceTTL = _defaultNonceTTL
+	}
+	if len(conf.Algorithms) == 0 {
+		conf.Algorithms = []string{token.AlgorithmES256, token.AlgorithmRS256, "PS256", token.AlgorithmEdDSA}
+	}
+	for _, alg := range conf.Algorithms {
+		if alg == token.AlgorithmNone || strings.HasPrefix(alg, "HS") || jwt.GetSigningMethod(alg) == nil {
+			return nil, fmt.Errorf("%w: %s", token.ErrUnsupportedAlgorithm, alg)
+		}
+	}
+	if replay == nil {
+		replay = NewMemoryReplayCache()
+	}
+	v := &Validator{conf: conf, parser: &jwt.Parser{ValidMethods: conf.Algorithms}, replay: replay}
+	if conf.PublicURL != "" {
+		u, err := url.Parse(conf.PublicURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse public url %w", err)
+		}
+		v.public = u
+	}
+	return v, nil
+}
+
+// Algorithms returns the accepted proof algorithms.
+func (v *Validator) Algorithms() []string {
+	return v.conf.Algorithms
+}
+
+// Nonce returns a nonce to send in HeaderNonce, empty when server nonces are disabled.
+func (v *Validator) Nonce() string {
+	if v.conf.NonceSecret == "" {
+		return ""
+	}
+	issuedAt := make([]byte, 8)
+	binary.BigEndian.PutUint64(issuedAt, uint64(time.Now().Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(issuedAt, v.nonceMAC(issuedAt)...))
+}
+
+// checkNonce reports whether nonce was issued with the secret and is younger than NonceTTL.
+func (v *Validator) checkNonce(nonce string) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(raw) != 8+_nonceMACLength {
+		return false
+	}
+	if !hmac.Equal(raw[8:], v.nonceMAC(raw[:8])) {
+		return false
+	}
+	issuedAt := time.Unix(int64(binary.BigEndian.Uint64(raw[:8])), 0)
+	now := time.Now()
+	return !issuedAt.After(now.Add(v.conf.Leeway)) && now.Before(issuedAt.Add(v.conf.NonceTTL))
+}
+
+func (v *Validator) nonceMAC(issuedAt []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(v.conf.NonceSecret))
+	mac.Write(issuedAt)
+	return mac.Sum(nil)[:_nonceMACLength]
+}
+
+// Validate checks the proof for a request of method to uri and returns the thumbprint of its key,
+// accessToken is empty for token requests.
+func (v *Validator) Validate(proof, method, uri, accessToken string) (string, error) {
+	claims := &proofClaims{}
+	var jkt string
+	_, err := v.parser.ParseWithClaims(proof, claims, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != _proofType {
+			return nil, fmt.Errorf("unexpected typ %v", t.Header["typ"])
+		}
+		raw, err := json.Marshal(t.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		var jwk jose.JSONWebKey
+		if err = jwk.UnmarshalJSON(raw); err != nil {
+			return nil, fmt.Errorf("invalid jwk header %w", err)
+		}
+		if !jwk.IsPublic() {
+			return nil, fmt.Errorf("jwk header must be a public key")
+		}
+		if jkt, err = Thumbprint(jwk.Key); err != nil {
+			return nil, err
+		}
+		return jwk.Key, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidProof, err)
+	}
+
+	if claims.ID == "" || !strings.EqualFold(claims.Method, method) {
+		return "", fmt.Errorf("%w: htm mismatch", ErrInvalidProof)
+	}
+	expected, err := normalizeURL(uri)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidProof, err)
+	}
+	if htu, err := normalizeURL(claims.URL); err != nil || htu != expected {
+		return "", fmt.Errorf("%w: htu mismatch", ErrInvalidProof)
+	}
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	now := time.Now()
+	if issuedAt.After(now.Add(v.conf.Leeway)) || now.After(issuedAt.Add(v.conf.MaxAge)) {
+		return "", fmt.Errorf("%w: iat outside the accepted window", ErrInvalidProof)
+	}
+	if accessToken != "" &&
+		subtle.ConstantTimeCompare([]byte(claims.AccessTokenHash), []byte(AccessTokenHash(accessToken))) != 1 {
+		return "", fmt.Errorf("%w: ath mismatch", ErrInvalidProof)
+	}
+	if v.conf.NonceSecret != "" && !v.checkNonce(claims.Nonce) {
+		return "", ErrUseNonce
+	}
+	if v.replay.Seen(jkt+":"+claims.ID, issuedAt.Add(v.conf.MaxAge+v.conf.Leeway)) {
+		return "", ErrProofReplayed
+	}
+	return jkt, nil
+}
+
+// VerifyRequest verifies the access token of r with verifier. Tokens bound to a key must be
+// presented with the DPoP scheme and a proof signed by that key, bearer tokens are accepted as is.
+func (v *Validator) VerifyRequest(r *http.Request, verifier token.Verifier) (*token.Claims, error) {
+	scheme, raw := splitAuthorization(r.Header.Get("Authorization"))
+	if raw == "" {
+		return nil, fmt.Errorf("%w: missing access token", token.ErrInvalidToken)
+	}
+	claims, err := verifier.Verify(raw)
+	if err != nil {
+		return nil, err
+	}
+	bound := claims.Confirmation != nil && claims.Confirmation.JKT != ""
+	switch {
+	case strings.EqualFold(scheme, SchemeDPoP):
+		if !bound {
+			return nil, fmt.Errorf("%w: token is not dpop bound", token.ErrInvalidToken)
+		}
+	case strings.EqualFold(scheme, "Bearer"):
+		if bound {
+			// a stolen bound token must not be downgraded to a bearer token.
+			return nil, fmt.Errorf("%w: dpop bound token presented as bearer", token.ErrInvalidToken)
+		}
+		return claims, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported authorization scheme", token.ErrInvalidToken)
+	}
+	jkt, err := v.Validate(r.Header.Get(HeaderDPoP), r.Method, v.requestURL(r), raw)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(jkt), []byte(claims.Confirmation.JKT)) != 1 {
+		return nil, ErrBindingMismatch
+	}
+	return claims, nil
+}
+
+// requestURL the htu a proof for r must carry.
+func (v *Validator) requestURL(r *http.Request) string {
+	u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawPath: r.URL.RawPath}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if v.public != nil {
+		u.Scheme, u.Host = v.public.Scheme, v.public.Host
+	}
+	return u.String()
+}
+
+func splitAuthorization(header string) (scheme, credentials string) {
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return "", ""
+	}
+	return header[:i], strings.TrimSpace(header[i+1:])
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"fmt"
+	"sync"
+)
+
+var _ Manager = &HookedManager{}
+
+// ClaimEnricher adds application claims (entitlements, feature flags, tenant metadata) before a token is issued.
+type ClaimEnricher func(claims *Claims) error
+
+// ClaimValidator checks application claims after the token itself verified.
+type ClaimValidator func(claims *Claims) error
+
+// HookedManager runs the registered hooks around another Manager.
+type HookedManager struct {
+	Manager
+	lock       sync.RWMutex
+	enrichers  []ClaimEnricher
+	validators []ClaimValidator
+}
+
+func NewHookedManager(m Manager) *HookedManager {
+	return &HookedManager{Manager: m}
+}
+
+// RegisterClaimEnricher adds an enricher, enrichers run in registration order.
+func (h *HookedManager) RegisterClaimEnricher(e ClaimEnricher) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.enrichers = append(h.enrichers, e)
+}
+
+// RegisterClaimValidator adds a validator, validators run in registration order.
+func (h *HookedManager) RegisterClaimValidator(v ClaimValidator) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.validators = append(h.validators, v)
+}
+
+func (h *HookedManager) Issue(claims *Claims) (string, error) {
+	h.lock.RLock()
+	enrichers := h.enrichers
+	h.lock.RUnlock()
+	for _, enrich := range enrichers {
+		if err := enrich(claims); err != nil {
+			return "", fmt.Errorf("enrich claims %w", err)
+		}
+	}
+	return h.Manager.Issue(claims)
+}
+
+func (h *HookedManager) Verify(token string) (*Claims, error) {
+	claims, err := h.Manager.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	h.lock.RLock()
+	validators := h.validators
+	h.lock.RUnlock()
+	for _, validate := range validators {
+		if err = validate(claims); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+		}
+	}
+	return claims, nil
+}
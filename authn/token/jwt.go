@@ -0,0 +1,130 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tkeel-io/security/authn/token/keyset"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var _ Manager = &jwtManager{}
+
+var (
+	// ErrSigningKeyRequired jwt signing key not configured.
+	ErrSigningKeyRequired = errors.New("token signing key required")
+	// ErrKeySetRequired jwt keyset manager not provided.
+	ErrKeySetRequired = errors.New("token keyset required")
+)
+
+type jwtManager struct {
+	conf *Config
+	// method HMAC signing method, used when keys is nil.
+	method jwt.SigningMethod
+	key    []byte
+	keys   *keyset.Manager
+	parser *jwt.Parser
+	// macs and headers serve the verification fast path.
+	macs    *macPool
+	headers headerCache
+}
+
+// NewJWTManager returns a Manager issuing HMAC signed JWTs.
+func NewJWTManager(conf *Config) (Manager, error) {
+	if conf.SigningKey == "" {
+		return nil, ErrSigningKeyRequired
+	}
+	alg := conf.Algorithm
+	if alg == "" {
+		alg = AlgorithmHS256
+	}
+	if !utils.StringsInclude([]string{AlgorithmHS256, AlgorithmHS384, AlgorithmHS512}, alg) {
+		return nil, fmt.Errorf("%w: %s requires a keyset", ErrUnsupportedAlgorithm, alg)
+	}
+	parser, err := newParser(conf.AllowedAlgorithms, []string{alg})
+	if err != nil {
+		return nil, err
+	}
+	return &jwtManager{
+		conf:   conf,
+		method: jwt.GetSigningMethod(alg),
+		key:    []byte(conf.SigningKey),
+		parser: parser,
+		macs:   newMACPool(alg, []byte(conf.SigningKey)),
+	}, nil
+}
+
+// NewJWTKeySetManager returns a Manager issuing JWTs signed with the active key of keys,
+// tokens signed by retired keys verify until the keyset drops them.
+func NewJWTKeySetManager(conf *Config, keys *keyset.Manager) (Manager, error) {
+	if keys == nil {
+		return nil, ErrKeySetRequired
+	}
+	parser, err := newParser(conf.AllowedAlgorithms, []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA})
+	if err != nil {
+		return nil, err
+	}
+	return &jwtManager{conf: conf, keys: keys, parser: parser}, nil
+}
+
+// newParser returns a parser only accepting the allowed algorithms, defaults when allowed is empty.
+func newParser(allowed, defaults []string) (*jwt.Parser, error) {
+	if len(allowed) == 0 {
+		allowed = defaults
+	}
+	for _, alg := range allowed {
+		if alg == AlgorithmNone || jwt.GetSigningMethod(alg) == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+		}
+	}
+	return &jwt.Parser{ValidMethods: allowed}, nil
+}
+
+func (m *jwtManager) Issue(claims *Claims) (string, error) {
+	id, err := utils.RandBase64String(16)
+	if err != nil {
+		return "", err
+	}
+	stamp(m.conf, claims, id)
+
+	var signed string
+	if m.keys == nil {
+		signed, err = jwt.NewWithClaims(m.method, claims).SignedString(m.key)
+	} else {
+		key := m.keys.SigningKey()
+		t := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+		t.Header["kid"] = key.ID
+		signed, err = t.SignedString(key.Signer)
+	}
+	if err != nil {
+		return "", fmt.Errorf("sign jwt token %w", err)
+	}
+	return signed, nil
+}
+
+func (m *jwtManager) Verify(token string) (*Claims, error) {
+	claims, err := m.verifyFast(token)
+	switch {
+	case err == nil:
+		return claims, nil
+	case errors.Is(err, ErrTokenExpired), errors.Is(err, ErrInvalidToken):
+		return nil, err
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+}
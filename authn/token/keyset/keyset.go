@@ -0,0 +1,345 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keyset
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// AlgorithmRS256 RSASSA-PKCS1-v1_5 using SHA-256.
+	AlgorithmRS256 = "RS256"
+	// AlgorithmES256 ECDSA using P-256 and SHA-256.
+	AlgorithmES256 = "ES256"
+	// AlgorithmEdDSA Ed25519 signatures.
+	AlgorithmEdDSA = "EdDSA"
+
+	_defaultRotationInterval = 30 * 24 * time.Hour
+	_defaultGracePeriod      = 7 * 24 * time.Hour
+	_defaultCheckInterval    = time.Minute
+	_rsaKeyBits              = 2048
+	// _maxSaveAttempts how often a rotation is retried on top of the keys another replica saved.
+	_maxSaveAttempts = 3
+)
+
+var (
+	// ErrKeyNotFound no verification key with the kid.
+	ErrKeyNotFound = errors.New("signing key not found")
+	// ErrUnsupportedAlgorithm keys for the algorithm can not be generated.
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// Key a signing key of the set.
+type Key struct {
+	// ID published as kid.
+	ID string
+	// Algorithm the JWS alg the key signs with.
+	Algorithm string
+	// Signer the private key.
+	Signer crypto.Signer
+	// CreatedAt time the key was generated.
+	CreatedAt time.Time
+	// RetiredAt time the key stopped signing, zero for the active key.
+	RetiredAt time.Time
+}
+
+// Public returns the public half of the key.
+func (k *Key) Public() crypto.PublicKey {
+	return k.Signer.Public()
+}
+
+// Config of the keyset rotation.
+type Config struct {
+	// Algorithm of generated keys, RS256, ES256 or EdDSA. Default to RS256.
+	Algorithm string `mapstructure:"algorithm" json:"algorithm" yaml:"algorithm"`
+	// RotationInterval age after which the active key is replaced. Default to 30 days.
+	RotationInterval time.Duration `mapstructure:"rotation_interval" json:"rotation_interval" yaml:"rotationInterval"`
+	// GracePeriod how long retired keys are still published for verification. Default to 7 days.
+	GracePeriod time.Duration `mapstructure:"grace_period" json:"grace_period" yaml:"gracePeriod"`
+	// EncryptionKey secret the persisted keyset is encrypted with.
+	EncryptionKey string `mapstructure:"encryption_key" json:"-" yaml:"encryptionKey"`
+}
+
+// Manager generates, rotates and persists the signing keys.
+type Manager struct {
+	conf  Config
+	store Store
+	lock  sync.RWMutex
+	// keys newest first, keys[0] is the active signing key.
+	keys []*Key
+	// version of the stored keyset the keys were loaded from.
+	version int64
+	cancel  context.CancelFunc
+	logger  *log.Helper
+}
+
+// New returns a Manager with the keys loaded from store,
+// a first key is generated when the store is empty.
+func New(conf Config, store Store) (*Manager, error) {
+	if conf.Algorithm == "" {
+		conf.Algorithm = AlgorithmRS256
+	}
+	if conf.RotationInterval <= 0 {
+		conf.RotationInterval = _defaultRotationInterval
+	}
+	if conf.GracePeriod <= 0 {
+		conf.GracePeriod = _defaultGracePeriod
+	}
+	m := &Manager{conf: conf, store: store}
+	if err := m.rotate(false); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetLogger writes the entries of the scheduled rotation to l instead of the global logger.
+func (m *Manager) SetLogger(l log.Logger) {
+	m.logger = log.NewHelper(l)
+}
+
+// SigningKey returns the active key.
+func (m *Manager) SigningKey() *Key {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.keys[0]
+}
+
+// Key returns the active or a retired key still in grace period.
+func (m *Manager) Key(kid string) (*Key, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, k := range m.keys {
+		if k.ID == kid {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, kid)
+}
+
+// JWKS returns the public keys of the set.
+func (m *Manager) JWKS() jose.JSONWebKeySet {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	set := jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, 0, len(m.keys))}
+	for _, k := range m.keys {
+		set.Keys = append(set.Keys, jose.JSONWebKey{
+			Key:       k.Public(),
+			KeyID:     k.ID,
+			Algorithm: k.Algorithm,
+			Use:       "sig",
+		})
+	}
+	return set
+}
+
+// Rotate replaces the active key immediately.
+func (m *Manager) Rotate() error {
+	return m.rotate(true)
+}
+
+// Start rotates the keys on schedule until Stop is called, the keys saved by other
+// replicas are picked up on the same schedule.
+func (m *Manager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	go func() {
+		ticker := time.NewTicker(_defaultCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.rotate(false); err != nil {
+					m.logger.Errorf("keyset rotate %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop the scheduled rotation.
+func (m *Manager) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+}
+
+// rotate reloads the stored keys, generates a new active key when forced or the active key
+// is due and drops retired keys past the grace period. When another replica saved in between
+// the rotation is redone on top of its keys.
+func (m *Manager) rotate(force bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for attempt := 1; ; attempt++ {
+		if err := m.load(); err != nil {
+			return err
+		}
+		keys, changed, err := m.next(force, time.Now())
+		if err != nil || !changed {
+			return err
+		}
+		err = m.save(keys)
+		if errors.Is(err, ErrVersionConflict) && attempt < _maxSaveAttempts {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		m.keys = keys
+		return nil
+	}
+}
+
+// next returns the keys after a rotation at now, changed is false when the keys are current.
+func (m *Manager) next(force bool, now time.Time) (keys []*Key, changed bool, err error) {
+	keys = m.keys
+	if force || len(keys) == 0 || now.Sub(keys[0].CreatedAt) >= m.conf.RotationInterval {
+		key, err := generate(m.conf.Algorithm)
+		if err != nil {
+			return nil, false, err
+		}
+		keys = []*Key{key}
+		if len(m.keys) > 0 {
+			// copied, the active key may be in use by readers.
+			retired := *m.keys[0]
+			retired.RetiredAt = now
+			keys = append(keys, &retired)
+			keys = append(keys, m.keys[1:]...)
+		}
+		changed = true
+	}
+	kept := make([]*Key, 1, len(keys))
+	kept[0] = keys[0]
+	for _, k := range keys[1:] {
+		if now.Sub(k.RetiredAt) < m.conf.GracePeriod {
+			kept = append(kept, k)
+			continue
+		}
+		changed = true
+	}
+	return kept, changed, nil
+}
+
+func generate(alg string) (*Key, error) {
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case AlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, _rsaKeyBits)
+	case AlgorithmES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generate %s key %w", alg, err)
+	}
+	kid, err := utils.RandBase64String(12)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: kid, Algorithm: alg, Signer: signer, CreatedAt: time.Now()}, nil
+}
+
+// persistedKey is the stored form of a Key, the private key as JWK.
+type persistedKey struct {
+	JWK       jose.JSONWebKey `json:"jwk"`
+	CreatedAt time.Time       `json:"created_at"`
+	RetiredAt time.Time       `json:"retired_at"`
+}
+
+// load replaces the keys with the stored keyset unless it is at the loaded version.
+func (m *Manager) load() error {
+	if m.store == nil {
+		return nil
+	}
+	sealed, version, err := m.store.Load()
+	if err != nil {
+		return fmt.Errorf("load keyset %w", err)
+	}
+	if len(sealed) == 0 || (version == m.version && len(m.keys) > 0) {
+		return nil
+	}
+	data, err := open(m.conf.EncryptionKey, sealed)
+	if err != nil {
+		return err
+	}
+	var persisted []persistedKey
+	if err = json.Unmarshal(data, &persisted); err != nil {
+		return fmt.Errorf("decode keyset %w", err)
+	}
+	keys := make([]*Key, 0, len(persisted))
+	for _, p := range persisted {
+		signer, ok := p.JWK.Key.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("decode keyset: key %s is not a private key", p.JWK.KeyID)
+		}
+		keys = append(keys, &Key{
+			ID:        p.JWK.KeyID,
+			Algorithm: p.JWK.Algorithm,
+			Signer:    signer,
+			CreatedAt: p.CreatedAt,
+			RetiredAt: p.RetiredAt,
+		})
+	}
+	m.keys, m.version = keys, version
+	return nil
+}
+
+// save stores keys over the loaded version.
+func (m *Manager) save(keys []*Key) error {
+	if m.store == nil {
+		return nil
+	}
+	persisted := make([]persistedKey, 0, len(keys))
+	for _, k := range keys {
+		persisted = append(persisted, persistedKey{
+			JWK:       jose.JSONWebKey{Key: k.Signer, KeyID: k.ID, Algorithm: k.Algorithm, Use: "sig"},
+			CreatedAt: k.CreatedAt,
+			RetiredAt: k.RetiredAt,
+		})
+	}
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return fmt.Errorf("encode keyset %w", err)
+	}
+	sealed, err := seal(m.conf.EncryptionKey, data)
+	if err != nil {
+		return err
+	}
+	if err = m.store.Save(sealed, m.version); err != nil {
+		return fmt.Errorf("save keyset %w", err)
+	}
+	m.version++
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keyset
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRotateKeepsRetiredKeys(t *testing.T) {
+	m, err := New(Config{Algorithm: AlgorithmES256}, nil)
+	assert.NoError(t, err)
+	first := m.SigningKey()
+	assert.NoError(t, m.Rotate())
+	assert.NotEqual(t, first.ID, m.SigningKey().ID)
+
+	_, err = m.Key(first.ID)
+	assert.NoError(t, err)
+	assert.Len(t, m.JWKS().Keys, 2)
+}
+
+func TestPersistEncrypted(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "keyset"))
+	conf := Config{Algorithm: AlgorithmES256, EncryptionKey: "secret"}
+	m, err := New(conf, store)
+	assert.NoError(t, err)
+
+	reloaded, err := New(conf, store)
+	assert.NoError(t, err)
+	assert.Equal(t, m.SigningKey().ID, reloaded.SigningKey().ID)
+
+	conf.EncryptionKey = "other"
+	_, err = New(conf, store)
+	assert.Error(t, err)
+}
+
+func TestConcurrentRotation(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormStore(db, "oauth")
+	assert.NoError(t, err)
+	conf := Config{Algorithm: AlgorithmES256, EncryptionKey: "secret"}
+
+	for name, store := range map[string]Store{"file": NewFileStore(filepath.Join(t.TempDir(), "keyset")), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			first, err := New(conf, store)
+			assert.NoError(t, err)
+			second, err := New(conf, store)
+			assert.NoError(t, err)
+			assert.Equal(t, first.SigningKey().ID, second.SigningKey().ID)
+
+			// second rotates over a stale version and must keep the key first saved.
+			assert.NoError(t, first.Rotate())
+			assert.NoError(t, second.Rotate())
+			_, err = second.Key(first.SigningKey().ID)
+			assert.NoError(t, err)
+
+			reloaded, err := New(conf, store)
+			assert.NoError(t, err)
+			assert.Equal(t, second.SigningKey().ID, reloaded.SigningKey().ID)
+			assert.Len(t, reloaded.JWKS().Keys, 3)
+		})
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	m, err := New(Config{Algorithm: AlgorithmES256}, nil)
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	JWKSHandler(m).ServeHTTP(rec, httptest.NewRequest("GET", JWKSPath, nil))
+
+	var set jose.JSONWebKeySet
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &set))
+	assert.Len(t, set.Keys, 1)
+	assert.True(t, set.Keys[0].IsPublic())
+	assert.Equal(t, m.SigningKey().ID, set.Keys[0].KeyID)
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keyset
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+const _versionLength = 8
+
+var (
+	_ Store = &FileStore{}
+	_ Store = &GormStore{}
+
+	// ErrEncryptionKeyRequired the keyset is persisted without an encryption key.
+	ErrEncryptionKeyRequired = errors.New("keyset encryption key required")
+	// ErrVersionConflict the keyset was saved by another replica since it was loaded.
+	ErrVersionConflict = errors.New("keyset version conflict")
+)
+
+// Store persists the encrypted keyset, so all replicas share the keys across restarts.
+// Saves compare and swap the version, so replicas rotating at once do not drop each other's keys.
+type Store interface {
+	// Load returns the sealed keyset and its version, nil and 0 when nothing was saved yet.
+	Load() ([]byte, int64, error)
+	// Save stores sealed at version+1 if the keyset is still at version, ErrVersionConflict otherwise.
+	Save(sealed []byte, version int64) error
+}
+
+// FileStore keeps the sealed keyset in a local file prefixed with its version. The compare and
+// swap only holds within the process, replicas sharing a keyset use a GormStore.
+type FileStore struct {
+	Path string
+	lock sync.Mutex
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (f *FileStore) Load() ([]byte, int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.load()
+}
+
+func (f *FileStore) load() ([]byte, int64, error) {
+	data, err := ioutil.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < _versionLength {
+		return nil, 0, errors.New("keyset file too short")
+	}
+	return data[_versionLength:], int64(binary.BigEndian.Uint64(data[:_versionLength])), nil
+}
+
+func (f *FileStore) Save(sealed []byte, version int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, current, err := f.load()
+	if err != nil {
+		return err
+	}
+	if current != version {
+		return ErrVersionConflict
+	}
+	data := make([]byte, _versionLength, _versionLength+len(sealed))
+	binary.BigEndian.PutUint64(data, uint64(version+1))
+	data = append(data, sealed...)
+	tmp, err := ioutil.TempFile(filepath.Dir(f.Path), ".keyset-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+// GormStore keeps the sealed keyset in a row of the sys_t_signing_keyset table.
+type GormStore struct {
+	db   *gorm.DB
+	name string
+}
+
+// NewGormStore returns a GormStore of the keyset name, migrating its table.
+func NewGormStore(db *gorm.DB, name string) (*GormStore, error) {
+	if err := db.AutoMigrate(&model.SigningKeyset{}); err != nil {
+		return nil, err
+	}
+	return &GormStore{db: db, name: name}, nil
+}
+
+func (s *GormStore) Load() ([]byte, int64, error) {
+	row := &model.SigningKeyset{Name: s.name}
+	found, err := row.Get(s.db)
+	if err != nil || !found {
+		return nil, 0, err
+	}
+	return row.Sealed, row.Version, nil
+}
+
+func (s *GormStore) Save(sealed []byte, version int64) error {
+	swapped, err := (&model.SigningKeyset{Name: s.name, Sealed: sealed}).CompareAndSwap(s.db, version)
+	if err != nil {
+		return err
+	}
+	if !swapped {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// seal encrypts data with AES-256-GCM under a key derived from secret.
+func seal(secret string, data []byte) ([]byte, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("keyset nonce %w", err)
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func open(secret string, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("decrypt keyset: data too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt keyset %w", err)
+	}
+	return data, nil
+}
+
+func newAEAD(secret string) (cipher.AEAD, error) {
+	if secret == "" {
+		return nil, ErrEncryptionKeyRequired
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("keyset cipher %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+var (
+	_ Manager   = &opaqueManager{}
+	_ Revoker   = &opaqueManager{}
+	_ IDRevoker = &opaqueManager{}
+)
+
+// ErrStoreRequired opaque tokens configured without a store.
+var ErrStoreRequired = errors.New("token store required")
+
+// opaqueManager hands out random reference tokens, the claims never leave the server.
+type opaqueManager struct {
+	conf  *Config
+	store Store
+}
+
+// NewOpaqueManager returns a Manager issuing opaque reference tokens backed by store.
+func NewOpaqueManager(conf *Config, store Store) (Manager, error) {
+	if store == nil {
+		return nil, ErrStoreRequired
+	}
+	return &opaqueManager{conf: conf, store: store}, nil
+}
+
+func (m *opaqueManager) Issue(claims *Claims) (string, error) {
+	token, err := utils.RandBase64String(32)
+	if err != nil {
+		return "", err
+	}
+	key := HashToken(token)
+	stamp(m.conf, claims, key)
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if err = m.store.Save(ctx, key, claims, time.Until(time.Unix(claims.ExpiresAt, 0))); err != nil {
+		return "", fmt.Errorf("save opaque token %w", err)
+	}
+	return token, nil
+}
+
+func (m *opaqueManager) Verify(token string) (*Claims, error) {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	claims, err := m.store.Load(ctx, HashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrTokenNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+		}
+		return nil, fmt.Errorf("load opaque token %w", err)
+	}
+	if err = claims.Valid(); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (m *opaqueManager) Revoke(token string) error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	return m.store.Delete(ctx, HashToken(token))
+}
+
+// RevokeID deletes the token stored under id, the id of opaque tokens is their key.
+func (m *opaqueManager) RevokeID(id string, _ time.Time) error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	return m.store.Delete(ctx, id)
+}
+
+// HashToken returns the key a reference token is stored under,
+// so a leaked store never exposes usable bearer tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
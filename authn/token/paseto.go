@@ -0,0 +1,289 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// PASETO v4 token codec, see https://github.com/paseto-standard/paseto-spec/blob/master/docs/01-Protocol-Versions/Version4.md
+
+const (
+	_pasetoLocalHeader  = "v4.local."
+	_pasetoPublicHeader = "v4.public."
+	_pasetoNonceSize    = 32
+	_pasetoMacSize      = 32
+)
+
+var (
+	_ Manager = &pasetoLocalManager{}
+	_ Manager = &pasetoPublicManager{}
+
+	// ErrInvalidKey key material has the wrong size or encoding.
+	ErrInvalidKey = errors.New("invalid token key")
+)
+
+type pasetoLocalManager struct {
+	conf *Config
+	key  []byte
+}
+
+// NewPASETOLocalManager returns a Manager issuing v4.local (encrypted) PASETO tokens,
+// conf.SigningKey is the base64 encoded 32 bytes symmetric key.
+func NewPASETOLocalManager(conf *Config) (Manager, error) {
+	key, err := decodeKey(conf.SigningKey, chacha20.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return &pasetoLocalManager{conf: conf, key: key}, nil
+}
+
+func (m *pasetoLocalManager) Issue(claims *Claims) (string, error) {
+	id, err := utils.RandBase64String(16)
+	if err != nil {
+		return "", err
+	}
+	stamp(m.conf, claims, id)
+	payload, err := marshalPASETOClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, _pasetoNonceSize)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("paseto nonce %w", err)
+	}
+	encKey, encNonce, authKey := m.splitKeys(nonce)
+	cipher, err := chacha20.NewUnauthenticatedCipher(encKey, encNonce)
+	if err != nil {
+		return "", fmt.Errorf("paseto cipher %w", err)
+	}
+	c := make([]byte, len(payload))
+	cipher.XORKeyStream(c, payload)
+	mac := keyedHash(authKey, _pasetoMacSize, pae([]byte(_pasetoLocalHeader), nonce, c, nil, nil))
+
+	body := make([]byte, 0, len(nonce)+len(c)+len(mac))
+	body = append(append(append(body, nonce...), c...), mac...)
+	return _pasetoLocalHeader + base64.RawURLEncoding.EncodeToString(body), nil
+}
+
+func (m *pasetoLocalManager) Verify(token string) (*Claims, error) {
+	body, footer, err := splitPASETO(token, _pasetoLocalHeader)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < _pasetoNonceSize+_pasetoMacSize {
+		return nil, fmt.Errorf("%w: paseto token too short", ErrInvalidToken)
+	}
+	nonce := body[:_pasetoNonceSize]
+	c := body[_pasetoNonceSize : len(body)-_pasetoMacSize]
+	mac := body[len(body)-_pasetoMacSize:]
+
+	encKey, encNonce, authKey := m.splitKeys(nonce)
+	expected := keyedHash(authKey, _pasetoMacSize, pae([]byte(_pasetoLocalHeader), nonce, c, footer, nil))
+	if subtle.ConstantTimeCompare(mac, expected) != 1 {
+		return nil, fmt.Errorf("%w: paseto authentication failed", ErrInvalidToken)
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encKey, encNonce)
+	if err != nil {
+		return nil, fmt.Errorf("paseto cipher %w", err)
+	}
+	payload := make([]byte, len(c))
+	cipher.XORKeyStream(payload, c)
+	return unmarshalPASETOClaims(payload)
+}
+
+// splitKeys derives the encryption key, the XChaCha20 nonce and the authentication key from the token nonce.
+func (m *pasetoLocalManager) splitKeys(nonce []byte) (encKey, encNonce, authKey []byte) {
+	tmp := keyedHash(m.key, chacha20.KeySize+chacha20.NonceSizeX, append([]byte("paseto-encryption-key"), nonce...))
+	authKey = keyedHash(m.key, _pasetoMacSize, append([]byte("paseto-auth-key-for-aead"), nonce...))
+	return tmp[:chacha20.KeySize], tmp[chacha20.KeySize:], authKey
+}
+
+type pasetoPublicManager struct {
+	conf       *Config
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// NewPASETOPublicManager returns a Manager issuing v4.public (signed) PASETO tokens,
+// conf.SigningKey is the base64 encoded 32 bytes ed25519 seed.
+func NewPASETOPublicManager(conf *Config) (Manager, error) {
+	seed, err := decodeKey(conf.SigningKey, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	return &pasetoPublicManager{
+		conf:       conf,
+		privateKey: privateKey,
+		publicKey:  privateKey.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// NewPASETOPublicVerifier returns a Verifier of v4.public tokens for services only holding the public key.
+func NewPASETOPublicVerifier(publicKey ed25519.PublicKey) (Verifier, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, ErrInvalidKey
+	}
+	return &pasetoPublicManager{publicKey: publicKey}, nil
+}
+
+func (m *pasetoPublicManager) Issue(claims *Claims) (string, error) {
+	if m.privateKey == nil {
+		return "", fmt.Errorf("%w: verify only paseto manager", ErrInvalidKey)
+	}
+	id, err := utils.RandBase64String(16)
+	if err != nil {
+		return "", err
+	}
+	stamp(m.conf, claims, id)
+	payload, err := marshalPASETOClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	sig := ed25519.Sign(m.privateKey, pae([]byte(_pasetoPublicHeader), payload, nil, nil))
+	return _pasetoPublicHeader + base64.RawURLEncoding.EncodeToString(append(payload, sig...)), nil
+}
+
+func (m *pasetoPublicManager) Verify(token string) (*Claims, error) {
+	body, footer, err := splitPASETO(token, _pasetoPublicHeader)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: paseto token too short", ErrInvalidToken)
+	}
+	payload := body[:len(body)-ed25519.SignatureSize]
+	sig := body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(m.publicKey, pae([]byte(_pasetoPublicHeader), payload, footer, nil), sig) {
+		return nil, fmt.Errorf("%w: paseto signature mismatch", ErrInvalidToken)
+	}
+	return unmarshalPASETOClaims(payload)
+}
+
+func splitPASETO(token, header string) (body, footer []byte, err error) {
+	if !strings.HasPrefix(token, header) {
+		return nil, nil, fmt.Errorf("%w: paseto header mismatch", ErrInvalidToken)
+	}
+	parts := strings.Split(token[len(header):], ".")
+	if len(parts) > 2 {
+		return nil, nil, fmt.Errorf("%w: malformed paseto token", ErrInvalidToken)
+	}
+	if body, err = base64.RawURLEncoding.DecodeString(parts[0]); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	if len(parts) == 2 {
+		if footer, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+		}
+	}
+	return body, footer, nil
+}
+
+// pae pre-authentication encoding.
+func pae(pieces ...[]byte) []byte {
+	le64 := func(n int) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(n)&^(1<<63))
+		return b
+	}
+	out := le64(len(pieces))
+	for _, p := range pieces {
+		out = append(out, le64(len(p))...)
+		out = append(out, p...)
+	}
+	return out
+}
+
+func keyedHash(key []byte, size int, msg []byte) []byte {
+	h, err := blake2b.New(size, key)
+	if err != nil {
+		// size and key length are constants of the protocol.
+		panic(err)
+	}
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+func decodeKey(encoded string, size int) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.RawURLEncoding.DecodeString(encoded)
+	}
+	if err != nil || len(key) != size {
+		return nil, fmt.Errorf("%w: want base64 encoded %d bytes", ErrInvalidKey, size)
+	}
+	return key, nil
+}
+
+// marshalPASETOClaims encodes the time claims as RFC 3339 strings as PASETO requires.
+func marshalPASETOClaims(claims *Claims) ([]byte, error) {
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("marshal paseto claims %w", err)
+	}
+	var payload map[string]interface{}
+	if err = json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("marshal paseto claims %w", err)
+	}
+	for _, k := range []string{"exp", "iat", "nbf"} {
+		if v, ok := payload[k].(float64); ok {
+			payload[k] = time.Unix(int64(v), 0).UTC().Format(time.RFC3339)
+		}
+	}
+	return json.Marshal(payload)
+}
+
+func unmarshalPASETOClaims(payload []byte) (*Claims, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	for _, k := range []string{"exp", "iat", "nbf"} {
+		if v, ok := raw[k].(string); ok {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: claim %s %s", ErrInvalidToken, k, err)
+			}
+			raw[k] = t.Unix()
+		}
+	}
+	normalized, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	claims := &Claims{}
+	if err = json.Unmarshal(normalized, claims); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+	if err = claims.Valid(); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
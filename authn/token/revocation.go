@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	_ Manager              = &RevocableManager{}
+	_ Revoker              = &RevocableManager{}
+	_ IDRevoker            = &RevocableManager{}
+	_ RevocationEnumerator = &MemoryRevocationList{}
+
+	// ErrTokenRevoked the token was revoked before it expired.
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
+// RevocationList records the ids of revoked tokens until the tokens expire.
+type RevocationList interface {
+	// Add revokes the token id, the entry may be dropped after expiresAt. A zero expiresAt never expires.
+	Add(id string, expiresAt time.Time) error
+	// Contains reports whether the token id was revoked.
+	Contains(id string) (bool, error)
+}
+
+// MemoryRevocationList in-process RevocationList.
+type MemoryRevocationList struct {
+	lock    sync.RWMutex
+	revoked map[string]time.Time
+}
+
+func NewMemoryRevocationList() *MemoryRevocationList {
+	return &MemoryRevocationList{revoked: make(map[string]time.Time)}
+}
+
+func (l *MemoryRevocationList) Add(id string, expiresAt time.Time) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	for k, exp := range l.revoked {
+		if !exp.IsZero() && now.After(exp) {
+			delete(l.revoked, k)
+		}
+	}
+	l.revoked[id] = expiresAt
+	return nil
+}
+
+func (l *MemoryRevocationList) Contains(id string) (bool, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	_, ok := l.revoked[id]
+	return ok, nil
+}
+
+func (l *MemoryRevocationList) IDs() ([]string, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	now := time.Now()
+	ids := make([]string, 0, len(l.revoked))
+	for id, exp := range l.revoked {
+		if exp.IsZero() || now.Before(exp) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// RevocableManager rejects tokens whose id is on the revocation list, which makes
+// self-contained tokens revocable. Wrap a CachedVerifier with it, not the other way around.
+type RevocableManager struct {
+	Manager
+	list RevocationList
+}
+
+func NewRevocableManager(m Manager, list RevocationList) *RevocableManager {
+	return &RevocableManager{Manager: m, list: list}
+}
+
+func (m *RevocableManager) Verify(token string) (*Claims, error) {
+	claims, err := m.Manager.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ID == "" {
+		return claims, nil
+	}
+	revoked, err := m.list.Contains(claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("check revocation list %w", err)
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// Revoke adds the token to the revocation list and revokes it with the wrapped manager
+// when that is a Revoker, invalid tokens are ignored.
+func (m *RevocableManager) Revoke(token string) error {
+	claims, err := m.Manager.Verify(token)
+	if err != nil {
+		return nil
+	}
+	if revoker, ok := m.Manager.(Revoker); ok {
+		if err = revoker.Revoke(token); err != nil {
+			return err
+		}
+	}
+	if claims.ID == "" {
+		return nil
+	}
+	var expiresAt time.Time
+	if claims.ExpiresAt != 0 {
+		expiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+	if err = m.list.Add(claims.ID, expiresAt); err != nil {
+		return fmt.Errorf("add to revocation list %w", err)
+	}
+	return nil
+}
+
+// RevokeID revokes the token with the jti id, e.g. from admin tooling, and revokes it with the
+// wrapped manager when that is an IDRevoker.
+func (m *RevocableManager) RevokeID(id string, expiresAt time.Time) error {
+	if revoker, ok := m.Manager.(IDRevoker); ok {
+		if err := revoker.RevokeID(id, expiresAt); err != nil {
+			return err
+		}
+	}
+	if err := m.list.Add(id, expiresAt); err != nil {
+		return fmt.Errorf("add to revocation list %w", err)
+	}
+	return nil
+}
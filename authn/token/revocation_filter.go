@@ -0,0 +1,184 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/log"
+)
+
+var _ RevocationList = &FilteredRevocationList{}
+
+const (
+	_defaultRebuildInterval   = 5 * time.Minute
+	_defaultFalsePositiveRate = 0.01
+	// _minFilterCapacity ids a filter is sized for at least, revocations keep coming between rebuilds.
+	_minFilterCapacity = 1024
+)
+
+// RevocationEnumerator a RevocationList listing the ids not expired yet.
+type RevocationEnumerator interface {
+	RevocationList
+	IDs() ([]string, error)
+}
+
+// RevocationNotifier a RevocationList telling the ids revoked by other replicas.
+type RevocationNotifier interface {
+	// Subscribe calls fn with the revoked ids until ctx is done.
+	Subscribe(ctx context.Context, fn func(id string)) error
+}
+
+// FilterConfig of a FilteredRevocationList.
+type FilterConfig struct {
+	// RebuildInterval how often the filter is rebuilt from the list, dropping expired ids and
+	// catching up with missed notifications. Default to 5m.
+	RebuildInterval time.Duration `mapstructure:"rebuild_interval" json:"rebuild_interval" yaml:"rebuildInterval"`
+	// FalsePositiveRate fraction of unrevoked ids still checked with the list. Default to 0.01.
+	FalsePositiveRate float64 `mapstructure:"false_positive_rate" json:"false_positive_rate" yaml:"falsePositiveRate"`
+}
+
+// FilteredRevocationList keeps a bloom filter of the revoked ids in front of a shared list, so
+// checking a token that was not revoked, the common case, never leaves the process. Ids the
+// filter may hold are checked with the list. Revocations of other replicas reach the filter
+// through the list when it is a RevocationNotifier, and with the next rebuild otherwise.
+type FilteredRevocationList struct {
+	list RevocationEnumerator
+	conf FilterConfig
+
+	lock    sync.RWMutex
+	filter  *bloomFilter
+	pending []string
+
+	rebuild sync.Mutex
+	cancel  context.CancelFunc
+	logger  *log.Helper
+}
+
+// NewFilteredRevocationList returns a FilteredRevocationList of list, checking every id with the
+// list until the filter is built by Rebuild or Start.
+func NewFilteredRevocationList(list RevocationEnumerator, conf FilterConfig) *FilteredRevocationList {
+	if conf.RebuildInterval <= 0 {
+		conf.RebuildInterval = _defaultRebuildInterval
+	}
+	if conf.FalsePositiveRate <= 0 || conf.FalsePositiveRate >= 1 {
+		conf.FalsePositiveRate = _defaultFalsePositiveRate
+	}
+	return &FilteredRevocationList{list: list, conf: conf}
+}
+
+// SetLogger writes the entries of the filter refreshes to l instead of the global logger.
+func (l *FilteredRevocationList) SetLogger(logger log.Logger) {
+	l.logger = log.NewHelper(logger)
+}
+
+func (l *FilteredRevocationList) Add(id string, expiresAt time.Time) error {
+	if err := l.list.Add(id, expiresAt); err != nil {
+		return err
+	}
+	l.note(id)
+	return nil
+}
+
+func (l *FilteredRevocationList) Contains(id string) (bool, error) {
+	l.lock.RLock()
+	absent := l.filter != nil && !l.filter.test(id)
+	l.lock.RUnlock()
+	if absent {
+		return false, nil
+	}
+	return l.list.Contains(id)
+}
+
+// Rebuild replaces the filter with one of the ids of the list.
+func (l *FilteredRevocationList) Rebuild() error {
+	l.rebuild.Lock()
+	defer l.rebuild.Unlock()
+	// ids revoked while the list is read are added to the new filter too.
+	l.lock.Lock()
+	l.pending = make([]string, 0)
+	l.lock.Unlock()
+	ids, err := l.list.IDs()
+	if err != nil {
+		l.lock.Lock()
+		l.pending = nil
+		l.lock.Unlock()
+		return fmt.Errorf("list revoked ids %w", err)
+	}
+	capacity := 2 * len(ids)
+	if capacity < _minFilterCapacity {
+		capacity = _minFilterCapacity
+	}
+	filter := newBloomFilter(capacity, l.conf.FalsePositiveRate)
+	for _, id := range ids {
+		filter.add(id)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, id := range l.pending {
+		filter.add(id)
+	}
+	l.pending = nil
+	l.filter = filter
+	return nil
+}
+
+// Start subscribes to the revocations of other replicas and rebuilds the filter every
+// RebuildInterval, the first time right away.
+func (l *FilteredRevocationList) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if notifier, ok := l.list.(RevocationNotifier); ok {
+		if err := notifier.Subscribe(ctx, l.note); err != nil {
+			cancel()
+			return err
+		}
+	}
+	l.cancel = cancel
+	go func() {
+		ticker := time.NewTicker(l.conf.RebuildInterval)
+		defer ticker.Stop()
+		for {
+			if err := l.Rebuild(); err != nil {
+				l.logger.Errorf("rebuild revocation filter %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop ends the subscription and the rebuilds.
+func (l *FilteredRevocationList) Stop() {
+	if l.cancel != nil {
+		l.cancel()
+	}
+}
+
+func (l *FilteredRevocationList) note(id string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.filter != nil {
+		l.filter.add(id)
+	}
+	if l.pending != nil {
+		l.pending = append(l.pending, id)
+	}
+}
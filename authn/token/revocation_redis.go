@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	_ RevocationEnumerator = &RedisRevocationList{}
+	_ RevocationNotifier   = &RedisRevocationList{}
+)
+
+const _defaultRevocationPrefix = "revoked:"
+
+// RedisRevocationList RevocationList shared by all replicas through redis. The ids are also
+// kept in a sorted set by expiry, so filters can be rebuilt from them, and published to the
+// replicas on a channel.
+type RedisRevocationList struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisRevocationList returns a RedisRevocationList keeping the ids under prefix, default to revoked:.
+func NewRedisRevocationList(client redis.UniversalClient, prefix string) *RedisRevocationList {
+	if prefix == "" {
+		prefix = _defaultRevocationPrefix
+	}
+	return &RedisRevocationList{client: client, prefix: prefix}
+}
+
+func (l *RedisRevocationList) Add(id string, expiresAt time.Time) error {
+	if !expiresAt.IsZero() && !time.Now().Before(expiresAt) {
+		// the token expired, it fails verification anyway.
+		return nil
+	}
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	// ids that never expire sort last.
+	score := float64(expiresAt.Unix())
+	if expiresAt.IsZero() {
+		score = float64(1<<53 - 1)
+	}
+	_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if expiresAt.IsZero() {
+			pipe.Set(ctx, l.prefix+"id:"+id, 1, 0)
+		} else {
+			pipe.Set(ctx, l.prefix+"id:"+id, 1, time.Until(expiresAt))
+		}
+		pipe.ZAdd(ctx, l.prefix+"ids", &redis.Z{Score: score, Member: id})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("revoke %w", err)
+	}
+	if err = l.client.Publish(ctx, l.prefix+"events", id).Err(); err != nil {
+		return fmt.Errorf("publish revocation %w", err)
+	}
+	return nil
+}
+
+func (l *RedisRevocationList) Contains(id string) (bool, error) {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	n, err := l.client.Exists(ctx, l.prefix+"id:"+id).Result()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// IDs returns the ids not expired yet, dropping the expired ones from the sorted set.
+func (l *RedisRevocationList) IDs() ([]string, error) {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	key := l.prefix + "ids"
+	if err := l.client.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(time.Now().Unix(), 10)).Err(); err != nil {
+		return nil, err
+	}
+	return l.client.ZRange(ctx, key, 0, -1).Result()
+}
+
+// Subscribe calls fn with the ids revoked by any replica until ctx is done.
+func (l *RedisRevocationList) Subscribe(ctx context.Context, fn func(id string)) error {
+	pubsub := l.client.Subscribe(ctx, l.prefix+"events")
+	// wait for the confirmation so no id revoked after Subscribe returns is missed.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("subscribe revocations %w", err)
+	}
+	go func() {
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				fn(msg.Payload)
+			}
+		}
+	}()
+	return nil
+}
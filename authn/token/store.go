@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Store = &MemoryStore{}
+
+// Store keeps the claims of opaque reference tokens server side.
+type Store interface {
+	// Save stores claims under key, expiring after ttl.
+	Save(ctx context.Context, key string, claims *Claims, ttl time.Duration) error
+	// Load returns the claims stored under key or ErrTokenNotFound.
+	Load(ctx context.Context, key string) (*Claims, error)
+	// Delete removes key, deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+type memoryEntry struct {
+	claims   *Claims
+	expireAt time.Time
+}
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock    sync.RWMutex
+	entries map[string]memoryEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry)}
+}
+
+func (s *MemoryStore) Save(_ context.Context, key string, claims *Claims, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries[key] = memoryEntry{claims: claims, expireAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Load(ctx context.Context, key string) (*Claims, error) {
+	s.lock.RLock()
+	entry, ok := s.entries[key]
+	s.lock.RUnlock()
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	if time.Now().After(entry.expireAt) {
+		_ = s.Delete(ctx, key)
+		return nil, ErrTokenNotFound
+	}
+	return entry.claims, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// RevokeSubject deletes all tokens of subject in the tenant, e.g. after a password reset.
+func (s *MemoryStore) RevokeSubject(tenantID, subject string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, entry := range s.entries {
+		if entry.claims.TenantID == tenantID && entry.claims.Subject == subject {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
+
+// RevokeTenant deletes all tokens of the tenant, e.g. when it is offboarded.
+func (s *MemoryStore) RevokeTenant(tenantID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, entry := range s.entries {
+		if entry.claims.TenantID == tenantID {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
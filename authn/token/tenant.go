@@ -0,0 +1,71 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTenantRequired the token carries no tenant_id.
+	ErrTenantRequired = errors.New("token without tenant")
+	// ErrTenantMismatch the tenant of the token is not the tenant of the request.
+	ErrTenantMismatch = errors.New("token tenant mismatch")
+)
+
+// CheckTenant checks the token was issued for tenantID, an empty tenantID accepts any tenant.
+func CheckTenant(claims *Claims, tenantID string) error {
+	if tenantID == "" {
+		return nil
+	}
+	if claims.TenantID == "" {
+		return ErrTenantRequired
+	}
+	if claims.TenantID != tenantID {
+		return fmt.Errorf("%w: token of %s used for %s", ErrTenantMismatch, claims.TenantID, tenantID)
+	}
+	return nil
+}
+
+// RequireTenant a ClaimValidator rejecting tokens without tenant_id, for services where every
+// request is tenant scoped.
+func RequireTenant(claims *Claims) error {
+	if claims.TenantID == "" {
+		return ErrTenantRequired
+	}
+	return nil
+}
+
+// ForTenant returns a Verifier only accepting the tokens of tenantID, e.g. for a service
+// deployed per tenant.
+func ForTenant(v Verifier, tenantID string) Verifier {
+	return &tenantVerifier{Verifier: v, tenantID: tenantID}
+}
+
+type tenantVerifier struct {
+	Verifier
+	tenantID string
+}
+
+func (v *tenantVerifier) Verify(token string) (*Claims, error) {
+	claims, err := v.Verifier.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if err = CheckTenant(claims, v.tenantID); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token/keyset"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/validation"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	// FormatJWT issues self-contained signed JWTs.
+	FormatJWT = "jwt"
+	// FormatOpaque issues random reference tokens whose claims live in a Store.
+	FormatOpaque = "opaque"
+	// FormatPASETOLocal issues PASETO v4.local encrypted tokens.
+	FormatPASETOLocal = "paseto.local"
+	// FormatPASETOPublic issues PASETO v4.public signed tokens.
+	FormatPASETOPublic = "paseto.public"
+
+	// AlgorithmNone unsecured JWTs, never accepted.
+	AlgorithmNone = "none"
+	// AlgorithmHS256 HMAC using SHA-256.
+	AlgorithmHS256 = "HS256"
+	// AlgorithmHS384 HMAC using SHA-384.
+	AlgorithmHS384 = "HS384"
+	// AlgorithmHS512 HMAC using SHA-512.
+	AlgorithmHS512 = "HS512"
+	// AlgorithmRS256 RSASSA-PKCS1-v1_5 using SHA-256.
+	AlgorithmRS256 = keyset.AlgorithmRS256
+	// AlgorithmES256 ECDSA using P-256 and SHA-256.
+	AlgorithmES256 = keyset.AlgorithmES256
+	// AlgorithmEdDSA Ed25519 signatures.
+	AlgorithmEdDSA = keyset.AlgorithmEdDSA
+
+	_defaultAccessTokenTTL = time.Hour
+)
+
+var (
+	// ErrInvalidToken the token is malformed or its signature does not verify.
+	ErrInvalidToken = errs.ErrInvalidToken
+	// ErrTokenExpired the token is past its expiry.
+	ErrTokenExpired = errs.ErrTokenExpired
+	// ErrTokenNotFound the reference token is unknown to the store.
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrUnsupportedFormat the configured token format is unknown.
+	ErrUnsupportedFormat = errors.New("unsupported token format")
+	// ErrUnsupportedAlgorithm the configured signing algorithm is unknown or not allowed.
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// Issuer hands out access tokens for claims.
+type Issuer interface {
+	// Issue encodes the claims into a token string.
+	Issue(claims *Claims) (string, error)
+}
+
+// Verifier validates tokens produced by an Issuer.
+type Verifier interface {
+	// Verify parses the token string and returns its validated claims.
+	Verify(token string) (*Claims, error)
+}
+
+// Revoker invalidates issued tokens before they expire.
+type Revoker interface {
+	// Revoke invalidates token, revoking an unknown token is not an error.
+	Revoke(token string) error
+}
+
+// IDRevoker invalidates issued tokens by their jti, for callers that keep the id but not the token.
+type IDRevoker interface {
+	// RevokeID invalidates the token with id expiring at expiresAt, revoking an unknown id is not an error.
+	RevokeID(id string, expiresAt time.Time) error
+}
+
+// Manager issues and verifies tokens of one format.
+type Manager interface {
+	Issuer
+	Verifier
+}
+
+// Claims carried by (or referenced from) an access token.
+type Claims struct {
+	// ID unique token identifier.
+	ID string `json:"jti,omitempty"`
+	// Issuer identifies the principal that issued the token.
+	Issuer string `json:"iss,omitempty"`
+	// Subject identifier for the End-User.
+	Subject string `json:"sub,omitempty"`
+	// Audience the recipients the token is intended for.
+	Audience string `json:"aud,omitempty"`
+	// TenantID tenant the subject belongs to.
+	TenantID string `json:"tenant_id,omitempty"`
+	// Username the name the End-User is referred to in tkeel.
+	Username string `json:"username,omitempty"`
+	// Scope space separated scopes granted to the token.
+	Scope string `json:"scope,omitempty"`
+	// IssuedAt unix time the token was issued.
+	IssuedAt int64 `json:"iat,omitempty"`
+	// NotBefore unix time before which the token must not be accepted.
+	NotBefore int64 `json:"nbf,omitempty"`
+	// ExpiresAt unix time after which the token must not be accepted.
+	ExpiresAt int64 `json:"exp,omitempty"`
+	// Confirmation binds the token to a proof-of-possession key.
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	// Actor the party acting as the subject, set on impersonation and delegation tokens.
+	Actor *Actor `json:"act,omitempty"`
+	// Extra other extensions.
+	Extra map[string]interface{} `json:"ext,omitempty"`
+}
+
+// Confirmation the key a token is bound to, see https://datatracker.ietf.org/doc/html/rfc7800
+type Confirmation struct {
+	// JKT base64url SHA-256 thumbprint of the DPoP key.
+	JKT string `json:"jkt,omitempty"`
+}
+
+// Actor the act claim, see https://datatracker.ietf.org/doc/html/rfc8693#section-4.1
+type Actor struct {
+	Subject  string `json:"sub"`
+	TenantID string `json:"tenant_id,omitempty"`
+	// Actor the prior actor of a chain of delegations.
+	Actor *Actor `json:"act,omitempty"`
+}
+
+// Valid checks the time based claims, satisfies jwt.Claims.
+func (c *Claims) Valid() error {
+	now := time.Now().Unix()
+	if c.ExpiresAt != 0 && now >= c.ExpiresAt {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && now < c.NotBefore {
+		return fmt.Errorf("token not valid yet: %w", ErrInvalidToken)
+	}
+	return nil
+}
+
+// Config of the internal token issuer.
+type Config struct {
+	// Issuer value of the iss claim.
+	Issuer string `mapstructure:"issuer" json:"issuer" yaml:"issuer"`
+	// Format token format, jwt, opaque, paseto.local or paseto.public. Default to jwt.
+	Format string `mapstructure:"format" json:"format" yaml:"format"`
+	// SigningKey secret used to sign jwt tokens,
+	// base64 encoded key for the paseto formats.
+	SigningKey string `mapstructure:"signing_key" json:"-" yaml:"signingKey"`
+	// Algorithm HMAC algorithm jwt tokens are signed with using SigningKey, HS256, HS384 or HS512.
+	// Keyset signed tokens use the algorithm of the keyset. Default to HS256.
+	Algorithm string `mapstructure:"algorithm" json:"algorithm" yaml:"algorithm"`
+	// AllowedAlgorithms jwt algorithms accepted on verify, none is always rejected.
+	// Default to the issuing algorithm for HMAC, to RS256, ES256 and EdDSA for keyset signed tokens.
+	AllowedAlgorithms []string `mapstructure:"allowed_algorithms" json:"allowed_algorithms" yaml:"allowedAlgorithms"`
+	// AccessTokenTTL lifetime of issued tokens. Default to 1h.
+	AccessTokenTTL time.Duration `mapstructure:"access_token_ttl" json:"access_token_ttl" yaml:"accessTokenTTL"`
+}
+
+func (conf *Config) ttl() time.Duration {
+	if conf.AccessTokenTTL <= 0 {
+		return _defaultAccessTokenTTL
+	}
+	return conf.AccessTokenTTL
+}
+
+// Validate reports all the problems of the configuration.
+func (conf *Config) Validate() error {
+	r := validation.New()
+	if conf.AccessTokenTTL < 0 {
+		r.Add("accessTokenTTL", "must not be negative", "leave it unset for the 1h default")
+	}
+	for _, alg := range conf.AllowedAlgorithms {
+		if alg == AlgorithmNone || jwt.GetSigningMethod(alg) == nil {
+			r.Add("allowedAlgorithms", fmt.Sprintf("unsupported algorithm %q", alg), "use HS256, HS384, HS512, RS256, ES256 or EdDSA")
+		}
+	}
+	switch strings.ToLower(conf.Format) {
+	case "", FormatJWT:
+		switch conf.Algorithm {
+		case "", AlgorithmHS256, AlgorithmHS384, AlgorithmHS512:
+			r.Required("signingKey", conf.SigningKey, "required to sign HMAC jwt tokens, or set algorithm to the keyset algorithm")
+		case AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA:
+		default:
+			r.Add("algorithm", fmt.Sprintf("unsupported algorithm %q", conf.Algorithm), "use HS256, HS384 or HS512, RS256, ES256 or EdDSA with a keyset")
+		}
+	case FormatOpaque:
+	case FormatPASETOLocal:
+		if _, err := decodeKey(conf.SigningKey, chacha20.KeySize); err != nil {
+			r.Add("signingKey", "invalid paseto.local key", fmt.Sprintf("use a base64 encoded %d bytes key", chacha20.KeySize))
+		}
+	case FormatPASETOPublic:
+		if _, err := decodeKey(conf.SigningKey, ed25519.SeedSize); err != nil {
+			r.Add("signingKey", "invalid paseto.public key", fmt.Sprintf("use a base64 encoded %d bytes ed25519 seed", ed25519.SeedSize))
+		}
+	default:
+		r.Add("format", fmt.Sprintf("unsupported format %q", conf.Format), "use jwt, opaque, paseto.local or paseto.public")
+	}
+	return r.Err()
+}
+
+// NewManager returns the Manager for the configured format,
+// store is only required for opaque tokens.
+func NewManager(conf *Config, store Store) (Manager, error) {
+	switch strings.ToLower(conf.Format) {
+	case "", FormatJWT:
+		return NewJWTManager(conf)
+	case FormatOpaque:
+		return NewOpaqueManager(conf, store)
+	case FormatPASETOLocal:
+		return NewPASETOLocalManager(conf)
+	case FormatPASETOPublic:
+		return NewPASETOPublicManager(conf)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, conf.Format)
+	}
+}
+
+// stamp fills the registered claims the issuer is responsible for.
+func stamp(conf *Config, claims *Claims, id string) {
+	now := time.Now()
+	claims.ID = id
+	if claims.Issuer == "" {
+		claims.Issuer = conf.Issuer
+	}
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = now.Unix()
+	}
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = now.Add(conf.ttl()).Unix()
+	}
+}
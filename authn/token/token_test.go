@@ -0,0 +1,465 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token/keyset"
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/validation"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManagerIssueVerify(t *testing.T) {
+	tests := []struct {
+		name string
+		conf *Config
+	}{
+		{"jwt", &Config{Issuer: "tkeel", SigningKey: "secret"}},
+		{"opaque", &Config{Issuer: "tkeel", Format: FormatOpaque}},
+		{"paseto.local", &Config{Issuer: "tkeel", Format: FormatPASETOLocal, SigningKey: "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="}},
+		{"paseto.public", &Config{Issuer: "tkeel", Format: FormatPASETOPublic, SigningKey: "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewManager(tt.conf, NewMemoryStore())
+			assert.NoError(t, err)
+			token, err := m.Issue(&Claims{Subject: "usr-1", TenantID: "tenant-1"})
+			assert.NoError(t, err)
+			claims, err := m.Verify(token)
+			assert.NoError(t, err)
+			assert.Equal(t, "usr-1", claims.Subject)
+			assert.Equal(t, "tenant-1", claims.TenantID)
+			assert.Equal(t, "tkeel", claims.Issuer)
+
+			_, err = m.Verify(token + "x")
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestOpaqueTokenCarriesNoClaims(t *testing.T) {
+	m, err := NewOpaqueManager(&Config{}, NewMemoryStore())
+	assert.NoError(t, err)
+	token, err := m.Issue(&Claims{Subject: "usr-1", Username: "admin"})
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(token, "."))
+	assert.False(t, strings.Contains(token, "admin"))
+}
+
+func TestVerifyExpired(t *testing.T) {
+	m, err := NewManager(&Config{SigningKey: "secret"}, nil)
+	assert.NoError(t, err)
+	token, err := m.Issue(&Claims{Subject: "usr-1", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	assert.NoError(t, err)
+	_, err = m.Verify(token)
+	assert.ErrorIs(t, err, ErrTokenExpired)
+}
+
+func TestJWTKeySetManager(t *testing.T) {
+	for _, alg := range []string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			keys, err := keyset.New(keyset.Config{Algorithm: alg}, nil)
+			assert.NoError(t, err)
+			m, err := NewJWTKeySetManager(&Config{}, keys)
+			assert.NoError(t, err)
+			token, err := m.Issue(&Claims{Subject: "usr-1"})
+			assert.NoError(t, err)
+
+			assert.NoError(t, keys.Rotate())
+			claims, err := m.Verify(token)
+			assert.NoError(t, err)
+			assert.Equal(t, "usr-1", claims.Subject)
+		})
+	}
+}
+
+func TestJWTRejectsUnexpectedAlgorithm(t *testing.T) {
+	m, err := NewJWTManager(&Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+
+	unsecured, err := jwt.NewWithClaims(jwt.SigningMethodNone, &Claims{Subject: "usr-1"}).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.NoError(t, err)
+	_, err = m.Verify(unsecured)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	downgraded, err := jwt.NewWithClaims(jwt.SigningMethodHS512, &Claims{Subject: "usr-1"}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	_, err = m.Verify(downgraded)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = NewJWTManager(&Config{SigningKey: "secret", AllowedAlgorithms: []string{AlgorithmNone}})
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestHookedManager(t *testing.T) {
+	m, err := NewJWTManager(&Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	hooked := NewHookedManager(m)
+	hooked.RegisterClaimEnricher(func(claims *Claims) error {
+		claims.Extra = map[string]interface{}{"plan": "pro"}
+		return nil
+	})
+	hooked.RegisterClaimValidator(func(claims *Claims) error {
+		if claims.TenantID == "" {
+			return errors.New("tenant required")
+		}
+		return nil
+	})
+
+	token, err := hooked.Issue(&Claims{Subject: "usr-1", TenantID: "tenant-1"})
+	assert.NoError(t, err)
+	claims, err := hooked.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "pro", claims.Extra["plan"])
+
+	token, err = hooked.Issue(&Claims{Subject: "usr-1"})
+	assert.NoError(t, err)
+	_, err = hooked.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestCachedVerifier(t *testing.T) {
+	m, err := NewOpaqueManager(&Config{}, NewMemoryStore())
+	assert.NoError(t, err)
+	cached := NewCachedVerifier(m, 1)
+	first, err := m.Issue(&Claims{Subject: "usr-1"})
+	assert.NoError(t, err)
+	second, err := m.Issue(&Claims{Subject: "usr-2"})
+	assert.NoError(t, err)
+
+	for _, token := range []string{first, first, second, first} {
+		_, err = cached.Verify(token)
+		assert.NoError(t, err)
+	}
+	stats := cached.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(3), stats.Misses)
+	assert.Equal(t, 1, stats.Size)
+
+	cached.Invalidate(first)
+	assert.Equal(t, 0, cached.Stats().Size)
+	assert.NoError(t, cached.Purge())
+}
+
+func TestCachedVerifierMaxTTL(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	m, err := NewOpaqueManager(&Config{}, NewMemoryStore())
+	assert.NoError(t, err)
+	cached := NewCachedVerifierWith(m, cache.NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "test:"), time.Minute)
+	token, err := m.Issue(&Claims{Subject: "usr-1", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	assert.NoError(t, err)
+	_, err = cached.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, mr.TTL("test:"+_cacheKeyPrefix+HashToken(token)))
+
+	// revoked tokens verify at most until the cached claims expire.
+	assert.NoError(t, m.(Revoker).Revoke(token))
+	_, err = cached.Verify(token)
+	assert.NoError(t, err)
+	mr.FastForward(2 * time.Minute)
+	_, err = cached.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestRevocableManager(t *testing.T) {
+	m, err := NewJWTManager(&Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	list := NewMemoryRevocationList()
+	revocable := NewRevocableManager(m, list)
+	token, err := revocable.Issue(&Claims{Subject: "usr-1"})
+	assert.NoError(t, err)
+	claims, err := revocable.Verify(token)
+	assert.NoError(t, err)
+
+	assert.NoError(t, revocable.Revoke(token))
+	_, err = revocable.Verify(token)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+	revoked, err := list.Contains(claims.ID)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	assert.NoError(t, revocable.Revoke("not-a-token"))
+}
+
+func TestForTenant(t *testing.T) {
+	m, err := NewJWTManager(&Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	t1, err := m.Issue(&Claims{Subject: "usr-1", TenantID: "t1"})
+	assert.NoError(t, err)
+	none, err := m.Issue(&Claims{Subject: "usr-1"})
+	assert.NoError(t, err)
+
+	v := ForTenant(m, "t1")
+	_, err = v.Verify(t1)
+	assert.NoError(t, err)
+	_, err = v.Verify(none)
+	assert.ErrorIs(t, err, ErrTenantRequired)
+	_, err = ForTenant(m, "t2").Verify(t1)
+	assert.ErrorIs(t, err, ErrTenantMismatch)
+
+	hooked := NewHookedManager(m)
+	hooked.RegisterClaimValidator(RequireTenant)
+	_, err = hooked.Verify(none)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+type countingVerifier struct {
+	Verifier
+	calls int32
+}
+
+func (v *countingVerifier) Verify(raw string) (*Claims, error) {
+	atomic.AddInt32(&v.calls, 1)
+	return v.Verifier.Verify(raw)
+}
+
+func TestVerifyBatch(t *testing.T) {
+	m, err := NewJWTManager(&Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	first, err := m.Issue(&Claims{Subject: "dev-1"})
+	assert.NoError(t, err)
+	second, err := m.Issue(&Claims{Subject: "dev-2"})
+	assert.NoError(t, err)
+
+	v := &countingVerifier{Verifier: m}
+	results := VerifyBatch(context.Background(), v, []string{first, "garbage", first, second}, 2)
+	assert.Len(t, results, 4)
+	assert.Equal(t, "dev-1", results[0].Claims.Subject)
+	assert.ErrorIs(t, results[1].Err, ErrInvalidToken)
+	assert.Equal(t, "dev-1", results[2].Claims.Subject)
+	assert.NotSame(t, results[0].Claims, results[2].Claims)
+	assert.Equal(t, "dev-2", results[3].Claims.Subject)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&v.calls), "repeated tokens are verified once")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = VerifyBatch(ctx, m, []string{first}, 0)
+	assert.ErrorIs(t, results[0].Err, context.Canceled)
+	assert.Empty(t, VerifyBatch(context.Background(), m, nil, 0))
+}
+
+func TestJWTMalformed(t *testing.T) {
+	m, err := NewJWTManager(&Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	raw, err := m.Issue(&Claims{Subject: "alice"})
+	assert.NoError(t, err)
+	parts := strings.Split(raw, ".")
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"two segments", parts[0] + "." + parts[1]},
+		{"four segments", raw + ".x"},
+		{"bad header", "!!." + parts[1] + "." + parts[2]},
+		{"bad payload", parts[0] + ".!!." + parts[2]},
+		{"bad signature", parts[0] + "." + parts[1] + ".!!"},
+		{"other payload", parts[0] + "." + jwt.EncodeSegment([]byte(`{"sub":"mallory"}`)) + "." + parts[2]},
+		{"none", jwt.EncodeSegment([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Verify(tt.token)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func BenchmarkJWTVerify(b *testing.B) {
+	m, err := NewJWTManager(&Config{SigningKey: "secret"})
+	assert.NoError(b, err)
+	raw, err := m.Issue(&Claims{Subject: "alice", TenantID: "t1", Scope: "read write"})
+	assert.NoError(b, err)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.Verify(raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type countingRevocationList struct {
+	RevocationEnumerator
+	checks int32
+}
+
+func (l *countingRevocationList) Contains(id string) (bool, error) {
+	atomic.AddInt32(&l.checks, 1)
+	return l.RevocationEnumerator.Contains(id)
+}
+
+func TestFilteredRevocationList(t *testing.T) {
+	list := &countingRevocationList{RevocationEnumerator: NewMemoryRevocationList()}
+	assert.NoError(t, list.Add("before", time.Time{}))
+	filtered := NewFilteredRevocationList(list, FilterConfig{})
+	revoked, err := filtered.Contains("before")
+	assert.NoError(t, err)
+	assert.True(t, revoked, "checked with the list until the filter is built")
+
+	assert.NoError(t, filtered.Rebuild())
+	assert.NoError(t, filtered.Add("after", time.Now().Add(time.Hour)))
+	for _, id := range []string{"before", "after"} {
+		revoked, err = filtered.Contains(id)
+		assert.NoError(t, err)
+		assert.True(t, revoked, id)
+	}
+	checks := atomic.LoadInt32(&list.checks)
+	for i := 0; i < 100; i++ {
+		revoked, err = filtered.Contains(fmt.Sprintf("live-%d", i))
+		assert.NoError(t, err)
+		assert.False(t, revoked)
+	}
+	assert.Less(t, atomic.LoadInt32(&list.checks)-checks, int32(5), "unrevoked ids stay in process")
+}
+
+func TestRedisRevocationList(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	// two replicas sharing the list.
+	first := NewFilteredRevocationList(NewRedisRevocationList(client, ""), FilterConfig{})
+	second := NewFilteredRevocationList(NewRedisRevocationList(client, ""), FilterConfig{})
+	for _, l := range []*FilteredRevocationList{first, second} {
+		assert.NoError(t, l.Start())
+		defer l.Stop()
+	}
+	assert.Eventually(t, func() bool {
+		first.lock.RLock()
+		defer first.lock.RUnlock()
+		second.lock.RLock()
+		defer second.lock.RUnlock()
+		return first.filter != nil && second.filter != nil
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, first.Add("jti-1", time.Now().Add(time.Hour)))
+	assert.NoError(t, first.Add("expired", time.Now().Add(-time.Minute)))
+	assert.Eventually(t, func() bool {
+		revoked, err := second.Contains("jti-1")
+		return err == nil && revoked
+	}, time.Second, 10*time.Millisecond)
+	revoked, err := second.Contains("expired")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	ids, err := NewRedisRevocationList(client, "").IDs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"jti-1"}, ids)
+	mr.FastForward(2 * time.Hour)
+	revoked, err = NewRedisRevocationList(client, "").Contains("jti-1")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		conf   Config
+		fields []string
+	}{
+		{"hmac", Config{SigningKey: "secret"}, nil},
+		{"keyset", Config{Algorithm: AlgorithmRS256}, nil},
+		{"opaque", Config{Format: FormatOpaque}, nil},
+		{"missing signing key", Config{}, []string{"signingKey"}},
+		{"all problems", Config{Algorithm: "HS1", AllowedAlgorithms: []string{AlgorithmNone}, AccessTokenTTL: -time.Second},
+			[]string{"accessTokenTTL", "allowedAlgorithms", "algorithm"}},
+		{"paseto key", Config{Format: FormatPASETOLocal, SigningKey: "c2hvcnQ="}, []string{"signingKey"}},
+		{"format", Config{Format: "saml"}, []string{"format"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.conf.Validate()
+			if tt.fields == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *validation.Error
+			assert.True(t, errors.As(err, &verr))
+			fields := make([]string, 0, len(verr.Problems))
+			for _, p := range verr.Problems {
+				fields = append(fields, p.Field)
+			}
+			assert.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestClaimGetters(t *testing.T) {
+	var c map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"email":"a@example.com","amr":["pwd","otp"],"groups":"admins","scope":"read write","auth_time":1700000000,"admin":true}`), &c))
+
+	assert.Equal(t, "a@example.com", ClaimString(c, "email"))
+	assert.Equal(t, "", ClaimString(c, "amr"))
+	assert.Equal(t, []string{"pwd", "otp"}, ClaimStrings(c, "amr"))
+	assert.Equal(t, []string{"admins"}, ClaimStrings(c, "groups"))
+	assert.Equal(t, []string{"read", "write"}, ClaimFields(c, "scope"))
+	assert.Equal(t, time.Unix(1700000000, 0), ClaimTime(c, "auth_time"))
+	assert.True(t, ClaimTime(c, "email").IsZero())
+	assert.True(t, ClaimBool(c, "admin"))
+	_, ok := ClaimInt64(c, "missing")
+	assert.False(t, ok)
+}
+
+func TestBindClaims(t *testing.T) {
+	type profile struct {
+		Email    string    `json:"email"`
+		Age      int       `json:"age,omitempty"`
+		Groups   []string  `json:"groups,omitempty"`
+		AuthTime time.Time `json:"auth_time,omitempty"`
+	}
+	tests := []struct {
+		name    string
+		claims  string
+		mode    ClaimMode
+		want    profile
+		wantErr error
+	}{
+		{"strict", `{"email":"a@example.com","age":42,"groups":["admins"],"auth_time":1700000000}`, ClaimsStrict,
+			profile{Email: "a@example.com", Age: 42, Groups: []string{"admins"}, AuthTime: time.Unix(1700000000, 0)}, nil},
+		{"strict missing", `{"age":42}`, ClaimsStrict, profile{}, ErrMissingClaim},
+		{"strict type", `{"email":"a@example.com","age":"42"}`, ClaimsStrict, profile{}, ErrClaimType},
+		{"lenient", `{"age":"42","groups":"admins"}`, ClaimsLenient, profile{Age: 42, Groups: []string{"admins"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c map[string]interface{}
+			assert.NoError(t, json.Unmarshal([]byte(tt.claims), &c))
+			var got profile
+			err := BindClaims(c, &got, tt.mode)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
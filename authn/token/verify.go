@@ -0,0 +1,200 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package token
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// _maxCachedHeaders bounds the decoded headers kept, there is one per signing key and algorithm
+// in practice, so reaching it means junk headers and the cache starts over.
+const _maxCachedHeaders = 1024
+
+var errMalformed = errors.New("token is malformed")
+
+// jwtHeader the fields of a JOSE header verification looks at.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// headerCache decoded headers by their encoded segment, the tokens of a key all share it.
+type headerCache struct {
+	lock    sync.RWMutex
+	headers map[string]jwtHeader
+}
+
+func (c *headerCache) decode(segment string) (jwtHeader, error) {
+	c.lock.RLock()
+	h, ok := c.headers[segment]
+	c.lock.RUnlock()
+	if ok {
+		return h, nil
+	}
+	var buf []byte
+	data, err := decodeSegment(&buf, segment)
+	if err != nil {
+		return h, err
+	}
+	if err = json.Unmarshal(data, &h); err != nil {
+		return h, fmt.Errorf("%w: header %s", errMalformed, err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.headers == nil || len(c.headers) >= _maxCachedHeaders {
+		c.headers = make(map[string]jwtHeader)
+	}
+	// copied, the segment shares the memory of the whole token.
+	c.headers[string([]byte(segment))] = h
+	return h, nil
+}
+
+// _buffers pools the buffers the HMAC input and decoded segments are written to.
+var _buffers = sync.Pool{New: func() interface{} {
+	b := make([]byte, 0, 1024)
+	return &b
+}}
+
+// macPool reuses the HMACs of a key, keyed hashes are costly to set up for every token.
+type macPool struct {
+	pool sync.Pool
+}
+
+func newMACPool(alg string, key []byte) *macPool {
+	var h crypto.Hash
+	switch alg {
+	case AlgorithmHS384:
+		h = crypto.SHA384
+	case AlgorithmHS512:
+		h = crypto.SHA512
+	default:
+		h = crypto.SHA256
+	}
+	return &macPool{pool: sync.Pool{New: func() interface{} { return hmac.New(h.New, key) }}}
+}
+
+// verify checks signature is the MAC of signingString.
+func (p *macPool) verify(signingString, signature string) error {
+	mac := p.pool.Get().(hash.Hash)
+	defer p.pool.Put(mac)
+	mac.Reset()
+	input := _buffers.Get().(*[]byte)
+	defer _buffers.Put(input)
+	*input = append((*input)[:0], signingString...)
+	mac.Write(*input)
+	// the sum goes after the input, the buffer already holds it.
+	*input = mac.Sum(*input)
+	sum := (*input)[len(signingString):]
+
+	decoded := _buffers.Get().(*[]byte)
+	defer _buffers.Put(decoded)
+	sig, err := decodeSegment(decoded, signature)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(sum, sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// verifyFast verifies token without the generic header map of the jwt parser, the claims are
+// decoded straight into Claims.
+func (m *jwtManager) verifyFast(token string) (*Claims, error) {
+	dot := strings.IndexByte(token, '.')
+	last := strings.LastIndexByte(token, '.')
+	if dot <= 0 || last == dot || strings.IndexByte(token[dot+1:last], '.') >= 0 {
+		return nil, errMalformed
+	}
+	h, err := m.headers.decode(token[:dot])
+	if err != nil {
+		return nil, err
+	}
+	if !utils.StringsInclude(m.parser.ValidMethods, h.Alg) {
+		return nil, fmt.Errorf("signing method %s is invalid", h.Alg)
+	}
+	signingString, signature := token[:last], token[last+1:]
+	if m.keys == nil {
+		if h.Alg != m.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v", h.Alg)
+		}
+		err = m.macs.verify(signingString, signature)
+	} else {
+		err = m.verifyKeySet(h, signingString, signature)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	buf := _buffers.Get().(*[]byte)
+	defer _buffers.Put(buf)
+	payload, err := decodeSegment(buf, token[dot+1:last])
+	if err != nil {
+		return nil, err
+	}
+	claims := &Claims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("%w: claims %s", errMalformed, err)
+	}
+	if err = claims.Valid(); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (m *jwtManager) verifyKeySet(h jwtHeader, signingString, signature string) error {
+	key, err := m.keys.Key(h.Kid)
+	if err != nil {
+		return err
+	}
+	// the key is bound to its algorithm, a token naming another one is a downgrade attempt.
+	if h.Alg != key.Algorithm {
+		return fmt.Errorf("unexpected signing method %v", h.Alg)
+	}
+	method := jwt.GetSigningMethod(h.Alg)
+	if method == nil {
+		return fmt.Errorf("signing method %s is invalid", h.Alg)
+	}
+	return method.Verify(signingString, signature, key.Public())
+}
+
+// decodeSegment base64url decodes segment, padded or not, in the buffer of buf, growing it when
+// needed. The segment is copied in first, so decoding allocates nothing once the buffer is large
+// enough. The result is only valid until the buffer is reused.
+func decodeSegment(buf *[]byte, segment string) ([]byte, error) {
+	segment = strings.TrimRight(segment, "=")
+	n := len(segment) + base64.RawURLEncoding.DecodedLen(len(segment))
+	if cap(*buf) < n {
+		*buf = make([]byte, n)
+	}
+	b := (*buf)[:n]
+	src := b[:copy(b, segment)]
+	written, err := base64.RawURLEncoding.Decode(b[len(src):], src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errMalformed, err)
+	}
+	return b[len(src) : len(src)+written], nil
+}
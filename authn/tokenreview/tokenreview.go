@@ -0,0 +1,212 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tokenreview serves the Kubernetes TokenReview API, so API servers and other webhook
+// token authenticators delegate the authentication of bearer tokens to tkeel security.
+// See also, https://kubernetes.io/docs/reference/access-authn-authz/authentication/#webhook-token-authentication
+package tokenreview
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	// Kind of TokenReview objects.
+	Kind = "TokenReview"
+	// ExtraTenantID extra of the user holding the tenant of the token.
+	ExtraTenantID = "tkeel.io/tenant-id"
+	// ExtraScopes extra of the user holding the scopes of the token.
+	ExtraScopes = "tkeel.io/scopes"
+
+	_defaultAPIVersion = "authentication.k8s.io/v1"
+	_maxBodySize       = 1 << 20
+	// _systemPrefix the prefix of the users and groups Kubernetes reserves, e.g. system:masters.
+	_systemPrefix = "system:"
+)
+
+// ErrPrefixRequired the usernames or groups would not be namespaced.
+var ErrPrefixRequired = errors.New("token review username and groups prefixes required")
+
+// _apiVersions the versions of the API served, their schema is the same.
+var _apiVersions = []string{"authentication.k8s.io/v1", "authentication.k8s.io/v1beta1"}
+
+// TokenReview a request to authenticate a token and its answer.
+type TokenReview struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+	Spec       Spec                   `json:"spec"`
+	Status     Status                 `json:"status"`
+}
+
+// Spec the token to authenticate.
+type Spec struct {
+	Token string `json:"token"`
+	// Audiences the token must be valid for, empty for the audiences of the API server.
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+// Status the result of the review.
+type Status struct {
+	Authenticated bool      `json:"authenticated"`
+	User          *UserInfo `json:"user,omitempty"`
+	// Audiences of Spec.Audiences the token is valid for.
+	Audiences []string `json:"audiences,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// UserInfo the user the token authenticates.
+type UserInfo struct {
+	Username string              `json:"username"`
+	UID      string              `json:"uid,omitempty"`
+	Groups   []string            `json:"groups,omitempty"`
+	Extra    map[string][]string `json:"extra,omitempty"`
+}
+
+// Config of the endpoint.
+type Config struct {
+	// UsernamePrefix prefixes the usernames, e.g. "tkeel:" so they don't collide with other
+	// authenticators or the system: users. Required.
+	UsernamePrefix string `mapstructure:"username_prefix" json:"username_prefix" yaml:"usernamePrefix"`
+	// GroupsPrefix prefixes the groups, the IdPs of tenants assert them. Required.
+	GroupsPrefix string `mapstructure:"groups_prefix" json:"groups_prefix" yaml:"groupsPrefix"`
+	// Audiences the tokens are accepted for: tokens without audience are valid for all of them,
+	// tokens with an audience only when it is one of them.
+	Audiences []string `mapstructure:"audiences" json:"audiences" yaml:"audiences"`
+}
+
+// Handler answers TokenReview requests with the claims of the tokens verified by a token.Verifier.
+// It does not authenticate its callers, serve it behind mutual TLS as the webhook kubeconfig
+// configures.
+type Handler struct {
+	conf     Config
+	verifier token.Verifier
+	logger   *log.Helper
+}
+
+// NewHandler returns a Handler, the prefixes must be set and must not be system ones.
+func NewHandler(verifier token.Verifier, conf Config) (*Handler, error) {
+	for _, prefix := range []string{conf.UsernamePrefix, conf.GroupsPrefix} {
+		if prefix == "" || strings.HasPrefix(prefix, _systemPrefix) {
+			return nil, ErrPrefixRequired
+		}
+	}
+	return &Handler{conf: conf, verifier: verifier}, nil
+}
+
+// SetLogger writes the entries of the token reviews to l instead of the global logger.
+func (h *Handler) SetLogger(l log.Logger) {
+	h.logger = log.NewHelper(l)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "token reviews must use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	review := &TokenReview{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, _maxBodySize)).Decode(review); err != nil {
+		http.Error(w, "malformed token review: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if review.APIVersion == "" {
+		review.APIVersion = _defaultAPIVersion
+	}
+	if (review.Kind != "" && review.Kind != Kind) || !utils.StringsInclude(_apiVersions, review.APIVersion) {
+		http.Error(w, "unsupported token review "+review.APIVersion+" "+review.Kind, http.StatusBadRequest)
+		return
+	}
+	review.Kind = Kind
+	review.Status = h.Review(&review.Spec)
+	// the token is not echoed back.
+	review.Spec.Token = ""
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(review)
+}
+
+// Review verifies the token of spec and returns the user it authenticates.
+func (h *Handler) Review(spec *Spec) Status {
+	if spec.Token == "" {
+		return Status{Error: "token required"}
+	}
+	claims, err := h.verifier.Verify(spec.Token)
+	if err != nil {
+		h.logger.Debugf("token review: %s", err)
+		return Status{Error: "invalid token"}
+	}
+	if claims.Audience != "" && !utils.StringsInclude(h.conf.Audiences, claims.Audience) {
+		return Status{Error: "token audience not accepted"}
+	}
+	audiences := h.audiences(claims, spec.Audiences)
+	if len(spec.Audiences) > 0 && len(audiences) == 0 {
+		return Status{Error: "token audience not accepted"}
+	}
+	return Status{Authenticated: true, User: h.userInfo(claims), Audiences: audiences}
+}
+
+// audiences returns the requested audiences the token is valid for.
+func (h *Handler) audiences(claims *token.Claims, requested []string) []string {
+	valid := h.conf.Audiences
+	if claims.Audience != "" {
+		valid = []string{claims.Audience}
+	}
+	audiences := make([]string, 0, len(requested))
+	for _, aud := range requested {
+		if utils.StringsInclude(valid, aud) {
+			audiences = append(audiences, aud)
+		}
+	}
+	return audiences
+}
+
+// userInfo namespaces the username, uid and groups by the tenant, the same name in two tenants
+// is not the same user. system: groups asserted by an IdP are dropped.
+func (h *Handler) userInfo(claims *token.Claims) *UserInfo {
+	username := claims.Username
+	if username == "" {
+		username = claims.Subject
+	}
+	namespace := ""
+	if claims.TenantID != "" {
+		namespace = claims.TenantID + ":"
+	}
+	user := &UserInfo{Username: h.conf.UsernamePrefix + namespace + username, UID: namespace + claims.Subject}
+	for _, group := range token.ClaimStrings(claims.Extra, idprovider.ExtraGroups) {
+		if strings.HasPrefix(group, _systemPrefix) {
+			h.logger.Warnf("token review: drop group %s of %s", group, user.Username)
+			continue
+		}
+		user.Groups = append(user.Groups, h.conf.GroupsPrefix+namespace+group)
+	}
+	extra := make(map[string][]string)
+	if claims.TenantID != "" {
+		extra[ExtraTenantID] = []string{claims.TenantID}
+	}
+	if scopes := strings.Fields(claims.Scope); len(scopes) > 0 {
+		extra[ExtraScopes] = scopes
+	}
+	if len(extra) > 0 {
+		user.Extra = extra
+	}
+	return user
+}
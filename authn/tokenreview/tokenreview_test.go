@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tokenreview
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/token"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	tokens, err := token.NewJWTManager(&token.Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	issue := func(c *token.Claims) string {
+		s, err := tokens.Issue(c)
+		assert.NoError(t, err)
+		return s
+	}
+	user := issue(&token.Claims{Subject: "user-1", Username: "alice", TenantID: "tenant-1", Scope: "read write",
+		Extra: map[string]interface{}{"groups": []string{"ops", "system:masters"}}})
+	scoped := issue(&token.Claims{Subject: "user-2", Audience: "https://other.example"})
+	unscoped := issue(&token.Claims{Subject: "user-3", Username: "bob"})
+	for _, conf := range []Config{{}, {UsernamePrefix: "tkeel:"}, {UsernamePrefix: "system:", GroupsPrefix: "tkeel:"}} {
+		_, err = NewHandler(tokens, conf)
+		assert.ErrorIs(t, err, ErrPrefixRequired)
+	}
+	h, err := NewHandler(tokens, Config{UsernamePrefix: "tkeel:", GroupsPrefix: "tkeel:", Audiences: []string{"https://kubernetes.default.svc"}})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   Status
+	}{
+		{
+			name:   "authenticated",
+			body:   `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":"` + user + `"}}`,
+			status: http.StatusOK,
+			want: Status{Authenticated: true, User: &UserInfo{
+				Username: "tkeel:tenant-1:alice", UID: "tenant-1:user-1", Groups: []string{"tkeel:tenant-1:ops"},
+				Extra: map[string][]string{ExtraTenantID: {"tenant-1"}, ExtraScopes: {"read", "write"}},
+			}},
+		},
+		{
+			name:   "audience",
+			body:   `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":"` + user + `","audiences":["https://kubernetes.default.svc","https://vault"]}}`,
+			status: http.StatusOK,
+			want: Status{Authenticated: true, Audiences: []string{"https://kubernetes.default.svc"}, User: &UserInfo{
+				Username: "tkeel:tenant-1:alice", UID: "tenant-1:user-1", Groups: []string{"tkeel:tenant-1:ops"},
+				Extra: map[string][]string{ExtraTenantID: {"tenant-1"}, ExtraScopes: {"read", "write"}},
+			}},
+		},
+		{
+			name:   "other audience",
+			body:   `{"spec":{"token":"` + scoped + `","audiences":["https://kubernetes.default.svc"]}}`,
+			status: http.StatusOK,
+			want:   Status{Error: "token audience not accepted"},
+		},
+		{
+			name:   "other audience without requested audiences",
+			body:   `{"spec":{"token":"` + scoped + `"}}`,
+			status: http.StatusOK,
+			want:   Status{Error: "token audience not accepted"},
+		},
+		{
+			name:   "without tenant",
+			body:   `{"spec":{"token":"` + unscoped + `"}}`,
+			status: http.StatusOK,
+			want:   Status{Authenticated: true, User: &UserInfo{Username: "tkeel:bob", UID: "user-3"}},
+		},
+		{"invalid token", `{"spec":{"token":"forged"}}`, http.StatusOK, Status{Error: "invalid token"}},
+		{"unsupported version", `{"apiVersion":"authentication.k8s.io/v2","spec":{"token":"x"}}`, http.StatusBadRequest, Status{}},
+		{"malformed", `{`, http.StatusBadRequest, Status{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/tokenreview", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var review TokenReview
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &review))
+			assert.Equal(t, Kind, review.Kind)
+			assert.Empty(t, review.Spec.Token)
+			assert.Equal(t, tt.want, review.Status)
+		})
+	}
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package abac
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/log"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+
+	_overloadInCIDR = "string_in_cidr_string"
+)
+
+var (
+	// ErrInvalidPolicy the policy has no id, an unknown effect or a condition that does not compile to a bool.
+	ErrInvalidPolicy = errors.New("invalid abac policy")
+	// ErrPolicyNotFound no policy with the id.
+	ErrPolicyNotFound = errors.New("abac policy not found")
+)
+
+type Effect string
+
+// Policy grants or denies actions when its condition holds. Conditions are CEL expressions over
+// the variables subject (map), resource (map), action (string) and env (map with time, ip and
+// the attributes of the EnvSources of the engine), e.g. `"ops" in subject.groups && resource.owner == subject.sub && env.ip.inCIDR("10.0.0.0/8")`.
+type Policy struct {
+	ID          string `json:"id" yaml:"id"`
+	Description string `json:"description,omitempty" yaml:"description"`
+	Effect      Effect `json:"effect" yaml:"effect"`
+	// Actions the policy applies to, empty or "*" applies to all actions.
+	Actions   []string `json:"actions,omitempty" yaml:"actions"`
+	Condition string   `json:"condition" yaml:"condition"`
+}
+
+func (p *Policy) appliesTo(action string) bool {
+	if len(p.Actions) == 0 {
+		return true
+	}
+	for _, a := range p.Actions {
+		if a == "*" || a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Request the attributes a decision is made on.
+type Request struct {
+	Subject  map[string]interface{}
+	Resource map[string]interface{}
+	Action   string
+	// Time default to now.
+	Time time.Time
+	// IP address of the client.
+	IP string
+}
+
+// SubjectFromClaims returns the subject attributes of token claims: the extensions
+// (e.g. groups) with sub, tenant_id, username and scopes on top.
+func SubjectFromClaims(c *token.Claims) map[string]interface{} {
+	subject := make(map[string]interface{}, len(c.Extra)+4)
+	for k, v := range c.Extra {
+		subject[k] = v
+	}
+	subject["sub"] = c.Subject
+	subject["tenant_id"] = c.TenantID
+	subject["username"] = c.Username
+	subject["scopes"] = strings.Fields(c.Scope)
+	return subject
+}
+
+// EnvSource adds attributes of the environment of a request to env, e.g. the location of the
+// client ip. Sources should set all their attributes, also when unknown: a deny policy whose
+// condition reads a missing attribute fails to evaluate and denies.
+type EnvSource interface {
+	Environment(req *Request) map[string]interface{}
+}
+
+type compiled struct {
+	policy  Policy
+	program cel.Program
+}
+
+// Engine evaluates ABAC policies, deny policies override allow policies. It answers
+// DecisionNoOpinion when no policy applies so it can be combined with RBAC.
+type Engine struct {
+	env     *cel.Env
+	sources []EnvSource
+
+	lock     sync.RWMutex
+	policies []*compiled
+	logger   *log.Helper
+}
+
+func NewEngine() (*Engine, error) {
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewVar("subject", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("resource", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("action", decls.String),
+		decls.NewVar("env", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewFunction("inCIDR", decls.NewInstanceOverload(_overloadInCIDR,
+			[]*exprpb.Type{decls.String, decls.String}, decls.Bool)),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("cel env %w", err)
+	}
+	return &Engine{env: env}, nil
+}
+
+// SetLogger writes the entries of the policy evaluation to l instead of the global logger.
+func (e *Engine) SetLogger(l log.Logger) {
+	e.logger = log.NewHelper(l)
+}
+
+// AddEnvSource adds the attributes of source to env, call it before evaluating requests. time
+// and ip can not be overridden.
+func (e *Engine) AddEnvSource(source EnvSource) {
+	e.sources = append(e.sources, source)
+}
+
+// AddPolicy compiles and adds p, replacing the policy with the same id.
+func (e *Engine) AddPolicy(p Policy) error {
+	if p.ID == "" || (p.Effect != EffectAllow && p.Effect != EffectDeny) {
+		return ErrInvalidPolicy
+	}
+	ast, issues := e.env.Compile(p.Condition)
+	if issues != nil && issues.Err() != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidPolicy, p.ID, issues.Err())
+	}
+	// dyn results, e.g. a bare attribute, are checked when evaluated.
+	if !proto.Equal(ast.ResultType(), decls.Bool) && !proto.Equal(ast.ResultType(), decls.Dyn) {
+		return fmt.Errorf("%w: %s: condition must be a bool", ErrInvalidPolicy, p.ID)
+	}
+	program, err := e.env.Program(ast, cel.Functions(&functions.Overload{
+		Operator: _overloadInCIDR,
+		Binary:   inCIDR,
+	}))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidPolicy, p.ID, err)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	c := &compiled{policy: p, program: program}
+	for i := range e.policies {
+		if e.policies[i].policy.ID == p.ID {
+			e.policies[i] = c
+			return nil
+		}
+	}
+	e.policies = append(e.policies, c)
+	return nil
+}
+
+func (e *Engine) RemovePolicy(id string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for i := range e.policies {
+		if e.policies[i].policy.ID == id {
+			e.policies = append(e.policies[:i], e.policies[i+1:]...)
+			return nil
+		}
+	}
+	return ErrPolicyNotFound
+}
+
+func (e *Engine) Policies() []Policy {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	policies := make([]Policy, 0, len(e.policies))
+	for _, c := range e.policies {
+		policies = append(policies, c.policy)
+	}
+	return policies
+}
+
+// Evaluate returns the decision of the policies for req. A condition failing to evaluate, e.g.
+// on a missing attribute, or not evaluating to a bool fails closed: an allow policy does not
+// match, a deny policy denies.
+func (e *Engine) Evaluate(req *Request) (authorizer.Decision, error) {
+	now := req.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+	env := make(map[string]interface{})
+	for _, source := range e.sources {
+		for k, v := range source.Environment(req) {
+			env[k] = v
+		}
+	}
+	env["time"], env["ip"] = now, req.IP
+	vars := map[string]interface{}{
+		"subject":  attributes(req.Subject),
+		"resource": attributes(req.Resource),
+		"action":   req.Action,
+		"env":      env,
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	decision := authorizer.DecisionNoOpinion
+	for _, c := range e.policies {
+		if !c.policy.appliesTo(req.Action) {
+			continue
+		}
+		out, _, err := c.program.Eval(vars)
+		if err != nil && c.policy.Effect == EffectDeny {
+			e.logger.Warnf("abac deny policy %s failed to evaluate, denying: %s", c.policy.ID, err)
+			return authorizer.DecisionDeny, nil
+		}
+		if _, ok := out.(types.Bool); !ok && c.policy.Effect == EffectDeny {
+			e.logger.Warnf("abac deny policy %s evaluated to %s, not a bool, denying", c.policy.ID, out.Type().TypeName())
+			return authorizer.DecisionDeny, nil
+		}
+		if err != nil || out != types.True {
+			continue
+		}
+		if c.policy.Effect == EffectDeny {
+			return authorizer.DecisionDeny, nil
+		}
+		decision = authorizer.DecisionAllow
+	}
+	return decision, nil
+}
+
+func attributes(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	return m
+}
+
+func inCIDR(lhs, rhs ref.Val) ref.Val {
+	ip, ok := lhs.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(lhs)
+	}
+	cidr, ok := rhs.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(rhs)
+	}
+	_, network, err := net.ParseCIDR(string(cidr))
+	if err != nil {
+		return types.NewErr("invalid cidr %s", cidr)
+	}
+	addr := net.ParseIP(string(ip))
+	return types.Bool(addr != nil && network.Contains(addr))
+}
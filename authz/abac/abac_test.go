@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package abac
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngine(t *testing.T) {
+	e, err := NewEngine()
+	assert.NoError(t, err)
+	assert.NoError(t, e.AddPolicy(Policy{
+		ID:        "owner",
+		Effect:    EffectAllow,
+		Condition: `resource.owner == subject.sub && resource.tenant_id == subject.tenant_id`,
+	}))
+	assert.NoError(t, e.AddPolicy(Policy{
+		ID:        "ops-write",
+		Effect:    EffectAllow,
+		Actions:   []string{"write"},
+		Condition: `"ops" in subject.groups && env.ip.inCIDR("10.0.0.0/8")`,
+	}))
+	assert.NoError(t, e.AddPolicy(Policy{
+		ID:        "locked",
+		Effect:    EffectDeny,
+		Condition: `has(resource.locked) && resource.locked`,
+	}))
+	assert.NoError(t, e.AddPolicy(Policy{
+		ID:        "office-hours",
+		Effect:    EffectDeny,
+		Actions:   []string{"delete"},
+		Condition: `env.time.getHours("UTC") < 8`,
+	}))
+
+	alice := SubjectFromClaims(&token.Claims{Subject: "alice", TenantID: "t1",
+		Extra: map[string]interface{}{"groups": []interface{}{"ops"}}})
+	device := map[string]interface{}{"owner": "bob", "tenant_id": "t1"}
+	own := map[string]interface{}{"owner": "alice", "tenant_id": "t1"}
+	noon := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		req      *Request
+		decision authorizer.Decision
+	}{
+		{"owner", &Request{Subject: alice, Resource: own, Action: "read"}, authorizer.DecisionAllow},
+		{"not owner", &Request{Subject: alice, Resource: device, Action: "read"}, authorizer.DecisionNoOpinion},
+		{"ops in network", &Request{Subject: alice, Resource: device, Action: "write", IP: "10.1.2.3"}, authorizer.DecisionAllow},
+		{"ops out of network", &Request{Subject: alice, Resource: device, Action: "write", IP: "192.168.0.1"}, authorizer.DecisionNoOpinion},
+		{"missing attribute", &Request{Resource: device, Action: "write", IP: "10.1.2.3"}, authorizer.DecisionNoOpinion},
+		{"locked", &Request{Subject: alice, Resource: map[string]interface{}{"owner": "alice", "tenant_id": "t1", "locked": true},
+			Action: "read"}, authorizer.DecisionDeny},
+		{"delete at night", &Request{Subject: alice, Resource: own, Action: "delete", Time: noon.Add(-8 * time.Hour)}, authorizer.DecisionDeny},
+		{"delete at noon", &Request{Subject: alice, Resource: own, Action: "delete", Time: noon}, authorizer.DecisionAllow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := e.Evaluate(tt.req)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.decision, d)
+		})
+	}
+
+	// a deny policy failing to evaluate denies.
+	assert.NoError(t, e.AddPolicy(Policy{ID: "quarantine", Effect: EffectDeny, Actions: []string{"read"}, Condition: `resource.quarantined`}))
+	d, err := e.Evaluate(&Request{Subject: alice, Resource: own, Action: "read"})
+	assert.NoError(t, err)
+	assert.Equal(t, authorizer.DecisionDeny, d)
+	assert.NoError(t, e.RemovePolicy("quarantine"))
+
+	// so does one evaluating to something other than a bool, e.g. a bare string attribute.
+	assert.NoError(t, e.AddPolicy(Policy{ID: "flagged", Effect: EffectDeny, Actions: []string{"read"}, Condition: `resource.flag`}))
+	d, err = e.Evaluate(&Request{Subject: alice, Resource: map[string]interface{}{"owner": "alice", "tenant_id": "t1", "flag": "yes"},
+		Action: "read"})
+	assert.NoError(t, err)
+	assert.Equal(t, authorizer.DecisionDeny, d)
+	assert.NoError(t, e.RemovePolicy("flagged"))
+
+	assert.ErrorIs(t, e.AddPolicy(Policy{ID: "bad", Effect: EffectAllow, Condition: `subject.sub +`}), ErrInvalidPolicy)
+	assert.ErrorIs(t, e.AddPolicy(Policy{ID: "bad", Effect: EffectAllow, Condition: `action + "x"`}), ErrInvalidPolicy)
+	assert.ErrorIs(t, e.AddPolicy(Policy{ID: "bad", Effect: "maybe", Condition: `true`}), ErrInvalidPolicy)
+	assert.NoError(t, e.RemovePolicy("locked"))
+	assert.ErrorIs(t, e.RemovePolicy("locked"), ErrPolicyNotFound)
+	assert.Len(t, e.Policies(), 3)
+}
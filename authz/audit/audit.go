@@ -0,0 +1,268 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/log"
+)
+
+var (
+	_ authorizer.Checker = &Auditor{}
+	_ Sink               = LogSink{}
+	_ Sink               = &MemorySink{}
+	_ EventSink          = LogSink{}
+	_ EventSink          = &MemorySink{}
+)
+
+// Decision a recorded authorization decision.
+type Decision struct {
+	Time     time.Time `json:"time"`
+	Subject  string    `json:"subject"`
+	TenantID string    `json:"tenant_id"`
+	Resource string    `json:"resource"`
+	Action   string    `json:"action"`
+	Allowed  bool      `json:"allowed"`
+	// Policy the matched policy rule, when the checker explains its decisions.
+	Policy  []string      `json:"policy,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Sink stores decisions. Write is called synchronously on the request path and must not block,
+// sinks shipping decisions elsewhere should buffer them.
+type Sink interface {
+	Write(d *Decision)
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(d *Decision)
+
+func (f SinkFunc) Write(d *Decision) {
+	f(d)
+}
+
+// Types of the events emitted by this module, besides the ones of their subsystems like
+// impersonation.started.
+const (
+	EventLogin         = "authn.login"
+	EventLogout        = "authn.logout"
+	EventTokenIssued   = "token.issued"
+	EventTokenRevoked  = "token.revoked"
+	EventRoleChanged   = "rbac.role_changed"
+	EventPolicyChanged = "rbac.policy_changed"
+	EventMFADisabled   = "mfa.disabled"
+)
+
+// Outcomes of events.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// Event a recorded security event other than an authorization decision, e.g. a login.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Type of the event, <subsystem>.<what happened> like impersonation.started.
+	Type string `json:"type"`
+	// Actor the subject causing the event.
+	Actor string `json:"actor"`
+	// Subject the subject the event is about, e.g. the impersonated user.
+	Subject  string `json:"subject"`
+	TenantID string `json:"tenant_id"`
+	// Target the object acted on, e.g. a role or a token id.
+	Target string `json:"target,omitempty"`
+	// Outcome success, failure or denied, empty when the event has none.
+	Outcome string `json:"outcome,omitempty"`
+	// IP and UserAgent of the client of the request causing the event.
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	// Detail event specific fields.
+	Detail map[string]interface{} `json:"detail,omitempty"`
+}
+
+// EventSink stores events, like Sink it must not block.
+type EventSink interface {
+	WriteEvent(e *Event)
+}
+
+// EventSinkFunc adapts a function to an EventSink.
+type EventSinkFunc func(e *Event)
+
+func (f EventSinkFunc) WriteEvent(e *Event) {
+	f(e)
+}
+
+// ContextEventSink an EventSink also taking the context of the operation causing the event,
+// e.g. to correlate the event with the trace of the request.
+type ContextEventSink interface {
+	EventSink
+	WriteEventContext(ctx context.Context, e *Event)
+}
+
+// WriteEvent writes e to sink, passing ctx along when sink is a ContextEventSink.
+func WriteEvent(ctx context.Context, sink EventSink, e *Event) {
+	if s, ok := sink.(ContextEventSink); ok {
+		s.WriteEventContext(ctx, e)
+		return
+	}
+	sink.WriteEvent(e)
+}
+
+// LogSink writes decisions and events to the process log.
+type LogSink struct{}
+
+func (LogSink) Write(d *Decision) {
+	log.Infof("authz decision subject=%s tenant=%s resource=%s action=%s allowed=%t policy=%v latency=%s error=%s",
+		d.Subject, d.TenantID, d.Resource, d.Action, d.Allowed, d.Policy, d.Latency, d.Error)
+}
+
+func (LogSink) WriteEvent(e *Event) {
+	log.Infof("audit event type=%s actor=%s subject=%s tenant=%s target=%s outcome=%s ip=%s reason=%q detail=%v",
+		e.Type, e.Actor, e.Subject, e.TenantID, e.Target, e.Outcome, e.IP, e.Reason, e.Detail)
+}
+
+// MemorySink keeps the last decisions and events, e.g. for an admin endpoint or tests.
+type MemorySink struct {
+	lock      sync.RWMutex
+	size      int
+	decisions []*Decision
+	events    []*Event
+}
+
+// NewMemorySink returns a MemorySink keeping the last size decisions and events.
+func NewMemorySink(size int) *MemorySink {
+	return &MemorySink{size: size}
+}
+
+func (s *MemorySink) Write(d *Decision) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.decisions = append(s.decisions, d)
+	if len(s.decisions) > s.size {
+		s.decisions = s.decisions[len(s.decisions)-s.size:]
+	}
+}
+
+// Decisions returns the kept decisions, oldest first.
+func (s *MemorySink) Decisions() []*Decision {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*Decision(nil), s.decisions...)
+}
+
+func (s *MemorySink) WriteEvent(e *Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, e)
+	if len(s.events) > s.size {
+		s.events = s.events[len(s.events)-s.size:]
+	}
+}
+
+// Events returns the kept events, oldest first.
+func (s *MemorySink) Events() []*Event {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]*Event(nil), s.events...)
+}
+
+// Config of the sampling.
+type Config struct {
+	// AllowSampleRate fraction of allow decisions recorded. Default to 1, negative records none.
+	AllowSampleRate float64 `mapstructure:"allow_sample_rate" json:"allow_sample_rate" yaml:"allowSampleRate"`
+	// DenySampleRate fraction of deny decisions and errors recorded. Default to 1, negative records none.
+	DenySampleRate float64 `mapstructure:"deny_sample_rate" json:"deny_sample_rate" yaml:"denySampleRate"`
+}
+
+// Auditor records the decisions of a Checker to a Sink.
+type Auditor struct {
+	checker authorizer.Checker
+	sink    Sink
+	conf    Config
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func NewAuditor(checker authorizer.Checker, sink Sink, conf Config) *Auditor {
+	if conf.AllowSampleRate == 0 {
+		conf.AllowSampleRate = 1
+	}
+	if conf.DenySampleRate == 0 {
+		conf.DenySampleRate = 1
+	}
+	return &Auditor{
+		checker: checker,
+		sink:    sink,
+		conf:    conf,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())), //nolint:gosec
+	}
+}
+
+func (a *Auditor) Check(subject, tenantID, resource, action string) (bool, error) {
+	start := time.Now()
+	var (
+		allowed bool
+		policy  []string
+		err     error
+	)
+	if explainer, ok := a.checker.(authorizer.Explainer); ok {
+		allowed, policy, err = explainer.Explain(subject, tenantID, resource, action)
+	} else {
+		allowed, err = a.checker.Check(subject, tenantID, resource, action)
+	}
+	latency := time.Since(start)
+
+	rate := a.conf.DenySampleRate
+	if allowed && err == nil {
+		rate = a.conf.AllowSampleRate
+	}
+	if a.sampled(rate) {
+		d := &Decision{
+			Time:     start,
+			Subject:  subject,
+			TenantID: tenantID,
+			Resource: resource,
+			Action:   action,
+			Allowed:  allowed,
+			Policy:   policy,
+			Latency:  latency,
+		}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		a.sink.Write(d)
+	}
+	return allowed, err
+}
+
+func (a *Auditor) sampled(rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.rand.Float64() < rate
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"testing"
+
+	"github.com/tkeel-io/security/authz/casbin"
+	"github.com/tkeel-io/security/authz/rbac"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditor(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	mgr := rbac.NewRoleOperator(enforcer)
+	assert.NoError(t, mgr.CreateRole("t1", "viewer", rbac.Permission{Resource: "devices/*", Action: "read"}))
+	assert.NoError(t, mgr.AssignRole("t1", "alice", "viewer"))
+
+	sink := NewMemorySink(2)
+	a := NewAuditor(mgr, sink, Config{})
+	ok, err := a.Check("alice", "t1", "devices/d1", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = a.Check("alice", "t1", "devices/d1", "write")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	decisions := sink.Decisions()
+	assert.Len(t, decisions, 2)
+	assert.True(t, decisions[0].Allowed)
+	assert.Equal(t, []string{"viewer", "t1", "devices/*", "read"}, decisions[0].Policy)
+	assert.False(t, decisions[1].Allowed)
+	assert.Empty(t, decisions[1].Policy)
+
+	_, err = a.Check("", "t1", "devices/d1", "read")
+	assert.Error(t, err)
+	assert.Len(t, sink.Decisions(), 2)
+	assert.NotEmpty(t, sink.Decisions()[1].Error)
+
+	// only denials.
+	var recorded []*Decision
+	a = NewAuditor(mgr, SinkFunc(func(d *Decision) { recorded = append(recorded, d) }), Config{AllowSampleRate: -1})
+	for i := 0; i < 3; i++ {
+		_, _ = a.Check("alice", "t1", "devices/d1", "read")
+		_, _ = a.Check("bob", "t1", "devices/d1", "read")
+	}
+	assert.Len(t, recorded, 3)
+	for _, d := range recorded {
+		assert.Equal(t, "bob", d.Subject)
+	}
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/log"
+)
+
+const (
+	_defaultBufferSize    = 10000
+	_defaultBatchSize     = 100
+	_defaultFlushInterval = time.Second
+	_defaultRetryBackoff  = time.Second
+	_maxRetryBackoff      = time.Minute
+)
+
+var (
+	_ Sink      = &BufferedSink{}
+	_ EventSink = &BufferedSink{}
+
+	// ErrSinkClosed events were left unpublished when the sink closed.
+	ErrSinkClosed = errors.New("audit sink closed with unpublished events")
+)
+
+// Publisher ships batches of events to a destination like a file, a Kafka topic or a webhook.
+// Unlike a sink it may block and fail, wrap it in a BufferedSink.
+type Publisher interface {
+	Publish(ctx context.Context, events []*Event) error
+}
+
+// BufferConfig of a BufferedSink.
+type BufferConfig struct {
+	// Size the most events kept while the publisher fails, the oldest are dropped beyond. Default to 10000.
+	Size int `mapstructure:"size" json:"size" yaml:"size"`
+	// BatchSize the most events published at once. Default to 100.
+	BatchSize int `mapstructure:"batch_size" json:"batch_size" yaml:"batchSize"`
+	// FlushInterval the longest an event waits for its batch to fill. Default to 1s.
+	FlushInterval time.Duration `mapstructure:"flush_interval" json:"flush_interval" yaml:"flushInterval"`
+	// RetryBackoff the first wait after a failed publish, doubled up to 1m. Default to 1s.
+	RetryBackoff time.Duration `mapstructure:"retry_backoff" json:"retry_backoff" yaml:"retryBackoff"`
+	// Logger receives the entries of dropped and failed publishes. Default to the global logger.
+	Logger log.Logger `mapstructure:"-" json:"-" yaml:"-"`
+}
+
+// BufferedSink queues events and publishes them in batches from a goroutine, so writing never
+// blocks. Events of failed batches stay queued and are retried with backoff.
+type BufferedSink struct {
+	conf      BufferConfig
+	publisher Publisher
+
+	lock    sync.Mutex
+	queue   []*Event
+	dropped uint64
+
+	wake   chan struct{}
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	logger *log.Helper
+}
+
+// NewBufferedSink starts publishing to p, call Close to flush and stop.
+func NewBufferedSink(p Publisher, conf BufferConfig) *BufferedSink {
+	if conf.Size <= 0 {
+		conf.Size = _defaultBufferSize
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = _defaultBatchSize
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = _defaultFlushInterval
+	}
+	if conf.RetryBackoff <= 0 {
+		conf.RetryBackoff = _defaultRetryBackoff
+	}
+	s := &BufferedSink{
+		conf:      conf,
+		publisher: p,
+		wake:      make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+		done:      make(chan struct{}),
+		logger:    log.NewHelper(conf.Logger),
+	}
+	go s.run()
+	return s
+}
+
+func (s *BufferedSink) WriteEvent(e *Event) {
+	s.lock.Lock()
+	if len(s.queue) >= s.conf.Size {
+		s.queue = s.queue[1:]
+		s.dropped++
+		if s.dropped == 1 || s.dropped%1000 == 0 {
+			s.logger.Warnf("audit buffer full, %d events dropped", s.dropped)
+		}
+	}
+	s.queue = append(s.queue, e)
+	full := len(s.queue) >= s.conf.BatchSize
+	s.lock.Unlock()
+	if full {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Write publishes the decision as an authz.decision event.
+func (s *BufferedSink) Write(d *Decision) {
+	s.WriteEvent(DecisionEvent(d))
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (s *BufferedSink) Dropped() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dropped
+}
+
+// Close publishes the queued events, until ctx is done, and stops the sink.
+func (s *BufferedSink) Close(ctx context.Context) error {
+	s.once.Do(func() { close(s.closed) })
+	<-s.done
+	for {
+		n, err := s.flush(ctx)
+		if err != nil || n == 0 {
+			break
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.queue) > 0 {
+		return fmt.Errorf("%d events: %w", len(s.queue), ErrSinkClosed)
+	}
+	return nil
+}
+
+func (s *BufferedSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.conf.FlushInterval)
+	defer ticker.Stop()
+	backoff := s.conf.RetryBackoff
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		for {
+			n, err := s.flush(context.Background())
+			if err != nil {
+				s.logger.Warnf("publish audit events, retry in %s: %s", backoff, err)
+				select {
+				case <-s.closed:
+					return
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > _maxRetryBackoff {
+					backoff = _maxRetryBackoff
+				}
+				continue
+			}
+			backoff = s.conf.RetryBackoff
+			if n < s.conf.BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// flush publishes the oldest batch and removes it from the queue once published.
+func (s *BufferedSink) flush(ctx context.Context) (int, error) {
+	s.lock.Lock()
+	n := len(s.queue)
+	if n > s.conf.BatchSize {
+		n = s.conf.BatchSize
+	}
+	batch := append([]*Event(nil), s.queue[:n]...)
+	s.lock.Unlock()
+	if n == 0 {
+		return 0, nil
+	}
+	if err := s.publisher.Publish(ctx, batch); err != nil {
+		return 0, err
+	}
+	s.lock.Lock()
+	// events may have been dropped from the front meanwhile, remove what is left of the batch.
+	for _, e := range batch {
+		if len(s.queue) > 0 && s.queue[0] == e {
+			s.queue = s.queue[1:]
+		}
+	}
+	s.lock.Unlock()
+	return len(batch), nil
+}
+
+// DecisionEvent the event of an authorization decision.
+func DecisionEvent(d *Decision) *Event {
+	e := &Event{
+		Time:     d.Time,
+		Type:     "authz.decision",
+		Actor:    d.Subject,
+		Subject:  d.Subject,
+		TenantID: d.TenantID,
+		Target:   d.Resource,
+		Outcome:  OutcomeSuccess,
+		Reason:   d.Error,
+		Detail:   map[string]interface{}{"action": d.Action, "latency": d.Latency.String()},
+	}
+	if len(d.Policy) > 0 {
+		e.Detail["policy"] = d.Policy
+	}
+	switch {
+	case d.Error != "":
+		e.Outcome = OutcomeFailure
+	case !d.Allowed:
+		e.Outcome = OutcomeDenied
+	}
+	return e
+}
+
+// RequestEvent returns an event of typ caused by r, with its time and client set.
+func RequestEvent(r *http.Request, typ string) *Event {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return &Event{Time: time.Now(), Type: typ, IP: host, UserAgent: r.UserAgent()}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/rbac"
+)
+
+var (
+	_ token.Issuer  = &auditedIssuer{}
+	_ token.Revoker = &auditedRevoker{}
+	_ rbac.RoleMgr  = &auditedRoleMgr{}
+)
+
+// TokenIssuer writes a token.issued event for every token i issues.
+func TokenIssuer(i token.Issuer, sink EventSink) token.Issuer {
+	return &auditedIssuer{Issuer: i, sink: sink}
+}
+
+type auditedIssuer struct {
+	token.Issuer
+	sink EventSink
+}
+
+func (i *auditedIssuer) Issue(claims *token.Claims) (string, error) {
+	raw, err := i.Issuer.Issue(claims)
+	e := &Event{
+		Time:     time.Now(),
+		Type:     EventTokenIssued,
+		Actor:    claims.Subject,
+		Subject:  claims.Subject,
+		TenantID: claims.TenantID,
+		Target:   claims.ID,
+		Outcome:  outcome(err),
+		Detail:   map[string]interface{}{"scope": claims.Scope, "expires_at": claims.ExpiresAt},
+	}
+	if claims.Actor != nil {
+		e.Actor = claims.Actor.Subject
+	}
+	if err != nil {
+		e.Reason = err.Error()
+	}
+	i.sink.WriteEvent(e)
+	return raw, err
+}
+
+// TokenRevoker writes a token.revoked event for every revocation through r, the target is a
+// fingerprint of the token and never the token itself.
+func TokenRevoker(r token.Revoker, sink EventSink) token.Revoker {
+	return &auditedRevoker{Revoker: r, sink: sink}
+}
+
+type auditedRevoker struct {
+	token.Revoker
+	sink EventSink
+}
+
+func (r *auditedRevoker) Revoke(raw string) error {
+	err := r.Revoker.Revoke(raw)
+	e := &Event{Time: time.Now(), Type: EventTokenRevoked, Target: Fingerprint(raw), Outcome: outcome(err)}
+	if err != nil {
+		e.Reason = err.Error()
+	}
+	r.sink.WriteEvent(e)
+	return err
+}
+
+// Fingerprint identifies a secret like a token in events without revealing it.
+func Fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// RoleMgr writes rbac.role_changed events for the changes of roles and their bindings, and
+// rbac.policy_changed events for the changes of permissions, through m.
+func RoleMgr(m rbac.RoleMgr, sink EventSink) rbac.RoleMgr {
+	return &auditedRoleMgr{RoleMgr: m, sink: sink}
+}
+
+type auditedRoleMgr struct {
+	rbac.RoleMgr
+	sink EventSink
+}
+
+func (m *auditedRoleMgr) emit(typ, op, tenantID, target string, detail map[string]interface{}, err error) {
+	if detail == nil {
+		detail = make(map[string]interface{})
+	}
+	detail["op"] = op
+	e := &Event{Time: time.Now(), Type: typ, TenantID: tenantID, Target: target, Outcome: outcome(err), Detail: detail}
+	if err != nil {
+		e.Reason = err.Error()
+	}
+	m.sink.WriteEvent(e)
+}
+
+func (m *auditedRoleMgr) CreateRole(tenantID, role string, permissions ...rbac.Permission) error {
+	err := m.RoleMgr.CreateRole(tenantID, role, permissions...)
+	m.emit(EventRoleChanged, "create_role", tenantID, role, map[string]interface{}{"permissions": permissions}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) DeleteRole(tenantID, role string) error {
+	err := m.RoleMgr.DeleteRole(tenantID, role)
+	m.emit(EventRoleChanged, "delete_role", tenantID, role, nil, err)
+	return err
+}
+
+func (m *auditedRoleMgr) GrantPermissions(tenantID, role string, permissions ...rbac.Permission) error {
+	err := m.RoleMgr.GrantPermissions(tenantID, role, permissions...)
+	m.emit(EventPolicyChanged, "grant_permissions", tenantID, role, map[string]interface{}{"permissions": permissions}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) RevokePermissions(tenantID, role string, permissions ...rbac.Permission) error {
+	err := m.RoleMgr.RevokePermissions(tenantID, role, permissions...)
+	m.emit(EventPolicyChanged, "revoke_permissions", tenantID, role, map[string]interface{}{"permissions": permissions}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) Deny(tenantID, subject string, permissions ...rbac.Permission) error {
+	err := m.RoleMgr.Deny(tenantID, subject, permissions...)
+	m.emit(EventPolicyChanged, "deny", tenantID, subject, map[string]interface{}{"permissions": permissions}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) RemoveDeny(tenantID, subject string, permissions ...rbac.Permission) error {
+	err := m.RoleMgr.RemoveDeny(tenantID, subject, permissions...)
+	m.emit(EventPolicyChanged, "remove_deny", tenantID, subject, map[string]interface{}{"permissions": permissions}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) AssignRole(tenantID, subject, role string) error {
+	err := m.RoleMgr.AssignRole(tenantID, subject, role)
+	m.emit(EventRoleChanged, "assign_role", tenantID, role, map[string]interface{}{"subject": subject}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) UnassignRole(tenantID, subject, role string) error {
+	err := m.RoleMgr.UnassignRole(tenantID, subject, role)
+	m.emit(EventRoleChanged, "unassign_role", tenantID, role, map[string]interface{}{"subject": subject}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) AddGroupMember(tenantID, group, user string) error {
+	err := m.RoleMgr.AddGroupMember(tenantID, group, user)
+	m.emit(EventRoleChanged, "add_group_member", tenantID, rbac.Group(group), map[string]interface{}{"subject": user}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) RemoveGroupMember(tenantID, group, user string) error {
+	err := m.RoleMgr.RemoveGroupMember(tenantID, group, user)
+	m.emit(EventRoleChanged, "remove_group_member", tenantID, rbac.Group(group), map[string]interface{}{"subject": user}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) InheritRole(tenantID, role, parent string) error {
+	err := m.RoleMgr.InheritRole(tenantID, role, parent)
+	m.emit(EventRoleChanged, "inherit_role", tenantID, role, map[string]interface{}{"parent": parent}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) DisinheritRole(tenantID, role, parent string) error {
+	err := m.RoleMgr.DisinheritRole(tenantID, role, parent)
+	m.emit(EventRoleChanged, "disinherit_role", tenantID, role, map[string]interface{}{"parent": parent}, err)
+	return err
+}
+
+func (m *auditedRoleMgr) Import(tenantID string, tp *rbac.TenantPolicy, dryRun bool) (*rbac.PolicyDiff, error) {
+	diff, err := m.RoleMgr.Import(tenantID, tp, dryRun)
+	if !dryRun && (err != nil || !diff.Empty()) {
+		m.emit(EventPolicyChanged, "import", tenantID, "", map[string]interface{}{"diff": diff}, err)
+	}
+	return diff, err
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	_defaultWebhookTimeout = 10 * time.Second
+	// SignatureHeader carries the HMAC-SHA256 of the webhook body, hex encoded with a sha256= prefix.
+	SignatureHeader = "X-Audit-Signature"
+)
+
+var (
+	_ Publisher = &FilePublisher{}
+	_ Publisher = &WebhookPublisher{}
+	_ Publisher = &KafkaPublisher{}
+
+	// ErrURLRequired the webhook has no url.
+	ErrURLRequired = errors.New("audit webhook url required")
+)
+
+// FilePublisher appends events to a file as JSON lines.
+type FilePublisher struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFilePublisher opens path for appending, creating it readable by the owner only.
+func NewFilePublisher(path string) (*FilePublisher, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit file %w", err)
+	}
+	return &FilePublisher{file: f}, nil
+}
+
+func (p *FilePublisher) Publish(_ context.Context, events []*Event) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("encode audit event %w", err)
+		}
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, err := p.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write audit file %w", err)
+	}
+	return nil
+}
+
+func (p *FilePublisher) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.file.Close()
+}
+
+// WebhookConfig of a WebhookPublisher.
+type WebhookConfig struct {
+	// URL receives the batches as a JSON array in a POST.
+	URL string `mapstructure:"url" json:"url" yaml:"url"`
+	// Secret signs the body into the SignatureHeader when set.
+	Secret string `mapstructure:"secret" json:"secret" yaml:"secret"`
+	// Headers added to the requests, e.g. an Authorization header.
+	Headers map[string]string `mapstructure:"headers" json:"headers" yaml:"headers"`
+	// Timeout of a request. Default to 10s.
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout" yaml:"timeout"`
+}
+
+// WebhookPublisher posts batches of events to a URL, any status but 2xx fails the batch.
+type WebhookPublisher struct {
+	conf   WebhookConfig
+	client *http.Client
+}
+
+// NewWebhookPublisher returns a WebhookPublisher, client defaults to utils.DefaultHTTPClient.
+func NewWebhookPublisher(conf WebhookConfig, client *http.Client) (*WebhookPublisher, error) {
+	if conf.URL == "" {
+		return nil, ErrURLRequired
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = _defaultWebhookTimeout
+	}
+	if client == nil {
+		client = utils.DefaultHTTPClient
+	}
+	return &WebhookPublisher{conf: conf, client: client}, nil
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, events []*Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("encode audit events %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.conf.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("audit webhook request %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	if p.conf.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(p.conf.Secret, body))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post audit events %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("post audit events: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the SignatureHeader value of body, receivers compare it with hmac.Equal.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// KafkaMessage a record produced to Kafka.
+type KafkaMessage struct {
+	Key   []byte
+	Value []byte
+}
+
+// KafkaProducer produces records to a topic, adapt the client of your choice (e.g. a sarama
+// SyncProducer or a kafka-go Writer). It returns once all messages are acknowledged.
+type KafkaProducer interface {
+	Produce(ctx context.Context, topic string, messages []KafkaMessage) error
+}
+
+// KafkaPublisher produces events to a topic as JSON, keyed by tenant so the events of a tenant
+// keep their order.
+type KafkaPublisher struct {
+	producer KafkaProducer
+	topic    string
+}
+
+func NewKafkaPublisher(producer KafkaProducer, topic string) *KafkaPublisher {
+	return &KafkaPublisher{producer: producer, topic: topic}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, events []*Event) error {
+	messages := make([]KafkaMessage, 0, len(events))
+	for _, e := range events {
+		value, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("encode audit event %w", err)
+		}
+		messages = append(messages, KafkaMessage{Key: []byte(e.TenantID), Value: value})
+	}
+	if err := p.producer.Produce(ctx, p.topic, messages); err != nil {
+		return fmt.Errorf("produce audit events %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/casbin"
+	"github.com/tkeel-io/security/authz/rbac"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyPublisher fails while down and records the published events.
+type flakyPublisher struct {
+	lock      sync.Mutex
+	down      bool
+	published []*Event
+}
+
+func (p *flakyPublisher) Publish(_ context.Context, events []*Event) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.down {
+		return errors.New("unavailable")
+	}
+	p.published = append(p.published, events...)
+	return nil
+}
+
+func (p *flakyPublisher) setDown(down bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.down = down
+}
+
+func (p *flakyPublisher) count() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.published)
+}
+
+func TestBufferedSink(t *testing.T) {
+	p := &flakyPublisher{down: true}
+	s := NewBufferedSink(p, BufferConfig{Size: 5, BatchSize: 2, FlushInterval: 10 * time.Millisecond, RetryBackoff: 10 * time.Millisecond})
+	for i := 0; i < 7; i++ {
+		s.WriteEvent(&Event{Type: EventLogin, Target: string(rune('a' + i))})
+	}
+	assert.Equal(t, uint64(2), s.Dropped())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, p.count(), "events stay buffered while the publisher fails")
+
+	p.setDown(false)
+	assert.Eventually(t, func() bool { return p.count() == 5 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "c", p.published[0].Target)
+	assert.Equal(t, "g", p.published[4].Target)
+
+	s.Write(&Decision{Subject: "alice", Resource: "devices", Action: "read", Allowed: false})
+	assert.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, 6, p.count())
+	assert.Equal(t, OutcomeDenied, p.published[5].Outcome)
+
+	p = &flakyPublisher{down: true}
+	s = NewBufferedSink(p, BufferConfig{})
+	s.WriteEvent(&Event{Type: EventLogout})
+	assert.ErrorIs(t, s.Close(context.Background()), ErrSinkClosed)
+}
+
+func TestFilePublisher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p, err := NewFilePublisher(filepath.Join(dir, "audit.log"))
+	assert.NoError(t, err)
+	assert.NoError(t, p.Publish(context.Background(), []*Event{{Type: EventLogin, Actor: "alice"}, {Type: EventLogout, Actor: "alice"}}))
+	assert.NoError(t, p.Close())
+
+	f, err := os.Open(filepath.Join(dir, "audit.log"))
+	assert.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	types := []string{}
+	for scanner.Scan() {
+		e := &Event{}
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), e))
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{EventLogin, EventLogout}, types)
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	var received []*Event
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, Sign("secret", body), r.Header.Get(SignatureHeader))
+		assert.Equal(t, "Bearer t", r.Header.Get("Authorization"))
+		assert.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	_, err := NewWebhookPublisher(WebhookConfig{}, nil)
+	assert.ErrorIs(t, err, ErrURLRequired)
+	p, err := NewWebhookPublisher(WebhookConfig{URL: srv.URL, Secret: "secret", Headers: map[string]string{"Authorization": "Bearer t"}}, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Publish(context.Background(), []*Event{{Type: EventTokenIssued, IP: "10.0.0.1"}}))
+	assert.Equal(t, "10.0.0.1", received[0].IP)
+
+	status = http.StatusBadGateway
+	assert.Error(t, p.Publish(context.Background(), []*Event{{Type: EventTokenIssued}}))
+}
+
+type kafkaFunc func(ctx context.Context, topic string, messages []KafkaMessage) error
+
+func (f kafkaFunc) Produce(ctx context.Context, topic string, messages []KafkaMessage) error {
+	return f(ctx, topic, messages)
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	var produced []KafkaMessage
+	p := NewKafkaPublisher(kafkaFunc(func(_ context.Context, topic string, messages []KafkaMessage) error {
+		assert.Equal(t, "audit", topic)
+		produced = messages
+		return nil
+	}), "audit")
+	assert.NoError(t, p.Publish(context.Background(), []*Event{{Type: EventLogin, TenantID: "t1"}}))
+	assert.Equal(t, "t1", string(produced[0].Key))
+	assert.Contains(t, string(produced[0].Value), `"type":"authn.login"`)
+}
+
+func TestEmitters(t *testing.T) {
+	sink := NewMemorySink(20)
+
+	jwt, err := token.NewJWTManager(&token.Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	raw, err := TokenIssuer(jwt, sink).Issue(&token.Claims{Subject: "bob", TenantID: "t1", Actor: &token.Actor{Subject: "alice"}})
+	assert.NoError(t, err)
+	opaque, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	assert.NoError(t, TokenRevoker(opaque.(token.Revoker), sink).Revoke(raw))
+
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	roles := RoleMgr(rbac.NewRoleOperator(enforcer), sink)
+	assert.NoError(t, roles.CreateRole("t1", "viewer", rbac.Permission{Resource: "devices", Action: "read"}))
+	assert.NoError(t, roles.AssignRole("t1", "bob", "viewer"))
+	assert.Error(t, roles.GrantPermissions("t1", "viewer", rbac.Permission{Resource: "devices"}))
+	_, err = roles.Import("t1", &rbac.TenantPolicy{}, true)
+	assert.NoError(t, err)
+
+	events := sink.Events()
+	assert.Len(t, events, 5)
+	assert.Equal(t, EventTokenIssued, events[0].Type)
+	assert.Equal(t, "alice", events[0].Actor)
+	assert.Equal(t, "bob", events[0].Subject)
+	assert.Equal(t, EventTokenRevoked, events[1].Type)
+	assert.Equal(t, Fingerprint(raw), events[1].Target)
+	assert.NotContains(t, events[1].Target, raw)
+	assert.Equal(t, EventRoleChanged, events[2].Type)
+	assert.Equal(t, "viewer", events[3].Target)
+	assert.Equal(t, "bob", events[3].Detail["subject"])
+	assert.Equal(t, EventPolicyChanged, events[4].Type)
+	assert.Equal(t, OutcomeFailure, events[4].Outcome)
+}
@@ -13,6 +13,12 @@ limitations under the License.
 
 package authorizer
 
+import (
+	"fmt"
+
+	"github.com/tkeel-io/security/errs"
+)
+
 const (
 	// DecisionDeny means that an authorizer decided to deny the action.
 	DecisionDeny Decision = iota
@@ -34,3 +40,27 @@ type AuthZReq interface {
 type Authorizer interface {
 	Authorize(req AuthZReq) (Decision, error)
 }
+
+// Checker answers whether subject may perform action on resource in a tenant.
+type Checker interface {
+	Check(subject, tenantID, resource, action string) (bool, error)
+}
+
+// Explainer is a Checker that also returns the policy rule that decided the request.
+type Explainer interface {
+	Checker
+	Explain(subject, tenantID, resource, action string) (bool, []string, error)
+}
+
+// Enforce returns an error matching errs.ErrPermissionDenied when subject may not perform action
+// on resource in the tenant, and the error of checker when the check failed.
+func Enforce(checker Checker, subject, tenantID, resource, action string) error {
+	allowed, err := checker.Check(subject, tenantID, resource, action)
+	if err != nil {
+		return fmt.Errorf("check permission %w", err)
+	}
+	if !allowed {
+		return errs.New(errs.ErrPermissionDenied, "authz.check", fmt.Errorf("%s %s %s", subject, action, resource))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package authorizer
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/utils"
+)
+
+var _ Checker = &CachedChecker{}
+
+const _defaultDecisionTTL = 30 * time.Second
+
+var (
+	_allowed = []byte{1}
+	_denied  = []byte{0}
+)
+
+// CachedChecker remembers the decisions of a Checker for a short time, errors are not cached.
+// Purge it after changing policies to apply them before the decisions expire.
+type CachedChecker struct {
+	checker Checker
+	cache   cache.Cache
+	ttl     time.Duration
+}
+
+// NewCachedChecker caches the decisions of checker in c for ttl, ttl <= 0 defaults to 30s.
+func NewCachedChecker(checker Checker, c cache.Cache, ttl time.Duration) *CachedChecker {
+	if ttl <= 0 {
+		ttl = _defaultDecisionTTL
+	}
+	return &CachedChecker{checker: checker, cache: c, ttl: ttl}
+}
+
+func (c *CachedChecker) Check(subject, tenantID, resource, action string) (bool, error) {
+	key := "authz:" + strings.Join([]string{tenantID, subject, resource, action}, "\x00")
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	value, err := c.cache.Get(ctx, key)
+	cancel()
+	if err == nil && len(value) == 1 {
+		return value[0] == 1, nil
+	}
+	ok, err := c.checker.Check(subject, tenantID, resource, action)
+	if err != nil {
+		return false, err
+	}
+	value = _denied
+	if ok {
+		value = _allowed
+	}
+	ctx, cancel = utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_ = c.cache.Set(ctx, key, value, c.ttl)
+	return ok, nil
+}
+
+// Purge drops all entries of the cache, give the checker a cache of its own.
+func (c *CachedChecker) Purge() error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	return cache.Purge(ctx, c.cache)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+)
+
+const _defaultBatchSize = 1000
+
+// Filter selects the policies of some tenants, pass it to LoadFilteredPolicy of an
+// enforcer backed by a filtered adapter to only hold the tenants an instance serves.
+type Filter struct {
+	Domains []string
+}
+
+// domainField returns the index of the domain in rules of the tenant model: the second
+// field of policies, the third of grouping policies.
+func domainField(ptype string) int {
+	if strings.HasPrefix(ptype, "g") {
+		return 2
+	}
+	return 1
+}
+
+func domainOf(ptype string, rule []string) string {
+	i := domainField(ptype)
+	if len(rule) <= i {
+		return ""
+	}
+	return rule[i]
+}
+
+func (f *Filter) matches(ptype string, rule []string) bool {
+	if f == nil || len(f.Domains) == 0 {
+		return true
+	}
+	dom := domainOf(ptype, rule)
+	for _, d := range f.Domains {
+		if d == dom {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFilteredRule reports whether rule matches the fieldValues from fieldIndex,
+// empty values match any field like in RemoveFilteredPolicy of the enforcer.
+func matchesFilteredRule(rule []string, fieldIndex int, fieldValues ...string) bool {
+	for i, v := range fieldValues {
+		if v == "" {
+			continue
+		}
+		if fieldIndex+i >= len(rule) || rule[fieldIndex+i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func toFilter(filter interface{}) *Filter {
+	switch f := filter.(type) {
+	case Filter:
+		return &f
+	case *Filter:
+		return f
+	}
+	return nil
+}
+
+// modelRules returns the rules of m as lines of ptype followed by the fields.
+func modelRules(m model.Model) [][]string {
+	var lines [][]string
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range m[sec] {
+			for _, rule := range ast.Policy {
+				lines = append(lines, append([]string{ptype}, rule...))
+			}
+		}
+	}
+	return lines
+}
+
+// loadLine adds line to m, skipping ptypes the model doesn't define.
+func loadLine(line []string, m model.Model) {
+	if len(line) < 2 || line[0] == "" {
+		return
+	}
+	if sec, ok := m[line[0][:1]]; !ok || sec[line[0]] == nil {
+		return
+	}
+	persist.LoadPolicyArray(line, m)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAdapters(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	// an adapter instance is filtered once an enforcer loaded a filtered policy through it.
+	adapters := []struct {
+		name       string
+		newAdapter func() persist.FilteredAdapter
+	}{
+		{"gorm", func() persist.FilteredAdapter {
+			a, err := NewGormAdapter(db)
+			assert.NoError(t, err)
+			return a
+		}},
+		{"redis", func() persist.FilteredAdapter { return NewRedisAdapter(client, "") }},
+	}
+	for _, tt := range adapters {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := tt.newAdapter()
+			e, err := NewEnforcer(adapter)
+			assert.NoError(t, err)
+			_, err = e.AddPolicies([][]string{{"admin", "t1", "*", "*"}, {"viewer", "t1", "device", "read"}, {"admin", "t2", "*", "*"}})
+			assert.NoError(t, err)
+			_, err = e.AddPolicy("admin", "t1", "*", "*")
+			assert.NoError(t, err)
+			_, err = e.AddGroupingPolicies([][]string{{"alice", "admin", "t1"}, {"bob", "viewer", "t1"}, {"carol", "admin", "t2"}})
+			assert.NoError(t, err)
+			_, err = e.RemovePolicy("viewer", "t1", "device", "read")
+			assert.NoError(t, err)
+			_, err = e.RemoveFilteredGroupingPolicy(1, "viewer", "t1")
+			assert.NoError(t, err)
+
+			reloaded, err := NewEnforcer(adapter)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, [][]string{{"admin", "t1", "*", "*"}, {"admin", "t2", "*", "*"}}, reloaded.GetPolicy())
+			assert.ElementsMatch(t, [][]string{{"alice", "admin", "t1"}, {"carol", "admin", "t2"}}, reloaded.GetGroupingPolicy())
+
+			filtered, err := NewFilteredEnforcer(tt.newAdapter(), Filter{Domains: []string{"t1"}})
+			assert.NoError(t, err)
+			assert.Equal(t, [][]string{{"admin", "t1", "*", "*"}}, filtered.GetPolicy())
+			ok, err := filtered.Enforce("alice", "t1", "device", "write")
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			ok, err = filtered.Enforce("carol", "t2", "device", "write")
+			assert.NoError(t, err)
+			assert.False(t, ok)
+			assert.Error(t, filtered.SavePolicy())
+
+			reloaded.ClearPolicy()
+			_, err = reloaded.AddPolicy("admin", "t3", "*", "*")
+			assert.NoError(t, err)
+			assert.NoError(t, reloaded.SavePolicy())
+			reloaded, err = NewEnforcer(adapter)
+			assert.NoError(t, err)
+			assert.Equal(t, [][]string{{"admin", "t3", "*", "*"}}, reloaded.GetPolicy())
+			assert.Empty(t, reloaded.GetGroupingPolicy())
+		})
+	}
+}
+
+func TestGormAdapterXormTable(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:xorm?mode=memory"), &gorm.Config{})
+	assert.NoError(t, err)
+	// the table as created by the xorm adapter.
+	assert.NoError(t, db.Exec("CREATE TABLE casbin_rule (id INTEGER PRIMARY KEY AUTOINCREMENT, p_type VARCHAR(100), "+
+		"v0 VARCHAR(100), v1 VARCHAR(100), v2 VARCHAR(100), v3 VARCHAR(100), v4 VARCHAR(100), v5 VARCHAR(100))").Error)
+	assert.NoError(t, db.Exec("INSERT INTO casbin_rule (p_type, v0, v1, v2, v3, v4, v5) VALUES "+
+		"('p', 'admin', 't1', '*', '*', '', ''), ('g', 'alice', 'admin', 't1', '', '', '')").Error)
+
+	adapter, err := NewGormAdapter(db)
+	assert.NoError(t, err)
+	e, err := NewEnforcer(adapter)
+	assert.NoError(t, err)
+	ok, err := e.Enforce("alice", "t1", "device", "write")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -18,14 +18,21 @@ import (
 
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
 	xormadapter "github.com/casbin/xorm-adapter/v2"
-	"github.com/tkeel-io/kit/log"
+	"github.com/tkeel-io/security/log"
 	// _ init sql driver in rbac model.
 	_ "github.com/go-sql-driver/mysql"
 )
 
+// DenyPolicyType the policy type of deny policies, they have the fields of allow policies.
+const DenyPolicyType = "p2"
+
 var (
 	_enforcer *casbin.SyncedEnforcer
+
+	// _denyContext matches the request against the deny policies.
+	_denyContext = casbin.EnforceContext{RType: "r", PType: DenyPolicyType, EType: "e2", MType: "m2"}
 )
 
 type MysqlConf struct {
@@ -40,35 +47,60 @@ func NewRBACOperator(conf *MysqlConf) (enforcer *casbin.SyncedEnforcer, err erro
 	dataSourceName := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", conf.User, conf.Password, conf.Host, conf.Port, conf.DBName)
 	adapter, err := xormadapter.NewAdapter("mysql", dataSourceName, true)
 	if err != nil {
-		log.Error(err)
+		log.Errorf("casbin xorm adapter %s", err)
 		return
 	}
 
-	casbinModel, err := model.NewModelFromString(_textCasbinModel)
+	_enforcer, err = NewEnforcer(adapter)
 	if err != nil {
-		log.Error(err)
+		log.Errorf("casbin enforcer %s", err)
 		return
 	}
+	_enforcer.EnableLog(true)
 
-	_enforcer, err = casbin.NewSyncedEnforcer(casbinModel, adapter)
+	return _enforcer, nil
+}
+
+// NewEnforcer returns an enforcer of the tenant domain model with the policies of adapter loaded,
+// a nil adapter keeps the policies in memory only.
+func NewEnforcer(adapter persist.Adapter) (*casbin.SyncedEnforcer, error) {
+	casbinModel, err := model.NewModelFromString(_textCasbinModel)
 	if err != nil {
-		log.Error(err)
-		return
+		return nil, fmt.Errorf("casbin model %w", err)
+	}
+	var enforcer *casbin.SyncedEnforcer
+	if adapter == nil {
+		enforcer, err = casbin.NewSyncedEnforcer(casbinModel)
+	} else {
+		// the enforcer loads the policies of the adapter.
+		enforcer, err = casbin.NewSyncedEnforcer(casbinModel, adapter)
 	}
-	err = _enforcer.LoadPolicy()
 	if err != nil {
-		log.Error(err)
-		return
+		return nil, fmt.Errorf("casbin enforcer %w", err)
 	}
-	_enforcer.EnableLog(true)
+	enforcer.AddFunction("resourceMatch", resourceMatchFunc)
+	enforcer.AddFunction("actionMatch", actionMatchFunc)
+	return enforcer, nil
+}
 
-	return _enforcer, nil
+// NewFilteredEnforcer returns an enforcer holding only the policies of the domains of filter,
+// e.g. for instances serving a subset of the tenants.
+func NewFilteredEnforcer(adapter persist.FilteredAdapter, filter Filter) (*casbin.SyncedEnforcer, error) {
+	enforcer, err := NewEnforcer(nil)
+	if err != nil {
+		return nil, err
+	}
+	enforcer.SetAdapter(adapter)
+	if err = enforcer.LoadFilteredPolicy(filter); err != nil {
+		return nil, fmt.Errorf("casbin load filtered policy %w", err)
+	}
+	return enforcer, nil
 }
 
 func AddGroupingPolicy(gPolicy *GroupingPolicy) (ok bool, err error) {
 	err = gPolicy.Valid()
 	if err != nil {
-		log.Error(err)
+		log.Errorf("casbin grouping policy %s", err)
 		return
 	}
 	params := []string{gPolicy.Subject, gPolicy.Role, gPolicy.Domain}
@@ -79,7 +111,7 @@ func AddGroupingPolicy(gPolicy *GroupingPolicy) (ok bool, err error) {
 func AddPolicy(pPolicy *Policy) (ok bool, err error) {
 	err = pPolicy.Valid()
 	if err != nil {
-		log.Error(err)
+		log.Errorf("casbin policy %s", err)
 		return
 	}
 	params := []string{pPolicy.Role, pPolicy.Domain, pPolicy.Object, pPolicy.Action}
@@ -90,14 +122,50 @@ func AddPolicy(pPolicy *Policy) (ok bool, err error) {
 func Enforce(r *RequestPolicy) (ok bool, err error) {
 	err = r.Valid()
 	if err != nil {
-		log.Error(err)
+		log.Errorf("casbin request %s", err)
 		return
 	}
-	params := []string{r.Subject, r.Domain, r.Object, r.Action}
-	ok, err = _enforcer.Enforce(params)
+	ok, _, err = Decide(_enforcer, r.Subject, r.Domain, r.Object, r.Action)
 	return
 }
 
+// Decide enforces with deny-override: a matching deny policy wins over any allow policy.
+// It returns the deciding rule, the deny policy when one matched.
+func Decide(e *casbin.SyncedEnforcer, sub, dom, obj, act string) (bool, []string, error) {
+	allowed, rule, err := e.EnforceEx(sub, dom, obj, act)
+	if err != nil || !allowed {
+		return false, nil, err
+	}
+	denied, denyRule, err := e.EnforceEx(_denyContext, sub, dom, obj, act)
+	if err != nil {
+		return false, nil, err
+	}
+	if denied {
+		return false, denyRule, nil
+	}
+	return true, rule, nil
+}
+
+// DecideBatch is Decide for many requests of sub, dom, obj, act.
+func DecideBatch(e *casbin.SyncedEnforcer, requests [][]interface{}) ([]bool, error) {
+	allowed, err := e.BatchEnforce(requests)
+	if err != nil {
+		return nil, err
+	}
+	denyRequests := make([][]interface{}, len(requests))
+	for i, r := range requests {
+		denyRequests[i] = append([]interface{}{_denyContext}, r...)
+	}
+	denied, err := e.BatchEnforce(denyRequests)
+	if err != nil {
+		return nil, err
+	}
+	for i := range allowed {
+		allowed[i] = allowed[i] && !denied[i]
+	}
+	return allowed, nil
+}
+
 func HasRoleInDomain(userID, role, domain string) bool {
 	return _enforcer.HasGroupingPolicy(userID, role, domain)
 }
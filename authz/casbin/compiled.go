@@ -0,0 +1,279 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/log"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/persist"
+)
+
+var (
+	_ authorizer.Checker   = &CompiledChecker{}
+	_ authorizer.Explainer = &CompiledChecker{}
+)
+
+// CompiledChecker decides like Decide from an immutable snapshot of the policies of an enforcer:
+// the roles of every subject are resolved transitively, resource patterns are compiled and rules
+// are indexed by tenant, subject and exact resource, so a check costs a few map lookups instead of
+// a scan of the policies. The snapshot is swapped atomically by Rebuild whenever the policies
+// change: SetWatcher rebuilds on the changes of other instances and rbac.WithCompiledChecker on
+// the changes made through a RoleOperator, other changes of the enforcer must call Rebuild.
+type CompiledChecker struct {
+	enforcer *casbin.SyncedEnforcer
+	snapshot atomic.Value
+	logger   *log.Helper
+}
+
+// NewCompiledChecker returns a CompiledChecker of the current policies of e.
+func NewCompiledChecker(e *casbin.SyncedEnforcer) (*CompiledChecker, error) {
+	c := &CompiledChecker{enforcer: e}
+	if err := c.Rebuild(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetLogger writes the entries of the policy reloads to l instead of the global logger.
+func (c *CompiledChecker) SetLogger(l log.Logger) {
+	c.logger = log.NewHelper(l)
+}
+
+// policySnapshot the compiled policies by tenant.
+type policySnapshot map[string]*tenantPolicies
+
+type tenantPolicies struct {
+	// roles subject and all roles it reaches by subject, absent subjects only have themselves.
+	roles map[string][]string
+	allow map[string]*ruleIndex
+	deny  map[string]*ruleIndex
+}
+
+// ruleIndex the rules of a policy subject.
+type ruleIndex struct {
+	// exact rules of resources without wildcards by resource trimmed of /.
+	exact    map[string][]*compiledRule
+	patterns []*compiledRule
+}
+
+type compiledRule struct {
+	rule     []string
+	resource *resourcePattern
+	// actions the alternatives of the action, nil when every action matches.
+	actions map[string]bool
+}
+
+// SetWatcher sets w as the watcher of the enforcer, the policies changed by other instances are
+// reloaded and compiled. It replaces the update callback SetWatcher of the enforcer installs.
+func (c *CompiledChecker) SetWatcher(w persist.Watcher) error {
+	if err := c.enforcer.SetWatcher(w); err != nil {
+		return fmt.Errorf("set watcher %w", err)
+	}
+	return w.SetUpdateCallback(func(string) {
+		if err := c.enforcer.LoadPolicy(); err != nil {
+			c.logger.Errorf("casbin reload policy %s", err)
+			return
+		}
+		if err := c.Rebuild(); err != nil {
+			c.logger.Errorf("casbin rebuild compiled checker %s", err)
+		}
+	})
+}
+
+// Rebuild compiles the current policies of the enforcer and swaps them in.
+func (c *CompiledChecker) Rebuild() error {
+	snapshot := make(policySnapshot)
+	for _, rule := range c.enforcer.GetPolicy() {
+		if err := snapshot.add(rule, false); err != nil {
+			return err
+		}
+	}
+	for _, rule := range c.enforcer.GetNamedPolicy(DenyPolicyType) {
+		if err := snapshot.add(rule, true); err != nil {
+			return err
+		}
+	}
+	links := make(map[string]map[string][]string)
+	for _, rule := range c.enforcer.GetGroupingPolicy() {
+		if len(rule) < 3 {
+			return fmt.Errorf("grouping policy %v %w", rule, errInvalidParam)
+		}
+		if links[rule[2]] == nil {
+			links[rule[2]] = make(map[string][]string)
+		}
+		links[rule[2]][rule[0]] = append(links[rule[2]][rule[0]], rule[1])
+	}
+	for tenantID, parents := range links {
+		tp := snapshot.tenant(tenantID)
+		for subject := range parents {
+			tp.roles[subject] = closure(subject, parents)
+		}
+	}
+	c.snapshot.Store(snapshot)
+	return nil
+}
+
+func (s policySnapshot) tenant(tenantID string) *tenantPolicies {
+	tp, ok := s[tenantID]
+	if !ok {
+		tp = &tenantPolicies{
+			roles: make(map[string][]string),
+			allow: make(map[string]*ruleIndex),
+			deny:  make(map[string]*ruleIndex),
+		}
+		s[tenantID] = tp
+	}
+	return tp
+}
+
+func (s policySnapshot) add(rule []string, deny bool) error {
+	if len(rule) < 4 {
+		return fmt.Errorf("policy %v %w", rule, errInvalidParam)
+	}
+	tp := s.tenant(rule[1])
+	indexes := tp.allow
+	if deny {
+		indexes = tp.deny
+	}
+	index, ok := indexes[rule[0]]
+	if !ok {
+		index = &ruleIndex{exact: make(map[string][]*compiledRule)}
+		indexes[rule[0]] = index
+	}
+	cr := &compiledRule{rule: rule, resource: compileResourcePattern(rule[2])}
+	if rule[3] != "*" {
+		cr.actions = make(map[string]bool)
+		for _, alt := range strings.Split(rule[3], "|") {
+			cr.actions[alt] = true
+		}
+		// the whole pattern matches too, as with r.act == p.act.
+		cr.actions[rule[3]] = true
+	}
+	if cr.resource.literal() {
+		key := strings.Trim(rule[2], "/")
+		index.exact[key] = append(index.exact[key], cr)
+	} else {
+		index.patterns = append(index.patterns, cr)
+	}
+	return nil
+}
+
+// closure returns subject and every role reachable from it through parents.
+func closure(subject string, parents map[string][]string) []string {
+	roles := []string{subject}
+	seen := map[string]bool{subject: true}
+	for i := 0; i < len(roles); i++ {
+		for _, parent := range parents[roles[i]] {
+			if !seen[parent] {
+				seen[parent] = true
+				roles = append(roles, parent)
+			}
+		}
+	}
+	return roles
+}
+
+// literal reports whether the pattern holds no wildcards, it then matches the resources equal to
+// it once trimmed of /.
+func (p *resourcePattern) literal() bool {
+	if p.any {
+		return false
+	}
+	for _, seg := range p.segments {
+		if strings.ContainsAny(seg, "*?[") {
+			return false
+		}
+	}
+	return true
+}
+
+// Check decides with deny-override like Decide.
+func (c *CompiledChecker) Check(subject, tenantID, resource, action string) (bool, error) {
+	ok, _, err := c.Explain(subject, tenantID, resource, action)
+	return ok, err
+}
+
+// Explain is Check that also returns the deciding rule, the deny policy when one matched.
+func (c *CompiledChecker) Explain(subject, tenantID, resource, action string) (bool, []string, error) {
+	if subject == "" || tenantID == "" || resource == "" || action == "" {
+		return false, nil, errInvalidParam
+	}
+	snapshot, _ := c.snapshot.Load().(policySnapshot)
+	tp, ok := snapshot[tenantID]
+	if !ok {
+		return false, nil, nil
+	}
+	roles, ok := tp.roles[subject]
+	if !ok {
+		roles = []string{subject}
+	}
+	m := matcher{resource: resource, key: strings.Trim(resource, "/"), action: action}
+	allow := m.match(roles, tp.allow)
+	if allow == nil {
+		return false, nil, nil
+	}
+	if deny := m.match(roles, tp.deny); deny != nil {
+		return false, deny, nil
+	}
+	return true, allow, nil
+}
+
+// matcher matches a request against rule indexes, the segments of the resource are split
+// once, when a pattern is tried.
+type matcher struct {
+	resource string
+	key      string
+	action   string
+	segments []string
+}
+
+// match returns the first rule of the indexes of roles matching the request.
+func (m *matcher) match(roles []string, indexes map[string]*ruleIndex) []string {
+	for _, role := range roles {
+		index, ok := indexes[role]
+		if !ok {
+			continue
+		}
+		for _, cr := range index.exact[m.key] {
+			if cr.matchAction(m.action) {
+				return cr.rule
+			}
+		}
+		for _, cr := range index.patterns {
+			if !cr.matchAction(m.action) {
+				continue
+			}
+			if cr.resource.any || cr.rule[2] == m.resource {
+				return cr.rule
+			}
+			if m.segments == nil {
+				m.segments = strings.Split(m.key, "/")
+			}
+			if matchSegments(m.segments, cr.resource.segments) {
+				return cr.rule
+			}
+		}
+	}
+	return nil
+}
+
+func (cr *compiledRule) matchAction(action string) bool {
+	return cr.actions == nil || cr.actions[action]
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompiledChecker(t *testing.T) {
+	e, err := NewEnforcer(nil)
+	assert.NoError(t, err)
+	_, err = e.AddPolicies([][]string{
+		{"viewer", "t1", "spaces/floor1/**", "read"},
+		{"viewer", "t1", "/v1/devices/{id}", "GET|HEAD"},
+		{"operator", "t1", "devices/d1", "write"},
+		{"admin", "t1", "*", "*"},
+		{"viewer", "t2", "spaces/**", "read"},
+	})
+	assert.NoError(t, err)
+	_, err = e.AddNamedPolicy(DenyPolicyType, "bob", "t1", "devices/d1", "*")
+	assert.NoError(t, err)
+	_, err = e.AddGroupingPolicies([][]string{
+		{"alice", "viewer", "t1"},
+		{"bob", "operator", "t1"},
+		{"operator", "viewer", "t1"},
+		{"carol", "admin", "t1"},
+	})
+	assert.NoError(t, err)
+
+	c, err := NewCompiledChecker(e)
+	assert.NoError(t, err)
+	subjects := []string{"alice", "bob", "carol", "viewer", "dave"}
+	tenants := []string{"t1", "t2"}
+	resources := []string{"spaces/floor1/room2", "spaces/floor2", "/v1/devices/d9", "v1/devices/d9", "devices/d1", "/devices/d1/", "devices/d2"}
+	actions := []string{"read", "write", "GET", "HEAD", "POST"}
+	for _, sub := range subjects {
+		for _, dom := range tenants {
+			for _, obj := range resources {
+				for _, act := range actions {
+					want, wantRule, err := Decide(e, sub, dom, obj, act)
+					assert.NoError(t, err)
+					got, rule, err := c.Explain(sub, dom, obj, act)
+					assert.NoError(t, err)
+					assert.Equal(t, want, got, "%s %s %s %s", sub, dom, obj, act)
+					if want || len(wantRule) > 0 {
+						assert.NotEmpty(t, rule, "%s %s %s %s", sub, dom, obj, act)
+					}
+				}
+			}
+		}
+	}
+
+	// changes apply once rebuilt.
+	_, err = e.AddGroupingPolicy("dave", "admin", "t1")
+	assert.NoError(t, err)
+	ok, err := c.Check("dave", "t1", "devices/d2", "write")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, c.Rebuild())
+	ok, err = c.Check("dave", "t1", "devices/d2", "write")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = c.Check("", "t1", "devices/d2", "write")
+	assert.ErrorIs(t, err, errInvalidParam)
+}
+
+func BenchmarkCompiledChecker(b *testing.B) {
+	e, err := NewEnforcer(nil)
+	assert.NoError(b, err)
+	rules := make([][]string, 0, 20000)
+	groupings := make([][]string, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		role := fmt.Sprintf("role-%d", i)
+		rules = append(rules,
+			[]string{role, "t1", fmt.Sprintf("devices/d%d", i), "GET|HEAD"},
+			[]string{role, "t1", fmt.Sprintf("spaces/s%d/**", i), "read"})
+		groupings = append(groupings, []string{fmt.Sprintf("user-%d", i), role, "t1"})
+	}
+	_, err = e.AddPolicies(rules)
+	assert.NoError(b, err)
+	_, err = e.AddGroupingPolicies(groupings)
+	assert.NoError(b, err)
+	c, err := NewCompiledChecker(e)
+	assert.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ok, _ := c.Check("user-42", "t1", "devices/d42", "GET"); !ok {
+			b.Fatal("denied")
+		}
+	}
+}
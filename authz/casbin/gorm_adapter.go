@@ -0,0 +1,202 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	_ persist.Adapter         = &GormAdapter{}
+	_ persist.BatchAdapter    = &GormAdapter{}
+	_ persist.FilteredAdapter = &GormAdapter{}
+)
+
+// CasbinRule a row of the policy table, compatible with the table of the xorm adapter.
+type CasbinRule struct {
+	ID    uint   `gorm:"primaryKey;autoIncrement"`
+	PType string `gorm:"column:p_type;type:varchar(100);not null;uniqueIndex:unique_index"`
+	V0    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_index"`
+	V1    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_index;index"`
+	V2    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_index;index"`
+	V3    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_index"`
+	V4    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_index"`
+	V5    string `gorm:"type:varchar(100);not null;default:'';uniqueIndex:unique_index"`
+}
+
+func (CasbinRule) TableName() string {
+	return "casbin_rule"
+}
+
+func newCasbinRule(ptype string, rule []string) *CasbinRule {
+	r := &CasbinRule{PType: ptype}
+	fields := []*string{&r.V0, &r.V1, &r.V2, &r.V3, &r.V4, &r.V5}
+	for i := 0; i < len(rule) && i < len(fields); i++ {
+		*fields[i] = rule[i]
+	}
+	return r
+}
+
+func (r *CasbinRule) line() []string {
+	line := []string{r.PType, r.V0, r.V1, r.V2, r.V3, r.V4, r.V5}
+	for len(line) > 1 && line[len(line)-1] == "" {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// conditions matches exactly this rule, struct conditions would skip the empty fields.
+func (r *CasbinRule) conditions() map[string]interface{} {
+	return map[string]interface{}{
+		"p_type": r.PType, "v0": r.V0, "v1": r.V1, "v2": r.V2, "v3": r.V3, "v4": r.V4, "v5": r.V5,
+	}
+}
+
+// GormAdapter persists policies with gorm in MySQL or Postgres.
+type GormAdapter struct {
+	db       *gorm.DB
+	filtered bool
+	// BatchSize rows read per query when loading. Default to 1000.
+	BatchSize int
+}
+
+// NewGormAdapter returns a GormAdapter and migrates the policy table.
+func NewGormAdapter(db *gorm.DB) (*GormAdapter, error) {
+	if err := db.AutoMigrate(&CasbinRule{}); err != nil {
+		return nil, fmt.Errorf("migrate casbin rule %w", err)
+	}
+	return &GormAdapter{db: db, BatchSize: _defaultBatchSize}, nil
+}
+
+func (a *GormAdapter) LoadPolicy(m model.Model) error {
+	a.filtered = false
+	return a.load(m, nil)
+}
+
+// LoadFilteredPolicy loads the policies of the domains of filter, a Filter or *Filter.
+func (a *GormAdapter) LoadFilteredPolicy(m model.Model, filter interface{}) error {
+	f := toFilter(filter)
+	if f == nil {
+		return fmt.Errorf("%w: unsupported filter %T", errInvalidParam, filter)
+	}
+	if err := a.load(m, f); err != nil {
+		return err
+	}
+	a.filtered = len(f.Domains) > 0
+	return nil
+}
+
+func (a *GormAdapter) IsFiltered() bool {
+	return a.filtered
+}
+
+func (a *GormAdapter) load(m model.Model, f *Filter) error {
+	db := a.db.Model(&CasbinRule{}).Order("id")
+	if f != nil && len(f.Domains) > 0 {
+		db = db.Where("(p_type LIKE 'p%' AND v1 IN ?) OR (p_type LIKE 'g%' AND v2 IN ?)", f.Domains, f.Domains)
+	}
+	var rules []*CasbinRule
+	err := db.FindInBatches(&rules, a.batchSize(), func(tx *gorm.DB, batch int) error {
+		for _, r := range rules {
+			loadLine(r.line(), m)
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return fmt.Errorf("load casbin rules %w", err)
+	}
+	return nil
+}
+
+// SavePolicy replaces all stored policies with the policies of m.
+func (a *GormAdapter) SavePolicy(m model.Model) error {
+	if a.filtered {
+		return errors.New("cannot save a filtered policy")
+	}
+	var rows []*CasbinRule
+	for _, line := range modelRules(m) {
+		rows = append(rows, newCasbinRule(line[0], line[1:]))
+	}
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&CasbinRule{}).Error; err != nil {
+			return fmt.Errorf("clear casbin rules %w", err)
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := tx.CreateInBatches(rows, a.batchSize()).Error; err != nil {
+			return fmt.Errorf("save casbin rules %w", err)
+		}
+		return nil
+	})
+}
+
+func (a *GormAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return a.AddPolicies(sec, ptype, [][]string{rule})
+}
+
+func (a *GormAdapter) AddPolicies(sec string, ptype string, rules [][]string) error {
+	rows := make([]*CasbinRule, 0, len(rules))
+	for _, rule := range rules {
+		rows = append(rows, newCasbinRule(ptype, rule))
+	}
+	if err := a.db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, a.batchSize()).Error; err != nil {
+		return fmt.Errorf("add casbin rules %w", err)
+	}
+	return nil
+}
+
+func (a *GormAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	return a.RemovePolicies(sec, ptype, [][]string{rule})
+}
+
+func (a *GormAdapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		for _, rule := range rules {
+			if err := tx.Where(newCasbinRule(ptype, rule).conditions()).Delete(&CasbinRule{}).Error; err != nil {
+				return fmt.Errorf("remove casbin rule %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (a *GormAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	if fieldIndex < 0 || fieldIndex+len(fieldValues) > 6 {
+		return errInvalidParam
+	}
+	db := a.db.Where("p_type = ?", ptype)
+	for i, v := range fieldValues {
+		if v != "" {
+			db = db.Where(fmt.Sprintf("v%d = ?", fieldIndex+i), v)
+		}
+	}
+	if err := db.Delete(&CasbinRule{}).Error; err != nil {
+		return fmt.Errorf("remove filtered casbin rules %w", err)
+	}
+	return nil
+}
+
+func (a *GormAdapter) batchSize() int {
+	if a.BatchSize <= 0 {
+		return _defaultBatchSize
+	}
+	return a.BatchSize
+}
@@ -24,15 +24,18 @@ r = sub, dom, obj, act
 
 [policy_definition]
 p = sub, dom, obj, act
+p2 = sub, dom, obj, act
 
 [role_definition]
 g = _, _, _
 
 [policy_effect]
 e = some(where (p.eft == allow))
+e2 = some(where (p.eft == allow))
 
 [matchers]
-m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && (r.obj == p.obj || p.obj == '*') && ( r.act == p.act || p.act == '*') 
+m = g(r.sub, p.sub, r.dom) && r.dom == p.dom && (r.obj == p.obj || resourceMatch(r.obj, p.obj)) && (r.act == p.act || actionMatch(r.act, p.act))
+m2 = g(r.sub, p2.sub, r.dom) && r.dom == p2.dom && (r.obj == p2.obj || resourceMatch(r.obj, p2.obj)) && (r.act == p2.act || actionMatch(r.act, p2.act))
 `
 )
 
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// _patterns compiled resource patterns by pattern, policies reuse a few patterns
+// so the cache stays small.
+var _patterns sync.Map
+
+type resourcePattern struct {
+	segments []string
+	// any the pattern is "*" and matches every resource.
+	any bool
+}
+
+func compileResourcePattern(pattern string) *resourcePattern {
+	if p, ok := _patterns.Load(pattern); ok {
+		return p.(*resourcePattern)
+	}
+	p := &resourcePattern{any: pattern == "*"}
+	if !p.any {
+		p.segments = strings.Split(strings.Trim(pattern, "/"), "/")
+		for i, seg := range p.segments {
+			// path template parameters match any segment.
+			if (strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")) || strings.HasPrefix(seg, ":") {
+				p.segments[i] = "*"
+			}
+		}
+	}
+	_patterns.Store(pattern, p)
+	return p
+}
+
+// ResourceMatch reports whether resource matches pattern. Patterns are / separated, a "*" segment
+// or a path template parameter ({id} or :id) matches one segment, "**" matches any number of
+// segments and segments may hold globs like "dev-*". The pattern "*" matches every resource.
+func ResourceMatch(resource, pattern string) bool {
+	if resource == pattern {
+		return true
+	}
+	p := compileResourcePattern(pattern)
+	if p.any {
+		return true
+	}
+	return matchSegments(strings.Split(strings.Trim(resource, "/"), "/"), p.segments)
+}
+
+func matchSegments(resource, pattern []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// trailing ** matches the rest, otherwise try every split.
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(resource); i++ {
+				if matchSegments(resource[i:], pattern[1:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(resource) == 0 || !matchSegment(resource[0], pattern[0]) {
+			return false
+		}
+		resource, pattern = resource[1:], pattern[1:]
+	}
+	return len(resource) == 0
+}
+
+func matchSegment(segment, pattern string) bool {
+	if pattern == "*" {
+		return segment != ""
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return segment == pattern
+	}
+	ok, err := path.Match(pattern, segment)
+	return err == nil && ok
+}
+
+// ActionMatch reports whether action matches pattern, "*" or | separated alternatives like "GET|HEAD".
+func ActionMatch(action, pattern string) bool {
+	if pattern == "*" || action == pattern {
+		return true
+	}
+	for _, alt := range strings.Split(pattern, "|") {
+		if alt == action {
+			return true
+		}
+	}
+	return false
+}
+
+func resourceMatchFunc(args ...interface{}) (interface{}, error) {
+	resource, pattern, err := stringArgs(args)
+	if err != nil {
+		return false, err
+	}
+	return ResourceMatch(resource, pattern), nil
+}
+
+func actionMatchFunc(args ...interface{}) (interface{}, error) {
+	action, pattern, err := stringArgs(args)
+	if err != nil {
+		return false, err
+	}
+	return ActionMatch(action, pattern), nil
+}
+
+func stringArgs(args []interface{}) (string, string, error) {
+	if len(args) != 2 {
+		return "", "", errInvalidParam
+	}
+	a, ok := args[0].(string)
+	if !ok {
+		return "", "", errInvalidParam
+	}
+	b, ok := args[1].(string)
+	if !ok {
+		return "", "", errInvalidParam
+	}
+	return a, b, nil
+}
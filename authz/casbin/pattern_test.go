@@ -0,0 +1,75 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceMatch(t *testing.T) {
+	tests := []struct {
+		resource, pattern string
+		match             bool
+	}{
+		{"devices/d1", "*", true},
+		{"devices/d1", "devices/*", true},
+		{"devices", "devices/*", false},
+		{"devices/d1/telemetry", "devices/*", false},
+		{"spaces/floor1/room2/sensor", "spaces/floor1/**", true},
+		{"spaces/floor1", "spaces/floor1/**", true},
+		{"spaces/floor2/room2", "spaces/floor1/**", false},
+		{"spaces/floor1/room2/sensor", "spaces/**/sensor", true},
+		{"spaces/floor1/room2/camera", "spaces/**/sensor", false},
+		{"/v1/devices/d1/commands", "/v1/devices/{id}/commands", true},
+		{"/v1/devices/d1/commands", "/v1/devices/:id/commands", true},
+		{"/v1/devices//commands", "/v1/devices/{id}/commands", false},
+		{"plugins/dev-kit", "plugins/dev-*", true},
+		{"plugins/core", "plugins/dev-*", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, ResourceMatch(tt.resource, tt.pattern), "%s %s", tt.resource, tt.pattern)
+	}
+	assert.True(t, ActionMatch("GET", "GET|HEAD"))
+	assert.False(t, ActionMatch("POST", "GET|HEAD"))
+	assert.True(t, ActionMatch("DELETE", "*"))
+}
+
+func TestEnforcePatterns(t *testing.T) {
+	e, err := NewEnforcer(nil)
+	assert.NoError(t, err)
+	_, err = e.AddPolicies([][]string{
+		{"viewer", "t1", "spaces/floor1/**", "read"},
+		{"viewer", "t1", "/v1/devices/{id}", "GET|HEAD"},
+	})
+	assert.NoError(t, err)
+	_, err = e.AddGroupingPolicy("alice", "viewer", "t1")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		obj, act string
+		allowed  bool
+	}{
+		{"spaces/floor1/room1", "read", true},
+		{"spaces/floor2/room1", "read", false},
+		{"/v1/devices/d1", "HEAD", true},
+		{"/v1/devices/d1", "DELETE", false},
+	}
+	for _, tt := range tests {
+		ok, err := e.Enforce("alice", "t1", tt.obj, tt.act)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.allowed, ok, "%+v", tt)
+	}
+}
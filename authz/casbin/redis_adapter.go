@@ -0,0 +1,244 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/go-redis/redis/v8"
+)
+
+const _defaultRedisKey = "casbin"
+
+var (
+	_ persist.Adapter         = &RedisAdapter{}
+	_ persist.BatchAdapter    = &RedisAdapter{}
+	_ persist.FilteredAdapter = &RedisAdapter{}
+)
+
+// RedisAdapter persists policies in redis, one set per domain so tenants load independently:
+// <key>:domains lists the domains, <key>:domain:<domain> holds the json encoded rules.
+type RedisAdapter struct {
+	client   redis.UniversalClient
+	key      string
+	filtered bool
+}
+
+// NewRedisAdapter returns a RedisAdapter storing under key, default to "casbin".
+func NewRedisAdapter(client redis.UniversalClient, key string) *RedisAdapter {
+	if key == "" {
+		key = _defaultRedisKey
+	}
+	return &RedisAdapter{client: client, key: key}
+}
+
+func (a *RedisAdapter) domainsKey() string {
+	return a.key + ":domains"
+}
+
+func (a *RedisAdapter) domainKey(domain string) string {
+	return a.key + ":domain:" + domain
+}
+
+func (a *RedisAdapter) LoadPolicy(m model.Model) error {
+	a.filtered = false
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	domains, err := a.client.SMembers(ctx, a.domainsKey()).Result()
+	if err != nil {
+		return fmt.Errorf("load casbin domains %w", err)
+	}
+	return a.load(m, domains)
+}
+
+// LoadFilteredPolicy loads the policies of the domains of filter, a Filter or *Filter.
+func (a *RedisAdapter) LoadFilteredPolicy(m model.Model, filter interface{}) error {
+	f := toFilter(filter)
+	if f == nil {
+		return fmt.Errorf("%w: unsupported filter %T", errInvalidParam, filter)
+	}
+	if len(f.Domains) == 0 {
+		return a.LoadPolicy(m)
+	}
+	if err := a.load(m, f.Domains); err != nil {
+		return err
+	}
+	a.filtered = true
+	return nil
+}
+
+func (a *RedisAdapter) IsFiltered() bool {
+	return a.filtered
+}
+
+func (a *RedisAdapter) load(m model.Model, domains []string) error {
+	for start := 0; start < len(domains); start += _defaultBatchSize {
+		end := start + _defaultBatchSize
+		if end > len(domains) {
+			end = len(domains)
+		}
+		// each batch gets its own timeout, loading many domains may take longer in total.
+		ctx, cancel := utils.WithTimeout(context.Background(), 0)
+		pipe := a.client.Pipeline()
+		cmds := make([]*redis.StringSliceCmd, 0, end-start)
+		for _, d := range domains[start:end] {
+			cmds = append(cmds, pipe.SMembers(ctx, a.domainKey(d)))
+		}
+		_, err := pipe.Exec(ctx)
+		cancel()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("load casbin rules %w", err)
+		}
+		for _, cmd := range cmds {
+			for _, member := range cmd.Val() {
+				var line []string
+				if err := json.Unmarshal([]byte(member), &line); err != nil {
+					return fmt.Errorf("decode casbin rule %w", err)
+				}
+				loadLine(line, m)
+			}
+		}
+	}
+	return nil
+}
+
+// SavePolicy replaces all stored policies with the policies of m.
+func (a *RedisAdapter) SavePolicy(m model.Model) error {
+	if a.filtered {
+		return errors.New("cannot save a filtered policy")
+	}
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	domains, err := a.client.SMembers(ctx, a.domainsKey()).Result()
+	if err != nil {
+		return fmt.Errorf("load casbin domains %w", err)
+	}
+	_, err = a.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		keys := []string{a.domainsKey()}
+		for _, d := range domains {
+			keys = append(keys, a.domainKey(d))
+		}
+		pipe.Del(ctx, keys...)
+		for _, line := range modelRules(m) {
+			if err := a.add(ctx, pipe, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("save casbin rules %w", err)
+	}
+	return nil
+}
+
+func (a *RedisAdapter) add(ctx context.Context, pipe redis.Pipeliner, line []string) error {
+	member, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("encode casbin rule %w", err)
+	}
+	domain := domainOf(line[0], line[1:])
+	pipe.SAdd(ctx, a.domainsKey(), domain)
+	pipe.SAdd(ctx, a.domainKey(domain), member)
+	return nil
+}
+
+func (a *RedisAdapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return a.AddPolicies(sec, ptype, [][]string{rule})
+}
+
+func (a *RedisAdapter) AddPolicies(sec string, ptype string, rules [][]string) error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, err := a.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, rule := range rules {
+			if err := a.add(ctx, pipe, append([]string{ptype}, rule...)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("add casbin rules %w", err)
+	}
+	return nil
+}
+
+func (a *RedisAdapter) RemovePolicy(sec string, ptype string, rule []string) error {
+	return a.RemovePolicies(sec, ptype, [][]string{rule})
+}
+
+func (a *RedisAdapter) RemovePolicies(sec string, ptype string, rules [][]string) error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	_, err := a.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, rule := range rules {
+			member, err := json.Marshal(append([]string{ptype}, rule...))
+			if err != nil {
+				return fmt.Errorf("encode casbin rule %w", err)
+			}
+			pipe.SRem(ctx, a.domainKey(domainOf(ptype, rule)), member)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("remove casbin rules %w", err)
+	}
+	return nil
+}
+
+// RemoveFilteredPolicy scans the domain of the filter when it sets the domain field, all domains otherwise.
+func (a *RedisAdapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	var domains []string
+	if i := domainField(ptype) - fieldIndex; i >= 0 && i < len(fieldValues) && fieldValues[i] != "" {
+		domains = []string{fieldValues[i]}
+	} else {
+		var err error
+		if domains, err = a.client.SMembers(ctx, a.domainsKey()).Result(); err != nil {
+			return fmt.Errorf("load casbin domains %w", err)
+		}
+	}
+	for _, d := range domains {
+		members, err := a.client.SMembers(ctx, a.domainKey(d)).Result()
+		if err != nil {
+			return fmt.Errorf("load casbin rules %w", err)
+		}
+		var removed []interface{}
+		for _, member := range members {
+			var line []string
+			if err = json.Unmarshal([]byte(member), &line); err != nil {
+				return fmt.Errorf("decode casbin rule %w", err)
+			}
+			if line[0] == ptype && matchesFilteredRule(line[1:], fieldIndex, fieldValues...) {
+				removed = append(removed, member)
+			}
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		if err = a.client.SRem(ctx, a.domainKey(d), removed...).Err(); err != nil {
+			return fmt.Errorf("remove casbin rules %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	_defaultWatcherChannel = "casbin:policy"
+
+	// WatcherMethodResync asks subscribers to reload the whole policy.
+	WatcherMethodResync = "Resync"
+)
+
+var (
+	_ persist.Watcher   = &RedisWatcher{}
+	_ persist.WatcherEx = &RedisWatcher{}
+)
+
+// WatcherConfig of the RedisWatcher.
+type WatcherConfig struct {
+	// Channel the pub/sub channel. Default to casbin:policy.
+	Channel string `mapstructure:"channel" json:"channel" yaml:"channel"`
+	// ResyncInterval interval of full reloads catching up with lost messages, 0 disables them.
+	ResyncInterval time.Duration `mapstructure:"resync_interval" json:"resync_interval" yaml:"resyncInterval"`
+	// Logger receives the entries of the watcher. Default to the global logger.
+	Logger log.Logger `mapstructure:"-" json:"-" yaml:"-"`
+}
+
+// WatcherMessage a policy change published to the other instances.
+type WatcherMessage struct {
+	// ID the instance that made the change.
+	ID          string     `json:"id"`
+	Method      string     `json:"method"`
+	Sec         string     `json:"sec,omitempty"`
+	PType       string     `json:"ptype,omitempty"`
+	Rules       [][]string `json:"rules,omitempty"`
+	FieldIndex  int        `json:"field_index,omitempty"`
+	FieldValues []string   `json:"field_values,omitempty"`
+}
+
+// RedisWatcher propagates policy changes between instances with redis pub/sub. Changes of the
+// other instances invoke the update callback, which enforcers set to reload their policy.
+// The policy is also reloaded after the subscription reconnects, since messages may have been
+// lost, and every ResyncInterval.
+type RedisWatcher struct {
+	client redis.UniversalClient
+	conf   WatcherConfig
+	id     string
+	pubsub *redis.PubSub
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	lock     sync.RWMutex
+	callback func(string)
+	logger   *log.Helper
+}
+
+// NewRedisWatcher subscribes to the channel, pass it to SetWatcher of the enforcer.
+func NewRedisWatcher(client redis.UniversalClient, conf WatcherConfig) (*RedisWatcher, error) {
+	if conf.Channel == "" {
+		conf.Channel = _defaultWatcherChannel
+	}
+	id, err := utils.RandBase64String(12)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pubsub := client.Subscribe(ctx, conf.Channel)
+	// wait for the confirmation so no change published after the constructor returns is missed.
+	if _, err = pubsub.Receive(ctx); err != nil {
+		cancel()
+		pubsub.Close()
+		return nil, fmt.Errorf("subscribe %s %w", conf.Channel, err)
+	}
+	w := &RedisWatcher{
+		client: client,
+		conf:   conf,
+		id:     id,
+		pubsub: pubsub,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		logger: log.NewHelper(conf.Logger),
+	}
+	go w.run(ctx)
+	return w, nil
+}
+
+func (w *RedisWatcher) run(ctx context.Context) {
+	defer close(w.done)
+	var resync <-chan time.Time
+	if w.conf.ResyncInterval > 0 {
+		ticker := time.NewTicker(w.conf.ResyncInterval)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+	received := make(chan interface{})
+	go func() {
+		defer close(received)
+		for {
+			msg, err := w.pubsub.Receive(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				// go-redis reconnects on the next Receive.
+				w.logger.Warnf("casbin watcher receive %s", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			select {
+			case received <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-resync:
+			w.notify(w.encode(&WatcherMessage{Method: WatcherMethodResync}))
+		case msg, ok := <-received:
+			if !ok {
+				return
+			}
+			switch m := msg.(type) {
+			case *redis.Subscription:
+				// resubscribed after a reconnect.
+				if m.Kind == "subscribe" {
+					w.notify(w.encode(&WatcherMessage{Method: WatcherMethodResync}))
+				}
+			case *redis.Message:
+				message := &WatcherMessage{}
+				if err := json.Unmarshal([]byte(m.Payload), message); err != nil {
+					w.logger.Warnf("casbin watcher decode message %s", err)
+					continue
+				}
+				if message.ID != w.id {
+					w.notify(m.Payload)
+				}
+			}
+		}
+	}
+}
+
+func (w *RedisWatcher) notify(msg string) {
+	w.lock.RLock()
+	callback := w.callback
+	w.lock.RUnlock()
+	if callback != nil {
+		callback(msg)
+	}
+}
+
+func (w *RedisWatcher) encode(m *WatcherMessage) string {
+	m.ID = w.id
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+func (w *RedisWatcher) publish(m *WatcherMessage) error {
+	if err := w.client.Publish(context.Background(), w.conf.Channel, w.encode(m)).Err(); err != nil {
+		return fmt.Errorf("publish policy change %w", err)
+	}
+	return nil
+}
+
+// SetUpdateCallback sets the function called with the WatcherMessage json of every change
+// made by another instance.
+func (w *RedisWatcher) SetUpdateCallback(callback func(string)) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.callback = callback
+	return nil
+}
+
+func (w *RedisWatcher) Update() error {
+	return w.publish(&WatcherMessage{Method: "Update"})
+}
+
+func (w *RedisWatcher) UpdateForAddPolicy(sec, ptype string, params ...string) error {
+	return w.publish(&WatcherMessage{Method: "UpdateForAddPolicy", Sec: sec, PType: ptype, Rules: [][]string{params}})
+}
+
+func (w *RedisWatcher) UpdateForRemovePolicy(sec, ptype string, params ...string) error {
+	return w.publish(&WatcherMessage{Method: "UpdateForRemovePolicy", Sec: sec, PType: ptype, Rules: [][]string{params}})
+}
+
+func (w *RedisWatcher) UpdateForRemoveFilteredPolicy(sec, ptype string, fieldIndex int, fieldValues ...string) error {
+	return w.publish(&WatcherMessage{Method: "UpdateForRemoveFilteredPolicy", Sec: sec, PType: ptype,
+		FieldIndex: fieldIndex, FieldValues: fieldValues})
+}
+
+func (w *RedisWatcher) UpdateForSavePolicy(model model.Model) error {
+	return w.publish(&WatcherMessage{Method: "UpdateForSavePolicy"})
+}
+
+func (w *RedisWatcher) UpdateForAddPolicies(sec string, ptype string, rules ...[]string) error {
+	return w.publish(&WatcherMessage{Method: "UpdateForAddPolicies", Sec: sec, PType: ptype, Rules: rules})
+}
+
+func (w *RedisWatcher) UpdateForRemovePolicies(sec string, ptype string, rules ...[]string) error {
+	return w.publish(&WatcherMessage{Method: "UpdateForRemovePolicies", Sec: sec, PType: ptype, Rules: rules})
+}
+
+// Close unsubscribes and stops notifying.
+func (w *RedisWatcher) Close() {
+	w.cancel()
+	w.pubsub.Close()
+	<-w.done
+}
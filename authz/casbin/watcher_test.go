@@ -0,0 +1,123 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package casbin
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisWatcher(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	adapter := NewRedisAdapter(client, "")
+
+	e0, err := NewEnforcer(adapter)
+	assert.NoError(t, err)
+	e1, err := NewEnforcer(adapter)
+	assert.NoError(t, err)
+	w0, err := NewRedisWatcher(client, WatcherConfig{})
+	assert.NoError(t, err)
+	defer w0.Close()
+	w1, err := NewRedisWatcher(client, WatcherConfig{})
+	assert.NoError(t, err)
+	defer w1.Close()
+	assert.NoError(t, e0.SetWatcher(w0))
+	assert.NoError(t, e1.SetWatcher(w1))
+
+	_, err = e0.AddPolicy("admin", "t1", "*", "*")
+	assert.NoError(t, err)
+	_, err = e0.AddGroupingPolicy("alice", "admin", "t1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		ok, err := e1.Enforce("alice", "t1", "device", "write")
+		return err == nil && ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	_, err = e1.RemoveFilteredGroupingPolicy(1, "admin", "t1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		ok, err := e0.Enforce("alice", "t1", "device", "write")
+		return err == nil && !ok
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestCompiledCheckerWatcher(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	adapter := NewRedisAdapter(client, "")
+
+	e0, err := NewEnforcer(adapter)
+	assert.NoError(t, err)
+	e1, err := NewEnforcer(adapter)
+	assert.NoError(t, err)
+	w0, err := NewRedisWatcher(client, WatcherConfig{})
+	assert.NoError(t, err)
+	defer w0.Close()
+	w1, err := NewRedisWatcher(client, WatcherConfig{})
+	assert.NoError(t, err)
+	defer w1.Close()
+	assert.NoError(t, e0.SetWatcher(w0))
+	c1, err := NewCompiledChecker(e1)
+	assert.NoError(t, err)
+	assert.NoError(t, c1.SetWatcher(w1))
+
+	_, err = e0.AddPolicy("admin", "t1", "*", "*")
+	assert.NoError(t, err)
+	_, err = e0.AddGroupingPolicy("alice", "admin", "t1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		ok, err := c1.Check("alice", "t1", "device", "write")
+		return err == nil && ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	_, err = e0.RemoveGroupingPolicy("alice", "admin", "t1")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		ok, err := c1.Check("alice", "t1", "device", "write")
+		return err == nil && !ok
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestRedisWatcherResync(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	w, err := NewRedisWatcher(client, WatcherConfig{ResyncInterval: 20 * time.Millisecond})
+	assert.NoError(t, err)
+	var resyncs int32
+	assert.NoError(t, w.SetUpdateCallback(func(msg string) {
+		m := &WatcherMessage{}
+		assert.NoError(t, json.Unmarshal([]byte(msg), m))
+		if m.Method == WatcherMethodResync {
+			atomic.AddInt32(&resyncs, 1)
+		}
+	}))
+	// own changes don't notify.
+	assert.NoError(t, w.Update())
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&resyncs) >= 2 }, 2*time.Second, 10*time.Millisecond)
+	w.Close()
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package opa
+
+import (
+	"time"
+
+	"github.com/tkeel-io/security/log"
+)
+
+var _ DecisionLogger = LogDecisionLogger{}
+
+// Decision a logged policy decision.
+type Decision struct {
+	// ID the decision id, assigned by the OPA server for remote evaluation.
+	ID       string        `json:"decision_id"`
+	Time     time.Time     `json:"timestamp"`
+	Query    string        `json:"query"`
+	Input    *Input        `json:"input"`
+	Allowed  bool          `json:"result"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// DecisionLogger receives every decision, e.g. to ship them to an audit store.
+// Log is called synchronously and must not block.
+type DecisionLogger interface {
+	Log(d *Decision)
+}
+
+// LogDecisionLogger writes decisions to the process log.
+type LogDecisionLogger struct{}
+
+func (LogDecisionLogger) Log(d *Decision) {
+	if d.Error != "" {
+		log.Warnf("opa decision %s %s subject=%s tenant=%s resource=%s action=%s error=%s",
+			d.ID, d.Query, d.Input.Subject, d.Input.TenantID, d.Input.Resource, d.Input.Action, d.Error)
+		return
+	}
+	log.Infof("opa decision %s %s subject=%s tenant=%s resource=%s action=%s allowed=%t in %s",
+		d.ID, d.Query, d.Input.Subject, d.Input.TenantID, d.Input.Resource, d.Input.Action, d.Allowed, d.Duration)
+}
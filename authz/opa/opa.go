@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package opa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+const _defaultQuery = "data.tkeel.authz.allow"
+
+var (
+	_ authorizer.Checker = &Engine{}
+
+	// ErrNoPolicy neither modules nor bundles are configured.
+	ErrNoPolicy = errors.New("opa: no policy configured")
+	// ErrUndefinedDecision the query is undefined or not a bool for the input.
+	ErrUndefinedDecision = errors.New("opa: undefined decision")
+)
+
+// Config of the embedded evaluation.
+type Config struct {
+	// Query the rule deciding the request. Default to data.tkeel.authz.allow.
+	Query string `mapstructure:"query" json:"query" yaml:"query"`
+	// Modules rego sources by file name.
+	Modules map[string]string `mapstructure:"modules" json:"modules" yaml:"modules"`
+	// Bundles paths of bundle directories or .tar.gz files.
+	Bundles []string `mapstructure:"bundles" json:"bundles" yaml:"bundles"`
+}
+
+// Input the document policies see as input.
+type Input struct {
+	Subject  string `json:"subject"`
+	TenantID string `json:"tenant_id"`
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+	// Attributes e.g. token claims or resource properties.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Engine evaluates requests against embedded rego policies.
+type Engine struct {
+	conf   Config
+	logger DecisionLogger
+
+	lock     sync.RWMutex
+	prepared rego.PreparedEvalQuery
+}
+
+// NewEngine compiles the configured policies, logger may be nil.
+func NewEngine(ctx context.Context, conf Config, logger DecisionLogger) (*Engine, error) {
+	if conf.Query == "" {
+		conf.Query = _defaultQuery
+	}
+	if len(conf.Modules) == 0 && len(conf.Bundles) == 0 {
+		return nil, ErrNoPolicy
+	}
+	e := &Engine{conf: conf, logger: logger}
+	if err := e.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload recompiles the modules and reloads the bundles from disk, the previous
+// policies stay in effect when that fails.
+func (e *Engine) Reload(ctx context.Context) error {
+	opts := []func(*rego.Rego){rego.Query(e.conf.Query)}
+	for name, src := range e.conf.Modules {
+		opts = append(opts, rego.Module(name, src))
+	}
+	for _, path := range e.conf.Bundles {
+		opts = append(opts, rego.LoadBundle(path))
+	}
+	prepared, err := rego.New(opts...).PrepareForEval(ctx)
+	if err != nil {
+		return fmt.Errorf("opa: prepare query %w", err)
+	}
+	e.lock.Lock()
+	e.prepared = prepared
+	e.lock.Unlock()
+	return nil
+}
+
+func (e *Engine) Check(subject, tenantID, resource, action string) (bool, error) {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	return e.Evaluate(ctx, &Input{Subject: subject, TenantID: tenantID, Resource: resource, Action: action})
+}
+
+// Evaluate returns the decision of the query for input.
+func (e *Engine) Evaluate(ctx context.Context, input *Input) (allowed bool, err error) {
+	start := time.Now()
+	defer func() { logDecision(e.logger, e.conf.Query, input, allowed, err, start) }()
+
+	e.lock.RLock()
+	prepared := e.prepared
+	e.lock.RUnlock()
+	rs, err := prepared.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return false, fmt.Errorf("opa: eval %w", err)
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return false, ErrUndefinedDecision
+	}
+	allowed, ok := rs[0].Expressions[0].Value.(bool)
+	if !ok {
+		return false, ErrUndefinedDecision
+	}
+	return allowed, nil
+}
+
+func logDecision(logger DecisionLogger, query string, input *Input, allowed bool, err error, start time.Time) {
+	if logger == nil {
+		return
+	}
+	id, _ := utils.RandBase64String(12)
+	d := &Decision{ID: id, Time: start, Query: query, Input: input, Allowed: allowed, Duration: time.Since(start)}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	logger.Log(d)
+}
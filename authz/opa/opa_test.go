@@ -0,0 +1,117 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const _policy = `package tkeel.authz
+
+default allow = false
+
+allow {
+	input.action == "read"
+	input.tenant_id == data.tenants[_]
+}
+
+allow {
+	input.subject == "admin"
+}
+`
+
+type recorder struct {
+	lock      sync.Mutex
+	decisions []*Decision
+}
+
+func (r *recorder) Log(d *Decision) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.decisions = append(r.decisions, d)
+}
+
+func TestEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "policy.rego"), []byte(_policy), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data.json"), []byte(`{"tenants":["t1"]}`), 0600))
+
+	logs := &recorder{}
+	e, err := NewEngine(context.Background(), Config{Bundles: []string{dir}}, logs)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		subject, tenant, action string
+		allowed                 bool
+	}{
+		{"alice", "t1", "read", true},
+		{"alice", "t2", "read", false},
+		{"alice", "t1", "write", false},
+		{"admin", "t2", "write", true},
+	}
+	for _, tt := range tests {
+		ok, err := e.Check(tt.subject, tt.tenant, "device", tt.action)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.allowed, ok, "%+v", tt)
+	}
+	assert.Len(t, logs.decisions, len(tests))
+	assert.Equal(t, "alice", logs.decisions[0].Input.Subject)
+	assert.NotEmpty(t, logs.decisions[0].ID)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "data.json"), []byte(`{"tenants":["t1","t2"]}`), 0600))
+	assert.NoError(t, e.Reload(context.Background()))
+	ok, err := e.Check("alice", "t2", "device", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = NewEngine(context.Background(), Config{}, nil)
+	assert.ErrorIs(t, err, ErrNoPolicy)
+	e, err = NewEngine(context.Background(), Config{Query: "data.tkeel.authz.missing", Modules: map[string]string{"p.rego": _policy}}, nil)
+	assert.NoError(t, err)
+	_, err = e.Check("admin", "t1", "device", "read")
+	assert.ErrorIs(t, err, ErrUndefinedDecision)
+}
+
+func TestRemote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/data/tkeel/authz/allow", r.URL.Path)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var body struct{ Input Input }
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"decision_id": "d1", "result": body.Input.Subject == "admin"})
+	}))
+	defer srv.Close()
+
+	logs := &recorder{}
+	r := NewRemote(RemoteConfig{URL: srv.URL, Token: "secret"}, logs)
+	ok, err := r.Check("admin", "t1", "device", "write")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = r.Check("alice", "t1", "device", "write")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "d1", logs.decisions[0].ID)
+}
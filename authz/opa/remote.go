@@ -0,0 +1,117 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package opa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authz/authorizer"
+)
+
+var _ authorizer.Checker = &Remote{}
+
+// RemoteConfig of an OPA server queried over its REST API.
+type RemoteConfig struct {
+	// URL of the server, e.g. http://localhost:8181.
+	URL string `mapstructure:"url" json:"url" yaml:"url"`
+	// Path of the decision document. Default to tkeel/authz/allow.
+	Path string `mapstructure:"path" json:"path" yaml:"path"`
+	// Token bearer token of the server, if it requires authentication.
+	Token   string        `mapstructure:"token" json:"token" yaml:"token"`
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout" yaml:"timeout"`
+}
+
+// Remote evaluates requests with a remote OPA server, the server manages policies and bundles.
+type Remote struct {
+	conf   RemoteConfig
+	client *http.Client
+	logger DecisionLogger
+}
+
+// NewRemote returns a Remote, logger may be nil.
+func NewRemote(conf RemoteConfig, logger DecisionLogger) *Remote {
+	if conf.Path == "" {
+		conf.Path = strings.ReplaceAll(strings.TrimPrefix(_defaultQuery, "data."), ".", "/")
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 5 * time.Second
+	}
+	return &Remote{conf: conf, client: &http.Client{Timeout: conf.Timeout}, logger: logger}
+}
+
+func (r *Remote) Check(subject, tenantID, resource, action string) (bool, error) {
+	return r.Evaluate(context.Background(), &Input{Subject: subject, TenantID: tenantID, Resource: resource, Action: action})
+}
+
+// Evaluate queries the decision document for input.
+func (r *Remote) Evaluate(ctx context.Context, input *Input) (bool, error) {
+	start := time.Now()
+	resp, err := r.query(ctx, input)
+	allowed, ok := resp.Result.(bool)
+	if err == nil && !ok {
+		err = ErrUndefinedDecision
+	}
+	if r.logger != nil {
+		d := &Decision{ID: resp.DecisionID, Time: start, Query: r.conf.Path, Input: input,
+			Allowed: allowed, Duration: time.Since(start)}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		r.logger.Log(d)
+	}
+	if err != nil {
+		return false, err
+	}
+	return allowed, nil
+}
+
+type dataResponse struct {
+	DecisionID string      `json:"decision_id"`
+	Result     interface{} `json:"result"`
+}
+
+func (r *Remote) query(ctx context.Context, input *Input) (*dataResponse, error) {
+	out := &dataResponse{}
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return out, fmt.Errorf("opa: marshal input %w", err)
+	}
+	url := strings.TrimSuffix(r.conf.URL, "/") + "/v1/data/" + strings.TrimPrefix(r.conf.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return out, fmt.Errorf("opa: new request %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.conf.Token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return out, fmt.Errorf("opa: query %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return out, fmt.Errorf("opa: query %s returned %d", url, resp.StatusCode)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return out, fmt.Errorf("opa: decode response %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"fmt"
+
+	"github.com/tkeel-io/security/authz/casbin"
+)
+
+var _ BatchChecker = &RoleOperator{}
+
+// BatchChecker checks many requests of a subject in one call, e.g. to authorize the items of a list.
+type BatchChecker interface {
+	// CheckBatch returns the decision of every request, in order.
+	CheckBatch(subject, tenantID string, requests []Permission) ([]bool, error)
+}
+
+func (o *RoleOperator) CheckBatch(subject, tenantID string, requests []Permission) ([]bool, error) {
+	if subject == "" || tenantID == "" {
+		return nil, ErrInvalidParam
+	}
+	rvals := make([][]interface{}, 0, len(requests))
+	for _, r := range requests {
+		if r.Resource == "" || r.Action == "" {
+			return nil, ErrInvalidParam
+		}
+		rvals = append(rvals, []interface{}{subject, tenantID, r.Resource, r.Action})
+	}
+	decisions, err := casbin.DecideBatch(o.RBACOperator, rvals)
+	if err != nil {
+		return nil, fmt.Errorf("batch enforce %w", err)
+	}
+	return decisions, nil
+}
+
+// FilterAllowed returns the resources subject may perform action on, keeping their order.
+func FilterAllowed(checker BatchChecker, subject, tenantID, action string, resources []string) ([]string, error) {
+	requests := make([]Permission, 0, len(resources))
+	for _, r := range resources {
+		requests = append(requests, Permission{Resource: r, Action: action})
+	}
+	decisions, err := checker.CheckBatch(subject, tenantID, requests)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make([]string, 0, len(resources))
+	for i, ok := range decisions {
+		if ok {
+			allowed = append(allowed, resources[i])
+		}
+	}
+	return allowed, nil
+}
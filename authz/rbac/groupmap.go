@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+)
+
+// GroupMappingRule binds the members of an IdP group to a role in a tenant.
+type GroupMappingRule struct {
+	// Provider key of the identity provider the rule applies to, empty applies to all.
+	Provider string `mapstructure:"provider" json:"provider,omitempty" yaml:"provider"`
+	// Group name of the IdP group, may be a glob like "iot-*".
+	Group    string `mapstructure:"group" json:"group" yaml:"group"`
+	TenantID string `mapstructure:"tenant_id" json:"tenant_id" yaml:"tenantID"`
+	Role     string `mapstructure:"role" json:"role" yaml:"role"`
+}
+
+func (r *GroupMappingRule) matches(groups []string) bool {
+	for _, g := range groups {
+		if ok, err := path.Match(r.Group, g); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TenantRole a role in a tenant.
+type TenantRole struct {
+	TenantID string `json:"tenant_id"`
+	Role     string `json:"role"`
+}
+
+// GroupMapping the outcome of a sync.
+type GroupMapping struct {
+	// Bound the managed roles the subject holds.
+	Bound []TenantRole `json:"bound,omitempty"`
+	// Unbound the managed roles the subject no longer holds, if it ever did.
+	Unbound []TenantRole `json:"unbound,omitempty"`
+}
+
+// GroupMapper keeps the role bindings of federated users in line with their IdP groups.
+// It only manages the roles its rules map to, bindings assigned otherwise are left alone.
+type GroupMapper struct {
+	roles RoleMgr
+	rules []GroupMappingRule
+}
+
+func NewGroupMapper(roles RoleMgr, rules []GroupMappingRule) (*GroupMapper, error) {
+	for _, r := range rules {
+		if r.Group == "" || r.TenantID == "" || r.Role == "" {
+			return nil, fmt.Errorf("%w: group mapping %+v", ErrInvalidParam, r)
+		}
+		if _, err := path.Match(r.Group, ""); err != nil {
+			return nil, fmt.Errorf("%w: group pattern %s", ErrInvalidParam, r.Group)
+		}
+	}
+	return &GroupMapper{roles: roles, rules: rules}, nil
+}
+
+// Sync binds subject, authenticated by provider, to the roles its groups map to and removes the
+// managed bindings its groups no longer map to. Call it on login, e.g. from an IdentityMapper.
+func (m *GroupMapper) Sync(provider, subject string, groups []string) (*GroupMapping, error) {
+	if subject == "" {
+		return nil, ErrInvalidParam
+	}
+	desired := make(map[TenantRole]bool)
+	managed := make([]TenantRole, 0, len(m.rules))
+	for i := range m.rules {
+		r := &m.rules[i]
+		if r.Provider != "" && r.Provider != provider {
+			continue
+		}
+		b := TenantRole{TenantID: r.TenantID, Role: r.Role}
+		managed = append(managed, b)
+		if r.matches(groups) {
+			desired[b] = true
+		}
+	}
+
+	result := &GroupMapping{}
+	done := make(map[TenantRole]bool, len(managed))
+	for _, b := range managed {
+		if done[b] {
+			continue
+		}
+		done[b] = true
+		if desired[b] {
+			if err := m.roles.AssignRole(b.TenantID, subject, b.Role); err != nil {
+				return result, err
+			}
+			result.Bound = append(result.Bound, b)
+			continue
+		}
+		if err := m.roles.UnassignRole(b.TenantID, subject, b.Role); err != nil {
+			return result, err
+		}
+		result.Unbound = append(result.Unbound, b)
+	}
+	return result, nil
+}
+
+// SyncIdentity is Sync with the user id and the groups of identity.
+func (m *GroupMapper) SyncIdentity(provider string, identity idprovider.Identity) (*GroupMapping, error) {
+	return m.Sync(provider, identity.GetUserID(), idprovider.Groups(identity))
+}
@@ -12,3 +12,360 @@ limitations under the License.
 */
 
 package rbac
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tkeel-io/security/authz/authorizer"
+	rbaccasbin "github.com/tkeel-io/security/authz/casbin"
+
+	"github.com/casbin/casbin/v2"
+)
+
+// GroupPrefix prefixes group subjects so they don't collide with roles and users.
+const GroupPrefix = "group:"
+
+var (
+	_ RoleMgr              = &RoleOperator{}
+	_ authorizer.Checker   = &RoleOperator{}
+	_ authorizer.Explainer = &RoleOperator{}
+
+	// ErrInvalidParam a tenant, role, subject or permission is empty.
+	ErrInvalidParam = errors.New("invalid rbac param")
+	// ErrRoleExists the role already holds permissions in the tenant.
+	ErrRoleExists = errors.New("role already exists")
+	// ErrRoleCycle the inheritance would make a role inherit itself.
+	ErrRoleCycle = errors.New("role inheritance cycle")
+)
+
+// Permission an action on a resource. Resources may be patterns like "devices/*", "spaces/floor1/**"
+// or "/v1/devices/{id}" and actions alternatives like "GET|HEAD", see casbin.ResourceMatch.
+type Permission struct {
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+}
+
+// RoleMgr manages roles, their permissions and bindings per tenant. Role metadata
+// (display names, descriptions) lives in model.Role, the manager only holds the policies.
+type RoleMgr interface {
+	CreateRole(tenantID, role string, permissions ...Permission) error
+	DeleteRole(tenantID, role string) error
+	GrantPermissions(tenantID, role string, permissions ...Permission) error
+	RevokePermissions(tenantID, role string, permissions ...Permission) error
+	RolePermissions(tenantID, role string) []Permission
+	// Deny denies subject, a user, group or role, the permissions whatever its roles grant.
+	Deny(tenantID, subject string, permissions ...Permission) error
+	RemoveDeny(tenantID, subject string, permissions ...Permission) error
+	// Denies returns the permissions denied to subject itself, not to its groups or roles.
+	Denies(tenantID, subject string) []Permission
+	// AssignRole binds a user or a group (see Group) to role in the tenant.
+	AssignRole(tenantID, subject, role string) error
+	UnassignRole(tenantID, subject, role string) error
+	AddGroupMember(tenantID, group, user string) error
+	RemoveGroupMember(tenantID, group, user string) error
+	// InheritRole makes role inherit the permissions of parent, e.g. admin inherits operator.
+	InheritRole(tenantID, role, parent string) error
+	DisinheritRole(tenantID, role, parent string) error
+	// SubjectRoles returns the roles of subject in the tenant, including the roles of its groups
+	// and the inherited roles.
+	SubjectRoles(tenantID, subject string) ([]string, error)
+	// EffectivePermissions returns the permissions of subject in the tenant, including the
+	// permissions granted through groups and inherited roles.
+	EffectivePermissions(tenantID, subject string) ([]Permission, error)
+	Check(subject, tenantID, resource, action string) (bool, error)
+	BatchChecker
+	// Export returns the role and permission set of the tenant.
+	Export(tenantID string) (*TenantPolicy, error)
+	// Import replaces the role and permission set of the tenant, see RoleOperator.Import.
+	Import(tenantID string, tp *TenantPolicy, dryRun bool) (*PolicyDiff, error)
+}
+
+// Group returns the subject of group.
+func Group(group string) string {
+	return GroupPrefix + group
+}
+
+// RoleOperator RoleMgr on a casbin enforcer with the domain model of the casbin package.
+type RoleOperator struct {
+	RBACOperator *casbin.SyncedEnforcer
+	compiled     *rbaccasbin.CompiledChecker
+}
+
+// RoleOperatorOption configures the RoleOperator created by NewRoleOperator.
+type RoleOperatorOption func(o *RoleOperator)
+
+// WithCompiledChecker rebuilds compiled, a checker of the same enforcer, after every change made
+// through the operator, so revoked permissions stop being allowed at once.
+func WithCompiledChecker(compiled *rbaccasbin.CompiledChecker) RoleOperatorOption {
+	return func(o *RoleOperator) {
+		o.compiled = compiled
+	}
+}
+
+func NewRoleOperator(opt *casbin.SyncedEnforcer, opts ...RoleOperatorOption) RoleMgr {
+	o := &RoleOperator{RBACOperator: opt}
+	for _, option := range opts {
+		option(o)
+	}
+	return o
+}
+
+// rebuild compiles the policies after a change, also a partly failed one, into the checker of
+// WithCompiledChecker.
+func (o *RoleOperator) rebuild(err *error) {
+	if o.compiled == nil {
+		return
+	}
+	if rerr := o.compiled.Rebuild(); rerr != nil && *err == nil {
+		*err = fmt.Errorf("rebuild compiled checker %w", rerr)
+	}
+}
+
+func (o *RoleOperator) CreateRole(tenantID, role string, permissions ...Permission) error {
+	if tenantID == "" || role == "" {
+		return ErrInvalidParam
+	}
+	if len(o.RBACOperator.GetFilteredPolicy(0, role, tenantID)) > 0 {
+		return ErrRoleExists
+	}
+	return o.GrantPermissions(tenantID, role, permissions...)
+}
+
+// DeleteRole removes the permissions of role, its bindings and its inheritance in the tenant.
+func (o *RoleOperator) DeleteRole(tenantID, role string) (err error) {
+	if tenantID == "" || role == "" {
+		return ErrInvalidParam
+	}
+	defer o.rebuild(&err)
+	if _, err := o.RBACOperator.RemoveFilteredPolicy(0, role, tenantID); err != nil {
+		return fmt.Errorf("remove role policies %w", err)
+	}
+	if _, err := o.RBACOperator.RemoveFilteredGroupingPolicy(1, role, tenantID); err != nil {
+		return fmt.Errorf("remove role bindings %w", err)
+	}
+	if _, err := o.RBACOperator.RemoveFilteredGroupingPolicy(0, role, "", tenantID); err != nil {
+		return fmt.Errorf("remove role parents %w", err)
+	}
+	if _, err := o.RBACOperator.RemoveFilteredNamedPolicy(rbaccasbin.DenyPolicyType, 0, role, tenantID); err != nil {
+		return fmt.Errorf("remove role denies %w", err)
+	}
+	return nil
+}
+
+// RevokeTenant removes all policies, denies and bindings of the tenant, e.g. when it is offboarded.
+func (o *RoleOperator) RevokeTenant(tenantID string) (err error) {
+	if tenantID == "" {
+		return ErrInvalidParam
+	}
+	defer o.rebuild(&err)
+	if _, err := o.RBACOperator.RemoveFilteredPolicy(1, tenantID); err != nil {
+		return fmt.Errorf("remove tenant policies %w", err)
+	}
+	if _, err := o.RBACOperator.RemoveFilteredNamedPolicy(rbaccasbin.DenyPolicyType, 1, tenantID); err != nil {
+		return fmt.Errorf("remove tenant denies %w", err)
+	}
+	if _, err := o.RBACOperator.RemoveFilteredGroupingPolicy(2, tenantID); err != nil {
+		return fmt.Errorf("remove tenant bindings %w", err)
+	}
+	return nil
+}
+
+func (o *RoleOperator) GrantPermissions(tenantID, role string, permissions ...Permission) (err error) {
+	rules, err := policies(tenantID, role, permissions)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	defer o.rebuild(&err)
+	for _, rule := range rules {
+		// AddPolicies is all or nothing, permissions already granted are skipped one by one.
+		if _, err = o.RBACOperator.AddPolicy(rule); err != nil {
+			return fmt.Errorf("add policy %w", err)
+		}
+	}
+	return nil
+}
+
+func (o *RoleOperator) RevokePermissions(tenantID, role string, permissions ...Permission) (err error) {
+	rules, err := policies(tenantID, role, permissions)
+	if err != nil {
+		return err
+	}
+	defer o.rebuild(&err)
+	for _, rule := range rules {
+		if _, err = o.RBACOperator.RemovePolicy(rule); err != nil {
+			return fmt.Errorf("remove policy %w", err)
+		}
+	}
+	return nil
+}
+
+func (o *RoleOperator) RolePermissions(tenantID, role string) []Permission {
+	rules := o.RBACOperator.GetFilteredPolicy(0, role, tenantID)
+	permissions := make([]Permission, 0, len(rules))
+	for _, rule := range rules {
+		permissions = append(permissions, Permission{Resource: rule[2], Action: rule[3]})
+	}
+	return permissions
+}
+
+func (o *RoleOperator) Deny(tenantID, subject string, permissions ...Permission) (err error) {
+	rules, err := policies(tenantID, subject, permissions)
+	if err != nil {
+		return err
+	}
+	defer o.rebuild(&err)
+	for _, rule := range rules {
+		if _, err = o.RBACOperator.AddNamedPolicy(rbaccasbin.DenyPolicyType, rule); err != nil {
+			return fmt.Errorf("add deny policy %w", err)
+		}
+	}
+	return nil
+}
+
+func (o *RoleOperator) RemoveDeny(tenantID, subject string, permissions ...Permission) (err error) {
+	rules, err := policies(tenantID, subject, permissions)
+	if err != nil {
+		return err
+	}
+	defer o.rebuild(&err)
+	for _, rule := range rules {
+		if _, err = o.RBACOperator.RemoveNamedPolicy(rbaccasbin.DenyPolicyType, rule); err != nil {
+			return fmt.Errorf("remove deny policy %w", err)
+		}
+	}
+	return nil
+}
+
+func (o *RoleOperator) Denies(tenantID, subject string) []Permission {
+	rules := o.RBACOperator.GetFilteredNamedPolicy(rbaccasbin.DenyPolicyType, 0, subject, tenantID)
+	permissions := make([]Permission, 0, len(rules))
+	for _, rule := range rules {
+		permissions = append(permissions, Permission{Resource: rule[2], Action: rule[3]})
+	}
+	return permissions
+}
+
+func (o *RoleOperator) AssignRole(tenantID, subject, role string) (err error) {
+	if tenantID == "" || subject == "" || role == "" {
+		return ErrInvalidParam
+	}
+	defer o.rebuild(&err)
+	if _, err := o.RBACOperator.AddGroupingPolicy(subject, role, tenantID); err != nil {
+		return fmt.Errorf("add grouping policy %w", err)
+	}
+	return nil
+}
+
+func (o *RoleOperator) UnassignRole(tenantID, subject, role string) (err error) {
+	if tenantID == "" || subject == "" || role == "" {
+		return ErrInvalidParam
+	}
+	defer o.rebuild(&err)
+	if _, err := o.RBACOperator.RemoveGroupingPolicy(subject, role, tenantID); err != nil {
+		return fmt.Errorf("remove grouping policy %w", err)
+	}
+	return nil
+}
+
+func (o *RoleOperator) AddGroupMember(tenantID, group, user string) error {
+	if group == "" {
+		return ErrInvalidParam
+	}
+	return o.AssignRole(tenantID, user, Group(group))
+}
+
+func (o *RoleOperator) RemoveGroupMember(tenantID, group, user string) error {
+	if group == "" {
+		return ErrInvalidParam
+	}
+	return o.UnassignRole(tenantID, user, Group(group))
+}
+
+func (o *RoleOperator) InheritRole(tenantID, role, parent string) error {
+	if tenantID == "" || role == "" || parent == "" {
+		return ErrInvalidParam
+	}
+	if role == parent {
+		return ErrRoleCycle
+	}
+	ancestors, err := o.RBACOperator.GetImplicitRolesForUser(parent, tenantID)
+	if err != nil {
+		return fmt.Errorf("get implicit roles %w", err)
+	}
+	for _, r := range ancestors {
+		if r == role {
+			return ErrRoleCycle
+		}
+	}
+	return o.AssignRole(tenantID, role, parent)
+}
+
+func (o *RoleOperator) DisinheritRole(tenantID, role, parent string) error {
+	return o.UnassignRole(tenantID, role, parent)
+}
+
+func (o *RoleOperator) SubjectRoles(tenantID, subject string) ([]string, error) {
+	implicit, err := o.RBACOperator.GetImplicitRolesForUser(subject, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("get implicit roles %w", err)
+	}
+	roles := make([]string, 0, len(implicit))
+	for _, role := range implicit {
+		if !strings.HasPrefix(role, GroupPrefix) {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+func (o *RoleOperator) EffectivePermissions(tenantID, subject string) ([]Permission, error) {
+	rules, err := o.RBACOperator.GetImplicitPermissionsForUser(subject, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("get implicit permissions %w", err)
+	}
+	seen := make(map[Permission]bool, len(rules))
+	permissions := make([]Permission, 0, len(rules))
+	for _, rule := range rules {
+		p := Permission{Resource: rule[2], Action: rule[3]}
+		if !seen[p] {
+			seen[p] = true
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions, nil
+}
+
+// Check decides with deny-override, a deny of the subject, its groups or roles wins.
+func (o *RoleOperator) Check(subject, tenantID, resource, action string) (bool, error) {
+	ok, _, err := o.Explain(subject, tenantID, resource, action)
+	return ok, err
+}
+
+// Explain is Check that also returns the deciding policy (subject, tenant, resource, action),
+// the deny policy when one matched.
+func (o *RoleOperator) Explain(subject, tenantID, resource, action string) (bool, []string, error) {
+	if subject == "" || tenantID == "" || resource == "" || action == "" {
+		return false, nil, ErrInvalidParam
+	}
+	ok, rule, err := rbaccasbin.Decide(o.RBACOperator, subject, tenantID, resource, action)
+	if err != nil {
+		return false, nil, fmt.Errorf("enforce %w", err)
+	}
+	return ok, rule, nil
+}
+
+func policies(tenantID, role string, permissions []Permission) ([][]string, error) {
+	if tenantID == "" || role == "" {
+		return nil, ErrInvalidParam
+	}
+	rules := make([][]string, 0, len(permissions))
+	for _, p := range permissions {
+		if p.Resource == "" || p.Action == "" {
+			return nil, ErrInvalidParam
+		}
+		rules = append(rules, []string{role, tenantID, p.Resource, p.Action})
+	}
+	return rules, nil
+}
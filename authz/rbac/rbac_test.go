@@ -0,0 +1,326 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/authz/casbin"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleOperator(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	mgr := NewRoleOperator(enforcer)
+
+	assert.NoError(t, mgr.CreateRole("t1", "admin", Permission{"*", "*"}))
+	assert.ErrorIs(t, mgr.CreateRole("t1", "admin"), ErrRoleExists)
+	assert.NoError(t, mgr.CreateRole("t1", "viewer", Permission{"device", "read"}))
+	assert.NoError(t, mgr.GrantPermissions("t1", "viewer", Permission{"device", "read"}, Permission{"plugin", "read"}))
+	assert.ElementsMatch(t, []Permission{{"device", "read"}, {"plugin", "read"}}, mgr.RolePermissions("t1", "viewer"))
+	assert.ErrorIs(t, mgr.GrantPermissions("t1", "viewer", Permission{Resource: "device"}), ErrInvalidParam)
+
+	assert.NoError(t, mgr.AssignRole("t1", "alice", "admin"))
+	assert.NoError(t, mgr.AddGroupMember("t1", "ops", "bob"))
+	assert.NoError(t, mgr.AssignRole("t1", Group("ops"), "viewer"))
+
+	tests := []struct {
+		subject, tenant, resource, action string
+		allowed                           bool
+	}{
+		{"alice", "t1", "device", "delete", true},
+		{"alice", "t2", "device", "read", false},
+		{"bob", "t1", "device", "read", true},
+		{"bob", "t1", "device", "write", false},
+		{"carol", "t1", "device", "read", false},
+	}
+	for _, tt := range tests {
+		ok, err := mgr.Check(tt.subject, tt.tenant, tt.resource, tt.action)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.allowed, ok, "%+v", tt)
+	}
+
+	roles, err := mgr.SubjectRoles("t1", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"viewer"}, roles)
+
+	assert.NoError(t, mgr.RemoveGroupMember("t1", "ops", "bob"))
+	ok, err := mgr.Check("bob", "t1", "device", "read")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, mgr.DeleteRole("t1", "admin"))
+	ok, err = mgr.Check("alice", "t1", "device", "delete")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	roles, err = mgr.SubjectRoles("t1", "alice")
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+}
+
+func TestRoleOperatorCompiledChecker(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	compiled, err := casbin.NewCompiledChecker(enforcer)
+	assert.NoError(t, err)
+	mgr := NewRoleOperator(enforcer, WithCompiledChecker(compiled))
+	check := func() bool {
+		ok, err := compiled.Check("alice", "t1", "device", "write")
+		assert.NoError(t, err)
+		return ok
+	}
+
+	assert.NoError(t, mgr.CreateRole("t1", "admin", Permission{"*", "*"}))
+	assert.NoError(t, mgr.AssignRole("t1", "alice", "admin"))
+	assert.True(t, check())
+	assert.NoError(t, mgr.Deny("t1", "alice", Permission{"device", "write"}))
+	assert.False(t, check())
+	assert.NoError(t, mgr.RemoveDeny("t1", "alice", Permission{"device", "write"}))
+	assert.True(t, check())
+	assert.NoError(t, mgr.RevokePermissions("t1", "admin", Permission{"*", "*"}))
+	assert.False(t, check())
+	assert.NoError(t, mgr.GrantPermissions("t1", "admin", Permission{"*", "*"}))
+	assert.True(t, check())
+	assert.NoError(t, mgr.UnassignRole("t1", "alice", "admin"))
+	assert.False(t, check())
+}
+
+func TestRoleHierarchy(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	mgr := NewRoleOperator(enforcer)
+
+	assert.NoError(t, mgr.CreateRole("t1", "viewer", Permission{"device", "read"}))
+	assert.NoError(t, mgr.CreateRole("t1", "operator", Permission{"device", "write"}, Permission{"device", "read"}))
+	assert.NoError(t, mgr.CreateRole("t1", "admin", Permission{"user", "*"}))
+	assert.NoError(t, mgr.InheritRole("t1", "operator", "viewer"))
+	assert.NoError(t, mgr.InheritRole("t1", "admin", "operator"))
+	assert.ErrorIs(t, mgr.InheritRole("t1", "viewer", "admin"), ErrRoleCycle)
+	assert.ErrorIs(t, mgr.InheritRole("t1", "viewer", "viewer"), ErrRoleCycle)
+
+	assert.NoError(t, mgr.AssignRole("t1", "alice", "admin"))
+	ok, err := mgr.Check("alice", "t1", "device", "write")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	roles, err := mgr.SubjectRoles("t1", "alice")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"admin", "operator", "viewer"}, roles)
+	permissions, err := mgr.EffectivePermissions("t1", "alice")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Permission{{"user", "*"}, {"device", "write"}, {"device", "read"}}, permissions)
+
+	assert.NoError(t, mgr.DisinheritRole("t1", "admin", "operator"))
+	permissions, err = mgr.EffectivePermissions("t1", "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []Permission{{"user", "*"}}, permissions)
+
+	assert.NoError(t, mgr.DeleteRole("t1", "operator"))
+	roles, err = mgr.SubjectRoles("t1", "operator")
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+}
+
+func TestCheckBatch(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	mgr := NewRoleOperator(enforcer)
+	assert.NoError(t, mgr.CreateRole("t1", "viewer", Permission{"device-1", "read"}, Permission{"device-3", "*"}))
+	assert.NoError(t, mgr.AssignRole("t1", "alice", "viewer"))
+
+	decisions, err := mgr.CheckBatch("alice", "t1", []Permission{{"device-1", "read"}, {"device-1", "write"}, {"device-3", "write"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false, true}, decisions)
+	_, err = mgr.CheckBatch("alice", "t1", []Permission{{"device-1", ""}})
+	assert.ErrorIs(t, err, ErrInvalidParam)
+
+	allowed, err := FilterAllowed(mgr, "alice", "t1", "read", []string{"device-3", "device-2", "device-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"device-3", "device-1"}, allowed)
+}
+
+func TestExportImport(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	mgr := NewRoleOperator(enforcer)
+	assert.NoError(t, mgr.CreateRole("staging", "viewer", Permission{"devices/*", "read"}))
+	assert.NoError(t, mgr.CreateRole("staging", "admin", Permission{"*", "*"}))
+	assert.NoError(t, mgr.InheritRole("staging", "admin", "viewer"))
+	assert.NoError(t, mgr.AddGroupMember("staging", "ops", "alice"))
+	assert.NoError(t, mgr.AssignRole("staging", Group("ops"), "viewer"))
+	assert.NoError(t, mgr.Deny("staging", Group("contractors"), Permission{"devices/secret", "*"}))
+	assert.NoError(t, mgr.CreateRole("prod", "legacy", Permission{"plugins", "read"}))
+	assert.NoError(t, mgr.CreateRole("prod", "viewer", Permission{"devices/*", "read"}))
+
+	exported, err := mgr.Export("staging")
+	assert.NoError(t, err)
+	assert.Len(t, exported.Policies, 2)
+	assert.Len(t, exported.Denies, 1)
+	assert.Len(t, exported.Bindings, 3)
+
+	var buf bytes.Buffer
+	assert.NoError(t, EncodeCSV(&buf, exported))
+	decoded, err := DecodeCSV(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, exported, decoded)
+
+	diff, err := mgr.Import("prod", decoded, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []PolicyRule{{"admin", "*", "*"}}, diff.AddedPolicies)
+	assert.Equal(t, []PolicyRule{{"legacy", "plugins", "read"}}, diff.RemovedPolicies)
+	assert.Len(t, diff.AddedBindings, 3)
+	assert.Len(t, diff.AddedDenies, 1)
+	ok, err := mgr.Check("alice", "prod", "devices/d1", "read")
+	assert.NoError(t, err)
+	assert.False(t, ok, "dry run must not change the tenant")
+
+	_, err = mgr.Import("prod", decoded, false)
+	assert.NoError(t, err)
+	ok, err = mgr.Check("alice", "prod", "devices/d1", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	prod, err := mgr.Export("prod")
+	assert.NoError(t, err)
+	assert.Equal(t, exported, prod)
+	diff, err = mgr.Import("prod", decoded, false)
+	assert.NoError(t, err)
+	assert.True(t, diff.Empty())
+
+	_, err = DecodeCSV(bytes.NewBufferString("p, viewer, devices\n"))
+	assert.ErrorIs(t, err, ErrInvalidPolicyRecord)
+	_, err = mgr.Import("prod", &TenantPolicy{Bindings: []Binding{{Subject: "alice"}}}, true)
+	assert.ErrorIs(t, err, ErrInvalidParam)
+}
+
+func TestDeny(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	mgr := NewRoleOperator(enforcer)
+	assert.NoError(t, mgr.CreateRole("t1", "member", Permission{"devices/**", "*"}))
+	assert.NoError(t, mgr.AssignRole("t1", Group("staff"), "member"))
+	assert.NoError(t, mgr.AddGroupMember("t1", "staff", "alice"))
+	assert.NoError(t, mgr.AddGroupMember("t1", "staff", "bob"))
+	assert.NoError(t, mgr.AddGroupMember("t1", "contractors", "bob"))
+	assert.NoError(t, mgr.Deny("t1", Group("contractors"), Permission{"devices/**", "delete"}))
+	assert.NoError(t, mgr.Deny("t1", "alice", Permission{"devices/vault", "*"}))
+	assert.Equal(t, []Permission{{"devices/vault", "*"}}, mgr.Denies("t1", "alice"))
+
+	tests := []struct {
+		subject, resource, action string
+		allowed                   bool
+	}{
+		{"alice", "devices/d1", "delete", true},
+		{"bob", "devices/d1", "delete", false},
+		{"bob", "devices/d1", "read", true},
+		{"alice", "devices/vault", "read", false},
+	}
+	for _, tt := range tests {
+		ok, err := mgr.Check(tt.subject, "t1", tt.resource, tt.action)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.allowed, ok, "%+v", tt)
+	}
+	_, rule, err := mgr.(authorizer.Explainer).Explain("bob", "t1", "devices/d1", "delete")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{Group("contractors"), "t1", "devices/**", "delete"}, rule)
+	decisions, err := mgr.CheckBatch("bob", "t1", []Permission{{"devices/d1", "read"}, {"devices/d1", "delete"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, decisions)
+
+	assert.NoError(t, mgr.RemoveDeny("t1", Group("contractors"), Permission{"devices/**", "delete"}))
+	ok, err := mgr.Check("bob", "t1", "devices/d1", "delete")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+type identity struct {
+	id     string
+	groups []interface{}
+}
+
+func (i identity) GetUserID() string     { return i.id }
+func (i identity) GetTenantID() string   { return "" }
+func (i identity) GetUsername() string   { return i.id }
+func (i identity) GetEmail() string      { return "" }
+func (i identity) GetExternalID() string { return i.id }
+func (i identity) GetExtra() map[string]interface{} {
+	return map[string]interface{}{idprovider.ExtraGroups: i.groups}
+}
+
+func TestGroupMapper(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	mgr := NewRoleOperator(enforcer)
+	assert.NoError(t, mgr.CreateRole("t1", "operator", Permission{"devices/*", "*"}))
+	assert.NoError(t, mgr.CreateRole("t1", "viewer", Permission{"devices/*", "read"}))
+	assert.NoError(t, mgr.AssignRole("t1", "alice", "auditor"))
+
+	_, err = NewGroupMapper(mgr, []GroupMappingRule{{Group: "ops"}})
+	assert.ErrorIs(t, err, ErrInvalidParam)
+	m, err := NewGroupMapper(mgr, []GroupMappingRule{
+		{Provider: "corp", Group: "iot-ops", TenantID: "t1", Role: "operator"},
+		{Provider: "corp", Group: "iot-*", TenantID: "t1", Role: "viewer"},
+		{Provider: "partner", Group: "*", TenantID: "t2", Role: "viewer"},
+	})
+	assert.NoError(t, err)
+
+	result, err := m.SyncIdentity("corp", identity{id: "alice", groups: []interface{}{"iot-ops", "staff"}})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []TenantRole{{"t1", "operator"}, {"t1", "viewer"}}, result.Bound)
+	ok, err := mgr.Check("alice", "t1", "devices/d1", "write")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// alice left iot-ops, the stale operator binding goes, the manual auditor binding stays.
+	result, err = m.SyncIdentity("corp", identity{id: "alice", groups: []interface{}{"iot-readers"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []TenantRole{{"t1", "operator"}}, result.Unbound)
+	roles, err := mgr.SubjectRoles("t1", "alice")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"auditor", "viewer"}, roles)
+
+	result, err = m.SyncIdentity("partner", identity{id: "bob", groups: []interface{}{"any"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []TenantRole{{"t2", "viewer"}}, result.Bound)
+	assert.Empty(t, result.Unbound)
+}
+
+func TestTenantPluginRevokeTenant(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	mgr := NewTenantPluginOperator(enforcer).(*TenantPluginOperator)
+
+	for _, tenantID := range []string{"t1", "t2"} {
+		_, err = mgr.OnCreateTenant(tenantID)
+		assert.NoError(t, err)
+		_, err = mgr.AddTenantPlugin(tenantID, "iothub")
+		assert.NoError(t, err)
+	}
+	ok, err := mgr.TenantPluginPermissible("t1", "iothub")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, mgr.RevokeTenant("t1"))
+	ok, err = mgr.TenantPluginPermissible("t1", "iothub")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, mgr.ListTenantPlugins("t1"))
+	ok, err = mgr.TenantPluginPermissible("t2", "iothub")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -81,6 +81,18 @@ func (t *TenantPluginOperator) TenantPluginPermissible(tenantID, pluginID string
 	return
 }
 
+// RevokeTenant removes the enabled plugins of the tenant, the counterpart of OnCreateTenant.
+func (t *TenantPluginOperator) RevokeTenant(tenantID string) error {
+	role := fmt.Sprintf("%s%s", SysRole, tenantID)
+	if _, err := t.RBACOperator.RemoveFilteredPolicy(0, role, SysTenant); err != nil {
+		return fmt.Errorf("remove tenant plugins %w", err)
+	}
+	if _, err := t.RBACOperator.RemoveFilteredGroupingPolicy(0, fmt.Sprintf("%s%s", sysUser, tenantID), role, SysTenant); err != nil {
+		return fmt.Errorf("remove tenant plugin binding %w", err)
+	}
+	return nil
+}
+
 func (t *TenantPluginOperator) OnCreateTenant(tenantID string) (ok bool, err error) {
 	gpolicy := []string{
 		fmt.Sprintf("%s%s", sysUser, tenantID),
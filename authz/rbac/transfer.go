@@ -0,0 +1,298 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rbac
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	rbaccasbin "github.com/tkeel-io/security/authz/casbin"
+)
+
+// ErrInvalidPolicyRecord a csv record is neither a p nor a g line.
+var ErrInvalidPolicyRecord = errors.New("invalid policy record")
+
+// PolicyRule a permission granted to a role.
+type PolicyRule struct {
+	Role     string `json:"role"`
+	Resource string `json:"resource"`
+	Action   string `json:"action"`
+}
+
+// Binding a subject holding a role: a user or group assigned a role, a user member
+// of a group or a role inheriting another.
+type Binding struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+}
+
+// TenantPolicy the role and permission set of a tenant, without the tenant so it can be
+// imported into another tenant.
+type TenantPolicy struct {
+	Policies []PolicyRule `json:"policies"`
+	// Denies deny policies, their Role is the denied subject.
+	Denies   []PolicyRule `json:"denies"`
+	Bindings []Binding    `json:"bindings"`
+}
+
+// PolicyDiff the changes an import makes to a tenant.
+type PolicyDiff struct {
+	AddedPolicies   []PolicyRule `json:"added_policies,omitempty"`
+	RemovedPolicies []PolicyRule `json:"removed_policies,omitempty"`
+	AddedDenies     []PolicyRule `json:"added_denies,omitempty"`
+	RemovedDenies   []PolicyRule `json:"removed_denies,omitempty"`
+	AddedBindings   []Binding    `json:"added_bindings,omitempty"`
+	RemovedBindings []Binding    `json:"removed_bindings,omitempty"`
+}
+
+// Empty reports whether the import changes nothing.
+func (d *PolicyDiff) Empty() bool {
+	return len(d.AddedPolicies)+len(d.RemovedPolicies)+len(d.AddedDenies)+len(d.RemovedDenies)+
+		len(d.AddedBindings)+len(d.RemovedBindings) == 0
+}
+
+func (o *RoleOperator) Export(tenantID string) (*TenantPolicy, error) {
+	if tenantID == "" {
+		return nil, ErrInvalidParam
+	}
+	tp := &TenantPolicy{Policies: []PolicyRule{}, Denies: []PolicyRule{}, Bindings: []Binding{}}
+	for _, rule := range o.RBACOperator.GetFilteredPolicy(1, tenantID) {
+		tp.Policies = append(tp.Policies, PolicyRule{Role: rule[0], Resource: rule[2], Action: rule[3]})
+	}
+	for _, rule := range o.RBACOperator.GetFilteredNamedPolicy(rbaccasbin.DenyPolicyType, 1, tenantID) {
+		tp.Denies = append(tp.Denies, PolicyRule{Role: rule[0], Resource: rule[2], Action: rule[3]})
+	}
+	for _, rule := range o.RBACOperator.GetFilteredGroupingPolicy(2, tenantID) {
+		tp.Bindings = append(tp.Bindings, Binding{Subject: rule[0], Role: rule[1]})
+	}
+	sortPolicyRules(tp.Policies)
+	sortPolicyRules(tp.Denies)
+	sort.Slice(tp.Bindings, func(i, j int) bool {
+		return lessStrings(tp.Bindings[i].strings(), tp.Bindings[j].strings())
+	})
+	return tp, nil
+}
+
+// Import replaces the role and permission set of the tenant with tp and returns the changes,
+// a dry run only returns them.
+func (o *RoleOperator) Import(tenantID string, tp *TenantPolicy, dryRun bool) (*PolicyDiff, error) {
+	if err := tp.Valid(); err != nil {
+		return nil, err
+	}
+	current, err := o.Export(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	diff := &PolicyDiff{}
+	diff.AddedPolicies, diff.RemovedPolicies = diffPolicyRules(current.Policies, tp.Policies)
+	diff.AddedDenies, diff.RemovedDenies = diffPolicyRules(current.Denies, tp.Denies)
+	diff.AddedBindings, diff.RemovedBindings = diffBindings(current.Bindings, tp.Bindings)
+	if dryRun || diff.Empty() {
+		return diff, nil
+	}
+	return diff, o.apply(tenantID, diff)
+}
+
+func (o *RoleOperator) apply(tenantID string, diff *PolicyDiff) (err error) {
+	defer o.rebuild(&err)
+	e := o.RBACOperator
+	if rules := policyRules(tenantID, diff.RemovedPolicies); len(rules) > 0 {
+		if _, err := e.RemovePolicies(rules); err != nil {
+			return fmt.Errorf("remove policies %w", err)
+		}
+	}
+	if rules := policyRules(tenantID, diff.RemovedDenies); len(rules) > 0 {
+		if _, err := e.RemoveNamedPolicies(rbaccasbin.DenyPolicyType, rules); err != nil {
+			return fmt.Errorf("remove deny policies %w", err)
+		}
+	}
+	if rules := bindingRules(tenantID, diff.RemovedBindings); len(rules) > 0 {
+		if _, err := e.RemoveGroupingPolicies(rules); err != nil {
+			return fmt.Errorf("remove grouping policies %w", err)
+		}
+	}
+	if rules := policyRules(tenantID, diff.AddedPolicies); len(rules) > 0 {
+		if _, err := e.AddPolicies(rules); err != nil {
+			return fmt.Errorf("add policies %w", err)
+		}
+	}
+	if rules := policyRules(tenantID, diff.AddedDenies); len(rules) > 0 {
+		if _, err := e.AddNamedPolicies(rbaccasbin.DenyPolicyType, rules); err != nil {
+			return fmt.Errorf("add deny policies %w", err)
+		}
+	}
+	if rules := bindingRules(tenantID, diff.AddedBindings); len(rules) > 0 {
+		if _, err := e.AddGroupingPolicies(rules); err != nil {
+			return fmt.Errorf("add grouping policies %w", err)
+		}
+	}
+	return nil
+}
+
+func diffPolicyRules(current, want []PolicyRule) (added, removed []PolicyRule) {
+	wanted := make(map[PolicyRule]bool, len(want))
+	for _, p := range want {
+		wanted[p] = true
+	}
+	for _, p := range current {
+		if !wanted[p] {
+			removed = append(removed, p)
+		}
+		delete(wanted, p)
+	}
+	for _, p := range want {
+		if wanted[p] {
+			added = append(added, p)
+			delete(wanted, p)
+		}
+	}
+	return added, removed
+}
+
+func diffBindings(current, want []Binding) (added, removed []Binding) {
+	wanted := make(map[Binding]bool, len(want))
+	for _, b := range want {
+		wanted[b] = true
+	}
+	for _, b := range current {
+		if !wanted[b] {
+			removed = append(removed, b)
+		}
+		delete(wanted, b)
+	}
+	for _, b := range want {
+		if wanted[b] {
+			added = append(added, b)
+			delete(wanted, b)
+		}
+	}
+	return added, removed
+}
+
+func policyRules(tenantID string, policies []PolicyRule) [][]string {
+	rules := make([][]string, 0, len(policies))
+	for _, p := range policies {
+		rules = append(rules, []string{p.Role, tenantID, p.Resource, p.Action})
+	}
+	return rules
+}
+
+func bindingRules(tenantID string, bindings []Binding) [][]string {
+	rules := make([][]string, 0, len(bindings))
+	for _, b := range bindings {
+		rules = append(rules, []string{b.Subject, b.Role, tenantID})
+	}
+	return rules
+}
+
+func (tp *TenantPolicy) Valid() error {
+	if tp == nil {
+		return ErrInvalidParam
+	}
+	for _, p := range append(append([]PolicyRule(nil), tp.Policies...), tp.Denies...) {
+		if p.Role == "" || p.Resource == "" || p.Action == "" {
+			return fmt.Errorf("%w: policy %v", ErrInvalidParam, p.strings())
+		}
+	}
+	for _, b := range tp.Bindings {
+		if b.Subject == "" || b.Role == "" {
+			return fmt.Errorf("%w: binding %v", ErrInvalidParam, b.strings())
+		}
+	}
+	return nil
+}
+
+// EncodeCSV writes tp in the casbin csv format without the domain: "p, role, resource, action",
+// "p2, subject, resource, action" for denies and "g, subject, role" lines.
+func EncodeCSV(w io.Writer, tp *TenantPolicy) error {
+	cw := csv.NewWriter(w)
+	for _, p := range tp.Policies {
+		if err := cw.Write(append([]string{"p"}, p.strings()...)); err != nil {
+			return fmt.Errorf("write csv %w", err)
+		}
+	}
+	for _, p := range tp.Denies {
+		if err := cw.Write(append([]string{rbaccasbin.DenyPolicyType}, p.strings()...)); err != nil {
+			return fmt.Errorf("write csv %w", err)
+		}
+	}
+	for _, b := range tp.Bindings {
+		if err := cw.Write(append([]string{"g"}, b.strings()...)); err != nil {
+			return fmt.Errorf("write csv %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("write csv %w", err)
+	}
+	return nil
+}
+
+// DecodeCSV reads a policy written by EncodeCSV, blank lines and # comments are skipped.
+func DecodeCSV(r io.Reader) (*TenantPolicy, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	tp := &TenantPolicy{Policies: []PolicyRule{}, Denies: []PolicyRule{}, Bindings: []Binding{}}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return tp, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv %w", err)
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		switch {
+		case record[0] == "p" && len(record) == 4:
+			tp.Policies = append(tp.Policies, PolicyRule{Role: record[1], Resource: record[2], Action: record[3]})
+		case record[0] == rbaccasbin.DenyPolicyType && len(record) == 4:
+			tp.Denies = append(tp.Denies, PolicyRule{Role: record[1], Resource: record[2], Action: record[3]})
+		case record[0] == "g" && len(record) == 3:
+			tp.Bindings = append(tp.Bindings, Binding{Subject: record[1], Role: record[2]})
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPolicyRecord, strings.Join(record, ", "))
+		}
+	}
+}
+
+func (p PolicyRule) strings() []string {
+	return []string{p.Role, p.Resource, p.Action}
+}
+
+func (b Binding) strings() []string {
+	return []string{b.Subject, b.Role}
+}
+
+func sortPolicyRules(rules []PolicyRule) {
+	sort.Slice(rules, func(i, j int) bool {
+		return lessStrings(rules[i].strings(), rules[j].strings())
+	})
+}
+
+func lessStrings(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,517 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package uma implements User-Managed Access 2.0: owners register resources, resource servers
+// ask for permission tickets and requesting parties trade them for requesting party tokens
+// (RPT) carrying the permissions the owners shared with them.
+// See also, https://docs.kantarainitiative.org/uma/wg/rec-oauth-uma-grant-2.0.html
+package uma
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	// ClaimPermissions the claim of the RPT holding the granted permissions.
+	ClaimPermissions = "permissions"
+
+	_defaultTicketTTL = 5 * time.Minute
+)
+
+var (
+	_ Store = &MemoryStore{}
+
+	// ErrNotFound no resource, ticket or share with the id.
+	ErrNotFound = errors.New("uma: not found")
+	// ErrInvalidResource the resource has no owner, name or scopes, or is owned by another user.
+	ErrInvalidResource = errors.New("uma: invalid resource")
+	// ErrInvalidScope a requested scope is not registered for the resource.
+	ErrInvalidScope = errors.New("uma: invalid scope")
+	// ErrInvalidTicket the permission ticket is unknown, expired or was used.
+	ErrInvalidTicket = errors.New("uma: invalid permission ticket")
+	// ErrRequestDenied the owners did not share the requested permissions with the requesting party.
+	ErrRequestDenied = errors.New("uma: request denied")
+)
+
+// Resource a protected resource registered by its owner, e.g. the data of a device.
+type Resource struct {
+	ID       string `json:"_id"`
+	Owner    string `json:"owner"`
+	TenantID string `json:"tenant_id,omitempty"`
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	// Scopes the actions the resource supports, e.g. read, write.
+	Scopes      []string `json:"resource_scopes"`
+	Description string   `json:"description,omitempty"`
+	IconURI     string   `json:"icon_uri,omitempty"`
+}
+
+// Permission scopes of a resource, requested by a resource server or granted in an RPT.
+type Permission struct {
+	ResourceID string   `json:"resource_id"`
+	Scopes     []string `json:"resource_scopes"`
+}
+
+// Ticket a permission ticket, stored under the hash of the ticket handed to the client.
+type Ticket struct {
+	Signature   string       `json:"signature"`
+	Permissions []Permission `json:"permissions"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+}
+
+// Share the scopes of a resource its owner shares with a requesting party.
+type Share struct {
+	ResourceID string    `json:"resource_id"`
+	Requester  string    `json:"requester"`
+	Scopes     []string  `json:"scopes"`
+	SharedAt   time.Time `json:"shared_at"`
+}
+
+// Store persists resources, tickets and shares.
+type Store interface {
+	// SaveResource adds or replaces the resource.
+	SaveResource(ctx context.Context, r *Resource) error
+	// GetResource returns the resource or ErrNotFound.
+	GetResource(ctx context.Context, id string) (*Resource, error)
+	// ListResources returns the resources of owner.
+	ListResources(ctx context.Context, owner string) ([]*Resource, error)
+	// DeleteResource deletes the resource and its shares.
+	DeleteResource(ctx context.Context, id string) error
+	SaveTicket(ctx context.Context, t *Ticket) error
+	// ConsumeTicket returns and deletes the ticket, or ErrNotFound.
+	ConsumeTicket(ctx context.Context, signature string) (*Ticket, error)
+	// SaveShare adds or replaces the share of the resource with the requester.
+	SaveShare(ctx context.Context, s *Share) error
+	// GetShare returns the share or ErrNotFound.
+	GetShare(ctx context.Context, resourceID, requester string) (*Share, error)
+	// ListShares returns the shares of the resource.
+	ListShares(ctx context.Context, resourceID string) ([]*Share, error)
+	DeleteShare(ctx context.Context, resourceID, requester string) error
+}
+
+// Config of the UMA authorization server.
+type Config struct {
+	// TicketTTL lifetime of permission tickets. Default to 5m.
+	TicketTTL time.Duration `mapstructure:"ticket_ttl" json:"ticket_ttl" yaml:"ticketTTL"`
+}
+
+// Service registers resources, issues permission tickets and decides which permissions of a
+// ticket a requesting party gets.
+type Service struct {
+	conf  Config
+	store Store
+	// checker nil while only the shares of the owners grant access.
+	checker authorizer.Checker
+}
+
+// New returns a Service keeping its state in store.
+func New(conf Config, store Store) *Service {
+	if conf.TicketTTL <= 0 {
+		conf.TicketTTL = _defaultTicketTTL
+	}
+	return &Service{conf: conf, store: store}
+}
+
+// SetChecker grants the scopes checker allows beyond the shares, checked with the resource id as
+// resource and the scope as action in the tenant of the resource, e.g. to let operators of a
+// tenant reach all its devices. Requesting parties of other tenants are never checked.
+func (s *Service) SetChecker(checker authorizer.Checker) {
+	s.checker = checker
+}
+
+// RegisterResource registers r for its owner and returns it with its new id.
+func (s *Service) RegisterResource(ctx context.Context, r *Resource) (*Resource, error) {
+	if err := validateResource(r); err != nil {
+		return nil, err
+	}
+	id, err := utils.RandBase64String(16)
+	if err != nil {
+		return nil, err
+	}
+	registered := *r
+	registered.ID = id
+	if err = s.store.SaveResource(ctx, &registered); err != nil {
+		return nil, fmt.Errorf("save uma resource %w", err)
+	}
+	return &registered, nil
+}
+
+// UpdateResource replaces the description of the resource owned by owner, the owner and id do not change.
+func (s *Service) UpdateResource(ctx context.Context, owner *token.Claims, r *Resource) error {
+	current, err := s.ownedResource(ctx, owner, r.ID)
+	if err != nil {
+		return err
+	}
+	updated := *r
+	updated.Owner, updated.TenantID = current.Owner, current.TenantID
+	if err = validateResource(&updated); err != nil {
+		return err
+	}
+	return s.store.SaveResource(ctx, &updated)
+}
+
+// GetResource returns the resource owned by owner.
+func (s *Service) GetResource(ctx context.Context, owner *token.Claims, id string) (*Resource, error) {
+	return s.ownedResource(ctx, owner, id)
+}
+
+// ListResources returns the resources of owner.
+func (s *Service) ListResources(ctx context.Context, owner *token.Claims) ([]*Resource, error) {
+	resources, err := s.store.ListResources(ctx, owner.Subject)
+	if err != nil {
+		return nil, err
+	}
+	owned := make([]*Resource, 0, len(resources))
+	for _, r := range resources {
+		if r.TenantID == owner.TenantID {
+			owned = append(owned, r)
+		}
+	}
+	return owned, nil
+}
+
+// DeleteResource deletes the resource owned by owner and stops sharing it.
+func (s *Service) DeleteResource(ctx context.Context, owner *token.Claims, id string) error {
+	if _, err := s.ownedResource(ctx, owner, id); err != nil {
+		return err
+	}
+	return s.store.DeleteResource(ctx, id)
+}
+
+// Share grants requester, a subject of the tenant of the resource, the scopes of the resource
+// owned by owner, replacing a previous share.
+func (s *Service) Share(ctx context.Context, owner *token.Claims, resourceID, requester string, scopes []string) error {
+	r, err := s.ownedResource(ctx, owner, resourceID)
+	if err != nil {
+		return err
+	}
+	if requester == "" || len(scopes) == 0 {
+		return fmt.Errorf("%w: requester and scopes required", ErrInvalidResource)
+	}
+	for _, scope := range scopes {
+		if !utils.StringsInclude(r.Scopes, scope) {
+			return fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	return s.store.SaveShare(ctx, &Share{ResourceID: resourceID, Requester: requester, Scopes: scopes, SharedAt: time.Now()})
+}
+
+// Unshare stops sharing the resource owned by owner with requester, which takes effect as the
+// RPTs issued before expire.
+func (s *Service) Unshare(ctx context.Context, owner *token.Claims, resourceID, requester string) error {
+	if _, err := s.ownedResource(ctx, owner, resourceID); err != nil {
+		return err
+	}
+	return s.store.DeleteShare(ctx, resourceID, requester)
+}
+
+// ListShares returns the shares of the resource owned by owner.
+func (s *Service) ListShares(ctx context.Context, owner *token.Claims, resourceID string) ([]*Share, error) {
+	if _, err := s.ownedResource(ctx, owner, resourceID); err != nil {
+		return nil, err
+	}
+	return s.store.ListShares(ctx, resourceID)
+}
+
+// CreateTicket returns a permission ticket for the permissions a resource server asks a client
+// to obtain. The resources must be owned by owner, the subject of the protection API token.
+func (s *Service) CreateTicket(ctx context.Context, owner *token.Claims, permissions []Permission) (string, error) {
+	if len(permissions) == 0 {
+		return "", fmt.Errorf("%w: permissions required", ErrInvalidResource)
+	}
+	for _, p := range permissions {
+		r, err := s.ownedResource(ctx, owner, p.ResourceID)
+		if err != nil {
+			return "", err
+		}
+		for _, scope := range p.Scopes {
+			if !utils.StringsInclude(r.Scopes, scope) {
+				return "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+			}
+		}
+	}
+	ticket, err := utils.RandBase64String(32)
+	if err != nil {
+		return "", err
+	}
+	err = s.store.SaveTicket(ctx, &Ticket{
+		Signature:   token.HashToken(ticket),
+		Permissions: permissions,
+		ExpiresAt:   time.Now().Add(s.conf.TicketTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("save uma ticket %w", err)
+	}
+	return ticket, nil
+}
+
+// Authorize consumes the ticket and returns the permissions requester gets, all of them or
+// ErrRequestDenied. A permission without scopes asks for every scope of the resource.
+func (s *Service) Authorize(ctx context.Context, ticket string, requester *token.Claims) ([]Permission, error) {
+	t, err := s.store.ConsumeTicket(ctx, token.HashToken(ticket))
+	if errors.Is(err, ErrNotFound) || (err == nil && time.Now().After(t.ExpiresAt)) {
+		return nil, ErrInvalidTicket
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume uma ticket %w", err)
+	}
+	granted := make([]Permission, 0, len(t.Permissions))
+	for _, p := range t.Permissions {
+		r, err := s.store.GetResource(ctx, p.ResourceID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrRequestDenied
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get uma resource %w", err)
+		}
+		scopes := p.Scopes
+		if len(scopes) == 0 {
+			scopes = r.Scopes
+		}
+		for _, scope := range scopes {
+			allowed, err := s.allowed(ctx, r, requester, scope)
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				return nil, fmt.Errorf("%w: %s %s", ErrRequestDenied, scope, r.ID)
+			}
+		}
+		granted = append(granted, Permission{ResourceID: r.ID, Scopes: scopes})
+	}
+	return granted, nil
+}
+
+func (s *Service) allowed(ctx context.Context, r *Resource, requester *token.Claims, scope string) (bool, error) {
+	// subjects come from the identity providers of the tenants, the same subject in another
+	// tenant is another party.
+	if requester.TenantID != r.TenantID {
+		return false, nil
+	}
+	if requester.Subject == r.Owner {
+		return true, nil
+	}
+	share, err := s.store.GetShare(ctx, r.ID, requester.Subject)
+	if err == nil && utils.StringsInclude(share.Scopes, scope) {
+		return true, nil
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, fmt.Errorf("get uma share %w", err)
+	}
+	if s.checker == nil {
+		return false, nil
+	}
+	allowed, err := s.checker.Check(requester.Subject, r.TenantID, r.ID, scope)
+	if err != nil {
+		return false, fmt.Errorf("check uma permission %w", err)
+	}
+	return allowed, nil
+}
+
+func (s *Service) ownedResource(ctx context.Context, owner *token.Claims, id string) (*Resource, error) {
+	r, err := s.store.GetResource(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Owner != owner.Subject || r.TenantID != owner.TenantID {
+		// resources of others are not revealed.
+		return nil, ErrNotFound
+	}
+	return r, nil
+}
+
+func validateResource(r *Resource) error {
+	if r.Owner == "" || r.Name == "" || len(r.Scopes) == 0 {
+		return fmt.Errorf("%w: owner, name and resource_scopes required", ErrInvalidResource)
+	}
+	return nil
+}
+
+// RPTClaims returns the claims of an RPT: those of the requesting party with the permissions.
+func RPTClaims(requester *token.Claims, permissions []Permission) *token.Claims {
+	claims := *requester
+	claims.Extra = make(map[string]interface{}, len(requester.Extra)+1)
+	for k, v := range requester.Extra {
+		claims.Extra[k] = v
+	}
+	claims.Extra[ClaimPermissions] = permissions
+	return &claims
+}
+
+// Permissions returns the permissions of RPT claims, e.g. of claims verified by a resource server.
+func Permissions(c *token.Claims) ([]Permission, error) {
+	v, ok := c.Extra[ClaimPermissions]
+	if !ok {
+		return nil, nil
+	}
+	if permissions, ok := v.([]Permission); ok {
+		return permissions, nil
+	}
+	// decoded tokens hold the generic JSON form.
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", token.ErrClaimType, ClaimPermissions)
+	}
+	var permissions []Permission
+	if err = json.Unmarshal(data, &permissions); err != nil {
+		return nil, fmt.Errorf("%w: %s", token.ErrClaimType, ClaimPermissions)
+	}
+	return permissions, nil
+}
+
+// Allows reports whether the RPT claims grant scope on the resource.
+func Allows(c *token.Claims, resourceID, scope string) bool {
+	permissions, err := Permissions(c)
+	if err != nil {
+		return false
+	}
+	for _, p := range permissions {
+		if p.ResourceID == resourceID && utils.StringsInclude(p.Scopes, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRPT verifies the RPT and returns its claims when it grants scope on the resource,
+// an error matching errs.ErrPermissionDenied when it does not.
+func ValidateRPT(verifier token.Verifier, rpt, resourceID, scope string) (*token.Claims, error) {
+	claims, err := verifier.Verify(rpt)
+	if err != nil {
+		return nil, err
+	}
+	if !Allows(claims, resourceID, scope) {
+		return nil, errs.New(errs.ErrPermissionDenied, "uma.rpt", fmt.Errorf("%s %s", scope, resourceID))
+	}
+	return claims, nil
+}
+
+// MemoryStore in-process Store.
+type MemoryStore struct {
+	lock      sync.RWMutex
+	resources map[string]*Resource
+	tickets   map[string]*Ticket
+	// shares by resource id then requester.
+	shares map[string]map[string]*Share
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		resources: make(map[string]*Resource),
+		tickets:   make(map[string]*Ticket),
+		shares:    make(map[string]map[string]*Share),
+	}
+}
+
+func (s *MemoryStore) SaveResource(_ context.Context, r *Resource) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.resources[r.ID] = r
+	return nil
+}
+
+func (s *MemoryStore) GetResource(_ context.Context, id string) (*Resource, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if r, ok := s.resources[id]; ok {
+		return r, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) ListResources(_ context.Context, owner string) ([]*Resource, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	resources := make([]*Resource, 0)
+	for _, r := range s.resources {
+		if r.Owner == owner {
+			resources = append(resources, r)
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].Name < resources[j].Name })
+	return resources, nil
+}
+
+func (s *MemoryStore) DeleteResource(_ context.Context, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.resources, id)
+	delete(s.shares, id)
+	return nil
+}
+
+func (s *MemoryStore) SaveTicket(_ context.Context, t *Ticket) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tickets[t.Signature] = t
+	return nil
+}
+
+func (s *MemoryStore) ConsumeTicket(_ context.Context, signature string) (*Ticket, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, ok := s.tickets[signature]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.tickets, signature)
+	return t, nil
+}
+
+func (s *MemoryStore) SaveShare(_ context.Context, share *Share) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.shares[share.ResourceID] == nil {
+		s.shares[share.ResourceID] = make(map[string]*Share)
+	}
+	s.shares[share.ResourceID][share.Requester] = share
+	return nil
+}
+
+func (s *MemoryStore) GetShare(_ context.Context, resourceID, requester string) (*Share, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if share, ok := s.shares[resourceID][requester]; ok {
+		return share, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStore) ListShares(_ context.Context, resourceID string) ([]*Share, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	shares := make([]*Share, 0, len(s.shares[resourceID]))
+	for _, share := range s.shares[resourceID] {
+		shares = append(shares, share)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Requester < shares[j].Requester })
+	return shares, nil
+}
+
+func (s *MemoryStore) DeleteShare(_ context.Context, resourceID, requester string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.shares[resourceID], requester)
+	return nil
+}
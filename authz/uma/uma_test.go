@@ -0,0 +1,106 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uma
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/errs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeChecker map[string]bool
+
+func (c fakeChecker) Check(subject, tenantID, resource, action string) (bool, error) {
+	return c[tenantID+" "+subject+" "+resource+" "+action], nil
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	s := New(Config{}, NewMemoryStore())
+	alice, mallory := &token.Claims{Subject: "alice", TenantID: "t1"}, &token.Claims{Subject: "mallory", TenantID: "t1"}
+	device, err := s.RegisterResource(ctx, &Resource{Owner: "alice", TenantID: "t1", Name: "thermostat", Scopes: []string{"read", "write"}})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, device.ID)
+	assert.NoError(t, s.Share(ctx, alice, device.ID, "bob", []string{"read"}))
+	assert.ErrorIs(t, s.Share(ctx, alice, device.ID, "bob", []string{"delete"}), ErrInvalidScope)
+	assert.ErrorIs(t, s.Share(ctx, mallory, device.ID, "mallory", []string{"read"}), ErrNotFound)
+	assert.ErrorIs(t, s.Share(ctx, &token.Claims{Subject: "alice", TenantID: "t2"}, device.ID, "bob", []string{"read"}), ErrNotFound)
+	s.SetChecker(fakeChecker{
+		"t1 carol " + device.ID + " write": true,
+		"t2 dave " + device.ID + " write":  true,
+	})
+
+	tests := []struct {
+		name      string
+		requester string
+		tenantID  string
+		scopes    []string
+		want      []string
+		wantError error
+	}{
+		{"owner", "alice", "t1", nil, []string{"read", "write"}, nil},
+		{"shared", "bob", "t1", []string{"read"}, []string{"read"}, nil},
+		{"not shared", "bob", "t1", []string{"read", "write"}, nil, ErrRequestDenied},
+		{"checker", "carol", "t1", []string{"write"}, []string{"write"}, nil},
+		{"stranger", "mallory", "t1", []string{"read"}, nil, ErrRequestDenied},
+		{"owner subject of other tenant", "alice", "t2", []string{"read"}, nil, ErrRequestDenied},
+		{"shared subject of other tenant", "bob", "t2", []string{"read"}, nil, ErrRequestDenied},
+		{"checker of other tenant", "dave", "t2", []string{"write"}, nil, ErrRequestDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ticket, err := s.CreateTicket(ctx, alice, []Permission{{ResourceID: device.ID, Scopes: tt.scopes}})
+			assert.NoError(t, err)
+			requester := &token.Claims{Subject: tt.requester, TenantID: tt.tenantID}
+			permissions, err := s.Authorize(ctx, ticket, requester)
+			if tt.wantError != nil {
+				assert.ErrorIs(t, err, tt.wantError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, []Permission{{ResourceID: device.ID, Scopes: tt.want}}, permissions)
+			_, err = s.Authorize(ctx, ticket, requester)
+			assert.ErrorIs(t, err, ErrInvalidTicket, "tickets are single use")
+		})
+	}
+
+	_, err = s.CreateTicket(ctx, mallory, []Permission{{ResourceID: device.ID}})
+	assert.ErrorIs(t, err, ErrNotFound)
+	resources, err := s.ListResources(ctx, &token.Claims{Subject: "alice", TenantID: "t2"})
+	assert.NoError(t, err)
+	assert.Empty(t, resources)
+	assert.NoError(t, s.DeleteResource(ctx, alice, device.ID))
+	shares, err := s.store.ListShares(ctx, device.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, shares)
+}
+
+func TestValidateRPT(t *testing.T) {
+	tokens, err := token.NewJWTManager(&token.Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	rpt, err := tokens.Issue(RPTClaims(&token.Claims{Subject: "bob"}, []Permission{{ResourceID: "device-1", Scopes: []string{"read"}}}))
+	assert.NoError(t, err)
+
+	claims, err := ValidateRPT(tokens, rpt, "device-1", "read")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", claims.Subject)
+	_, err = ValidateRPT(tokens, rpt, "device-1", "write")
+	assert.ErrorIs(t, err, errs.ErrPermissionDenied)
+	_, err = ValidateRPT(tokens, rpt, "device-2", "read")
+	assert.ErrorIs(t, err, errs.ErrPermissionDenied)
+}
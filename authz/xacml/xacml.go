@@ -0,0 +1,640 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package xacml converts XACML 3.0 policy sets into RBAC policies and ABAC policies, reporting
+// the constructs it can not convert, to migrate existing XACML estates.
+//
+// Rules whose target only matches roles, resource ids and action ids with string-equal, and
+// which have no condition, become RBAC permissions of the role (deny rules become denies).
+// The other rules become ABAC policies with a CEL condition translated from their targets and
+// condition. Everything is combined with deny overrides, as both engines do.
+package xacml
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/tkeel-io/security/authz/abac"
+	"github.com/tkeel-io/security/authz/rbac"
+)
+
+// Standard attribute ids mapped by default.
+const (
+	AttributeSubjectID  = "urn:oasis:names:tc:xacml:1.0:subject:subject-id"
+	AttributeRole       = "urn:oasis:names:tc:xacml:2.0:subject:role"
+	AttributeResourceID = "urn:oasis:names:tc:xacml:1.0:resource:resource-id"
+	AttributeActionID   = "urn:oasis:names:tc:xacml:1.0:action:action-id"
+
+	_categorySubject  = "urn:oasis:names:tc:xacml:1.0:subject-category:access-subject"
+	_categoryResource = "urn:oasis:names:tc:xacml:3.0:attribute-category:resource"
+
+	_typeString  = "http://www.w3.org/2001/XMLSchema#string"
+	_typeInteger = "http://www.w3.org/2001/XMLSchema#integer"
+	_typeDouble  = "http://www.w3.org/2001/XMLSchema#double"
+	_typeBoolean = "http://www.w3.org/2001/XMLSchema#boolean"
+	_typeAnyURI  = "http://www.w3.org/2001/XMLSchema#anyURI"
+
+	_effectPermit = "Permit"
+	_effectDeny   = "Deny"
+)
+
+// ErrInvalidDocument the document is not a XACML policy set or policy.
+var ErrInvalidDocument = errors.New("invalid xacml document")
+
+// Attribute the ABAC expression a XACML attribute is read from.
+type Attribute struct {
+	// Expr CEL expression of the attribute, e.g. subject.sub.
+	Expr string
+	// Bag the attribute holds several values, e.g. the roles of a subject.
+	Bag bool
+}
+
+// Finding a construct that was not converted, or converted with a different meaning.
+type Finding struct {
+	// Path the ids of the policy sets, policy and rule, / separated.
+	Path      string `json:"path"`
+	Construct string `json:"construct"`
+	Message   string `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Path, f.Construct, f.Message)
+}
+
+// Result of an import.
+type Result struct {
+	// RBAC the role permissions and denies, see rbac.RoleMgr Import.
+	RBAC *rbac.TenantPolicy
+	// ABAC policies, see abac.Engine AddPolicy.
+	ABAC []abac.Policy
+	// Findings the constructs skipped or approximated, a rule with a finding was skipped
+	// unless the finding says otherwise.
+	Findings []Finding
+}
+
+// Importer converts XACML documents.
+type Importer struct {
+	attributes map[string]Attribute
+}
+
+// NewImporter returns an Importer mapping subject-id to subject.sub, role to subject.roles,
+// resource-id to resource.id and action-id to the action. Other subject and resource
+// attributes map to subject and resource keys named after the last segment of their id.
+func NewImporter() *Importer {
+	return &Importer{attributes: map[string]Attribute{
+		AttributeSubjectID:  {Expr: "subject.sub"},
+		AttributeRole:       {Expr: "subject.roles", Bag: true},
+		AttributeResourceID: {Expr: "resource.id"},
+		AttributeActionID:   {Expr: "action"},
+	}}
+}
+
+// SetAttribute maps the XACML attribute id to an ABAC expression, e.g. a group attribute to
+// subject.groups.
+func (im *Importer) SetAttribute(id string, a Attribute) {
+	im.attributes[id] = a
+}
+
+type policySet struct {
+	ID             string      `xml:"PolicySetId,attr"`
+	Combining      string      `xml:"PolicyCombiningAlgId,attr"`
+	Target         *target     `xml:"Target"`
+	PolicySets     []policySet `xml:"PolicySet"`
+	Policies       []policy    `xml:"Policy"`
+	PolicySetRefs  []string    `xml:"PolicySetIdReference"`
+	PolicyRefs     []string    `xml:"PolicyIdReference"`
+	Obligations    *node       `xml:"ObligationExpressions"`
+	Advice         *node       `xml:"AdviceExpressions"`
+	CombinerParams []node      `xml:"CombinerParameters"`
+}
+
+type policy struct {
+	ID          string  `xml:"PolicyId,attr"`
+	Combining   string  `xml:"RuleCombiningAlgId,attr"`
+	Target      *target `xml:"Target"`
+	Rules       []rule  `xml:"Rule"`
+	Variables   []node  `xml:"VariableDefinition"`
+	Obligations *node   `xml:"ObligationExpressions"`
+	Advice      *node   `xml:"AdviceExpressions"`
+}
+
+type rule struct {
+	ID          string  `xml:"RuleId,attr"`
+	Effect      string  `xml:"Effect,attr"`
+	Description string  `xml:"Description"`
+	Target      *target `xml:"Target"`
+	Condition   *node   `xml:"Condition"`
+	Obligations *node   `xml:"ObligationExpressions"`
+	Advice      *node   `xml:"AdviceExpressions"`
+}
+
+// target matches when all its AnyOf match, an AnyOf when one of its AllOf matches and an
+// AllOf when all its matches match.
+type target struct {
+	AnyOf []anyOf `xml:"AnyOf"`
+}
+
+type anyOf struct {
+	AllOf []allOf `xml:"AllOf"`
+}
+
+type allOf struct {
+	Matches []match `xml:"Match"`
+}
+
+type match struct {
+	MatchID    string          `xml:"MatchId,attr"`
+	Value      *attributeValue `xml:"AttributeValue"`
+	Designator *designator     `xml:"AttributeDesignator"`
+	Selector   *node           `xml:"AttributeSelector"`
+}
+
+type attributeValue struct {
+	DataType string `xml:"DataType,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type designator struct {
+	Category    string `xml:"Category,attr"`
+	AttributeID string `xml:"AttributeId,attr"`
+	DataType    string `xml:"DataType,attr"`
+}
+
+// node an expression element of a condition, kept generic so the order of arguments is kept.
+type node struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []node     `xml:",any"`
+}
+
+func (n *node) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// Import converts the PolicySet or Policy of the document read from r.
+func (im *Importer) Import(r io.Reader) (*Result, error) {
+	var root node
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read xacml %w", err)
+	}
+	if err = xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDocument, err)
+	}
+	res := &Result{RBAC: &rbac.TenantPolicy{Policies: []rbac.PolicyRule{}, Denies: []rbac.PolicyRule{}, Bindings: []rbac.Binding{}}}
+	switch root.XMLName.Local {
+	case "PolicySet":
+		var ps policySet
+		if err = xml.Unmarshal(data, &ps); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidDocument, err)
+		}
+		im.importPolicySet(res, "", nil, &ps)
+	case "Policy":
+		var p policy
+		if err = xml.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidDocument, err)
+		}
+		im.importPolicy(res, "", nil, &p)
+	default:
+		return nil, fmt.Errorf("%w: root element %s", ErrInvalidDocument, root.XMLName.Local)
+	}
+	return res, nil
+}
+
+func (im *Importer) importPolicySet(res *Result, parent string, targets []anyOf, ps *policySet) {
+	path := join(parent, ps.ID)
+	checkCombining(res, path, "PolicyCombiningAlgId", ps.Combining)
+	for _, ref := range append(append([]string(nil), ps.PolicySetRefs...), ps.PolicyRefs...) {
+		res.Findings = append(res.Findings, Finding{Path: path, Construct: "PolicyReference", Message: "reference to " + strings.TrimSpace(ref) + " skipped, import the referenced document"})
+	}
+	reportIgnored(res, path, ps.Obligations, ps.Advice)
+	if len(ps.CombinerParams) > 0 {
+		res.Findings = append(res.Findings, Finding{Path: path, Construct: "CombinerParameters", Message: "ignored"})
+	}
+	targets = withTarget(targets, ps.Target)
+	for i := range ps.PolicySets {
+		im.importPolicySet(res, path, targets, &ps.PolicySets[i])
+	}
+	for i := range ps.Policies {
+		im.importPolicy(res, path, targets, &ps.Policies[i])
+	}
+}
+
+func (im *Importer) importPolicy(res *Result, parent string, targets []anyOf, p *policy) {
+	path := join(parent, p.ID)
+	checkCombining(res, path, "RuleCombiningAlgId", p.Combining)
+	reportIgnored(res, path, p.Obligations, p.Advice)
+	if len(p.Variables) > 0 {
+		res.Findings = append(res.Findings, Finding{Path: path, Construct: "VariableDefinition", Message: "rules referencing variables are skipped"})
+	}
+	targets = withTarget(targets, p.Target)
+	for i := range p.Rules {
+		im.importRule(res, path, targets, &p.Rules[i])
+	}
+}
+
+func (im *Importer) importRule(res *Result, parent string, targets []anyOf, r *rule) {
+	path := join(parent, r.ID)
+	if r.Effect != _effectPermit && r.Effect != _effectDeny {
+		res.Findings = append(res.Findings, Finding{Path: path, Construct: "Effect", Message: "unknown effect " + r.Effect})
+		return
+	}
+	reportIgnored(res, path, r.Obligations, r.Advice)
+	targets = withTarget(targets, r.Target)
+	if r.Condition == nil && im.importRBAC(res, r.Effect, targets) {
+		return
+	}
+	policy, err := im.abacPolicy(path, r, targets)
+	if err != nil {
+		message := err.Error()
+		if r.Effect == _effectDeny {
+			message += ", what the rule denied may now be allowed"
+		}
+		res.Findings = append(res.Findings, Finding{Path: path, Construct: "Rule", Message: message})
+		return
+	}
+	res.ABAC = append(res.ABAC, *policy)
+}
+
+// importRBAC adds the rule as role permissions or denies when its target only matches roles,
+// resources and actions with string-equal, denies may also match subject ids.
+func (im *Importer) importRBAC(res *Result, effect string, targets []anyOf) bool {
+	values := make(map[string][]string)
+	for _, group := range targets {
+		id, alternatives, ok := equalAlternatives(group)
+		if !ok || values[id] != nil {
+			return false
+		}
+		switch id {
+		case AttributeRole, AttributeResourceID, AttributeActionID:
+		case AttributeSubjectID:
+			if effect != _effectDeny {
+				return false
+			}
+		default:
+			return false
+		}
+		for _, v := range alternatives {
+			// values RBAC would read as resource or action patterns stay literal in ABAC.
+			if strings.ContainsAny(v, "*{}|") || strings.Contains(v, "/:") || strings.HasPrefix(v, ":") {
+				return false
+			}
+		}
+		values[id] = alternatives
+	}
+	subjects := values[AttributeRole]
+	if values[AttributeSubjectID] != nil {
+		if subjects != nil {
+			return false
+		}
+		subjects = values[AttributeSubjectID]
+	}
+	if subjects == nil {
+		return false
+	}
+	resources := values[AttributeResourceID]
+	if resources == nil {
+		resources = []string{"*"}
+	}
+	action := "*"
+	if values[AttributeActionID] != nil {
+		action = strings.Join(values[AttributeActionID], "|")
+	}
+	for _, subject := range subjects {
+		for _, resource := range resources {
+			rule := rbac.PolicyRule{Role: subject, Resource: resource, Action: action}
+			if effect == _effectPermit {
+				res.RBAC.Policies = append(res.RBAC.Policies, rule)
+			} else {
+				res.RBAC.Denies = append(res.RBAC.Denies, rule)
+			}
+		}
+	}
+	return true
+}
+
+// equalAlternatives returns the attribute and the values of an AnyOf of single string-equal matches on one attribute.
+func equalAlternatives(group anyOf) (string, []string, bool) {
+	var id string
+	values := make([]string, 0, len(group.AllOf))
+	for _, all := range group.AllOf {
+		if len(all.Matches) != 1 {
+			return "", nil, false
+		}
+		m := all.Matches[0]
+		if functionName(m.MatchID) != "string-equal" || m.Value == nil || m.Designator == nil || m.Selector != nil {
+			return "", nil, false
+		}
+		if id != "" && m.Designator.AttributeID != id {
+			return "", nil, false
+		}
+		id = m.Designator.AttributeID
+		values = append(values, strings.TrimSpace(m.Value.Value))
+	}
+	return id, values, id != ""
+}
+
+func (im *Importer) abacPolicy(path string, r *rule, targets []anyOf) (*abac.Policy, error) {
+	p := &abac.Policy{ID: path, Description: strings.TrimSpace(r.Description), Effect: abac.EffectAllow}
+	if r.Effect == _effectDeny {
+		p.Effect = abac.EffectDeny
+	}
+	conditions := make([]string, 0, len(targets)+1)
+	for _, group := range targets {
+		// a lone AnyOf of actions becomes the actions of the policy.
+		if id, actions, ok := equalAlternatives(group); ok && id == AttributeActionID && p.Actions == nil {
+			p.Actions = actions
+			continue
+		}
+		expr, err := im.anyOf(group)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, expr)
+	}
+	if r.Condition != nil {
+		if len(r.Condition.Children) != 1 {
+			return nil, errors.New("condition must hold one expression")
+		}
+		expr, bag, err := im.expr(&r.Condition.Children[0])
+		if err != nil {
+			return nil, err
+		}
+		if bag {
+			return nil, errors.New("condition must be a boolean, not a bag")
+		}
+		conditions = append(conditions, expr)
+	}
+	p.Condition = "true"
+	if len(conditions) > 0 {
+		// the parts are atoms, conjunctions or parenthesized.
+		p.Condition = strings.Join(conditions, " && ")
+	}
+	return p, nil
+}
+
+func (im *Importer) anyOf(group anyOf) (string, error) {
+	alternatives := make([]string, 0, len(group.AllOf))
+	for _, all := range group.AllOf {
+		matches := make([]string, 0, len(all.Matches))
+		for i := range all.Matches {
+			expr, err := im.match(&all.Matches[i])
+			if err != nil {
+				return "", err
+			}
+			matches = append(matches, expr)
+		}
+		alternatives = append(alternatives, strings.Join(matches, " && "))
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return "(" + strings.Join(alternatives, ") || (") + ")", nil
+}
+
+// match translates a match: the function applied to the value and each value of the attribute
+// bag, true when it holds for one of them.
+func (im *Importer) match(m *match) (string, error) {
+	if m.Selector != nil {
+		return "", errors.New("AttributeSelector is not supported")
+	}
+	if m.Value == nil || m.Designator == nil {
+		return "", errors.New("match without value or designator")
+	}
+	value, err := literal(m.Value.DataType, m.Value.Value)
+	if err != nil {
+		return "", err
+	}
+	attr, err := im.attribute(m.Designator)
+	if err != nil {
+		return "", err
+	}
+	name := functionName(m.MatchID)
+	if !attr.Bag {
+		return function(name, []string{value, attr.Expr})
+	}
+	if strings.HasSuffix(name, "-equal") {
+		return value + " in " + attr.Expr, nil
+	}
+	expr, err := function(name, []string{value, "v"})
+	if err != nil {
+		return "", err
+	}
+	return attr.Expr + ".exists(v, " + expr + ")", nil
+}
+
+// expr translates a condition expression and reports whether it is a bag.
+func (im *Importer) expr(n *node) (string, bool, error) {
+	switch n.XMLName.Local {
+	case "AttributeValue":
+		value, err := literal(n.attr("DataType"), n.Text)
+		return value, false, err
+	case "AttributeDesignator":
+		attr, err := im.attribute(&designator{Category: n.attr("Category"), AttributeID: n.attr("AttributeId"), DataType: n.attr("DataType")})
+		if err != nil {
+			return "", false, err
+		}
+		// bags of single valued attributes hold the one value.
+		return attr.Expr, attr.Bag, nil
+	case "Apply":
+	default:
+		return "", false, fmt.Errorf("%s is not supported", n.XMLName.Local)
+	}
+	name := functionName(n.attr("FunctionId"))
+	args := make([]string, 0, len(n.Children))
+	bags := make([]bool, 0, len(n.Children))
+	for i := range n.Children {
+		if n.Children[i].XMLName.Local == "Description" {
+			continue
+		}
+		arg, bag, err := im.expr(&n.Children[i])
+		if err != nil {
+			return "", false, err
+		}
+		args, bags = append(args, arg), append(bags, bag)
+	}
+	switch {
+	case strings.HasSuffix(name, "-one-and-only"):
+		if len(args) != 1 || bags[0] {
+			return "", false, fmt.Errorf("%s of a multi valued attribute is not supported", name)
+		}
+		return args[0], false, nil
+	case strings.HasSuffix(name, "-bag-size"):
+		if len(args) != 1 {
+			return "", false, fmt.Errorf("%s takes one bag", name)
+		}
+		if !bags[0] {
+			return "1", false, nil
+		}
+		return "size(" + args[0] + ")", false, nil
+	case strings.HasSuffix(name, "-is-in"):
+		if len(args) != 2 || bags[0] {
+			return "", false, fmt.Errorf("%s takes a value and a bag", name)
+		}
+		if !bags[1] {
+			return "(" + args[0] + " == " + args[1] + ")", false, nil
+		}
+		return "(" + args[0] + " in " + args[1] + ")", false, nil
+	}
+	for _, bag := range bags {
+		if bag {
+			return "", false, fmt.Errorf("%s of a multi valued attribute is not supported", name)
+		}
+	}
+	expr, err := function(name, args)
+	return expr, false, err
+}
+
+// function translates the scalar functions.
+func function(name string, args []string) (string, error) {
+	binary := func(op string) (string, error) {
+		if len(args) != 2 {
+			return "", fmt.Errorf("%s takes two arguments", name)
+		}
+		return "(" + args[0] + " " + op + " " + args[1] + ")", nil
+	}
+	switch name {
+	case "and", "or":
+		if len(args) == 0 {
+			return strconv.FormatBool(name == "and"), nil
+		}
+		op := " && "
+		if name == "or" {
+			op = " || "
+		}
+		return "(" + strings.Join(args, op) + ")", nil
+	case "not":
+		if len(args) != 1 {
+			return "", fmt.Errorf("%s takes one argument", name)
+		}
+		return "!(" + args[0] + ")", nil
+	case "string-starts-with", "string-ends-with", "string-contains":
+		// the first argument is looked for in the second.
+		if len(args) != 2 {
+			return "", fmt.Errorf("%s takes two arguments", name)
+		}
+		method := map[string]string{"string-starts-with": "startsWith", "string-ends-with": "endsWith", "string-contains": "contains"}[name]
+		return args[1] + "." + method + "(" + args[0] + ")", nil
+	}
+	i := strings.IndexByte(name, '-')
+	if i < 0 {
+		return "", fmt.Errorf("function %s is not supported", name)
+	}
+	switch typ, op := name[:i], name[i+1:]; {
+	case op == "equal" && (typ == "string" || typ == "integer" || typ == "double" || typ == "boolean" || typ == "anyURI"):
+		return binary("==")
+	case typ != "integer" && typ != "double" && typ != "string":
+	case op == "greater-than":
+		return binary(">")
+	case op == "greater-than-or-equal":
+		return binary(">=")
+	case op == "less-than":
+		return binary("<")
+	case op == "less-than-or-equal":
+		return binary("<=")
+	}
+	return "", fmt.Errorf("function %s is not supported", name)
+}
+
+func (im *Importer) attribute(d *designator) (Attribute, error) {
+	if attr, ok := im.attributes[d.AttributeID]; ok {
+		return attr, nil
+	}
+	key := d.AttributeID[strings.LastIndexAny(d.AttributeID, ":/#")+1:]
+	switch d.Category {
+	case _categorySubject:
+		return Attribute{Expr: "subject[" + strconv.Quote(key) + "]"}, nil
+	case _categoryResource:
+		return Attribute{Expr: "resource[" + strconv.Quote(key) + "]"}, nil
+	}
+	return Attribute{}, fmt.Errorf("attribute %s of category %s is not mapped", d.AttributeID, d.Category)
+}
+
+func literal(dataType, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch dataType {
+	case _typeString, _typeAnyURI, "":
+		return strconv.Quote(value), nil
+	case _typeInteger:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "", fmt.Errorf("invalid integer %q", value)
+		}
+		return value, nil
+	case _typeDouble:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid double %q", value)
+		}
+		formatted := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.ContainsAny(formatted, ".eE") {
+			// CEL does not compare doubles with ints.
+			formatted += ".0"
+		}
+		return formatted, nil
+	case _typeBoolean:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid boolean %q", value)
+		}
+		return strconv.FormatBool(b), nil
+	}
+	return "", fmt.Errorf("data type %s is not supported", dataType)
+}
+
+// functionName strips the URN prefix of XACML 1.0 to 3.0 function ids.
+func functionName(id string) string {
+	return id[strings.LastIndexByte(id, ':')+1:]
+}
+
+func checkCombining(res *Result, path, attr, id string) {
+	switch functionName(id) {
+	case "deny-overrides", "ordered-deny-overrides":
+		return
+	}
+	res.Findings = append(res.Findings, Finding{Path: path, Construct: attr, Message: functionName(id) + " approximated with deny-overrides, converted anyway"})
+}
+
+func reportIgnored(res *Result, path string, obligations, advice *node) {
+	if obligations != nil {
+		res.Findings = append(res.Findings, Finding{Path: path, Construct: "ObligationExpressions", Message: "obligations are not enforced, converted anyway"})
+	}
+	if advice != nil {
+		res.Findings = append(res.Findings, Finding{Path: path, Construct: "AdviceExpressions", Message: "advice is dropped, converted anyway"})
+	}
+}
+
+// withTarget returns the AnyOf of the enclosing targets and t, all of them must match.
+func withTarget(targets []anyOf, t *target) []anyOf {
+	if t == nil || len(t.AnyOf) == 0 {
+		return targets
+	}
+	return append(append(make([]anyOf, 0, len(targets)+len(t.AnyOf)), targets...), t.AnyOf...)
+}
+
+func join(parent, id string) string {
+	if parent == "" {
+		return id
+	}
+	return parent + "/" + id
+}
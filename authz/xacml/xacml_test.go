@@ -0,0 +1,205 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xacml
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tkeel-io/security/authz/abac"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/authz/rbac"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const _policySet = `<?xml version="1.0" encoding="UTF-8"?>
+<PolicySet xmlns="urn:oasis:names:tc:xacml:3.0:core:schema:wd-17" PolicySetId="devices"
+    PolicyCombiningAlgId="urn:oasis:names:tc:xacml:3.0:policy-combining-algorithm:deny-overrides">
+  <Target/>
+  <Policy PolicyId="operators" RuleCombiningAlgId="urn:oasis:names:tc:xacml:3.0:rule-combining-algorithm:deny-overrides">
+    <Target>
+      <AnyOf>
+        <AllOf>
+          <Match MatchId="urn:oasis:names:tc:xacml:1.0:function:string-equal">
+            <AttributeValue DataType="http://www.w3.org/2001/XMLSchema#string">devices</AttributeValue>
+            <AttributeDesignator Category="urn:oasis:names:tc:xacml:3.0:attribute-category:resource"
+                AttributeId="urn:oasis:names:tc:xacml:1.0:resource:resource-id" DataType="http://www.w3.org/2001/XMLSchema#string"/>
+          </Match>
+        </AllOf>
+      </AnyOf>
+    </Target>
+    <Rule RuleId="operate" Effect="Permit">
+      <Target>
+        <AnyOf>
+          <AllOf>
+            <Match MatchId="urn:oasis:names:tc:xacml:1.0:function:string-equal">
+              <AttributeValue DataType="http://www.w3.org/2001/XMLSchema#string">operator</AttributeValue>
+              <AttributeDesignator Category="urn:oasis:names:tc:xacml:1.0:subject-category:access-subject"
+                  AttributeId="urn:oasis:names:tc:xacml:2.0:subject:role" DataType="http://www.w3.org/2001/XMLSchema#string"/>
+            </Match>
+          </AllOf>
+        </AnyOf>
+        <AnyOf>
+          <AllOf>
+            <Match MatchId="urn:oasis:names:tc:xacml:1.0:function:string-equal">
+              <AttributeValue DataType="http://www.w3.org/2001/XMLSchema#string">read</AttributeValue>
+              <AttributeDesignator Category="urn:oasis:names:tc:xacml:3.0:attribute-category:action"
+                  AttributeId="urn:oasis:names:tc:xacml:1.0:action:action-id" DataType="http://www.w3.org/2001/XMLSchema#string"/>
+            </Match>
+          </AllOf>
+          <AllOf>
+            <Match MatchId="urn:oasis:names:tc:xacml:1.0:function:string-equal">
+              <AttributeValue DataType="http://www.w3.org/2001/XMLSchema#string">write</AttributeValue>
+              <AttributeDesignator Category="urn:oasis:names:tc:xacml:3.0:attribute-category:action"
+                  AttributeId="urn:oasis:names:tc:xacml:1.0:action:action-id" DataType="http://www.w3.org/2001/XMLSchema#string"/>
+            </Match>
+          </AllOf>
+        </AnyOf>
+      </Target>
+    </Rule>
+    <Rule RuleId="cleared" Effect="Permit">
+      <Description>viewers cleared above level 2</Description>
+      <Target>
+        <AnyOf>
+          <AllOf>
+            <Match MatchId="urn:oasis:names:tc:xacml:1.0:function:string-equal">
+              <AttributeValue DataType="http://www.w3.org/2001/XMLSchema#string">viewer</AttributeValue>
+              <AttributeDesignator Category="urn:oasis:names:tc:xacml:1.0:subject-category:access-subject"
+                  AttributeId="urn:oasis:names:tc:xacml:2.0:subject:role" DataType="http://www.w3.org/2001/XMLSchema#string"/>
+            </Match>
+          </AllOf>
+        </AnyOf>
+      </Target>
+      <Condition>
+        <Apply FunctionId="urn:oasis:names:tc:xacml:1.0:function:integer-greater-than">
+          <Apply FunctionId="urn:oasis:names:tc:xacml:1.0:function:integer-one-and-only">
+            <AttributeDesignator Category="urn:oasis:names:tc:xacml:1.0:subject-category:access-subject"
+                AttributeId="urn:example:clearance" DataType="http://www.w3.org/2001/XMLSchema#integer"/>
+          </Apply>
+          <AttributeValue DataType="http://www.w3.org/2001/XMLSchema#integer">2</AttributeValue>
+        </Apply>
+      </Condition>
+    </Rule>
+    <Rule RuleId="selector" Effect="Deny">
+      <Target>
+        <AnyOf>
+          <AllOf>
+            <Match MatchId="urn:oasis:names:tc:xacml:1.0:function:string-equal">
+              <AttributeValue DataType="http://www.w3.org/2001/XMLSchema#string">x</AttributeValue>
+              <AttributeSelector Path="//status" DataType="http://www.w3.org/2001/XMLSchema#string"
+                  Category="urn:oasis:names:tc:xacml:3.0:attribute-category:resource"/>
+            </Match>
+          </AllOf>
+        </AnyOf>
+      </Target>
+    </Rule>
+  </Policy>
+  <Policy PolicyId="owners" RuleCombiningAlgId="urn:oasis:names:tc:xacml:3.0:rule-combining-algorithm:permit-overrides">
+    <Rule RuleId="banned" Effect="Deny">
+      <Target>
+        <AnyOf>
+          <AllOf>
+            <Match MatchId="urn:oasis:names:tc:xacml:1.0:function:string-equal">
+              <AttributeValue DataType="http://www.w3.org/2001/XMLSchema#string">mallory</AttributeValue>
+              <AttributeDesignator Category="urn:oasis:names:tc:xacml:1.0:subject-category:access-subject"
+                  AttributeId="urn:oasis:names:tc:xacml:1.0:subject:subject-id" DataType="http://www.w3.org/2001/XMLSchema#string"/>
+            </Match>
+          </AllOf>
+        </AnyOf>
+      </Target>
+    </Rule>
+    <Rule RuleId="owner" Effect="Permit">
+      <Target>
+        <AnyOf>
+          <AllOf>
+            <Match MatchId="urn:oasis:names:tc:xacml:1.0:function:string-equal">
+              <AttributeValue DataType="http://www.w3.org/2001/XMLSchema#string">read</AttributeValue>
+              <AttributeDesignator Category="urn:oasis:names:tc:xacml:3.0:attribute-category:action"
+                  AttributeId="urn:oasis:names:tc:xacml:1.0:action:action-id" DataType="http://www.w3.org/2001/XMLSchema#string"/>
+            </Match>
+          </AllOf>
+        </AnyOf>
+      </Target>
+      <Condition>
+        <Apply FunctionId="urn:oasis:names:tc:xacml:1.0:function:string-equal">
+          <Apply FunctionId="urn:oasis:names:tc:xacml:1.0:function:string-one-and-only">
+            <AttributeDesignator Category="urn:oasis:names:tc:xacml:3.0:attribute-category:resource"
+                AttributeId="urn:example:owner" DataType="http://www.w3.org/2001/XMLSchema#string"/>
+          </Apply>
+          <Apply FunctionId="urn:oasis:names:tc:xacml:1.0:function:string-one-and-only">
+            <AttributeDesignator Category="urn:oasis:names:tc:xacml:1.0:subject-category:access-subject"
+                AttributeId="urn:oasis:names:tc:xacml:1.0:subject:subject-id" DataType="http://www.w3.org/2001/XMLSchema#string"/>
+          </Apply>
+        </Apply>
+      </Condition>
+      <ObligationExpressions>
+        <ObligationExpression ObligationId="urn:example:log" FulfillOn="Permit"/>
+      </ObligationExpressions>
+    </Rule>
+  </Policy>
+  <PolicySetIdReference>urn:example:legacy</PolicySetIdReference>
+</PolicySet>`
+
+func TestImport(t *testing.T) {
+	res, err := NewImporter().Import(strings.NewReader(_policySet))
+	assert.NoError(t, err)
+	assert.Equal(t, []rbac.PolicyRule{{Role: "operator", Resource: "devices", Action: "read|write"}}, res.RBAC.Policies)
+	assert.Equal(t, []rbac.PolicyRule{{Role: "mallory", Resource: "*", Action: "*"}}, res.RBAC.Denies)
+
+	findings := make([]string, 0, len(res.Findings))
+	for _, f := range res.Findings {
+		findings = append(findings, f.Path+" "+f.Construct)
+	}
+	assert.Equal(t, []string{
+		"devices PolicyReference",
+		"devices/operators/selector Rule",
+		"devices/owners RuleCombiningAlgId",
+		"devices/owners/owner ObligationExpressions",
+	}, findings)
+	assert.Contains(t, res.Findings[1].Message, "may now be allowed")
+
+	engine, err := abac.NewEngine()
+	assert.NoError(t, err)
+	assert.Len(t, res.ABAC, 2)
+	for _, p := range res.ABAC {
+		assert.NoError(t, engine.AddPolicy(p), p.Condition)
+	}
+	assert.Equal(t, "viewers cleared above level 2", res.ABAC[0].Description)
+	assert.Equal(t, []string{"read"}, res.ABAC[1].Actions)
+
+	tests := []struct {
+		name     string
+		subject  map[string]interface{}
+		resource map[string]interface{}
+		action   string
+		want     authorizer.Decision
+	}{
+		{"cleared viewer", map[string]interface{}{"roles": []string{"viewer"}, "clearance": 3}, map[string]interface{}{"id": "devices"}, "read", authorizer.DecisionAllow},
+		{"viewer", map[string]interface{}{"roles": []string{"viewer"}, "clearance": 1}, map[string]interface{}{"id": "devices"}, "read", authorizer.DecisionNoOpinion},
+		{"cleared viewer of other resource", map[string]interface{}{"roles": []string{"viewer"}, "clearance": 3}, map[string]interface{}{"id": "spaces"}, "read", authorizer.DecisionNoOpinion},
+		{"owner", map[string]interface{}{"sub": "alice"}, map[string]interface{}{"owner": "alice"}, "read", authorizer.DecisionAllow},
+		{"owner writes", map[string]interface{}{"sub": "alice"}, map[string]interface{}{"owner": "alice"}, "write", authorizer.DecisionNoOpinion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, err := engine.Evaluate(&abac.Request{Subject: tt.subject, Resource: tt.resource, Action: tt.action})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, decision)
+		})
+	}
+
+	_, err = NewImporter().Import(strings.NewReader(`<Request/>`))
+	assert.ErrorIs(t, err, ErrInvalidDocument)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cache the caches shared by token verification, JWKS fetching, userinfo lookups and
+// policy checks: an in-process LRU bounded in entries and a Redis cache shared by replicas.
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound the key is not cached or expired.
+	ErrNotFound = errors.New("cache miss")
+	// ErrPurgeUnsupported the cache can not drop all its entries.
+	ErrPurgeUnsupported = errors.New("cache does not support purge")
+)
+
+// Cache stores values under keys for a limited time.
+type Cache interface {
+	// Get returns the value of key, ErrNotFound when it is absent or expired.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value under key for ttl, ttl <= 0 keeps it until evicted.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete drops key, deleting an absent key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Purger is a Cache that can drop all its entries.
+type Purger interface {
+	Purge(ctx context.Context) error
+}
+
+// Stats counters of a cache.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// HitRate fraction of lookups served from the cache.
+func (s Stats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// Purge drops all entries of c, ErrPurgeUnsupported unless it is a Purger.
+func Purge(ctx context.Context, c Cache) error {
+	if p, ok := c.(Purger); ok {
+		return p.Purge(ctx)
+	}
+	return ErrPurgeUnsupported
+}
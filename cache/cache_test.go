@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	ctx := context.Background()
+	for name, c := range map[string]Cache{"lru": NewLRU(0), "redis": NewRedis(client, "")} {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.Get(ctx, "a")
+			assert.ErrorIs(t, err, ErrNotFound)
+			assert.NoError(t, c.Set(ctx, "a", []byte("1"), time.Minute))
+			assert.NoError(t, c.Set(ctx, "b", []byte("2"), 0))
+			value, err := c.Get(ctx, "a")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("1"), value)
+
+			assert.NoError(t, c.Delete(ctx, "a"))
+			assert.NoError(t, c.Delete(ctx, "a"))
+			_, err = c.Get(ctx, "a")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			assert.NoError(t, Purge(ctx, c))
+			_, err = c.Get(ctx, "b")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			stats := c.(interface{ Stats() Stats }).Stats()
+			assert.Equal(t, uint64(1), stats.Hits)
+			assert.Equal(t, uint64(3), stats.Misses)
+			assert.Equal(t, 0.25, stats.HitRate())
+		})
+	}
+}
+
+type noPurge struct{ Cache }
+
+func TestPurgeUnsupported(t *testing.T) {
+	assert.ErrorIs(t, Purge(context.Background(), noPurge{NewLRU(0)}), ErrPurgeUnsupported)
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRU(2)
+	assert.NoError(t, c.Set(ctx, "a", []byte("1"), 0))
+	assert.NoError(t, c.Set(ctx, "b", []byte("2"), 0))
+	_, err := c.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.NoError(t, c.Set(ctx, "c", []byte("3"), 0))
+	assert.Equal(t, 2, c.Len())
+	_, err = c.Get(ctx, "b")
+	assert.ErrorIs(t, err, ErrNotFound, "least recently used entry evicted")
+
+	assert.NoError(t, c.Set(ctx, "d", []byte("4"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	_, err = c.Get(ctx, "d")
+	assert.ErrorIs(t, err, ErrNotFound, "expired entry")
+}
+
+func TestRedisExpiry(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	ctx := context.Background()
+	c := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "test:")
+	assert.NoError(t, c.Set(ctx, "a", []byte("1"), time.Minute))
+	assert.True(t, mr.Exists("test:a"))
+	mr.FastForward(2 * time.Minute)
+	_, err = c.Get(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
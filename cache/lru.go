@@ -0,0 +1,132 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_ Cache  = &LRU{}
+	_ Purger = &LRU{}
+)
+
+const _defaultLRUSize = 10000
+
+type lruEntry struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+// LRU in-process cache, least recently used entries are evicted once it holds size entries.
+type LRU struct {
+	size    int
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+// NewLRU returns an LRU of size entries, size <= 0 defaults to 10000.
+func NewLRU(size int) *LRU {
+	if size <= 0 {
+		size = _defaultLRUSize
+	}
+	return &LRU{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Get(_ context.Context, key string) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	el, ok := c.entries[key]
+	if ok {
+		entry := el.Value.(*lruEntry)
+		if entry.expireAt.IsZero() || time.Now().Before(entry.expireAt) {
+			c.lru.MoveToFront(el)
+			atomic.AddUint64(&c.hits, 1)
+			return entry.value, nil
+		}
+		c.remove(el)
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return nil, ErrNotFound
+}
+
+func (c *LRU) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*lruEntry)
+		entry.value, entry.expireAt = value, expireAt
+		c.lru.MoveToFront(el)
+		return nil
+	}
+	c.entries[key] = c.lru.PushFront(&lruEntry{key: key, value: value, expireAt: expireAt})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+	return nil
+}
+
+func (c *LRU) Delete(_ context.Context, key string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	return nil
+}
+
+func (c *LRU) Purge(_ context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	return nil
+}
+
+// Len number of entries, expired ones included until they are looked up or evicted.
+func (c *LRU) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+// Stats returns the hit and miss counters.
+func (c *LRU) Stats() Stats {
+	return Stats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Size:   c.Len(),
+	}
+}
+
+func (c *LRU) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	_ Cache  = &Redis{}
+	_ Purger = &Redis{}
+)
+
+const (
+	_defaultRedisPrefix = "cache:"
+	_purgeBatch         = 500
+)
+
+// Redis cache shared by all replicas, its size is bounded by the maxmemory policy of the server.
+type Redis struct {
+	client redis.UniversalClient
+	prefix string
+	hits   uint64
+	misses uint64
+}
+
+// NewRedis returns a Redis cache keeping the entries under prefix, default to cache:.
+func NewRedis(client redis.UniversalClient, prefix string) *Redis {
+	if prefix == "" {
+		prefix = _defaultRedisPrefix
+	}
+	return &Redis{client: client, prefix: prefix}
+}
+
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get cache entry %w", err)
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return value, nil
+}
+
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0
+	}
+	if err := c.client.Set(ctx, c.prefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("set cache entry %w", err)
+	}
+	return nil
+}
+
+func (c *Redis) Delete(ctx context.Context, key string) error {
+	if err := c.client.Del(ctx, c.prefix+key).Err(); err != nil {
+		return fmt.Errorf("delete cache entry %w", err)
+	}
+	return nil
+}
+
+// Purge deletes the keys under the prefix, scanning them in batches.
+func (c *Redis) Purge(ctx context.Context) error {
+	var cursor uint64
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, c.prefix+"*", _purgeBatch).Result()
+		if err != nil {
+			return fmt.Errorf("scan cache entries %w", err)
+		}
+		if len(keys) > 0 {
+			if err = c.client.Del(ctx, keys...).Err(); err != nil {
+				return fmt.Errorf("delete cache entries %w", err)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// Stats returns the hit and miss counters of this process, Size is not tracked.
+func (c *Redis) Stats() Stats {
+	return Stats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tkeel-io/security/authn/token"
+)
+
+const _rsaKeyBits = 2048
+
+// keyPair a generated key, Secret for symmetric keys, the PEM encoded PrivateKey and PublicKey
+// or, for paseto.public, the base64 encoded seed in Secret and public key in PublicKey.
+type keyPair struct {
+	Type       string `json:"type"`
+	Secret     string `json:"secret,omitempty"`
+	PrivateKey string `json:"private_key,omitempty"`
+	PublicKey  string `json:"public_key,omitempty"`
+}
+
+func runKeygen(_ context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("keygen")
+	typ := fs.String("type", "hmac", "key type: hmac, paseto.local, paseto.public, RS256, ES256 or EdDSA")
+	size := fs.Int("bytes", 32, "size of hmac keys in bytes")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	key, err := generateKey(*typ, *size)
+	if err != nil {
+		return err
+	}
+	return printJSON(out, key)
+}
+
+func generateKey(typ string, size int) (*keyPair, error) {
+	key := &keyPair{Type: typ}
+	switch strings.ToLower(typ) {
+	case "hmac", token.FormatPASETOLocal:
+		if strings.ToLower(typ) == token.FormatPASETOLocal {
+			size = 32
+		}
+		if size < 32 {
+			return nil, fmt.Errorf("%w: hmac keys need at least 32 bytes", errUsage)
+		}
+		secret := make([]byte, size)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		key.Secret = base64.StdEncoding.EncodeToString(secret)
+		return key, nil
+	case token.FormatPASETOPublic:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key.Secret = base64.StdEncoding.EncodeToString(private.Seed())
+		key.PublicKey = base64.StdEncoding.EncodeToString(public)
+		return key, nil
+	}
+	var signer crypto.Signer
+	var err error
+	switch typ {
+	case token.AlgorithmRS256:
+		signer, err = rsa.GenerateKey(rand.Reader, _rsaKeyBits)
+	case token.AlgorithmES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case token.AlgorithmEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%w: unknown key type %s", errUsage, typ)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generate %s key %w", typ, err)
+	}
+	private, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.PrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}))
+	key.PublicKey = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	return key, nil
+}
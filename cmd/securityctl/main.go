@@ -0,0 +1,144 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command securityctl is the operator tool of the security library: it generates signing keys,
+// inspects and verifies tokens, tests OIDC providers end to end and manages the policies and
+// API keys in their stores, e.g.
+//
+//	securityctl keygen -type ES256
+//	securityctl token inspect eyJhbGciOi...
+//	securityctl token verify -config security.yaml eyJhbGciOi...
+//	securityctl oidc test -issuer https://idp.example.com -client-id tkeel
+//	securityctl policy export -config security.yaml -tenant t1 -format csv
+//	securityctl apikey create -config security.yaml -tenant t1 -owner svc -scopes devices:read
+//
+// The stores and the token settings are read from the configuration file, see package config,
+// with the sections token, database, casbin and apikey.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/casbin"
+	"github.com/tkeel-io/security/config"
+	"github.com/tkeel-io/security/gormdb"
+	"github.com/tkeel-io/security/secrets"
+)
+
+// errUsage the command line is malformed, the usage has been printed.
+var errUsage = errors.New("usage")
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string, out io.Writer) error
+}
+
+var _commands = []command{
+	{"keygen", "generate a signing key", runKeygen},
+	{"token inspect", "decode a jwt without verifying it", runTokenInspect},
+	{"token verify", "verify a token with the token settings", runTokenVerify},
+	{"oidc test", "discover an OIDC provider, fetch its keys and build a login URL", runOIDCTest},
+	{"policy export", "export the policies of a tenant", runPolicyExport},
+	{"policy import", "import the policies of a tenant", runPolicyImport},
+	{"policy check", "explain a permission check", runPolicyCheck},
+	{"apikey create", "create an API key", runAPIKeyCreate},
+	{"apikey list", "list the API keys of a tenant", runAPIKeyList},
+	{"apikey revoke", "revoke an API key", runAPIKeyRevoke},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "securityctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	for _, c := range _commands {
+		words := strings.Fields(c.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == c.name {
+			return c.run(ctx, args[len(words):], out)
+		}
+	}
+	usage(os.Stderr)
+	return errUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: securityctl <command> [flags]")
+	fmt.Fprintln(w)
+	for _, c := range _commands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "run securityctl <command> -h for the flags of a command.")
+}
+
+// newFlagSet returns the flag set of the command name, parse errors are returned.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("securityctl "+name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return errUsage
+		}
+		return fmt.Errorf("%w: %s", errUsage, err)
+	}
+	return nil
+}
+
+// fileConfig the sections of the configuration file the commands use, absent sections are nil.
+type fileConfig struct {
+	Token    *token.Config     `yaml:"token"`
+	Database *gormdb.DBConfig  `yaml:"database"`
+	Casbin   *casbin.MysqlConf `yaml:"casbin"`
+	APIKey   *apikey.Config    `yaml:"apikey"`
+}
+
+func loadConfig(ctx context.Context, path string) (*fileConfig, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%w: -config required", errUsage)
+	}
+	var conf fileConfig
+	if err := config.NewLoader(secrets.NewResolver(0)).Load(ctx, path, &conf); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
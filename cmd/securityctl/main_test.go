@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/idprovider/oidc/oidctest"
+	"github.com/tkeel-io/security/authn/token"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeygen(t *testing.T) {
+	tests := []struct {
+		typ        string
+		secret     bool
+		privateKey bool
+	}{
+		{"hmac", true, false},
+		{"paseto.local", true, false},
+		{"paseto.public", true, false},
+		{"RS256", false, true},
+		{"ES256", false, true},
+		{"EdDSA", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			var out bytes.Buffer
+			assert.NoError(t, run(context.Background(), []string{"keygen", "-type", tt.typ}, &out))
+			var key keyPair
+			assert.NoError(t, json.Unmarshal(out.Bytes(), &key))
+			assert.Equal(t, tt.secret, key.Secret != "")
+			assert.Equal(t, tt.privateKey, key.PrivateKey != "")
+		})
+	}
+	assert.ErrorIs(t, run(context.Background(), []string{"keygen", "-type", "DES"}, &bytes.Buffer{}), errUsage)
+}
+
+func TestToken(t *testing.T) {
+	m, err := token.NewJWTManager(&token.Config{Issuer: "tkeel", SigningKey: "secret"})
+	assert.NoError(t, err)
+	raw, err := m.Issue(&token.Claims{Subject: "u-1"})
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, run(context.Background(), []string{"token", "inspect", raw}, &out))
+	var t1 inspected
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &t1))
+	assert.Equal(t, "HS256", t1.Header["alg"])
+	assert.Equal(t, "u-1", t1.Claims["sub"])
+	assert.NotEmpty(t, t1.Expires)
+
+	path := filepath.Join(t.TempDir(), "security.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("token:\n  issuer: tkeel\n  signingKey: secret\n"), 0o600))
+	out.Reset()
+	assert.NoError(t, run(context.Background(), []string{"token", "verify", "-config", path, raw}, &out))
+	assert.Contains(t, out.String(), `"sub": "u-1"`)
+
+	assert.ErrorIs(t, run(context.Background(), []string{"token", "verify", "-config", path, raw + "x"}, &out), token.ErrInvalidToken)
+	assert.ErrorIs(t, run(context.Background(), []string{"token", "inspect", "opaque"}, &out), token.ErrInvalidToken)
+}
+
+func TestOIDCTest(t *testing.T) {
+	op := oidctest.NewServer("tkeel")
+	defer op.Close()
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"oidc", "test", "-issuer", op.Issuer, "-client-id", "tkeel",
+		"-redirect-url", "https://rp.example/cb"}, &out)
+	assert.NoError(t, err)
+	var report oidcReport
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, op.Issuer+oidctest.PathToken, report.TokenURL)
+	assert.Contains(t, report.LoginURL, op.Issuer+oidctest.PathAuthorize)
+	assert.Equal(t, 1, op.Hits(oidctest.PathJWKS))
+}
+
+func TestUnknownCommand(t *testing.T) {
+	assert.ErrorIs(t, run(context.Background(), []string{"token", "mint"}, &bytes.Buffer{}), errUsage)
+}
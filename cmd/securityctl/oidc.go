@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tkeel-io/security/authn/idprovider/oidc"
+	"github.com/tkeel-io/security/secrets"
+	"github.com/tkeel-io/security/utils"
+)
+
+// oidcReport the result of the checks of an OIDC provider.
+type oidcReport struct {
+	Issuer      string `json:"issuer"`
+	AuthURL     string `json:"auth_url"`
+	TokenURL    string `json:"token_url"`
+	UserInfoURL string `json:"user_info_url,omitempty"`
+	JWKSURL     string `json:"jwks_url"`
+	// LoginURL the dry run login redirect, no request is sent to it.
+	LoginURL string `json:"login_url"`
+}
+
+func runOIDCTest(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("oidc test")
+	issuer := fs.String("issuer", "", "issuer URL of the provider")
+	clientID := fs.String("client-id", "", "client id registered at the provider")
+	clientSecret := fs.String("client-secret", "", "client secret, may be a secret reference")
+	redirectURL := fs.String("redirect-url", "", "redirect URL of the login")
+	scopes := fs.String("scopes", "", "comma separated scopes requested besides openid")
+	insecure := fs.Bool("insecure-skip-verify", false, "skip the TLS certificate checks of the provider")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	secret, err := secrets.NewResolver(0).Resolve(ctx, *clientSecret)
+	if err != nil {
+		return err
+	}
+	opts := []oidc.Option{oidc.WithClientSecret(secret), oidc.WithRedirectURL(*redirectURL)}
+	if *scopes != "" {
+		opts = append(opts, oidc.WithScopes(strings.Split(*scopes, ",")...))
+	}
+	if *insecure {
+		opts = append(opts, oidc.WithInsecureSkipVerify())
+	}
+	ctx, cancel := utils.WithTimeout(ctx, utils.DefaultHTTPTimeout)
+	defer cancel()
+	p, err := oidc.NewOIDCProvider(ctx, *issuer, *clientID, opts...)
+	if err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+	if err = p.Test(ctx); err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	state, err := utils.RandBase64String(16)
+	if err != nil {
+		return err
+	}
+	nonce, err := utils.RandBase64String(16)
+	if err != nil {
+		return err
+	}
+	return printJSON(out, &oidcReport{
+		Issuer:      p.Issuer,
+		AuthURL:     p.Endpoint.AuthURL,
+		TokenURL:    p.Endpoint.TokenURL,
+		UserInfoURL: p.Endpoint.UserInfoURL,
+		JWKSURL:     p.Endpoint.JWKSURL,
+		LoginURL:    p.AuthCodeURL(state, nonce),
+	})
+}
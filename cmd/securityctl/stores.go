@@ -0,0 +1,233 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/authz/casbin"
+	"github.com/tkeel-io/security/authz/rbac"
+	"github.com/tkeel-io/security/gormdb"
+)
+
+func roleManager(ctx context.Context, path string) (rbac.RoleMgr, error) {
+	conf, err := loadConfig(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Casbin == nil {
+		return nil, fmt.Errorf("%w: no casbin section in %s", errUsage, path)
+	}
+	enforcer, err := casbin.NewRBACOperator(conf.Casbin)
+	if err != nil {
+		return nil, err
+	}
+	return rbac.NewRoleOperator(enforcer), nil
+}
+
+func runPolicyExport(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("policy export")
+	path := fs.String("config", "", "configuration file with the casbin section")
+	tenant := fs.String("tenant", "", "tenant id")
+	format := fs.String("format", "json", "output format, json or csv")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	roles, err := roleManager(ctx, *path)
+	if err != nil {
+		return err
+	}
+	tp, err := roles.Export(*tenant)
+	if err != nil {
+		return err
+	}
+	if *format == "csv" {
+		return rbac.EncodeCSV(out, tp)
+	}
+	return printJSON(out, tp)
+}
+
+func runPolicyImport(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("policy import")
+	path := fs.String("config", "", "configuration file with the casbin section")
+	tenant := fs.String("tenant", "", "tenant id")
+	file := fs.String("file", "", "policy file, .csv or .json")
+	dryRun := fs.Bool("dry-run", false, "only print the changes")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	tp, err := readPolicy(*file)
+	if err != nil {
+		return err
+	}
+	roles, err := roleManager(ctx, *path)
+	if err != nil {
+		return err
+	}
+	diff, err := roles.Import(*tenant, tp, *dryRun)
+	if err != nil {
+		return err
+	}
+	return printJSON(out, diff)
+}
+
+func readPolicy(path string) (*rbac.TenantPolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open policy %w", err)
+	}
+	defer f.Close()
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return rbac.DecodeCSV(f)
+	}
+	var tp rbac.TenantPolicy
+	if err = json.NewDecoder(f).Decode(&tp); err != nil {
+		return nil, fmt.Errorf("decode policy %w", err)
+	}
+	return &tp, nil
+}
+
+// checkResult the decision of a permission check and the rules that decided it.
+type checkResult struct {
+	Allowed bool     `json:"allowed"`
+	Rules   []string `json:"rules"`
+}
+
+func runPolicyCheck(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("policy check")
+	path := fs.String("config", "", "configuration file with the casbin section")
+	tenant := fs.String("tenant", "", "tenant id")
+	subject := fs.String("subject", "", "user or group")
+	resource := fs.String("resource", "", "resource")
+	action := fs.String("action", "", "action")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	roles, err := roleManager(ctx, *path)
+	if err != nil {
+		return err
+	}
+	res := checkResult{Rules: []string{}}
+	if explainer, ok := roles.(*rbac.RoleOperator); ok {
+		res.Allowed, res.Rules, err = explainer.Explain(*subject, *tenant, *resource, *action)
+	} else {
+		res.Allowed, err = roles.Check(*subject, *tenant, *resource, *action)
+	}
+	if err != nil {
+		return err
+	}
+	return printJSON(out, res)
+}
+
+func keyManager(ctx context.Context, path string) (*apikey.Manager, error) {
+	conf, err := loadConfig(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Database == nil {
+		return nil, fmt.Errorf("%w: no database section in %s", errUsage, path)
+	}
+	db, err := gormdb.SetUp(*conf.Database)
+	if err != nil {
+		return nil, err
+	}
+	store, err := apikey.NewGormStore(db)
+	if err != nil {
+		return nil, err
+	}
+	var keyConf apikey.Config
+	if conf.APIKey != nil {
+		keyConf = *conf.APIKey
+	}
+	return apikey.NewManager(keyConf, store), nil
+}
+
+// createdKey a new key, Raw is shown once and can not be recovered.
+type createdKey struct {
+	Raw string `json:"key"`
+	*apikey.Key
+}
+
+func runAPIKeyCreate(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("apikey create")
+	path := fs.String("config", "", "configuration file with the database section")
+	opts := apikey.CreateOptions{}
+	fs.StringVar(&opts.Name, "name", "", "name of the key")
+	fs.StringVar(&opts.TenantID, "tenant", "", "tenant id")
+	fs.StringVar(&opts.Owner, "owner", "", "subject the key acts as")
+	scopes := fs.String("scopes", "", "comma separated scopes")
+	fs.DurationVar(&opts.TTL, "ttl", 0, "lifetime of the key, 0 for no expiry")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if opts.TenantID == "" || opts.Owner == "" {
+		return fmt.Errorf("%w: -tenant and -owner required", errUsage)
+	}
+	if *scopes != "" {
+		opts.Scopes = strings.Split(*scopes, ",")
+	}
+	keys, err := keyManager(ctx, *path)
+	if err != nil {
+		return err
+	}
+	raw, k, err := keys.Create(opts)
+	if err != nil {
+		return err
+	}
+	return printJSON(out, &createdKey{Raw: raw, Key: k})
+}
+
+func runAPIKeyList(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("apikey list")
+	path := fs.String("config", "", "configuration file with the database section")
+	tenant := fs.String("tenant", "", "tenant id")
+	owner := fs.String("owner", "", "only the keys of owner")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	keys, err := keyManager(ctx, *path)
+	if err != nil {
+		return err
+	}
+	list, err := keys.List(*tenant, *owner)
+	if err != nil {
+		return err
+	}
+	return printJSON(out, list)
+}
+
+func runAPIKeyRevoke(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("apikey revoke")
+	path := fs.String("config", "", "configuration file with the database section")
+	id := fs.String("id", "", "id of the key")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	keys, err := keyManager(ctx, *path)
+	if err != nil {
+		return err
+	}
+	if err = keys.Revoke(*id); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "revoked %s\n", *id)
+	return nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+)
+
+// inspected the decoded parts of a jwt.
+type inspected struct {
+	Header  map[string]interface{} `json:"header"`
+	Claims  map[string]interface{} `json:"claims"`
+	Expires string                 `json:"expires,omitempty"`
+	// Verified always false, inspect does not check the signature.
+	Verified bool `json:"verified"`
+}
+
+func runTokenInspect(_ context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("token inspect")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: token inspect <token>", errUsage)
+	}
+	t, err := inspectJWT(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(out, t)
+}
+
+func inspectJWT(raw string) (*inspected, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a jwt", token.ErrInvalidToken)
+	}
+	t := &inspected{}
+	for i, v := range []*map[string]interface{}{&t.Header, &t.Claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("%w: decode jwt part %d %s", token.ErrInvalidToken, i, err)
+		}
+		if err = json.Unmarshal(data, v); err != nil {
+			return nil, fmt.Errorf("%w: decode jwt part %d %s", token.ErrInvalidToken, i, err)
+		}
+	}
+	if exp := token.ClaimTime(t.Claims, "exp"); !exp.IsZero() {
+		t.Expires = exp.UTC().Format(time.RFC3339)
+		if time.Now().After(exp) {
+			t.Expires += " (expired)"
+		}
+	}
+	return t, nil
+}
+
+func runTokenVerify(ctx context.Context, args []string, out io.Writer) error {
+	fs := newFlagSet("token verify")
+	path := fs.String("config", "", "configuration file with the token section")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: token verify -config <file> <token>", errUsage)
+	}
+	conf, err := loadConfig(ctx, *path)
+	if err != nil {
+		return err
+	}
+	if conf.Token == nil {
+		return fmt.Errorf("%w: no token section in %s", errUsage, *path)
+	}
+	m, err := token.NewManager(conf.Token, nil)
+	if err != nil {
+		return err
+	}
+	claims, err := m.Verify(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printJSON(out, claims)
+}
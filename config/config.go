@@ -0,0 +1,286 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads the YAML or JSON configuration of the security subsystems into their
+// typed config structs, e.g.
+//
+//	var conf struct {
+//		Token    token.Config         `yaml:"token"`
+//		OIDC     *oidc.OIDCProvider   `yaml:"oidc"`
+//		Database gormdb.DBConfig      `yaml:"database"`
+//	}
+//	conf.Token.AccessTokenTTL = time.Hour // defaults are the values preset in the structs.
+//	err := config.NewLoader(resolver).Load(ctx, "security.yaml", &conf)
+//
+// String values may reference environment variables as ${NAME} or ${NAME:-default}, and
+// secrets as secret references of the resolver, e.g. vault://secret/data/tkeel/db#password,
+// both are resolved at load time. Settings of the selected profile under profiles override
+// the base settings:
+//
+//	token:
+//	  issuer: tkeel
+//	  signingKey: ${TOKEN_SIGNING_KEY}
+//	profiles:
+//	  dev:
+//	    token:
+//	      accessTokenTTL: 24h
+//
+// Keys are the yaml names of the fields. The loaded sections implementing validation.Validator
+// are validated and all problems are reported at once.
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tkeel-io/security/secrets"
+	"github.com/tkeel-io/security/validation"
+
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v2"
+)
+
+// ProfileEnv the environment variable selecting the profile when none is set on the Loader.
+const ProfileEnv = "SECURITY_PROFILE"
+
+const _profilesKey = "profiles"
+
+var _envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Loader reads configuration files into typed config structs.
+type Loader struct {
+	resolver  *secrets.Resolver
+	profile   string
+	lookupEnv func(name string) (string, bool)
+}
+
+// NewLoader returns a Loader resolving secret references with resolver, which may be nil
+// to leave them as they are.
+func NewLoader(resolver *secrets.Resolver) *Loader {
+	return &Loader{resolver: resolver, lookupEnv: os.LookupEnv}
+}
+
+// SetProfile selects the profile applied over the base settings, default to the value of ProfileEnv.
+func (l *Loader) SetProfile(profile string) {
+	l.profile = profile
+}
+
+// SetLookupEnv replaces the lookup of environment variables, os.LookupEnv by default.
+func (l *Loader) SetLookupEnv(lookup func(name string) (string, bool)) {
+	l.lookupEnv = lookup
+}
+
+// Load reads the file at path and decodes it into out, a pointer to a struct.
+func (l *Loader) Load(ctx context.Context, path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config %w", err)
+	}
+	if err = l.Decode(ctx, data, out); err != nil {
+		return fmt.Errorf("load config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Decode decodes the YAML or JSON data into out, a pointer to a struct, after applying the
+// profile and resolving the references, then validates the sections of out.
+func (l *Loader) Decode(ctx context.Context, data []byte, out interface{}) error {
+	var raw interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("parse config %w", err)
+	}
+	settings, ok := normalize(raw).(map[string]interface{})
+	if !ok && raw != nil {
+		return fmt.Errorf("parse config: want a mapping at the top level, got %T", raw)
+	}
+	settings, err := l.applyProfile(settings)
+	if err != nil {
+		return err
+	}
+	r := validation.New()
+	resolved := l.resolve(ctx, r, "", settings)
+	if err = r.Err(); err != nil {
+		return err
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		TagName:          "yaml",
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+	if err = decoder.Decode(resolved); err != nil {
+		return fmt.Errorf("decode config %w", err)
+	}
+	return Validate(out)
+}
+
+func (l *Loader) applyProfile(settings map[string]interface{}) (map[string]interface{}, error) {
+	profiles, _ := settings[_profilesKey].(map[string]interface{})
+	delete(settings, _profilesKey)
+	profile := l.profile
+	if profile == "" {
+		profile, _ = l.lookupEnv(ProfileEnv)
+	}
+	if profile == "" {
+		return settings, nil
+	}
+	overlay, ok := profiles[profile].(map[string]interface{})
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("config profile %q not found, available: %s", profile, strings.Join(names, ", "))
+	}
+	return merge(settings, overlay), nil
+}
+
+// resolve expands the environment variables and resolves the secret references of the string
+// values of v, recording the failures under their field path.
+func (l *Loader) resolve(ctx context.Context, r *validation.Report, field string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return l.resolveString(ctx, r, field, v)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		out := make(map[string]interface{}, len(v))
+		for _, k := range keys {
+			path := k
+			if field != "" {
+				path = field + "." + k
+			}
+			out[k] = l.resolve(ctx, r, path, v[k])
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = l.resolve(ctx, r, fmt.Sprintf("%s[%d]", field, i), item)
+		}
+		return out
+	}
+	return v
+}
+
+func (l *Loader) resolveString(ctx context.Context, r *validation.Report, field, value string) string {
+	value = _envPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		m := _envPattern.FindStringSubmatch(ref)
+		if v, ok := l.lookupEnv(m[1]); ok {
+			return v
+		}
+		if m[2] != "" {
+			return m[3]
+		}
+		r.Add(field, fmt.Sprintf("environment variable %s is not set", m[1]),
+			fmt.Sprintf("export %s, or give a default as ${%s:-value}", m[1], m[1]))
+		return ""
+	})
+	if l.resolver == nil || !l.resolver.IsReference(value) {
+		return value
+	}
+	secret, err := l.resolver.Resolve(ctx, value)
+	if err != nil {
+		r.Add(field, err.Error(), "check the secret exists and its backend is reachable")
+		return ""
+	}
+	return secret
+}
+
+// Validate validates out and each field of the struct out points to that implements
+// validation.Validator, reporting the problems under the yaml names of the fields.
+func Validate(out interface{}) error {
+	r := validation.New()
+	if v, ok := out.(validation.Validator); ok {
+		r.Check("", v)
+	}
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return r.Err()
+	}
+	rv = rv.Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		f, value := rv.Type().Field(i), rv.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if value.Kind() != reflect.Ptr && value.Kind() != reflect.Interface {
+			value = value.Addr()
+		}
+		if value.IsNil() {
+			continue
+		}
+		if v, ok := value.Interface().(validation.Validator); ok {
+			r.Check(fieldName(f), v)
+		}
+	}
+	return r.Err()
+}
+
+func fieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if name == "" {
+		return strings.ToLower(f.Name)
+	}
+	return name
+}
+
+// normalize converts the map[interface{}]interface{} of yaml.v2 to map[string]interface{}.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[fmt.Sprint(k)] = normalize(item)
+		}
+		return out
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+	}
+	return v
+}
+
+// merge returns base with overlay merged in, nested maps are merged and other values replaced.
+func merge(base, overlay map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(base))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range overlay {
+		baseMap, ok1 := out[k].(map[string]interface{})
+		overlayMap, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			out[k] = merge(baseMap, overlayMap)
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
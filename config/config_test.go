@@ -0,0 +1,138 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/gormdb"
+	"github.com/tkeel-io/security/secrets"
+	"github.com/tkeel-io/security/validation"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const _testConfig = `
+token:
+  issuer: tkeel
+  signingKey: ${TOKEN_SIGNING_KEY}
+  allowedAlgorithms: HS256,HS384
+database:
+  type: mysql
+  host: ${DB_HOST:-localhost}
+  port: ${DB_PORT}
+  dbname: security
+  password: vault://secret/data/tkeel/db#password
+  queryTimeout: 5s
+profiles:
+  dev:
+    token:
+      accessTokenTTL: 24h
+    database:
+      logLevel: info
+  prod:
+    database:
+      maxOpenConns: 64
+`
+
+type testConfig struct {
+	Token    token.Config    `yaml:"token"`
+	Database gormdb.DBConfig `yaml:"database"`
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func newTestLoader(vars map[string]string) *Loader {
+	resolver := secrets.NewResolver(0)
+	resolver.Register("vault", secrets.ProviderFunc(func(_ context.Context, path string) (map[string]string, error) {
+		if path != "secret/data/tkeel/db" {
+			return nil, secrets.ErrSecretNotFound
+		}
+		return map[string]string{"password": "s3cret"}, nil
+	}))
+	l := NewLoader(resolver)
+	l.SetLookupEnv(env(vars))
+	return l
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "security.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(_testConfig), 0o600))
+	l := newTestLoader(map[string]string{"TOKEN_SIGNING_KEY": "key", "DB_PORT": "3306", ProfileEnv: "dev"})
+
+	conf := testConfig{Token: token.Config{AccessTokenTTL: time.Hour}, Database: gormdb.DBConfig{MaxIdleConns: 4}}
+	assert.NoError(t, l.Load(context.Background(), path, &conf))
+	assert.Equal(t, "tkeel", conf.Token.Issuer)
+	assert.Equal(t, "key", conf.Token.SigningKey)
+	assert.Equal(t, []string{"HS256", "HS384"}, conf.Token.AllowedAlgorithms)
+	assert.Equal(t, 24*time.Hour, conf.Token.AccessTokenTTL)
+	assert.Equal(t, "localhost", conf.Database.Host)
+	assert.Equal(t, "3306", conf.Database.Port)
+	assert.Equal(t, "s3cret", conf.Database.Password)
+	assert.Equal(t, 5*time.Second, conf.Database.QueryTimeout)
+	assert.Equal(t, "info", conf.Database.LogLevel)
+	assert.Equal(t, 4, conf.Database.MaxIdleConns)
+	assert.Equal(t, 0, conf.Database.MaxOpenConns)
+
+	l.SetProfile("prod")
+	conf = testConfig{}
+	assert.NoError(t, l.Load(context.Background(), path, &conf))
+	assert.Equal(t, 64, conf.Database.MaxOpenConns)
+	assert.Equal(t, time.Duration(0), conf.Token.AccessTokenTTL)
+}
+
+func TestDecodeProblems(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		fields []string
+	}{
+		{"unresolved", _testConfig, []string{"database.port", "token.signingKey"}},
+		{"secret not found", "database:\n  password: vault://secret/data/other#password\n", []string{"database.password"}},
+		{"invalid", "token:\n  format: saml\ndatabase:\n  host: db\n  dbname: security\n  port: \"0\"\n", []string{"token.format", "database.port"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conf testConfig
+			err := newTestLoader(nil).Decode(context.Background(), []byte(tt.config), &conf)
+			assert.ErrorIs(t, err, errs.ErrInvalidConfig)
+			var verr *validation.Error
+			assert.True(t, errors.As(err, &verr))
+			fields := make([]string, 0, len(verr.Problems))
+			for _, p := range verr.Problems {
+				fields = append(fields, p.Field)
+			}
+			assert.ElementsMatch(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestUnknownProfile(t *testing.T) {
+	l := newTestLoader(nil)
+	l.SetProfile("staging")
+	err := l.Decode(context.Background(), []byte(_testConfig), &testConfig{})
+	assert.EqualError(t, err, `config profile "staging" not found, available: dev, prod`)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package configz serves the effective runtime configuration with secrets redacted, so
+// operators can check which settings are actually loaded, e.g.
+//
+//	r := configz.New()
+//	r.Register("token", configz.Value(tokenConf))
+//	r.Register("providers", configz.Providers())
+//	r.Register("policies", configz.Policies(enforcer))
+//	http.Handle("/debug/configz", authorize(r.Handler()))
+//
+// The handler exposes the deployment layout, mount it behind admin authorization.
+package configz
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/tkeel-io/security/log"
+)
+
+// Source returns the current value of a configuration section,
+// it is called on each request so reloaded settings are reported.
+type Source func() interface{}
+
+// Value returns a Source of a fixed value, typically a pointer to the loaded config struct.
+func Value(v interface{}) Source {
+	return func() interface{} {
+		return v
+	}
+}
+
+// Registry the configuration sections to report.
+type Registry struct {
+	lock     sync.RWMutex
+	sections map[string]Source
+	logger   *log.Helper
+}
+
+func New() *Registry {
+	return &Registry{sections: make(map[string]Source)}
+}
+
+// SetLogger writes the entries of the configuration handler to l instead of the global logger.
+func (r *Registry) SetLogger(l log.Logger) {
+	r.logger = log.NewHelper(l)
+}
+
+// Register adds or replaces the section name.
+func (r *Registry) Register(name string, s Source) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sections[name] = s
+}
+
+// Sections returns the sorted names of the registered sections.
+func (r *Registry) Sections() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	names := make([]string, 0, len(r.sections))
+	for name := range r.sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Snapshot returns the redacted value of the sections, all when names is empty.
+// Unknown names are ignored.
+func (r *Registry) Snapshot(names ...string) map[string]interface{} {
+	r.lock.RLock()
+	sources := make(map[string]Source, len(r.sections))
+	for name, s := range r.sections {
+		sources[name] = s
+	}
+	r.lock.RUnlock()
+	if len(names) == 0 {
+		names = make([]string, 0, len(sources))
+		for name := range sources {
+			names = append(names, name)
+		}
+	}
+	snapshot := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		if s, ok := sources[name]; ok {
+			snapshot[name] = Redact(s())
+		}
+	}
+	return snapshot
+}
+
+// Handler serves the snapshot as JSON on GET, ?section= selects sections and may be repeated.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r.Snapshot(req.URL.Query()["section"]...)); err != nil {
+			r.logger.Errorf("write configuration snapshot %s", err)
+		}
+	})
+}
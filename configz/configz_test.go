@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/idprovider/oidc"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/casbin"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want interface{}
+	}{
+		{
+			"token config",
+			&token.Config{Issuer: "https://auth", SigningKey: "secret", AllowedAlgorithms: []string{"HS256"}, AccessTokenTTL: time.Hour},
+			map[string]interface{}{
+				"issuer": "https://auth", "format": "", "signing_key": Redacted, "algorithm": "",
+				"allowed_algorithms": []interface{}{"HS256"}, "access_token_ttl": "1h0m0s",
+			},
+		},
+		{
+			"unset secret",
+			&token.Config{},
+			map[string]interface{}{
+				"issuer": "", "format": "", "signing_key": "", "algorithm": "",
+				"allowed_algorithms": nil, "access_token_ttl": "0s",
+			},
+		},
+		{
+			"options",
+			map[string]interface{}{"client_secret": "s3cr3t", "token_url": "https://idp/token", "refresh_token": "rt", "nested": map[string]string{"password": "pw"}},
+			map[string]interface{}{"client_secret": Redacted, "token_url": "https://idp/token", "refresh_token": Redacted, "nested": map[string]interface{}{"password": Redacted}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Redact(tt.in))
+		})
+	}
+
+	// fields hidden from yaml too are runtime state.
+	m := Redact(&oidc.OIDCProvider{ClientID: "app", ClientSecret: "s3cr3t"}).(map[string]interface{})
+	assert.Equal(t, "app", m["client_id"])
+	assert.Equal(t, Redacted, m["clientSecret"])
+	assert.Equal(t, "", m["dpopKey"])
+	assert.NotContains(t, m, "OAuth2Config")
+	assert.NotContains(t, m, "Provider")
+}
+
+func TestHandler(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	_, err = enforcer.AddPolicy("admin", "tenant1", "users", "write")
+	assert.NoError(t, err)
+
+	idprovider.RegisterIdentityProvider("configz", &oidc.OIDCProvider{Issuer: "https://idp", ClientSecret: "s3cr3t"})
+	defer idprovider.UnregisterIdentityProvider("configz")
+
+	r := New()
+	r.Register("token", Value(&token.Config{SigningKey: "secret"}))
+	r.Register("providers", Providers())
+	r.Register("policies", Policies(enforcer))
+	assert.Equal(t, []string{"policies", "providers", "token"}, r.Sections())
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/configz?section=providers&section=policies", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "s3cr3t")
+	got := map[string]map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.NotContains(t, got, "token")
+	provider := got["providers"]["configz"].(map[string]interface{})
+	assert.Equal(t, "OIDCIdentityProvider", provider["type"])
+	assert.Equal(t, "https://idp", provider["config"].(map[string]interface{})["issuer"])
+	assert.Contains(t, got["policies"]["model"], "[policy_definition]")
+	assert.Equal(t, []interface{}{[]interface{}{"admin", "tenant1", "users", "write"}}, got["policies"]["rules"].(map[string]interface{})["p"])
+
+	w = httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/configz", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
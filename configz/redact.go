@@ -0,0 +1,196 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configz
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Redacted replaces the value of secrets that are set.
+const Redacted = "******"
+
+const _maxDepth = 16
+
+var (
+	// _sensitiveWords names containing one of these hold secrets.
+	_sensitiveWords = []string{"secret", "password", "passwd", "private", "credential"}
+	// _sensitiveSuffixes names ending with one of these hold secrets,
+	// matching on suffix keeps e.g. token_url and access_token_ttl visible.
+	_sensitiveSuffixes = []string{"token", "tokens", "apikey", "api_key", "signing_key", "encryption_key"}
+
+	_durationType = reflect.TypeOf(time.Duration(0))
+	_timeType     = reflect.TypeOf(time.Time{})
+)
+
+// Redact returns a JSON friendly copy of v with secrets redacted:
+//   - struct fields hidden from JSON (json:"-") are reported under their mapstructure or
+//     yaml name as Redacted when set, fields also hidden from yaml are runtime state and skipped;
+//   - string values of fields and map keys with sensitive names are Redacted when set;
+//   - durations are reported in their string form, funcs and channels are skipped.
+func Redact(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return redact(reflect.ValueOf(v), false, 0)
+}
+
+func redact(v reflect.Value, sensitive bool, depth int) interface{} {
+	if depth > _maxDepth {
+		return nil
+	}
+	if v.Type() == _durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Type() == _timeType {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return redact(v.Elem(), sensitive, depth+1)
+	case reflect.Struct:
+		return redactStruct(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			out[key] = redact(iter.Value(), sensitive || isSensitive(key), depth+1)
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return redactBytes(v, sensitive)
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = redact(v.Index(i), sensitive, depth+1)
+		}
+		return out
+	case reflect.String:
+		if sensitive && v.Len() > 0 {
+			return Redacted
+		}
+		return v.String()
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer, reflect.Invalid:
+		return nil
+	default:
+		return v.Interface()
+	}
+}
+
+func redactStruct(v reflect.Value, depth int) interface{} {
+	t := v.Type()
+	out := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			if embedded, ok := redact(fv, false, depth+1).(map[string]interface{}); ok {
+				for k, ev := range embedded {
+					out[k] = ev
+				}
+			}
+			continue
+		}
+		name := tagName(f.Tag.Get("json"))
+		if name == "-" {
+			name = tagName(f.Tag.Get("mapstructure"))
+			if name == "" {
+				name = tagName(f.Tag.Get("yaml"))
+			}
+			if name == "-" || f.Tag.Get("yaml") == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			out[name] = redactHidden(fv)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		out[name] = redact(fv, isSensitive(name) || isSensitive(f.Name), depth+1)
+	}
+	return out
+}
+
+// redactHidden reports whether a field hidden from JSON is set without revealing it.
+func redactHidden(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return ""
+		}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+	default:
+		if v.IsZero() {
+			return ""
+		}
+	}
+	return Redacted
+}
+
+func redactBytes(v reflect.Value, sensitive bool) interface{} {
+	if v.Len() == 0 {
+		return ""
+	}
+	if sensitive {
+		return Redacted
+	}
+	if v.Kind() == reflect.Array {
+		return fmt.Sprintf("%x", v.Slice(0, v.Len()).Interface())
+	}
+	return v.Interface()
+}
+
+func tagName(tag string) string {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, w := range _sensitiveWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	for _, s := range _sensitiveSuffixes {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package configz
+
+import (
+	"github.com/tkeel-io/security/authn/idprovider"
+
+	"github.com/casbin/casbin/v2"
+)
+
+// Providers returns a Source of the registered identity providers by key with their type
+// and loaded configuration.
+func Providers() Source {
+	return func() interface{} {
+		providers := idprovider.IdentityProviders()
+		out := make(map[string]interface{}, len(providers))
+		for key, p := range providers {
+			out[key] = map[string]interface{}{
+				"type":   p.Type(),
+				"config": p,
+			}
+		}
+		return out
+	}
+}
+
+// Policies returns a Source of the casbin model and its policy and grouping rules by ptype.
+func Policies(e *casbin.SyncedEnforcer) Source {
+	return func() interface{} {
+		m := e.GetModel()
+		rules := make(map[string][][]string)
+		for _, sec := range []string{"p", "g"} {
+			for ptype := range m[sec] {
+				if sec == "p" {
+					rules[ptype] = e.GetNamedPolicy(ptype)
+				} else {
+					rules[ptype] = e.GetNamedGroupingPolicy(ptype)
+				}
+			}
+		}
+		return map[string]interface{}{
+			"model": m.ToText(),
+			"rules": rules,
+		}
+	}
+}
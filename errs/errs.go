@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package errs the error kinds shared by authn and authz, so callers map failures to HTTP
+// statuses and retries with errors.Is instead of matching messages. Packages either reuse the
+// kinds as their own sentinels or wrap their errors with New.
+package errs
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	// ErrInvalidToken the token is malformed or its signature does not verify.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired the token is past its expiry.
+	ErrTokenExpired = errors.New("token expired")
+	// ErrCodeExchangeFailed the identity provider rejected the authorization code or ticket.
+	ErrCodeExchangeFailed = errors.New("authorization code exchange failed")
+	// ErrIdentityNotFound the identity provider knows no such user.
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrPermissionDenied the subject may not perform the action.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrProviderUnavailable the identity provider or a backing store can not be reached,
+	// the operation may succeed when retried.
+	ErrProviderUnavailable = errors.New("provider unavailable")
+	// ErrInvalidConfig a setting is missing, malformed or inconsistent.
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// Error an error of Kind, one of the kinds of the package, raised by Op. errors.Is matches
+// both Kind and the wrapped Err.
+type Error struct {
+	Kind error
+	// Op the failed operation, e.g. ldap.authenticate.
+	Op  string
+	Err error
+}
+
+// New returns an Error of kind raised by op wrapping err, which may be nil.
+func New(kind error, op string, err error) error {
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Op != "" {
+		msg = e.Op + ": " + msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// HTTPStatus returns the status answering err: 401 for rejected tokens and logins, 403 for
+// denied permissions, 503 for unavailable providers and 500 otherwise.
+func HTTPStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrTokenExpired),
+		errors.Is(err, ErrCodeExchangeFailed), errors.Is(err, ErrIdentityNotFound):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrProviderUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// Retryable reports whether the operation failing with err may succeed when retried.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrProviderUnavailable)
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := fmt.Errorf("login %w", New(ErrProviderUnavailable, "ldap.authenticate", cause))
+	assert.True(t, errors.Is(err, ErrProviderUnavailable))
+	assert.True(t, errors.Is(err, cause))
+	assert.False(t, errors.Is(err, ErrIdentityNotFound))
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, "ldap.authenticate", e.Op)
+	assert.Equal(t, "login ldap.authenticate: provider unavailable: connection refused", err.Error())
+	assert.True(t, Retryable(err))
+
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{nil, http.StatusOK},
+		{fmt.Errorf("verify %w", ErrTokenExpired), http.StatusUnauthorized},
+		{New(ErrCodeExchangeFailed, "oidc.exchange", nil), http.StatusUnauthorized},
+		{New(ErrIdentityNotFound, "", nil), http.StatusUnauthorized},
+		{ErrPermissionDenied, http.StatusForbidden},
+		{err, http.StatusServiceUnavailable},
+		{cause, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.status, HTTPStatus(tt.err), "%v", tt.err)
+	}
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package geoip resolves client ips to their country and autonomous system, so ABAC policies and
+// the risk engine can act on where a request comes from, e.g. deny admin logins from outside
+// CN and the EU. Lookups go to a pluggable Database: an adapter of a MaxMind or IP2Location
+// reader, or the MemoryDatabase loaded from CSV exports.
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tkeel-io/security/authz/abac"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/notify"
+	"github.com/tkeel-io/security/risk"
+)
+
+// Attributes the Resolver adds to the env of ABAC policies.
+const (
+	// AttrCountry ISO 3166-1 alpha-2 code of the client ip, empty when unknown.
+	AttrCountry = "country"
+	// AttrASN number of the autonomous system of the client ip, 0 when unknown.
+	AttrASN = "asn"
+	// AttrASOrg organization of the autonomous system.
+	AttrASOrg = "as_org"
+	// AttrRegions the regions of the country, e.g. EU.
+	AttrRegions = "regions"
+)
+
+// RegionEU the region of the member states of the European Union.
+const RegionEU = "EU"
+
+// _euCountries the member states of the European Union.
+var _euCountries = []string{
+	"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU",
+	"IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK",
+}
+
+var (
+	_ Database        = &MemoryDatabase{}
+	_ abac.EnvSource  = &Resolver{}
+	_ risk.ASNLocator = &Resolver{}
+	_ notify.Locator  = &Resolver{}
+
+	// ErrInvalidRecord a database record has an invalid network, country or asn.
+	ErrInvalidRecord = errors.New("invalid geoip record")
+)
+
+// Location of an ip, the fields are empty when the database does not know them.
+type Location struct {
+	Country string `json:"country,omitempty"`
+	ASN     uint32 `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+// Database looks up the location of ips.
+type Database interface {
+	// Lookup returns the location of ip, nil when it is not in the database.
+	Lookup(ip net.IP) (*Location, error)
+}
+
+// MemoryDatabase an in-memory Database of networks. Country and autonomous system databases
+// can be loaded into the same MemoryDatabase, lookups merge the most specific network knowing
+// each field.
+type MemoryDatabase struct {
+	lock sync.RWMutex
+	// networks the locations by prefix length and network address.
+	networks map[int]map[string]Location
+	// lengths the prefix lengths of networks, longest first.
+	lengths []int
+}
+
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{networks: make(map[int]map[string]Location)}
+}
+
+// Add sets the location of the network cidr, replacing the location of the same network.
+func (d *MemoryDatabase) Add(cidr string, loc Location) error {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+	}
+	if ip.To4() != nil {
+		network.IP, network.Mask = network.IP.To4(), network.Mask[len(network.Mask)-net.IPv4len:]
+	}
+	ones, _ := network.Mask.Size()
+	loc.Country = strings.ToUpper(loc.Country)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.networks[ones]; !ok {
+		d.networks[ones] = make(map[string]Location)
+		d.lengths = append(d.lengths, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(d.lengths)))
+	}
+	d.networks[ones][string(network.IP)] = loc
+	return nil
+}
+
+// LoadCSV adds the records of r, one network per line: cidr,country,asn,as_org. Trailing fields
+// may be omitted, asn may carry the AS prefix, a network header record and lines starting with #
+// are skipped, errors count the records from 1. It returns the number of networks added.
+func (d *MemoryDatabase) LoadCSV(r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	n := 0
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("%w: %s", ErrInvalidRecord, err)
+		}
+		if line == 1 && strings.EqualFold(record[0], "network") {
+			continue
+		}
+		var loc Location
+		if len(record) > 1 {
+			loc.Country = record[1]
+		}
+		if len(record) > 2 && record[2] != "" {
+			asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(record[2]), "AS"), 10, 32)
+			if err != nil {
+				return n, fmt.Errorf("%w: record %d: asn %s", ErrInvalidRecord, line, record[2])
+			}
+			loc.ASN = uint32(asn)
+		}
+		if len(record) > 3 {
+			loc.ASOrg = record[3]
+		}
+		if err = d.Add(record[0], loc); err != nil {
+			return n, fmt.Errorf("record %d: %w", line, err)
+		}
+		n++
+	}
+}
+
+func (d *MemoryDatabase) Lookup(ip net.IP) (*Location, error) {
+	bits := net.IPv6len * 8
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, net.IPv4len*8
+	}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var loc *Location
+	for _, ones := range d.lengths {
+		if ones > bits {
+			continue
+		}
+		found, ok := d.networks[ones][string(ip.Mask(net.CIDRMask(ones, bits)))]
+		if !ok {
+			continue
+		}
+		if loc == nil {
+			loc = &Location{}
+		}
+		if loc.Country == "" {
+			loc.Country = found.Country
+		}
+		if loc.ASN == 0 && found.ASN != 0 {
+			loc.ASN, loc.ASOrg = found.ASN, found.ASOrg
+		}
+		if loc.Country != "" && loc.ASN != 0 {
+			break
+		}
+	}
+	return loc, nil
+}
+
+// Config of the Resolver.
+type Config struct {
+	// Regions named groups of country codes, policies test them in env.regions. EU is predefined
+	// with the member states of the European Union unless redefined here.
+	Regions map[string][]string `mapstructure:"regions" json:"regions" yaml:"regions"`
+}
+
+// Resolver locates client ips in a Database. It is the abac.EnvSource of the location attributes
+// and the risk and notify Locator.
+type Resolver struct {
+	db Database
+	// regions the sorted regions of each country.
+	regions map[string][]string
+	logger  *log.Helper
+}
+
+// NewResolver returns a Resolver looking ips up in db.
+func NewResolver(conf Config, db Database) *Resolver {
+	regions := map[string][]string{RegionEU: _euCountries}
+	for name, countries := range conf.Regions {
+		regions[name] = countries
+	}
+	r := &Resolver{db: db, regions: make(map[string][]string)}
+	for name, countries := range regions {
+		for _, country := range countries {
+			country = strings.ToUpper(country)
+			r.regions[country] = append(r.regions[country], name)
+		}
+	}
+	for _, names := range r.regions {
+		sort.Strings(names)
+	}
+	return r
+}
+
+// SetLogger writes the entries of the lookups to l instead of the global logger.
+func (r *Resolver) SetLogger(l log.Logger) {
+	r.logger = log.NewHelper(l)
+}
+
+// Locate returns the location of ip, empty when unknown. Database errors are logged and treated
+// as unknown.
+func (r *Resolver) Locate(ip net.IP) Location {
+	if ip == nil {
+		return Location{}
+	}
+	loc, err := r.db.Lookup(ip)
+	if err != nil {
+		r.logger.Errorf("geoip: lookup %s %s", ip, err)
+		return Location{}
+	}
+	if loc == nil {
+		return Location{}
+	}
+	return *loc
+}
+
+// Country returns the ISO country code of ip, empty when unknown.
+func (r *Resolver) Country(ip net.IP) string {
+	return r.Locate(ip).Country
+}
+
+// ASN returns the number and organization of the autonomous system of ip, 0 when unknown.
+func (r *Resolver) ASN(ip net.IP) (uint32, string) {
+	loc := r.Locate(ip)
+	return loc.ASN, loc.ASOrg
+}
+
+// Regions returns the regions of country, e.g. [EU] for FR.
+func (r *Resolver) Regions(country string) []string {
+	return append([]string{}, r.regions[strings.ToUpper(country)]...)
+}
+
+// Environment returns the location attributes of the client ip of req, all set also when the ip
+// is unknown, e.g. `"admin" in subject.roles && !(env.country == "CN" || "EU" in env.regions)`.
+func (r *Resolver) Environment(req *abac.Request) map[string]interface{} {
+	loc := r.Locate(net.ParseIP(req.IP))
+	return map[string]interface{}{
+		AttrCountry: loc.Country,
+		AttrASN:     int64(loc.ASN),
+		AttrASOrg:   loc.ASOrg,
+		AttrRegions: r.Regions(loc.Country),
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package geoip
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/abac"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/risk"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const _testCSV = `network,country,asn,as_org
+# countries
+1.0.0.0/8,cn
+81.0.0.0/8,FR
+2001:db8::/32,DE
+# autonomous systems
+1.2.0.0/16,,AS4134,Chinanet
+81.2.3.0/24,,16276,OVH
+`
+
+type failingDatabase struct{}
+
+func (failingDatabase) Lookup(net.IP) (*Location, error) {
+	return nil, errors.New("database closed")
+}
+
+func TestMemoryDatabase(t *testing.T) {
+	db := NewMemoryDatabase()
+	n, err := db.LoadCSV(strings.NewReader(_testCSV))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	tests := []struct {
+		ip  string
+		loc *Location
+	}{
+		{"1.2.3.4", &Location{Country: "CN", ASN: 4134, ASOrg: "Chinanet"}},
+		{"1.9.3.4", &Location{Country: "CN"}},
+		{"81.2.3.4", &Location{Country: "FR", ASN: 16276, ASOrg: "OVH"}},
+		{"::ffff:81.2.3.4", &Location{Country: "FR", ASN: 16276, ASOrg: "OVH"}},
+		{"2001:db8::1", &Location{Country: "DE"}},
+		{"8.8.8.8", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			loc, err := db.Lookup(net.ParseIP(tt.ip))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.loc, loc)
+		})
+	}
+
+	_, err = db.LoadCSV(strings.NewReader("10.0.0.0/8,US\n10.0.0.0/33,US\n"))
+	assert.True(t, errors.Is(err, ErrInvalidRecord))
+	_, err = db.LoadCSV(strings.NewReader("10.0.0.0/8,US,ASX\n"))
+	assert.True(t, errors.Is(err, ErrInvalidRecord))
+}
+
+func TestPolicies(t *testing.T) {
+	db := NewMemoryDatabase()
+	_, err := db.LoadCSV(strings.NewReader(_testCSV))
+	assert.NoError(t, err)
+	resolver := NewResolver(Config{Regions: map[string][]string{"APAC": {"cn"}}}, db)
+	assert.Equal(t, []string{"APAC"}, resolver.Regions("CN"))
+	assert.Equal(t, []string{RegionEU}, resolver.Regions("fr"))
+
+	e, err := abac.NewEngine()
+	assert.NoError(t, err)
+	e.AddEnvSource(resolver)
+	assert.NoError(t, e.AddPolicy(abac.Policy{
+		ID:        "admin-location",
+		Effect:    abac.EffectDeny,
+		Actions:   []string{"login"},
+		Condition: `"admin" in subject.roles && !(env.country == "CN" || "EU" in env.regions)`,
+	}))
+	assert.NoError(t, e.AddPolicy(abac.Policy{
+		ID:        "hosting",
+		Effect:    abac.EffectDeny,
+		Condition: `env.asn == 16276`,
+	}))
+	admin := abac.SubjectFromClaims(&token.Claims{Subject: "alice",
+		Extra: map[string]interface{}{"roles": []interface{}{"admin"}}})
+	tests := []struct {
+		name     string
+		subject  map[string]interface{}
+		ip       string
+		decision authorizer.Decision
+	}{
+		{"admin from CN", admin, "1.9.3.4", authorizer.DecisionNoOpinion},
+		{"admin from EU", admin, "2001:db8::1", authorizer.DecisionNoOpinion},
+		{"admin from elsewhere", admin, "8.8.8.8", authorizer.DecisionDeny},
+		{"admin without ip", admin, "", authorizer.DecisionDeny},
+		{"user from elsewhere", map[string]interface{}{"roles": []interface{}{"user"}}, "8.8.8.8", authorizer.DecisionNoOpinion},
+		{"hosting provider", admin, "81.2.3.4", authorizer.DecisionDeny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := e.Evaluate(&abac.Request{Subject: tt.subject, Action: "login", IP: tt.ip})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.decision, d)
+		})
+	}
+
+	r := httptest.NewRequest("POST", "/oauth/token", nil)
+	r.RemoteAddr = "1.2.3.4:4711"
+	s := risk.New(risk.Config{}, resolver).Signals(r, "ldap", "alice", "t1")
+	assert.Equal(t, "CN", s.Country)
+	assert.Equal(t, uint32(4134), s.ASN)
+	assert.Equal(t, "Chinanet", s.ASOrg)
+
+	assert.Equal(t, Location{}, NewResolver(Config{}, failingDatabase{}).Locate(net.ParseIP("1.2.3.4")))
+}
@@ -3,31 +3,39 @@ module github.com/tkeel-io/security
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.17.0
 	github.com/casbin/casbin/v2 v2.41.0
 	github.com/casbin/xorm-adapter/v2 v2.4.0
 	github.com/coreos/go-oidc v2.2.1+incompatible
+	github.com/duo-labs/webauthn v0.0.0-20220122034320-81aea484c951
+	github.com/gin-gonic/gin v1.7.7
 	github.com/go-ldap/ldap v3.0.3+incompatible
+	github.com/go-redis/redis/v8 v8.11.4
 	github.com/go-sql-driver/mysql v1.6.0
-	github.com/golang-jwt/jwt v3.2.1+incompatible
-	github.com/golang/glog v1.0.0 // indirect
-	github.com/google/go-cmp v0.5.6 // indirect
-	github.com/kr/text v0.2.0 // indirect
+	github.com/gofiber/fiber/v2 v2.24.0
+	github.com/golang-jwt/jwt v3.2.2+incompatible
+	github.com/golang/protobuf v1.5.2
+	github.com/google/cel-go v0.9.0
+	github.com/labstack/echo/v4 v4.6.3
 	github.com/mitchellh/mapstructure v1.4.2
-	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
-	github.com/pkg/errors v0.9.1 // indirect
+	github.com/open-policy-agent/opa v0.34.2
 	github.com/pquerna/cachecontrol v0.1.0 // indirect
+	github.com/prometheus/client_golang v1.11.0
 	github.com/stretchr/testify v1.7.0
 	github.com/tkeel-io/kit v0.0.0-20211223050802-7dfccfe43fdb
+	github.com/valyala/fasthttp v1.31.0
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 	golang.org/x/net v0.0.0-20211109214657-ef0fda0de508 // indirect
 	golang.org/x/oauth2 v0.0.0-20211104180415-d3ed0bb246c8
-	golang.org/x/sys v0.0.0-20210809222454-d867a43fc93e // indirect
+	google.golang.org/genproto v0.0.0-20211104193956-4c6863e31247
+	google.golang.org/grpc v1.42.0
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/asn1-ber.v1 v1.0.0-20181015200546-f715ec2f112d // indirect
 	gopkg.in/cas.v2 v2.2.2
-	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
-	gopkg.in/square/go-jose.v2 v2.6.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/square/go-jose.v2 v2.6.0
+	gopkg.in/yaml.v2 v2.4.0
 	gorm.io/driver/mysql v1.1.3
 	gorm.io/driver/postgres v1.2.3
+	gorm.io/driver/sqlite v1.2.6
 	gorm.io/gorm v1.22.3
 )
@@ -14,11 +14,15 @@ limitations under the License.
 package gormdb
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/tkeel-io/security/validation"
+
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -34,6 +38,39 @@ type DBConfig struct {
 	MaxIdleConns int    `mapstructure:"max_idle_conns" json:"max_idle_conns" yaml:"maxIdleConns"` // nolint
 	MaxOpenConns int    `mapstructure:"max_open_conns" json:"max_open_conns" yaml:"maxOpenConns"` // nolint
 	LogLevel     string `mapstructure:"log_level" json:"log_level" yaml:"logLevel"`               // nolint
+	// QueryTimeout bounds the statements run without a deadline. Default to 10s.
+	QueryTimeout time.Duration `mapstructure:"query_timeout" json:"query_timeout" yaml:"queryTimeout"`
+}
+
+// Validate reports all the problems of the configuration.
+func (conf *DBConfig) Validate() error {
+	r := validation.New()
+	switch strings.ToLower(conf.Type) {
+	case "", "mysql", "pgsql":
+	default:
+		r.Add("type", fmt.Sprintf("unsupported database type %q", conf.Type), "use mysql or pgsql")
+	}
+	r.Required("host", conf.Host, "set the address of the database server")
+	r.Required("dbname", conf.Dbname, "set the name of the database")
+	if conf.Port != "" {
+		if port, err := strconv.Atoi(conf.Port); err != nil || port <= 0 || port > 65535 {
+			r.Add("port", fmt.Sprintf("invalid port %q", conf.Port), "use a number between 1 and 65535")
+		}
+	}
+	if conf.MaxIdleConns < 0 || conf.MaxOpenConns < 0 {
+		r.Add("maxOpenConns", "connection limits must not be negative", "leave maxIdleConns and maxOpenConns unset for the driver defaults")
+	} else if conf.MaxOpenConns > 0 && conf.MaxIdleConns > conf.MaxOpenConns {
+		r.Add("maxIdleConns", "exceeds maxOpenConns", "set maxIdleConns at most maxOpenConns")
+	}
+	switch strings.ToLower(conf.LogLevel) {
+	case "", "silent", "error", "warn", "info":
+	default:
+		r.Add("logLevel", fmt.Sprintf("unknown log level %q", conf.LogLevel), "use silent, error, warn or info")
+	}
+	if conf.QueryTimeout < 0 {
+		r.Add("queryTimeout", "must not be negative", "leave it unset for the 10s default")
+	}
+	return r.Err()
 }
 
 func (conf *DBConfig) MysqlDsn() string {
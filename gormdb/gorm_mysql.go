@@ -43,5 +43,8 @@ func GormMySQLByConfig(conf DBConfig) (*gorm.DB, error) {
 	}
 	sqlDB.SetMaxIdleConns(conf.MaxIdleConns)
 	sqlDB.SetMaxOpenConns(conf.MaxOpenConns)
+	if err = SetQueryTimeout(_db, conf.QueryTimeout); err != nil {
+		return nil, err
+	}
 	return _db, nil
 }
@@ -36,5 +36,8 @@ func GormPgSQLByConfig(conf DBConfig) (*gorm.DB, error) {
 	sqlDB, _ := _db.DB()
 	sqlDB.SetMaxIdleConns(conf.MaxIdleConns)
 	sqlDB.SetMaxOpenConns(conf.MaxOpenConns)
+	if err = SetQueryTimeout(_db, conf.QueryTimeout); err != nil {
+		return nil, err
+	}
 	return _db, nil
 }
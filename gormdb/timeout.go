@@ -0,0 +1,87 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gormdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	_cancelSetting  = "security:cancel"
+	_contextSetting = "security:context"
+)
+
+// SetQueryTimeout bounds every create, query, update, delete and raw statement of db run without
+// a deadline by timeout, default to utils.DefaultTimeout, so a stalled database fails the call
+// instead of pinning its goroutine. Rows returned by Rows or Row are not bounded, since they are
+// read after the statement returns.
+//
+// A chain such as db.Where(...) reuses its Statement for every call, e.g. Count then Find, so each
+// statement derives its own deadline from the context of the caller and the Statement gets that
+// context back once the statement ran.
+func SetQueryTimeout(db *gorm.DB, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = utils.DefaultTimeout
+	}
+	before := func(tx *gorm.DB) {
+		parent := tx.Statement.Context
+		if parent == nil {
+			parent = context.Background()
+		}
+		if _, ok := parent.Deadline(); ok {
+			return
+		}
+		ctx, cancel := context.WithTimeout(parent, timeout)
+		tx.Statement.Context = ctx
+		tx.Statement.Settings.Store(_contextSetting, parent)
+		tx.Statement.Settings.Store(_cancelSetting, cancel)
+	}
+	after := func(tx *gorm.DB) {
+		if cancel, ok := tx.Statement.Settings.Load(_cancelSetting); ok {
+			cancel.(context.CancelFunc)()
+			tx.Statement.Settings.Delete(_cancelSetting)
+		}
+		if parent, ok := tx.Statement.Settings.Load(_contextSetting); ok {
+			tx.Statement.Context = parent.(context.Context)
+			tx.Statement.Settings.Delete(_contextSetting)
+		}
+	}
+	callbacks := db.Callback()
+	type register func(name string, fn func(*gorm.DB)) error
+	for _, p := range []struct {
+		name   string
+		before register
+		after  register
+	}{
+		{"create", callbacks.Create().Before("*").Register, callbacks.Create().After("*").Register},
+		{"query", callbacks.Query().Before("*").Register, callbacks.Query().After("*").Register},
+		{"update", callbacks.Update().Before("*").Register, callbacks.Update().After("*").Register},
+		{"delete", callbacks.Delete().Before("*").Register, callbacks.Delete().After("*").Register},
+		{"raw", callbacks.Raw().Before("*").Register, callbacks.Raw().After("*").Register},
+	} {
+		if err := p.before("security:timeout", before); err != nil {
+			return fmt.Errorf("register %s timeout %w", p.name, err)
+		}
+		if err := p.after("security:cancel", after); err != nil {
+			return fmt.Errorf("register %s cancel %w", p.name, err)
+		}
+	}
+	return nil
+}
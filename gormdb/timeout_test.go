@@ -0,0 +1,53 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gormdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type timeoutRow struct {
+	ID   int
+	Name string
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, SetQueryTimeout(db, time.Second))
+	assert.NoError(t, db.AutoMigrate(&timeoutRow{}))
+	assert.NoError(t, db.Create(&[]timeoutRow{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}).Error)
+
+	// the chain reuses its statement: Find must not run with the context Count cancelled.
+	q := db.Model(&timeoutRow{}).Where("name <> ?", "")
+	var total int64
+	assert.NoError(t, q.Count(&total).Error)
+	var rows []timeoutRow
+	assert.NoError(t, q.Find(&rows).Error)
+	assert.Equal(t, int64(2), total)
+	assert.Len(t, rows, 2)
+	assert.NoError(t, q.Find(&rows).Error)
+
+	// a caller deadline is kept, a cancelled caller fails.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, db.WithContext(ctx).Find(&rows).Error)
+	assert.NoError(t, db.WithContext(context.Background()).Find(&rows).Error)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token/keyset"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrNoSigningKey returned when the keyset has no usable signing key.
+	ErrNoSigningKey = errors.New("no signing key available")
+)
+
+// SQL checks the database answers a ping.
+func SQL(db *sql.DB) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	})
+}
+
+// Gorm checks the database behind db answers a ping.
+func Gorm(db *gorm.DB) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return fmt.Errorf("gorm sql db %w", err)
+		}
+		return sqlDB.PingContext(ctx)
+	})
+}
+
+// Redis checks the redis server answers a ping.
+func Redis(client redis.UniversalClient) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+}
+
+// SigningKey checks the keyset has an active key able to sign.
+func SigningKey(keys *keyset.Manager) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		k := keys.SigningKey()
+		if k == nil || k.Signer == nil || !k.RetiredAt.IsZero() {
+			return ErrNoSigningKey
+		}
+		return nil
+	})
+}
+
+// Provider checks the identity provider registered under key is registered and, when it
+// implements idprovider.Tester, reachable. It is looked up on each check so a provider
+// reconfigured at runtime is checked in its current configuration.
+func Provider(key string) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		p, err := idprovider.GetIdentityProvider(key)
+		if err != nil {
+			return err
+		}
+		if t, ok := p.(idprovider.Tester); ok {
+			return t.Test(ctx)
+		}
+		return nil
+	})
+}
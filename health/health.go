@@ -0,0 +1,217 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health serves aggregated liveness and readiness endpoints for operators, e.g.
+//
+//	h := health.New(health.Config{})
+//	h.AddLiveness("signing_key", health.SigningKey(keys))
+//	h.AddReadiness("sql", health.SQL(db))
+//	h.AddReadiness("redis", health.Redis(client))
+//	h.AddReadiness("idp", health.Provider("tenant1"))
+//	http.Handle("/healthz", h.Liveness())
+//	http.Handle("/readyz", h.Readiness())
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/log"
+)
+
+const (
+	// StatusOK the dependency or the service is healthy.
+	StatusOK = "ok"
+	// StatusFail the dependency or the service is unhealthy.
+	StatusFail = "fail"
+
+	_defaultTimeout = 5 * time.Second
+)
+
+// ErrTimeout returned for a check not completed in Config.Timeout.
+var ErrTimeout = errors.New("health check timed out")
+
+// Checker checks a dependency, returning nil when healthy.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a func to a Checker.
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Config of the health endpoints.
+type Config struct {
+	// Timeout of each check. Default to 5s.
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout" yaml:"timeout"`
+}
+
+// Result of a check.
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report aggregated results, Status is StatusFail when any check failed.
+type Report struct {
+	Status string             `json:"status"`
+	Checks map[string]*Result `json:"checks"`
+}
+
+type check struct {
+	name    string
+	checker Checker
+}
+
+// Health aggregates the checks of the dependencies.
+type Health struct {
+	conf      Config
+	lock      sync.RWMutex
+	liveness  []check
+	readiness []check
+}
+
+func New(conf Config) *Health {
+	if conf.Timeout <= 0 {
+		conf.Timeout = _defaultTimeout
+	}
+	return &Health{conf: conf}
+}
+
+// AddLiveness adds a check of the process itself, failing it means the process should be
+// restarted. Liveness checks are also part of the readiness.
+func (h *Health) AddLiveness(name string, c Checker) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.liveness = append(h.liveness, check{name: name, checker: c})
+}
+
+// AddReadiness adds a check of a dependency, failing it means no traffic should be routed to
+// the process until it recovers.
+func (h *Health) AddReadiness(name string, c Checker) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.readiness = append(h.readiness, check{name: name, checker: c})
+}
+
+// Live runs the liveness checks.
+func (h *Health) Live(ctx context.Context) *Report {
+	h.lock.RLock()
+	checks := append([]check(nil), h.liveness...)
+	h.lock.RUnlock()
+	return h.run(ctx, checks)
+}
+
+// Ready runs the liveness and readiness checks.
+func (h *Health) Ready(ctx context.Context) *Report {
+	h.lock.RLock()
+	checks := append(append([]check(nil), h.liveness...), h.readiness...)
+	h.lock.RUnlock()
+	return h.run(ctx, checks)
+}
+
+// Liveness serves the liveness report, /healthz.
+func (h *Health) Liveness() http.Handler {
+	return handler(h.Live)
+}
+
+// Readiness serves the readiness report, /readyz.
+func (h *Health) Readiness() http.Handler {
+	return handler(h.Ready)
+}
+
+// run runs the checks concurrently, each bounded by the timeout.
+func (h *Health) run(ctx context.Context, checks []check) *Report {
+	report := &Report{Status: StatusOK, Checks: make(map[string]*Result, len(checks))}
+	results := make([]*Result, len(checks))
+	wg := sync.WaitGroup{}
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = h.check(ctx, checks[i].checker)
+		}(i)
+	}
+	wg.Wait()
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (h *Health) check(ctx context.Context, c Checker) *Result {
+	ctx, cancel := context.WithTimeout(ctx, h.conf.Timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ErrTimeout
+	}
+	res := &Result{Status: StatusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func handler(run func(ctx context.Context) *Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		report := run(r.Context())
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+			log.Warnf("health check failed: %s", failed(report))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Errorf("write health report %s", err)
+		}
+	})
+}
+
+// failed returns the sorted names of the failed checks.
+func failed(report *Report) []string {
+	names := make([]string, 0)
+	for name, res := range report.Checks {
+		if res.Status != StatusOK {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
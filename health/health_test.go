@@ -0,0 +1,125 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token/keyset"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type fakeProvider struct {
+	err error
+}
+
+func (p *fakeProvider) Type() string { return "health-fake" }
+
+func (p *fakeProvider) AuthenticateCode(string) (idprovider.Identity, error) { return nil, nil }
+
+func (p *fakeProvider) Authenticate(string, string) (idprovider.Identity, error) { return nil, nil }
+
+func (p *fakeProvider) AuthCodeURL(string, string) string { return "" }
+
+func (p *fakeProvider) Test(context.Context) error { return p.err }
+
+func get(t *testing.T, h http.Handler) (int, *Report) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	report := &Report{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), report))
+	return w.Code, report
+}
+
+func TestHealth(t *testing.T) {
+	h := New(Config{Timeout: 50 * time.Millisecond})
+	h.AddLiveness("live", CheckerFunc(func(context.Context) error { return nil }))
+	h.AddReadiness("broken", CheckerFunc(func(context.Context) error { return errors.New("connection refused") }))
+	h.AddReadiness("slow", CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	code, report := get(t, h.Liveness())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Len(t, report.Checks, 1)
+
+	code, report = get(t, h.Readiness())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusFail, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["live"].Status)
+	assert.Equal(t, "connection refused", report.Checks["broken"].Error)
+	assert.Equal(t, StatusFail, report.Checks["slow"].Status)
+
+	w := httptest.NewRecorder()
+	h.Readiness().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestCheckers(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	keys, err := keyset.New(keyset.Config{Algorithm: keyset.AlgorithmES256}, nil)
+	assert.NoError(t, err)
+
+	idprovider.RegisterIdentityProvider("health-up", &fakeProvider{})
+	idprovider.RegisterIdentityProvider("health-down", &fakeProvider{err: errors.New("discovery failed")})
+	defer idprovider.UnregisterIdentityProvider("health-up")
+	defer idprovider.UnregisterIdentityProvider("health-down")
+
+	tests := []struct {
+		name    string
+		checker Checker
+		wantErr bool
+	}{
+		{"sql", SQL(sqlDB), false},
+		{"gorm", Gorm(db), false},
+		{"redis", Redis(client), false},
+		{"signing key", SigningKey(keys), false},
+		{"provider", Provider("health-up"), false},
+		{"provider failing test", Provider("health-down"), true},
+		{"provider not registered", Provider("health-missing"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.checker.Check(context.Background())
+			assert.Equal(t, tt.wantErr, err != nil, "%v", err)
+		})
+	}
+
+	mr.Close()
+	assert.Error(t, Redis(client).Check(context.Background()))
+}
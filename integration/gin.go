@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"net/http"
+
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/middleware/ginauth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupGin wires the security of conf into router: the login, callback and logout routes under
+// the base path and the session middleware for all routes. Guard routes with RequireAuth and
+// RequirePermission of the returned GinSecurity.
+func SetupGin(router gin.IRouter, conf *Config, opts ...Option) (*GinSecurity, error) {
+	s, err := New(conf, opts...)
+	if err != nil {
+		return nil, err
+	}
+	router.Use(s.GinMiddleware())
+	group := router.Group(s.conf.BasePath)
+	group.GET(LoginPath, gin.WrapF(s.HandleLogin))
+	group.GET(CallbackPath, gin.WrapF(s.HandleCallback))
+	group.POST(LogoutPath, gin.WrapF(s.HandleLogout))
+	return &GinSecurity{Security: s, handlers: ginauth.New(s.Authn, s.checker)}, nil
+}
+
+// GinSecurity the Gin handlers of a Security.
+type GinSecurity struct {
+	*Security
+	handlers *ginauth.Security
+}
+
+// GinMiddleware runs Middleware in the Gin chain.
+func (s *Security) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		passed := false
+		s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			passed = true
+			c.Request = r
+			c.Next()
+		})).ServeHTTP(c.Writer, c.Request)
+		if !passed {
+			c.Abort()
+		}
+	}
+}
+
+// RequireAuth aborts unauthenticated requests with a 401 challenge.
+func (s *GinSecurity) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := middleware.ClaimsFromContext(c.Request.Context()); !ok {
+			s.Authn.WriteChallenge(c.Writer, middleware.ErrMissingToken, "")
+			c.Abort()
+		}
+	}
+}
+
+// RequirePermission aborts requests whose subject may not perform action on resource with 403,
+// route parameters in resource like devices/:id are expanded. It must run after RequireAuth.
+func (s *GinSecurity) RequirePermission(resource, action string) gin.HandlerFunc {
+	return s.handlers.RequirePermission(resource, action)
+}
@@ -0,0 +1,368 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package integration wires the login flow, the sessions, the token authentication and the
+// identity provider of a service from a single Config, e.g. with Gin
+//
+//	sec, err := integration.SetupGin(router, conf, integration.WithChecker(enforcer))
+//	router.GET("/devices/:id", sec.RequireAuth(), sec.RequirePermission("devices/:id", "read"), getDevice)
+//
+// or with net/http
+//
+//	sec, err := integration.New(conf)
+//	mux.Handle(sec.BasePath()+"/", sec.Routes())
+//	mux.Handle("/api/", sec.Middleware(sec.Require(api)))
+//
+// The routes are login, redirecting to the identity provider, callback, starting the session,
+// and logout. Requests are authenticated by their session or by a bearer access token.
+package integration
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/authz/rbac"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/secrets"
+	"github.com/tkeel-io/security/utils"
+	"github.com/tkeel-io/security/validation"
+)
+
+const (
+	_defaultBasePath    = "/auth"
+	_defaultProviderKey = "default"
+	_stateCookieName    = "tkeel_login_state"
+	_stateTTL           = 10 * time.Minute
+)
+
+// Paths of the routes under the base path.
+const (
+	LoginPath    = "/login"
+	CallbackPath = "/callback"
+	LogoutPath   = "/logout"
+)
+
+// ProviderConfig the identity provider of the logins.
+type ProviderConfig struct {
+	// Key the provider is registered under in the idprovider registry. Default to default.
+	Key string `mapstructure:"key" json:"key" yaml:"key"`
+	// Type of the registered idprovider.ProviderFactory, e.g. OIDCIdentityProvider.
+	Type string `mapstructure:"type" json:"type" yaml:"type"`
+	// Options of the factory, string values may be secret references.
+	Options map[string]interface{} `mapstructure:"options" json:"options" yaml:"options"`
+}
+
+// Config of the wiring, the sections are the configs of the wired packages.
+type Config struct {
+	Provider ProviderConfig    `mapstructure:"provider" json:"provider" yaml:"provider"`
+	Token    token.Config      `mapstructure:"token" json:"token" yaml:"token"`
+	Session  session.Config    `mapstructure:"session" json:"session" yaml:"session"`
+	Authn    middleware.Config `mapstructure:"authn" json:"authn" yaml:"authn"`
+	// BasePath of the login, callback and logout routes. Default to /auth.
+	BasePath string `mapstructure:"base_path" json:"base_path" yaml:"basePath"`
+	// AfterLoginURL where the callback redirects once the session started. Default to /.
+	AfterLoginURL string `mapstructure:"after_login_url" json:"after_login_url" yaml:"afterLoginURL"`
+	// AfterLogoutURL where logout redirects. Default to /.
+	AfterLogoutURL string `mapstructure:"after_logout_url" json:"after_logout_url" yaml:"afterLogoutURL"`
+}
+
+// Validate reports all the problems of the configuration.
+func (c *Config) Validate() error {
+	r := validation.New()
+	r.Required("provider.type", c.Provider.Type, "set the type of a registered identity provider, e.g. OIDCIdentityProvider")
+	r.Check("token", &c.Token)
+	if c.BasePath != "" && !strings.HasPrefix(c.BasePath, "/") {
+		r.Add("basePath", fmt.Sprintf("%q is not an absolute path", c.BasePath), "start it with /, e.g. /auth")
+	}
+	return r.Err()
+}
+
+// Option configures the Security created by New or SetupGin.
+type Option func(s *Security)
+
+// WithChecker authorizes the permissions of RequirePermission with checker.
+func WithChecker(checker authorizer.Checker) Option {
+	return func(s *Security) {
+		s.checker = checker
+	}
+}
+
+// WithGroupMapper syncs the role bindings of every user logging in with the groups of its
+// identity, a failing sync fails the login.
+func WithGroupMapper(mapper *rbac.GroupMapper) Option {
+	return func(s *Security) {
+		s.groups = mapper
+	}
+}
+
+// WithLogger writes the entries of the login flow to l instead of the global logger.
+func WithLogger(l log.Logger) Option {
+	return func(s *Security) {
+		s.logger = log.NewHelper(l)
+	}
+}
+
+// WithSessionStore keeps the sessions in store instead of encrypted cookies.
+func WithSessionStore(store session.Store) Option {
+	return func(s *Security) {
+		s.sessionStore = store
+	}
+}
+
+// WithTokenStore stores the opaque tokens in store, required by the opaque token format.
+func WithTokenStore(store token.Store) Option {
+	return func(s *Security) {
+		s.tokenStore = store
+	}
+}
+
+// WithSecretResolver resolves the secret references of the provider options with resolver,
+// default to the env:// and file:// references.
+func WithSecretResolver(resolver *secrets.Resolver) Option {
+	return func(s *Security) {
+		s.resolver = resolver
+	}
+}
+
+// WithProvider uses p instead of creating the provider of the configuration, p is registered
+// under the provider key.
+func WithProvider(p idprovider.Provider) Option {
+	return func(s *Security) {
+		s.provider = p
+	}
+}
+
+// Security the wired login flow and request authentication.
+type Security struct {
+	conf         Config
+	checker      authorizer.Checker
+	groups       *rbac.GroupMapper
+	sessionStore session.Store
+	tokenStore   token.Store
+	resolver     *secrets.Resolver
+	logger       *log.Helper
+
+	provider idprovider.Provider
+	Sessions *session.Manager
+	Tokens   token.Manager
+	Authn    *middleware.Authenticator
+}
+
+// New validates conf, creates and registers the identity provider and builds the session and
+// token managers.
+func New(conf *Config, opts ...Option) (*Security, error) {
+	s := &Security{conf: *conf}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.conf.Provider.Key == "" {
+		s.conf.Provider.Key = _defaultProviderKey
+	}
+	if s.conf.BasePath == "" {
+		s.conf.BasePath = _defaultBasePath
+	}
+	s.conf.BasePath = strings.TrimSuffix(s.conf.BasePath, "/")
+	if s.conf.AfterLoginURL == "" {
+		s.conf.AfterLoginURL = "/"
+	}
+	if s.conf.AfterLogoutURL == "" {
+		s.conf.AfterLogoutURL = "/"
+	}
+	if s.resolver == nil {
+		s.resolver = secrets.NewResolver(0)
+	}
+	if s.provider == nil {
+		if err := s.conf.Validate(); err != nil {
+			return nil, err
+		}
+		if err := s.createProvider(); err != nil {
+			return nil, err
+		}
+	}
+	idprovider.RegisterIdentityProvider(s.conf.Provider.Key, s.provider)
+
+	var err error
+	if s.Tokens, err = token.NewManager(&s.conf.Token, s.tokenStore); err != nil {
+		return nil, fmt.Errorf("token manager %w", err)
+	}
+	if s.Sessions, err = session.NewManager(s.conf.Session, s.sessionStore); err != nil {
+		return nil, fmt.Errorf("session manager %w", err)
+	}
+	s.Authn = middleware.NewAuthenticator(s.Tokens, s.conf.Authn)
+	return s, nil
+}
+
+func (s *Security) createProvider() error {
+	ctx, cancel := utils.WithTimeout(context.Background(), 0)
+	defer cancel()
+	options, err := s.resolver.ResolveOptions(ctx, s.conf.Provider.Options)
+	if err != nil {
+		return fmt.Errorf("resolve provider options %w", err)
+	}
+	if s.provider, err = idprovider.CreateProvider(s.conf.Provider.Type, options); err != nil {
+		return fmt.Errorf("create %s provider %w", s.conf.Provider.Type, err)
+	}
+	return nil
+}
+
+// BasePath returns the path the routes are served under.
+func (s *Security) BasePath() string {
+	return s.conf.BasePath
+}
+
+// Routes serves the login, callback and logout routes under the base path.
+func (s *Security) Routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.conf.BasePath+LoginPath, s.HandleLogin)
+	mux.HandleFunc(s.conf.BasePath+CallbackPath, s.HandleCallback)
+	mux.HandleFunc(s.conf.BasePath+LogoutPath, s.HandleLogout)
+	return mux
+}
+
+// HandleLogin redirects to the login of the identity provider. The state, the nonce and the
+// PKCE verifier of the request are kept in a cookie the callback checks.
+func (s *Security) HandleLogin(w http.ResponseWriter, r *http.Request) {
+	var secrets [3]string
+	for i := range secrets {
+		secret, err := utils.RandBase64String(32)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		secrets[i] = secret
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+	http.SetCookie(w, s.stateCookie(strings.Join(secrets[:], "."), int(_stateTTL.Seconds())))
+	http.Redirect(w, r, idprovider.AuthCodeURL(s.provider, state, nonce, verifier), http.StatusFound)
+}
+
+// HandleCallback authenticates the code the identity provider redirected back with and starts
+// the session of the user.
+func (s *Security) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(_stateCookieName)
+	var secrets []string
+	if err == nil {
+		secrets = strings.Split(c.Value, ".")
+	}
+	state := r.URL.Query().Get("state")
+	if len(secrets) != 3 || state == "" || subtle.ConstantTimeCompare([]byte(secrets[0]), []byte(state)) != 1 {
+		http.Error(w, "login state mismatch", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, s.stateCookie("", -1))
+	ctx := idprovider.WithLogin(r.Context(), &idprovider.Login{Nonce: secrets[1], CodeVerifier: secrets[2]})
+	identity, err := idprovider.AuthenticateCode(ctx, s.provider, r.URL.Query().Get("code"))
+	if err != nil {
+		s.logger.Warnf("integration: authenticate code with %s: %s", s.conf.Provider.Key, err)
+		http.Error(w, "authentication failed", http.StatusForbidden)
+		return
+	}
+	claims := Claims(identity)
+	if s.groups != nil {
+		if _, err = s.groups.Sync(s.conf.Provider.Key, claims.Subject, idprovider.Groups(identity)); err != nil {
+			s.logger.Errorf("integration: sync groups of %s: %s", claims.Subject, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, err = s.Sessions.Login(w, r, claims); err != nil {
+		s.logger.Errorf("integration: start session %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, s.conf.AfterLoginURL, http.StatusFound)
+}
+
+// HandleLogout ends the session, only on POST so a link can not log the user out.
+func (s *Security) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.Sessions.Logout(w, r); err != nil {
+		s.logger.Errorf("integration: end session %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, s.conf.AfterLogoutURL, http.StatusSeeOther)
+}
+
+// Middleware loads the session of requests, requests without a session are authenticated by
+// their access token when they carry one. Unauthenticated requests pass, guard handlers with Require.
+func (s *Security) Middleware(next http.Handler) http.Handler {
+	return s.Sessions.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := middleware.ClaimsFromContext(r.Context()); !ok {
+			if claims, err := s.Authn.Authenticate(r); err == nil {
+				r = r.WithContext(middleware.WithClaims(r.Context(), claims))
+			}
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// Require rejects unauthenticated requests with a 401 challenge, it must run after Middleware.
+func (s *Security) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := middleware.ClaimsFromContext(r.Context()); !ok {
+			s.Authn.WriteChallenge(w, middleware.ErrMissingToken, "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Security) stateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     _stateCookieName,
+		Value:    value,
+		Path:     s.conf.BasePath + CallbackPath,
+		MaxAge:   maxAge,
+		Secure:   !s.conf.Session.Insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Claims returns the session claims of identity, its extensions, such as the OP session of
+// OIDC identities, are kept.
+func Claims(identity idprovider.Identity) *token.Claims {
+	claims := &token.Claims{
+		Subject:  identity.GetUserID(),
+		TenantID: identity.GetTenantID(),
+		Username: identity.GetUsername(),
+		IssuedAt: time.Now().Unix(),
+	}
+	extra := make(map[string]interface{})
+	for k, v := range identity.GetExtra() {
+		extra[k] = v
+	}
+	if email := identity.GetEmail(); email != "" {
+		extra["email"] = email
+	}
+	if groups := idprovider.Groups(identity); len(groups) > 0 {
+		extra[idprovider.ExtraGroups] = groups
+	}
+	if len(extra) > 0 {
+		claims.Extra = extra
+	}
+	return claims
+}
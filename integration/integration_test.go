@@ -0,0 +1,207 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/idprovider/fake"
+	"github.com/tkeel-io/security/authn/idprovider/oidc"
+	"github.com/tkeel-io/security/authn/idprovider/oidc/oidctest"
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/casbin"
+	"github.com/tkeel-io/security/authz/rbac"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type adminChecker struct{}
+
+func (adminChecker) Check(subject, tenantID, resource, action string) (bool, error) {
+	return subject == "admin", nil
+}
+
+func newTestRouter(t *testing.T) (*gin.Engine, *GinSecurity) {
+	gin.SetMode(gin.TestMode)
+	provider := fake.NewProvider().
+		SetLoginURL("https://idp.example.com/login").
+		AddCode("code-1", fake.NewIdentity("u-1").WithTenant("t-1").WithEmail("u1@example.com"))
+	conf := &Config{
+		Token:   token.Config{SigningKey: "secret"},
+		Session: session.Config{Secret: "session-secret", Insecure: true},
+	}
+	router := gin.New()
+	sec, err := SetupGin(router, conf, WithProvider(provider), WithChecker(adminChecker{}))
+	assert.NoError(t, err)
+	router.GET("/me", sec.RequireAuth(), func(c *gin.Context) {
+		claims, _ := middleware.ClaimsFromContext(c.Request.Context())
+		c.String(http.StatusOK, claims.Subject+" "+token.ClaimString(claims.Extra, "email"))
+	})
+	router.GET("/devices/:id", sec.RequireAuth(), sec.RequirePermission("devices/:id", "read"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router, sec
+}
+
+func serve(router http.Handler, method, target string, cookies []*http.Cookie, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestSetupGinLoginFlow(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	w := serve(router, http.MethodGet, "/auth/login", nil, nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "idp.example.com", location.Host)
+	state := location.Query().Get("state")
+	assert.NotEmpty(t, state)
+	stateCookies := w.Result().Cookies()
+
+	w = serve(router, http.MethodGet, "/auth/callback?code=code-1&state=forged", stateCookies, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serve(router, http.MethodGet, "/auth/callback?code=code-1&state="+url.QueryEscape(state), stateCookies, nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+	var sessionCookies []*http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "tkeel_session" {
+			sessionCookies = append(sessionCookies, c)
+		}
+	}
+	assert.Len(t, sessionCookies, 1)
+
+	w = serve(router, http.MethodGet, "/me", sessionCookies, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "u-1 u1@example.com", w.Body.String())
+	assert.Equal(t, http.StatusForbidden, serve(router, http.MethodGet, "/devices/d-1", sessionCookies, nil).Code)
+
+	w = serve(router, http.MethodPost, "/auth/logout", sessionCookies, nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+}
+
+func TestGroupMapperLogin(t *testing.T) {
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	roles := rbac.NewRoleOperator(enforcer)
+	assert.NoError(t, roles.CreateRole("t-1", "operator", rbac.Permission{Resource: "devices/*", Action: "*"}))
+	mapper, err := rbac.NewGroupMapper(roles, []rbac.GroupMappingRule{{Group: "iot-ops", TenantID: "t-1", Role: "operator"}})
+	assert.NoError(t, err)
+	provider := fake.NewProvider().
+		SetLoginURL("https://idp.example.com/login").
+		AddCode("code-1", fake.NewIdentity("u-1").WithTenant("t-1").WithGroups("iot-ops"))
+	sec, err := New(&Config{
+		Token:   token.Config{SigningKey: "secret"},
+		Session: session.Config{Secret: "session-secret", Insecure: true},
+	}, WithProvider(provider), WithGroupMapper(mapper))
+	assert.NoError(t, err)
+
+	w := serve(sec.Routes(), http.MethodGet, "/auth/login", nil, nil)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	w = serve(sec.Routes(), http.MethodGet, "/auth/callback?code=code-1&state="+url.QueryEscape(location.Query().Get("state")), w.Result().Cookies(), nil)
+	assert.Equal(t, http.StatusFound, w.Code)
+	bound, err := roles.SubjectRoles("t-1", "u-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"operator"}, bound)
+}
+
+func TestSetupGinBearerToken(t *testing.T) {
+	router, sec := newTestRouter(t)
+	raw, err := sec.Tokens.Issue(&token.Claims{Subject: "admin", TenantID: "t-1"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(router, http.MethodGet, "/me", nil, nil).Code)
+	bearer := http.Header{"Authorization": {"Bearer " + raw}}
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, "/me", nil, bearer).Code)
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, "/devices/d-1", nil, bearer).Code)
+}
+
+func TestConfigValidate(t *testing.T) {
+	_, err := New(&Config{BasePath: "auth"})
+	assert.ErrorIs(t, err, errs.ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "provider.type: required")
+	assert.Contains(t, err.Error(), "token.signingKey: required")
+	assert.Contains(t, err.Error(), "basePath")
+}
+
+func TestOIDCLoginNonceAndPKCE(t *testing.T) {
+	op := oidctest.NewServer("rp")
+	defer op.Close()
+	provider, err := oidc.NewOIDCProvider(context.Background(), op.Issuer, "rp", oidc.WithRedirectURL("https://rp.example/auth/callback"))
+	assert.NoError(t, err)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	_, err = SetupGin(router, &Config{
+		Token:   token.Config{SigningKey: "secret"},
+		Session: session.Config{Secret: "session-secret", Insecure: true},
+	}, WithProvider(provider))
+	assert.NoError(t, err)
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	// authorize follows the login redirect to the OP, tamper edits its request.
+	authorize := func(tamper func(q url.Values)) (code, state string, cookies []*http.Cookie) {
+		w := serve(router, http.MethodGet, "/auth/login", nil, nil)
+		assert.Equal(t, http.StatusFound, w.Code)
+		u, err := url.Parse(w.Header().Get("Location"))
+		assert.NoError(t, err)
+		q := u.Query()
+		assert.Equal(t, "S256", q.Get("code_challenge_method"))
+		assert.NotEmpty(t, q.Get("nonce"))
+		if tamper != nil {
+			tamper(q)
+			u.RawQuery = q.Encode()
+		}
+		resp, err := noRedirect.Get(u.String())
+		assert.NoError(t, err)
+		resp.Body.Close()
+		back, err := url.Parse(resp.Header.Get("Location"))
+		assert.NoError(t, err)
+		return back.Query().Get("code"), back.Query().Get("state"), w.Result().Cookies()
+	}
+	callback := func(code, state string, cookies []*http.Cookie) int {
+		return serve(router, http.MethodGet, "/auth/callback?code="+url.QueryEscape(code)+"&state="+url.QueryEscape(state), cookies, nil).Code
+	}
+
+	code, state, cookies := authorize(nil)
+	assert.Equal(t, http.StatusFound, callback(code, state, cookies))
+
+	// a code issued for another login carries another nonce.
+	_, state, cookies = authorize(nil)
+	assert.Equal(t, http.StatusForbidden, callback(op.IssueCode("other"), state, cookies))
+
+	// a code issued for another challenge fails the exchange.
+	code, state, cookies = authorize(func(q url.Values) { q.Set("code_challenge", "other") })
+	assert.Equal(t, http.StatusForbidden, callback(code, state, cookies))
+}
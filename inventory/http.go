@@ -0,0 +1,68 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tkeel-io/security/middleware"
+)
+
+// Handler serves the inventory of the tenant the request authenticated as: GET returns its
+// Counts, or with ?subject= the credentials of that subject, and DELETE logs the requesting
+// subject out everywhere. Mount it behind the authentication and authorization middlewares.
+func (i *Inventory) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID := middleware.TenantFromContext(r.Context())
+		if tenantID == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			var (
+				v   interface{}
+				err error
+			)
+			if subject := r.URL.Query().Get("subject"); subject != "" {
+				v, err = i.Subject(tenantID, subject)
+			} else {
+				v, err = i.Counts(tenantID)
+			}
+			if err != nil {
+				i.logger.Errorf("inventory of tenant %s: %s", tenantID, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			_ = json.NewEncoder(w).Encode(v)
+		case http.MethodDelete:
+			subject := middleware.SubjectFromContext(r.Context())
+			if subject == "" {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			if err := i.LogoutEverywhere(tenantID, subject); err != nil {
+				i.logger.Errorf("log out %s of tenant %s everywhere: %s", subject, tenantID, err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
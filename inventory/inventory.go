@@ -0,0 +1,176 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inventory reports the sessions, refresh tokens and API keys outstanding per tenant
+// and subject, for capacity planning and "log out everywhere".
+package inventory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/oauth/server"
+)
+
+var (
+	_ SessionLister      = &session.MemoryStore{}
+	_ RefreshTokenLister = &server.MemoryStorage{}
+	_ KeyLister          = &apikey.Manager{}
+)
+
+// SessionLister a session store able to list and revoke sessions.
+type SessionLister interface {
+	// ListSessions returns the live sessions of the tenant, of subject unless it is empty.
+	ListSessions(tenantID, subject string) ([]*session.Session, error)
+	// RevokeSubject deletes the sessions of subject in the tenant.
+	RevokeSubject(tenantID, subject string) error
+}
+
+// RefreshTokenLister an authorization server storage able to list and revoke refresh tokens.
+type RefreshTokenLister interface {
+	// ListRefreshTokens returns the unexpired refresh tokens of the tenant, of subject unless it is empty.
+	ListRefreshTokens(tenantID, subject string) ([]*server.RefreshToken, error)
+	// RevokeSubject deletes the refresh tokens of subject in the tenant.
+	RevokeSubject(tenantID, subject string) error
+}
+
+// KeyLister lists API keys, e.g. apikey.Manager.
+type KeyLister interface {
+	List(tenantID, owner string) ([]*apikey.Key, error)
+}
+
+// Counts the active credentials of a tenant.
+type Counts struct {
+	Sessions      int `json:"sessions"`
+	RefreshTokens int `json:"refresh_tokens"`
+	APIKeys       int `json:"api_keys"`
+}
+
+// SessionInfo a session without its id, which is a bearer credential.
+type SessionInfo struct {
+	// Fingerprint identifies the session in audit events.
+	Fingerprint string    `json:"fingerprint"`
+	CreatedAt   time.Time `json:"created_at"`
+	AccessedAt  time.Time `json:"accessed_at"`
+}
+
+// RefreshTokenInfo a refresh token without its signature.
+type RefreshTokenInfo struct {
+	Fingerprint string    `json:"fingerprint"`
+	ClientID    string    `json:"client_id"`
+	Scope       string    `json:"scope"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Subject the active credentials of a subject.
+type Subject struct {
+	Sessions      []*SessionInfo      `json:"sessions"`
+	RefreshTokens []*RefreshTokenInfo `json:"refresh_tokens"`
+	APIKeys       []*apikey.Key       `json:"api_keys"`
+}
+
+// Inventory queries the credential stores, nil stores are reported empty.
+type Inventory struct {
+	sessions SessionLister
+	refresh  RefreshTokenLister
+	keys     KeyLister
+	logger   *log.Helper
+}
+
+func New(sessions SessionLister, refresh RefreshTokenLister, keys KeyLister) *Inventory {
+	return &Inventory{sessions: sessions, refresh: refresh, keys: keys}
+}
+
+// SetLogger writes the entries of the inventory handler to l instead of the global logger.
+func (i *Inventory) SetLogger(l log.Logger) {
+	i.logger = log.NewHelper(l)
+}
+
+// Counts returns the active credentials of the tenant.
+func (i *Inventory) Counts(tenantID string) (*Counts, error) {
+	s, err := i.Subject(tenantID, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Counts{Sessions: len(s.Sessions), RefreshTokens: len(s.RefreshTokens), APIKeys: len(s.APIKeys)}, nil
+}
+
+// Subject returns the active credentials of subject in the tenant, of all its subjects when
+// subject is empty.
+func (i *Inventory) Subject(tenantID, subject string) (*Subject, error) {
+	out := &Subject{
+		Sessions:      make([]*SessionInfo, 0),
+		RefreshTokens: make([]*RefreshTokenInfo, 0),
+		APIKeys:       make([]*apikey.Key, 0),
+	}
+	if i.sessions != nil {
+		sessions, err := i.sessions.ListSessions(tenantID, subject)
+		if err != nil {
+			return nil, fmt.Errorf("list sessions %w", err)
+		}
+		for _, s := range sessions {
+			out.Sessions = append(out.Sessions, &SessionInfo{
+				Fingerprint: audit.Fingerprint(s.ID),
+				CreatedAt:   time.Unix(s.CreatedAt, 0),
+				AccessedAt:  time.Unix(s.AccessedAt, 0),
+			})
+		}
+	}
+	if i.refresh != nil {
+		tokens, err := i.refresh.ListRefreshTokens(tenantID, subject)
+		if err != nil {
+			return nil, fmt.Errorf("list refresh tokens %w", err)
+		}
+		for _, rt := range tokens {
+			out.RefreshTokens = append(out.RefreshTokens, &RefreshTokenInfo{
+				Fingerprint: audit.Fingerprint(rt.Signature),
+				ClientID:    rt.ClientID,
+				Scope:       rt.Scope,
+				ExpiresAt:   rt.ExpiresAt,
+			})
+		}
+	}
+	if i.keys != nil {
+		keys, err := i.keys.List(tenantID, subject)
+		if err != nil {
+			return nil, fmt.Errorf("list api keys %w", err)
+		}
+		for _, k := range keys {
+			if k.Active() {
+				out.APIKeys = append(out.APIKeys, k)
+			}
+		}
+	}
+	return out, nil
+}
+
+// LogoutEverywhere ends the sessions and refresh tokens of subject in the tenant, the same
+// subject in other tenants is another user. Access tokens already issued stay valid until they
+// expire and API keys are left to be revoked explicitly.
+func (i *Inventory) LogoutEverywhere(tenantID, subject string) error {
+	if i.sessions != nil {
+		if err := i.sessions.RevokeSubject(tenantID, subject); err != nil {
+			return fmt.Errorf("revoke sessions %w", err)
+		}
+	}
+	if i.refresh != nil {
+		if err := i.refresh.RevokeSubject(tenantID, subject); err != nil {
+			return fmt.Errorf("revoke refresh tokens %w", err)
+		}
+	}
+	return nil
+}
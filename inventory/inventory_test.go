@@ -0,0 +1,124 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/oauth/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newInventory(t *testing.T) *Inventory {
+	sessions := session.NewMemoryStore()
+	refresh := server.NewMemoryStorage()
+	keys := apikey.NewManager(apikey.Config{}, apikey.NewMemoryStore())
+	for _, c := range []struct{ id, tenant, subject string }{
+		{"s1", "t1", "alice"},
+		{"s2", "t1", "alice"},
+		{"s3", "t1", "bob"},
+		{"s4", "t2", "carol"},
+	} {
+		claims := &token.Claims{Subject: c.subject, TenantID: c.tenant}
+		assert.NoError(t, sessions.Save(context.Background(), &session.Session{ID: c.id, Claims: claims, CreatedAt: time.Now().Unix()}, time.Hour))
+		assert.NoError(t, refresh.SaveRefreshToken(&server.RefreshToken{Signature: "rt-" + c.id, ClientID: "web", Claims: claims, ExpiresAt: time.Now().Add(time.Hour)}))
+	}
+	// expired credentials are not counted.
+	assert.NoError(t, refresh.SaveRefreshToken(&server.RefreshToken{Signature: "rt-old", Claims: &token.Claims{Subject: "alice", TenantID: "t1"}, ExpiresAt: time.Now().Add(-time.Hour)}))
+	_, _, err := keys.Create(apikey.CreateOptions{TenantID: "t1", Owner: "alice"})
+	assert.NoError(t, err)
+	_, revoked, err := keys.Create(apikey.CreateOptions{TenantID: "t1", Owner: "alice"})
+	assert.NoError(t, err)
+	assert.NoError(t, keys.Revoke(revoked.ID))
+	return New(sessions, refresh, keys)
+}
+
+func TestInventory(t *testing.T) {
+	inv := newInventory(t)
+
+	counts, err := inv.Counts("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Counts{Sessions: 3, RefreshTokens: 3, APIKeys: 1}, counts)
+
+	alice, err := inv.Subject("t1", "alice")
+	assert.NoError(t, err)
+	assert.Len(t, alice.Sessions, 2)
+	assert.Len(t, alice.RefreshTokens, 2)
+	assert.Len(t, alice.APIKeys, 1)
+	assert.NotEqual(t, "s1", alice.Sessions[0].Fingerprint)
+
+	// carol is not in t1.
+	carol, err := inv.Subject("t1", "carol")
+	assert.NoError(t, err)
+	assert.Empty(t, carol.Sessions)
+
+	// alice of t2 is another user.
+	assert.NoError(t, inv.LogoutEverywhere("t2", "alice"))
+	counts, err = inv.Counts("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Counts{Sessions: 3, RefreshTokens: 3, APIKeys: 1}, counts)
+	assert.NoError(t, inv.LogoutEverywhere("t1", "alice"))
+	counts, err = inv.Counts("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Counts{Sessions: 1, RefreshTokens: 1, APIKeys: 1}, counts)
+
+	empty, err := New(nil, nil, nil).Counts("t1")
+	assert.NoError(t, err)
+	assert.Equal(t, &Counts{}, empty)
+}
+
+func TestHandler(t *testing.T) {
+	inv := newInventory(t)
+	serve := func(method, target string, claims *token.Claims) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if claims != nil {
+			r = r.WithContext(middleware.WithClaims(r.Context(), claims))
+		}
+		w := httptest.NewRecorder()
+		inv.Handler().ServeHTTP(w, r)
+		return w
+	}
+	admin := &token.Claims{Subject: "admin", TenantID: "t1"}
+
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/", nil).Code)
+
+	w := serve(http.MethodGet, "/", admin)
+	assert.Equal(t, http.StatusOK, w.Code)
+	counts := &Counts{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), counts))
+	assert.Equal(t, 3, counts.Sessions)
+
+	w = serve(http.MethodGet, "/?subject=bob", admin)
+	assert.Equal(t, http.StatusOK, w.Code)
+	bob := &Subject{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), bob))
+	assert.Len(t, bob.Sessions, 1)
+	assert.Equal(t, "web", bob.RefreshTokens[0].ClientID)
+
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/", &token.Claims{Subject: "bob", TenantID: "t1"}).Code)
+	bob, err := inv.Subject("t1", "bob")
+	assert.NoError(t, err)
+	assert.Empty(t, bob.Sessions)
+	assert.Empty(t, bob.RefreshTokens)
+}
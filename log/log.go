@@ -0,0 +1,195 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package log is the logging front of the security components. Entries go to the tkeel kit
+// logger until another Logger is set, adapters to zap, logr or slog are a few lines:
+//
+//	log.SetLogger(log.LoggerFunc(func(level log.Level, msg string, keysAndValues ...interface{}) {
+//		sugar.Logw(zapcore.Level(level-1), msg, keysAndValues...)
+//	}))
+//
+// Components take their own Logger as an option or with a SetLogger method, they write to it
+// through a Helper and fall back to the logger set here.
+//
+// Messages are scrubbed of credentials before they reach the Logger, and values of sensitive
+// keys are redacted, see Scrub. Log identifiers of tokens with Token rather than the tokens.
+package log
+
+import (
+	"fmt"
+	"sync"
+
+	kitlog "github.com/tkeel-io/kit/log"
+)
+
+// Level the severity of an entry.
+type Level int8
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", l)
+}
+
+// Logger receives the entries, keysAndValues alternate keys and values.
+type Logger interface {
+	Log(level Level, msg string, keysAndValues ...interface{})
+}
+
+// LoggerFunc adapts a func to a Logger.
+type LoggerFunc func(level Level, msg string, keysAndValues ...interface{})
+
+func (f LoggerFunc) Log(level Level, msg string, keysAndValues ...interface{}) {
+	f(level, msg, keysAndValues...)
+}
+
+var (
+	_lock   sync.RWMutex
+	_logger Logger = kitLogger{}
+)
+
+// SetLogger makes l the logger of all components, nil restores the kit logger.
+func SetLogger(l Logger) {
+	_lock.Lock()
+	defer _lock.Unlock()
+	if l == nil {
+		l = kitLogger{}
+	}
+	_logger = l
+}
+
+func Debugf(format string, args ...interface{}) {
+	(*Helper)(nil).logf(LevelDebug, format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	(*Helper)(nil).logf(LevelInfo, format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	(*Helper)(nil).logf(LevelWarn, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	(*Helper)(nil).logf(LevelError, format, args...)
+}
+
+func Debugw(msg string, keysAndValues ...interface{}) {
+	(*Helper)(nil).logw(LevelDebug, msg, keysAndValues...)
+}
+
+func Infow(msg string, keysAndValues ...interface{}) {
+	(*Helper)(nil).logw(LevelInfo, msg, keysAndValues...)
+}
+
+func Warnw(msg string, keysAndValues ...interface{}) {
+	(*Helper)(nil).logw(LevelWarn, msg, keysAndValues...)
+}
+
+func Errorw(msg string, keysAndValues ...interface{}) {
+	(*Helper)(nil).logw(LevelError, msg, keysAndValues...)
+}
+
+// Helper scrubs entries and writes them to the Logger of a component. A nil Helper, or one
+// without Logger, writes to the logger set with SetLogger.
+type Helper struct {
+	logger Logger
+}
+
+// NewHelper returns a Helper writing to l.
+func NewHelper(l Logger) *Helper {
+	return &Helper{logger: l}
+}
+
+func (h *Helper) Debugf(format string, args ...interface{}) {
+	h.logf(LevelDebug, format, args...)
+}
+
+func (h *Helper) Infof(format string, args ...interface{}) {
+	h.logf(LevelInfo, format, args...)
+}
+
+func (h *Helper) Warnf(format string, args ...interface{}) {
+	h.logf(LevelWarn, format, args...)
+}
+
+func (h *Helper) Errorf(format string, args ...interface{}) {
+	h.logf(LevelError, format, args...)
+}
+
+func (h *Helper) Debugw(msg string, keysAndValues ...interface{}) {
+	h.logw(LevelDebug, msg, keysAndValues...)
+}
+
+func (h *Helper) Infow(msg string, keysAndValues ...interface{}) {
+	h.logw(LevelInfo, msg, keysAndValues...)
+}
+
+func (h *Helper) Warnw(msg string, keysAndValues ...interface{}) {
+	h.logw(LevelWarn, msg, keysAndValues...)
+}
+
+func (h *Helper) Errorw(msg string, keysAndValues ...interface{}) {
+	h.logw(LevelError, msg, keysAndValues...)
+}
+
+func (h *Helper) logf(level Level, format string, args ...interface{}) {
+	h.target().Log(level, Scrub(fmt.Sprintf(format, args...)))
+}
+
+func (h *Helper) logw(level Level, msg string, keysAndValues ...interface{}) {
+	h.target().Log(level, Scrub(msg), redactPairs(keysAndValues)...)
+}
+
+func (h *Helper) target() Logger {
+	if h == nil || h.logger == nil {
+		return logger()
+	}
+	return h.logger
+}
+
+func logger() Logger {
+	_lock.RLock()
+	defer _lock.RUnlock()
+	return _logger
+}
+
+type kitLogger struct{}
+
+func (kitLogger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	switch level {
+	case LevelDebug:
+		kitlog.Debugw(msg, keysAndValues...)
+	case LevelInfo:
+		kitlog.Infow(msg, keysAndValues...)
+	case LevelWarn:
+		kitlog.Warnw(msg, keysAndValues...)
+	default:
+		kitlog.Errorw(msg, keysAndValues...)
+	}
+}
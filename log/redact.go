@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces credentials in log entries.
+const Redacted = "******"
+
+var (
+	// _jwtPattern compact JWS and JWE, their header always starts with {" encoded.
+	_jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]{4,}\.[A-Za-z0-9_-]{4,}(\.[A-Za-z0-9_-]*){1,3}`)
+	// _pasetoPattern PASETO tokens.
+	_pasetoPattern = regexp.MustCompile(`v[1-4]\.(local|public)\.[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)?`)
+	// _schemePattern credentials of Authorization headers.
+	_schemePattern = regexp.MustCompile(`(?i)\b(bearer|basic|dpop)\s+[A-Za-z0-9._~+/=-]+`)
+	// _paramPattern sensitive query, form, key=value and JSON parameters.
+	_paramPattern = regexp.MustCompile(`(?i)\b(access_token|refresh_token|id_token|subject_token|actor_token|token|` +
+		`client_secret|secret|password|passwd|code|device_code|user_code|code_verifier|otp|api_key|client_assertion|assertion)` +
+		`(=|"\s*:\s*")([^&\s"',;]+)`)
+
+	// _sensitiveKeys keys of structured values containing one of these are redacted.
+	_sensitiveKeys = []string{"token", "secret", "password", "passwd", "otp", "code", "credential", "authorization", "cookie", "private", "api_key", "apikey"}
+)
+
+// Scrub masks the credentials in s: JWTs, PASETO tokens, Authorization header values and the
+// values of sensitive parameters such as access_token=, code= or "password":"...".
+func Scrub(s string) string {
+	s = _jwtPattern.ReplaceAllString(s, Redacted)
+	s = _pasetoPattern.ReplaceAllString(s, Redacted)
+	s = _schemePattern.ReplaceAllString(s, "$1 "+Redacted)
+	return _paramPattern.ReplaceAllString(s, "${1}${2}"+Redacted)
+}
+
+// Secret returns Redacted when s is set, for logging whether a secret is configured.
+func Secret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// Token returns a fingerprint of a token, code or key, identifying it across log lines and
+// audit events without revealing it.
+func Token(s string) string {
+	if s == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// IsSensitive reports whether values under key hold credentials.
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range _sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactPairs redacts the values of sensitive keys and scrubs the string values of the others.
+func redactPairs(keysAndValues []interface{}) []interface{} {
+	out := make([]interface{}, len(keysAndValues))
+	copy(out, keysAndValues)
+	for i := 0; i+1 < len(out); i += 2 {
+		key := fmt.Sprint(out[i])
+		switch v := out[i+1].(type) {
+		case nil:
+		case string:
+			if IsSensitive(key) {
+				out[i+1] = Secret(v)
+			} else {
+				out[i+1] = Scrub(v)
+			}
+		case error:
+			out[i+1] = Scrub(v.Error())
+		default:
+			if IsSensitive(key) {
+				out[i+1] = Redacted
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"github.com/tkeel-io/security/inventory"
+	"github.com/tkeel-io/security/log"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InventoryCollector reports the credentials outstanding per tenant as gauges, computed on
+// each scrape. Each tenant is a label value, so only use it with a bounded number of tenants.
+type InventoryCollector struct {
+	inv     *inventory.Inventory
+	tenants func() ([]string, error)
+
+	sessions      *prometheus.Desc
+	refreshTokens *prometheus.Desc
+	apiKeys       *prometheus.Desc
+	logger        *log.Helper
+}
+
+var _ prometheus.Collector = &InventoryCollector{}
+
+// NewInventoryCollector returns a collector of inv for the tenants listed by tenants,
+// register it with reg.MustRegister.
+func NewInventoryCollector(inv *inventory.Inventory, tenants func() ([]string, error)) *InventoryCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(_namespace, "inventory", name), help, []string{"tenant"}, nil)
+	}
+	return &InventoryCollector{
+		inv:           inv,
+		tenants:       tenants,
+		sessions:      desc("sessions", "Active sessions by tenant."),
+		refreshTokens: desc("refresh_tokens", "Outstanding refresh tokens by tenant."),
+		apiKeys:       desc("api_keys", "Active API keys by tenant."),
+	}
+}
+
+// SetLogger writes the entries of the collection failures to l instead of the global logger.
+func (c *InventoryCollector) SetLogger(l log.Logger) {
+	c.logger = log.NewHelper(l)
+}
+
+func (c *InventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.sessions
+	ch <- c.refreshTokens
+	ch <- c.apiKeys
+}
+
+func (c *InventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	tenants, err := c.tenants()
+	if err != nil {
+		c.logger.Errorf("inventory metrics: list tenants %s", err)
+		return
+	}
+	for _, tenantID := range tenants {
+		counts, err := c.inv.Counts(tenantID)
+		if err != nil {
+			c.logger.Errorf("inventory metrics: tenant %s %s", tenantID, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.sessions, prometheus.GaugeValue, float64(counts.Sessions), tenantID)
+		ch <- prometheus.MustNewConstMetric(c.refreshTokens, prometheus.GaugeValue, float64(counts.RefreshTokens), tenantID)
+		ch <- prometheus.MustNewConstMetric(c.apiKeys, prometheus.GaugeValue, float64(counts.APIKeys), tenantID)
+	}
+}
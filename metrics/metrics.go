@@ -0,0 +1,300 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics instruments authentication and authorization with Prometheus collectors.
+// Register them on a registry and serve it with promhttp, e.g.
+//
+//	reg := prometheus.NewRegistry()
+//	m, err := metrics.New(reg)
+//	verifier := m.Verifier(manager)
+//	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const _namespace = "tkeel_security"
+
+// Results of the observed operations.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultAllow   = "allow"
+	ResultDeny    = "deny"
+	ResultError   = "error"
+	ResultHit     = "hit"
+	ResultMiss    = "miss"
+)
+
+// Metrics the collectors, create it once per registry.
+type Metrics struct {
+	logins         *prometheus.CounterVec
+	issued         *prometheus.CounterVec
+	verifyDuration *prometheus.HistogramVec
+	checkDuration  *prometheus.HistogramVec
+	jwksRefreshes  *prometheus.CounterVec
+	cacheLookups   *prometheus.CounterVec
+}
+
+// New creates the collectors and registers them on reg.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: _namespace,
+			Subsystem: "authn",
+			Name:      "logins_total",
+			Help:      "Logins through identity providers by provider and result.",
+		}, []string{"provider", "result"}),
+		issued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: _namespace,
+			Subsystem: "token",
+			Name:      "issued_total",
+			Help:      "Tokens issued by result.",
+		}, []string{"result"}),
+		verifyDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: _namespace,
+			Subsystem: "token",
+			Name:      "verify_duration_seconds",
+			Help:      "Latency of token verification by result.",
+			Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+		}, []string{"result"}),
+		checkDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: _namespace,
+			Subsystem: "authz",
+			Name:      "check_duration_seconds",
+			Help:      "Latency of policy checks by decision.",
+			Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+		}, []string{"result"}),
+		jwksRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: _namespace,
+			Subsystem: "authn",
+			Name:      "jwks_refreshes_total",
+			Help:      "JWKS fetches by key source and result.",
+		}, []string{"source", "result"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: _namespace,
+			Subsystem: "cache",
+			Name:      "lookups_total",
+			Help:      "Cache lookups by cache and result.",
+		}, []string{"cache", "result"}),
+	}
+	for _, c := range []prometheus.Collector{m.logins, m.issued, m.verifyDuration, m.checkDuration, m.jwksRefreshes, m.cacheLookups} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("register metrics %w", err)
+		}
+	}
+	return m, nil
+}
+
+// ObserveLogin counts a login through provider, for logins not going through Provider.
+func (m *Metrics) ObserveLogin(provider string, err error) {
+	m.logins.WithLabelValues(provider, result(err)).Inc()
+}
+
+var (
+	_ idprovider.Provider        = &instrumentedProvider{}
+	_ idprovider.Tester          = &instrumentedProvider{}
+	_ idprovider.ContextProvider = &instrumentedProvider{}
+	_ token.Manager              = &instrumentedManager{}
+	_ authorizer.Checker         = &instrumentedChecker{}
+	_ cache.Purger               = &instrumentedCache{}
+)
+
+// Provider counts the logins through p, labelled with its type.
+func (m *Metrics) Provider(p idprovider.Provider) idprovider.Provider {
+	return &instrumentedProvider{Provider: p, m: m}
+}
+
+type instrumentedProvider struct {
+	idprovider.Provider
+	m *Metrics
+}
+
+func (p *instrumentedProvider) AuthenticateCode(code string) (idprovider.Identity, error) {
+	identity, err := p.Provider.AuthenticateCode(code)
+	p.m.ObserveLogin(p.Type(), err)
+	return identity, err
+}
+
+func (p *instrumentedProvider) Authenticate(username, password string) (idprovider.Identity, error) {
+	identity, err := p.Provider.Authenticate(username, password)
+	p.m.ObserveLogin(p.Type(), err)
+	return identity, err
+}
+
+func (p *instrumentedProvider) AuthenticateCodeContext(ctx context.Context, code string) (idprovider.Identity, error) {
+	identity, err := idprovider.AuthenticateCode(ctx, p.Provider, code)
+	p.m.ObserveLogin(p.Type(), err)
+	return identity, err
+}
+
+func (p *instrumentedProvider) AuthenticateContext(ctx context.Context, username, password string) (idprovider.Identity, error) {
+	identity, err := idprovider.Authenticate(ctx, p.Provider, username, password)
+	p.m.ObserveLogin(p.Type(), err)
+	return identity, err
+}
+
+// Test keeps the wrapped provider testable, providers without a test pass.
+func (p *instrumentedProvider) Test(ctx context.Context) error {
+	if tester, ok := p.Provider.(idprovider.Tester); ok {
+		return tester.Test(ctx)
+	}
+	return nil
+}
+
+// Issuer counts the tokens issued by i.
+func (m *Metrics) Issuer(i token.Issuer) token.Issuer {
+	return &instrumentedIssuer{Issuer: i, m: m}
+}
+
+type instrumentedIssuer struct {
+	token.Issuer
+	m *Metrics
+}
+
+func (i *instrumentedIssuer) Issue(claims *token.Claims) (string, error) {
+	raw, err := i.Issuer.Issue(claims)
+	i.m.issued.WithLabelValues(result(err)).Inc()
+	return raw, err
+}
+
+// Verifier times the verifications of v.
+func (m *Metrics) Verifier(v token.Verifier) token.Verifier {
+	return &instrumentedVerifier{Verifier: v, m: m}
+}
+
+type instrumentedVerifier struct {
+	token.Verifier
+	m *Metrics
+}
+
+func (v *instrumentedVerifier) Verify(raw string) (*token.Claims, error) {
+	start := time.Now()
+	claims, err := v.Verifier.Verify(raw)
+	v.m.verifyDuration.WithLabelValues(result(err)).Observe(time.Since(start).Seconds())
+	return claims, err
+}
+
+// Manager counts the tokens issued and times the verifications of tm.
+func (m *Metrics) Manager(tm token.Manager) token.Manager {
+	return &instrumentedManager{
+		instrumentedIssuer:   &instrumentedIssuer{Issuer: tm, m: m},
+		instrumentedVerifier: &instrumentedVerifier{Verifier: tm, m: m},
+	}
+}
+
+type instrumentedManager struct {
+	*instrumentedIssuer
+	*instrumentedVerifier
+}
+
+// Checker times the policy checks of c.
+func (m *Metrics) Checker(c authorizer.Checker) authorizer.Checker {
+	return &instrumentedChecker{Checker: c, m: m}
+}
+
+type instrumentedChecker struct {
+	authorizer.Checker
+	m *Metrics
+}
+
+func (c *instrumentedChecker) Check(subject, tenantID, resource, action string) (bool, error) {
+	start := time.Now()
+	ok, err := c.Checker.Check(subject, tenantID, resource, action)
+	decision := ResultDeny
+	switch {
+	case err != nil:
+		decision = ResultError
+	case ok:
+		decision = ResultAllow
+	}
+	c.m.checkDuration.WithLabelValues(decision).Observe(time.Since(start).Seconds())
+	return ok, err
+}
+
+// Cache counts the hits and misses of c under name, lookups failing otherwise count as errors.
+func (m *Metrics) Cache(name string, c cache.Cache) cache.Cache {
+	return &instrumentedCache{Cache: c, name: name, m: m}
+}
+
+type instrumentedCache struct {
+	cache.Cache
+	name string
+	m    *Metrics
+}
+
+func (c *instrumentedCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.Cache.Get(ctx, key)
+	res := ResultHit
+	switch {
+	case errors.Is(err, cache.ErrNotFound):
+		res = ResultMiss
+	case err != nil:
+		res = ResultError
+	}
+	c.m.cacheLookups.WithLabelValues(c.name, res).Inc()
+	return value, err
+}
+
+func (c *instrumentedCache) Purge(ctx context.Context) error {
+	return cache.Purge(ctx, c.Cache)
+}
+
+// JWKSClient returns a copy of client, utils.DefaultHTTPClient when nil, counting its requests as
+// JWKS refreshes of source. Give it to the fetchers of key sets, e.g. svctoken.NewRemoteKeySet.
+func (m *Metrics) JWKSClient(source string, client *http.Client) *http.Client {
+	if client == nil {
+		client = utils.DefaultHTTPClient
+	}
+	c := *client
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := base.RoundTrip(r)
+		res := ResultSuccess
+		if err != nil || resp.StatusCode != http.StatusOK {
+			res = ResultFailure
+		}
+		m.jwksRefreshes.WithLabelValues(source, res).Inc()
+		return resp, err
+	})
+	return &c
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/cache"
+	"github.com/tkeel-io/security/inventory"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProvider struct {
+	idprovider.Provider
+}
+
+func (fakeProvider) Type() string { return "fake" }
+
+func (fakeProvider) Authenticate(username, password string) (idprovider.Identity, error) {
+	if password != "secret" {
+		return nil, errors.New("bad credentials")
+	}
+	return nil, nil
+}
+
+type checkerFunc func(subject, tenantID, resource, action string) (bool, error)
+
+func (f checkerFunc) Check(subject, tenantID, resource, action string) (bool, error) {
+	return f(subject, tenantID, resource, action)
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	assert.NoError(t, err)
+	_, err = New(reg)
+	assert.Error(t, err, "collectors register once per registry")
+
+	p := m.Provider(fakeProvider{})
+	_, _ = p.Authenticate("alice", "secret")
+	_, _ = p.Authenticate("alice", "wrong")
+	_, _ = p.Authenticate("alice", "wrong")
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.logins.WithLabelValues("fake", ResultSuccess)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.logins.WithLabelValues("fake", ResultFailure)))
+
+	jwt, err := token.NewJWTManager(&token.Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	tm := m.Manager(jwt)
+	raw, err := tm.Issue(&token.Claims{Subject: "alice"})
+	assert.NoError(t, err)
+	_, err = tm.Verify(raw)
+	assert.NoError(t, err)
+	_, err = m.Verifier(jwt).Verify("garbage")
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.issued.WithLabelValues(ResultSuccess)))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.verifyDuration))
+
+	c := m.Checker(checkerFunc(func(subject, tenantID, resource, action string) (bool, error) {
+		return subject == "alice", nil
+	}))
+	ok, err := c.Check("alice", "t1", "device", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, _ = c.Check("bob", "t1", "device", "read")
+	assert.False(t, ok)
+	assert.Equal(t, 2, testutil.CollectAndCount(m.checkDuration))
+
+	checks := 0
+	cached := authorizer.NewCachedChecker(checkerFunc(func(subject, tenantID, resource, action string) (bool, error) {
+		checks++
+		return c.Check(subject, tenantID, resource, action)
+	}), m.Cache("authz", cache.NewLRU(0)), time.Minute)
+	for i := 0; i < 3; i++ {
+		ok, err = cached.Check("alice", "t1", "device", "read")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 1, checks, "decisions served from the cache")
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.cacheLookups.WithLabelValues("authz", ResultHit)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.cacheLookups.WithLabelValues("authz", ResultMiss)))
+	assert.NoError(t, cached.Purge())
+	_, _ = cached.Check("alice", "t1", "device", "read")
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.cacheLookups.WithLabelValues("authz", ResultMiss)))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jwks" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer srv.Close()
+	client := m.JWKSClient("svc", nil)
+	for _, path := range []string{"/jwks", "/missing"} {
+		resp, err := client.Get(srv.URL + path)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.jwksRefreshes.WithLabelValues("svc", ResultSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.jwksRefreshes.WithLabelValues("svc", ResultFailure)))
+}
+
+func TestInventoryCollector(t *testing.T) {
+	sessions := session.NewMemoryStore()
+	claims := &token.Claims{Subject: "alice", TenantID: "t1"}
+	assert.NoError(t, sessions.Save(context.Background(), &session.Session{ID: "s1", Claims: claims}, time.Hour))
+	assert.NoError(t, sessions.Save(context.Background(), &session.Session{ID: "s2", Claims: claims}, time.Hour))
+	inv := inventory.New(sessions, nil, nil)
+
+	c := NewInventoryCollector(inv, func() ([]string, error) { return []string{"t1", "t2"}, nil })
+	expected := `
+# HELP tkeel_security_inventory_sessions Active sessions by tenant.
+# TYPE tkeel_security_inventory_sessions gauge
+tkeel_security_inventory_sessions{tenant="t1"} 2
+tkeel_security_inventory_sessions{tenant="t2"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(expected), "tkeel_security_inventory_sessions"))
+	assert.Equal(t, 6, testutil.CollectAndCount(c))
+}
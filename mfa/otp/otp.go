@@ -0,0 +1,271 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package otp sends one-time codes by SMS or email, for passwordless login or as second factor.
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	_defaultLength         = 6
+	_defaultTTL            = 5 * time.Minute
+	_defaultMaxAttempts    = 5
+	_defaultResendInterval = time.Minute
+	_defaultMaxPerWindow   = 10
+	_defaultWindow         = time.Hour
+
+	// PurposeLogin codes authenticating the owner of a phone number or email address.
+	PurposeLogin = "login"
+	// PurposeSecondFactor codes confirming a login after the password.
+	PurposeSecondFactor = "mfa"
+)
+
+var (
+	// ErrInvalidCode the code is wrong, expired or was used.
+	ErrInvalidCode = errors.New("invalid one-time code")
+	// ErrTooManyAttempts the code was guessed wrong too often and is void.
+	ErrTooManyAttempts = errors.New("too many one-time code attempts")
+	// ErrRateLimited codes were sent to the destination too recently or too often.
+	ErrRateLimited = errors.New("one-time code rate limited")
+)
+
+// Sender delivers codes.
+type Sender interface {
+	// Send delivers code to the phone number or email address to, valid for ttl.
+	Send(ctx context.Context, to, code string, ttl time.Duration) error
+}
+
+// SenderFunc adapts a function to a Sender.
+type SenderFunc func(ctx context.Context, to, code string, ttl time.Duration) error
+
+func (f SenderFunc) Send(ctx context.Context, to, code string, ttl time.Duration) error {
+	return f(ctx, to, code, ttl)
+}
+
+// Challenge the state of the codes of a key.
+type Challenge struct {
+	// Hash of the outstanding code, empty once used.
+	Hash      string    `json:"hash,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Attempts  int       `json:"attempts"`
+	// Sent times codes were sent within the rate limit window.
+	Sent []time.Time `json:"sent"`
+}
+
+// Store keeps the challenges.
+type Store interface {
+	// Save stores c under key, expiring after ttl.
+	Save(key string, c *Challenge, ttl time.Duration) error
+	// Load returns the challenge of key, nil when there is none.
+	Load(key string) (*Challenge, error)
+}
+
+// Config of the codes.
+type Config struct {
+	// Length digits of a code. Default to 6.
+	Length int `mapstructure:"length" json:"length" yaml:"length"`
+	// TTL how long a code is valid. Default to 5m.
+	TTL time.Duration `mapstructure:"ttl" json:"ttl" yaml:"ttl"`
+	// MaxAttempts wrong guesses voiding a code. Default to 5.
+	MaxAttempts int `mapstructure:"max_attempts" json:"max_attempts" yaml:"maxAttempts"`
+	// ResendInterval minimum time between two codes to the same destination. Default to 1m.
+	ResendInterval time.Duration `mapstructure:"resend_interval" json:"resend_interval" yaml:"resendInterval"`
+	// MaxPerWindow codes sent to the same destination per Window. Default to 10 per hour.
+	MaxPerWindow int           `mapstructure:"max_per_window" json:"max_per_window" yaml:"maxPerWindow"`
+	Window       time.Duration `mapstructure:"window" json:"window" yaml:"window"`
+}
+
+// Service issues and verifies codes.
+type Service struct {
+	conf   Config
+	sender Sender
+	store  Store
+	// lock serializes the read-modify-write of challenges within the replica.
+	lock sync.Mutex
+}
+
+// NewService returns a Service, store defaults to a MemoryStore.
+func NewService(conf Config, sender Sender, store Store) *Service {
+	if conf.Length <= 0 {
+		conf.Length = _defaultLength
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = _defaultTTL
+	}
+	if conf.MaxAttempts <= 0 {
+		conf.MaxAttempts = _defaultMaxAttempts
+	}
+	if conf.ResendInterval <= 0 {
+		conf.ResendInterval = _defaultResendInterval
+	}
+	if conf.MaxPerWindow <= 0 {
+		conf.MaxPerWindow = _defaultMaxPerWindow
+	}
+	if conf.Window <= 0 {
+		conf.Window = _defaultWindow
+	}
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &Service{conf: conf, sender: sender, store: store}
+}
+
+// SendLoginCode sends a passwordless login code to the phone number or email address to.
+func (s *Service) SendLoginCode(ctx context.Context, to string) error {
+	return s.send(ctx, PurposeLogin+":"+to, to)
+}
+
+// VerifyLogin checks the login code sent to to, the caller then signs in the owner of to.
+func (s *Service) VerifyLogin(to, code string) error {
+	return s.verify(PurposeLogin+":"+to, code)
+}
+
+// SendSecondFactor sends a second factor code of userID to the phone number or email address to.
+func (s *Service) SendSecondFactor(ctx context.Context, userID, to string) error {
+	return s.send(ctx, PurposeSecondFactor+":"+userID, to)
+}
+
+// VerifySecondFactor checks the second factor code sent for userID.
+func (s *Service) VerifySecondFactor(userID, code string) error {
+	return s.verify(PurposeSecondFactor+":"+userID, code)
+}
+
+func (s *Service) send(ctx context.Context, key, to string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, err := s.store.Load(key)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		c = &Challenge{}
+	}
+	now := time.Now()
+	sent := c.Sent[:0]
+	for _, t := range c.Sent {
+		if now.Sub(t) < s.conf.Window {
+			sent = append(sent, t)
+		}
+	}
+	if len(sent) >= s.conf.MaxPerWindow || (len(sent) > 0 && now.Sub(sent[len(sent)-1]) < s.conf.ResendInterval) {
+		return ErrRateLimited
+	}
+	code, err := newCode(s.conf.Length)
+	if err != nil {
+		return err
+	}
+	c.Hash, c.ExpiresAt, c.Attempts, c.Sent = hash(key, code), now.Add(s.conf.TTL), 0, append(sent, now)
+	if err = s.store.Save(key, c, s.conf.Window); err != nil {
+		return fmt.Errorf("save one-time code %w", err)
+	}
+	if err = s.sender.Send(ctx, to, code, s.conf.TTL); err != nil {
+		return fmt.Errorf("send one-time code %w", err)
+	}
+	return nil
+}
+
+func (s *Service) verify(key, code string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c, err := s.store.Load(key)
+	if err != nil {
+		return err
+	}
+	if c == nil || c.Hash == "" || time.Now().After(c.ExpiresAt) {
+		return ErrInvalidCode
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Hash), []byte(hash(key, strings.TrimSpace(code)))) == 1 {
+		c.Hash = ""
+		return s.store.Save(key, c, s.conf.Window)
+	}
+	c.Attempts++
+	err = ErrInvalidCode
+	if c.Attempts >= s.conf.MaxAttempts {
+		c.Hash, err = "", ErrTooManyAttempts
+	}
+	if serr := s.store.Save(key, c, s.conf.Window); serr != nil {
+		return fmt.Errorf("save one-time code %w", serr)
+	}
+	return err
+}
+
+// hash binds code to key, a code sent to one destination does not verify for another.
+func hash(key, code string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + code))
+	return fmt.Sprintf("%x", sum)
+}
+
+func newCode(length int) (string, error) {
+	digits := make([]byte, length)
+	for i := range digits {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", fmt.Errorf("one-time code %w", err)
+		}
+		digits[i] = byte('0' + n.Int64())
+	}
+	return string(digits), nil
+}
+
+type memoryEntry struct {
+	challenge Challenge
+	expireAt  time.Time
+}
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock    sync.Mutex
+	entries map[string]memoryEntry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry)}
+}
+
+func (s *MemoryStore) Save(key string, c *Challenge, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for k, entry := range s.entries {
+		if now.After(entry.expireAt) {
+			delete(s.entries, k)
+		}
+	}
+	entry := memoryEntry{challenge: *c, expireAt: now.Add(ttl)}
+	entry.challenge.Sent = append([]time.Time(nil), c.Sent...)
+	s.entries[key] = entry
+	return nil
+}
+
+func (s *MemoryStore) Load(key string) (*Challenge, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.expireAt) {
+		return nil, nil
+	}
+	c := entry.challenge
+	c.Sent = append([]time.Time(nil), entry.challenge.Sent...)
+	return &c, nil
+}
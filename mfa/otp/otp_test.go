@@ -0,0 +1,119 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recorder struct {
+	to, code string
+}
+
+func (r *recorder) Send(ctx context.Context, to, code string, ttl time.Duration) error {
+	r.to, r.code = to, code
+	return nil
+}
+
+func TestService(t *testing.T) {
+	sender := &recorder{}
+	s := NewService(Config{MaxAttempts: 2, ResendInterval: time.Millisecond, MaxPerWindow: 3}, sender, nil)
+	ctx := context.Background()
+
+	assert.Nil(t, s.SendLoginCode(ctx, "+8613800000000"))
+	assert.Equal(t, "+8613800000000", sender.to)
+	assert.Len(t, sender.code, 6)
+	assert.ErrorIs(t, s.VerifySecondFactor("+8613800000000", sender.code), ErrInvalidCode)
+	assert.Nil(t, s.VerifyLogin("+8613800000000", sender.code))
+	assert.ErrorIs(t, s.VerifyLogin("+8613800000000", sender.code), ErrInvalidCode)
+
+	time.Sleep(2 * time.Millisecond)
+	assert.Nil(t, s.SendSecondFactor(ctx, "u1", "a@example.com"))
+	code := sender.code
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	assert.ErrorIs(t, s.VerifySecondFactor("u1", wrong), ErrInvalidCode)
+	assert.ErrorIs(t, s.VerifySecondFactor("u1", wrong), ErrTooManyAttempts)
+	assert.ErrorIs(t, s.VerifySecondFactor("u1", code), ErrInvalidCode)
+
+	assert.ErrorIs(t, s.SendSecondFactor(ctx, "u1", "a@example.com"), ErrRateLimited)
+	for i := 0; i < 2; i++ {
+		time.Sleep(2 * time.Millisecond)
+		assert.Nil(t, s.SendSecondFactor(ctx, "u1", "a@example.com"))
+	}
+	time.Sleep(2 * time.Millisecond)
+	assert.ErrorIs(t, s.SendSecondFactor(ctx, "u1", "a@example.com"), ErrRateLimited)
+}
+
+func TestTwilioSender(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		assert.Equal(t, "/2010-04-01/Accounts/AC1/Messages.json", r.URL.Path)
+		assert.Equal(t, "AC1", user)
+		assert.Equal(t, "token", pass)
+		assert.Equal(t, "+1555", r.FormValue("From"))
+		assert.Equal(t, "code 123456 5", r.FormValue("Body"))
+		if r.FormValue("To") != "+1666" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":21211,"message":"invalid To"}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	s := &TwilioSender{AccountSID: "AC1", AuthToken: "token", From: "+1555", Template: "code {code} {minutes}", BaseURL: srv.URL}
+	assert.Nil(t, s.Send(context.Background(), "+1666", "123456", 5*time.Minute))
+	assert.EqualError(t, s.Send(context.Background(), "+1777", "123456", 5*time.Minute), "twilio send: status 400 code 21211: invalid To")
+}
+
+func TestAliyunSMSSender(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		assert.Equal(t, AliyunSignature(http.MethodGet, q, "secret"), q.Get("Signature"))
+		assert.Equal(t, "SendSms", q.Get("Action"))
+		assert.Equal(t, "tKeel", q.Get("SignName"))
+		var param map[string]string
+		assert.Nil(t, json.Unmarshal([]byte(q.Get("TemplateParam")), &param))
+		assert.Equal(t, "654321", param["code"])
+		_, _ = w.Write([]byte(`{"Code":"OK","Message":"OK"}`))
+	}))
+	defer srv.Close()
+
+	s := &AliyunSMSSender{AccessKeyID: "id", AccessKeySecret: "secret", SignName: "tKeel", TemplateCode: "SMS_1", Endpoint: srv.URL + "/"}
+	assert.Nil(t, s.Send(context.Background(), "13800000000", "654321", time.Minute))
+}
+
+func TestAliyunEscape(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"a b", "a%20b"},
+		{"a*b", "a%2Ab"},
+		{"a~b", "a~b"},
+		{"/", "%2F"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.out, aliyunEscape(tt.in))
+	}
+}
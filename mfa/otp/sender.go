@@ -0,0 +1,234 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package otp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	_defaultTemplate     = "Your verification code is {code}, valid for {minutes} minutes."
+	_defaultSubject      = "Verification code"
+	_defaultTwilioURL    = "https://api.twilio.com"
+	_defaultAliyunURL    = "https://dysmsapi.aliyuncs.com/"
+	_defaultAliyunRegion = "cn-hangzhou"
+)
+
+func render(template, code string, ttl time.Duration) string {
+	if template == "" {
+		template = _defaultTemplate
+	}
+	return strings.NewReplacer("{code}", code, "{minutes}", strconv.Itoa(int(ttl.Minutes()))).Replace(template)
+}
+
+func httpClient(c *http.Client) *http.Client {
+	if c == nil {
+		return utils.DefaultHTTPClient
+	}
+	return c
+}
+
+// SMTPSender mails codes.
+type SMTPSender struct {
+	// Addr host:port of the mail server.
+	Addr     string `mapstructure:"addr" json:"addr" yaml:"addr"`
+	Username string `mapstructure:"username" json:"username" yaml:"username"`
+	Password string `mapstructure:"password" json:"password" yaml:"password"`
+	From     string `mapstructure:"from" json:"from" yaml:"from"`
+	Subject  string `mapstructure:"subject" json:"subject" yaml:"subject"`
+	// Template of the body, {code} and {minutes} are replaced.
+	Template string `mapstructure:"template" json:"template" yaml:"template"`
+}
+
+var _ Sender = &SMTPSender{}
+
+func (s *SMTPSender) Send(ctx context.Context, to, code string, ttl time.Duration) error {
+	subject := s.Subject
+	if subject == "" {
+		subject = _defaultSubject
+	}
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid recipient %q", to)
+	}
+	msg := "From: " + s.From + "\r\nTo: " + to + "\r\nSubject: " + subject +
+		"\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n" + render(s.Template, code, ttl) + "\r\n"
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("smtp addr %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if err := smtp.SendMail(s.Addr, auth, s.From, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("smtp send %w", err)
+	}
+	return nil
+}
+
+// TwilioSender texts codes through the Twilio messaging API.
+type TwilioSender struct {
+	AccountSID string `mapstructure:"account_sid" json:"account_sid" yaml:"accountSid"`
+	AuthToken  string `mapstructure:"auth_token" json:"auth_token" yaml:"authToken"`
+	// From the sending number or messaging service SID.
+	From     string `mapstructure:"from" json:"from" yaml:"from"`
+	Template string `mapstructure:"template" json:"template" yaml:"template"`
+	// BaseURL default to https://api.twilio.com.
+	BaseURL string       `mapstructure:"base_url" json:"base_url" yaml:"baseUrl"`
+	Client  *http.Client `mapstructure:"-" json:"-" yaml:"-"`
+}
+
+var _ Sender = &TwilioSender{}
+
+func (s *TwilioSender) Send(ctx context.Context, to, code string, ttl time.Duration) error {
+	base := s.BaseURL
+	if base == "" {
+		base = _defaultTwilioURL
+	}
+	form := url.Values{"To": {to}, "Body": {render(s.Template, code, ttl)}}
+	if strings.HasPrefix(s.From, "MG") {
+		form.Set("MessagingServiceSid", s.From)
+	} else {
+		form.Set("From", s.From)
+	}
+	endpoint := strings.TrimSuffix(base, "/") + "/2010-04-01/Accounts/" + url.PathEscape(s.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("twilio request %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.AccountSID, s.AuthToken)
+	resp, err := httpClient(s.Client).Do(req)
+	if err != nil {
+		return fmt.Errorf("twilio send %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var body struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body)
+		return fmt.Errorf("twilio send: status %d code %d: %s", resp.StatusCode, body.Code, body.Message)
+	}
+	return nil
+}
+
+// AliyunSMSSender texts codes through Alibaba Cloud SMS, the template receives the code as ${code}.
+type AliyunSMSSender struct {
+	AccessKeyID     string `mapstructure:"access_key_id" json:"access_key_id" yaml:"accessKeyId"`
+	AccessKeySecret string `mapstructure:"access_key_secret" json:"access_key_secret" yaml:"accessKeySecret"`
+	SignName        string `mapstructure:"sign_name" json:"sign_name" yaml:"signName"`
+	TemplateCode    string `mapstructure:"template_code" json:"template_code" yaml:"templateCode"`
+	// RegionID default to cn-hangzhou.
+	RegionID string `mapstructure:"region_id" json:"region_id" yaml:"regionId"`
+	// Endpoint default to https://dysmsapi.aliyuncs.com/.
+	Endpoint string       `mapstructure:"endpoint" json:"endpoint" yaml:"endpoint"`
+	Client   *http.Client `mapstructure:"-" json:"-" yaml:"-"`
+}
+
+var _ Sender = &AliyunSMSSender{}
+
+func (s *AliyunSMSSender) Send(ctx context.Context, to, code string, ttl time.Duration) error {
+	endpoint, region := s.Endpoint, s.RegionID
+	if endpoint == "" {
+		endpoint = _defaultAliyunURL
+	}
+	if region == "" {
+		region = _defaultAliyunRegion
+	}
+	nonce, err := utils.RandBase64String(16)
+	if err != nil {
+		return fmt.Errorf("aliyun nonce %w", err)
+	}
+	param, err := json.Marshal(map[string]string{"code": code})
+	if err != nil {
+		return fmt.Errorf("aliyun template param %w", err)
+	}
+	query := url.Values{
+		"Action":           {"SendSms"},
+		"Version":          {"2017-05-25"},
+		"Format":           {"JSON"},
+		"RegionId":         {region},
+		"AccessKeyId":      {s.AccessKeyID},
+		"SignatureMethod":  {"HMAC-SHA1"},
+		"SignatureVersion": {"1.0"},
+		"SignatureNonce":   {nonce},
+		"Timestamp":        {time.Now().UTC().Format("2006-01-02T15:04:05Z")},
+		"PhoneNumbers":     {to},
+		"SignName":         {s.SignName},
+		"TemplateCode":     {s.TemplateCode},
+		"TemplateParam":    {string(param)},
+	}
+	query.Set("Signature", AliyunSignature(http.MethodGet, query, s.AccessKeySecret))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("aliyun request %w", err)
+	}
+	resp, err := httpClient(s.Client).Do(req)
+	if err != nil {
+		return fmt.Errorf("aliyun send %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Code    string `json:"Code"`
+		Message string `json:"Message"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&body); err != nil {
+		return fmt.Errorf("aliyun response: status %d %w", resp.StatusCode, err)
+	}
+	if body.Code != "OK" {
+		return fmt.Errorf("aliyun send: %s: %s", body.Code, body.Message)
+	}
+	return nil
+}
+
+// AliyunSignature signs query with the Alibaba Cloud RPC signature version 1.0.
+func AliyunSignature(method string, query url.Values, secret string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if k != "Signature" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, aliyunEscape(k)+"="+aliyunEscape(query.Get(k)))
+	}
+	toSign := method + "&" + aliyunEscape("/") + "&" + aliyunEscape(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	mac.Write([]byte(toSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func aliyunEscape(s string) string {
+	return strings.NewReplacer("+", "%20", "*", "%2A", "%7E", "~").Replace(url.QueryEscape(s))
+}
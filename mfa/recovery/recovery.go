@@ -0,0 +1,262 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package recovery issues single-use codes standing in for a lost second factor device.
+package recovery
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	_defaultCount  = 10
+	_defaultLength = 10
+	// _alphabet lowercase letters and digits without the easily confused 0, 1, l and o.
+	_alphabet = "23456789abcdefghijkmnpqrstuvwxyz"
+)
+
+var (
+	_ Store = &MemoryStore{}
+	_ Store = &GormStore{}
+
+	// ErrInvalidCode the recovery code is unknown or was used.
+	ErrInvalidCode = errors.New("invalid recovery code")
+)
+
+// Store persists the hashes of recovery codes.
+type Store interface {
+	// Replace drops all codes of userID and stores hashes instead.
+	Replace(userID string, hashes []string) error
+	// Use marks the unused code hash of userID used, used is false when there is none.
+	Use(userID, hash string) (used bool, err error)
+	// Remaining counts the unused codes of userID.
+	Remaining(userID string) (int, error)
+}
+
+// Config of the code sets.
+type Config struct {
+	// Count codes per set. Default to 10.
+	Count int `mapstructure:"count" json:"count" yaml:"count"`
+	// Length characters per code, excluding the separator. Default to 10.
+	Length int `mapstructure:"length" json:"length" yaml:"length"`
+}
+
+// Manager generates and verifies recovery codes.
+type Manager struct {
+	conf  Config
+	store Store
+}
+
+func NewManager(conf Config, store Store) *Manager {
+	if conf.Count <= 0 {
+		conf.Count = _defaultCount
+	}
+	if conf.Length <= 0 {
+		conf.Length = _defaultLength
+	}
+	return &Manager{conf: conf, store: store}
+}
+
+// Generate issues a new set of codes for userID, invalidating the previous set. The codes are
+// returned only this once, show them to the user to write down.
+func (m *Manager) Generate(userID string) ([]string, error) {
+	codes := make([]string, 0, m.conf.Count)
+	hashes := make([]string, 0, m.conf.Count)
+	for len(codes) < m.conf.Count {
+		code, err := newCode(m.conf.Length)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash(userID, code))
+	}
+	if err := m.store.Replace(userID, hashes); err != nil {
+		return nil, fmt.Errorf("save recovery codes %w", err)
+	}
+	return codes, nil
+}
+
+// Verify consumes code of userID.
+func (m *Manager) Verify(userID, code string) error {
+	used, err := m.store.Use(userID, hash(userID, code))
+	if err != nil {
+		return fmt.Errorf("use recovery code %w", err)
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// Remaining counts the unused codes of userID, prompt the user to regenerate when few are left.
+func (m *Manager) Remaining(userID string) (int, error) {
+	return m.store.Remaining(userID)
+}
+
+// Enrolled reports whether userID has unused codes.
+func (m *Manager) Enrolled(userID string) (bool, error) {
+	n, err := m.store.Remaining(userID)
+	return n > 0, err
+}
+
+// Revoke drops all codes of userID, e.g. once the user disabled MFA.
+func (m *Manager) Revoke(userID string) error {
+	return m.store.Replace(userID, nil)
+}
+
+// Factor a second factor verifier, e.g. a *totp.Manager.
+type Factor interface {
+	Enrolled(userID string) (bool, error)
+	Verify(userID, code string) error
+}
+
+var _ Factor = &Manager{}
+
+type fallback struct {
+	primary Factor
+	codes   *Manager
+}
+
+// Fallback returns a Factor required when primary is enrolled, accepting a code of primary or a
+// recovery code in its place.
+func Fallback(primary Factor, codes *Manager) Factor {
+	return &fallback{primary: primary, codes: codes}
+}
+
+func (f *fallback) Enrolled(userID string) (bool, error) {
+	return f.primary.Enrolled(userID)
+}
+
+func (f *fallback) Verify(userID, code string) error {
+	err := f.primary.Verify(userID, code)
+	if err == nil || len(normalize(code)) != f.codes.conf.Length {
+		return err
+	}
+	if rerr := f.codes.Verify(userID, code); rerr != nil {
+		return err
+	}
+	return nil
+}
+
+// normalize accepts codes typed in upper case, with or without separators.
+func normalize(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
+
+func hash(userID, code string) string {
+	sum := sha256.Sum256([]byte(userID + "\x00" + normalize(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newCode returns length random characters, split in two halves by a dash.
+func newCode(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("recovery code %w", err)
+	}
+	for i, b := range buf {
+		// the alphabet has 32 letters, so b%32 is uniform.
+		buf[i] = _alphabet[b%byte(len(_alphabet))]
+	}
+	half := length / 2
+	return string(buf[:half]) + "-" + string(buf[half:]), nil
+}
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock  sync.Mutex
+	codes map[string]map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{codes: make(map[string]map[string]bool)}
+}
+
+func (s *MemoryStore) Replace(userID string, hashes []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(hashes) == 0 {
+		delete(s.codes, userID)
+		return nil
+	}
+	set := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		set[h] = false
+	}
+	s.codes[userID] = set
+	return nil
+}
+
+func (s *MemoryStore) Use(userID, hash string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	used, ok := s.codes[userID][hash]
+	if !ok || used {
+		return false, nil
+	}
+	s.codes[userID][hash] = true
+	return true, nil
+}
+
+func (s *MemoryStore) Remaining(userID string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	n := 0
+	for _, used := range s.codes[userID] {
+		if !used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// GormStore keeps the hashes next to the users.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore migrates the table and returns a GormStore.
+func NewGormStore(db *gorm.DB) (*GormStore, error) {
+	if err := db.AutoMigrate(&model.UserRecoveryCode{}); err != nil {
+		return nil, fmt.Errorf("migrate recovery codes %w", err)
+	}
+	return &GormStore{db: db}, nil
+}
+
+func (s *GormStore) Replace(userID string, hashes []string) error {
+	return model.ReplaceUserRecoveryCodes(s.db, userID, hashes)
+}
+
+func (s *GormStore) Use(userID, hash string) (bool, error) {
+	return (&model.UserRecoveryCode{UserID: userID, Hash: hash}).Use(s.db)
+}
+
+func (s *GormStore) Remaining(userID string) (int, error) {
+	n, err := model.CountUnusedUserRecoveryCodes(s.db, userID)
+	return int(n), err
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package recovery
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type staticFactor struct {
+	enrolled bool
+	code     string
+}
+
+var errWrongCode = errors.New("wrong code")
+
+func (f staticFactor) Enrolled(string) (bool, error) { return f.enrolled, nil }
+
+func (f staticFactor) Verify(_, code string) error {
+	if code != f.code {
+		return errWrongCode
+	}
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormStore(db)
+	assert.NoError(t, err)
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			m := NewManager(Config{Count: 4}, store)
+			enrolled, err := m.Enrolled("usr-1")
+			assert.NoError(t, err)
+			assert.False(t, enrolled)
+
+			codes, err := m.Generate("usr-1")
+			assert.NoError(t, err)
+			assert.Len(t, codes, 4)
+			assert.Len(t, codes[0], 11)
+			n, err := m.Remaining("usr-1")
+			assert.NoError(t, err)
+			assert.Equal(t, 4, n)
+
+			assert.ErrorIs(t, m.Verify("usr-2", codes[0]), ErrInvalidCode)
+			assert.NoError(t, m.Verify("usr-1", " "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+			assert.ErrorIs(t, m.Verify("usr-1", codes[0]), ErrInvalidCode)
+			n, _ = m.Remaining("usr-1")
+			assert.Equal(t, 3, n)
+
+			regenerated, err := m.Generate("usr-1")
+			assert.NoError(t, err)
+			assert.ErrorIs(t, m.Verify("usr-1", codes[1]), ErrInvalidCode)
+			n, _ = m.Remaining("usr-1")
+			assert.Equal(t, 4, n)
+
+			factor := Fallback(staticFactor{enrolled: true, code: "123456"}, m)
+			assert.NoError(t, factor.Verify("usr-1", "123456"))
+			assert.NoError(t, factor.Verify("usr-1", regenerated[0]))
+			assert.ErrorIs(t, factor.Verify("usr-1", regenerated[0]), errWrongCode)
+			assert.ErrorIs(t, factor.Verify("usr-1", "654321"), errWrongCode)
+
+			assert.NoError(t, m.Revoke("usr-1"))
+			enrolled, _ = m.Enrolled("usr-1")
+			assert.False(t, enrolled)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stepup
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/utils"
+)
+
+// Signals describe the request a policy decides on.
+type Signals struct {
+	Claims *token.Claims
+	// Request the request, nil when deciding outside of one, e.g. during login.
+	Request *http.Request
+	// IP address of the client.
+	IP net.IP
+	// Roles of the subject, as the authorizer knows them.
+	Roles []string
+	// Scopes needed by the request, default to the scopes of the claims.
+	Scopes []string
+	// NewDevice the client was not seen with the subject before.
+	NewDevice bool
+}
+
+// Rule demands a level of assurance for signals, an empty acr when the rule does not apply.
+type Rule func(s *Signals) (acr, reason string)
+
+// Decision of a Policy.
+type Decision struct {
+	// ACR the level the request needs, empty when any authenticated session will do.
+	ACR string
+	// Reasons of the rules demanding ACR.
+	Reasons []string
+}
+
+// Required reports whether the decision needs more than a single factor.
+func (d Decision) Required() bool {
+	return d.ACR != ""
+}
+
+// Policy decides when multi-factor authentication is required.
+type Policy struct {
+	rules []Rule
+}
+
+func NewPolicy(rules ...Rule) *Policy {
+	return &Policy{rules: rules}
+}
+
+// Evaluate returns the strictest level demanded by the rules.
+func (p *Policy) Evaluate(s *Signals) Decision {
+	if s.Scopes == nil && s.Claims != nil {
+		s.Scopes = strings.Fields(s.Claims.Scope)
+	}
+	var d Decision
+	for _, rule := range p.rules {
+		acr, reason := rule(s)
+		if acr == "" {
+			continue
+		}
+		d.Reasons = append(d.Reasons, reason)
+		if Rank(acr) > Rank(d.ACR) {
+			d.ACR = acr
+		}
+	}
+	return d
+}
+
+// NewDevice demands acr from clients unknown with the subject.
+func NewDevice(acr string) Rule {
+	return func(s *Signals) (string, string) {
+		if s.NewDevice {
+			return acr, "new device"
+		}
+		return "", ""
+	}
+}
+
+// SensitiveScopes demands acr from requests needing one of scopes.
+func SensitiveScopes(acr string, scopes ...string) Rule {
+	return func(s *Signals) (string, string) {
+		for _, scope := range s.Scopes {
+			if utils.StringsInclude(scopes, scope) {
+				return acr, "sensitive scope " + scope
+			}
+		}
+		return "", ""
+	}
+}
+
+// AdminRoles demands acr from subjects holding one of roles.
+func AdminRoles(acr string, roles ...string) Rule {
+	return func(s *Signals) (string, string) {
+		for _, role := range s.Roles {
+			if utils.StringsInclude(roles, role) {
+				return acr, "admin role " + role
+			}
+		}
+		return "", ""
+	}
+}
+
+// UntrustedNetwork demands acr from clients outside of the trusted CIDRs.
+func UntrustedNetwork(acr string, trusted ...string) (Rule, error) {
+	networks := make([]*net.IPNet, 0, len(trusted))
+	for _, cidr := range trusted {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted network %w", err)
+		}
+		networks = append(networks, network)
+	}
+	return func(s *Signals) (string, string) {
+		for _, network := range networks {
+			if s.IP != nil && network.Contains(s.IP) {
+				return "", ""
+			}
+		}
+		return acr, "untrusted network"
+	}, nil
+}
+
+// RiskyIP demands acr from clients risky reports, e.g. backed by a reputation feed.
+func RiskyIP(acr string, risky func(ip net.IP) bool) Rule {
+	return func(s *Signals) (string, string) {
+		if s.IP != nil && risky(s.IP) {
+			return acr, "risky ip"
+		}
+		return "", ""
+	}
+}
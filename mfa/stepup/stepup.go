@@ -0,0 +1,261 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package stepup decides when a request needs multi-factor authentication and demands it,
+// see https://datatracker.ietf.org/doc/html/rfc9470 for the challenge.
+package stepup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/utils"
+)
+
+// Authentication method references, see https://datatracker.ietf.org/doc/html/rfc8176
+const (
+	AMRPassword    = "pwd"
+	AMROTP         = "otp"
+	AMRSMS         = "sms"
+	AMRHardwareKey = "hwk"
+	AMRSoftwareKey = "swk"
+	AMRMultiFactor = "mfa"
+)
+
+// Authentication context class references, ordered by assurance.
+const (
+	ACRSingleFactor       = "urn:tkeel:acr:sfa"
+	ACRMultiFactor        = "urn:tkeel:acr:mfa"
+	ACRPhishingResistant  = "urn:tkeel:acr:phr"
+	ErrorInsufficientAuth = "insufficient_user_authentication"
+
+	_defaultMaxAge = 15 * time.Minute
+
+	_valueACR      = "acr"
+	_valueAMR      = "amr"
+	_valueAuthTime = "auth_time"
+)
+
+// ErrNotAuthenticated step-up was recorded on a request without a logged in session.
+var ErrNotAuthenticated = errors.New("step-up without session")
+
+// Rank orders the known ACR values, unknown values rank 0.
+func Rank(acr string) int {
+	switch acr {
+	case ACRSingleFactor:
+		return 1
+	case ACRMultiFactor:
+		return 2
+	case ACRPhishingResistant:
+		return 3
+	}
+	return 0
+}
+
+// ACRFromAMR returns the level the methods in amr reach together.
+func ACRFromAMR(amr []string) string {
+	factors := 0
+	for _, method := range amr {
+		switch method {
+		case AMRHardwareKey:
+			return ACRPhishingResistant
+		case AMRMultiFactor:
+			factors += 2
+		case AMRPassword, AMROTP, AMRSMS, AMRSoftwareKey:
+			factors++
+		}
+	}
+	switch {
+	case factors >= 2:
+		return ACRMultiFactor
+	case factors == 1:
+		return ACRSingleFactor
+	}
+	return ""
+}
+
+// State the authentication a request carries.
+type State struct {
+	ACR      string
+	AMR      []string
+	AuthTime time.Time
+}
+
+// Satisfies reports whether the state reaches acr no longer than maxAge ago.
+func (st State) Satisfies(acr string, maxAge time.Duration) bool {
+	if acr == "" {
+		return true
+	}
+	if st.ACR != acr && (Rank(acr) == 0 || Rank(st.ACR) < Rank(acr)) {
+		return false
+	}
+	return maxAge <= 0 || time.Since(st.AuthTime) <= maxAge
+}
+
+// Config of the step-up.
+type Config struct {
+	// MaxAge how long a completed step-up is honored. Default to 15m.
+	MaxAge time.Duration `mapstructure:"max_age" json:"max_age" yaml:"maxAge"`
+	// ChallengeURL where browsers complete the step-up, reported in the challenge body.
+	ChallengeURL string `mapstructure:"challenge_url" json:"challenge_url" yaml:"challengeUrl"`
+	Realm        string `mapstructure:"realm" json:"realm" yaml:"realm"`
+}
+
+// StepUp demands and records elevated authentication.
+type StepUp struct {
+	sessions *session.Manager
+	conf     Config
+}
+
+// New returns a StepUp recording in the sessions of sessions, which may be nil for
+// bearer token only APIs, their state comes from the acr, amr and auth_time claims.
+func New(sessions *session.Manager, conf Config) *StepUp {
+	if conf.MaxAge <= 0 {
+		conf.MaxAge = _defaultMaxAge
+	}
+	return &StepUp{sessions: sessions, conf: conf}
+}
+
+// State returns the authentication of r, from its session or else from its token claims.
+func (s *StepUp) State(r *http.Request) State {
+	if sess, ok := session.FromContext(r.Context()); ok && sess.Claims != nil {
+		st := State{ACR: sess.Values[_valueACR], AMR: strings.Fields(sess.Values[_valueAMR])}
+		if sec, err := strconv.ParseInt(sess.Values[_valueAuthTime], 10, 64); err == nil {
+			st.AuthTime = time.Unix(sec, 0)
+		}
+		return st
+	}
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		return State{}
+	}
+	st := State{}
+	st.ACR = token.ClaimString(claims.Extra, _valueACR)
+	st.AMR = token.ClaimStrings(claims.Extra, _valueAMR)
+	if st.AuthTime = token.ClaimTime(claims.Extra, _valueAuthTime); st.AuthTime.IsZero() {
+		st.AuthTime = time.Unix(claims.IssuedAt, 0)
+	}
+	return st
+}
+
+// Record adds the methods the end-user just completed to the session of r, raising its ACR.
+// Call it at login with AMRPassword and after each verified second factor.
+func (s *StepUp) Record(w http.ResponseWriter, r *http.Request, methods ...string) (State, error) {
+	sess, ok := session.FromContext(r.Context())
+	if !ok || sess.Claims == nil || s.sessions == nil {
+		return State{}, ErrNotAuthenticated
+	}
+	amr := strings.Fields(sess.Values[_valueAMR])
+	for _, method := range methods {
+		amr = utils.StringsUniqueAppend(amr, method)
+	}
+	st := State{ACR: ACRFromAMR(amr), AMR: amr, AuthTime: time.Now()}
+	if sess.Values == nil {
+		sess.Values = make(map[string]string)
+	}
+	sess.Values[_valueACR] = st.ACR
+	sess.Values[_valueAMR] = strings.Join(amr, " ")
+	sess.Values[_valueAuthTime] = strconv.FormatInt(st.AuthTime.Unix(), 10)
+	if err := s.sessions.Save(r.Context(), w, sess); err != nil {
+		return State{}, fmt.Errorf("record step-up %w", err)
+	}
+	return st, nil
+}
+
+// Demand reports whether r reaches acr, answering it with the step-up challenge when it does not.
+func (s *StepUp) Demand(w http.ResponseWriter, r *http.Request, acr string) bool {
+	if s.State(r).Satisfies(acr, s.conf.MaxAge) {
+		return true
+	}
+	s.WriteChallenge(w, acr)
+	return false
+}
+
+// Require guards handlers with Demand.
+func (s *StepUp) Require(acr string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.Demand(w, r, acr) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// Enforce guards handlers with the level policy decides, signals may be nil to decide on the
+// claims and remote address of the request only.
+func (s *StepUp) Enforce(policy *Policy, signals func(r *http.Request) *Signals) func(http.Handler) http.Handler {
+	if signals == nil {
+		signals = RequestSignals
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.Demand(w, r, policy.Evaluate(signals(r)).ACR) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// RequestSignals the claims and remote address of r.
+func RequestSignals(r *http.Request) *Signals {
+	sig := &Signals{Request: r}
+	sig.Claims, _ = middleware.ClaimsFromContext(r.Context())
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	sig.IP = net.ParseIP(host)
+	return sig
+}
+
+// Challenge the body of a step-up answer.
+type Challenge struct {
+	Error        string `json:"error"`
+	ACRValues    string `json:"acr_values"`
+	MaxAge       int64  `json:"max_age"`
+	ChallengeURL string `json:"challenge_url,omitempty"`
+}
+
+// WriteChallenge answers 401 insufficient_user_authentication naming acr.
+func (s *StepUp) WriteChallenge(w http.ResponseWriter, acr string) {
+	maxAge := int64(s.conf.MaxAge.Seconds())
+	params := make([]string, 0, 5)
+	if s.conf.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", s.conf.Realm))
+	}
+	params = append(params,
+		fmt.Sprintf("error=%q", ErrorInsufficientAuth),
+		fmt.Sprintf("error_description=%q", "a different authentication level is required"),
+		fmt.Sprintf("acr_values=%q", acr),
+		fmt.Sprintf("max_age=%d", maxAge))
+	w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(&Challenge{
+		Error:        ErrorInsufficientAuth,
+		ACRValues:    acr,
+		MaxAge:       maxAge,
+		ChallengeURL: s.conf.ChallengeURL,
+	})
+}
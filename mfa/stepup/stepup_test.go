@@ -0,0 +1,148 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stepup
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	untrusted, err := UntrustedNetwork(ACRMultiFactor, "10.0.0.0/8")
+	assert.NoError(t, err)
+	_, err = UntrustedNetwork(ACRMultiFactor, "10.0.0.0")
+	assert.Error(t, err)
+	policy := NewPolicy(
+		NewDevice(ACRMultiFactor),
+		SensitiveScopes(ACRMultiFactor, "billing"),
+		AdminRoles(ACRPhishingResistant, "admin"),
+		untrusted,
+		RiskyIP(ACRPhishingResistant, func(ip net.IP) bool { return ip.Equal(net.ParseIP("10.6.6.6")) }),
+	)
+
+	tests := []struct {
+		name    string
+		signals *Signals
+		acr     string
+		reasons int
+	}{
+		{"trusted", &Signals{IP: net.ParseIP("10.0.0.1"), Claims: &token.Claims{Scope: "read"}}, "", 0},
+		{"new device", &Signals{IP: net.ParseIP("10.0.0.1"), NewDevice: true}, ACRMultiFactor, 1},
+		{"sensitive scope", &Signals{IP: net.ParseIP("10.0.0.1"), Claims: &token.Claims{Scope: "read billing"}}, ACRMultiFactor, 1},
+		{"untrusted", &Signals{IP: net.ParseIP("192.168.1.1")}, ACRMultiFactor, 1},
+		{"admin", &Signals{IP: net.ParseIP("192.168.1.1"), Roles: []string{"admin"}}, ACRPhishingResistant, 2},
+		{"risky", &Signals{IP: net.ParseIP("10.6.6.6")}, ACRPhishingResistant, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := policy.Evaluate(tt.signals)
+			assert.Equal(t, tt.acr, d.ACR)
+			assert.Equal(t, tt.acr != "", d.Required())
+			assert.Len(t, d.Reasons, tt.reasons)
+		})
+	}
+}
+
+func TestACRFromAMR(t *testing.T) {
+	tests := []struct {
+		amr []string
+		acr string
+	}{
+		{nil, ""},
+		{[]string{AMRPassword}, ACRSingleFactor},
+		{[]string{AMRPassword, AMROTP}, ACRMultiFactor},
+		{[]string{AMRMultiFactor}, ACRMultiFactor},
+		{[]string{AMRPassword, AMRHardwareKey}, ACRPhishingResistant},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.acr, ACRFromAMR(tt.amr), tt.amr)
+	}
+}
+
+func TestStepUp(t *testing.T) {
+	sessions, err := session.NewManager(session.Config{}, session.NewMemoryStore())
+	assert.NoError(t, err)
+	s := New(sessions, Config{ChallengeURL: "/mfa", Realm: "tkeel"})
+
+	var record []string
+	h := sessions.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if record != nil {
+			_, err := s.Record(w, r, record...)
+			assert.NoError(t, err)
+			return
+		}
+		s.Require(ACRMultiFactor)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, []string{AMRPassword, AMROTP}, s.State(r).AMR)
+			w.WriteHeader(http.StatusNoContent)
+		})).ServeHTTP(w, r)
+	}))
+	w := httptest.NewRecorder()
+	_, err = sessions.Login(w, httptest.NewRequest(http.MethodPost, "/login", nil), &token.Claims{Subject: "alice"})
+	assert.NoError(t, err)
+	cookie := w.Result().Cookies()[0]
+	serve := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	record = []string{AMRPassword}
+	serve()
+	record = nil
+	w = serve()
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="tkeel", error="insufficient_user_authentication", error_description="a different authentication level is required", acr_values="urn:tkeel:acr:mfa", max_age=900`, w.Header().Get("WWW-Authenticate"))
+	var challenge Challenge
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&challenge))
+	assert.Equal(t, Challenge{Error: ErrorInsufficientAuth, ACRValues: ACRMultiFactor, MaxAge: 900, ChallengeURL: "/mfa"}, challenge)
+
+	record = []string{AMROTP}
+	serve()
+	record = nil
+	assert.Equal(t, http.StatusNoContent, serve().Code)
+
+	_, err = s.Record(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil), AMROTP)
+	assert.ErrorIs(t, err, ErrNotAuthenticated)
+}
+
+func TestStateFromClaims(t *testing.T) {
+	s := New(nil, Config{MaxAge: time.Minute})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	claims := &token.Claims{Extra: map[string]interface{}{}}
+	assert.NoError(t, json.NewDecoder(strings.NewReader(`{"ext":{"acr":"urn:tkeel:acr:phr","amr":["hwk"]}}`)).Decode(claims))
+	claims.IssuedAt = time.Now().Unix()
+	r = r.WithContext(middleware.WithClaims(r.Context(), claims))
+
+	st := s.State(r)
+	assert.Equal(t, []string{AMRHardwareKey}, st.AMR)
+	assert.True(t, st.Satisfies(ACRMultiFactor, time.Minute))
+	assert.False(t, st.Satisfies("urn:other", time.Minute))
+	claims.IssuedAt = time.Now().Add(-time.Hour).Unix()
+	w := httptest.NewRecorder()
+	assert.False(t, s.Demand(w, r, ACRMultiFactor))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
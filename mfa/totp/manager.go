@@ -0,0 +1,380 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+var (
+	_ Store = &MemoryStore{}
+	_ Store = &GormStore{}
+
+	// ErrEncryptionKeyRequired the secrets are stored without an encryption key.
+	ErrEncryptionKeyRequired = errors.New("totp encryption key required")
+)
+
+// Enrollment the TOTP factor of a user.
+type Enrollment struct {
+	UserID string
+	// Secret base32 encoded shared secret.
+	Secret string
+	// Confirmed once the user proved the authenticator app holds the secret.
+	Confirmed bool
+	// LastStep time step of the last accepted code, codes are single use.
+	LastStep int64
+	// Failures wrong codes since the last accepted one or lockout.
+	Failures int
+	// LockedUntil the factor rejects all codes until then after too many failures.
+	LockedUntil time.Time
+}
+
+// Store persists enrollments. Accept and Fail update the stored enrollment in place, so codes
+// stay single use and failures all count with several replicas sharing the store.
+type Store interface {
+	// Load returns the enrollment of userID or ErrNotEnrolled.
+	Load(userID string) (*Enrollment, error)
+	Save(e *Enrollment) error
+	Delete(userID string) error
+	// Accept records step as the last accepted code and confirms the enrollment, ErrCodeReused
+	// unless step is later than the stored one and the enrollment is unlocked at now.
+	Accept(userID string, step int64, now time.Time) error
+	// Fail counts a wrong code, at maxFailures in a row the enrollment is locked until lockUntil.
+	Fail(userID string, maxFailures int, lockUntil time.Time) error
+}
+
+// Manager enrolls users and verifies their codes.
+type Manager struct {
+	conf  Config
+	store Store
+	// lock serializes enrollments and verifications of the replica, the store keeps codes
+	// single use across replicas.
+	lock   sync.Mutex
+	events audit.EventSink
+}
+
+func NewManager(conf Config, store Store) *Manager {
+	conf.init()
+	return &Manager{conf: conf, store: store}
+}
+
+// Enroll provisions a new secret for userID, replacing an unconfirmed one. The factor is only
+// required once Confirm succeeded, show the URI of the key as QR code until then. A confirmed
+// factor is not replaced, ErrAlreadyEnrolled: use Reenroll, or Disable as an administrator reset.
+func (m *Manager) Enroll(userID, account string) (*Key, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, err := m.store.Load(userID)
+	if err == nil && e.Confirmed {
+		return nil, ErrAlreadyEnrolled
+	}
+	if err != nil && !errors.Is(err, ErrNotEnrolled) {
+		return nil, err
+	}
+	return m.enroll(userID, account)
+}
+
+// Reenroll provisions a new secret for userID after code proved the current factor, e.g. when
+// the user moves to another authenticator app.
+func (m *Manager) Reenroll(userID, account, code string) (*Key, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, err := m.store.Load(userID)
+	if err != nil {
+		return nil, err
+	}
+	if e.Confirmed {
+		if err = m.check(e, code); err != nil {
+			return nil, err
+		}
+	}
+	return m.enroll(userID, account)
+}
+
+func (m *Manager) enroll(userID, account string) (*Key, error) {
+	key, err := GenerateKey(m.conf, account)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.store.Save(&Enrollment{UserID: userID, Secret: key.Secret}); err != nil {
+		return nil, fmt.Errorf("save totp enrollment %w", err)
+	}
+	return key, nil
+}
+
+// Confirm activates the enrollment of userID with a code of the new secret.
+func (m *Manager) Confirm(userID, code string) error {
+	return m.verify(userID, code, true)
+}
+
+// Verify checks code for the confirmed enrollment of userID.
+func (m *Manager) Verify(userID, code string) error {
+	return m.verify(userID, code, false)
+}
+
+func (m *Manager) verify(userID, code string, confirm bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, err := m.store.Load(userID)
+	if err != nil {
+		return err
+	}
+	if !confirm && !e.Confirmed {
+		return ErrNotEnrolled
+	}
+	return m.check(e, code)
+}
+
+// check accepts code for e and confirms it. Wrong codes count against e, MaxFailures of them
+// in a row lock it for Lockout so the codes can not be guessed.
+func (m *Manager) check(e *Enrollment, code string) error {
+	now := time.Now()
+	if now.Before(e.LockedUntil) {
+		return ErrTooManyFailures
+	}
+	s, err := Validate(m.conf, e.Secret, code, now)
+	if errors.Is(err, ErrInvalidCode) {
+		if ferr := m.store.Fail(e.UserID, m.conf.MaxFailures, now.Add(m.conf.Lockout)); ferr != nil {
+			return fmt.Errorf("save totp failure %w", ferr)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if s <= e.LastStep {
+		return ErrCodeReused
+	}
+	// the store only accepts s when no other replica accepted it, or a later code, meanwhile.
+	if err = m.store.Accept(e.UserID, s, now); err != nil {
+		if errors.Is(err, ErrCodeReused) {
+			return err
+		}
+		return fmt.Errorf("save totp enrollment %w", err)
+	}
+	return nil
+}
+
+// Enrolled reports whether userID has a confirmed factor.
+func (m *Manager) Enrolled(userID string) (bool, error) {
+	e, err := m.store.Load(userID)
+	if errors.Is(err, ErrNotEnrolled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return e.Confirmed, nil
+}
+
+// SetEventSink writes an mfa.disabled event to sink when a confirmed factor is removed.
+func (m *Manager) SetEventSink(sink audit.EventSink) {
+	m.events = sink
+}
+
+// Disable removes the factor of userID.
+func (m *Manager) Disable(userID string) error {
+	confirmed, err := m.Enrolled(userID)
+	if err != nil {
+		return err
+	}
+	if err = m.store.Delete(userID); err != nil {
+		return err
+	}
+	if confirmed && m.events != nil {
+		m.events.WriteEvent(&audit.Event{
+			Time:    time.Now(),
+			Type:    audit.EventMFADisabled,
+			Subject: userID,
+			Outcome: audit.OutcomeSuccess,
+			Detail:  map[string]interface{}{"factor": "totp"},
+		})
+	}
+	return nil
+}
+
+// MemoryStore in-process Store, for tests.
+type MemoryStore struct {
+	lock        sync.RWMutex
+	enrollments map[string]Enrollment
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{enrollments: make(map[string]Enrollment)}
+}
+
+func (s *MemoryStore) Load(userID string) (*Enrollment, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	e, ok := s.enrollments[userID]
+	if !ok {
+		return nil, ErrNotEnrolled
+	}
+	return &e, nil
+}
+
+func (s *MemoryStore) Save(e *Enrollment) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.enrollments[e.UserID] = *e
+	return nil
+}
+
+func (s *MemoryStore) Delete(userID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.enrollments, userID)
+	return nil
+}
+
+func (s *MemoryStore) Accept(userID string, step int64, now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.enrollments[userID]
+	if !ok {
+		return ErrNotEnrolled
+	}
+	if step <= e.LastStep || now.Before(e.LockedUntil) {
+		return ErrCodeReused
+	}
+	e.LastStep, e.Confirmed, e.Failures, e.LockedUntil = step, true, 0, time.Time{}
+	s.enrollments[userID] = e
+	return nil
+}
+
+func (s *MemoryStore) Fail(userID string, maxFailures int, lockUntil time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.enrollments[userID]
+	if !ok {
+		return ErrNotEnrolled
+	}
+	if e.Failures++; e.Failures >= maxFailures {
+		e.Failures, e.LockedUntil = 0, lockUntil
+	}
+	s.enrollments[userID] = e
+	return nil
+}
+
+// GormStore keeps enrollments next to the users, the secrets encrypted with AES-256-GCM.
+type GormStore struct {
+	db   *gorm.DB
+	aead cipher.AEAD
+}
+
+// NewGormStore migrates the table and returns a GormStore encrypting under a key derived from encryptionKey.
+func NewGormStore(db *gorm.DB, encryptionKey string) (*GormStore, error) {
+	if encryptionKey == "" {
+		return nil, ErrEncryptionKeyRequired
+	}
+	key := sha256.Sum256([]byte(encryptionKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("totp cipher %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("totp cipher %w", err)
+	}
+	if err = db.AutoMigrate(&model.UserTOTP{}); err != nil {
+		return nil, fmt.Errorf("migrate totp %w", err)
+	}
+	return &GormStore{db: db, aead: aead}, nil
+}
+
+func (s *GormStore) Load(userID string) (*Enrollment, error) {
+	row := &model.UserTOTP{UserID: userID}
+	found, err := row.Get(s.db)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotEnrolled
+	}
+	secret, err := s.open(row.Secret, userID)
+	if err != nil {
+		return nil, err
+	}
+	e := &Enrollment{UserID: userID, Secret: secret, Confirmed: row.Confirmed, LastStep: row.LastStep, Failures: row.Failures}
+	if row.LockedUntil != nil {
+		e.LockedUntil = *row.LockedUntil
+	}
+	return e, nil
+}
+
+func (s *GormStore) Save(e *Enrollment) error {
+	sealed, err := s.seal(e.Secret, e.UserID)
+	if err != nil {
+		return err
+	}
+	row := &model.UserTOTP{UserID: e.UserID, Secret: sealed, Confirmed: e.Confirmed, LastStep: e.LastStep, Failures: e.Failures}
+	if !e.LockedUntil.IsZero() {
+		row.LockedUntil = &e.LockedUntil
+	}
+	return row.Save(s.db)
+}
+
+func (s *GormStore) Delete(userID string) error {
+	return (&model.UserTOTP{UserID: userID}).Delete(s.db)
+}
+
+func (s *GormStore) Accept(userID string, step int64, now time.Time) error {
+	accepted, err := (&model.UserTOTP{UserID: userID}).Accept(s.db, step, now)
+	if err != nil {
+		return err
+	}
+	if !accepted {
+		return ErrCodeReused
+	}
+	return nil
+}
+
+func (s *GormStore) Fail(userID string, maxFailures int, lockUntil time.Time) error {
+	return (&model.UserTOTP{UserID: userID}).Fail(s.db, maxFailures, lockUntil)
+}
+
+// seal encrypts secret bound to userID, so a row copied to another user does not decrypt.
+func (s *GormStore) seal(secret, userID string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("totp nonce %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, []byte(secret), []byte(userID))), nil
+}
+
+func (s *GormStore) open(sealed, userID string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return "", errors.New("decrypt totp secret: malformed data")
+	}
+	secret, err := s.aead.Open(nil, data[:s.aead.NonceSize()], data[s.aead.NonceSize():], []byte(userID))
+	if err != nil {
+		return "", fmt.Errorf("decrypt totp secret %w", err)
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package totp implements time-based one-time passwords (RFC 6238) as a second factor.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_defaultDigits      = 6
+	_defaultPeriod      = 30 * time.Second
+	_defaultSkew        = 1
+	_defaultSecretSize  = 20
+	_defaultMaxFailures = 5
+	_defaultLockout     = 15 * time.Minute
+)
+
+var (
+	// ErrInvalidCode the code does not match the secret.
+	ErrInvalidCode = errors.New("invalid totp code")
+	// ErrCodeReused the code, or a later one, was accepted before.
+	ErrCodeReused = errors.New("totp code already used")
+	// ErrNotEnrolled the user has no confirmed TOTP factor.
+	ErrNotEnrolled = errors.New("totp not enrolled")
+	// ErrTooManyFailures the factor is locked after too many wrong codes.
+	ErrTooManyFailures = errors.New("too many failed totp attempts")
+	// ErrAlreadyEnrolled the user has a confirmed factor, replacing it takes a code of it or a reset.
+	ErrAlreadyEnrolled = errors.New("totp already enrolled")
+)
+
+var _encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Config of the codes, the defaults are the ones authenticator apps assume.
+type Config struct {
+	// Issuer shown by authenticator apps, e.g. tKeel.
+	Issuer string `mapstructure:"issuer" json:"issuer" yaml:"issuer"`
+	// Digits of a code, 6 or 8. Default to 6.
+	Digits int `mapstructure:"digits" json:"digits" yaml:"digits"`
+	// Period a code is valid for. Default to 30s.
+	Period time.Duration `mapstructure:"period" json:"period" yaml:"period"`
+	// Skew periods before and after the current one accepted for clock drift. Default to 1.
+	Skew int `mapstructure:"skew" json:"skew" yaml:"skew"`
+	// MaxFailures wrong codes in a row locking the factor of a user. Default to 5.
+	MaxFailures int `mapstructure:"max_failures" json:"max_failures" yaml:"maxFailures"`
+	// Lockout how long a locked factor rejects all codes. Default to 15m.
+	Lockout time.Duration `mapstructure:"lockout" json:"lockout" yaml:"lockout"`
+}
+
+func (c *Config) init() {
+	if c.Digits != 8 {
+		c.Digits = _defaultDigits
+	}
+	if c.Period <= 0 {
+		c.Period = _defaultPeriod
+	}
+	if c.Skew <= 0 {
+		c.Skew = _defaultSkew
+	}
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = _defaultMaxFailures
+	}
+	if c.Lockout <= 0 {
+		c.Lockout = _defaultLockout
+	}
+}
+
+// Key a provisioned secret.
+type Key struct {
+	// Secret base32 encoded shared secret.
+	Secret  string
+	Issuer  string
+	Account string
+	Digits  int
+	Period  time.Duration
+}
+
+// GenerateKey returns a random secret for account.
+func GenerateKey(conf Config, account string) (*Key, error) {
+	conf.init()
+	raw := make([]byte, _defaultSecretSize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("totp secret %w", err)
+	}
+	return &Key{
+		Secret:  _encoding.EncodeToString(raw),
+		Issuer:  conf.Issuer,
+		Account: account,
+		Digits:  conf.Digits,
+		Period:  conf.Period,
+	}, nil
+}
+
+// URI returns the otpauth:// URI authenticator apps import, also the payload of the QR code to show.
+func (k *Key) URI() string {
+	label := url.PathEscape(k.Account)
+	q := url.Values{}
+	q.Set("secret", k.Secret)
+	if k.Issuer != "" {
+		label = url.PathEscape(k.Issuer) + ":" + label
+		q.Set("issuer", k.Issuer)
+	}
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", strconv.Itoa(k.Digits))
+	q.Set("period", strconv.Itoa(int(k.Period/time.Second)))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// GenerateCode returns the code of secret at t.
+func GenerateCode(conf Config, secret string, t time.Time) (string, error) {
+	conf.init()
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, step(conf, t), conf.Digits), nil
+}
+
+// Validate checks code against secret at t within the skew and returns the time step it matched.
+func Validate(conf Config, secret, code string, t time.Time) (int64, error) {
+	conf.init()
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, err
+	}
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != conf.Digits {
+		return 0, ErrInvalidCode
+	}
+	current := step(conf, t)
+	for i := -conf.Skew; i <= conf.Skew; i++ {
+		s := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, s, conf.Digits)), []byte(code)) == 1 {
+			return s, nil
+		}
+	}
+	return 0, ErrInvalidCode
+}
+
+func step(conf Config, t time.Time) int64 {
+	return t.Unix() / int64(conf.Period/time.Second)
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := _encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, fmt.Errorf("decode totp secret %w", err)
+	}
+	return key, nil
+}
+
+// hotp the RFC 4226 code of counter.
+func hotp(key []byte, counter int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// _rfcSecret the base32 of the RFC 6238 SHA1 test secret 12345678901234567890.
+const _rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+	}
+	for _, tt := range tests {
+		code, err := GenerateCode(Config{Digits: 8}, _rfcSecret, time.Unix(tt.unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.code, code)
+	}
+
+	now := time.Unix(1111111109, 0)
+	code, err := GenerateCode(Config{}, _rfcSecret, now.Add(-30*time.Second))
+	assert.NoError(t, err)
+	_, err = Validate(Config{}, _rfcSecret, code, now)
+	assert.NoError(t, err)
+	_, err = Validate(Config{}, _rfcSecret, code, now.Add(30*time.Second))
+	assert.ErrorIs(t, err, ErrInvalidCode)
+}
+
+func TestKeyURI(t *testing.T) {
+	key, err := GenerateKey(Config{Issuer: "tKeel"}, "alice@example.com")
+	assert.NoError(t, err)
+	u, err := url.Parse(key.URI())
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/tKeel:alice@example.com", u.Path)
+	assert.Equal(t, key.Secret, u.Query().Get("secret"))
+	assert.Equal(t, "30", u.Query().Get("period"))
+}
+
+func TestManager(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormStore(db, "encryption-key")
+	assert.NoError(t, err)
+	_, err = NewGormStore(db, "")
+	assert.ErrorIs(t, err, ErrEncryptionKeyRequired)
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			m := NewManager(Config{}, store)
+			key, err := m.Enroll("usr-1", "alice")
+			assert.NoError(t, err)
+			enrolled, err := m.Enrolled("usr-1")
+			assert.NoError(t, err)
+			assert.False(t, enrolled)
+
+			code, err := GenerateCode(Config{}, key.Secret, time.Now())
+			assert.NoError(t, err)
+			assert.ErrorIs(t, m.Verify("usr-1", code), ErrNotEnrolled)
+			assert.NoError(t, m.Confirm("usr-1", code))
+			enrolled, err = m.Enrolled("usr-1")
+			assert.NoError(t, err)
+			assert.True(t, enrolled)
+			if name == "gorm" {
+				var stored string
+				assert.NoError(t, db.Raw("SELECT secret FROM sys_t_user_totp WHERE user_id = ?", "usr-1").Scan(&stored).Error)
+				assert.NotContains(t, stored, key.Secret)
+			}
+
+			assert.ErrorIs(t, m.Verify("usr-1", code), ErrCodeReused)
+			next, err := GenerateCode(Config{}, key.Secret, time.Now().Add(30*time.Second))
+			assert.NoError(t, err)
+			// a confirmed factor is only replaced with a code of it.
+			_, err = m.Enroll("usr-1", "alice")
+			assert.ErrorIs(t, err, ErrAlreadyEnrolled)
+			_, err = m.Reenroll("usr-1", "alice", "000000")
+			assert.ErrorIs(t, err, ErrInvalidCode)
+			assert.NoError(t, m.Verify("usr-1", next))
+			assert.ErrorIs(t, m.Verify("usr-1", "000000"), ErrInvalidCode)
+
+			assert.NoError(t, m.Disable("usr-1"))
+			assert.ErrorIs(t, m.Verify("usr-1", next), ErrNotEnrolled)
+		})
+	}
+}
+
+func TestLockout(t *testing.T) {
+	m := NewManager(Config{MaxFailures: 2, Lockout: time.Hour}, NewMemoryStore())
+	key, err := m.Enroll("usr-1", "alice")
+	assert.NoError(t, err)
+	code, err := GenerateCode(Config{}, key.Secret, time.Now())
+	assert.NoError(t, err)
+	assert.NoError(t, m.Confirm("usr-1", code))
+	next, err := GenerateCode(Config{}, key.Secret, time.Now().Add(30*time.Second))
+	assert.NoError(t, err)
+	key, err = m.Reenroll("usr-1", "alice", next)
+	assert.NoError(t, err)
+	code, err = GenerateCode(Config{}, key.Secret, time.Now())
+	assert.NoError(t, err)
+	assert.NoError(t, m.Confirm("usr-1", code))
+
+	wrong := "000000"
+	if wrong == code {
+		wrong = "111111"
+	}
+	assert.ErrorIs(t, m.Verify("usr-1", wrong), ErrInvalidCode)
+	assert.ErrorIs(t, m.Verify("usr-1", wrong), ErrInvalidCode)
+	next, err = GenerateCode(Config{}, key.Secret, time.Now().Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, m.Verify("usr-1", next), ErrTooManyFailures, "a locked factor rejects valid codes")
+	_, err = m.Reenroll("usr-1", "alice", next)
+	assert.ErrorIs(t, err, ErrTooManyFailures)
+}
+
+func TestStoreUpdates(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormStore(db, "encryption-key")
+	assert.NoError(t, err)
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, store.Save(&Enrollment{UserID: "usr-1", Secret: _rfcSecret, Confirmed: true, LastStep: 10}))
+			now := time.Now()
+
+			// a step accepted by one replica is a replay for the others, even with a stale load.
+			assert.ErrorIs(t, store.Accept("usr-1", 10, now), ErrCodeReused)
+			assert.NoError(t, store.Accept("usr-1", 11, now))
+			assert.ErrorIs(t, store.Accept("usr-1", 11, now), ErrCodeReused)
+
+			// failures of all replicas add up to the lockout.
+			lockUntil := now.Add(time.Hour)
+			for i := 0; i < 2; i++ {
+				assert.NoError(t, store.Fail("usr-1", 3, lockUntil))
+			}
+			e, err := store.Load("usr-1")
+			assert.NoError(t, err)
+			assert.Equal(t, 2, e.Failures)
+			assert.True(t, e.LockedUntil.IsZero())
+			assert.NoError(t, store.Fail("usr-1", 3, lockUntil))
+			e, err = store.Load("usr-1")
+			assert.NoError(t, err)
+			assert.Equal(t, 0, e.Failures)
+			assert.True(t, e.LockedUntil.Equal(lockUntil))
+			assert.ErrorIs(t, store.Accept("usr-1", 12, now), ErrCodeReused, "a locked factor accepts no code")
+
+			assert.NoError(t, store.Accept("usr-1", 12, lockUntil))
+			e, err = store.Load("usr-1")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(12), e.LastStep)
+			assert.True(t, e.LockedUntil.IsZero())
+		})
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webauthn
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/model"
+
+	gowebauthn "github.com/duo-labs/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+var (
+	_ CredentialStore = &MemoryCredentialStore{}
+	_ CredentialStore = &GormCredentialStore{}
+	_ CeremonyStore   = &MemoryCeremonyStore{}
+)
+
+// CredentialStore persists the registered credentials.
+type CredentialStore interface {
+	Credentials(userID string) ([]*Credential, error)
+	// SaveCredential creates or updates the credential.
+	SaveCredential(c *Credential) error
+	// DeleteCredential removes the credential id of userID, deleting a missing credential is not an error.
+	DeleteCredential(userID string, id []byte) error
+}
+
+// CeremonyStore keeps the state of begun ceremonies until they are finished.
+type CeremonyStore interface {
+	SaveCeremony(id string, session *gowebauthn.SessionData, ttl time.Duration) error
+	// ConsumeCeremony returns and deletes the ceremony or returns ErrCeremonyNotFound.
+	ConsumeCeremony(id string) (*gowebauthn.SessionData, error)
+}
+
+type ceremonyEntry struct {
+	session  *gowebauthn.SessionData
+	expireAt time.Time
+}
+
+// MemoryCeremonyStore in-process CeremonyStore, suitable for a single replica or tests.
+type MemoryCeremonyStore struct {
+	lock    sync.Mutex
+	entries map[string]ceremonyEntry
+}
+
+func NewMemoryCeremonyStore() *MemoryCeremonyStore {
+	return &MemoryCeremonyStore{entries: make(map[string]ceremonyEntry)}
+}
+
+func (s *MemoryCeremonyStore) SaveCeremony(id string, session *gowebauthn.SessionData, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for k, entry := range s.entries {
+		if now.After(entry.expireAt) {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[id] = ceremonyEntry{session: session, expireAt: now.Add(ttl)}
+	return nil
+}
+
+func (s *MemoryCeremonyStore) ConsumeCeremony(id string) (*gowebauthn.SessionData, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry, ok := s.entries[id]
+	delete(s.entries, id)
+	if !ok || time.Now().After(entry.expireAt) {
+		return nil, ErrCeremonyNotFound
+	}
+	return entry.session, nil
+}
+
+// MemoryCredentialStore in-process CredentialStore, for tests.
+type MemoryCredentialStore struct {
+	lock        sync.RWMutex
+	credentials map[string][]Credential
+}
+
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{credentials: make(map[string][]Credential)}
+}
+
+func (s *MemoryCredentialStore) Credentials(userID string) ([]*Credential, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	credentials := make([]*Credential, 0, len(s.credentials[userID]))
+	for i := range s.credentials[userID] {
+		c := s.credentials[userID][i]
+		credentials = append(credentials, &c)
+	}
+	return credentials, nil
+}
+
+func (s *MemoryCredentialStore) SaveCredential(c *Credential) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	credentials := s.credentials[c.UserID]
+	for i := range credentials {
+		if bytes.Equal(credentials[i].ID, c.ID) {
+			credentials[i] = *c
+			return nil
+		}
+	}
+	s.credentials[c.UserID] = append(credentials, *c)
+	return nil
+}
+
+func (s *MemoryCredentialStore) DeleteCredential(userID string, id []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	credentials := s.credentials[userID]
+	for i := range credentials {
+		if bytes.Equal(credentials[i].ID, id) {
+			s.credentials[userID] = append(credentials[:i], credentials[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// GormCredentialStore keeps the credentials next to the users.
+type GormCredentialStore struct {
+	db *gorm.DB
+}
+
+// NewGormCredentialStore migrates the table and returns a GormCredentialStore.
+func NewGormCredentialStore(db *gorm.DB) (*GormCredentialStore, error) {
+	if err := db.AutoMigrate(&model.UserWebAuthnCredential{}); err != nil {
+		return nil, fmt.Errorf("migrate webauthn credentials %w", err)
+	}
+	return &GormCredentialStore{db: db}, nil
+}
+
+func (s *GormCredentialStore) Credentials(userID string) ([]*Credential, error) {
+	rows, err := (&model.UserWebAuthnCredential{}).ListByUser(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make([]*Credential, 0, len(rows))
+	for _, row := range rows {
+		id, err := decodeID(row.ID)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, &Credential{
+			ID:              id,
+			UserID:          row.UserID,
+			Name:            row.Name,
+			PublicKey:       row.PublicKey,
+			AttestationType: row.AttestationType,
+			AAGUID:          row.AAGUID,
+			SignCount:       row.SignCount,
+			CreatedAt:       row.CreatedAt,
+			LastUsedAt:      row.LastUsedAt,
+		})
+	}
+	return credentials, nil
+}
+
+func (s *GormCredentialStore) SaveCredential(c *Credential) error {
+	row := &model.UserWebAuthnCredential{
+		ID:              encodeID(c.ID),
+		UserID:          c.UserID,
+		Name:            c.Name,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		AAGUID:          c.AAGUID,
+		SignCount:       c.SignCount,
+		LastUsedAt:      c.LastUsedAt,
+		CreatedAt:       c.CreatedAt,
+	}
+	return row.Save(s.db)
+}
+
+func (s *GormCredentialStore) DeleteCredential(userID string, id []byte) error {
+	return (&model.UserWebAuthnCredential{ID: encodeID(id), UserID: userID}).Delete(s.db)
+}
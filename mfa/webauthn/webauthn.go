@@ -0,0 +1,419 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webauthn registers passkeys and security keys and verifies their assertions,
+// see https://www.w3.org/TR/webauthn-2/
+package webauthn
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/duo-labs/webauthn/protocol"
+	gowebauthn "github.com/duo-labs/webauthn/webauthn"
+)
+
+const _defaultCeremonyTTL = 5 * time.Minute
+
+var (
+	// ErrCeremonyNotFound the ceremony is unknown or expired.
+	ErrCeremonyNotFound = errors.New("webauthn ceremony not found")
+	// ErrUserMismatch the ceremony was begun for another user.
+	ErrUserMismatch = errors.New("webauthn ceremony of another user")
+	// ErrNoCredentials the user has no registered credential.
+	ErrNoCredentials = errors.New("webauthn no credentials registered")
+	// ErrAttestationNotAllowed the authenticator does not satisfy the attestation policy.
+	ErrAttestationNotAllowed = errors.New("webauthn attestation not allowed")
+	// ErrClonedAuthenticator the signature counter went backwards, the key may have been cloned.
+	ErrClonedAuthenticator = errors.New("webauthn authenticator may be cloned")
+	// ErrVerification the response of the authenticator did not verify.
+	ErrVerification = errors.New("webauthn verification failed")
+)
+
+// Config of the relying party.
+type Config struct {
+	// RPID the domain credentials are scoped to, e.g. tkeel.example.
+	RPID string `mapstructure:"rp_id" json:"rp_id" yaml:"rpID"`
+	// RPDisplayName shown by the authenticator.
+	RPDisplayName string `mapstructure:"rp_display_name" json:"rp_display_name" yaml:"rpDisplayName"`
+	// RPOrigin origin of the pages running the ceremonies, e.g. https://tkeel.example.
+	RPOrigin string `mapstructure:"rp_origin" json:"rp_origin" yaml:"rpOrigin"`
+	// Attestation conveyance requested, none, indirect or direct. Default to none.
+	Attestation string `mapstructure:"attestation" json:"attestation" yaml:"attestation"`
+	// AllowedAttestationFormats attestation statement formats accepted, e.g. packed and tpm to
+	// reject unattested credentials. Empty accepts all.
+	AllowedAttestationFormats []string `mapstructure:"allowed_attestation_formats" json:"allowed_attestation_formats" yaml:"allowedAttestationFormats"`
+	// AllowedAAGUIDs hex AAGUIDs of the authenticator models accepted. Empty accepts all.
+	// An AAGUID is only trusted when the attestation chains to AttestationRootsFile, so setting
+	// it rejects unattested credentials.
+	AllowedAAGUIDs []string `mapstructure:"allowed_aaguids" json:"allowed_aaguids" yaml:"allowedAAGUIDs"`
+	// AttestationRootsFile PEM bundle of the authenticator vendor roots attestation certificates
+	// are verified against. Without it no AAGUID is trusted.
+	AttestationRootsFile string `mapstructure:"attestation_roots_file" json:"attestation_roots_file" yaml:"attestationRootsFile"`
+	// UserVerification required, preferred or discouraged. Default to preferred.
+	UserVerification string `mapstructure:"user_verification" json:"user_verification" yaml:"userVerification"`
+	// RequireResidentKey registers discoverable credentials, i.e. passkeys.
+	RequireResidentKey bool `mapstructure:"require_resident_key" json:"require_resident_key" yaml:"requireResidentKey"`
+	// CeremonyTTL how long a begun ceremony may be finished. Default to 5m.
+	CeremonyTTL time.Duration `mapstructure:"ceremony_ttl" json:"ceremony_ttl" yaml:"ceremonyTTL"`
+}
+
+// Credential a registered credential of a user, AAGUID is nil unless the attestation was verified.
+type Credential struct {
+	ID              []byte
+	UserID          string
+	Name            string
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	SignCount       uint32
+	CreatedAt       time.Time
+	LastUsedAt      time.Time
+}
+
+// User the account a ceremony is run for.
+type User struct {
+	ID          string
+	Name        string
+	DisplayName string
+}
+
+// Service runs the registration and assertion ceremonies.
+type Service struct {
+	conf       Config
+	rp         *gowebauthn.WebAuthn
+	roots      *x509.CertPool
+	creds      CredentialStore
+	ceremonies CeremonyStore
+}
+
+// New returns a Service, ceremonies defaults to a MemoryCeremonyStore.
+func New(conf Config, creds CredentialStore, ceremonies CeremonyStore) (*Service, error) {
+	if conf.Attestation == "" {
+		conf.Attestation = string(protocol.PreferNoAttestation)
+	}
+	if conf.UserVerification == "" {
+		conf.UserVerification = string(protocol.VerificationPreferred)
+	}
+	if conf.CeremonyTTL <= 0 {
+		conf.CeremonyTTL = _defaultCeremonyTTL
+	}
+	if ceremonies == nil {
+		ceremonies = NewMemoryCeremonyStore()
+	}
+	requireResidentKey := conf.RequireResidentKey
+	rp, err := gowebauthn.New(&gowebauthn.Config{
+		RPID:                  conf.RPID,
+		RPDisplayName:         conf.RPDisplayName,
+		RPOrigin:              conf.RPOrigin,
+		AttestationPreference: protocol.ConveyancePreference(conf.Attestation),
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			RequireResidentKey: &requireResidentKey,
+			UserVerification:   protocol.UserVerificationRequirement(conf.UserVerification),
+		},
+		Timeout: int(conf.CeremonyTTL / time.Millisecond),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("webauthn config %w", err)
+	}
+	s := &Service{conf: conf, rp: rp, creds: creds, ceremonies: ceremonies}
+	if conf.AttestationRootsFile != "" {
+		pem, err := os.ReadFile(conf.AttestationRootsFile)
+		if err != nil {
+			return nil, fmt.Errorf("read webauthn attestation roots %w", err)
+		}
+		s.roots = x509.NewCertPool()
+		if !s.roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("webauthn attestation roots %s hold no certificate", conf.AttestationRootsFile)
+		}
+	}
+	return s, nil
+}
+
+// BeginRegistration returns the id of the ceremony and the options to pass to
+// navigator.credentials.create, the registered credentials of user are excluded.
+func (s *Service) BeginRegistration(user *User) (string, *protocol.CredentialCreation, error) {
+	u, err := s.loadUser(user)
+	if err != nil {
+		return "", nil, err
+	}
+	exclude := make([]protocol.CredentialDescriptor, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		exclude = append(exclude, protocol.CredentialDescriptor{Type: protocol.PublicKeyCredentialType, CredentialID: c.ID})
+	}
+	options, session, err := s.rp.BeginRegistration(u, gowebauthn.WithExclusions(exclude))
+	if err != nil {
+		return "", nil, fmt.Errorf("begin webauthn registration %w", err)
+	}
+	id, err := s.saveCeremony(session)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, options, nil
+}
+
+// FinishRegistration verifies the response of navigator.credentials.create in the body of r
+// and stores the new credential under name.
+func (s *Service) FinishRegistration(user *User, ceremonyID, name string, r *http.Request) (*Credential, error) {
+	session, u, err := s.resume(user, ceremonyID)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialCreationResponse(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrVerification, protocolDetails(err))
+	}
+	c, err := s.rp.CreateCredential(u, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrVerification, protocolDetails(err))
+	}
+	aaguid := c.Authenticator.AAGUID
+	if !s.attested(&parsed.Response.AttestationObject) {
+		aaguid = nil
+	}
+	if err = s.checkAttestation(c.AttestationType, aaguid); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	credential := &Credential{
+		ID:              c.ID,
+		UserID:          user.ID,
+		Name:            name,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		AAGUID:          aaguid,
+		SignCount:       c.Authenticator.SignCount,
+		CreatedAt:       now,
+		LastUsedAt:      now,
+	}
+	if err = s.creds.SaveCredential(credential); err != nil {
+		return nil, fmt.Errorf("save webauthn credential %w", err)
+	}
+	return credential, nil
+}
+
+// BeginLogin returns the id of the ceremony and the options to pass to navigator.credentials.get,
+// for a login or a step-up of user.
+func (s *Service) BeginLogin(user *User) (string, *protocol.CredentialAssertion, error) {
+	u, err := s.loadUser(user)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(u.credentials) == 0 {
+		return "", nil, ErrNoCredentials
+	}
+	options, session, err := s.rp.BeginLogin(u,
+		gowebauthn.WithUserVerification(protocol.UserVerificationRequirement(s.conf.UserVerification)))
+	if err != nil {
+		return "", nil, fmt.Errorf("begin webauthn login %w", err)
+	}
+	id, err := s.saveCeremony(session)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, options, nil
+}
+
+// FinishLogin verifies the response of navigator.credentials.get in the body of r and returns the
+// credential used, whose signature counter is updated.
+func (s *Service) FinishLogin(user *User, ceremonyID string, r *http.Request) (*Credential, error) {
+	session, u, err := s.resume(user, ceremonyID)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.rp.FinishLogin(u, *session, r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrVerification, protocolDetails(err))
+	}
+	if c.Authenticator.CloneWarning {
+		return nil, ErrClonedAuthenticator
+	}
+	for _, credential := range u.credentials {
+		if bytes.Equal(credential.ID, c.ID) {
+			credential.SignCount = c.Authenticator.SignCount
+			credential.LastUsedAt = time.Now()
+			if err = s.creds.SaveCredential(credential); err != nil {
+				return nil, fmt.Errorf("save webauthn credential %w", err)
+			}
+			return credential, nil
+		}
+	}
+	return nil, ErrVerification
+}
+
+// Credentials returns the registered credentials of userID.
+func (s *Service) Credentials(userID string) ([]*Credential, error) {
+	return s.creds.Credentials(userID)
+}
+
+// RemoveCredential deletes the credential id of userID.
+func (s *Service) RemoveCredential(userID string, id []byte) error {
+	return s.creds.DeleteCredential(userID, id)
+}
+
+// checkAttestation applies the attestation policy, aaguid is nil when the attestation was not verified.
+func (s *Service) checkAttestation(format string, aaguid []byte) error {
+	if len(s.conf.AllowedAttestationFormats) > 0 && !utils.StringsInclude(s.conf.AllowedAttestationFormats, format) {
+		return fmt.Errorf("%w: attestation format %q", ErrAttestationNotAllowed, format)
+	}
+	if len(s.conf.AllowedAAGUIDs) == 0 {
+		return nil
+	}
+	if aaguid == nil {
+		return fmt.Errorf("%w: unverified attestation", ErrAttestationNotAllowed)
+	}
+	if !utils.StringsInclude(s.conf.AllowedAAGUIDs, fmt.Sprintf("%x", aaguid)) {
+		return fmt.Errorf("%w: authenticator %x", ErrAttestationNotAllowed, aaguid)
+	}
+	return nil
+}
+
+// attested reports whether the attestation certificate of att chains to the configured roots,
+// the library only checks the signature of the certificate, so anyone may claim an AAGUID.
+func (s *Service) attested(att *protocol.AttestationObject) bool {
+	if s.roots == nil {
+		return false
+	}
+	x5c, ok := att.AttStatement["x5c"].([]interface{})
+	if !ok || len(x5c) == 0 {
+		return false
+	}
+	certs := make([]*x509.Certificate, 0, len(x5c))
+	for _, raw := range x5c {
+		der, ok := raw.([]byte)
+		if !ok {
+			return false
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return false
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         s.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+func (s *Service) saveCeremony(session *gowebauthn.SessionData) (string, error) {
+	id, err := utils.RandBase64String(16)
+	if err != nil {
+		return "", err
+	}
+	if err = s.ceremonies.SaveCeremony(id, session, s.conf.CeremonyTTL); err != nil {
+		return "", fmt.Errorf("save webauthn ceremony %w", err)
+	}
+	return id, nil
+}
+
+// resume consumes the ceremony of user, so a response can only be verified once.
+func (s *Service) resume(user *User, ceremonyID string) (*gowebauthn.SessionData, *webauthnUser, error) {
+	session, err := s.ceremonies.ConsumeCeremony(ceremonyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(session.UserID, []byte(user.ID)) {
+		return nil, nil, ErrUserMismatch
+	}
+	u, err := s.loadUser(user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, u, nil
+}
+
+func (s *Service) loadUser(user *User) (*webauthnUser, error) {
+	credentials, err := s.creds.Credentials(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("load webauthn credentials %w", err)
+	}
+	return &webauthnUser{User: user, credentials: credentials}, nil
+}
+
+// protocolDetails the developer details of a protocol error, its message alone is generic.
+func protocolDetails(err error) string {
+	var perr *protocol.Error
+	if errors.As(err, &perr) && perr.DevInfo != "" {
+		return perr.Details + ": " + perr.DevInfo
+	}
+	if errors.As(err, &perr) {
+		return perr.Details
+	}
+	return err.Error()
+}
+
+// webauthnUser adapts User to the relying party library.
+type webauthnUser struct {
+	*User
+	credentials []*Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return []byte(u.ID)
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.Name
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	if u.DisplayName == "" {
+		return u.Name
+	}
+	return u.DisplayName
+}
+
+func (u *webauthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []gowebauthn.Credential {
+	credentials := make([]gowebauthn.Credential, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		credentials = append(credentials, gowebauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Authenticator:   gowebauthn.Authenticator{AAGUID: c.AAGUID, SignCount: c.SignCount},
+		})
+	}
+	return credentials
+}
+
+// encodeID the base64url form credential ids are stored under.
+func encodeID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+func decodeID(id string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil {
+		return nil, fmt.Errorf("decode webauthn credential id %w", err)
+	}
+	return raw, nil
+}
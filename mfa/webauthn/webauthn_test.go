@@ -0,0 +1,309 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/duo-labs/webauthn/protocol"
+	"github.com/duo-labs/webauthn/protocol/webauthncbor"
+	"github.com/duo-labs/webauthn/protocol/webauthncose"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	_rpID     = "tkeel.example"
+	_rpOrigin = "https://tkeel.example"
+)
+
+// authenticator a software security key signing with a P-256 key.
+type authenticator struct {
+	t       *testing.T
+	key     *ecdsa.PrivateKey
+	id      []byte
+	counter uint32
+	aaguid  []byte
+	// attestation signs a packed attestation when set, none otherwise.
+	attestation    *x509.Certificate
+	attestationKey *ecdsa.PrivateKey
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	assert.NoError(t, err)
+	return &authenticator{t: t, key: key, id: id, aaguid: make([]byte, 16)}
+}
+
+func (a *authenticator) clientData(typ string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    _rpOrigin,
+	})
+	assert.NoError(a.t, err)
+	return data
+}
+
+func (a *authenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(_rpID))
+	data := append(rpIDHash[:], flags)
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, a.counter)
+	return append(append(data, counter...), attested...)
+}
+
+// create answers navigator.credentials.create.
+func (a *authenticator) create(options *protocol.CredentialCreation) *http.Request {
+	publicKey, err := webauthncbor.Marshal(&webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{KeyType: int64(webauthncose.EllipticKey), Algorithm: int64(webauthncose.AlgES256)},
+		Curve:         1,
+		XCoord:        a.key.X.FillBytes(make([]byte, 32)),
+		YCoord:        a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	assert.NoError(a.t, err)
+	attested := append([]byte{}, a.aaguid...)
+	idLength := make([]byte, 2)
+	binary.BigEndian.PutUint16(idLength, uint16(len(a.id)))
+	attested = append(append(append(attested, idLength...), a.id...), publicKey...)
+	authData := a.authData(0x45, attested)
+	clientData := a.clientData("webauthn.create", options.Response.Challenge)
+	format, statement := "none", map[string]interface{}{}
+	if a.attestation != nil {
+		clientDataHash := sha256.Sum256(clientData)
+		digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+		signature, err := ecdsa.SignASN1(rand.Reader, a.attestationKey, digest[:])
+		assert.NoError(a.t, err)
+		format = "packed"
+		statement = map[string]interface{}{
+			"alg": int64(webauthncose.AlgES256),
+			"sig": signature,
+			"x5c": []interface{}{a.attestation.Raw},
+		}
+	}
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      format,
+		"attStmt":  statement,
+		"authData": authData,
+	})
+	assert.NoError(a.t, err)
+	return a.request(map[string]string{
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+		"attestationObject": base64.RawURLEncoding.EncodeToString(attestation),
+	})
+}
+
+// get answers navigator.credentials.get.
+func (a *authenticator) get(options *protocol.CredentialAssertion) *http.Request {
+	a.counter++
+	authData := a.authData(0x05, nil)
+	clientData := a.clientData("webauthn.get", options.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	assert.NoError(a.t, err)
+	return a.request(map[string]string{
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+		"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+		"signature":         base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
+
+func (a *authenticator) request(response map[string]string) *http.Request {
+	id := base64.RawURLEncoding.EncodeToString(a.id)
+	body, err := json.Marshal(map[string]interface{}{"id": id, "rawId": id, "type": "public-key", "response": response})
+	assert.NoError(a.t, err)
+	return httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+}
+
+func TestCeremonies(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	gormStore, err := NewGormCredentialStore(db)
+	assert.NoError(t, err)
+	conf := Config{RPID: _rpID, RPDisplayName: "tKeel", RPOrigin: _rpOrigin}
+	alice := &User{ID: "usr-alice", Name: "alice"}
+
+	for name, store := range map[string]CredentialStore{"memory": NewMemoryCredentialStore(), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			s, err := New(conf, store, nil)
+			assert.NoError(t, err)
+			_, _, err = s.BeginLogin(alice)
+			assert.ErrorIs(t, err, ErrNoCredentials)
+
+			key := newAuthenticator(t)
+			id, creation, err := s.BeginRegistration(alice)
+			assert.NoError(t, err)
+			credential, err := s.FinishRegistration(alice, id, "laptop", key.create(creation))
+			assert.NoError(t, err)
+			assert.Equal(t, key.id, credential.ID)
+			_, err = s.FinishRegistration(alice, id, "laptop", key.create(creation))
+			assert.ErrorIs(t, err, ErrCeremonyNotFound)
+
+			id, assertion, err := s.BeginLogin(alice)
+			assert.NoError(t, err)
+			used, err := s.FinishLogin(alice, id, key.get(assertion))
+			assert.NoError(t, err)
+			assert.Equal(t, uint32(1), used.SignCount)
+
+			// a response signed by another key does not verify.
+			id, assertion, err = s.BeginLogin(alice)
+			assert.NoError(t, err)
+			other := newAuthenticator(t)
+			other.id = key.id
+			_, err = s.FinishLogin(alice, id, other.get(assertion))
+			assert.ErrorIs(t, err, ErrVerification)
+
+			// a counter going backwards reveals a cloned key.
+			id, assertion, err = s.BeginLogin(alice)
+			assert.NoError(t, err)
+			key.counter = 0
+			_, err = s.FinishLogin(alice, id, key.get(assertion))
+			assert.ErrorIs(t, err, ErrClonedAuthenticator)
+
+			id, assertion, err = s.BeginLogin(alice)
+			assert.NoError(t, err)
+			_, err = s.FinishLogin(&User{ID: "usr-bob", Name: "bob"}, id, key.get(assertion))
+			assert.ErrorIs(t, err, ErrUserMismatch)
+
+			credentials, err := s.Credentials(alice.ID)
+			assert.NoError(t, err)
+			assert.Len(t, credentials, 1)
+			assert.NoError(t, s.RemoveCredential(alice.ID, key.id))
+			credentials, err = s.Credentials(alice.ID)
+			assert.NoError(t, err)
+			assert.Empty(t, credentials)
+		})
+	}
+
+	strict, err := New(Config{RPID: _rpID, RPDisplayName: "tKeel", RPOrigin: _rpOrigin,
+		AllowedAttestationFormats: []string{"packed"}}, NewMemoryCredentialStore(), nil)
+	assert.NoError(t, err)
+	id, creation, err := strict.BeginRegistration(alice)
+	assert.NoError(t, err)
+	_, err = strict.FinishRegistration(alice, id, "laptop", newAuthenticator(t).create(creation))
+	assert.ErrorIs(t, err, ErrAttestationNotAllowed)
+}
+
+// attestationCA issues the attestation certificates of a vendor.
+type attestationCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newAttestationCA(t *testing.T) *attestationCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tKeel Attestation Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &attestationCA{cert: cert, key: key}
+}
+
+// attest gives a an attestation certificate issued by ca.
+func (ca *attestationCA) attest(t *testing.T, a *authenticator) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			Country:            []string{"CN"},
+			Organization:       []string{"tKeel"},
+			OrganizationalUnit: []string{"Authenticator Attestation"},
+			CommonName:         "tKeel Security Key",
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	a.attestation, err = x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	a.attestationKey = key
+}
+
+func TestAttestedAAGUID(t *testing.T) {
+	ca := newAttestationCA(t)
+	roots := filepath.Join(t.TempDir(), "roots.pem")
+	assert.NoError(t, os.WriteFile(roots, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+	aaguid := []byte("tkeel-securitykey")[:16]
+	alice := &User{ID: "usr-alice", Name: "alice"}
+	register := func(conf Config, a *authenticator) (*Credential, error) {
+		conf.RPID, conf.RPDisplayName, conf.RPOrigin = _rpID, "tKeel", _rpOrigin
+		s, err := New(conf, NewMemoryCredentialStore(), nil)
+		assert.NoError(t, err)
+		id, creation, err := s.BeginRegistration(alice)
+		assert.NoError(t, err)
+		return s.FinishRegistration(alice, id, "key", a.create(creation))
+	}
+
+	// a none attestation may claim any aaguid, it is not stored nor accepted by the policy.
+	unattested := newAuthenticator(t)
+	unattested.aaguid = aaguid
+	credential, err := register(Config{AttestationRootsFile: roots}, unattested)
+	assert.NoError(t, err)
+	assert.Nil(t, credential.AAGUID)
+	_, err = register(Config{AttestationRootsFile: roots, AllowedAAGUIDs: []string{fmt.Sprintf("%x", aaguid)}}, unattested)
+	assert.ErrorIs(t, err, ErrAttestationNotAllowed)
+
+	attested := newAuthenticator(t)
+	attested.aaguid = aaguid
+	ca.attest(t, attested)
+	credential, err = register(Config{AttestationRootsFile: roots, AllowedAAGUIDs: []string{fmt.Sprintf("%x", aaguid)}}, attested)
+	assert.NoError(t, err)
+	assert.Equal(t, aaguid, credential.AAGUID)
+
+	// the certificate of another vendor does not chain to the roots.
+	forged := newAuthenticator(t)
+	forged.aaguid = aaguid
+	newAttestationCA(t).attest(t, forged)
+	credential, err = register(Config{AttestationRootsFile: roots}, forged)
+	assert.NoError(t, err)
+	assert.Nil(t, credential.AAGUID)
+	_, err = register(Config{AttestationRootsFile: roots, AllowedAAGUIDs: []string{fmt.Sprintf("%x", aaguid)}}, forged)
+	assert.ErrorIs(t, err, ErrAttestationNotAllowed)
+}
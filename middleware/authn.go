@@ -0,0 +1,269 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package middleware authenticates and authorizes requests of services built on tkeel security.
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authn/token/dpop"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	// ErrorInvalidRequest RFC 6750 error of a malformed request.
+	ErrorInvalidRequest = "invalid_request"
+	// ErrorInvalidToken RFC 6750 error of an expired, revoked or malformed token.
+	ErrorInvalidToken = "invalid_token"
+	// ErrorInsufficientScope RFC 6750 error of a token lacking the required scope.
+	ErrorInsufficientScope = "insufficient_scope"
+
+	_defaultQueryParam = "access_token"
+)
+
+var (
+	// ErrMissingToken the request carries no access token.
+	ErrMissingToken = errors.New("missing access token")
+	// ErrMultipleTokens the request carries the token by more than one method.
+	ErrMultipleTokens = errors.New("access token presented more than once")
+	// ErrInsufficientScope the token lacks a required scope.
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// Config of the authentication.
+type Config struct {
+	// Realm of the WWW-Authenticate challenges.
+	Realm string `mapstructure:"realm" json:"realm" yaml:"realm"`
+	// CookieName cookie carrying the token, empty disables cookies.
+	CookieName string `mapstructure:"cookie_name" json:"cookie_name" yaml:"cookieName"`
+	// AllowQuery accepts the token in the access_token query parameter, which leaks it to logs.
+	AllowQuery bool `mapstructure:"allow_query" json:"allow_query" yaml:"allowQuery"`
+	// TenantHeader header naming the tenant the request is scoped to, e.g. X-Tenant-ID. Tokens
+	// of other tenants are rejected, empty leaves requests unscoped.
+	TenantHeader string `mapstructure:"tenant_header" json:"tenant_header" yaml:"tenantHeader"`
+}
+
+// TenantResolver returns the tenant r is scoped to, empty when r is not tenant scoped.
+type TenantResolver func(r *http.Request) string
+
+// Authenticator verifies the access tokens of requests.
+type Authenticator struct {
+	conf     Config
+	verifier token.Verifier
+	// dpop nil while DPoP bound tokens are not accepted.
+	dpop   *dpop.Validator
+	tenant TenantResolver
+	logger *log.Helper
+}
+
+func NewAuthenticator(verifier token.Verifier, conf Config) *Authenticator {
+	a := &Authenticator{conf: conf, verifier: verifier}
+	if conf.TenantHeader != "" {
+		a.tenant = func(r *http.Request) string { return r.Header.Get(conf.TenantHeader) }
+	}
+	return a
+}
+
+// SetLogger writes the entries of the rejected requests to l instead of the global logger.
+func (a *Authenticator) SetLogger(l log.Logger) {
+	a.logger = log.NewHelper(l)
+}
+
+// SetTenantResolver scopes requests to the tenant resolve returns, e.g. from the path or the
+// host, replacing the TenantHeader.
+func (a *Authenticator) SetTenantResolver(resolve TenantResolver) {
+	a.tenant = resolve
+}
+
+// EnableDPoP accepts DPoP bound tokens presented with a proof of their key.
+func (a *Authenticator) EnableDPoP(validator *dpop.Validator) {
+	a.dpop = validator
+}
+
+// Authenticate extracts the access token of r from the Authorization header, the cookie or the
+// query and verifies it, the token must belong to the tenant r is scoped to.
+func (a *Authenticator) Authenticate(r *http.Request) (*token.Claims, error) {
+	claims, err := a.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if a.tenant != nil {
+		if err = token.CheckTenant(claims, a.tenant(r)); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (*token.Claims, error) {
+	header := r.Header.Get("Authorization")
+	var raw, from string
+	if header != "" {
+		from = "header"
+	}
+	if a.conf.CookieName != "" {
+		if c, err := r.Cookie(a.conf.CookieName); err == nil && c.Value != "" {
+			if from != "" {
+				return nil, ErrMultipleTokens
+			}
+			raw, from = c.Value, "cookie"
+		}
+	}
+	if a.conf.AllowQuery {
+		if v := r.URL.Query().Get(_defaultQueryParam); v != "" {
+			if from != "" {
+				return nil, ErrMultipleTokens
+			}
+			raw, from = v, "query"
+		}
+	}
+
+	if from == "" {
+		return nil, ErrMissingToken
+	}
+	if from == "header" {
+		if a.dpop != nil {
+			return a.dpop.VerifyRequest(r, a.verifier)
+		}
+		scheme, credentials := splitAuthorization(header)
+		if !strings.EqualFold(scheme, "Bearer") || credentials == "" {
+			return nil, fmt.Errorf("%w: unsupported authorization scheme", token.ErrInvalidToken)
+		}
+		raw = credentials
+	}
+	claims, err := a.verifier.Verify(raw)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Confirmation != nil && claims.Confirmation.JKT != "" {
+		return nil, fmt.Errorf("%w: dpop bound token presented as bearer", token.ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// Middleware rejects requests without a valid access token with a 401 challenge, the claims
+// of the token are available to next through ClaimsFromContext.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := a.Authenticate(r)
+		if err != nil {
+			a.WriteChallenge(w, err, "")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+	})
+}
+
+// Optional is Middleware letting requests without a token through unauthenticated,
+// requests with an invalid token are still rejected.
+func (a *Authenticator) Optional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := a.Authenticate(r)
+		switch {
+		case errors.Is(err, ErrMissingToken):
+			next.ServeHTTP(w, r)
+		case err != nil:
+			a.WriteChallenge(w, err, "")
+		default:
+			next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+		}
+	})
+}
+
+// RequireScope rejects requests whose token lacks one of scopes with a 403 challenge,
+// it must run after Middleware.
+func (a *Authenticator) RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				a.WriteChallenge(w, ErrMissingToken, "")
+				return
+			}
+			granted := strings.Fields(claims.Scope)
+			for _, s := range scopes {
+				if !utils.StringsInclude(granted, s) {
+					a.WriteChallenge(w, ErrInsufficientScope, strings.Join(scopes, " "))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WriteChallenge answers an authentication failure as RFC 6750 section 3 describes:
+// 401 with a bare challenge for a missing token, 401 invalid_token for a rejected token,
+// 400 invalid_request for a malformed request and 403 insufficient_scope naming scope.
+// The error_description is fixed per error, the detail of err is only logged. A DPoP proof
+// lacking the server nonce gets 401 use_dpop_nonce with a fresh DPoP-Nonce.
+func (a *Authenticator) WriteChallenge(w http.ResponseWriter, err error, scope string) {
+	status, code, description := http.StatusUnauthorized, ErrorInvalidToken, "the access token is invalid"
+	switch {
+	case errors.Is(err, ErrMissingToken):
+		code = ""
+	case errors.Is(err, ErrMultipleTokens):
+		status, code, description = http.StatusBadRequest, ErrorInvalidRequest, ErrMultipleTokens.Error()
+	case errors.Is(err, ErrInsufficientScope):
+		status, code, description = http.StatusForbidden, ErrorInsufficientScope, ErrInsufficientScope.Error()
+	case errors.Is(err, token.ErrTokenExpired):
+		description = "the access token expired"
+	case errors.Is(err, dpop.ErrUseNonce):
+		code, description = dpop.ErrorUseNonce, dpop.ErrUseNonce.Error()
+	}
+	if code != "" {
+		a.logger.Debugf("reject request %s: %s", code, err)
+	}
+	scheme := "Bearer"
+	if a.dpop != nil {
+		scheme = dpop.SchemeDPoP
+	}
+	params := make([]string, 0, 4)
+	if a.conf.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", a.conf.Realm))
+	}
+	if code != "" {
+		params = append(params, fmt.Sprintf("error=%q", code))
+		params = append(params, fmt.Sprintf("error_description=%q", description))
+	}
+	if scope != "" {
+		params = append(params, fmt.Sprintf("scope=%q", scope))
+	}
+	challenge := scheme
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	if a.dpop != nil {
+		if nonce := a.dpop.Nonce(); nonce != "" {
+			w.Header().Set(dpop.HeaderNonce, nonce)
+		}
+		// bearer tokens stay accepted alongside dpop bound tokens.
+		w.Header().Add("WWW-Authenticate", "Bearer"+strings.TrimPrefix(challenge, scheme))
+	}
+	w.WriteHeader(status)
+}
+
+func splitAuthorization(header string) (scheme, credentials string) {
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return header, ""
+	}
+	return header[:i], strings.TrimSpace(header[i+1:])
+}
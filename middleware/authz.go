@@ -0,0 +1,97 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/tracing"
+)
+
+// ErrForbidden the subject may not perform the action.
+var ErrForbidden = errs.ErrPermissionDenied
+
+// ExpandResource replaces the ":name" and "{name}" segments of template with the values param
+// returns for them, e.g. devices/:id becomes devices/d1 for the route parameter id=d1.
+func ExpandResource(template string, param func(name string) string) string {
+	if !strings.ContainsAny(template, ":{") {
+		return template
+	}
+	segments := strings.Split(template, "/")
+	for i, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			segments[i] = param(seg[1:])
+		case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}"):
+			segments[i] = param(seg[1 : len(seg)-1])
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// RequirePermission rejects requests whose subject may not perform action on resource in its
+// tenant with 403, it must run after the authentication middleware. Resource may be a template
+// expanded with param, nil param leaves it as is.
+func RequirePermission(checker authorizer.Checker, resource, action string,
+	param func(r *http.Request, name string) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := resource
+			if param != nil {
+				res = ExpandResource(resource, func(name string) string { return param(r, name) })
+			}
+			status := Authorize(r, checker, res, action)
+			if status != http.StatusOK {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Authorize checks the subject of the authenticated request r and returns the status to answer:
+// 200 when allowed, 401 without claims, 403 when denied and 500 when the check failed.
+func Authorize(r *http.Request, checker authorizer.Checker, resource, action string) int {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		return http.StatusUnauthorized
+	}
+	allowed, err := Check(r.Context(), checker, claims.Subject, claims.TenantID, resource, action)
+	switch {
+	case err != nil:
+		return http.StatusInternalServerError
+	case !allowed:
+		return http.StatusForbidden
+	}
+	return http.StatusOK
+}
+
+// Check asks checker within an authz.check span, a child of the span in ctx.
+func Check(ctx context.Context, checker authorizer.Checker, subject, tenantID, resource, action string) (allowed bool, err error) {
+	_, span := tracing.Start(ctx, "authz.check",
+		tracing.String("authz.subject", subject),
+		tracing.String("authz.tenant", tenantID),
+		tracing.String("authz.resource", resource),
+		tracing.String("authz.action", action))
+	defer func() {
+		span.SetAttributes(tracing.Bool("authz.allowed", allowed))
+		tracing.End(span, err)
+	}()
+	return checker.Check(subject, tenantID, resource, action)
+}
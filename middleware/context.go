@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token"
+)
+
+var _ idprovider.Identity = &claimsIdentity{}
+
+type claimsContextKey struct{}
+
+// WithClaims returns a copy of ctx carrying the claims of the authenticated request.
+func WithClaims(ctx context.Context, claims *token.Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims the middleware verified.
+func ClaimsFromContext(ctx context.Context) (*token.Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*token.Claims)
+	return claims, ok && claims != nil
+}
+
+// IdentityFromContext returns the identity of the authenticated request.
+func IdentityFromContext(ctx context.Context) (idprovider.Identity, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	return &claimsIdentity{claims: claims}, true
+}
+
+// SubjectFromContext returns the subject of the authenticated request, empty when unauthenticated.
+func SubjectFromContext(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return claims.Subject
+	}
+	return ""
+}
+
+// TenantFromContext returns the tenant of the authenticated request, empty when unauthenticated.
+func TenantFromContext(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return claims.TenantID
+	}
+	return ""
+}
+
+// claimsIdentity the Identity of verified token claims.
+type claimsIdentity struct {
+	claims *token.Claims
+}
+
+func (i *claimsIdentity) GetUserID() string {
+	return i.claims.Subject
+}
+
+func (i *claimsIdentity) GetTenantID() string {
+	return i.claims.TenantID
+}
+
+func (i *claimsIdentity) GetUsername() string {
+	return i.claims.Username
+}
+
+func (i *claimsIdentity) GetEmail() string {
+	return token.ClaimString(i.claims.Extra, "email")
+}
+
+func (i *claimsIdentity) GetExternalID() string {
+	return i.claims.Subject
+}
+
+func (i *claimsIdentity) GetExtra() map[string]interface{} {
+	return i.claims.Extra
+}
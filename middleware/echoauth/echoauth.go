@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package echoauth adapts the security middleware to Echo.
+package echoauth
+
+import (
+	"net/http"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Security the Echo middleware of an Authenticator and a Checker.
+type Security struct {
+	authn   *middleware.Authenticator
+	checker authorizer.Checker
+}
+
+// New returns the Echo middleware, checker may be nil when only authentication is used.
+func New(authn *middleware.Authenticator, checker authorizer.Checker) *Security {
+	return &Security{authn: authn, checker: checker}
+}
+
+// RequireAuth rejects requests without a valid access token with a 401 challenge.
+func (s *Security) RequireAuth() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			claims, err := s.authn.Authenticate(r)
+			if err != nil {
+				s.authn.WriteChallenge(c.Response(), err, "")
+				return nil
+			}
+			c.SetRequest(r.WithContext(middleware.WithClaims(r.Context(), claims)))
+			return next(c)
+		}
+	}
+}
+
+// RequirePermission rejects requests whose subject may not perform action on resource with 403,
+// route parameters in resource like devices/:id are expanded. It must run after RequireAuth.
+func (s *Security) RequirePermission(resource, action string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			res := middleware.ExpandResource(resource, c.Param)
+			switch status := middleware.Authorize(c.Request(), s.checker, res, action); status {
+			case http.StatusOK:
+				return next(c)
+			case http.StatusUnauthorized:
+				s.authn.WriteChallenge(c.Response(), middleware.ErrMissingToken, "")
+				return nil
+			default:
+				return echo.NewHTTPError(status)
+			}
+		}
+	}
+}
+
+// Claims returns the claims of the authenticated request of c.
+func Claims(c echo.Context) (*token.Claims, bool) {
+	return middleware.ClaimsFromContext(c.Request().Context())
+}
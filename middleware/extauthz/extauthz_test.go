@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package extauthz
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type checkerFunc func(subject, tenantID, resource, action string) (bool, error)
+
+func (f checkerFunc) Check(subject, tenantID, resource, action string) (bool, error) {
+	return f(subject, tenantID, resource, action)
+}
+
+func TestCheck(t *testing.T) {
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	alice, err := tokens.Issue(&token.Claims{Subject: "alice", TenantID: "t1"})
+	assert.NoError(t, err)
+	checker := checkerFunc(func(subject, tenantID, resource, action string) (bool, error) {
+		return subject == "alice" && resource == "devices" && action == "read", nil
+	})
+	s := NewServer(middleware.NewAuthenticator(tokens, middleware.Config{}), checker, []middleware.ForwardRule{
+		{Method: http.MethodGet, PathPrefix: "/devices", Resource: "devices", Action: "read"},
+		{PathPrefix: "/admin", Resource: "admin", Action: "*"},
+	})
+
+	listener := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	s.Register(gs)
+	go func() { _ = gs.Serve(listener) }()
+	defer gs.Stop()
+	conn, err := grpc.DialContext(context.Background(), "bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		code    codes.Code
+		status  int32
+	}{
+		{"allowed", http.MethodGet, "/devices/1", map[string]string{"authorization": "Bearer " + alice}, codes.OK, 0},
+		{"denied", http.MethodGet, "/admin", map[string]string{"authorization": "Bearer " + alice}, codes.PermissionDenied, http.StatusForbidden},
+		{"forged original uri", http.MethodGet, "/admin", map[string]string{"authorization": "Bearer " + alice, "x-forwarded-uri": "/devices"}, codes.PermissionDenied, http.StatusForbidden},
+		{"anonymous", http.MethodGet, "/devices/1", nil, codes.Unauthenticated, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &CheckRequest{Attributes: &AttributeContext{Request: &Request{HTTP: &HTTPRequest{
+				Method: tt.method, Path: tt.path, Host: "api.example", Scheme: "https", Headers: tt.headers,
+			}}}}
+			resp := &CheckResponse{}
+			assert.NoError(t, conn.Invoke(context.Background(), "/"+ServiceName+"/Check", req, resp))
+			assert.Equal(t, int32(tt.code), resp.Status.Code)
+			if tt.code != codes.OK {
+				assert.Equal(t, tt.status, resp.DeniedResponse.Status.Code)
+				return
+			}
+			assert.Nil(t, resp.DeniedResponse)
+			headers := make(map[string]string)
+			for _, h := range resp.OkResponse.Headers {
+				headers[h.Header.Key] = h.Header.Value
+				assert.False(t, h.Append.Value)
+			}
+			assert.Equal(t, map[string]string{middleware.HeaderSubject: "alice", middleware.HeaderTenant: "t1"}, headers)
+			assert.ElementsMatch(t, []string{"x-auth-username", "x-auth-scope"}, resp.OkResponse.HeadersToRemove)
+		})
+	}
+	resp := &CheckResponse{}
+	assert.NoError(t, conn.Invoke(context.Background(), "/"+ServiceName+"/Check", &CheckRequest{}, resp))
+	assert.Equal(t, int32(http.StatusBadRequest), resp.DeniedResponse.Status.Code)
+}
+
+// TestWireFormat decodes a request encoded with the field numbers of the envoy API.
+func TestWireFormat(t *testing.T) {
+	var httpReq []byte
+	httpReq = protowire.AppendTag(httpReq, 2, protowire.BytesType)
+	httpReq = protowire.AppendString(httpReq, "GET")
+	var header []byte
+	header = protowire.AppendTag(header, 1, protowire.BytesType)
+	header = protowire.AppendString(header, "authorization")
+	header = protowire.AppendTag(header, 2, protowire.BytesType)
+	header = protowire.AppendString(header, "Bearer x")
+	httpReq = protowire.AppendTag(httpReq, 3, protowire.BytesType)
+	httpReq = protowire.AppendBytes(httpReq, header)
+	httpReq = protowire.AppendTag(httpReq, 4, protowire.BytesType)
+	httpReq = protowire.AppendString(httpReq, "/devices")
+	var request, attributes, check []byte
+	request = protowire.AppendTag(request, 2, protowire.BytesType)
+	request = protowire.AppendBytes(request, httpReq)
+	attributes = protowire.AppendTag(attributes, 4, protowire.BytesType)
+	attributes = protowire.AppendBytes(attributes, request)
+	// an unknown field, e.g. the metadata context, is skipped.
+	attributes = protowire.AppendTag(attributes, 11, protowire.BytesType)
+	attributes = protowire.AppendBytes(attributes, []byte{})
+	check = protowire.AppendTag(check, 1, protowire.BytesType)
+	check = protowire.AppendBytes(check, attributes)
+
+	req := &CheckRequest{}
+	assert.NoError(t, proto.Unmarshal(check, req))
+	h := req.Attributes.Request.HTTP
+	assert.Equal(t, "GET", h.Method)
+	assert.Equal(t, "/devices", h.Path)
+	assert.Equal(t, map[string]string{"authorization": "Bearer x"}, h.Headers)
+}
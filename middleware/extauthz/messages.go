@@ -0,0 +1,167 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package extauthz
+
+import (
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// The messages of envoy/service/auth/v3/external_auth.proto the server reads and writes,
+// declared with the field numbers of the envoy API so the server does not depend on the
+// generated envoy packages. Fields the server does not use are left out, they are skipped
+// when decoding.
+
+// CheckRequest envoy.service.auth.v3.CheckRequest.
+type CheckRequest struct {
+	Attributes *AttributeContext `protobuf:"bytes,1,opt,name=attributes,proto3"`
+}
+
+// AttributeContext envoy.service.auth.v3.AttributeContext.
+type AttributeContext struct {
+	Source            *Peer             `protobuf:"bytes,1,opt,name=source,proto3"`
+	Destination       *Peer             `protobuf:"bytes,2,opt,name=destination,proto3"`
+	Request           *Request          `protobuf:"bytes,4,opt,name=request,proto3"`
+	ContextExtensions map[string]string `protobuf:"bytes,10,rep,name=context_extensions,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+// Peer envoy.service.auth.v3.AttributeContext.Peer.
+type Peer struct {
+	Address   *Address          `protobuf:"bytes,1,opt,name=address,proto3"`
+	Service   string            `protobuf:"bytes,2,opt,name=service,proto3"`
+	Labels    map[string]string `protobuf:"bytes,3,rep,name=labels,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Principal string            `protobuf:"bytes,4,opt,name=principal,proto3"`
+}
+
+// Address envoy.config.core.v3.Address.
+type Address struct {
+	SocketAddress *SocketAddress `protobuf:"bytes,1,opt,name=socket_address,json=socketAddress,proto3"`
+}
+
+// SocketAddress envoy.config.core.v3.SocketAddress.
+type SocketAddress struct {
+	Address   string `protobuf:"bytes,2,opt,name=address,proto3"`
+	PortValue uint32 `protobuf:"varint,3,opt,name=port_value,json=portValue,proto3"`
+}
+
+// Request envoy.service.auth.v3.AttributeContext.Request.
+type Request struct {
+	HTTP *HTTPRequest `protobuf:"bytes,2,opt,name=http,proto3"`
+}
+
+// HTTPRequest envoy.service.auth.v3.AttributeContext.HttpRequest, header names are lower case.
+type HTTPRequest struct {
+	ID       string            `protobuf:"bytes,1,opt,name=id,proto3"`
+	Method   string            `protobuf:"bytes,2,opt,name=method,proto3"`
+	Headers  map[string]string `protobuf:"bytes,3,rep,name=headers,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Path     string            `protobuf:"bytes,4,opt,name=path,proto3"`
+	Host     string            `protobuf:"bytes,5,opt,name=host,proto3"`
+	Scheme   string            `protobuf:"bytes,6,opt,name=scheme,proto3"`
+	Protocol string            `protobuf:"bytes,10,opt,name=protocol,proto3"`
+}
+
+// CheckResponse envoy.service.auth.v3.CheckResponse, one of DeniedResponse and OkResponse is set.
+type CheckResponse struct {
+	Status          *status.Status      `protobuf:"bytes,1,opt,name=status,proto3"`
+	DeniedResponse  *DeniedHTTPResponse `protobuf:"bytes,2,opt,name=denied_response,json=deniedResponse,proto3"`
+	OkResponse      *OkHTTPResponse     `protobuf:"bytes,3,opt,name=ok_response,json=okResponse,proto3"`
+	DynamicMetadata *structpb.Struct    `protobuf:"bytes,4,opt,name=dynamic_metadata,json=dynamicMetadata,proto3"`
+}
+
+// DeniedHTTPResponse envoy.service.auth.v3.DeniedHttpResponse.
+type DeniedHTTPResponse struct {
+	Status  *HTTPStatus          `protobuf:"bytes,1,opt,name=status,proto3"`
+	Headers []*HeaderValueOption `protobuf:"bytes,2,rep,name=headers,proto3"`
+	Body    string               `protobuf:"bytes,3,opt,name=body,proto3"`
+}
+
+// HTTPStatus envoy.type.v3.HttpStatus, the code is the HTTP status code.
+type HTTPStatus struct {
+	Code int32 `protobuf:"varint,1,opt,name=code,proto3"`
+}
+
+// OkHTTPResponse envoy.service.auth.v3.OkHttpResponse.
+type OkHTTPResponse struct {
+	// Headers set on the request sent upstream.
+	Headers []*HeaderValueOption `protobuf:"bytes,2,rep,name=headers,proto3"`
+	// HeadersToRemove removed from the request sent upstream.
+	HeadersToRemove []string `protobuf:"bytes,5,rep,name=headers_to_remove,json=headersToRemove,proto3"`
+}
+
+// HeaderValueOption envoy.config.core.v3.HeaderValueOption.
+type HeaderValueOption struct {
+	Header *HeaderValue `protobuf:"bytes,1,opt,name=header,proto3"`
+	// Append adds the value to the existing ones instead of replacing them when true.
+	Append *wrapperspb.BoolValue `protobuf:"bytes,2,opt,name=append,proto3"`
+}
+
+// HeaderValue envoy.config.core.v3.HeaderValue.
+type HeaderValue struct {
+	Key   string `protobuf:"bytes,1,opt,name=key,proto3"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *CheckRequest) Reset()         { *m = CheckRequest{} }
+func (m *CheckRequest) String() string { return proto.CompactTextString(m) }
+func (*CheckRequest) ProtoMessage()    {}
+
+func (m *AttributeContext) Reset()         { *m = AttributeContext{} }
+func (m *AttributeContext) String() string { return proto.CompactTextString(m) }
+func (*AttributeContext) ProtoMessage()    {}
+
+func (m *Peer) Reset()         { *m = Peer{} }
+func (m *Peer) String() string { return proto.CompactTextString(m) }
+func (*Peer) ProtoMessage()    {}
+
+func (m *Address) Reset()         { *m = Address{} }
+func (m *Address) String() string { return proto.CompactTextString(m) }
+func (*Address) ProtoMessage()    {}
+
+func (m *SocketAddress) Reset()         { *m = SocketAddress{} }
+func (m *SocketAddress) String() string { return proto.CompactTextString(m) }
+func (*SocketAddress) ProtoMessage()    {}
+
+func (m *Request) Reset()         { *m = Request{} }
+func (m *Request) String() string { return proto.CompactTextString(m) }
+func (*Request) ProtoMessage()    {}
+
+func (m *HTTPRequest) Reset()         { *m = HTTPRequest{} }
+func (m *HTTPRequest) String() string { return proto.CompactTextString(m) }
+func (*HTTPRequest) ProtoMessage()    {}
+
+func (m *CheckResponse) Reset()         { *m = CheckResponse{} }
+func (m *CheckResponse) String() string { return proto.CompactTextString(m) }
+func (*CheckResponse) ProtoMessage()    {}
+
+func (m *DeniedHTTPResponse) Reset()         { *m = DeniedHTTPResponse{} }
+func (m *DeniedHTTPResponse) String() string { return proto.CompactTextString(m) }
+func (*DeniedHTTPResponse) ProtoMessage()    {}
+
+func (m *HTTPStatus) Reset()         { *m = HTTPStatus{} }
+func (m *HTTPStatus) String() string { return proto.CompactTextString(m) }
+func (*HTTPStatus) ProtoMessage()    {}
+
+func (m *OkHTTPResponse) Reset()         { *m = OkHTTPResponse{} }
+func (m *OkHTTPResponse) String() string { return proto.CompactTextString(m) }
+func (*OkHTTPResponse) ProtoMessage()    {}
+
+func (m *HeaderValueOption) Reset()         { *m = HeaderValueOption{} }
+func (m *HeaderValueOption) String() string { return proto.CompactTextString(m) }
+func (*HeaderValueOption) ProtoMessage()    {}
+
+func (m *HeaderValue) Reset()         { *m = HeaderValue{} }
+func (m *HeaderValue) String() string { return proto.CompactTextString(m) }
+func (*HeaderValue) ProtoMessage()    {}
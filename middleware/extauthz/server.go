@@ -0,0 +1,181 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package extauthz serves the Envoy external authorization gRPC API, so Envoy and Istio
+// sidecars enforce tkeel security: the requests they hold are authenticated and checked against
+// the forward rules as middleware.Authenticator ForwardAuth does, allowed requests are sent
+// upstream with the identity headers.
+// See also, https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/auth/v3/external_auth.proto
+package extauthz
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/utils"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// ServiceName the gRPC service of the v3 external authorization API.
+const ServiceName = "envoy.service.auth.v3.Authorization"
+
+// _originalRequestHeaders the headers ForwardAuth reads the original request from, clients
+// must not set them as Envoy hands the original request itself.
+var _originalRequestHeaders = []string{
+	"x-forwarded-method", "x-forwarded-host", "x-forwarded-uri", "x-original-method", "x-original-uri",
+}
+
+// _identityHeaders the headers ForwardAuth answers with, set on or removed from the upstream
+// request so clients can not forge them.
+var _identityHeaders = []string{
+	middleware.HeaderSubject, middleware.HeaderTenant, middleware.HeaderUsername, middleware.HeaderScope,
+}
+
+// Server answers the Check calls of Envoy.
+type Server struct {
+	forward http.Handler
+}
+
+// NewServer returns a Server authenticating with authn and checking the first rule matching the
+// request with checker, checker may be nil without rules.
+func NewServer(authn *middleware.Authenticator, checker authorizer.Checker, rules []middleware.ForwardRule) *Server {
+	return &Server{forward: authn.ForwardAuth(checker, rules)}
+}
+
+// Register registers the Authorization service on s.
+func (s *Server) Register(gs *grpc.Server) {
+	gs.RegisterService(&_serviceDesc, s)
+}
+
+// Check authorizes the HTTP request of req.
+func (s *Server) Check(ctx context.Context, req *CheckRequest) (*CheckResponse, error) {
+	r, err := httpRequest(ctx, req)
+	if err != nil {
+		return denied(http.StatusBadRequest, nil, "malformed check request"), nil
+	}
+	w := &recorder{header: make(http.Header), status: http.StatusOK}
+	s.forward.ServeHTTP(w, r)
+	if w.status != http.StatusOK {
+		return denied(w.status, w.header, w.body.String()), nil
+	}
+	ok := &OkHTTPResponse{}
+	for _, name := range _identityHeaders {
+		if v := w.header.Get(name); v != "" {
+			ok.Headers = append(ok.Headers, &HeaderValueOption{
+				Header: &HeaderValue{Key: name, Value: v},
+				Append: wrapperspb.Bool(false),
+			})
+			continue
+		}
+		ok.HeadersToRemove = append(ok.HeadersToRemove, strings.ToLower(name))
+	}
+	return &CheckResponse{Status: &status.Status{Code: int32(codes.OK)}, OkResponse: ok}, nil
+}
+
+// httpRequest returns the request Envoy holds, pseudo headers and original request headers are skipped.
+func httpRequest(ctx context.Context, req *CheckRequest) (*http.Request, error) {
+	var h *HTTPRequest
+	if req.Attributes != nil && req.Attributes.Request != nil {
+		h = req.Attributes.Request.HTTP
+	}
+	if h == nil {
+		h = &HTTPRequest{}
+	}
+	u, err := url.ParseRequestURI(h.Path)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme, u.Host = h.Scheme, h.Host
+	r, err := http.NewRequestWithContext(ctx, h.Method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h.Headers {
+		if !strings.HasPrefix(k, ":") && !utils.StringsInclude(_originalRequestHeaders, strings.ToLower(k)) {
+			r.Header.Set(k, v)
+		}
+	}
+	if addr := remoteAddr(req.Attributes); addr != "" {
+		r.RemoteAddr = addr
+	}
+	return r, nil
+}
+
+func remoteAddr(attrs *AttributeContext) string {
+	if attrs == nil || attrs.Source == nil || attrs.Source.Address == nil || attrs.Source.Address.SocketAddress == nil {
+		return ""
+	}
+	return attrs.Source.Address.SocketAddress.Address
+}
+
+func denied(code int, header http.Header, body string) *CheckResponse {
+	resp := &DeniedHTTPResponse{Status: &HTTPStatus{Code: int32(code)}, Body: body}
+	for name, values := range header {
+		for _, v := range values {
+			resp.Headers = append(resp.Headers, &HeaderValueOption{Header: &HeaderValue{Key: name, Value: v}, Append: wrapperspb.Bool(true)})
+		}
+	}
+	grpcCode := codes.PermissionDenied
+	if code == http.StatusUnauthorized {
+		grpcCode = codes.Unauthenticated
+	}
+	return &CheckResponse{Status: &status.Status{Code: int32(grpcCode)}, DeniedResponse: resp}
+}
+
+// recorder keeps the answer of the forward auth handler.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+
+func (r *recorder) WriteHeader(status int) { r.status = status }
+
+type authorizationServer interface {
+	Check(ctx context.Context, req *CheckRequest) (*CheckResponse, error)
+}
+
+func checkHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(authorizationServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/Check"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(authorizationServer).Check(ctx, req.(*CheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*authorizationServer)(nil),
+	Methods:     []grpc.MethodDesc{{MethodName: "Check", Handler: checkHandler}},
+	Metadata:    "envoy/service/auth/v3/external_auth.proto",
+}
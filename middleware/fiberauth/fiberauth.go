@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fiberauth adapts the security middleware to Fiber.
+package fiberauth
+
+import (
+	"net/http"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// _localsClaims the fiber.Ctx locals key of the claims, fasthttp requests carry no context.Context.
+const _localsClaims = "security.claims"
+
+// Security the Fiber handlers of an Authenticator and a Checker.
+type Security struct {
+	authn   *middleware.Authenticator
+	checker authorizer.Checker
+}
+
+// New returns the Fiber handlers, checker may be nil when only authentication is used.
+func New(authn *middleware.Authenticator, checker authorizer.Checker) *Security {
+	return &Security{authn: authn, checker: checker}
+}
+
+// RequireAuth rejects requests without a valid access token with a 401 challenge.
+func (s *Security) RequireAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		r := &http.Request{}
+		if err := fasthttpadaptor.ConvertRequest(c.Context(), r, true); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		claims, err := s.authn.Authenticate(r)
+		if err != nil {
+			s.authn.WriteChallenge(&responseWriter{c: c}, err, "")
+			return nil
+		}
+		c.Locals(_localsClaims, claims)
+		return c.Next()
+	}
+}
+
+// RequirePermission rejects requests whose subject may not perform action on resource with 403,
+// route parameters in resource like devices/:id are expanded. It must run after RequireAuth.
+func (s *Security) RequirePermission(resource, action string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := Claims(c)
+		if !ok {
+			s.authn.WriteChallenge(&responseWriter{c: c}, middleware.ErrMissingToken, "")
+			return nil
+		}
+		res := middleware.ExpandResource(resource, func(name string) string { return c.Params(name) })
+		allowed, err := middleware.Check(c.UserContext(), s.checker, claims.Subject, claims.TenantID, res, action)
+		switch {
+		case err != nil:
+			return fiber.ErrInternalServerError
+		case !allowed:
+			return fiber.ErrForbidden
+		}
+		return c.Next()
+	}
+}
+
+// Claims returns the claims of the authenticated request of c.
+func Claims(c *fiber.Ctx) (*token.Claims, bool) {
+	claims, ok := c.Locals(_localsClaims).(*token.Claims)
+	return claims, ok
+}
+
+// responseWriter writes the challenges of the Authenticator to c.
+type responseWriter struct {
+	c      *fiber.Ctx
+	header http.Header
+}
+
+func (w *responseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	return w.c.Write(b)
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	for k, values := range w.header {
+		for _, v := range values {
+			w.c.Response().Header.Add(k, v)
+		}
+	}
+	w.c.Status(status)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/tkeel-io/security/authz/authorizer"
+)
+
+// Identity headers set on the 200 answer of the forward-auth handler, ingress controllers
+// copy them to the upstream request, e.g. with Traefik authResponseHeaders.
+const (
+	HeaderSubject  = "X-Auth-Subject"
+	HeaderTenant   = "X-Auth-Tenant"
+	HeaderUsername = "X-Auth-Username"
+	HeaderScope    = "X-Auth-Scope"
+)
+
+// errInvalidOriginalURI the original request uri header does not parse, the request the ingress
+// forwards is unknown.
+var errInvalidOriginalURI = errors.New("invalid original request uri")
+
+// ForwardRule the permission required for the original requests matching Method and PathPrefix.
+type ForwardRule struct {
+	// Method of the original request, empty for any method.
+	Method string `mapstructure:"method" json:"method" yaml:"method"`
+	// PathPrefix of the original request path, matched after unescaping and cleaning the path so
+	// dot segments can not step out of the prefix.
+	PathPrefix string `mapstructure:"path_prefix" json:"path_prefix" yaml:"pathPrefix"`
+	Resource   string `mapstructure:"resource" json:"resource" yaml:"resource"`
+	Action     string `mapstructure:"action" json:"action" yaml:"action"`
+}
+
+// ForwardAuth answers the subrequests of nginx auth_request and Traefik ForwardAuth: 200 with
+// the identity headers for authenticated original requests, 401 with a challenge otherwise and
+// 403 when the first matching rule denies. Original requests matching no rule are only
+// authenticated, checker may be nil without rules. An original uri that does not parse is
+// answered with 400.
+//
+// The original request is read from X-Forwarded-Method, X-Forwarded-Host and
+// X-Forwarded-Uri as Traefik sets them, or X-Original-Method and X-Original-URI as nginx is
+// usually configured to. The handler must only be reachable by the ingress, which must
+// overwrite these headers.
+func (a *Authenticator) ForwardAuth(checker authorizer.Checker, rules []ForwardRule) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		original, err := originalRequest(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		claims, err := a.Authenticate(original)
+		if err != nil {
+			a.WriteChallenge(w, err, "")
+			return
+		}
+		for _, rule := range rules {
+			if !rule.matches(original) {
+				continue
+			}
+			status := Authorize(original.WithContext(WithClaims(r.Context(), claims)), checker, rule.Resource, rule.Action)
+			if status != http.StatusOK {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			break
+		}
+		w.Header().Set(HeaderSubject, claims.Subject)
+		w.Header().Set(HeaderTenant, claims.TenantID)
+		w.Header().Set(HeaderUsername, claims.Username)
+		w.Header().Set(HeaderScope, claims.Scope)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (rule ForwardRule) matches(r *http.Request) bool {
+	return (rule.Method == "" || strings.EqualFold(rule.Method, r.Method)) &&
+		strings.HasPrefix(cleanPath(r.URL.Path), rule.PathPrefix)
+}
+
+// cleanPath returns the unescaped p without dot segments as the upstream resolves it, keeping a
+// trailing slash, e.g. /devices/../admin/ reads /admin/.
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// originalRequest returns r with the method, url and host of the request the ingress authenticates,
+// headers and cookies of the original request are forwarded as is.
+func originalRequest(r *http.Request) (*http.Request, error) {
+	original := r.Clone(r.Context())
+	if m := firstHeader(r, "X-Forwarded-Method", "X-Original-Method"); m != "" {
+		original.Method = m
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		original.Host = host
+	}
+	if uri := firstHeader(r, "X-Forwarded-Uri", "X-Original-URI"); uri != "" {
+		u, err := url.ParseRequestURI(uri)
+		if err != nil {
+			return nil, errInvalidOriginalURI
+		}
+		original.URL = u
+		original.RequestURI = uri
+	}
+	return original, nil
+}
+
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
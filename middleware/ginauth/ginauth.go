@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ginauth adapts the security middleware to Gin.
+package ginauth
+
+import (
+	"net/http"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Security the Gin handlers of an Authenticator and a Checker.
+type Security struct {
+	authn   *middleware.Authenticator
+	checker authorizer.Checker
+}
+
+// New returns the Gin handlers, checker may be nil when only authentication is used.
+func New(authn *middleware.Authenticator, checker authorizer.Checker) *Security {
+	return &Security{authn: authn, checker: checker}
+}
+
+// RequireAuth aborts requests without a valid access token with a 401 challenge.
+func (s *Security) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := s.authn.Authenticate(c.Request)
+		if err != nil {
+			s.authn.WriteChallenge(c.Writer, err, "")
+			c.Abort()
+			return
+		}
+		c.Request = c.Request.WithContext(middleware.WithClaims(c.Request.Context(), claims))
+	}
+}
+
+// RequirePermission aborts requests whose subject may not perform action on resource with 403,
+// route parameters in resource like devices/:id are expanded. It must run after RequireAuth.
+func (s *Security) RequirePermission(resource, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res := middleware.ExpandResource(resource, c.Param)
+		switch status := middleware.Authorize(c.Request, s.checker, res, action); status {
+		case http.StatusOK:
+		case http.StatusUnauthorized:
+			s.authn.WriteChallenge(c.Writer, middleware.ErrMissingToken, "")
+			c.Abort()
+		default:
+			c.AbortWithStatusJSON(status, gin.H{"error": http.StatusText(status)})
+		}
+	}
+}
+
+// Claims returns the claims of the authenticated request of c.
+func Claims(c *gin.Context) (*token.Claims, bool) {
+	return middleware.ClaimsFromContext(c.Request.Context())
+}
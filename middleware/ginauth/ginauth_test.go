@@ -0,0 +1,67 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ginauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/casbin"
+	"github.com/tkeel-io/security/authz/rbac"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	alice, err := tokens.Issue(&token.Claims{Subject: "alice", TenantID: "t1"})
+	assert.NoError(t, err)
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	roles := rbac.NewRoleOperator(enforcer)
+	assert.NoError(t, roles.CreateRole("t1", "viewer", rbac.Permission{Resource: "devices/d1", Action: "read"}))
+	assert.NoError(t, roles.AssignRole("t1", "alice", "viewer"))
+
+	s := New(middleware.NewAuthenticator(tokens, middleware.Config{}), roles)
+	router := gin.New()
+	router.GET("/devices/:id", s.RequireAuth(), s.RequirePermission("devices/:id", "read"), func(c *gin.Context) {
+		claims, ok := Claims(c)
+		assert.True(t, ok)
+		c.String(http.StatusOK, claims.Subject)
+	})
+
+	tests := []struct {
+		path, token string
+		status      int
+	}{
+		{"/devices/d1", alice, http.StatusOK},
+		{"/devices/d2", alice, http.StatusForbidden},
+		{"/devices/d1", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, tt.status, w.Code, tt.path)
+	}
+}
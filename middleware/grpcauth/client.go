@@ -0,0 +1,60 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpcauth
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryClient attaches an access token of source to every call, e.g. a client credentials
+// token source of the calling service.
+func UnaryClient(source oauth2.TokenSource) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := withToken(ctx, source)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClient is UnaryClient for streams.
+func StreamClient(source oauth2.TokenSource) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := withToken(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func withToken(ctx context.Context, source oauth2.TokenSource) (context.Context, error) {
+	// a token set by the caller, e.g. forwarded from the end-user, takes precedence.
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(_metadataAuthorization)) > 0 {
+		return ctx, nil
+	}
+	t, err := source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("grpcauth: get token %w", err)
+	}
+	return metadata.AppendToOutgoingContext(ctx, _metadataAuthorization, "Bearer "+t.AccessToken), nil
+}
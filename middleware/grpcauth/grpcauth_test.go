@@ -0,0 +1,117 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grpcauth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type checkerFunc func(subject, tenantID, resource, action string) (bool, error)
+
+func (f checkerFunc) Check(subject, tenantID, resource, action string) (bool, error) {
+	return f(subject, tenantID, resource, action)
+}
+
+func TestServer(t *testing.T) {
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	alice, err := tokens.Issue(&token.Claims{Subject: "alice", TenantID: "t1"})
+	assert.NoError(t, err)
+	bob, err := tokens.Issue(&token.Claims{Subject: "bob", TenantID: "t1"})
+	assert.NoError(t, err)
+
+	checker := checkerFunc(func(subject, tenantID, resource, action string) (bool, error) {
+		return subject == "alice" && resource == "devices" && action == "read", nil
+	})
+	s := NewServer(tokens, checker, Config{
+		Permissions: map[string]Permission{
+			"/tkeel.Device/Get": {Resource: "devices", Action: "read"},
+			"/tkeel.Admin/*":    {Resource: "admin", Action: "*"},
+		},
+		Public: []string{"/grpc.health.v1.Health/Check"},
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return middleware.SubjectFromContext(ctx), nil
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		code   codes.Code
+		resp   interface{}
+	}{
+		{"allowed", "/tkeel.Device/Get", alice, codes.OK, "alice"},
+		{"denied", "/tkeel.Device/Get", bob, codes.PermissionDenied, nil},
+		{"wildcard", "/tkeel.Admin/Reset", alice, codes.PermissionDenied, nil},
+		{"unmapped", "/tkeel.Device/List", bob, codes.OK, "bob"},
+		{"public", "/grpc.health.v1.Health/Check", "", codes.OK, ""},
+		{"missing", "/tkeel.Device/Get", "", codes.Unauthenticated, nil},
+		{"invalid", "/tkeel.Device/Get", "nope", codes.Unauthenticated, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tt.token))
+			}
+			resp, err := s.Unary()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.Equal(t, tt.resp, resp)
+		})
+	}
+
+	s.conf.DenyUnmapped = true
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+bob))
+	_, err = s.Unary()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/tkeel.Device/List"}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	s.conf.TenantMetadata = "x-tenant-id"
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+alice, "x-tenant-id", "t2"))
+	_, err = s.Unary()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/tkeel.Device/Get"}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, "invalid access token", status.Convert(err).Message(), "verifier errors are not disclosed")
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+alice, "x-tenant-id", "t1"))
+	resp, err := s.Unary()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/tkeel.Device/Get"}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", resp)
+}
+
+func TestUnaryClient(t *testing.T) {
+	source := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "service-token"})
+	var got []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		got = md.Get("authorization")
+		return nil
+	}
+
+	assert.NoError(t, UnaryClient(source)(context.Background(), "/tkeel.Device/Get", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"Bearer service-token"}, got)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer user-token")
+	assert.NoError(t, UnaryClient(source)(ctx, "/tkeel.Device/Get", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"Bearer user-token"}, got)
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package grpcauth authenticates and authorizes gRPC calls.
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/authorizer"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/utils"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const _metadataAuthorization = "authorization"
+
+// Permission the resource and action a method requires.
+type Permission struct {
+	Resource string `mapstructure:"resource" json:"resource" yaml:"resource"`
+	Action   string `mapstructure:"action" json:"action" yaml:"action"`
+}
+
+// Config of the server interceptors.
+type Config struct {
+	// Permissions by full method name like /tkeel.device.v1.Device/Get, a /tkeel.device.v1.Device/*
+	// entry applies to the methods of the service without their own entry.
+	Permissions map[string]Permission `mapstructure:"permissions" json:"permissions" yaml:"permissions"`
+	// Public methods callable without a token, e.g. /grpc.health.v1.Health/Check.
+	Public []string `mapstructure:"public" json:"public" yaml:"public"`
+	// DenyUnmapped rejects authenticated calls of methods without permission, they are only
+	// authenticated otherwise.
+	DenyUnmapped bool `mapstructure:"deny_unmapped" json:"deny_unmapped" yaml:"denyUnmapped"`
+	// TenantMetadata metadata key naming the tenant the call is scoped to, e.g. x-tenant-id.
+	// Tokens of other tenants are rejected, empty leaves calls unscoped.
+	TenantMetadata string `mapstructure:"tenant_metadata" json:"tenant_metadata" yaml:"tenantMetadata"`
+}
+
+// Server authenticates the bearer token of the authorization metadata and checks the
+// permission of the method.
+type Server struct {
+	verifier token.Verifier
+	checker  authorizer.Checker
+	conf     Config
+	logger   *log.Helper
+}
+
+// NewServer returns the server interceptors, checker may be nil when no permission is mapped.
+func NewServer(verifier token.Verifier, checker authorizer.Checker, conf Config) *Server {
+	return &Server{verifier: verifier, checker: checker, conf: conf}
+}
+
+// SetLogger writes the entries of the rejected calls to l instead of the global logger.
+func (s *Server) SetLogger(l log.Logger) {
+	s.logger = log.NewHelper(l)
+}
+
+// Unary returns the unary server interceptor.
+func (s *Server) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := s.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns the stream server interceptor, handlers get the claims from the stream context.
+func (s *Server) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := s.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorize returns ctx with the claims of the caller, or an Unauthenticated or PermissionDenied status.
+func (s *Server) authorize(ctx context.Context, method string) (context.Context, error) {
+	if utils.StringsInclude(s.conf.Public, method) {
+		return ctx, nil
+	}
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		// the verifier errors stay in the logs, callers only learn the category.
+		s.logger.Debugf("grpc reject %s: %s", method, err)
+		if errors.Is(err, middleware.ErrMissingToken) || errors.Is(err, middleware.ErrMultipleTokens) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid access token")
+	}
+	ctx = middleware.WithClaims(ctx, claims)
+
+	perm, ok := s.permission(method)
+	if !ok {
+		if s.conf.DenyUnmapped {
+			return nil, status.Errorf(codes.PermissionDenied, "no permission mapped for %s", method)
+		}
+		return ctx, nil
+	}
+	allowed, err := middleware.Check(ctx, s.checker, claims.Subject, claims.TenantID, perm.Resource, perm.Action)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "check permission: %s", err)
+	}
+	if !allowed {
+		return nil, status.Errorf(codes.PermissionDenied, "%s may not %s %s", claims.Subject, perm.Action, perm.Resource)
+	}
+	return ctx, nil
+}
+
+func (s *Server) authenticate(ctx context.Context) (*token.Claims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(_metadataAuthorization)
+	switch len(values) {
+	case 0:
+		return nil, middleware.ErrMissingToken
+	case 1:
+	default:
+		return nil, middleware.ErrMultipleTokens
+	}
+	raw := strings.TrimSpace(values[0])
+	if len(raw) < 7 || !strings.EqualFold(raw[:7], "Bearer ") {
+		return nil, fmt.Errorf("%w: unsupported authorization scheme", token.ErrInvalidToken)
+	}
+	claims, err := s.verifier.Verify(strings.TrimSpace(raw[7:]))
+	if err != nil {
+		return nil, err
+	}
+	if claims.Confirmation != nil && claims.Confirmation.JKT != "" {
+		return nil, fmt.Errorf("%w: dpop bound token presented as bearer", token.ErrInvalidToken)
+	}
+	if s.conf.TenantMetadata != "" {
+		var tenantID string
+		if values = md.Get(s.conf.TenantMetadata); len(values) > 0 {
+			tenantID = values[0]
+		}
+		if err = token.CheckTenant(claims, tenantID); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+func (s *Server) permission(method string) (Permission, bool) {
+	if p, ok := s.conf.Permissions[method]; ok {
+		return p, true
+	}
+	if i := strings.LastIndexByte(method, '/'); i > 0 {
+		p, ok := s.conf.Permissions[method[:i]+"/*"]
+		return p, ok
+	}
+	return Permission{}, false
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfilter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/middleware"
+)
+
+type rulesRequest struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// Handler serves the rules of the tenant the request authenticated as, or of its API key
+// ?key=: GET returns them, PUT replaces them with the allow and deny lists of the JSON body and
+// DELETE removes them. Rules denying the client of the request itself are rejected with 409, so
+// admins do not lock themselves out. Mount it behind the authorization middleware.
+func (f *Filter) Handler(keys *apikey.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.ClaimsFromContext(r.Context())
+		if !ok || claims.TenantID == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		kind, owner := KindTenant, claims.TenantID
+		if keyID := r.URL.Query().Get("key"); keyID != "" {
+			if !ownsKey(keys, claims.TenantID, keyID) {
+				http.Error(w, "api key not found", http.StatusNotFound)
+				return
+			}
+			kind, owner = KindAPIKey, keyID
+		}
+		switch r.Method {
+		case http.MethodGet:
+			rules, err := f.Rules(kind, owner)
+			if err != nil {
+				f.logger.Errorf("ip filter: %s", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if rules == nil {
+				rules = &Rules{Kind: kind, Owner: owner}
+			}
+			writeJSON(w, http.StatusOK, rules)
+		case http.MethodPut:
+			var req rulesRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid json body", http.StatusBadRequest)
+				return
+			}
+			rules := &Rules{Kind: kind, Owner: owner, Allow: req.Allow, Deny: req.Deny}
+			c, err := compile(rules)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			self := kind == KindTenant || (claims.Extra["token_type"] == apikey.TokenType && claims.ID == owner)
+			if self && !c.permits(f.ClientIP(r)) {
+				http.Error(w, "rules deny the client of this request", http.StatusConflict)
+				return
+			}
+			if err = f.SetRules(rules); err != nil {
+				f.logger.Errorf("ip filter: %s", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, rules)
+		case http.MethodDelete:
+			if err := f.DeleteRules(kind, owner); err != nil {
+				f.logger.Errorf("ip filter: %s", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func ownsKey(keys *apikey.Manager, tenantID, keyID string) bool {
+	if keys == nil {
+		return false
+	}
+	list, err := keys.List(tenantID, "")
+	if err != nil {
+		if !errors.Is(err, apikey.ErrKeyNotFound) {
+			log.Errorf("ip filter: list api keys %s", err)
+		}
+		return false
+	}
+	for _, k := range list {
+		if k.ID == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
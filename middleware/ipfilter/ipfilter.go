@@ -0,0 +1,435 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ipfilter enforces CIDR allow and deny lists, globally, per tenant and per API key,
+// for customers requiring network restrictions on top of tokens. The lists are compiled into
+// radix trees, swapped atomically when they change and reloaded periodically so the changes
+// of other replicas take effect without a restart.
+package ipfilter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/middleware"
+)
+
+// Kinds of rules.
+const (
+	// KindGlobal rules applying to all requests, without owner.
+	KindGlobal = "global"
+	// KindTenant rules of the tenant owner.
+	KindTenant = "tenant"
+	// KindAPIKey rules of the requests authenticated with the API key owner.
+	KindAPIKey = "api_key"
+
+	_defaultReloadInterval = time.Minute
+)
+
+var (
+	_ Store = &MemoryStore{}
+
+	// ErrInvalidRules the rules have an unknown kind, miss their owner or hold an invalid network.
+	ErrInvalidRules = errors.New("invalid ip rules")
+)
+
+// Rules the networks requests of a scope may come from. Deny wins over Allow, an empty Allow
+// allows all networks not denied.
+type Rules struct {
+	Kind  string `json:"kind" yaml:"kind"`
+	Owner string `json:"owner,omitempty" yaml:"owner"`
+	// Allow and Deny CIDRs or single addresses.
+	Allow     []string  `json:"allow,omitempty" yaml:"allow"`
+	Deny      []string  `json:"deny,omitempty" yaml:"deny"`
+	UpdatedAt time.Time `json:"updated_at,omitempty" yaml:"-"`
+}
+
+// Validate checks the scope and the networks of r.
+func (r *Rules) Validate() error {
+	_, err := compile(r)
+	return err
+}
+
+func scopeKey(kind, owner string) string {
+	return kind + "/" + owner
+}
+
+// Store persists the rules of the scopes.
+type Store interface {
+	// Load returns the rules of all scopes.
+	Load() ([]*Rules, error)
+	// Save stores r, replacing the rules of its scope.
+	Save(r *Rules) error
+	// Delete removes the rules of the scope, deleting missing rules is not an error.
+	Delete(kind, owner string) error
+}
+
+// MemoryStore in-process Store.
+type MemoryStore struct {
+	lock  sync.RWMutex
+	rules map[string]Rules
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{rules: make(map[string]Rules)}
+}
+
+func (s *MemoryStore) Load() ([]*Rules, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	out := make([]*Rules, 0, len(s.rules))
+	for _, r := range s.rules {
+		r := r
+		out = append(out, &r)
+	}
+	return out, nil
+}
+
+func (s *MemoryStore) Save(r *Rules) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rules[scopeKey(r.Kind, r.Owner)] = *r
+	return nil
+}
+
+func (s *MemoryStore) Delete(kind, owner string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.rules, scopeKey(kind, owner))
+	return nil
+}
+
+// compiled the trees of the rules of a scope.
+type compiled struct {
+	allow *tree
+	deny  *tree
+	// restricted the scope has an allow list.
+	restricted bool
+}
+
+func compile(r *Rules) (*compiled, error) {
+	switch {
+	case r.Kind == KindGlobal && r.Owner != "":
+		return nil, fmt.Errorf("%w: global rules without owner", ErrInvalidRules)
+	case (r.Kind == KindTenant || r.Kind == KindAPIKey) && r.Owner == "":
+		return nil, fmt.Errorf("%w: %s owner required", ErrInvalidRules, r.Kind)
+	case r.Kind != KindGlobal && r.Kind != KindTenant && r.Kind != KindAPIKey:
+		return nil, fmt.Errorf("%w: kind %s", ErrInvalidRules, r.Kind)
+	}
+	c := &compiled{allow: newTree(), deny: newTree(), restricted: len(r.Allow) > 0}
+	for _, list := range []struct {
+		networks []string
+		tree     *tree
+	}{{r.Allow, c.allow}, {r.Deny, c.deny}} {
+		for _, s := range list.networks {
+			network, err := parseNetwork(strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidRules, err)
+			}
+			list.tree.insert(network)
+		}
+	}
+	return c, nil
+}
+
+func (c *compiled) permits(ip net.IP) bool {
+	if ip == nil || c.deny.contains(ip) {
+		return false
+	}
+	return !c.restricted || c.allow.contains(ip)
+}
+
+// snapshot the compiled rules by scope.
+type snapshot map[string]*compiled
+
+// Config of the Filter.
+type Config struct {
+	// ReloadInterval how often Start reloads the rules from the store, picking up the changes of
+	// other replicas. Default to 1m.
+	ReloadInterval time.Duration `mapstructure:"reload_interval" json:"reload_interval" yaml:"reloadInterval"`
+	// TrustedProxies CIDRs of the proxies in front of the service, the client ip is taken from
+	// the X-Forwarded-For hops they added. Without them the peer address is the client.
+	TrustedProxies []string `mapstructure:"trusted_proxies" json:"trusted_proxies" yaml:"trustedProxies"`
+}
+
+// KeyResolver returns the id of the API key r presents, empty when it presents none.
+type KeyResolver func(r *http.Request) string
+
+// BearerKeyID returns a KeyResolver of API keys of keys presented as bearer tokens.
+func BearerKeyID(keys *apikey.Manager) KeyResolver {
+	return func(r *http.Request) string {
+		scheme, credentials := splitAuthorization(r.Header.Get("Authorization"))
+		if !strings.EqualFold(scheme, "Bearer") {
+			return ""
+		}
+		id, _ := keys.KeyID(credentials)
+		return id
+	}
+}
+
+func splitAuthorization(header string) (string, string) {
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return header, ""
+	}
+	return header[:i], strings.TrimSpace(header[i+1:])
+}
+
+// Filter decides whether requests may come from their client ip.
+type Filter struct {
+	conf    Config
+	store   Store
+	proxies *Proxies
+	tenant  middleware.TenantResolver
+	key     KeyResolver
+
+	snapshot atomic.Value
+	reload   sync.Mutex
+	cancel   context.CancelFunc
+	logger   *log.Helper
+}
+
+// New returns a Filter of the rules of store, loaded right away.
+func New(conf Config, store Store) (*Filter, error) {
+	if conf.ReloadInterval <= 0 {
+		conf.ReloadInterval = _defaultReloadInterval
+	}
+	proxies, err := NewProxies(conf.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	f := &Filter{conf: conf, store: store, proxies: proxies}
+	if err = f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetLogger writes the entries of the filter to l instead of the global logger.
+func (f *Filter) SetLogger(l log.Logger) {
+	f.logger = log.NewHelper(l)
+}
+
+// SetTenantResolver applies the rules of the tenant resolve returns before authentication, e.g.
+// from the tenant header.
+func (f *Filter) SetTenantResolver(resolve middleware.TenantResolver) {
+	f.tenant = resolve
+}
+
+// SetKeyResolver applies the rules of the API key resolve returns before authentication, e.g.
+// BearerKeyID.
+func (f *Filter) SetKeyResolver(resolve KeyResolver) {
+	f.key = resolve
+}
+
+// Reload compiles the rules of the store and swaps them in, the current rules are kept when
+// the store fails or holds invalid rules.
+func (f *Filter) Reload() error {
+	f.reload.Lock()
+	defer f.reload.Unlock()
+	rules, err := f.store.Load()
+	if err != nil {
+		return fmt.Errorf("load ip rules %w", err)
+	}
+	s := make(snapshot, len(rules))
+	for _, r := range rules {
+		c, err := compile(r)
+		if err != nil {
+			return fmt.Errorf("%s rules of %s %w", r.Kind, r.Owner, err)
+		}
+		s[scopeKey(r.Kind, r.Owner)] = c
+	}
+	f.snapshot.Store(s)
+	return nil
+}
+
+// Start reloads the rules every ReloadInterval until Stop.
+func (f *Filter) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	go func() {
+		ticker := time.NewTicker(f.conf.ReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := f.Reload(); err != nil {
+					f.logger.Errorf("reload ip rules %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends the reloads.
+func (f *Filter) Stop() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+}
+
+// SetRules validates and stores r, it takes effect right away on this replica and with the
+// next reload on the others.
+func (f *Filter) SetRules(r *Rules) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	r.UpdatedAt = time.Now()
+	if err := f.store.Save(r); err != nil {
+		return fmt.Errorf("save ip rules %w", err)
+	}
+	return f.Reload()
+}
+
+// DeleteRules removes the rules of the scope.
+func (f *Filter) DeleteRules(kind, owner string) error {
+	if err := f.store.Delete(kind, owner); err != nil {
+		return fmt.Errorf("delete ip rules %w", err)
+	}
+	return f.Reload()
+}
+
+// Rules returns the rules of the scope, nil when it has none.
+func (f *Filter) Rules(kind, owner string) (*Rules, error) {
+	rules, err := f.store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load ip rules %w", err)
+	}
+	for _, r := range rules {
+		if r.Kind == kind && r.Owner == owner {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+// Allowed reports whether ip passes the global rules and the rules of the tenant and the API
+// key, empty tenantID or keyID skip theirs. A nil ip only passes when no rules apply.
+func (f *Filter) Allowed(ip net.IP, tenantID, keyID string) bool {
+	s, _ := f.snapshot.Load().(snapshot)
+	scopes := []string{scopeKey(KindGlobal, "")}
+	if tenantID != "" {
+		scopes = append(scopes, scopeKey(KindTenant, tenantID))
+	}
+	if keyID != "" {
+		scopes = append(scopes, scopeKey(KindAPIKey, keyID))
+	}
+	for _, scope := range scopes {
+		if c, ok := s[scope]; ok && !c.permits(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// ClientIP returns the ip of the client of r, see Proxies.ClientIP.
+func (f *Filter) ClientIP(r *http.Request) net.IP {
+	return f.proxies.ClientIP(r)
+}
+
+// Proxies the trusted proxies in front of the service, to find the client ip of requests
+// elsewhere than in a Filter, e.g. for risk signals.
+type Proxies struct {
+	networks *tree
+}
+
+// NewProxies returns the Proxies of the CIDRs or ips.
+func NewProxies(trusted []string) (*Proxies, error) {
+	p := &Proxies{networks: newTree()}
+	for _, s := range trusted {
+		network, err := parseNetwork(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %w", err)
+		}
+		p.networks.insert(network)
+	}
+	return p, nil
+}
+
+// ClientIP returns the ip of the client of r: the peer address, or the last X-Forwarded-For
+// hop not added by a trusted proxy. nil when an address is malformed.
+func (p *Proxies) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.networks.contains(ip) {
+		return ip
+	}
+	forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+	if forwarded == "" {
+		return ip
+	}
+	hops := strings.Split(forwarded, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		if ip = net.ParseIP(strings.TrimSpace(hops[i])); ip == nil || !p.networks.contains(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// Middleware rejects requests from networks their rules do not allow with 403, before they are
+// authenticated. The tenant and the API key come from the resolvers, mount Authenticated after
+// the authentication too, so requests not naming their tenant or key do not skip its rules.
+func (f *Filter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tenantID, keyID string
+		if f.tenant != nil {
+			tenantID = f.tenant(r)
+		}
+		if f.key != nil {
+			keyID = f.key(r)
+		}
+		f.serve(w, r, next, tenantID, keyID)
+	})
+}
+
+// Authenticated applies the rules of the tenant and the API key of the verified claims, it must
+// run after the authentication middleware.
+func (f *Filter) Authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := middleware.ClaimsFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var keyID string
+		if claims.Extra["token_type"] == apikey.TokenType {
+			keyID = claims.ID
+		}
+		f.serve(w, r, next, claims.TenantID, keyID)
+	})
+}
+
+func (f *Filter) serve(w http.ResponseWriter, r *http.Request, next http.Handler, tenantID, keyID string) {
+	ip := f.ClientIP(r)
+	if !f.Allowed(ip, tenantID, keyID) {
+		f.logger.Debugf("ip filter: deny %s of tenant %q key %q", ip, tenantID, keyID)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	next.ServeHTTP(w, r)
+}
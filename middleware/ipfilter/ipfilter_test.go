@@ -0,0 +1,177 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTree(t *testing.T) {
+	tr := newTree()
+	for _, s := range []string{"10.0.0.0/8", "10.1.0.0/16", "192.168.1.7", "2001:db8::/32", "0.0.0.0/32"} {
+		network, err := parseNetwork(s)
+		assert.NoError(t, err)
+		tr.insert(network)
+	}
+	tests := []struct {
+		ip       string
+		contains bool
+	}{
+		{"10.2.3.4", true},
+		{"10.1.3.4", true},
+		{"::ffff:10.2.3.4", true},
+		{"11.0.0.1", false},
+		{"192.168.1.7", true},
+		{"192.168.1.8", false},
+		{"2001:db8:1::1", true},
+		{"2001:db9::1", false},
+		{"0.0.0.0", true},
+		{"0.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.contains, tr.contains(net.ParseIP(tt.ip)))
+		})
+	}
+	assert.False(t, tr.contains(nil))
+	_, err := parseNetwork("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	keys := apikey.NewManager(apikey.Config{}, apikey.NewMemoryStore())
+	rawKey, key, err := keys.Create(apikey.CreateOptions{TenantID: "t1", Owner: "svc"})
+	assert.NoError(t, err)
+
+	store := NewMemoryStore()
+	f, err := New(Config{TrustedProxies: []string{"172.16.0.0/12"}}, store)
+	assert.NoError(t, err)
+	f.SetTenantResolver(func(r *http.Request) string { return r.Header.Get("X-Tenant-ID") })
+	f.SetKeyResolver(BearerKeyID(keys))
+	assert.NoError(t, f.SetRules(&Rules{Kind: KindGlobal, Deny: []string{"198.51.100.0/24"}}))
+	assert.NoError(t, f.SetRules(&Rules{Kind: KindTenant, Owner: "t1", Allow: []string{"203.0.113.0/24", "10.0.0.0/8"}}))
+	assert.NoError(t, f.SetRules(&Rules{Kind: KindAPIKey, Owner: key.ID, Allow: []string{"10.0.0.5"}}))
+	assert.ErrorIs(t, f.SetRules(&Rules{Kind: KindTenant, Allow: []string{"10.0.0.0/8"}}), ErrInvalidRules)
+	assert.ErrorIs(t, f.SetRules(&Rules{Kind: KindTenant, Owner: "t1", Allow: []string{"10.0.0.0/40"}}), ErrInvalidRules)
+
+	handler := f.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		tenant    string
+		auth      string
+		status    int
+	}{
+		{"unscoped", "192.0.2.1:1000", "", "", "", http.StatusOK},
+		{"globally denied", "198.51.100.7:1000", "", "", "", http.StatusForbidden},
+		{"tenant allowed", "203.0.113.9:1000", "", "t1", "", http.StatusOK},
+		{"tenant denied", "192.0.2.1:1000", "", "t1", "", http.StatusForbidden},
+		{"other tenant", "192.0.2.1:1000", "", "t2", "", http.StatusOK},
+		{"key allowed", "10.0.0.5:1000", "", "t1", "Bearer " + rawKey, http.StatusOK},
+		{"key denied", "10.0.0.6:1000", "", "t1", "Bearer " + rawKey, http.StatusForbidden},
+		{"not a key", "10.0.0.6:1000", "", "t1", "Bearer eyJhbGciOi", http.StatusOK},
+		{"forwarded by trusted proxy", "172.16.0.2:1000", "192.0.2.1, 203.0.113.9", "t1", "", http.StatusOK},
+		{"forwarded by trusted proxies", "172.16.0.2:1000", "192.0.2.1, 172.16.0.3", "t1", "", http.StatusForbidden},
+		{"forwarded by client", "192.0.2.1:1000", "203.0.113.9", "t1", "", http.StatusForbidden},
+		{"malformed forwarded", "172.16.0.2:1000", "unknown", "t1", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/devices", nil)
+			r.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.tenant != "" {
+				r.Header.Set("X-Tenant-ID", tt.tenant)
+			}
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+
+	// the tenant of the claims applies also when the request does not name it.
+	r := httptest.NewRequest(http.MethodGet, "/devices", nil)
+	r.RemoteAddr = "192.0.2.1:1000"
+	r = r.WithContext(middleware.WithClaims(r.Context(), &token.Claims{Subject: "alice", TenantID: "t1"}))
+	w := httptest.NewRecorder()
+	f.Authenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// another replica picks the changes up on reload, invalid rules keep the current ones.
+	replica, err := New(Config{}, store)
+	assert.NoError(t, err)
+	assert.NoError(t, f.DeleteRules(KindTenant, "t1"))
+	assert.False(t, replica.Allowed(net.ParseIP("192.0.2.1"), "t1", ""))
+	assert.NoError(t, replica.Reload())
+	assert.True(t, replica.Allowed(net.ParseIP("192.0.2.1"), "t1", ""))
+	assert.NoError(t, store.Save(&Rules{Kind: KindTenant, Owner: "t1", Allow: []string{"bogus"}}))
+	assert.ErrorIs(t, replica.Reload(), ErrInvalidRules)
+	assert.True(t, replica.Allowed(net.ParseIP("192.0.2.1"), "t1", ""))
+}
+
+func TestHandler(t *testing.T) {
+	keys := apikey.NewManager(apikey.Config{}, apikey.NewMemoryStore())
+	_, key, err := keys.Create(apikey.CreateOptions{TenantID: "t1", Owner: "svc"})
+	assert.NoError(t, err)
+	_, otherKey, err := keys.Create(apikey.CreateOptions{TenantID: "t2", Owner: "svc"})
+	assert.NoError(t, err)
+	f, err := New(Config{}, NewMemoryStore())
+	assert.NoError(t, err)
+	handler := f.Handler(keys)
+
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		body   string
+		status int
+	}{
+		{"get empty", http.MethodGet, "", "", http.StatusOK},
+		{"lock out", http.MethodPut, "", `{"allow":["10.0.0.0/8"]}`, http.StatusConflict},
+		{"invalid network", http.MethodPut, "", `{"allow":["10.0.0.0/99"]}`, http.StatusBadRequest},
+		{"set tenant", http.MethodPut, "", `{"allow":["192.0.2.0/24"],"deny":["192.0.2.128/25"]}`, http.StatusOK},
+		{"set key", http.MethodPut, "?key=" + key.ID, `{"allow":["10.0.0.0/8"]}`, http.StatusOK},
+		{"key of other tenant", http.MethodPut, "?key=" + otherKey.ID, `{"allow":["10.0.0.0/8"]}`, http.StatusNotFound},
+		{"delete key", http.MethodDelete, "?key=" + key.ID, "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/ip-rules"+tt.query, strings.NewReader(tt.body))
+			r.RemoteAddr = "192.0.2.1:1000"
+			r = r.WithContext(middleware.WithClaims(r.Context(), &token.Claims{Subject: "admin", TenantID: "t1"}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+	assert.True(t, f.Allowed(net.ParseIP("192.0.2.1"), "t1", key.ID))
+	assert.False(t, f.Allowed(net.ParseIP("192.0.2.200"), "t1", ""))
+	assert.True(t, f.Allowed(net.ParseIP("192.0.2.200"), "t2", otherKey.ID))
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipfilter
+
+import (
+	"net"
+)
+
+// tree a binary radix tree of networks, a lookup visits at most one node per bit of the address.
+// IPv4 networks and addresses, also v4-mapped IPv6 ones, live under their own root.
+type tree struct {
+	v4 *node
+	v6 *node
+}
+
+type node struct {
+	children [2]*node
+	// terminal a network ends at the node, covering all its descendants.
+	terminal bool
+}
+
+func newTree() *tree {
+	return &tree{v4: &node{}, v6: &node{}}
+}
+
+func (t *tree) root(ip net.IP) (net.IP, *node) {
+	if v4 := ip.To4(); v4 != nil {
+		return v4, t.v4
+	}
+	return ip.To16(), t.v6
+}
+
+func (t *tree) insert(network *net.IPNet) {
+	ip, n := t.root(network.IP)
+	ones, _ := network.Mask.Size()
+	if len(ip) == net.IPv4len && len(network.Mask) == net.IPv6len {
+		ones -= 8 * (net.IPv6len - net.IPv4len)
+	}
+	for i := 0; i < ones; i++ {
+		if n.terminal {
+			return
+		}
+		b := bit(ip, i)
+		if n.children[b] == nil {
+			n.children[b] = &node{}
+		}
+		n = n.children[b]
+	}
+	n.terminal = true
+	n.children = [2]*node{}
+}
+
+func (t *tree) contains(ip net.IP) bool {
+	ip, n := t.root(ip)
+	if ip == nil {
+		return false
+	}
+	for i := 0; n != nil; i++ {
+		if n.terminal {
+			return true
+		}
+		if i == len(ip)*8 {
+			return false
+		}
+		n = n.children[bit(ip, i)]
+	}
+	return false
+}
+
+func bit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
+
+// parseNetwork parses a CIDR or a single address, which is a network of its own.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "CIDR address", Text: s}
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}, nil
+}
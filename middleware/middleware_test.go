@@ -0,0 +1,200 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tkeel-io/security/authn/token"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticator(t *testing.T) {
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	valid, err := tokens.Issue(&token.Claims{Subject: "alice", TenantID: "t1", Scope: "devices:read"})
+	assert.NoError(t, err)
+	bound, err := tokens.Issue(&token.Claims{Subject: "alice", Confirmation: &token.Confirmation{JKT: "jkt"}})
+	assert.NoError(t, err)
+
+	a := NewAuthenticator(tokens, Config{Realm: "tkeel", CookieName: "session", AllowQuery: true})
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := IdentityFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "alice", identity.GetUserID())
+		assert.Equal(t, "t1", TenantFromContext(r.Context()))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name      string
+		prepare   func(r *http.Request)
+		status    int
+		challenge string
+	}{
+		{"header", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+valid) }, http.StatusNoContent, ""},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "session", Value: valid}) }, http.StatusNoContent, ""},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "access_token=" + valid }, http.StatusNoContent, ""},
+		{"missing", func(r *http.Request) {}, http.StatusUnauthorized, `Bearer realm="tkeel"`},
+		{"invalid", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") },
+			http.StatusUnauthorized, `Bearer realm="tkeel", error="invalid_token"`},
+		{"basic", func(r *http.Request) { r.Header.Set("Authorization", "Basic "+valid) },
+			http.StatusUnauthorized, `Bearer realm="tkeel", error="invalid_token"`},
+		{"bound", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+bound) },
+			http.StatusUnauthorized, `Bearer realm="tkeel", error="invalid_token", error_description="the access token is invalid"`},
+		{"twice", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+valid)
+			r.URL.RawQuery = "access_token=" + valid
+		}, http.StatusBadRequest, `Bearer realm="tkeel", error="invalid_request"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/devices", nil)
+			tt.prepare(r)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.challenge != "" {
+				assert.Contains(t, w.Header().Get("WWW-Authenticate"), tt.challenge)
+			}
+		})
+	}
+
+	scoped := a.Middleware(a.RequireScope("devices:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	r := httptest.NewRequest(http.MethodPost, "/v1/devices", nil)
+	r.Header.Set("Authorization", "Bearer "+valid)
+	w := httptest.NewRecorder()
+	scoped.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `scope="devices:write"`)
+
+	optional := a.Optional(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := ClaimsFromContext(r.Context())
+		assert.False(t, ok)
+		assert.Empty(t, SubjectFromContext(r.Context()))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	w = httptest.NewRecorder()
+	optional.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestAuthenticatorTenant(t *testing.T) {
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	t1, err := tokens.Issue(&token.Claims{Subject: "alice", TenantID: "t1"})
+	assert.NoError(t, err)
+	unscoped, err := tokens.Issue(&token.Claims{Subject: "svc"})
+	assert.NoError(t, err)
+
+	a := NewAuthenticator(tokens, Config{TenantHeader: "X-Tenant-ID"})
+	tests := []struct {
+		name   string
+		token  string
+		tenant string
+		err    error
+	}{
+		{"match", t1, "t1", nil},
+		{"unscoped request", t1, "", nil},
+		{"mismatch", t1, "t2", token.ErrTenantMismatch},
+		{"tokens without tenant", unscoped, "t1", token.ErrTenantRequired},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		r.Header.Set("X-Tenant-ID", tt.tenant)
+		_, err := a.Authenticate(r)
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+
+	a.SetTenantResolver(func(r *http.Request) string { return r.URL.Query().Get("tenant") })
+	r := httptest.NewRequest(http.MethodGet, "/?tenant=t2", nil)
+	r.Header.Set("Authorization", "Bearer "+t1)
+	w := httptest.NewRecorder()
+	a.Middleware(http.NotFoundHandler()).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="invalid_token"`)
+}
+
+func TestExpandResource(t *testing.T) {
+	params := map[string]string{"id": "d1", "tenant": "t1"}
+	param := func(name string) string { return params[name] }
+	assert.Equal(t, "devices/d1", ExpandResource("devices/:id", param))
+	assert.Equal(t, "tenants/t1/devices/d1/commands", ExpandResource("tenants/{tenant}/devices/:id/commands", param))
+	assert.Equal(t, "devices/*", ExpandResource("devices/*", param))
+}
+
+func TestForwardAuth(t *testing.T) {
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	alice, err := tokens.Issue(&token.Claims{Subject: "alice", TenantID: "t1", Scope: "openid"})
+	assert.NoError(t, err)
+	checker := checkerFunc(func(subject, tenantID, resource, action string) (bool, error) {
+		return resource == "devices" && action == "read", nil
+	})
+	h := NewAuthenticator(tokens, Config{AllowQuery: true}).ForwardAuth(checker, []ForwardRule{
+		{Method: http.MethodGet, PathPrefix: "/api/devices", Resource: "devices", Action: "read"},
+		{PathPrefix: "/api/devices", Resource: "devices", Action: "write"},
+		{PathPrefix: "/api/admin", Resource: "admin", Action: "read"},
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"traefik", map[string]string{"X-Forwarded-Method": "GET", "X-Forwarded-Uri": "/api/devices/d1",
+			"Authorization": "Bearer " + alice}, http.StatusOK},
+		{"nginx query", map[string]string{"X-Original-Method": "GET", "X-Original-URI": "/api/devices?access_token=" + alice},
+			http.StatusOK},
+		{"denied", map[string]string{"X-Forwarded-Method": "DELETE", "X-Forwarded-Uri": "/api/devices/d1",
+			"Authorization": "Bearer " + alice}, http.StatusForbidden},
+		{"unmapped", map[string]string{"X-Forwarded-Method": "DELETE", "X-Forwarded-Uri": "/api/users/u1",
+			"Authorization": "Bearer " + alice}, http.StatusOK},
+		{"missing", map[string]string{"X-Forwarded-Method": "GET", "X-Forwarded-Uri": "/api/devices"}, http.StatusUnauthorized},
+		{"dot segments", map[string]string{"X-Forwarded-Method": "GET", "X-Forwarded-Uri": "/api/devices/../admin",
+			"Authorization": "Bearer " + alice}, http.StatusForbidden},
+		{"escaped dot segments", map[string]string{"X-Forwarded-Method": "GET", "X-Forwarded-Uri": "/api/devices/%2e%2e/admin",
+			"Authorization": "Bearer " + alice}, http.StatusForbidden},
+		{"unparsable uri", map[string]string{"X-Forwarded-Method": "GET", "X-Forwarded-Uri": "/api/devices/%zz",
+			"Authorization": "Bearer " + alice}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, "alice", w.Header().Get(HeaderSubject))
+				assert.Equal(t, "t1", w.Header().Get(HeaderTenant))
+			}
+		})
+	}
+}
+
+type checkerFunc func(subject, tenantID, resource, action string) (bool, error)
+
+func (f checkerFunc) Check(subject, tenantID, resource, action string) (bool, error) {
+	return f(subject, tenantID, resource, action)
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ratelimit limits the request rate of authenticated subjects, tenants or API keys.
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/validation"
+)
+
+var _ Limiter = &MemoryLimiter{}
+
+// Config of a token bucket.
+type Config struct {
+	// Limit requests allowed per Period.
+	Limit int `mapstructure:"limit" json:"limit" yaml:"limit"`
+	// Period over which Limit requests are allowed. Default to 1s.
+	Period time.Duration `mapstructure:"period" json:"period" yaml:"period"`
+	// Burst size of the bucket. Default to Limit.
+	Burst int `mapstructure:"burst" json:"burst" yaml:"burst"`
+}
+
+// Validate reports a bucket that can never refill.
+func (c *Config) Validate() error {
+	r := validation.New()
+	if c.Limit <= 0 {
+		r.Add("limit", "must be positive", "set the requests allowed per period, e.g. 10")
+	}
+	if c.Period < 0 {
+		r.Add("period", "must not be negative", "leave it empty for 1s")
+	}
+	if c.Burst < 0 {
+		r.Add("burst", "must not be negative", "leave it empty for limit")
+	}
+	return r.Err()
+}
+
+func (c *Config) init() {
+	if c.Period <= 0 {
+		c.Period = time.Second
+	}
+	if c.Burst <= 0 {
+		c.Burst = c.Limit
+	}
+}
+
+// rate tokens refilled per second.
+func (c *Config) rate() float64 {
+	return float64(c.Limit) / c.Period.Seconds()
+}
+
+// Result of taking a token.
+type Result struct {
+	Allowed bool
+	// Limit size of the bucket.
+	Limit int
+	// Remaining tokens after the request.
+	Remaining int
+	// Reset time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter time until the next token, zero when allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter takes tokens from the bucket of a key.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// result returns the Result of a bucket holding tokens after the request.
+func result(conf *Config, allowed bool, tokens float64) Result {
+	rate := conf.rate()
+	r := Result{
+		Allowed:   allowed,
+		Limit:     conf.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(conf.Burst) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		r.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return r
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryLimiter in-process Limiter, each replica limits on its own.
+type MemoryLimiter struct {
+	conf Config
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryLimiter(conf Config) (*MemoryLimiter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	conf.init()
+	return &MemoryLimiter{conf: conf, buckets: make(map[string]*bucket), lastSweep: time.Now()}, nil
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	rate := l.conf.rate()
+	burst := float64(l.conf.Burst)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	l.sweep(now)
+	return result(&l.conf, allowed, b.tokens), nil
+}
+
+// sweep drops the buckets refilled by now, at most once per Period.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.conf.Period {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(float64(l.conf.Burst) / l.conf.rate() * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// KeyFunc returns the key of the bucket of r, empty to not limit r.
+type KeyFunc func(r *http.Request) string
+
+// BySubject limits each authenticated subject.
+func BySubject(r *http.Request) string {
+	if claims, ok := middleware.ClaimsFromContext(r.Context()); ok {
+		return "sub:" + claims.TenantID + ":" + claims.Subject
+	}
+	return ""
+}
+
+// ByTenant limits each tenant as a whole.
+func ByTenant(r *http.Request) string {
+	if tenantID := middleware.TenantFromContext(r.Context()); tenantID != "" {
+		return "tenant:" + tenantID
+	}
+	return ""
+}
+
+// ByHeader limits each value of the header, e.g. the API key of requests authenticated by one.
+// The key holds the hash of the value, credentials end up neither in redis nor in the logs.
+func ByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			sum := sha256.Sum256([]byte(v))
+			return "header:" + name + ":" + hex.EncodeToString(sum[:])
+		}
+		return ""
+	}
+}
+
+// Middleware rejects requests over the limit of their key with 429 and sets the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers. It must run after authentication, requests
+// without a key are not limited. Requests are let through when the limiter fails.
+func Middleware(limiter Limiter, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			res, err := limiter.Allow(r.Context(), k)
+			if err != nil {
+				log.Warnf("rate limit %s: %s", k, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
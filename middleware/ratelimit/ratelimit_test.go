@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/errs"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	conf := Config{Limit: 2, Period: time.Minute, Burst: 3}
+
+	_, err = NewMemoryLimiter(Config{})
+	assert.ErrorIs(t, err, errs.ErrInvalidConfig)
+	_, err = NewRedisLimiter(client, "", Config{Limit: -1})
+	assert.ErrorIs(t, err, errs.ErrInvalidConfig)
+	memory, err := NewMemoryLimiter(conf)
+	assert.NoError(t, err)
+	shared, err := NewRedisLimiter(client, "", conf)
+	assert.NoError(t, err)
+	limiters := map[string]Limiter{"memory": memory, "redis": shared}
+	for name, l := range limiters {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for i := 2; i >= 0; i-- {
+				res, err := l.Allow(ctx, "alice")
+				assert.NoError(t, err)
+				assert.True(t, res.Allowed)
+				assert.Equal(t, 3, res.Limit)
+				assert.Equal(t, i, res.Remaining)
+			}
+			res, err := l.Allow(ctx, "alice")
+			assert.NoError(t, err)
+			assert.False(t, res.Allowed)
+			assert.InDelta(t, 30*time.Second, res.RetryAfter, float64(time.Second))
+
+			res, err = l.Allow(ctx, "bob")
+			assert.NoError(t, err)
+			assert.True(t, res.Allowed)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter, err := NewMemoryLimiter(Config{Limit: 1, Period: time.Hour})
+	assert.NoError(t, err)
+	h := Middleware(limiter, BySubject)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	serve := func(subject string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if subject != "" {
+			r = r.WithContext(middleware.WithClaims(r.Context(), &token.Claims{Subject: subject}))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("alice")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "3600", w.Header().Get("RateLimit-Reset"))
+
+	w = serve("alice")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusNoContent, serve("bob").Code)
+	assert.Equal(t, http.StatusNoContent, serve("").Code)
+	assert.Equal(t, http.StatusNoContent, serve("").Code)
+}
+
+func TestByHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Empty(t, ByHeader("X-API-Key")(r))
+	r.Header.Set("X-API-Key", "tk_secret")
+	key := ByHeader("X-API-Key")(r)
+	assert.NotContains(t, key, "tk_secret", "the credential is hashed")
+	r2 := httptest.NewRequest(http.MethodGet, "/", nil)
+	r2.Header.Set("X-API-Key", "tk_secret")
+	assert.Equal(t, key, ByHeader("X-API-Key")(r2))
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var _ Limiter = &RedisLimiter{}
+
+const _defaultRedisPrefix = "ratelimit:"
+
+// _tokenBucket takes a token from the bucket hash KEYS[1] holding tokens and the millisecond
+// time of the last refill. ARGV: burst, tokens per second, now in milliseconds.
+var _tokenBucket = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) * 1000 / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisLimiter Limiter shared by all replicas through redis.
+type RedisLimiter struct {
+	client redis.UniversalClient
+	conf   Config
+	prefix string
+}
+
+// NewRedisLimiter returns a RedisLimiter keeping the buckets under prefix, default to ratelimit:.
+func NewRedisLimiter(client redis.UniversalClient, prefix string, conf Config) (*RedisLimiter, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	conf.init()
+	if prefix == "" {
+		prefix = _defaultRedisPrefix
+	}
+	return &RedisLimiter{client: client, conf: conf, prefix: prefix}, nil
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	reply, err := _tokenBucket.Run(ctx, l.client, []string{l.prefix + key},
+		l.conf.Burst, l.conf.rate(), time.Now().UnixNano()/int64(time.Millisecond)).Result()
+	if err != nil {
+		return Result{}, fmt.Errorf("run token bucket %w", err)
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return Result{}, fmt.Errorf("unexpected token bucket reply %v", reply)
+	}
+	allowed, _ := values[0].(int64)
+	raw, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return Result{}, fmt.Errorf("parse tokens %w", err)
+	}
+	return result(&l.conf, allowed == 1, tokens), nil
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wsauth authenticates WebSocket connections and enforces the expiry of their token.
+package wsauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+)
+
+// SubprotocolToken the Sec-WebSocket-Protocol entry preceding the access token, browsers
+// can not set the Authorization header of upgrade requests: new WebSocket(url, ["access_token", token]).
+const SubprotocolToken = "access_token"
+
+const _headerProtocol = "Sec-WebSocket-Protocol"
+
+var (
+	// ErrSessionExpired the token of the connection expired without refresh.
+	ErrSessionExpired = errors.New("websocket session expired")
+	// ErrIdentityChanged the refreshed token belongs to another subject or tenant.
+	ErrIdentityChanged = errors.New("refreshed token identity changed")
+)
+
+// Config of the handshake.
+type Config struct {
+	// Realm of the WWW-Authenticate challenges.
+	Realm string `mapstructure:"realm" json:"realm" yaml:"realm"`
+	// CookieName cookie carrying the token, empty disables cookies.
+	CookieName string `mapstructure:"cookie_name" json:"cookie_name" yaml:"cookieName"`
+}
+
+// Handshake authenticates WebSocket upgrade requests by the token in the subprotocols, the
+// access_token query parameter, the Authorization header or the cookie.
+type Handshake struct {
+	verifier token.Verifier
+	authn    *middleware.Authenticator
+}
+
+func NewHandshake(verifier token.Verifier, conf Config) *Handshake {
+	return &Handshake{
+		verifier: verifier,
+		authn: middleware.NewAuthenticator(verifier, middleware.Config{
+			Realm:      conf.Realm,
+			CookieName: conf.CookieName,
+			// the query is the only other place browsers can put the token of an upgrade request.
+			AllowQuery: true,
+		}),
+	}
+}
+
+// Authenticate returns the session of the upgrade request r and the subprotocols offered besides
+// the token, the server must select one of those and never echo the token entry.
+func (h *Handshake) Authenticate(r *http.Request) (*Session, []string, error) {
+	raw, offers := splitProtocols(r.Header.Values(_headerProtocol))
+	var claims *token.Claims
+	var err error
+	if raw != "" {
+		if r.Header.Get("Authorization") != "" || r.URL.Query().Get(SubprotocolToken) != "" {
+			return nil, nil, middleware.ErrMultipleTokens
+		}
+		claims, err = h.verifier.Verify(raw)
+	} else {
+		claims, err = h.authn.Authenticate(r)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return newSession(h.verifier, claims), offers, nil
+}
+
+// Reject answers the upgrade request of a failed Authenticate with a challenge.
+func (h *Handshake) Reject(w http.ResponseWriter, err error) {
+	h.authn.WriteChallenge(w, err, "")
+}
+
+// Middleware rejects unauthenticated upgrade requests and passes the others to the upgrading
+// next with the session in the context and the token removed from the offered subprotocols.
+func (h *Handshake) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, offers, err := h.Authenticate(r)
+		if err != nil {
+			h.Reject(w, err)
+			return
+		}
+		r = r.WithContext(session.Context(r.Context()))
+		r.Header.Del(_headerProtocol)
+		if len(offers) > 0 {
+			r.Header.Set(_headerProtocol, strings.Join(offers, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// splitProtocols returns the token following SubprotocolToken and the other offered subprotocols.
+func splitProtocols(headers []string) (raw string, offers []string) {
+	var protocols []string
+	for _, h := range headers {
+		for _, p := range strings.Split(h, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	for i := 0; i < len(protocols); i++ {
+		if protocols[i] == SubprotocolToken && i+1 < len(protocols) {
+			raw = protocols[i+1]
+			i++
+			continue
+		}
+		offers = append(offers, protocols[i])
+	}
+	return raw, offers
+}
+
+type sessionContextKey struct{}
+
+// SessionFromContext returns the session Middleware authenticated.
+func SessionFromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(sessionContextKey{}).(*Session)
+	return s, ok
+}
+
+// Session the identity bound to a WebSocket connection. Done is closed when the token expires
+// without Refresh, the owner of the connection must close it then.
+type Session struct {
+	verifier token.Verifier
+
+	lock   sync.RWMutex
+	claims *token.Claims
+	timer  *time.Timer
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newSession(verifier token.Verifier, claims *token.Claims) *Session {
+	s := &Session{verifier: verifier, claims: claims, done: make(chan struct{})}
+	s.schedule(claims.ExpiresAt)
+	return s
+}
+
+// Claims returns the claims of the current token.
+func (s *Session) Claims() *token.Claims {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.claims
+}
+
+// Context returns a copy of parent carrying the session and its claims.
+func (s *Session) Context(parent context.Context) context.Context {
+	return context.WithValue(middleware.WithClaims(parent, s.Claims()), sessionContextKey{}, s)
+}
+
+// Refresh replaces the token of the connection by raw, e.g. sent by the client in a message before
+// the current one expires. The new token must belong to the same subject and tenant.
+func (s *Session) Refresh(raw string) error {
+	select {
+	case <-s.done:
+		return ErrSessionExpired
+	default:
+	}
+	claims, err := s.verifier.Verify(raw)
+	if err != nil {
+		return fmt.Errorf("verify refreshed token %w", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if claims.Subject != s.claims.Subject || claims.TenantID != s.claims.TenantID {
+		return ErrIdentityChanged
+	}
+	s.claims = claims
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.schedule(claims.ExpiresAt)
+	return nil
+}
+
+// Done is closed when the session expired or was closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns ErrSessionExpired once Done is closed.
+func (s *Session) Err() error {
+	select {
+	case <-s.done:
+		return ErrSessionExpired
+	default:
+		return nil
+	}
+}
+
+// Close ends the session and releases its timer.
+func (s *Session) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.once.Do(func() { close(s.done) })
+}
+
+// schedule closes done at expiresAt, tokens without exp never expire. Callers hold lock
+// or own the session exclusively.
+func (s *Session) schedule(expiresAt int64) {
+	s.timer = nil
+	if expiresAt == 0 {
+		return
+	}
+	s.timer = time.AfterFunc(time.Until(time.Unix(expiresAt, 0)), func() {
+		s.once.Do(func() { close(s.done) })
+	})
+}
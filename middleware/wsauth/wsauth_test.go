@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wsauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandshake(t *testing.T) {
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	alice, err := tokens.Issue(&token.Claims{Subject: "alice", TenantID: "t1"})
+	assert.NoError(t, err)
+
+	h := NewHandshake(tokens, Config{CookieName: "session"})
+	var offered string
+	handler := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := SessionFromContext(r.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "alice", session.Claims().Subject)
+		assert.Equal(t, "alice", middleware.SubjectFromContext(r.Context()))
+		offered = r.Header.Get("Sec-WebSocket-Protocol")
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	}))
+
+	tests := []struct {
+		name    string
+		prepare func(r *http.Request)
+		status  int
+		offered string
+	}{
+		{"subprotocol", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", "mqtt, access_token, "+alice) },
+			http.StatusSwitchingProtocols, "mqtt"},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "access_token=" + alice }, http.StatusSwitchingProtocols, ""},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "session", Value: alice}) },
+			http.StatusSwitchingProtocols, ""},
+		{"missing", func(r *http.Request) {}, http.StatusUnauthorized, ""},
+		{"invalid", func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", "access_token, nope") },
+			http.StatusUnauthorized, ""},
+		{"twice", func(r *http.Request) {
+			r.Header.Set("Sec-WebSocket-Protocol", "access_token, "+alice)
+			r.URL.RawQuery = "access_token=" + alice
+		}, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offered = ""
+			r := httptest.NewRequest(http.MethodGet, "/v1/streams", nil)
+			tt.prepare(r)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.offered, offered)
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	short, err := tokens.Issue(&token.Claims{Subject: "alice", TenantID: "t1", ExpiresAt: time.Now().Unix() + 1})
+	assert.NoError(t, err)
+	longer, err := tokens.Issue(&token.Claims{Subject: "alice", TenantID: "t1"})
+	assert.NoError(t, err)
+	bob, err := tokens.Issue(&token.Claims{Subject: "bob", TenantID: "t1"})
+	assert.NoError(t, err)
+
+	h := NewHandshake(tokens, Config{})
+	newSession := func(raw string) *Session {
+		r := httptest.NewRequest(http.MethodGet, "/v1/streams?access_token="+raw, nil)
+		s, _, err := h.Authenticate(r)
+		assert.NoError(t, err)
+		return s
+	}
+
+	refreshed := newSession(short)
+	assert.ErrorIs(t, refreshed.Refresh(bob), ErrIdentityChanged)
+	assert.NoError(t, refreshed.Refresh(longer))
+
+	expiring := newSession(short)
+	select {
+	case <-expiring.Done():
+		assert.ErrorIs(t, expiring.Err(), ErrSessionExpired)
+		assert.ErrorIs(t, expiring.Refresh(longer), ErrSessionExpired)
+	case <-time.After(3 * time.Second):
+		t.Fatal("session did not expire")
+	}
+	assert.NoError(t, refreshed.Err())
+	refreshed.Close()
+	assert.ErrorIs(t, refreshed.Err(), ErrSessionExpired)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey a machine credential, only the hash of its secret is stored.
+type APIKey struct {
+	ID         string     `json:"id" gorm:"primaryKey;type:varchar(32);comment:密钥ID"`
+	Name       string     `json:"name" gorm:"type:varchar(128);not null;default:''"`
+	TenantID   string     `json:"tenant_id" gorm:"type:varchar(32);not null;index;comment:租户ID"`
+	Owner      string     `json:"owner" gorm:"type:varchar(128);not null;index;comment:所属主体"`
+	Scopes     string     `json:"scopes" gorm:"type:varchar(1024);not null;default:''"`
+	Hash       string     `json:"-" gorm:"type:varchar(64);not null;comment:密钥哈希"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (APIKey) TableName() string {
+	return "sys_t_api_key"
+}
+
+// Get loads the key k.ID, found is false when there is none.
+func (k *APIKey) Get(db *gorm.DB) (found bool, err error) {
+	err = db.Where("id = ?", k.ID).First(k).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (k *APIKey) Create(db *gorm.DB) error {
+	return db.Create(k).Error
+}
+
+// Save updates all fields of k.
+func (k *APIKey) Save(db *gorm.DB) error {
+	return db.Save(k).Error
+}
+
+// TouchAPIKey sets the last use of the unrevoked key id, a revoked or deleted key is left as is.
+func TouchAPIKey(db *gorm.DB, id string, at time.Time) error {
+	return db.Model(&APIKey{}).Where("id = ? AND revoked_at IS NULL", id).UpdateColumn("last_used_at", at).Error
+}
+
+// ListAPIKeys returns the keys of tenantID, of owner unless it is empty.
+func ListAPIKeys(db *gorm.DB, tenantID, owner string) ([]*APIKey, error) {
+	keys := make([]*APIKey, 0)
+	db = db.Where("tenant_id = ?", tenantID)
+	if owner != "" {
+		db = db.Where("owner = ?", owner)
+	}
+	err := db.Order("created_at").Find(&keys).Error
+	return keys, err
+}
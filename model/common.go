@@ -49,3 +49,10 @@ func FormatPage(db *gorm.DB, page *Page) *gorm.DB {
 	}
 	return db
 }
+
+// TenantScope restricts a query to the rows of tenantID, e.g. db.Scopes(TenantScope(id)).Find(&users).
+func TenantScope(tenantID string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantID)
+	}
+}
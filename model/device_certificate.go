@@ -0,0 +1,67 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeviceCertificate a certificate the device CA issued.
+type DeviceCertificate struct {
+	Serial    string     `json:"serial" gorm:"primaryKey;type:varchar(40);comment:证书序列号"`
+	DeviceID  string     `json:"device_id" gorm:"type:varchar(128);not null;index;comment:设备ID"`
+	TenantID  string     `json:"tenant_id" gorm:"type:varchar(32);not null;comment:租户ID"`
+	NotAfter  time.Time  `json:"not_after"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (DeviceCertificate) TableName() string {
+	return "sys_t_device_certificate"
+}
+
+// Get loads the certificate c.Serial, found is false when there is none.
+func (c *DeviceCertificate) Get(db *gorm.DB) (found bool, err error) {
+	err = db.Where("serial = ?", c.Serial).First(c).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *DeviceCertificate) Create(db *gorm.DB) error {
+	return db.Create(c).Error
+}
+
+// RevokeDeviceCertificates revokes the unrevoked certificates matching the serial, or all of
+// deviceID when serial is empty.
+func RevokeDeviceCertificates(db *gorm.DB, deviceID, serial string) error {
+	db = db.Model(&DeviceCertificate{}).Where("revoked_at IS NULL")
+	if serial != "" {
+		db = db.Where("serial = ?", serial)
+	} else {
+		db = db.Where("device_id = ?", deviceID)
+	}
+	return db.Update("revoked_at", time.Now()).Error
+}
+
+// ListRevokedDeviceCertificates returns the revoked certificates not expired yet.
+func ListRevokedDeviceCertificates(db *gorm.DB) ([]*DeviceCertificate, error) {
+	certs := make([]*DeviceCertificate, 0)
+	err := db.Where("revoked_at IS NOT NULL AND not_after > ?", time.Now()).Find(&certs).Error
+	return certs, err
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EntityToken a long-lived token of a device or another entity, only its hash is stored.
+type EntityToken struct {
+	ID         string     `json:"id" gorm:"primaryKey;type:varchar(32);comment:令牌ID"`
+	EntityID   string     `json:"entity_id" gorm:"type:varchar(128);not null;index:entity_token_entity;comment:实体ID"`
+	EntityType string     `json:"entity_type" gorm:"type:varchar(64);not null;default:'';comment:实体类型"`
+	TenantID   string     `json:"tenant_id" gorm:"type:varchar(32);not null;index:entity_token_entity;comment:租户ID"`
+	Owner      string     `json:"owner" gorm:"type:varchar(128);not null;default:'';comment:所属用户"`
+	Scopes     string     `json:"scopes" gorm:"type:varchar(1024);not null;default:''"`
+	Hash       string     `json:"-" gorm:"type:varchar(64);not null;comment:令牌哈希"`
+	Disabled   bool       `json:"disabled" gorm:"not null;default:false"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+func (EntityToken) TableName() string {
+	return "sys_t_entity_token"
+}
+
+// Get loads the token t.ID, found is false when there is none.
+func (t *EntityToken) Get(db *gorm.DB) (found bool, err error) {
+	err = db.Where("id = ?", t.ID).First(t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Save updates all fields of t.
+func (t *EntityToken) Save(db *gorm.DB) error {
+	return db.Save(t).Error
+}
+
+func (t *EntityToken) Delete(db *gorm.DB) error {
+	return db.Delete(t).Error
+}
+
+// TouchEntityToken sets the last use of the enabled token id, a disabled or deleted token is left as is.
+func TouchEntityToken(db *gorm.DB, id string, at time.Time) error {
+	return db.Model(&EntityToken{}).Where("id = ? AND disabled = ?", id, false).UpdateColumn("last_used_at", at).Error
+}
+
+// CreateEntityTokens creates tokens in one transaction, none is created when one fails.
+func CreateEntityTokens(db *gorm.DB, tokens []*EntityToken) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&tokens).Error
+	})
+}
+
+// ListEntityTokens returns the tokens of tenantID, of entityID unless it is empty.
+func ListEntityTokens(db *gorm.DB, tenantID, entityID string) ([]*EntityToken, error) {
+	tokens := make([]*EntityToken, 0)
+	db = db.Where("tenant_id = ?", tenantID)
+	if entityID != "" {
+		db = db.Where("entity_id = ?", entityID)
+	}
+	err := db.Order("created_at").Find(&tokens).Error
+	return tokens, err
+}
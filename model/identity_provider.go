@@ -0,0 +1,60 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// IdentityProvider the identity provider configuration of a tenant.
+type IdentityProvider struct {
+	TenantID string `json:"tenant_id" gorm:"primaryKey;type:varchar(32);comment:租户ID"`
+	Type     string `json:"type" gorm:"type:varchar(64);not null;comment:身份提供者类型"`
+	// Options JSON options of the provider factory, secrets are best kept as secret references.
+	Options   string    `json:"-" gorm:"type:text;comment:配置"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (IdentityProvider) TableName() string {
+	return "sys_t_identity_provider"
+}
+
+// Get loads the provider of p.TenantID, found is false when there is none.
+func (p *IdentityProvider) Get(db *gorm.DB) (found bool, err error) {
+	err = db.Where("tenant_id = ?", p.TenantID).First(p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Save creates or updates all fields of p.
+func (p *IdentityProvider) Save(db *gorm.DB) error {
+	return db.Save(p).Error
+}
+
+func (p *IdentityProvider) Delete(db *gorm.DB) error {
+	return db.Delete(p).Error
+}
+
+// ListIdentityProviders returns the providers of all tenants.
+func ListIdentityProviders(db *gorm.DB) ([]*IdentityProvider, error) {
+	providers := make([]*IdentityProvider, 0)
+	err := db.Order("tenant_id").Find(&providers).Error
+	return providers, err
+}
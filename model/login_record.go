@@ -0,0 +1,62 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginRecord a login attempt of a subject.
+type LoginRecord struct {
+	ID        uint64    `json:"id" gorm:"primaryKey;autoIncrement"`
+	TenantID  string    `json:"tenant_id" gorm:"type:varchar(32);not null;default:'';index:login_record_subject;comment:租户ID"`
+	Subject   string    `json:"subject" gorm:"type:varchar(128);not null;index:login_record_subject;comment:登录主体"`
+	Provider  string    `json:"provider" gorm:"type:varchar(64);not null;default:''"`
+	IP        string    `json:"ip" gorm:"type:varchar(64);not null;default:''"`
+	UserAgent string    `json:"user_agent" gorm:"type:varchar(512);not null;default:''"`
+	Success   bool      `json:"success" gorm:"not null"`
+	Reason    string    `json:"reason" gorm:"type:varchar(255);not null;default:''"`
+	MFA       bool      `json:"mfa" gorm:"not null;default:false"`
+	CreatedAt time.Time `json:"created_at" gorm:"index;comment:登录时间"`
+}
+
+func (LoginRecord) TableName() string {
+	return "sys_t_login_record"
+}
+
+func (r *LoginRecord) Create(db *gorm.DB) error {
+	return db.Create(r).Error
+}
+
+// ListLoginRecords returns a page of the records of subject in tenantID newest first, with their total.
+func ListLoginRecords(db *gorm.DB, tenantID, subject string, page *Page) (total int64, records []*LoginRecord, err error) {
+	records = make([]*LoginRecord, 0)
+	db = db.Model(&LoginRecord{}).Where("tenant_id = ? and subject = ?", tenantID, subject)
+	if err = db.Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	if page != nil {
+		db = FormatPage(db, &Page{PageNum: page.PageNum, PageSize: page.PageSize})
+	}
+	err = db.Order("created_at desc, id desc").Find(&records).Error
+	return total, records, err
+}
+
+// PurgeLoginRecords deletes the records older than before.
+func PurgeLoginRecords(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("created_at < ?", before).Delete(&LoginRecord{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session a browser session stored server side, Data holds the encoded session.
+type Session struct {
+	ID        string    `json:"id" gorm:"primaryKey;type:varchar(64);comment:会话ID"`
+	TenantID  string    `json:"tenant_id" gorm:"type:varchar(32);not null;default:'';index;comment:租户ID"`
+	Subject   string    `json:"subject" gorm:"type:varchar(128);not null;default:'';index;comment:登录主体"`
+	Data      string    `json:"-" gorm:"type:text;not null"`
+	ExpireAt  time.Time `json:"expire_at" gorm:"index;comment:过期时间"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Session) TableName() string {
+	return "sys_t_session"
+}
+
+// Get loads the live session s.ID, found is false when there is none or it expired.
+func (s *Session) Get(db *gorm.DB) (found bool, err error) {
+	err = db.Where("id = ? and expire_at > ?", s.ID, time.Now()).First(s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Save creates or updates s.
+func (s *Session) Save(db *gorm.DB) error {
+	return db.Save(s).Error
+}
+
+// Update replaces the data and expiry of the live session s.ID, updated is false when there is
+// none or it expired.
+func (s *Session) Update(db *gorm.DB) (updated bool, err error) {
+	now := time.Now()
+	result := db.Model(&Session{}).Where("id = ? and expire_at > ?", s.ID, now).
+		Updates(map[string]interface{}{"data": s.Data, "expire_at": s.ExpireAt})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error == nil, result.Error
+	}
+	// mysql counts changed rows only, an update leaving the row as is affects none.
+	var n int64
+	err = db.Model(&Session{}).Where("id = ? and expire_at > ?", s.ID, now).Count(&n).Error
+	return n > 0, err
+}
+
+// DeleteSession deletes the session with id.
+func DeleteSession(db *gorm.DB, id string) error {
+	return db.Where("id = ?", id).Delete(&Session{}).Error
+}
+
+// DeleteSubjectSessions deletes all sessions of subject in tenantID.
+func DeleteSubjectSessions(db *gorm.DB, tenantID, subject string) error {
+	return db.Where("tenant_id = ? and subject = ?", tenantID, subject).Delete(&Session{}).Error
+}
+
+// DeleteTenantSessions deletes all sessions of tenantID.
+func DeleteTenantSessions(db *gorm.DB, tenantID string) error {
+	return db.Where("tenant_id = ?", tenantID).Delete(&Session{}).Error
+}
+
+// ListSessions returns the live logged in sessions of tenantID and subject oldest first,
+// empty filters match all.
+func ListSessions(db *gorm.DB, tenantID, subject string) ([]*Session, error) {
+	sessions := make([]*Session, 0)
+	db = db.Where("subject <> '' and expire_at > ?", time.Now())
+	if tenantID != "" {
+		db = db.Where("tenant_id = ?", tenantID)
+	}
+	if subject != "" {
+		db = db.Where("subject = ?", subject)
+	}
+	err := db.Order("created_at, id").Find(&sessions).Error
+	return sessions, err
+}
+
+// PurgeSessions deletes the sessions expired before.
+func PurgeSessions(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("expire_at <= ?", before).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
+
+// ScanSessions passes all live sessions to fn in batches of size.
+func ScanSessions(db *gorm.DB, size int, fn func([]*Session) error) error {
+	var batch []*Session
+	return db.Where("expire_at > ?", time.Now()).FindInBatches(&batch, size, func(_ *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SigningKeyset the sealed signing keys shared by the replicas, Version guards concurrent saves.
+type SigningKeyset struct {
+	Name      string `json:"name" gorm:"primaryKey;type:varchar(64)"`
+	Sealed    []byte `json:"-" gorm:"not null"`
+	Version   int64  `json:"version" gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func (SigningKeyset) TableName() string {
+	return "sys_t_signing_keyset"
+}
+
+// Get loads the keyset k.Name, found is false when it was never saved.
+func (k *SigningKeyset) Get(db *gorm.DB) (found bool, err error) {
+	err = db.Where("name = ?", k.Name).First(k).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CompareAndSwap stores k.Sealed at version+1 when the keyset is still at version,
+// swapped is false when another writer saved first.
+func (k *SigningKeyset) CompareAndSwap(db *gorm.DB, version int64) (swapped bool, err error) {
+	if version == 0 {
+		res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&SigningKeyset{Name: k.Name, Sealed: k.Sealed, Version: 1})
+		return res.RowsAffected == 1, res.Error
+	}
+	res := db.Model(&SigningKeyset{}).
+		Where("name = ? AND version = ?", k.Name, version).
+		Updates(map[string]interface{}{"sealed": k.Sealed, "version": version + 1, "updated_at": time.Now()})
+	return res.RowsAffected == 1, res.Error
+}
@@ -14,17 +14,20 @@ limitations under the License.
 package model
 
 import (
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type Tenant struct {
-	ID        string `json:"id" gorm:"primaryKey;type:varchar(32);comment:租户ID"`
-	Title     string `json:"title" gorm:"type:varchar(128);comment:租户标题; not null;index"`
-	Remark    string `json:"remark" gorm:"type:varchar(255);comment:备注"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID     string `json:"id" gorm:"primaryKey;type:varchar(32);comment:租户ID"`
+	Title  string `json:"title" gorm:"type:varchar(128);comment:租户标题; not null;index"`
+	Remark string `json:"remark" gorm:"type:varchar(255);comment:备注"`
+	// AuthConfig references the authentication config of the tenant, e.g. an identity provider.
+	AuthConfig string `json:"auth_config" gorm:"type:varchar(255);comment:认证配置"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 func (Tenant) TableName() string {
@@ -36,6 +39,14 @@ func (o *Tenant) Create(db *gorm.DB) (err error) {
 	return err
 }
 
+func (o *Tenant) Get(db *gorm.DB) (found bool, err error) {
+	err = db.Where("id = ?", o.ID).First(o).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (o *Tenant) Existed(db *gorm.DB) (existed bool) {
 	tenant := &Tenant{}
 	db.Where("title", o.Title).First(tenant)
@@ -65,3 +76,20 @@ func (o *Tenant) Update(db *gorm.DB, where map[string]interface{}, updates map[s
 	result := db.Table(o.TableName()).Where(where).Updates(updates)
 	return result.RowsAffected, result.Error
 }
+
+// ListTenants lists the tenants whose id, title or remark contains keywords.
+func ListTenants(db *gorm.DB, page *Page, keywords string) (total int64, tenants []*Tenant, err error) {
+	db = db.Model(&Tenant{})
+	if keywords != "" {
+		like := "%" + keywords + "%"
+		db = db.Where("id like ? or title like ? or remark like ?", like, like, like)
+	}
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	if page != nil {
+		db = FormatPage(db, page)
+	}
+	err = db.Find(&tenants).Error
+	return
+}
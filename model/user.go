@@ -18,9 +18,9 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/tkeel-io/security/authn/passwd"
 	"github.com/tkeel-io/security/utils"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -46,11 +46,11 @@ func (u *User) Encrypt() (err error) {
 		return
 	}
 
-	var hash []byte
-	if hash, err = bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost); err != nil {
+	var hash string
+	if hash, err = passwd.Hash(u.Password); err != nil {
 		return
 	}
-	u.Password = string(hash)
+	u.Password = hash
 
 	return
 }
@@ -167,10 +167,17 @@ func AuthenticateUser(db *gorm.DB, tenantID, username, password string) (*User,
 	if err != nil {
 		return nil, err
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	err = passwd.Verify(password, user.Password)
 	if err != nil {
 		return nil, fmt.Errorf("authenticate user password %w", err)
 	}
+	// upgrade hashes of weaker algorithms or parameters while the password is at hand, best
+	// effort: the login succeeds anyway and the next one retries.
+	if passwd.NeedsRehash(user.Password) {
+		if hash, err := passwd.Hash(password); err == nil {
+			_ = db.Model(user).Update("password", hash).Error
+		}
+	}
 	return user, nil
 }
 
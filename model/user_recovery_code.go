@@ -0,0 +1,65 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserRecoveryCode a single-use MFA recovery code of a user, only its hash is stored.
+type UserRecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    string     `json:"user_id" gorm:"type:varchar(32);not null;index;comment:用户ID"`
+	Hash      string     `json:"-" gorm:"type:varchar(64);not null;uniqueIndex;comment:恢复码哈希"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time
+}
+
+func (UserRecoveryCode) TableName() string {
+	return "sys_t_user_recovery_code"
+}
+
+// ReplaceUserRecoveryCodes replaces all codes of userID with the given hashes.
+func ReplaceUserRecoveryCodes(db *gorm.DB, userID string, hashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&UserRecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return nil
+		}
+		codes := make([]UserRecoveryCode, 0, len(hashes))
+		for _, hash := range hashes {
+			codes = append(codes, UserRecoveryCode{UserID: userID, Hash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// Use marks the unused code u.Hash of u.UserID used, used is false when there is none.
+func (u *UserRecoveryCode) Use(db *gorm.DB) (used bool, err error) {
+	res := db.Model(&UserRecoveryCode{}).
+		Where("user_id = ? AND hash = ? AND used_at IS NULL", u.UserID, u.Hash).
+		Update("used_at", time.Now())
+	return res.RowsAffected == 1, res.Error
+}
+
+// CountUnusedUserRecoveryCodes returns how many codes of userID are left.
+func CountUnusedUserRecoveryCodes(db *gorm.DB, userID string) (int64, error) {
+	var count int64
+	err := db.Model(&UserRecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserTOTP the TOTP second factor of a user, the secret is stored encrypted.
+type UserTOTP struct {
+	UserID    string `json:"user_id" gorm:"primaryKey;type:varchar(32);comment:用户ID"`
+	Secret    string `json:"-" gorm:"type:varchar(255);not null;comment:加密的密钥"`
+	Confirmed bool   `json:"confirmed" gorm:"not null;default:false"`
+	LastStep  int64  `json:"-" gorm:"not null;default:0"`
+	// Failures wrong codes in a row, LockedUntil set once they reach the limit.
+	Failures    int        `json:"-" gorm:"not null;default:0"`
+	LockedUntil *time.Time `json:"-"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (UserTOTP) TableName() string {
+	return "sys_t_user_totp"
+}
+
+// Get loads the factor of u.UserID, found is false when the user has none.
+func (u *UserTOTP) Get(db *gorm.DB) (found bool, err error) {
+	err = db.Where("user_id = ?", u.UserID).First(u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Save creates or replaces the factor of u.UserID.
+func (u *UserTOTP) Save(db *gorm.DB) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(u).Error
+}
+
+// Accept records step as the last accepted code of u.UserID and clears the failures, only when
+// step is later than the stored one and the factor is not locked at now. accepted is false
+// otherwise, e.g. when another replica accepted the same code first.
+func (u *UserTOTP) Accept(db *gorm.DB, step int64, now time.Time) (accepted bool, err error) {
+	result := db.Model(&UserTOTP{}).
+		Where("user_id = ? AND last_step < ? AND (locked_until IS NULL OR locked_until <= ?)", u.UserID, step, now).
+		Updates(map[string]interface{}{"last_step": step, "confirmed": true, "failures": 0, "locked_until": nil})
+	return result.RowsAffected == 1, result.Error
+}
+
+// Fail counts a wrong code of u.UserID in place, so concurrent failures all count, and locks
+// the factor until lockUntil once maxFailures are reached.
+func (u *UserTOTP) Fail(db *gorm.DB, maxFailures int, lockUntil time.Time) error {
+	err := db.Model(&UserTOTP{}).Where("user_id = ?", u.UserID).
+		UpdateColumn("failures", gorm.Expr("failures + 1")).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(&UserTOTP{}).Where("user_id = ? AND failures >= ?", u.UserID, maxFailures).
+		Updates(map[string]interface{}{"failures": 0, "locked_until": lockUntil}).Error
+}
+
+func (u *UserTOTP) Delete(db *gorm.DB) error {
+	return db.Where("user_id = ?", u.UserID).Delete(&UserTOTP{}).Error
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserWebAuthnCredential a passkey or security key registered by a user.
+type UserWebAuthnCredential struct {
+	// ID base64url credential id.
+	ID              string    `json:"id" gorm:"primaryKey;type:varchar(255)"`
+	UserID          string    `json:"user_id" gorm:"type:varchar(32);not null;index;comment:用户ID"`
+	Name            string    `json:"name" gorm:"type:varchar(128);comment:名称"`
+	PublicKey       []byte    `json:"-" gorm:"not null"`
+	AttestationType string    `json:"attestation_type" gorm:"type:varchar(32)"`
+	AAGUID          []byte    `json:"aaguid"`
+	SignCount       uint32    `json:"sign_count" gorm:"not null;default:0"`
+	LastUsedAt      time.Time `json:"last_used_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+func (UserWebAuthnCredential) TableName() string {
+	return "sys_t_user_webauthn"
+}
+
+func (c *UserWebAuthnCredential) ListByUser(db *gorm.DB, userID string) (credentials []*UserWebAuthnCredential, err error) {
+	err = db.Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error
+	return
+}
+
+// Save creates or replaces the credential c.ID.
+func (c *UserWebAuthnCredential) Save(db *gorm.DB) error {
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(c).Error
+}
+
+func (c *UserWebAuthnCredential) Delete(db *gorm.DB) error {
+	return db.Where("id = ? and user_id = ?", c.ID, c.UserID).Delete(&UserWebAuthnCredential{}).Error
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/utils"
+)
+
+var (
+	_ Channel = ChannelFunc(nil)
+	_ Channel = &WebhookChannel{}
+	_ Channel = &EmailChannel{}
+
+	// ErrURLRequired the webhook channel has no url.
+	ErrURLRequired = errors.New("notification webhook url required")
+	// ErrRecipientRequired the email channel has no server, sender or recipient.
+	ErrRecipientRequired = errors.New("notification email server, sender and recipient required")
+)
+
+// ChannelFunc adapts a function to a Channel.
+type ChannelFunc func(ctx context.Context, n *Notification) error
+
+func (f ChannelFunc) Notify(ctx context.Context, n *Notification) error {
+	return f(ctx, n)
+}
+
+// WebhookChannel posts notifications as JSON, signed like the audit webhooks when a secret is set.
+type WebhookChannel struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhookChannel returns a WebhookChannel, client defaults to utils.DefaultHTTPClient.
+func NewWebhookChannel(url, secret string, client *http.Client) (*WebhookChannel, error) {
+	if url == "" {
+		return nil, ErrURLRequired
+	}
+	if client == nil {
+		client = utils.DefaultHTTPClient
+	}
+	return &WebhookChannel{url: url, secret: secret, client: client}, nil
+}
+
+func (c *WebhookChannel) Notify(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("encode notification %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("notification request %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.secret != "" {
+		req.Header.Set(audit.SignatureHeader, audit.Sign(c.secret, body))
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post notification %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("post notification: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// EmailConfig of an EmailChannel.
+type EmailConfig struct {
+	// Addr host:port of the SMTP server.
+	Addr string `mapstructure:"addr" json:"addr" yaml:"addr"`
+	// Username and Password authenticate with PLAIN when set.
+	Username string   `mapstructure:"username" json:"username" yaml:"username"`
+	Password string   `mapstructure:"password" json:"password" yaml:"password"`
+	From     string   `mapstructure:"from" json:"from" yaml:"from"`
+	To       []string `mapstructure:"to" json:"to" yaml:"to"`
+}
+
+// EmailChannel mails notifications as plain text.
+type EmailChannel struct {
+	conf EmailConfig
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewEmailChannel returns a channel mailing conf.To through conf.Addr.
+func NewEmailChannel(conf EmailConfig) (*EmailChannel, error) {
+	if conf.Addr == "" || conf.From == "" || len(conf.To) == 0 {
+		return nil, ErrRecipientRequired
+	}
+	for _, addr := range append([]string{conf.From}, conf.To...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return nil, fmt.Errorf("invalid mail address %q", addr)
+		}
+	}
+	return &EmailChannel{conf: conf, send: smtp.SendMail}, nil
+}
+
+func (c *EmailChannel) Notify(_ context.Context, n *Notification) error {
+	var auth smtp.Auth
+	if c.conf.Username != "" {
+		host, _, err := net.SplitHostPort(c.conf.Addr)
+		if err != nil {
+			return fmt.Errorf("smtp addr %w", err)
+		}
+		auth = smtp.PlainAuth("", c.conf.Username, c.conf.Password, host)
+	}
+	msg := &strings.Builder{}
+	fmt.Fprintf(msg, "From: %s\r\n", c.conf.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(c.conf.To, ", "))
+	fmt.Fprintf(msg, "Subject: [security][%s] %s\r\n", n.Severity, n.Type)
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(msg, "%s\r\n\r\n", n.Message)
+	fmt.Fprintf(msg, "Time: %s\r\nSubject: %s\r\nTenant: %s\r\nIP: %s\r\n",
+		n.Time.UTC().Format("2006-01-02T15:04:05Z"), n.Subject, n.TenantID, n.IP)
+	if err := c.send(c.conf.Addr, auth, c.conf.From, c.conf.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("send notification mail %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify detects noteworthy security events in the audit event stream, like repeated
+// failed logins or an admin role granted, and publishes them to channels such as a webhook or
+// an email address, e.g. of a SOC. The Notifier is an audit.EventSink, fan the events out to it.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	_defaultFailedLoginThreshold = 5
+	_defaultFailedLoginWindow    = 15 * time.Minute
+	_defaultAdminRole            = "admin"
+	_defaultQueueSize            = 1000
+	_deliverTimeout              = 30 * time.Second
+)
+
+// Types of the notifications.
+const (
+	TypeRepeatedFailedLogins = "login.repeated_failures"
+	TypeNewDevice            = "login.new_device"
+	TypeNewCountry           = "login.new_country"
+	TypeMFADisabled          = "mfa.disabled"
+	TypeAdminRoleGranted     = "rbac.admin_granted"
+)
+
+// Severities of the notifications.
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+var _ audit.EventSink = &Notifier{}
+
+// Notification a noteworthy security event.
+type Notification struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Severity string    `json:"severity"`
+	Subject  string    `json:"subject"`
+	TenantID string    `json:"tenant_id"`
+	IP       string    `json:"ip,omitempty"`
+	Message  string    `json:"message"`
+	// Event the audit event the notification was detected in.
+	Event *audit.Event `json:"event"`
+}
+
+// Channel delivers notifications.
+type Channel interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// Locator returns the ISO country code of ip, empty when unknown.
+type Locator interface {
+	Country(ip net.IP) string
+}
+
+// History remembers the devices and countries subjects logged in from.
+type History interface {
+	// Remember records value of kind for subject and reports whether it is novel: unknown while
+	// subject already has other values of kind. The first value of a subject is not novel.
+	Remember(subject, kind, value string) (novel bool, err error)
+}
+
+type Config struct {
+	// FailedLoginThreshold failed logins of a subject within FailedLoginWindow notify. Default to 5.
+	FailedLoginThreshold int           `mapstructure:"failed_login_threshold" json:"failed_login_threshold" yaml:"failedLoginThreshold"`
+	FailedLoginWindow    time.Duration `mapstructure:"failed_login_window" json:"failed_login_window" yaml:"failedLoginWindow"`
+	// AdminRoles granting one of these roles notifies. Default to admin.
+	AdminRoles []string `mapstructure:"admin_roles" json:"admin_roles" yaml:"adminRoles"`
+	// QueueSize the most notifications waiting for delivery, more are dropped. Default to 1000.
+	QueueSize int `mapstructure:"queue_size" json:"queue_size" yaml:"queueSize"`
+	// Logger receives the entries of the notifier. Default to the global logger.
+	Logger log.Logger `mapstructure:"-" json:"-" yaml:"-"`
+}
+
+// Notifier detects notifications in audit events and delivers them to its channels from a
+// goroutine, so writing events never blocks.
+type Notifier struct {
+	conf     Config
+	channels []Channel
+	history  History
+	locator  Locator
+
+	lock      sync.Mutex
+	failures  map[string][]time.Time
+	lastSweep time.Time
+
+	queue  chan *Notification
+	done   chan struct{}
+	logger *log.Helper
+}
+
+// New starts a Notifier delivering to channels. history defaults to a MemoryHistory, without a
+// locator logins from new countries are not detected.
+func New(conf Config, history History, locator Locator, channels ...Channel) *Notifier {
+	if conf.FailedLoginThreshold <= 0 {
+		conf.FailedLoginThreshold = _defaultFailedLoginThreshold
+	}
+	if conf.FailedLoginWindow <= 0 {
+		conf.FailedLoginWindow = _defaultFailedLoginWindow
+	}
+	if len(conf.AdminRoles) == 0 {
+		conf.AdminRoles = []string{_defaultAdminRole}
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = _defaultQueueSize
+	}
+	if history == nil {
+		history = NewMemoryHistory()
+	}
+	n := &Notifier{
+		conf:      conf,
+		channels:  channels,
+		history:   history,
+		locator:   locator,
+		failures:  make(map[string][]time.Time),
+		lastSweep: time.Now(),
+		queue:     make(chan *Notification, conf.QueueSize),
+		done:      make(chan struct{}),
+		logger:    log.NewHelper(conf.Logger),
+	}
+	go n.run()
+	return n
+}
+
+func (n *Notifier) WriteEvent(e *audit.Event) {
+	for _, notification := range n.detect(e) {
+		select {
+		case n.queue <- notification:
+		default:
+			n.logger.Warnf("notification queue full, dropped %s of %s", notification.Type, notification.Subject)
+		}
+	}
+}
+
+// Close delivers the queued notifications and stops the Notifier, do not write events after.
+func (n *Notifier) Close() {
+	close(n.queue)
+	<-n.done
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for notification := range n.queue {
+		for _, c := range n.channels {
+			ctx, cancel := context.WithTimeout(context.Background(), _deliverTimeout)
+			if err := c.Notify(ctx, notification); err != nil {
+				n.logger.Errorf("deliver notification %s of %s: %s", notification.Type, notification.Subject, err)
+			}
+			cancel()
+		}
+	}
+}
+
+func (n *Notifier) detect(e *audit.Event) []*Notification {
+	switch e.Type {
+	case audit.EventLogin:
+		if e.Outcome == audit.OutcomeSuccess {
+			return n.detectNewClient(e)
+		}
+		if notification := n.detectFailures(e); notification != nil {
+			return []*Notification{notification}
+		}
+	case audit.EventMFADisabled:
+		return []*Notification{notify(e, TypeMFADisabled, SeverityHigh,
+			fmt.Sprintf("multi-factor authentication of %s disabled", e.Subject))}
+	case audit.EventRoleChanged:
+		if op, _ := e.Detail["op"].(string); op == "assign_role" && e.Outcome == audit.OutcomeSuccess &&
+			utils.StringsInclude(n.conf.AdminRoles, e.Target) {
+			subject, _ := e.Detail["subject"].(string)
+			notification := notify(e, TypeAdminRoleGranted, SeverityHigh,
+				fmt.Sprintf("role %s granted to %s in tenant %s", e.Target, subject, e.TenantID))
+			notification.Subject = subject
+			return []*Notification{notification}
+		}
+	}
+	return nil
+}
+
+// detectFailures counts the failed logins of the subject and notifies once the threshold is
+// reached within the window, the count starts over after.
+func (n *Notifier) detectFailures(e *audit.Event) *Notification {
+	if e.Subject == "" {
+		return nil
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	now := time.Now()
+	n.sweepFailures(now)
+	key := e.TenantID + "/" + e.Subject
+	times := append(n.failures[key], now)
+	for len(times) > 0 && now.Sub(times[0]) > n.conf.FailedLoginWindow {
+		times = times[1:]
+	}
+	if len(times) < n.conf.FailedLoginThreshold {
+		n.failures[key] = times
+		return nil
+	}
+	delete(n.failures, key)
+	return notify(e, TypeRepeatedFailedLogins, SeverityMedium,
+		fmt.Sprintf("%d failed logins of %s within %s", len(times), e.Subject, n.conf.FailedLoginWindow))
+}
+
+// sweepFailures drops the subjects without failures within the window, at most once per window.
+func (n *Notifier) sweepFailures(now time.Time) {
+	if now.Sub(n.lastSweep) < n.conf.FailedLoginWindow {
+		return
+	}
+	n.lastSweep = now
+	for key, times := range n.failures {
+		if now.Sub(times[len(times)-1]) > n.conf.FailedLoginWindow {
+			delete(n.failures, key)
+		}
+	}
+}
+
+func (n *Notifier) detectNewClient(e *audit.Event) []*Notification {
+	subject := e.TenantID + "/" + e.Subject
+	var notifications []*Notification
+	if e.UserAgent != "" {
+		novel, err := n.history.Remember(subject, "device", audit.Fingerprint(e.UserAgent))
+		if err != nil {
+			n.logger.Errorf("remember login device of %s: %s", subject, err)
+		} else if novel {
+			notifications = append(notifications, notify(e, TypeNewDevice, SeverityLow,
+				fmt.Sprintf("%s logged in from a new device: %s", e.Subject, e.UserAgent)))
+		}
+	}
+	if ip := net.ParseIP(e.IP); ip != nil && n.locator != nil {
+		if country := n.locator.Country(ip); country != "" {
+			novel, err := n.history.Remember(subject, "country", country)
+			if err != nil {
+				n.logger.Errorf("remember login country of %s: %s", subject, err)
+			} else if novel {
+				notifications = append(notifications, notify(e, TypeNewCountry, SeverityMedium,
+					fmt.Sprintf("%s logged in from a new country: %s", e.Subject, country)))
+			}
+		}
+	}
+	return notifications
+}
+
+func notify(e *audit.Event, typ, severity, message string) *Notification {
+	return &Notification{
+		Time:     e.Time,
+		Type:     typ,
+		Severity: severity,
+		Subject:  e.Subject,
+		TenantID: e.TenantID,
+		IP:       e.IP,
+		Message:  message,
+		Event:    e,
+	}
+}
+
+var _ History = &MemoryHistory{}
+
+// MemoryHistory in-process History, it forgets on restart and grows with the subjects.
+type MemoryHistory struct {
+	lock   sync.Mutex
+	values map[string]map[string]bool
+}
+
+func NewMemoryHistory() *MemoryHistory {
+	return &MemoryHistory{values: make(map[string]map[string]bool)}
+}
+
+func (h *MemoryHistory) Remember(subject, kind, value string) (bool, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	key := kind + "/" + subject
+	values, ok := h.values[key]
+	if !ok {
+		h.values[key] = map[string]bool{value: true}
+		return false, nil
+	}
+	if values[value] {
+		return false, nil
+	}
+	values[value] = true
+	return true, nil
+}
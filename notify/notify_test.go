@@ -0,0 +1,167 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authz/audit"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countryFunc func(ip net.IP) string
+
+func (f countryFunc) Country(ip net.IP) string { return f(ip) }
+
+type recorder struct {
+	lock          sync.Mutex
+	notifications []*Notification
+}
+
+func (r *recorder) Notify(_ context.Context, n *Notification) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+func (r *recorder) types() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	types := make([]string, 0, len(r.notifications))
+	for _, n := range r.notifications {
+		types = append(types, n.Type)
+	}
+	return types
+}
+
+func login(subject, ip, ua string, ok bool) *audit.Event {
+	e := &audit.Event{Time: time.Now(), Type: audit.EventLogin, Subject: subject, TenantID: "t1", IP: ip, UserAgent: ua, Outcome: audit.OutcomeSuccess}
+	if !ok {
+		e.Outcome = audit.OutcomeFailure
+	}
+	return e
+}
+
+func TestNotifier(t *testing.T) {
+	rec := &recorder{}
+	locator := countryFunc(func(ip net.IP) string {
+		if ip.Equal(net.ParseIP("203.0.113.9")) {
+			return "FR"
+		}
+		return "CN"
+	})
+	n := New(Config{FailedLoginThreshold: 3}, nil, locator, rec)
+
+	events := []*audit.Event{
+		// the first login sets the baseline.
+		login("alice", "10.0.0.1", "firefox", true),
+		login("alice", "10.0.0.2", "firefox", true),
+		login("alice", "203.0.113.9", "curl", true),
+		login("bob", "10.0.0.1", "firefox", false),
+		login("bob", "10.0.0.1", "firefox", false),
+		login("bob", "10.0.0.1", "firefox", false),
+		login("bob", "10.0.0.1", "firefox", false),
+		{Type: audit.EventMFADisabled, Subject: "alice"},
+		{Type: audit.EventRoleChanged, TenantID: "t1", Target: "viewer", Outcome: audit.OutcomeSuccess, Detail: map[string]interface{}{"op": "assign_role", "subject": "carol"}},
+		{Type: audit.EventRoleChanged, TenantID: "t1", Target: "admin", Outcome: audit.OutcomeFailure, Detail: map[string]interface{}{"op": "assign_role", "subject": "carol"}},
+		{Type: audit.EventRoleChanged, TenantID: "t1", Target: "admin", Outcome: audit.OutcomeSuccess, Detail: map[string]interface{}{"op": "assign_role", "subject": "carol"}},
+	}
+	for _, e := range events {
+		n.WriteEvent(e)
+	}
+	n.Close()
+
+	assert.Equal(t, []string{TypeNewDevice, TypeNewCountry, TypeRepeatedFailedLogins, TypeMFADisabled, TypeAdminRoleGranted}, rec.types())
+	assert.Equal(t, "carol", rec.notifications[4].Subject)
+	assert.Equal(t, SeverityHigh, rec.notifications[4].Severity)
+	assert.Equal(t, "bob", rec.notifications[2].Subject)
+}
+
+func TestFailureSweep(t *testing.T) {
+	n := New(Config{FailedLoginWindow: 10 * time.Millisecond}, nil, nil)
+	defer n.Close()
+	for i := 0; i < 100; i++ {
+		n.WriteEvent(login(fmt.Sprintf("usr-%d", i), "10.0.0.1", "firefox", false))
+	}
+	assert.Len(t, n.failures, 100, "no sweep within the window")
+	time.Sleep(20 * time.Millisecond)
+	n.WriteEvent(login("bob", "10.0.0.1", "firefox", false))
+	assert.Len(t, n.failures, 1)
+}
+
+func TestMemoryHistory(t *testing.T) {
+	h := NewMemoryHistory()
+	tests := []struct {
+		subject, kind, value string
+		novel                bool
+	}{
+		{"alice", "country", "CN", false},
+		{"alice", "country", "CN", false},
+		{"alice", "device", "d1", false},
+		{"alice", "country", "FR", true},
+		{"alice", "country", "FR", false},
+		{"bob", "country", "FR", false},
+	}
+	for _, tt := range tests {
+		novel, err := h.Remember(tt.subject, tt.kind, tt.value)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.novel, novel, "%+v", tt)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	notification := &Notification{Time: time.Now(), Type: TypeMFADisabled, Severity: SeverityHigh, Subject: "alice", Message: "mfa disabled"}
+
+	var received Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, audit.Sign("secret", body), r.Header.Get(audit.SignatureHeader))
+		assert.NoError(t, json.Unmarshal(body, &received))
+	}))
+	defer srv.Close()
+	_, err := NewWebhookChannel("", "", nil)
+	assert.ErrorIs(t, err, ErrURLRequired)
+	webhook, err := NewWebhookChannel(srv.URL, "secret", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, webhook.Notify(context.Background(), notification))
+	assert.Equal(t, "alice", received.Subject)
+
+	_, err = NewEmailChannel(EmailConfig{Addr: "smtp.example.com:587"})
+	assert.ErrorIs(t, err, ErrRecipientRequired)
+	email, err := NewEmailChannel(EmailConfig{Addr: "smtp.example.com:587", Username: "soc", Password: "pw", From: "security@example.com", To: []string{"soc@example.com"}})
+	assert.NoError(t, err)
+	var sent string
+	email.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		assert.Equal(t, "smtp.example.com:587", addr)
+		assert.NotNil(t, a)
+		assert.Equal(t, []string{"soc@example.com"}, to)
+		sent = string(msg)
+		return nil
+	}
+	assert.NoError(t, email.Notify(context.Background(), notification))
+	assert.Contains(t, sent, "Subject: [security][high] mfa.disabled\r\n")
+	assert.Contains(t, sent, "mfa disabled")
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/utils"
+)
+
+const _pkceMethodS256 = "S256"
+
+var _loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<input type="hidden" name="request_id" value="{{.RequestID}}">
+<label>Username <input name="username" autocomplete="username"></label>
+<label>Password <input name="password" type="password" autocomplete="current-password"></label>
+<button type="submit">Sign in</button>
+</form>
+</body></html>
+`))
+
+// HandleAuthorize the authorization endpoint, see https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.1
+func (s *Server) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, errInvalidRequest(err.Error()))
+		return
+	}
+	// the login form posts back the pending request.
+	if id := r.PostForm.Get("request_id"); id != "" {
+		req, err := s.states.ConsumeAuthorizeRequest(id)
+		if err != nil {
+			writeError(w, errInvalidRequest("authorization request expired"))
+			return
+		}
+		if req.SecondFactorPending {
+			s.verifySecondFactor(w, r, req)
+			return
+		}
+		s.authenticatePassword(w, r, req)
+		return
+	}
+
+	var (
+		req  *AuthorizeRequest
+		oerr *Error
+	)
+	if requestURI := r.Form.Get("request_uri"); requestURI != "" {
+		if req, oerr = s.loadPushedRequest(r.Form.Get("client_id"), requestURI); oerr != nil {
+			writeError(w, oerr)
+			return
+		}
+	} else {
+		if s.conf.RequirePushedAuthorizationRequests {
+			writeError(w, errInvalidRequest("pushed authorization request required"))
+			return
+		}
+		if oerr = s.resolveRequestObject(r); oerr != nil {
+			writeError(w, oerr)
+			return
+		}
+		if req, oerr = s.parseAuthorizeRequest(r); oerr != nil {
+			writeError(w, oerr)
+			return
+		}
+		if oerr = s.checkAuthorizeRequest(req); oerr != nil {
+			redirectError(w, r, req.RedirectURI, req.State, oerr)
+			return
+		}
+	}
+	if err := s.states.SaveAuthorizeRequest(req); err != nil {
+		redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+		return
+	}
+
+	provider, err := idprovider.GetIdentityProvider(req.Provider)
+	if err != nil {
+		redirectError(w, r, req.RedirectURI, req.State, errInvalidRequest("unknown identity provider"))
+		return
+	}
+	if utils.StringsInclude(s.conf.RedirectProviders, req.Provider) {
+		// the pending request id travels as the provider state.
+		http.Redirect(w, r, provider.AuthCodeURL(req.ID, req.Nonce), http.StatusFound)
+		return
+	}
+	s.renderLogin(w, req, "")
+}
+
+// HandleCallback completes the authorization after a federated identity provider redirected the end-user back.
+func (s *Server) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	req, err := s.states.ConsumeAuthorizeRequest(r.URL.Query().Get("state"))
+	if err != nil {
+		writeError(w, errInvalidRequest("authorization request expired"))
+		return
+	}
+	provider, err := idprovider.GetIdentityProvider(req.Provider)
+	if err != nil {
+		redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+		return
+	}
+	identity, err := idprovider.AuthenticateCode(r.Context(), provider, r.URL.Query().Get("code"))
+	if err != nil {
+		s.auditLogin(r, req.Provider, "", nil, err)
+		s.logger.Warnf("oauth authenticate code with %s: %s", req.Provider, err)
+		redirectError(w, r, req.RedirectURI, req.State, newError(http.StatusForbidden, ErrorAccessDenied, "authentication failed"))
+		return
+	}
+	s.authenticated(w, r, req, identity, false)
+}
+
+func (s *Server) authenticatePassword(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest) {
+	provider, err := idprovider.GetIdentityProvider(req.Provider)
+	if err != nil {
+		redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+		return
+	}
+	identity, err := idprovider.Authenticate(r.Context(), provider, r.PostForm.Get("username"), r.PostForm.Get("password"))
+	if err != nil {
+		s.auditLogin(r, req.Provider, r.PostForm.Get("username"), nil, err)
+		s.logger.Debugf("oauth authenticate password with %s: %s", req.Provider, err)
+		// a fresh pending request, the consumed one must not be replayed.
+		if req.ID, err = utils.RandBase64String(16); err == nil {
+			err = s.states.SaveAuthorizeRequest(req)
+		}
+		if err != nil {
+			redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+			return
+		}
+		s.renderLogin(w, req, "Invalid username or password.")
+		return
+	}
+	s.authenticated(w, r, req, identity, true)
+}
+
+// authenticated maps the end-user identity authenticated with the provider of req and
+// continues through the second factor when its login is risky or, for password logins,
+// when it is enrolled.
+func (s *Server) authenticated(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest, identity idprovider.Identity, password bool) {
+	claims, err := s.mapLogin(req.Provider, identity)
+	if err != nil {
+		s.auditLogin(r, req.Provider, identity.GetUsername(), nil, err)
+		redirectError(w, r, req.RedirectURI, req.State, newError(http.StatusForbidden, ErrorAccessDenied, err.Error()))
+		return
+	}
+	stepUp, err := s.assessLogin(r, req.Provider, claims)
+	required := false
+	if err == nil && (password || stepUp) {
+		required, err = s.requireSecondFactor(claims.Subject, stepUp)
+	}
+	if errors.Is(err, errRiskDenied) {
+		s.auditLogin(r, req.Provider, identity.GetUsername(), claims, err)
+		redirectError(w, r, req.RedirectURI, req.State, newError(http.StatusForbidden, ErrorAccessDenied, "authentication failed"))
+		return
+	}
+	if err != nil {
+		redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+		return
+	}
+	req.Claims = claims
+	req.AuthTime = time.Now()
+	// the login is recorded once the second factor is verified.
+	if required {
+		req.SecondFactorPending = true
+		if req.ID, err = utils.RandBase64String(16); err == nil {
+			err = s.states.SaveAuthorizeRequest(req)
+		}
+		if err != nil {
+			redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+			return
+		}
+		s.renderSecondFactor(w, req, "")
+		return
+	}
+	s.auditLogin(r, req.Provider, identity.GetUsername(), claims, nil)
+	s.continueAuthorize(w, r, req)
+}
+
+// continueAuthorize asks the authenticated end-user for consent when needed.
+func (s *Server) continueAuthorize(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest) {
+	needed, err := s.needsConsent(req)
+	if err != nil {
+		redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+		return
+	}
+	if needed {
+		if req.ID, err = utils.RandBase64String(16); err == nil {
+			err = s.states.SaveAuthorizeRequest(req)
+		}
+		if err != nil {
+			redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+			return
+		}
+		s.renderConsent(w, req)
+		return
+	}
+	s.issueCode(w, r, req)
+}
+
+// issueCode redirects the end-user back to the client with the authorization code.
+func (s *Server) issueCode(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest) {
+	code, signature, err := newSecret()
+	if err != nil {
+		redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+		return
+	}
+	err = s.storage.SaveAuthorizationCode(&AuthorizationCode{
+		Signature: signature,
+		Request:   req,
+		Claims:    req.Claims,
+		ExpiresAt: time.Now().Add(s.conf.AuthorizationCodeTTL),
+	})
+	if err != nil {
+		redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+		return
+	}
+	u, _ := url.Parse(req.RedirectURI)
+	q := u.Query()
+	q.Set("code", code)
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// checkAuthorizeRequest validates the request against the client registration, errors are returned to the client.
+func (s *Server) checkAuthorizeRequest(req *AuthorizeRequest) *Error {
+	if req.ResponseType != "code" {
+		return newError(http.StatusBadRequest, ErrorUnsupportedResponseType, "only the code response type is supported")
+	}
+	client, err := s.clients.GetClient(req.ClientID)
+	if err != nil {
+		return errServer(err)
+	}
+	if !client.AllowsGrantType(GrantTypeAuthorizationCode) {
+		return newError(http.StatusBadRequest, ErrorUnauthorizedClient, "client may not use the authorization code grant")
+	}
+	if !client.AllowsScope(req.Scope) {
+		return newError(http.StatusBadRequest, ErrorInvalidScope, "")
+	}
+	if req.CodeChallengeMethod != "" && req.CodeChallengeMethod != _pkceMethodS256 {
+		return errInvalidRequest("code_challenge_method must be S256")
+	}
+	if client.Public() && req.CodeChallenge == "" {
+		return errInvalidRequest("public clients must use PKCE")
+	}
+	return nil
+}
+
+// parseAuthorizeRequest validates the client and redirect uri, errors are not redirected.
+func (s *Server) parseAuthorizeRequest(r *http.Request) (*AuthorizeRequest, *Error) {
+	client, err := s.clients.GetClient(r.Form.Get("client_id"))
+	if err != nil {
+		return nil, errInvalidRequest("unknown client_id")
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	requested := redirectURI != ""
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !client.AllowsRedirectURI(redirectURI) {
+		return nil, errInvalidRequest("redirect_uri not registered")
+	}
+	id, err := utils.RandBase64String(16)
+	if err != nil {
+		return nil, errServer(err)
+	}
+	provider := r.Form.Get("provider")
+	if provider == "" {
+		provider = s.conf.DefaultProvider
+	}
+	return &AuthorizeRequest{
+		ID:                   id,
+		ClientID:             client.ID,
+		ResponseType:         r.Form.Get("response_type"),
+		RedirectURI:          redirectURI,
+		RedirectURIRequested: requested,
+		Scope:                r.Form.Get("scope"),
+		State:                r.Form.Get("state"),
+		Nonce:                r.Form.Get("nonce"),
+		CodeChallenge:        r.Form.Get("code_challenge"),
+		CodeChallengeMethod:  r.Form.Get("code_challenge_method"),
+		Provider:             provider,
+		ExpiresAt:            time.Now().Add(_defaultAuthorizeRequestTTL),
+	}, nil
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, req *AuthorizeRequest, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	err := _loginTemplate.Execute(w, map[string]string{
+		"Action":    AuthorizePath,
+		"RequestID": req.ID,
+		"Error":     message,
+	})
+	if err != nil {
+		s.logger.Errorf("oauth render login %s", err)
+	}
+}
+
+// verifyCodeChallenge checks the PKCE verifier, see https://datatracker.ietf.org/doc/html/rfc7636#section-4.6
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if challenge == "" {
+		return verifier == ""
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
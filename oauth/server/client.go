@@ -0,0 +1,283 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/passwd"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/utils"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// GrantTypeAuthorizationCode the authorization code grant.
+	GrantTypeAuthorizationCode = "authorization_code"
+	// GrantTypeRefreshToken the refresh token grant.
+	GrantTypeRefreshToken = "refresh_token"
+)
+
+var (
+	_ ClientStore = &MemoryClientStore{}
+
+	// ErrClientNotFound no client with the id.
+	ErrClientNotFound = errors.New("oauth client not found")
+	// ErrClientExists a client with the id is already registered.
+	ErrClientExists = errors.New("oauth client already exists")
+)
+
+// ClientSecret a secret of a confidential client, only its salted hash is stored.
+type ClientSecret struct {
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt zero for secrets without expiry, rotation sets it on the replaced secrets.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the secret is no longer accepted at t.
+func (cs ClientSecret) Expired(t time.Time) bool {
+	return !cs.ExpiresAt.IsZero() && !t.Before(cs.ExpiresAt)
+}
+
+// HashClientSecret returns the hash a secret is stored under, hashed like passwords since
+// secrets set by operators may be guessable.
+func HashClientSecret(secret string) (string, error) {
+	hash, err := passwd.Hash(secret)
+	if err != nil {
+		return "", fmt.Errorf("error hash client secret: %w", err)
+	}
+	return hash, nil
+}
+
+// matches reports whether secret hashes to cs, hex SHA-256 hashes stored by earlier
+// releases are still accepted.
+func (cs ClientSecret) matches(secret string) bool {
+	if !strings.HasPrefix(cs.Hash, "$") {
+		return subtle.ConstantTimeCompare([]byte(cs.Hash), []byte(token.HashToken(secret))) == 1
+	}
+	return passwd.Verify(secret, cs.Hash) == nil
+}
+
+// Client an application allowed to request tokens.
+type Client struct {
+	ID string `json:"client_id"`
+	// Secrets empty for public clients, which must use PKCE. More than one secret
+	// is valid while a rotation is in progress.
+	Secrets      []ClientSecret `json:"secrets,omitempty"`
+	RedirectURIs []string       `json:"redirect_uris"`
+	GrantTypes   []string       `json:"grant_types"`
+	Scopes       []string       `json:"scopes"`
+	// Trusted first party clients skip the consent step.
+	Trusted bool `json:"trusted"`
+	// JWKS public keys the client signs request objects with.
+	JWKS *jose.JSONWebKeySet `json:"jwks,omitempty"`
+}
+
+// Public reports whether the client can not keep a secret.
+func (c *Client) Public() bool {
+	return len(c.Secrets) == 0
+}
+
+// SetSecret replaces the secrets of the client with secret.
+func (c *Client) SetSecret(secret string) error {
+	hash, err := HashClientSecret(secret)
+	if err != nil {
+		return err
+	}
+	c.Secrets = []ClientSecret{{Hash: hash, CreatedAt: time.Now()}}
+	return nil
+}
+
+// RotateSecret adds a new random secret and returns it, the current secrets
+// stay valid for grace so deployments can roll over. Expired secrets are dropped.
+func (c *Client) RotateSecret(grace time.Duration) (string, error) {
+	secret, err := utils.RandBase64String(32)
+	if err != nil {
+		return "", fmt.Errorf("error generate client secret: %w", err)
+	}
+	hash, err := HashClientSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	deadline := now.Add(grace)
+	secrets := make([]ClientSecret, 0, len(c.Secrets)+1)
+	for _, cs := range c.Secrets {
+		if cs.Expired(now) {
+			continue
+		}
+		if cs.ExpiresAt.IsZero() || cs.ExpiresAt.After(deadline) {
+			cs.ExpiresAt = deadline
+		}
+		secrets = append(secrets, cs)
+	}
+	c.Secrets = append(secrets, ClientSecret{Hash: hash, CreatedAt: now})
+	return secret, nil
+}
+
+// CheckSecret reports whether secret matches one of the unexpired secrets.
+func (c *Client) CheckSecret(secret string) bool {
+	now := time.Now()
+	for _, cs := range c.Secrets {
+		if !cs.Expired(now) && cs.matches(secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a deep copy of the client.
+func (c *Client) Clone() *Client {
+	clone := *c
+	clone.Secrets = append([]ClientSecret(nil), c.Secrets...)
+	clone.RedirectURIs = append([]string(nil), c.RedirectURIs...)
+	clone.GrantTypes = append([]string(nil), c.GrantTypes...)
+	clone.Scopes = append([]string(nil), c.Scopes...)
+	if c.JWKS != nil {
+		clone.JWKS = &jose.JSONWebKeySet{Keys: append([]jose.JSONWebKey(nil), c.JWKS.Keys...)}
+	}
+	return &clone
+}
+
+// AllowsRedirectURI reports whether uri is registered, redirect uris match exactly.
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	return utils.StringsInclude(c.RedirectURIs, uri)
+}
+
+// AllowsGrantType reports whether the client may use the grant.
+func (c *Client) AllowsGrantType(grantType string) bool {
+	return utils.StringsInclude(c.GrantTypes, grantType)
+}
+
+// AllowsScope reports whether every space separated scope was registered for the client.
+func (c *Client) AllowsScope(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !utils.StringsInclude(c.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// ClientStore manages registered clients, used by the server endpoints and admin tooling.
+// Clients returned must not be modified in place, update a Clone instead.
+type ClientStore interface {
+	// GetClient returns the client or ErrClientNotFound.
+	GetClient(id string) (*Client, error)
+	// ListClients returns all clients ordered by id.
+	ListClients() ([]*Client, error)
+	// CreateClient adds a new client or returns ErrClientExists.
+	CreateClient(client *Client) error
+	// UpdateClient replaces an existing client or returns ErrClientNotFound.
+	UpdateClient(client *Client) error
+	// DeleteClient removes the client or returns ErrClientNotFound.
+	DeleteClient(id string) error
+}
+
+// RotateClientSecret rotates the secret of the stored client and returns the new secret,
+// the previous secrets stay valid for grace.
+func RotateClientSecret(store ClientStore, id string, grace time.Duration) (string, error) {
+	client, err := store.GetClient(id)
+	if err != nil {
+		return "", err
+	}
+	client = client.Clone()
+	secret, err := client.RotateSecret(grace)
+	if err != nil {
+		return "", err
+	}
+	if err = store.UpdateClient(client); err != nil {
+		return "", fmt.Errorf("error update client %s: %w", id, err)
+	}
+	return secret, nil
+}
+
+// MemoryClientStore in-process ClientStore.
+type MemoryClientStore struct {
+	lock    sync.RWMutex
+	clients map[string]*Client
+}
+
+func NewMemoryClientStore(clients ...*Client) *MemoryClientStore {
+	s := &MemoryClientStore{clients: make(map[string]*Client)}
+	for _, c := range clients {
+		s.clients[c.ID] = c
+	}
+	return s
+}
+
+func (s *MemoryClientStore) GetClient(id string) (*Client, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if c, ok := s.clients[id]; ok {
+		return c, nil
+	}
+	return nil, ErrClientNotFound
+}
+
+func (s *MemoryClientStore) ListClients() ([]*Client, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients, nil
+}
+
+func (s *MemoryClientStore) CreateClient(c *Client) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.clients[c.ID]; ok {
+		return ErrClientExists
+	}
+	s.clients[c.ID] = c
+	return nil
+}
+
+func (s *MemoryClientStore) UpdateClient(c *Client) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.clients[c.ID]; !ok {
+		return ErrClientNotFound
+	}
+	s.clients[c.ID] = c
+	return nil
+}
+
+func (s *MemoryClientStore) DeleteClient(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.clients[id]; !ok {
+		return ErrClientNotFound
+	}
+	delete(s.clients, id)
+	return nil
+}
+
+// SetClient adds or replaces a client.
+func (s *MemoryClientStore) SetClient(c *Client) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clients[c.ID] = c
+}
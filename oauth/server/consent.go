@@ -0,0 +1,251 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	// ConsentPath the consent form posts the end-user decision here.
+	ConsentPath = "/oauth/consent"
+	// ConsentsPath lists (GET) and revokes (DELETE ?client_id=) the consents of the bearer.
+	ConsentsPath = "/oauth/consents"
+
+	// ScopeConsents the scope of access tokens managing the consents of the end-user, tokens
+	// of trusted first party clients do without it.
+	ScopeConsents = "consents"
+)
+
+var _ ConsentStore = &MemoryConsentStore{}
+
+var _consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Authorize {{.ClientID}}</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+<p>{{.ClientID}} requests access to:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<input type="hidden" name="request_id" value="{{.RequestID}}">
+<label><input type="checkbox" name="remember" value="true" checked> Remember this decision</label>
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body></html>
+`))
+
+// Consent the scopes a subject granted to a client.
+type Consent struct {
+	Subject   string    `json:"subject"`
+	ClientID  string    `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// Covers reports whether every space separated scope was granted.
+func (c *Consent) Covers(scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !utils.StringsInclude(c.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// ConsentStore remembers consent decisions per subject and client.
+type ConsentStore interface {
+	// GetConsent returns the consent or ErrNotFound.
+	GetConsent(subject, clientID string) (*Consent, error)
+	// SaveConsent adds or replaces the consent of subject for the client.
+	SaveConsent(consent *Consent) error
+	// ListConsents returns all consents of subject.
+	ListConsents(subject string) ([]*Consent, error)
+	// RevokeConsent deletes the consent, revoking a missing consent is not an error.
+	RevokeConsent(subject, clientID string) error
+}
+
+// MemoryConsentStore in-process ConsentStore.
+type MemoryConsentStore struct {
+	lock     sync.RWMutex
+	consents map[string]map[string]*Consent
+}
+
+func NewMemoryConsentStore() *MemoryConsentStore {
+	return &MemoryConsentStore{consents: make(map[string]map[string]*Consent)}
+}
+
+func (s *MemoryConsentStore) GetConsent(subject, clientID string) (*Consent, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if c, ok := s.consents[subject][clientID]; ok {
+		return c, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryConsentStore) SaveConsent(consent *Consent) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.consents[consent.Subject] == nil {
+		s.consents[consent.Subject] = make(map[string]*Consent)
+	}
+	s.consents[consent.Subject][consent.ClientID] = consent
+	return nil
+}
+
+func (s *MemoryConsentStore) ListConsents(subject string) ([]*Consent, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	consents := make([]*Consent, 0, len(s.consents[subject]))
+	for _, c := range s.consents[subject] {
+		consents = append(consents, c)
+	}
+	return consents, nil
+}
+
+func (s *MemoryConsentStore) RevokeConsent(subject, clientID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.consents[subject], clientID)
+	return nil
+}
+
+// SetConsentStore enables the consent step, without a store every authorization is implicitly consented.
+func (s *Server) SetConsentStore(store ConsentStore) {
+	s.consents = store
+}
+
+// needsConsent reports whether the end-user has to approve the request.
+func (s *Server) needsConsent(req *AuthorizeRequest) (bool, error) {
+	if s.consents == nil {
+		return false, nil
+	}
+	client, err := s.clients.GetClient(req.ClientID)
+	if err != nil {
+		return false, err
+	}
+	if client.Trusted {
+		return false, nil
+	}
+	consent, err := s.consents.GetConsent(req.Claims.Subject, req.ClientID)
+	if errors.Is(err, ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !consent.Covers(req.Scope), nil
+}
+
+func (s *Server) renderConsent(w http.ResponseWriter, req *AuthorizeRequest) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	err := _consentTemplate.Execute(w, map[string]interface{}{
+		"Action":    ConsentPath,
+		"RequestID": req.ID,
+		"ClientID":  req.ClientID,
+		"Scopes":    strings.Fields(req.Scope),
+	})
+	if err != nil {
+		s.logger.Errorf("oauth render consent %s", err)
+	}
+}
+
+// HandleConsent records the end-user decision and completes or denies the authorization.
+func (s *Server) HandleConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, errInvalidRequest("consent decisions must use POST"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, errInvalidRequest(err.Error()))
+		return
+	}
+	req, err := s.states.ConsumeAuthorizeRequest(r.PostForm.Get("request_id"))
+	if err != nil || req.Claims == nil || req.SecondFactorPending {
+		writeError(w, errInvalidRequest("authorization request expired"))
+		return
+	}
+	if r.PostForm.Get("decision") != "allow" {
+		redirectError(w, r, req.RedirectURI, req.State, newError(http.StatusForbidden, ErrorAccessDenied, "the end-user denied the request"))
+		return
+	}
+	if r.PostForm.Get("remember") == "true" {
+		scopes := strings.Fields(req.Scope)
+		if previous, err := s.consents.GetConsent(req.Claims.Subject, req.ClientID); err == nil {
+			scopes = utils.StringsUniqueAppend(previous.Scopes, scopes...)
+		}
+		err = s.consents.SaveConsent(&Consent{
+			Subject:   req.Claims.Subject,
+			ClientID:  req.ClientID,
+			Scopes:    scopes,
+			GrantedAt: time.Now(),
+		})
+		if err != nil {
+			redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+			return
+		}
+	}
+	s.issueCode(w, r, req)
+}
+
+// HandleConsents lets the bearer of an access token of a trusted client, or carrying the
+// consents scope, list and revoke its consents. Third party clients must not see or revoke
+// the grants of others.
+func (s *Server) HandleConsents(w http.ResponseWriter, r *http.Request) {
+	if s.consents == nil {
+		writeError(w, newError(http.StatusNotFound, ErrorInvalidRequest, "consent is disabled"))
+		return
+	}
+	claims, ok := s.authenticateBearer(w, r)
+	if !ok {
+		return
+	}
+	if !utils.StringsInclude(strings.Fields(claims.Scope), ScopeConsents) {
+		client, err := s.clients.GetClient(claims.Audience)
+		if err != nil || !client.Trusted {
+			insufficientScope(w, ScopeConsents)
+			return
+		}
+	}
+	switch r.Method {
+	case http.MethodGet:
+		consents, err := s.consents.ListConsents(claims.Subject)
+		if err != nil {
+			writeError(w, errServer(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, consents)
+	case http.MethodDelete:
+		clientID := r.URL.Query().Get("client_id")
+		if clientID == "" {
+			writeError(w, errInvalidRequest("client_id required"))
+			return
+		}
+		if err := s.consents.RevokeConsent(claims.Subject, clientID); err != nil {
+			writeError(w, errServer(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,431 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token"
+)
+
+const (
+	// DeviceAuthorizationPath device authorization endpoint, see https://datatracker.ietf.org/doc/html/rfc8628
+	DeviceAuthorizationPath = "/oauth/device_authorization"
+	// DeviceVerificationPath the end-user enters the user code here.
+	DeviceVerificationPath = "/oauth/device"
+
+	// GrantTypeDeviceCode the device authorization grant.
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// Device grant error codes, see https://datatracker.ietf.org/doc/html/rfc8628#section-3.5
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorExpiredToken         = "expired_token"
+
+	_defaultDeviceCodeTTL  = 10 * time.Minute
+	_defaultDeviceInterval = 5 * time.Second
+	_slowDownIncrement     = 5 * time.Second
+
+	// _userCodeCharset consonants only, user codes can not spell words and survive case mistakes.
+	_userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	_userCodeLength  = 8
+)
+
+// DeviceStatus the end-user decision on a device authorization.
+type DeviceStatus string
+
+const (
+	DeviceStatusPending  DeviceStatus = "pending"
+	DeviceStatusApproved DeviceStatus = "approved"
+	DeviceStatusDenied   DeviceStatus = "denied"
+)
+
+var (
+	_ DeviceStore = &MemoryDeviceStore{}
+
+	_deviceTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+<label>Code <input name="user_code" value="{{.UserCode}}" autocomplete="off"></label>
+<label>Username <input name="username" autocomplete="username"></label>
+<label>Password <input name="password" type="password" autocomplete="current-password"></label>
+{{if .SecondFactor}}<label>Verification code <input name="otp" inputmode="numeric" autocomplete="one-time-code"></label>{{end}}
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body></html>
+`))
+)
+
+// DeviceAuthorization a pending device grant, stored under the hash of the device code.
+type DeviceAuthorization struct {
+	Signature string       `json:"signature"`
+	UserCode  string       `json:"user_code"`
+	ClientID  string       `json:"client_id"`
+	Scope     string       `json:"scope"`
+	Status    DeviceStatus `json:"status"`
+	// Claims of the end-user who approved the device.
+	Claims       *token.Claims `json:"claims,omitempty"`
+	Interval     time.Duration `json:"interval"`
+	LastPolledAt time.Time     `json:"last_polled_at"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+}
+
+// DeviceStore persists device authorizations.
+type DeviceStore interface {
+	// SaveDeviceAuthorization adds or replaces the authorization.
+	SaveDeviceAuthorization(auth *DeviceAuthorization) error
+	// GetDeviceAuthorization returns the authorization or ErrNotFound.
+	GetDeviceAuthorization(signature string) (*DeviceAuthorization, error)
+	// GetDeviceAuthorizationByUserCode returns the authorization or ErrNotFound.
+	GetDeviceAuthorizationByUserCode(userCode string) (*DeviceAuthorization, error)
+	// PollDeviceAuthorization atomically records a poll of the device at now and returns the
+	// authorization as polled, or ErrNotFound. A decided authorization is deleted so it is
+	// answered once, a pending one polled within its interval of the previous poll gets the
+	// interval raised by the slow down increment and tooFast set.
+	PollDeviceAuthorization(signature string, now time.Time) (auth *DeviceAuthorization, tooFast bool, err error)
+	DeleteDeviceAuthorization(signature string) error
+}
+
+// MemoryDeviceStore in-process DeviceStore, it hands out and keeps copies so callers never share
+// an authorization.
+type MemoryDeviceStore struct {
+	lock       sync.RWMutex
+	auths      map[string]*DeviceAuthorization
+	byUserCode map[string]string
+}
+
+func NewMemoryDeviceStore() *MemoryDeviceStore {
+	return &MemoryDeviceStore{auths: make(map[string]*DeviceAuthorization), byUserCode: make(map[string]string)}
+}
+
+func (s *MemoryDeviceStore) SaveDeviceAuthorization(auth *DeviceAuthorization) error {
+	stored := *auth
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.auths[auth.Signature] = &stored
+	s.byUserCode[auth.UserCode] = auth.Signature
+	return nil
+}
+
+func (s *MemoryDeviceStore) GetDeviceAuthorization(signature string) (*DeviceAuthorization, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if auth, ok := s.auths[signature]; ok {
+		copied := *auth
+		return &copied, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryDeviceStore) GetDeviceAuthorizationByUserCode(userCode string) (*DeviceAuthorization, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if auth, ok := s.auths[s.byUserCode[userCode]]; ok {
+		copied := *auth
+		return &copied, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryDeviceStore) PollDeviceAuthorization(signature string, now time.Time) (*DeviceAuthorization, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	auth, ok := s.auths[signature]
+	if !ok {
+		return nil, false, ErrNotFound
+	}
+	if auth.Status != DeviceStatusPending {
+		delete(s.byUserCode, auth.UserCode)
+		delete(s.auths, signature)
+		return auth, false, nil
+	}
+	tooFast := now.Sub(auth.LastPolledAt) < auth.Interval
+	if tooFast {
+		auth.Interval += _slowDownIncrement
+	}
+	auth.LastPolledAt = now
+	copied := *auth
+	return &copied, tooFast, nil
+}
+
+func (s *MemoryDeviceStore) DeleteDeviceAuthorization(signature string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if auth, ok := s.auths[signature]; ok {
+		delete(s.byUserCode, auth.UserCode)
+		delete(s.auths, signature)
+	}
+	return nil
+}
+
+// DeviceConfig of the device authorization grant.
+type DeviceConfig struct {
+	// CodeTTL lifetime of device and user codes. Default to 10m.
+	CodeTTL time.Duration `mapstructure:"code_ttl" json:"code_ttl" yaml:"codeTTL"`
+	// Interval minimum time between polls of a device. Default to 5s.
+	Interval time.Duration `mapstructure:"interval" json:"interval" yaml:"interval"`
+}
+
+// DeviceAuthorizationResponse see https://datatracker.ietf.org/doc/html/rfc8628#section-3.2
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// EnableDeviceGrant turns on the device authorization grant, end-users approve devices at
+// DeviceVerificationPath with the username password form of the default provider.
+func (s *Server) EnableDeviceGrant(conf DeviceConfig, store DeviceStore) {
+	if conf.CodeTTL <= 0 {
+		conf.CodeTTL = _defaultDeviceCodeTTL
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = _defaultDeviceInterval
+	}
+	s.device = &conf
+	s.devices = store
+}
+
+// HandleDeviceAuthorization issues the device and user codes to a device.
+func (s *Server) HandleDeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if s.device == nil {
+		writeError(w, newError(http.StatusNotFound, ErrorInvalidRequest, "device grant is disabled"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, errInvalidRequest("device authorization requests must use POST"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, errInvalidRequest(err.Error()))
+		return
+	}
+	client, oerr := s.authenticateClient(r)
+	if oerr != nil {
+		writeError(w, oerr)
+		return
+	}
+	if !client.AllowsGrantType(GrantTypeDeviceCode) {
+		writeError(w, newError(http.StatusBadRequest, ErrorUnauthorizedClient, "client may not use the device grant"))
+		return
+	}
+	scope := r.PostForm.Get("scope")
+	if !client.AllowsScope(scope) {
+		writeError(w, newError(http.StatusBadRequest, ErrorInvalidScope, ""))
+		return
+	}
+
+	deviceCode, signature, err := newSecret()
+	if err != nil {
+		writeError(w, errServer(err))
+		return
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		writeError(w, errServer(err))
+		return
+	}
+	auth := &DeviceAuthorization{
+		Signature: signature,
+		UserCode:  userCode,
+		ClientID:  client.ID,
+		Scope:     scope,
+		Status:    DeviceStatusPending,
+		Interval:  s.device.Interval,
+		ExpiresAt: time.Now().Add(s.device.CodeTTL),
+	}
+	if err = s.devices.SaveDeviceAuthorization(auth); err != nil {
+		writeError(w, errServer(err))
+		return
+	}
+	verificationURI := s.conf.Issuer + DeviceVerificationPath
+	writeJSON(w, http.StatusOK, &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                formatUserCode(userCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {formatUserCode(userCode)}}.Encode(),
+		ExpiresIn:               int64(s.device.CodeTTL / time.Second),
+		Interval:                int64(s.device.Interval / time.Second),
+	})
+}
+
+// HandleDeviceVerification lets the end-user sign in and approve or deny the device showing the user code.
+func (s *Server) HandleDeviceVerification(w http.ResponseWriter, r *http.Request) {
+	if s.device == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.renderDeviceVerification(w, r.URL.Query().Get("user_code"), "")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, errInvalidRequest(err.Error()))
+		return
+	}
+	userCode := r.PostForm.Get("user_code")
+	auth, err := s.devices.GetDeviceAuthorizationByUserCode(normalizeUserCode(userCode))
+	if err != nil || auth.Status != DeviceStatusPending || time.Now().After(auth.ExpiresAt) {
+		s.renderDeviceVerification(w, "", "Unknown or expired code.")
+		return
+	}
+	provider, err := idprovider.GetIdentityProvider(s.conf.DefaultProvider)
+	if err != nil {
+		writeError(w, errServer(err))
+		return
+	}
+	identity, err := idprovider.Authenticate(r.Context(), provider, r.PostForm.Get("username"), r.PostForm.Get("password"))
+	if err != nil {
+		s.auditLogin(r, s.conf.DefaultProvider, r.PostForm.Get("username"), nil, err)
+		s.logger.Debugf("oauth device authenticate with %s: %s", s.conf.DefaultProvider, err)
+		s.renderDeviceVerification(w, userCode, "Invalid username or password.")
+		return
+	}
+	auth.Status = DeviceStatusDenied
+	if r.PostForm.Get("decision") == "allow" {
+		auth.Claims, err = s.mapLogin(s.conf.DefaultProvider, identity)
+		stepUp := false
+		if err == nil {
+			stepUp, err = s.assessLogin(r, s.conf.DefaultProvider, auth.Claims)
+		}
+		if err != nil {
+			s.auditLogin(r, s.conf.DefaultProvider, identity.GetUsername(), auth.Claims, err)
+			s.renderDeviceVerification(w, "", "Access denied.")
+			return
+		}
+		required, err := s.verifyDeviceSecondFactor(auth.Claims.Subject, r.PostForm.Get("otp"), stepUp)
+		if required {
+			s.auditSecondFactor(r, s.conf.DefaultProvider, auth.Claims, err)
+		} else {
+			s.auditLogin(r, s.conf.DefaultProvider, identity.GetUsername(), auth.Claims, err)
+		}
+		if errors.Is(err, errRiskDenied) {
+			s.renderDeviceVerification(w, "", "Access denied.")
+			return
+		}
+		if err != nil {
+			s.logger.Debugf("oauth device verify second factor of %s: %s", auth.Claims.Subject, err)
+			s.renderDeviceVerification(w, userCode, "Invalid verification code.")
+			return
+		}
+		auth.Status = DeviceStatusApproved
+	}
+	if err = s.devices.SaveDeviceAuthorization(auth); err != nil {
+		writeError(w, errServer(err))
+		return
+	}
+	if auth.Status == DeviceStatusApproved {
+		s.renderDeviceVerification(w, "", "Device connected, you may return to your device.")
+		return
+	}
+	s.renderDeviceVerification(w, "", "Device denied.")
+}
+
+// exchangeDeviceCode answers a device poll, see https://datatracker.ietf.org/doc/html/rfc8628#section-3.4
+func (s *Server) exchangeDeviceCode(r *http.Request, client *Client, jkt string) (*TokenResponse, *Error) {
+	if s.device == nil {
+		return nil, newError(http.StatusBadRequest, ErrorUnsupportedGrantType, GrantTypeDeviceCode)
+	}
+	signature := token.HashToken(r.PostForm.Get("device_code"))
+	auth, err := s.devices.GetDeviceAuthorization(signature)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errInvalidGrant("invalid device code")
+	}
+	if err != nil {
+		return nil, errServer(err)
+	}
+	if auth.ClientID != client.ID {
+		return nil, errInvalidGrant("device code was issued to another client")
+	}
+	now := time.Now()
+	if now.After(auth.ExpiresAt) {
+		_ = s.devices.DeleteDeviceAuthorization(signature)
+		return nil, newError(http.StatusBadRequest, ErrorExpiredToken, "")
+	}
+
+	// the poll is recorded and a decision consumed atomically, concurrent polls can neither
+	// dodge the interval nor redeem an approval twice.
+	auth, tooFast, err := s.devices.PollDeviceAuthorization(signature, now)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errInvalidGrant("invalid device code")
+	}
+	if err != nil {
+		return nil, errServer(err)
+	}
+	switch auth.Status {
+	case DeviceStatusApproved:
+		return s.issue(client, auth.Claims, auth.Scope, jkt)
+	case DeviceStatusDenied:
+		return nil, newError(http.StatusBadRequest, ErrorAccessDenied, "")
+	}
+	if tooFast {
+		return nil, newError(http.StatusBadRequest, ErrorSlowDown, fmt.Sprintf("poll at most every %s", auth.Interval))
+	}
+	return nil, newError(http.StatusBadRequest, ErrorAuthorizationPending, "")
+}
+
+func (s *Server) renderDeviceVerification(w http.ResponseWriter, userCode, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	err := _deviceTemplate.Execute(w, map[string]interface{}{
+		"Action":       DeviceVerificationPath,
+		"UserCode":     userCode,
+		"Message":      message,
+		"SecondFactor": s.secondFactor != nil,
+	})
+	if err != nil {
+		s.logger.Errorf("oauth render device verification %s", err)
+	}
+}
+
+// newUserCode returns a random user code of _userCodeCharset.
+func newUserCode() (string, error) {
+	code := make([]byte, _userCodeLength)
+	max := big.NewInt(int64(len(_userCodeCharset)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("generate user code %w", err)
+		}
+		code[i] = _userCodeCharset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// formatUserCode splits the code in two halves for readability, e.g. BDFG-HJKL.
+func formatUserCode(code string) string {
+	return code[:len(code)/2] + "-" + code[len(code)/2:]
+}
+
+// normalizeUserCode drops separators and case differences of the entered code.
+func normalizeUserCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// Error codes, see https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+	ErrorServerError             = "server_error"
+)
+
+// Error an OAuth2 error response.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	status      int
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func newError(status int, code, description string) *Error {
+	return &Error{Code: code, Description: description, status: status}
+}
+
+func errInvalidRequest(description string) *Error {
+	return newError(http.StatusBadRequest, ErrorInvalidRequest, description)
+}
+
+func errInvalidClient(description string) *Error {
+	return newError(http.StatusUnauthorized, ErrorInvalidClient, description)
+}
+
+func errInvalidGrant(description string) *Error {
+	return newError(http.StatusBadRequest, ErrorInvalidGrant, description)
+}
+
+func errServer(err error) *Error {
+	return newError(http.StatusInternalServerError, ErrorServerError, err.Error())
+}
+
+// writeJSON writes a no-store JSON response as the token endpoints require.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, e *Error) {
+	if e.status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	writeJSON(w, e.status, e)
+}
+
+// redirectError returns the error to the client through its redirect uri.
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI, state string, e *Error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		writeError(w, errInvalidRequest("invalid redirect_uri"))
+		return
+	}
+	q := u.Query()
+	q.Set("error", e.Code)
+	if e.Description != "" {
+		q.Set("error_description", e.Description)
+	}
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Request object error codes, see https://datatracker.ietf.org/doc/html/rfc9101#section-7
+const (
+	ErrorInvalidRequestObject   = "invalid_request_object"
+	ErrorRequestNotSupported    = "request_not_supported"
+	ErrorRequestURINotSupported = "request_uri_not_supported"
+)
+
+// _requestObjectAlgs asymmetric algorithms request objects may be signed with.
+var _requestObjectAlgs = []string{"RS256", "PS256", "ES256", "EdDSA"}
+
+// resolveRequestObject replaces the authorization parameters of r with those of the signed
+// request object in the request parameter, see https://datatracker.ietf.org/doc/html/rfc9101
+func (s *Server) resolveRequestObject(r *http.Request) *Error {
+	object := r.Form.Get("request")
+	if object == "" {
+		if requestURI := r.Form.Get("request_uri"); requestURI != "" && !strings.HasPrefix(requestURI, _requestURIPrefix) {
+			return newError(http.StatusBadRequest, ErrorRequestURINotSupported, "only pushed request uris are supported")
+		}
+		if s.conf.RequireSignedRequestObject {
+			return newError(http.StatusBadRequest, ErrorInvalidRequest, "signed request object required")
+		}
+		return nil
+	}
+	clientID := r.Form.Get("client_id")
+	client, err := s.clients.GetClient(clientID)
+	if err != nil {
+		return errInvalidRequest("unknown client_id")
+	}
+	if client.JWKS == nil || len(client.JWKS.Keys) == 0 {
+		return newError(http.StatusBadRequest, ErrorRequestNotSupported, "client has no registered keys")
+	}
+
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: _requestObjectAlgs}
+	_, err = parser.ParseWithClaims(object, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" && len(client.JWKS.Keys) == 1 {
+			kid = client.JWKS.Keys[0].KeyID
+		}
+		keys := client.JWKS.Key(kid)
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if keys[0].Algorithm != "" && keys[0].Algorithm != t.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return keys[0].Key, nil
+	})
+	if err != nil {
+		return newError(http.StatusBadRequest, ErrorInvalidRequestObject, err.Error())
+	}
+	if _, ok := claims["exp"]; !ok {
+		return newError(http.StatusBadRequest, ErrorInvalidRequestObject, "exp required")
+	}
+	if !claims.VerifyIssuer(client.ID, true) || !claims.VerifyAudience(s.conf.Issuer, true) {
+		return newError(http.StatusBadRequest, ErrorInvalidRequestObject, "iss must be the client and aud the issuer")
+	}
+	if id, ok := claims["client_id"]; ok && id != client.ID {
+		return newError(http.StatusBadRequest, ErrorInvalidRequestObject, "client_id mismatch")
+	}
+
+	// only the parameters inside the request object are used.
+	form := url.Values{"client_id": {client.ID}}
+	for k, v := range claims {
+		switch k {
+		case "iss", "aud", "exp", "iat", "nbf", "jti", "client_id", "request", "request_uri":
+			continue
+		}
+		switch value := v.(type) {
+		case string:
+			form.Set(k, value)
+		case float64:
+			form.Set(k, strconv.FormatFloat(value, 'f', -1, 64))
+		case bool:
+			form.Set(k, strconv.FormatBool(value))
+		}
+	}
+	r.Form = form
+	return nil
+}
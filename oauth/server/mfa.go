@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+// errSecondFactorRequired the device verification form lacks the code of an enrolled end-user.
+var errSecondFactorRequired = errors.New("second factor required")
+
+var _secondFactorTemplate = template.Must(template.New("second_factor").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Verification code</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<input type="hidden" name="request_id" value="{{.RequestID}}">
+<label>Verification code <input name="otp" inputmode="numeric" autocomplete="one-time-code"></label>
+<button type="submit">Verify</button>
+</form>
+</body></html>
+`))
+
+// SecondFactor verifies a second factor of end-users after their password, e.g. a *totp.Manager.
+type SecondFactor interface {
+	// Enrolled reports whether subject must present the factor.
+	Enrolled(subject string) (bool, error)
+	// Verify checks the code subject presented.
+	Verify(subject, code string) error
+}
+
+// SetSecondFactor requires enrolled end-users signing in with the username password form to
+// present a code of factor as well.
+func (s *Server) SetSecondFactor(factor SecondFactor) {
+	s.secondFactor = factor
+}
+
+// requireSecondFactor reports whether subject must present the second factor, a login stepping
+// up fails with errRiskDenied when subject has none to present.
+func (s *Server) requireSecondFactor(subject string, stepUp bool) (bool, error) {
+	enrolled := false
+	if s.secondFactor != nil {
+		var err error
+		if enrolled, err = s.secondFactor.Enrolled(subject); err != nil {
+			return false, err
+		}
+	}
+	if stepUp && !enrolled {
+		return false, errRiskDenied
+	}
+	return enrolled, nil
+}
+
+// verifySecondFactor completes the authorization of a request waiting for the second factor.
+func (s *Server) verifySecondFactor(w http.ResponseWriter, r *http.Request, req *AuthorizeRequest) {
+	err := s.secondFactor.Verify(req.Claims.Subject, r.PostForm.Get("otp"))
+	s.auditSecondFactor(r, req.Provider, req.Claims, err)
+	if err != nil {
+		s.logger.Debugf("oauth verify second factor of %s: %s", req.Claims.Subject, err)
+		// a fresh pending request, the consumed one must not be replayed.
+		if req.ID, err = utils.RandBase64String(16); err == nil {
+			err = s.states.SaveAuthorizeRequest(req)
+		}
+		if err != nil {
+			redirectError(w, r, req.RedirectURI, req.State, errServer(err))
+			return
+		}
+		s.renderSecondFactor(w, req, "Invalid verification code.")
+		return
+	}
+	req.SecondFactorPending = false
+	s.continueAuthorize(w, r, req)
+}
+
+func (s *Server) renderSecondFactor(w http.ResponseWriter, req *AuthorizeRequest, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	err := _secondFactorTemplate.Execute(w, map[string]string{
+		"Action":    AuthorizePath,
+		"RequestID": req.ID,
+		"Error":     message,
+	})
+	if err != nil {
+		s.logger.Errorf("oauth render second factor %s", err)
+	}
+}
+
+// verifyDeviceSecondFactor checks the code of the device verification form when subject is
+// enrolled, required reports whether it was.
+func (s *Server) verifyDeviceSecondFactor(subject, code string, stepUp bool) (required bool, err error) {
+	required, err = s.requireSecondFactor(subject, stepUp)
+	if err != nil || !required {
+		return required, err
+	}
+	if code == "" {
+		return true, errSecondFactorRequired
+	}
+	return true, s.secondFactor.Verify(subject, code)
+}
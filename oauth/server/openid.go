@@ -0,0 +1,222 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authn/token/keyset"
+	"github.com/tkeel-io/security/model"
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/golang-jwt/jwt"
+	"gorm.io/gorm"
+)
+
+const (
+	// DiscoveryPath OpenID provider metadata, see https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfig
+	DiscoveryPath = "/.well-known/openid-configuration"
+	// UserInfoPath userinfo endpoint.
+	UserInfoPath = "/oauth/userinfo"
+
+	// ScopeOpenID requests an ID token.
+	ScopeOpenID = "openid"
+
+	_defaultIDTokenTTL = time.Hour
+)
+
+// _scopeClaims the userinfo claims released per scope, sub is always released.
+var _scopeClaims = map[string][]string{
+	"profile": {"name", "preferred_username", "picture", "tenant_id", "updated_at"},
+	"email":   {"email"},
+}
+
+// UserInfoLoader returns the standard claims of the subject of an access token.
+type UserInfoLoader func(claims *token.Claims) (map[string]interface{}, error)
+
+// NewUserInfoLoader returns a UserInfoLoader reading the users of db.
+func NewUserInfoLoader(db *gorm.DB) UserInfoLoader {
+	return func(claims *token.Claims) (map[string]interface{}, error) {
+		user := &model.User{}
+		err := db.Model(user).Where("id = ? and tenant_id = ?", claims.Subject, claims.TenantID).First(user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error load user %s: %w", claims.Subject, err)
+		}
+		return map[string]interface{}{
+			"name":               user.NickName,
+			"preferred_username": user.UserName,
+			"picture":            user.Avatar,
+			"email":              user.Email,
+			"tenant_id":          user.TenantID,
+			"updated_at":         user.UpdatedAt.Unix(),
+		}, nil
+	}
+}
+
+// OpenIDConfig of the OpenID provider mode.
+type OpenIDConfig struct {
+	// IDTokenTTL lifetime of ID tokens. Default to 1h.
+	IDTokenTTL time.Duration `mapstructure:"id_token_ttl" json:"id_token_ttl" yaml:"idTokenTTL"`
+}
+
+// EnableOpenID turns the server into an OpenID provider: ID tokens signed with the active key
+// of keys are issued for the openid scope, userinfo may be nil to answer from the access token claims.
+func (s *Server) EnableOpenID(conf OpenIDConfig, keys *keyset.Manager, userinfo UserInfoLoader) {
+	if conf.IDTokenTTL <= 0 {
+		conf.IDTokenTTL = _defaultIDTokenTTL
+	}
+	s.openid = &conf
+	s.keys = keys
+	s.userinfo = userinfo
+}
+
+// issueIDToken returns the ID token of an authorization code exchange, see https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+func (s *Server) issueIDToken(client *Client, req *AuthorizeRequest, claims *token.Claims) (string, error) {
+	now := time.Now()
+	idClaims := jwt.MapClaims{
+		"iss":       s.conf.Issuer,
+		"sub":       claims.Subject,
+		"aud":       client.ID,
+		"azp":       client.ID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(s.openid.IDTokenTTL).Unix(),
+		"auth_time": req.AuthTime.Unix(),
+	}
+	if req.Nonce != "" {
+		idClaims["nonce"] = req.Nonce
+	}
+	if claims.TenantID != "" {
+		idClaims["tenant_id"] = claims.TenantID
+	}
+	if claims.Username != "" {
+		idClaims["preferred_username"] = claims.Username
+	}
+	key := s.keys.SigningKey()
+	t := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), idClaims)
+	t.Header["kid"] = key.ID
+	signed, err := t.SignedString(key.Signer)
+	if err != nil {
+		return "", fmt.Errorf("sign id token %w", err)
+	}
+	return signed, nil
+}
+
+// HandleDiscovery serves the provider metadata.
+func (s *Server) HandleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if s.openid == nil {
+		http.NotFound(w, r)
+		return
+	}
+	metadata := map[string]interface{}{
+		"issuer":                                s.conf.Issuer,
+		"authorization_endpoint":                s.conf.Issuer + AuthorizePath,
+		"token_endpoint":                        s.conf.Issuer + TokenPath,
+		"userinfo_endpoint":                     s.conf.Issuer + UserInfoPath,
+		"revocation_endpoint":                   s.conf.Issuer + RevokePath,
+		"jwks_uri":                              s.conf.Issuer + keyset.JWKSPath,
+		"scopes_supported":                      []string{ScopeOpenID, "profile", "email"},
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{s.keys.SigningKey().Algorithm},
+		"token_endpoint_auth_methods_supported": []string{_tokenEndpointAuthClientSecretBasic, _tokenEndpointAuthClientSecretPost, _tokenEndpointAuthNone},
+		"code_challenge_methods_supported":      []string{_pkceMethodS256},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "preferred_username", "picture", "email", "tenant_id"},
+	}
+	metadata["pushed_authorization_request_endpoint"] = s.conf.Issuer + PARPath
+	metadata["require_pushed_authorization_requests"] = s.conf.RequirePushedAuthorizationRequests
+	grantTypes := []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken}
+	if s.device != nil {
+		metadata["device_authorization_endpoint"] = s.conf.Issuer + DeviceAuthorizationPath
+		grantTypes = append(grantTypes, GrantTypeDeviceCode)
+	}
+	if s.uma != nil {
+		metadata["resource_registration_endpoint"] = s.conf.Issuer + UMAResourceSetPath
+		metadata["permission_endpoint"] = s.conf.Issuer + UMAPermissionPath
+		grantTypes = append(grantTypes, GrantTypeUMATicket)
+	}
+	metadata["grant_types_supported"] = grantTypes
+	if s.dpop != nil {
+		metadata["dpop_signing_alg_values_supported"] = s.dpop.Algorithms()
+	}
+	metadata["request_parameter_supported"] = true
+	metadata["request_object_signing_alg_values_supported"] = _requestObjectAlgs
+	metadata["require_signed_request_object"] = s.conf.RequireSignedRequestObject
+	if s.registration != nil {
+		metadata["registration_endpoint"] = s.conf.Issuer + RegisterPath
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_ = json.NewEncoder(w).Encode(metadata)
+}
+
+// HandleJWKS serves the public keys ID tokens are signed with.
+func (s *Server) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	if s.keys == nil {
+		http.NotFound(w, r)
+		return
+	}
+	keyset.JWKSHandler(s.keys).ServeHTTP(w, r)
+}
+
+// HandleUserInfo returns the claims about the bearer of an access token granted the openid scope.
+func (s *Server) HandleUserInfo(w http.ResponseWriter, r *http.Request) {
+	if s.openid == nil {
+		http.NotFound(w, r)
+		return
+	}
+	claims, ok := s.authenticateBearer(w, r)
+	if !ok {
+		return
+	}
+	granted := strings.Fields(claims.Scope)
+	if !utils.StringsInclude(granted, ScopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		writeJSON(w, http.StatusForbidden, newError(http.StatusForbidden, "insufficient_scope", "the openid scope is required"))
+		return
+	}
+	info := map[string]interface{}{
+		"preferred_username": claims.Username,
+		"tenant_id":          claims.TenantID,
+	}
+	if s.userinfo != nil {
+		var err error
+		if info, err = s.userinfo(claims); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeJSON(w, http.StatusUnauthorized, newError(http.StatusUnauthorized, "invalid_token", "unknown subject"))
+				return
+			}
+			writeError(w, errServer(err))
+			return
+		}
+	}
+	released := map[string]interface{}{"sub": claims.Subject}
+	for _, scope := range granted {
+		for _, name := range _scopeClaims[scope] {
+			if v, ok := info[name]; ok && v != "" {
+				released[name] = v
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, released)
+}
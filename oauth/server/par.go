@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	// PARPath pushed authorization request endpoint, see https://datatracker.ietf.org/doc/html/rfc9126
+	PARPath = "/oauth/par"
+
+	_requestURIPrefix        = "urn:ietf:params:oauth:request_uri:"
+	_defaultPushedRequestTTL = time.Minute
+)
+
+// PushedAuthorizationResponse successful pushed authorization request response.
+type PushedAuthorizationResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+// HandlePushedAuthorization validates the authorization request of an authenticated client
+// and returns the request_uri referencing it at the authorization endpoint.
+func (s *Server) HandlePushedAuthorization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, errInvalidRequest("pushed authorization requests must use POST"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, errInvalidRequest(err.Error()))
+		return
+	}
+	client, oerr := s.authenticateClient(r)
+	if oerr != nil {
+		writeError(w, oerr)
+		return
+	}
+	if r.PostForm.Get("request_uri") != "" {
+		writeError(w, errInvalidRequest("request_uri is not allowed in a pushed request"))
+		return
+	}
+	if clientID := r.PostForm.Get("client_id"); clientID != "" && clientID != client.ID {
+		writeError(w, errInvalidRequest("client_id does not match the authenticated client"))
+		return
+	}
+	r.Form.Set("client_id", client.ID)
+	if oerr = s.resolveRequestObject(r); oerr != nil {
+		writeError(w, oerr)
+		return
+	}
+	req, oerr := s.parseAuthorizeRequest(r)
+	if oerr == nil {
+		oerr = s.checkAuthorizeRequest(req)
+	}
+	if oerr != nil {
+		writeError(w, oerr)
+		return
+	}
+	req.ID = _requestURIPrefix + req.ID
+	req.ExpiresAt = time.Now().Add(_defaultPushedRequestTTL)
+	if err := s.states.SaveAuthorizeRequest(req); err != nil {
+		writeError(w, errServer(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, &PushedAuthorizationResponse{
+		RequestURI: req.ID,
+		ExpiresIn:  int64(_defaultPushedRequestTTL / time.Second),
+	})
+}
+
+// loadPushedRequest consumes the pushed request referenced by requestURI, request uris are single use.
+func (s *Server) loadPushedRequest(clientID, requestURI string) (*AuthorizeRequest, *Error) {
+	if !strings.HasPrefix(requestURI, _requestURIPrefix) {
+		return nil, errInvalidRequest("invalid request_uri")
+	}
+	req, err := s.states.ConsumeAuthorizeRequest(requestURI)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errInvalidRequest("request_uri expired")
+	}
+	if err != nil {
+		return nil, errServer(err)
+	}
+	if req.ClientID != clientID {
+		return nil, errInvalidRequest("request_uri was pushed by another client")
+	}
+	// the request continues as a regular pending request.
+	if req.ID, err = utils.RandBase64String(16); err != nil {
+		return nil, errServer(err)
+	}
+	req.ExpiresAt = time.Now().Add(_defaultAuthorizeRequestTTL)
+	return req, nil
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/mitchellh/mapstructure"
+)
+
+// RegisterPath dynamic client registration endpoint, see https://datatracker.ietf.org/doc/html/rfc7591
+const RegisterPath = "/oauth/register"
+
+// Registration error codes, see https://datatracker.ietf.org/doc/html/rfc7591#section-3.2.2
+const (
+	ErrorInvalidRedirectURI          = "invalid_redirect_uri"
+	ErrorInvalidClientMetadata       = "invalid_client_metadata"
+	ErrorInvalidSoftwareStatement    = "invalid_software_statement"
+	ErrorUnapprovedSoftwareStatement = "unapproved_software_statement"
+)
+
+const (
+	_tokenEndpointAuthNone              = "none"
+	_tokenEndpointAuthClientSecretBasic = "client_secret_basic"
+	_tokenEndpointAuthClientSecretPost  = "client_secret_post"
+)
+
+// SoftwareStatementVerifier verifies a software statement and returns its claims,
+// which take precedence over the plain request metadata.
+type SoftwareStatementVerifier func(statement string) (map[string]interface{}, error)
+
+// NewSoftwareStatementVerifier accepts statements of issuer signed with key using one of algs.
+func NewSoftwareStatementVerifier(issuer string, key interface{}, algs ...string) SoftwareStatementVerifier {
+	parser := &jwt.Parser{ValidMethods: algs}
+	return func(statement string) (map[string]interface{}, error) {
+		claims := jwt.MapClaims{}
+		if _, err := parser.ParseWithClaims(statement, claims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		}); err != nil {
+			return nil, err
+		}
+		if !claims.VerifyIssuer(issuer, true) {
+			return nil, fmt.Errorf("software statement issuer %v not approved", claims["iss"])
+		}
+		return claims, nil
+	}
+}
+
+// RegistrationConfig of the dynamic client registration endpoint.
+type RegistrationConfig struct {
+	// InitialAccessTokens bearer tokens authorizing registrations.
+	InitialAccessTokens []string `mapstructure:"initial_access_tokens" json:"-" yaml:"initialAccessTokens"`
+	// AllowOpenRegistration accepts registrations without an initial access token.
+	AllowOpenRegistration bool `mapstructure:"allow_open_registration" json:"allow_open_registration" yaml:"allowOpenRegistration"`
+	// RequireSoftwareStatement rejects registrations without a verified software statement.
+	RequireSoftwareStatement bool `mapstructure:"require_software_statement" json:"require_software_statement" yaml:"requireSoftwareStatement"`
+	// AllowedScopes scopes clients may register for.
+	AllowedScopes []string `mapstructure:"allowed_scopes" json:"allowed_scopes" yaml:"allowedScopes"`
+}
+
+// ClientMetadata registration request and response metadata.
+type ClientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris,omitempty" mapstructure:"redirect_uris"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty" mapstructure:"token_endpoint_auth_method"`
+	GrantTypes              []string `json:"grant_types,omitempty" mapstructure:"grant_types"`
+	ResponseTypes           []string `json:"response_types,omitempty" mapstructure:"response_types"`
+	ClientName              string   `json:"client_name,omitempty" mapstructure:"client_name"`
+	ClientURI               string   `json:"client_uri,omitempty" mapstructure:"client_uri"`
+	Scope                   string   `json:"scope,omitempty" mapstructure:"scope"`
+	SoftwareID              string   `json:"software_id,omitempty" mapstructure:"software_id"`
+	SoftwareVersion         string   `json:"software_version,omitempty" mapstructure:"software_version"`
+	SoftwareStatement       string   `json:"software_statement,omitempty" mapstructure:"-"`
+}
+
+// RegistrationResponse the registered client, see https://datatracker.ietf.org/doc/html/rfc7591#section-3.2.1
+type RegistrationResponse struct {
+	ClientMetadata
+	ClientID              string `json:"client_id"`
+	ClientSecret          string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt      int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt int64  `json:"client_secret_expires_at"`
+}
+
+// EnableRegistration turns on the registration endpoint, statements may be nil when software statements are not used.
+func (s *Server) EnableRegistration(conf RegistrationConfig, statements SoftwareStatementVerifier) {
+	s.registration = &conf
+	s.statements = statements
+}
+
+// HandleRegister the client registration endpoint.
+func (s *Server) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	if s.registration == nil {
+		writeError(w, newError(http.StatusNotFound, ErrorInvalidRequest, "client registration is disabled"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, errInvalidRequest("registration requests must use POST"))
+		return
+	}
+	if !s.authorizeRegistration(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, newError(http.StatusUnauthorized, "invalid_token", "initial access token required"))
+		return
+	}
+	var metadata ClientMetadata
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		writeError(w, newError(http.StatusBadRequest, ErrorInvalidClientMetadata, err.Error()))
+		return
+	}
+	if oerr := s.applySoftwareStatement(&metadata); oerr != nil {
+		writeError(w, oerr)
+		return
+	}
+	if oerr := s.validateMetadata(&metadata); oerr != nil {
+		writeError(w, oerr)
+		return
+	}
+
+	clientID, err := utils.RandStringWithPrefix("client", 12)
+	if err != nil {
+		writeError(w, errServer(err))
+		return
+	}
+	client := &Client{
+		ID:           clientID,
+		RedirectURIs: metadata.RedirectURIs,
+		GrantTypes:   metadata.GrantTypes,
+		Scopes:       strings.Fields(metadata.Scope),
+	}
+	resp := &RegistrationResponse{ClientMetadata: metadata, ClientID: clientID, ClientIDIssuedAt: time.Now().Unix()}
+	if metadata.TokenEndpointAuthMethod != _tokenEndpointAuthNone {
+		if resp.ClientSecret, err = client.RotateSecret(0); err != nil {
+			writeError(w, errServer(err))
+			return
+		}
+	}
+	if err = s.clients.CreateClient(client); err != nil {
+		writeError(w, errServer(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) authorizeRegistration(r *http.Request) bool {
+	raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if raw == "" || raw == r.Header.Get("Authorization") {
+		return s.registration.AllowOpenRegistration
+	}
+	for _, t := range s.registration.InitialAccessTokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(raw)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// applySoftwareStatement overrides the metadata with the verified statement claims.
+func (s *Server) applySoftwareStatement(metadata *ClientMetadata) *Error {
+	if metadata.SoftwareStatement == "" {
+		if s.registration.RequireSoftwareStatement {
+			return newError(http.StatusBadRequest, ErrorInvalidSoftwareStatement, "software statement required")
+		}
+		return nil
+	}
+	if s.statements == nil {
+		return newError(http.StatusBadRequest, ErrorUnapprovedSoftwareStatement, "software statements are not accepted")
+	}
+	claims, err := s.statements(metadata.SoftwareStatement)
+	if err != nil {
+		return newError(http.StatusBadRequest, ErrorInvalidSoftwareStatement, err.Error())
+	}
+	if err = mapstructure.Decode(claims, metadata); err != nil {
+		return newError(http.StatusBadRequest, ErrorInvalidSoftwareStatement, err.Error())
+	}
+	return nil
+}
+
+// validateMetadata checks the metadata and fills the defaults, see https://datatracker.ietf.org/doc/html/rfc7591#section-2
+func (s *Server) validateMetadata(metadata *ClientMetadata) *Error {
+	invalid := func(description string) *Error {
+		return newError(http.StatusBadRequest, ErrorInvalidClientMetadata, description)
+	}
+	switch metadata.TokenEndpointAuthMethod {
+	case "":
+		metadata.TokenEndpointAuthMethod = _tokenEndpointAuthClientSecretBasic
+	case _tokenEndpointAuthNone, _tokenEndpointAuthClientSecretBasic, _tokenEndpointAuthClientSecretPost:
+	default:
+		return invalid("unsupported token_endpoint_auth_method " + metadata.TokenEndpointAuthMethod)
+	}
+	if len(metadata.GrantTypes) == 0 {
+		metadata.GrantTypes = []string{GrantTypeAuthorizationCode}
+	}
+	for _, g := range metadata.GrantTypes {
+		if g != GrantTypeAuthorizationCode && g != GrantTypeRefreshToken && g != GrantTypeDeviceCode {
+			return invalid("unsupported grant type " + g)
+		}
+	}
+	if len(metadata.ResponseTypes) == 0 {
+		metadata.ResponseTypes = []string{"code"}
+	}
+	for _, rt := range metadata.ResponseTypes {
+		if rt != "code" {
+			return invalid("unsupported response type " + rt)
+		}
+	}
+	if utils.StringsInclude(metadata.GrantTypes, GrantTypeAuthorizationCode) && len(metadata.RedirectURIs) == 0 {
+		return newError(http.StatusBadRequest, ErrorInvalidRedirectURI, "redirect_uris required")
+	}
+	for _, uri := range metadata.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return newError(http.StatusBadRequest, ErrorInvalidRedirectURI, err.Error())
+		}
+	}
+	for _, sc := range strings.Fields(metadata.Scope) {
+		if !utils.StringsInclude(s.registration.AllowedScopes, sc) {
+			return invalid("scope not allowed: " + sc)
+		}
+	}
+	return nil
+}
+
+// validateRedirectURI requires absolute uris without fragment, plain http only for loopback.
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || u.Fragment != "" {
+		return fmt.Errorf("redirect uri %s must be absolute without fragment", uri)
+	}
+	if u.Scheme == "http" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1" && u.Hostname() != "::1" {
+		return fmt.Errorf("redirect uri %s must use https", uri)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+)
+
+const (
+	// ErrorUnsupportedTokenType the server can not revoke tokens of the type.
+	ErrorUnsupportedTokenType = "unsupported_token_type"
+
+	_tokenTypeHintAccessToken  = "access_token"
+	_tokenTypeHintRefreshToken = "refresh_token"
+)
+
+// HandleRevoke the revocation endpoint, see https://datatracker.ietf.org/doc/html/rfc7009
+// Refresh tokens are always revocable, access tokens when the token manager implements token.Revoker,
+// e.g. a token.RevocableManager backed by a revocation list. Revoking a refresh token revokes the
+// access tokens issued with it when the manager implements token.IDRevoker.
+func (s *Server) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, errInvalidRequest("revocation requests must use POST"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, errInvalidRequest(err.Error()))
+		return
+	}
+	client, oerr := s.authenticateClient(r)
+	if oerr != nil {
+		writeError(w, oerr)
+		return
+	}
+	raw := r.PostForm.Get("token")
+	if raw == "" {
+		writeError(w, errInvalidRequest("token required"))
+		return
+	}
+
+	// the hint only decides which lookup runs first, unknown hints are ignored.
+	revokers := []func(*Client, string) (bool, *Error){s.revokeRefreshToken, s.revokeAccessToken}
+	if r.PostForm.Get("token_type_hint") == _tokenTypeHintAccessToken {
+		revokers[0], revokers[1] = revokers[1], revokers[0]
+	}
+	for _, revoke := range revokers {
+		found, oerr := revoke(client, raw)
+		if oerr != nil {
+			writeError(w, oerr)
+			return
+		}
+		if found {
+			break
+		}
+	}
+	// invalid tokens do not cause an error response.
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeRefreshToken reports whether raw is a refresh token and revokes it.
+func (s *Server) revokeRefreshToken(client *Client, raw string) (bool, *Error) {
+	signature := token.HashToken(raw)
+	rt, err := s.storage.LoadRefreshToken(signature)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errServer(err)
+	}
+	if rt.ClientID != client.ID {
+		return true, newError(http.StatusBadRequest, ErrorUnauthorizedClient, "token was issued to another client")
+	}
+	if err = s.storage.DeleteRefreshToken(signature); err != nil {
+		return true, errServer(err)
+	}
+	if revoker, ok := s.tokens.(token.IDRevoker); ok {
+		now := time.Now()
+		for _, at := range rt.AccessTokens {
+			if !now.Before(at.ExpiresAt) {
+				continue
+			}
+			if err = revoker.RevokeID(at.ID, at.ExpiresAt); err != nil {
+				return true, errServer(err)
+			}
+		}
+	}
+	return true, nil
+}
+
+// revokeAccessToken reports whether raw is a valid access token and revokes it.
+func (s *Server) revokeAccessToken(client *Client, raw string) (bool, *Error) {
+	claims, err := s.tokens.Verify(raw)
+	if err != nil {
+		return false, nil
+	}
+	if claims.Audience != client.ID {
+		return true, newError(http.StatusBadRequest, ErrorUnauthorizedClient, "token was issued to another client")
+	}
+	revoker, ok := s.tokens.(token.Revoker)
+	if !ok {
+		return true, newError(http.StatusBadRequest, ErrorUnsupportedTokenType, "access tokens can not be revoked")
+	}
+	if err = revoker.Revoke(raw); err != nil {
+		return true, errServer(err)
+	}
+	return true, nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/risk"
+)
+
+// errRiskDenied the risk engine denied the login, or demanded a second factor the end-user lacks.
+var errRiskDenied = errors.New("login denied by risk assessment")
+
+// SetRiskEngine assesses every end-user login with engine: logins scoring for step-up must
+// present the second factor, also after federated logins, and fail without one.
+func (s *Server) SetRiskEngine(engine *risk.Engine) {
+	s.risk = engine
+}
+
+// assessLogin reports whether the login of claims must step up, or fails with errRiskDenied.
+func (s *Server) assessLogin(r *http.Request, provider string, claims *token.Claims) (bool, error) {
+	if s.risk == nil {
+		return false, nil
+	}
+	d := s.risk.Assess(r.Context(), s.risk.Signals(r, provider, claims.Subject, claims.TenantID))
+	switch d.Action {
+	case risk.ActionDeny:
+		s.logger.Warnf("oauth login of %s denied, risk %d: %s", claims.Subject, d.Score, strings.Join(d.Reasons, ","))
+		return false, errRiskDenied
+	case risk.ActionStepUp:
+		s.logger.Infof("oauth login of %s steps up, risk %d: %s", claims.Subject, d.Score, strings.Join(d.Reasons, ","))
+		return true, nil
+	}
+	return false, nil
+}
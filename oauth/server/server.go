@@ -0,0 +1,351 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authn/token/dpop"
+	"github.com/tkeel-io/security/authn/token/keyset"
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/authz/rbac"
+	"github.com/tkeel-io/security/authz/uma"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/risk"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	// AuthorizePath authorization endpoint.
+	AuthorizePath = "/oauth/authorize"
+	// CallbackPath redirect uri registered at federated identity providers.
+	CallbackPath = "/oauth/callback"
+	// TokenPath token endpoint.
+	TokenPath = "/oauth/token"
+	// RevokePath revocation endpoint.
+	RevokePath = "/oauth/revoke"
+
+	_defaultAuthorizeRequestTTL  = 10 * time.Minute
+	_defaultAuthorizationCodeTTL = time.Minute
+	_defaultRefreshTokenTTL      = 30 * 24 * time.Hour
+)
+
+// IdentityMapper maps an identity authenticated by the provider registered under providerKey
+// to the claims of the tokens issued for it.
+type IdentityMapper func(providerKey string, identity idprovider.Identity) (*token.Claims, error)
+
+// Config of the embedded authorization server.
+type Config struct {
+	// Issuer base URL of the authorization server.
+	Issuer string `mapstructure:"issuer" json:"issuer" yaml:"issuer"`
+	// DefaultProvider key of the identity provider used when the request names none.
+	DefaultProvider string `mapstructure:"default_provider" json:"default_provider" yaml:"defaultProvider"`
+	// RedirectProviders keys of providers authenticating by redirect (AuthCodeURL),
+	// the others authenticate with the username password form.
+	RedirectProviders []string `mapstructure:"redirect_providers" json:"redirect_providers" yaml:"redirectProviders"`
+	// AuthorizationCodeTTL lifetime of authorization codes. Default to 1m.
+	AuthorizationCodeTTL time.Duration `mapstructure:"authorization_code_ttl" json:"authorization_code_ttl" yaml:"authorizationCodeTTL"`
+	// RefreshTokenTTL lifetime of refresh tokens. Default to 30 days.
+	RefreshTokenTTL time.Duration `mapstructure:"refresh_token_ttl" json:"refresh_token_ttl" yaml:"refreshTokenTTL"`
+	// RequirePushedAuthorizationRequests rejects authorization requests not pushed to PARPath first.
+	RequirePushedAuthorizationRequests bool `mapstructure:"require_pushed_authorization_requests" json:"require_pushed_authorization_requests" yaml:"requirePushedAuthorizationRequests"`
+	// RequireSignedRequestObject rejects authorization requests not passed as a signed request object.
+	RequireSignedRequestObject bool `mapstructure:"require_signed_request_object" json:"require_signed_request_object" yaml:"requireSignedRequestObject"`
+}
+
+// Server the embedded OAuth2 authorization server, end-users authenticate
+// with the identity providers of the idprovider registry.
+type Server struct {
+	conf        Config
+	clients     ClientStore
+	storage     Storage
+	states      StateStore
+	tokens      token.Manager
+	mapIdentity IdentityMapper
+	consents    ConsentStore
+	// registration nil while dynamic client registration is disabled.
+	registration *RegistrationConfig
+	statements   SoftwareStatementVerifier
+	// openid nil while the OpenID provider mode is disabled.
+	openid   *OpenIDConfig
+	keys     *keyset.Manager
+	userinfo UserInfoLoader
+	// dpop nil while DPoP binding is disabled, proofs are then ignored.
+	dpop *dpop.Validator
+	// device nil while the device authorization grant is disabled.
+	device  *DeviceConfig
+	devices DeviceStore
+	// secondFactor nil while no second factor is required.
+	secondFactor SecondFactor
+	// events nil while logins are not audited.
+	events audit.EventSink
+	// risk nil while logins are not assessed.
+	risk *risk.Engine
+	// uma nil while the UMA grant and protection API are disabled.
+	uma *uma.Service
+	// tenants nil while refresh tokens are not checked against the tenants.
+	tenants TenantChecker
+	// groups nil while the role bindings do not follow the IdP groups.
+	groups *rbac.GroupMapper
+	logger *log.Helper
+}
+
+// TenantChecker reports whether a tenant still exists, satisfied by tenant.Manager.
+type TenantChecker interface {
+	Exists(ctx context.Context, tenantID string) (bool, error)
+}
+
+// New returns a Server issuing access tokens with tokens.
+func New(conf Config, clients ClientStore, storage Storage, tokens token.Manager) *Server {
+	if conf.AuthorizationCodeTTL <= 0 {
+		conf.AuthorizationCodeTTL = _defaultAuthorizationCodeTTL
+	}
+	if conf.RefreshTokenTTL <= 0 {
+		conf.RefreshTokenTTL = _defaultRefreshTokenTTL
+	}
+	return &Server{
+		conf:        conf,
+		clients:     clients,
+		storage:     storage,
+		states:      storage,
+		tokens:      tokens,
+		mapIdentity: defaultIdentityMapper,
+	}
+}
+
+// SetLogger writes the entries of the endpoints to l instead of the global logger.
+func (s *Server) SetLogger(l log.Logger) {
+	s.logger = log.NewHelper(l)
+}
+
+// SetIdentityMapper replaces the default mapping of identities to claims,
+// e.g. to map external identities onto internal users.
+func (s *Server) SetIdentityMapper(mapper IdentityMapper) {
+	s.mapIdentity = mapper
+}
+
+// SetGroupMapper syncs the role bindings of every end-user logging in with the groups of its
+// identity, a failing sync fails the login.
+func (s *Server) SetGroupMapper(mapper *rbac.GroupMapper) {
+	s.groups = mapper
+}
+
+// mapLogin maps the identity authenticated with provider to claims and syncs the role bindings
+// of its groups.
+func (s *Server) mapLogin(provider string, identity idprovider.Identity) (*token.Claims, error) {
+	claims, err := s.mapIdentity(provider, identity)
+	if err != nil || s.groups == nil {
+		return claims, err
+	}
+	if _, err = s.groups.Sync(provider, claims.Subject, idprovider.Groups(identity)); err != nil {
+		s.logger.Errorf("oauth sync groups of %s: %s", claims.Subject, err)
+		return nil, errors.New("role bindings of the groups could not be synced")
+	}
+	return claims, nil
+}
+
+// SetStateStore keeps the pending authorization requests in states instead of the storage,
+// e.g. a RedisStateStore shared by the replicas.
+func (s *Server) SetStateStore(states StateStore) {
+	s.states = states
+}
+
+// SetTenantChecker refuses to refresh the tokens of tenants checker no longer knows, so refresh
+// tokens missed by an offboarding stop working with their tenant.
+func (s *Server) SetTenantChecker(checker TenantChecker) {
+	s.tenants = checker
+}
+
+// SetEventSink writes an authn.login event for every end-user login to sink.
+func (s *Server) SetEventSink(sink audit.EventSink) {
+	s.events = sink
+}
+
+// auditLogin records the login of subject through provider, claims are nil when it failed.
+func (s *Server) auditLogin(r *http.Request, provider, subject string, claims *token.Claims, err error) {
+	if s.events == nil {
+		return
+	}
+	s.events.WriteEvent(loginEvent(r, provider, subject, claims, err))
+}
+
+// auditSecondFactor records the login of claims through provider completed, or failed, with
+// the second factor.
+func (s *Server) auditSecondFactor(r *http.Request, provider string, claims *token.Claims, err error) {
+	if s.events == nil {
+		return
+	}
+	e := loginEvent(r, provider, claims.Subject, claims, err)
+	e.Detail["mfa"] = true
+	s.events.WriteEvent(e)
+}
+
+func loginEvent(r *http.Request, provider, subject string, claims *token.Claims, err error) *audit.Event {
+	e := audit.RequestEvent(r, audit.EventLogin)
+	e.Actor, e.Subject = subject, subject
+	e.Outcome = audit.OutcomeSuccess
+	e.Detail = map[string]interface{}{"provider": provider}
+	if claims != nil {
+		e.Actor, e.Subject, e.TenantID = claims.Subject, claims.Subject, claims.TenantID
+	}
+	switch {
+	case errors.Is(err, errRiskDenied):
+		e.Outcome, e.Reason = audit.OutcomeDenied, err.Error()
+	case err != nil:
+		e.Outcome, e.Reason = audit.OutcomeFailure, err.Error()
+	}
+	return e
+}
+
+// EnableDPoP binds the tokens issued for token requests carrying a DPoP proof to the proof key.
+func (s *Server) EnableDPoP(validator *dpop.Validator) {
+	s.dpop = validator
+}
+
+// Handler returns the endpoints of the server mounted at their paths.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	s.Register(mux)
+	return mux
+}
+
+// Register mounts the endpoints on mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc(AuthorizePath, s.HandleAuthorize)
+	mux.HandleFunc(CallbackPath, s.HandleCallback)
+	mux.HandleFunc(TokenPath, s.HandleToken)
+	mux.HandleFunc(RevokePath, s.HandleRevoke)
+	mux.HandleFunc(ConsentPath, s.HandleConsent)
+	mux.HandleFunc(ConsentsPath, s.HandleConsents)
+	mux.HandleFunc(RegisterPath, s.HandleRegister)
+	mux.HandleFunc(PARPath, s.HandlePushedAuthorization)
+	mux.HandleFunc(DeviceAuthorizationPath, s.HandleDeviceAuthorization)
+	mux.HandleFunc(DeviceVerificationPath, s.HandleDeviceVerification)
+	mux.HandleFunc(DiscoveryPath, s.HandleDiscovery)
+	mux.HandleFunc(keyset.JWKSPath, s.HandleJWKS)
+	mux.HandleFunc(UserInfoPath, s.HandleUserInfo)
+	mux.HandleFunc(UMAResourceSetPath, s.HandleUMAResourceSet)
+	mux.HandleFunc(UMAResourceSetPath+"/", s.HandleUMAResourceSet)
+	mux.HandleFunc(UMAPermissionPath, s.HandleUMAPermission)
+	mux.HandleFunc(UMASharesPath, s.HandleUMAShares)
+}
+
+func defaultIdentityMapper(_ string, identity idprovider.Identity) (*token.Claims, error) {
+	claims := &token.Claims{
+		Subject:  identity.GetUserID(),
+		TenantID: identity.GetTenantID(),
+		Username: identity.GetUsername(),
+	}
+	if groups := idprovider.Groups(identity); len(groups) > 0 {
+		claims.Extra = map[string]interface{}{idprovider.ExtraGroups: groups}
+	}
+	return claims, nil
+}
+
+// authenticateClient authenticates the client with HTTP basic or the client_id and client_secret form parameters,
+// public clients only present their client_id.
+func (s *Server) authenticateClient(r *http.Request) (*Client, *Error) {
+	clientID, secret, basic := r.BasicAuth()
+	if !basic {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	if clientID == "" {
+		return nil, errInvalidClient("client authentication required")
+	}
+	client, err := s.clients.GetClient(clientID)
+	if err != nil {
+		return nil, errInvalidClient("unknown client")
+	}
+	if client.Public() {
+		if secret != "" {
+			return nil, errInvalidClient("public client presented a secret")
+		}
+		return client, nil
+	}
+	if !client.CheckSecret(secret) {
+		return nil, errInvalidClient("client authentication failed")
+	}
+	return client, nil
+}
+
+// authenticateBearer verifies the access token of r, writing the challenge when it is missing or
+// invalid. Tokens bound to a DPoP key are only accepted with the DPoP scheme and a proof signed
+// by that key, so a stolen bound token can't be replayed as a bearer token.
+func (s *Server) authenticateBearer(w http.ResponseWriter, r *http.Request) (*token.Claims, bool) {
+	header := r.Header.Get("Authorization")
+	if s.dpop != nil && header != "" {
+		claims, err := s.dpop.VerifyRequest(r, s.tokens)
+		if err != nil {
+			code := "invalid_token"
+			if errors.Is(err, dpop.ErrUseNonce) {
+				code = dpop.ErrorUseNonce
+			}
+			s.writeTokenChallenge(w, code)
+			return nil, false
+		}
+		return claims, true
+	}
+	raw := strings.TrimPrefix(header, "Bearer ")
+	if raw == "" || raw == header {
+		s.writeTokenChallenge(w, "")
+		return nil, false
+	}
+	claims, err := s.tokens.Verify(raw)
+	if err != nil || (claims.Confirmation != nil && claims.Confirmation.JKT != "") {
+		s.writeTokenChallenge(w, "invalid_token")
+		return nil, false
+	}
+	return claims, true
+}
+
+// writeTokenChallenge answers a request without a valid access token, code empty for a missing
+// token. With DPoP enabled the DPoP challenge and a fresh nonce come first.
+func (s *Server) writeTokenChallenge(w http.ResponseWriter, code string) {
+	var params string
+	if code != "" {
+		params = ` error="` + code + `"`
+	}
+	if s.dpop != nil {
+		if nonce := s.dpop.Nonce(); nonce != "" {
+			w.Header().Set(dpop.HeaderNonce, nonce)
+		}
+		w.Header().Add("WWW-Authenticate", dpop.SchemeDPoP+params)
+	}
+	w.Header().Add("WWW-Authenticate", "Bearer"+params)
+	if code == "" {
+		code = "invalid_token"
+	}
+	writeJSON(w, http.StatusUnauthorized, newError(http.StatusUnauthorized, code, ""))
+}
+
+// insufficientScope answers a bearer whose token lacks scope.
+func insufficientScope(w http.ResponseWriter, scope string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+	writeJSON(w, http.StatusForbidden, newError(http.StatusForbidden, "insufficient_scope", ""))
+}
+
+// newSecret returns a random secret and the signature it is stored under.
+func newSecret() (secret, signature string, err error) {
+	if secret, err = utils.RandBase64String(32); err != nil {
+		return "", "", err
+	}
+	return secret, token.HashToken(secret), nil
+}
@@ -0,0 +1,1043 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/idprovider"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authn/token/dpop"
+	"github.com/tkeel-io/security/authn/token/keyset"
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/authz/casbin"
+	"github.com/tkeel-io/security/authz/rbac"
+	"github.com/tkeel-io/security/authz/uma"
+	"github.com/tkeel-io/security/risk"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+type fakeIdentity struct{ user string }
+
+func (f fakeIdentity) GetUserID() string                { return f.user }
+func (f fakeIdentity) GetTenantID() string              { return "tenant-1" }
+func (f fakeIdentity) GetUsername() string              { return f.user }
+func (f fakeIdentity) GetEmail() string                 { return "" }
+func (f fakeIdentity) GetExternalID() string            { return f.user }
+func (f fakeIdentity) GetExtra() map[string]interface{} { return nil }
+
+type fakeProvider struct{}
+
+func (fakeProvider) Type() string { return "fake" }
+func (fakeProvider) AuthenticateCode(string) (idprovider.Identity, error) {
+	return nil, errors.New("unsupported")
+}
+func (fakeProvider) Authenticate(username, password string) (idprovider.Identity, error) {
+	if password != "secret" {
+		return nil, errors.New("bad password")
+	}
+	return fakeIdentity{user: username}, nil
+}
+func (fakeProvider) AuthCodeURL(string, string) string { return "" }
+
+var _requestIDPattern = regexp.MustCompile(`name="request_id" value="([^"]+)"`)
+
+func newTestServer(t *testing.T) (*Server, http.Handler) {
+	idprovider.RegisterIdentityProvider("fake", fakeProvider{})
+	tokens, err := token.NewOpaqueManager(&token.Config{}, token.NewMemoryStore())
+	assert.NoError(t, err)
+	clients := NewMemoryClientStore(&Client{
+		ID:           "plugin",
+		RedirectURIs: []string{"https://plugin.example/cb"},
+		GrantTypes:   []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		Scopes:       []string{"read", "write"},
+	})
+	s := New(Config{Issuer: "https://tkeel.example", DefaultProvider: "fake"}, clients, NewMemoryStorage(), tokens)
+	return s, s.Handler()
+}
+
+// authorize returns an authorization code, extra overrides the default request parameters.
+func authorize(t *testing.T, h http.Handler, verifier string, extra url.Values) string {
+	sum := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"plugin"},
+		"redirect_uri":          {"https://plugin.example/cb"},
+		"scope":                 {"read write"},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	for k, v := range extra {
+		q[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?"+q.Encode(), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	match := _requestIDPattern.FindStringSubmatch(rec.Body.String())
+	assert.Len(t, match, 2)
+
+	rec = postForm(h, AuthorizePath, url.Values{"request_id": {match[1]}, "username": {"admin"}, "password": {"secret"}})
+	assert.Equal(t, http.StatusFound, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "xyz", location.Query().Get("state"))
+	return location.Query().Get("code")
+}
+
+func postForm(h http.Handler, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	s, h := newTestServer(t)
+	code := authorize(t, h, "verifier-0123456789", nil)
+
+	form := url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"client_id":     {"plugin"},
+		"code":          {code},
+		"redirect_uri":  {"https://plugin.example/cb"},
+		"code_verifier": {"verifier-0123456789"},
+	}
+	rec := postForm(h, TokenPath, form)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp TokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	claims, err := s.tokens.Verify(resp.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", claims.Subject)
+	assert.Equal(t, "plugin", claims.Audience)
+	assert.Equal(t, "read write", claims.Scope)
+
+	// codes are single use.
+	rec = postForm(h, TokenPath, form)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = postForm(h, TokenPath, url.Values{
+		"grant_type":    {GrantTypeRefreshToken},
+		"client_id":     {"plugin"},
+		"refresh_token": {resp.RefreshToken},
+		"scope":         {"read"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var refreshed TokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &refreshed))
+	assert.Equal(t, "read", refreshed.Scope)
+	assert.NotEqual(t, resp.RefreshToken, refreshed.RefreshToken)
+
+	rec = postForm(h, RevokePath, url.Values{"client_id": {"plugin"}, "token": {refreshed.AccessToken}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	_, err = s.tokens.Verify(refreshed.AccessToken)
+	assert.Error(t, err)
+}
+
+type fakeTenants map[string]bool
+
+func (f fakeTenants) Exists(_ context.Context, tenantID string) (bool, error) {
+	return f[tenantID], nil
+}
+
+func TestRefreshTenant(t *testing.T) {
+	s, h := newTestServer(t)
+	s.SetTenantChecker(fakeTenants{"kept": true})
+	refresh := func(tenantID string) *httptest.ResponseRecorder {
+		raw := "refresh-" + tenantID
+		assert.NoError(t, s.storage.SaveRefreshToken(&RefreshToken{
+			Signature: token.HashToken(raw),
+			ClientID:  "plugin",
+			Scope:     "read",
+			Claims:    &token.Claims{Subject: "alice", TenantID: tenantID},
+			ExpiresAt: time.Now().Add(time.Hour),
+		}))
+		return postForm(h, TokenPath, url.Values{"grant_type": {GrantTypeRefreshToken}, "client_id": {"plugin"}, "refresh_token": {raw}})
+	}
+	assert.Equal(t, http.StatusOK, refresh("kept").Code)
+	rec := refresh("gone")
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "offboarded tenants are not refreshed")
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+
+	// offboarding deletes the refresh tokens of the tenant.
+	assert.NoError(t, s.storage.SaveRefreshToken(&RefreshToken{Signature: "a", Claims: &token.Claims{TenantID: "kept"}, ExpiresAt: time.Now().Add(time.Hour)}))
+	assert.NoError(t, s.storage.SaveRefreshToken(&RefreshToken{Signature: "b", Claims: &token.Claims{TenantID: "other"}, ExpiresAt: time.Now().Add(time.Hour)}))
+	assert.NoError(t, s.storage.RevokeTenant("kept"))
+	_, err := s.storage.LoadRefreshToken("a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.storage.LoadRefreshToken("b")
+	assert.NoError(t, err)
+}
+
+func TestTokenRejectsWrongVerifier(t *testing.T) {
+	_, h := newTestServer(t)
+	code := authorize(t, h, "verifier-0123456789", nil)
+	rec := postForm(h, TokenPath, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"client_id":     {"plugin"},
+		"code":          {code},
+		"redirect_uri":  {"https://plugin.example/cb"},
+		"code_verifier": {"other"},
+	})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+}
+
+func TestTokenRequiresRedirectURI(t *testing.T) {
+	_, h := newTestServer(t)
+	exchange := func(code string) *httptest.ResponseRecorder {
+		return postForm(h, TokenPath, url.Values{
+			"grant_type":    {GrantTypeAuthorizationCode},
+			"client_id":     {"plugin"},
+			"code":          {code},
+			"code_verifier": {"verifier-0123456789"},
+		})
+	}
+	rec := exchange(authorize(t, h, "verifier-0123456789", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code, "the authorization request had a redirect_uri")
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+
+	// the only registered redirect uri is implied by both requests.
+	rec = exchange(authorize(t, h, "verifier-0123456789", url.Values{"redirect_uri": {""}}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	s, h := newTestServer(t)
+	assert.NoError(t, s.storage.SaveRefreshToken(&RefreshToken{
+		Signature: token.HashToken("refresh"),
+		ClientID:  "plugin",
+		Scope:     "read",
+		Claims:    &token.Claims{Subject: "alice"},
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+	var (
+		wg sync.WaitGroup
+		ok int32
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := postForm(h, TokenPath, url.Values{"grant_type": {GrantTypeRefreshToken}, "client_id": {"plugin"}, "refresh_token": {"refresh"}})
+			if rec.Code == http.StatusOK {
+				atomic.AddInt32(&ok, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), ok, "a refresh token is spent once")
+}
+
+func TestConsentRemembered(t *testing.T) {
+	s, h := newTestServer(t)
+	consents := NewMemoryConsentStore()
+	s.SetConsentStore(consents)
+
+	q := url.Values{"response_type": {"code"}, "client_id": {"plugin"}, "scope": {"read"}, "code_challenge": {"challenge"}}
+	login := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?"+q.Encode(), nil))
+		match := _requestIDPattern.FindStringSubmatch(rec.Body.String())
+		return postForm(h, AuthorizePath, url.Values{"request_id": {match[1]}, "username": {"admin"}, "password": {"secret"}})
+	}
+
+	rec := login()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), ConsentPath)
+	match := _requestIDPattern.FindStringSubmatch(rec.Body.String())
+	rec = postForm(h, ConsentPath, url.Values{"request_id": {match[1]}, "decision": {"allow"}, "remember": {"true"}})
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Contains(t, rec.Header().Get("Location"), "code=")
+
+	// the remembered consent skips the consent step.
+	rec = login()
+	assert.Equal(t, http.StatusFound, rec.Code)
+
+	assert.NoError(t, consents.RevokeConsent("admin", "plugin"))
+	rec = login()
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestHandleConsents(t *testing.T) {
+	s, _ := newTestServer(t)
+	consents := NewMemoryConsentStore()
+	s.SetConsentStore(consents)
+	assert.NoError(t, consents.SaveConsent(&Consent{Subject: "admin", ClientID: "plugin", Scopes: []string{"read"}, GrantedAt: time.Now()}))
+	assert.NoError(t, s.clients.CreateClient(&Client{ID: "console", Trusted: true}))
+	list := func(clientID, scope string) *httptest.ResponseRecorder {
+		accessToken, err := s.tokens.Issue(&token.Claims{Subject: "admin", Audience: clientID, Scope: scope})
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, ConsentsPath, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		s.HandleConsents(rec, req)
+		return rec
+	}
+	rec := list("plugin", "read")
+	assert.Equal(t, http.StatusForbidden, rec.Code, "a third party token does not manage consents")
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), ScopeConsents)
+	assert.Equal(t, http.StatusOK, list("plugin", "read "+ScopeConsents).Code)
+	rec = list("console", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"client_id":"plugin"`)
+}
+
+func TestRegisterClient(t *testing.T) {
+	s, h := newTestServer(t)
+	statementKey := []byte("statement-key")
+	s.EnableRegistration(RegistrationConfig{
+		InitialAccessTokens: []string{"initial"},
+		AllowedScopes:       []string{"read"},
+	}, NewSoftwareStatementVerifier("tkeel-market", statementKey, "HS256"))
+
+	register := func(bearer string, metadata ClientMetadata) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(metadata)
+		req := httptest.NewRequest("POST", RegisterPath, strings.NewReader(string(body)))
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	metadata := ClientMetadata{RedirectURIs: []string{"https://plugin.example/cb"}, Scope: "read"}
+	assert.Equal(t, http.StatusUnauthorized, register("", metadata).Code)
+	assert.Equal(t, http.StatusUnauthorized, register("wrong", metadata).Code)
+	assert.Equal(t, http.StatusBadRequest, register("initial", ClientMetadata{RedirectURIs: []string{"http://plugin.example/cb"}}).Code)
+	assert.Equal(t, http.StatusBadRequest, register("initial", ClientMetadata{RedirectURIs: metadata.RedirectURIs, Scope: "admin"}).Code)
+
+	statement, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":         "tkeel-market",
+		"software_id": "plugin-core",
+	}).SignedString(statementKey)
+	assert.NoError(t, err)
+	metadata.SoftwareStatement = statement
+	rec := register("initial", metadata)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var resp RegistrationResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "plugin-core", resp.SoftwareID)
+	assert.NotEmpty(t, resp.ClientSecret)
+
+	client, err := s.clients.GetClient(resp.ClientID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{GrantTypeAuthorizationCode}, client.GrantTypes)
+	assert.True(t, client.CheckSecret(resp.ClientSecret))
+}
+
+func TestClientSecretRotation(t *testing.T) {
+	client := &Client{ID: "backend"}
+	assert.NoError(t, client.SetSecret("first"))
+	store := NewMemoryClientStore(client)
+	assert.False(t, client.Public())
+	assert.Equal(t, ErrClientExists, store.CreateClient(&Client{ID: "backend"}))
+
+	second, err := RotateClientSecret(store, "backend", time.Hour)
+	assert.NoError(t, err)
+	rotated, err := store.GetClient("backend")
+	assert.NoError(t, err)
+	assert.True(t, rotated.CheckSecret("first"))
+	assert.True(t, rotated.CheckSecret(second))
+	assert.False(t, rotated.CheckSecret("other"))
+	hash, err := HashClientSecret("first")
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, rotated.Secrets[0].Hash, "hashes are salted")
+
+	// hashes stored before secrets were salted still verify.
+	legacy := &Client{ID: "legacy", Secrets: []ClientSecret{{Hash: token.HashToken("legacy-secret")}}}
+	assert.True(t, legacy.CheckSecret("legacy-secret"))
+	assert.False(t, legacy.CheckSecret("other"))
+
+	third, err := RotateClientSecret(store, "backend", 0)
+	assert.NoError(t, err)
+	rotated, _ = store.GetClient("backend")
+	assert.False(t, rotated.CheckSecret("first"))
+	assert.False(t, rotated.CheckSecret(second))
+	assert.True(t, rotated.CheckSecret(third))
+
+	assert.NoError(t, store.DeleteClient("backend"))
+	clients, err := store.ListClients()
+	assert.NoError(t, err)
+	assert.Empty(t, clients)
+}
+
+func TestOpenIDProvider(t *testing.T) {
+	s, h := newTestServer(t)
+	keys, err := keyset.New(keyset.Config{Algorithm: keyset.AlgorithmES256}, nil)
+	assert.NoError(t, err)
+	s.EnableOpenID(OpenIDConfig{}, keys, nil)
+	client, _ := s.clients.GetClient("plugin")
+	client.Scopes = append(client.Scopes, ScopeOpenID, "profile")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", DiscoveryPath, nil))
+	var metadata map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &metadata))
+	assert.Equal(t, "https://tkeel.example/.well-known/jwks.json", metadata["jwks_uri"])
+
+	code := authorize(t, h, "verifier-0123456789", url.Values{"scope": {"openid profile"}, "nonce": {"n-0S6_WzA2Mj"}})
+	rec = postForm(h, TokenPath, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"client_id":     {"plugin"},
+		"code":          {code},
+		"redirect_uri":  {"https://plugin.example/cb"},
+		"code_verifier": {"verifier-0123456789"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp TokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	idClaims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(resp.IDToken, idClaims, func(t *jwt.Token) (interface{}, error) {
+		key, err := keys.Key(t.Header["kid"].(string))
+		if err != nil {
+			return nil, err
+		}
+		return key.Public(), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "n-0S6_WzA2Mj", idClaims["nonce"])
+	assert.Equal(t, "plugin", idClaims["aud"])
+	assert.Equal(t, "admin", idClaims["sub"])
+
+	req := httptest.NewRequest("GET", UserInfoPath, nil)
+	req.Header.Set("Authorization", "Bearer "+resp.AccessToken)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var info map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	assert.Equal(t, map[string]interface{}{"sub": "admin", "preferred_username": "admin", "tenant_id": "tenant-1"}, info)
+}
+
+func TestPushedAuthorizationRequest(t *testing.T) {
+	s, h := newTestServer(t)
+	s.conf.RequirePushedAuthorizationRequests = true
+	client, _ := s.clients.GetClient("plugin")
+	assert.NoError(t, client.SetSecret("plugin-secret"))
+
+	// without a pushed request the authorization endpoint refuses.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?client_id=plugin&response_type=code", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	push := url.Values{"response_type": {"code"}, "scope": {"read"}, "state": {"xyz"}}
+	rec = postForm(h, PARPath, push)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	push.Set("client_id", "plugin")
+	push.Set("client_secret", "plugin-secret")
+	rec = postForm(h, PARPath, push)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var pushed PushedAuthorizationResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pushed))
+	assert.True(t, strings.HasPrefix(pushed.RequestURI, "urn:ietf:params:oauth:request_uri:"))
+
+	q := url.Values{"client_id": {"plugin"}, "request_uri": {pushed.RequestURI}}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?"+q.Encode(), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	match := _requestIDPattern.FindStringSubmatch(rec.Body.String())
+	rec = postForm(h, AuthorizePath, url.Values{"request_id": {match[1]}, "username": {"admin"}, "password": {"secret"}})
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Contains(t, rec.Header().Get("Location"), "state=xyz")
+
+	// request uris are single use.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?"+q.Encode(), nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestSignedRequestObject(t *testing.T) {
+	s, h := newTestServer(t)
+	s.conf.RequireSignedRequestObject = true
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	client, _ := s.clients.GetClient("plugin")
+	client.JWKS = &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.Public(), KeyID: "k1", Algorithm: "ES256"}}}
+
+	sign := func(claims jwt.MapClaims) string {
+		t := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		t.Header["kid"] = "k1"
+		signed, _ := t.SignedString(key)
+		return signed
+	}
+	request := func(object string) *httptest.ResponseRecorder {
+		q := url.Values{"client_id": {"plugin"}, "response_type": {"code"}, "scope": {"write"}, "request": {object}}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?"+q.Encode(), nil))
+		return rec
+	}
+	claims := jwt.MapClaims{
+		"iss":            "plugin",
+		"aud":            "https://tkeel.example",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"response_type":  "code",
+		"scope":          "read",
+		"state":          "signed-state",
+		"code_challenge": "challenge",
+	}
+
+	rec := request(sign(claims))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	match := _requestIDPattern.FindStringSubmatch(rec.Body.String())
+	rec = postForm(h, AuthorizePath, url.Values{"request_id": {match[1]}, "username": {"admin"}, "password": {"secret"}})
+	assert.Contains(t, rec.Header().Get("Location"), "state=signed-state")
+
+	claims["aud"] = "https://other.example"
+	rec = request(sign(claims))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidRequestObject)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?client_id=plugin&response_type=code&code_challenge=c", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestDPoPBoundTokens(t *testing.T) {
+	s, h := newTestServer(t)
+	validator, err := dpop.NewValidator(dpop.Config{PublicURL: "https://tkeel.example"}, nil)
+	assert.NoError(t, err)
+	s.EnableDPoP(validator)
+	keys, err := keyset.New(keyset.Config{Algorithm: keyset.AlgorithmES256}, nil)
+	assert.NoError(t, err)
+	s.EnableOpenID(OpenIDConfig{}, keys, nil)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	proofer, err := dpop.NewProofer(key)
+	assert.NoError(t, err)
+
+	tokenRequest := func(form url.Values, p *dpop.Proofer) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", TokenPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		proof, err := p.Proof("POST", "https://tkeel.example"+TokenPath, "")
+		assert.NoError(t, err)
+		req.Header.Set(dpop.HeaderDPoP, proof)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	code := authorize(t, h, "verifier-0123456789", nil)
+	rec := tokenRequest(url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"client_id":     {"plugin"},
+		"code":          {code},
+		"redirect_uri":  {"https://plugin.example/cb"},
+		"code_verifier": {"verifier-0123456789"},
+	}, proofer)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp TokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, dpop.SchemeDPoP, resp.TokenType)
+	claims, err := s.tokens.Verify(resp.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, proofer.Thumbprint(), claims.Confirmation.JKT)
+
+	// the bound access token needs a proof of its key at the server's own endpoints too.
+	userInfo := func(scheme string, p *dpop.Proofer) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", UserInfoPath, nil)
+		req.Header.Set("Authorization", scheme+" "+resp.AccessToken)
+		if p != nil {
+			proof, err := p.Proof("GET", "https://tkeel.example"+UserInfoPath, resp.AccessToken)
+			assert.NoError(t, err)
+			req.Header.Set(dpop.HeaderDPoP, proof)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec = userInfo("Bearer", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Values("WWW-Authenticate"), `DPoP error="invalid_token"`)
+	rec = userInfo(dpop.SchemeDPoP, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	// authenticated, the token was only granted read and write.
+	rec = userInfo(dpop.SchemeDPoP, proofer)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// the refresh token of the public client is bound to the same key.
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherProofer, err := dpop.NewProofer(other)
+	assert.NoError(t, err)
+	refresh := url.Values{"grant_type": {GrantTypeRefreshToken}, "client_id": {"plugin"}, "refresh_token": {resp.RefreshToken}}
+	rec = tokenRequest(refresh, otherProofer)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+}
+
+func TestDPoPNonce(t *testing.T) {
+	s, h := newTestServer(t)
+	validator, err := dpop.NewValidator(dpop.Config{NonceSecret: "nonce-secret"}, nil)
+	assert.NoError(t, err)
+	s.EnableDPoP(validator)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	proofer, err := dpop.NewProofer(key)
+	assert.NoError(t, err)
+
+	code := authorize(t, h, "verifier-0123456789", nil)
+	form := url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"client_id":     {"plugin"},
+		"code":          {code},
+		"redirect_uri":  {"https://plugin.example/cb"},
+		"code_verifier": {"verifier-0123456789"},
+	}
+	tokenRequest := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", TokenPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		proof, err := proofer.Proof("POST", "https://tkeel.example"+TokenPath, "")
+		assert.NoError(t, err)
+		req.Header.Set(dpop.HeaderDPoP, proof)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// a proof without the nonce is refused before the code is redeemed.
+	rec := tokenRequest()
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), dpop.ErrorUseNonce)
+	nonce := rec.Header().Get(dpop.HeaderNonce)
+	assert.NotEmpty(t, nonce)
+
+	proofer.SetNonce(nonce)
+	rec = tokenRequest()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get(dpop.HeaderNonce))
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	s, h := newTestServer(t)
+	jwtManager, err := token.NewJWTManager(&token.Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	s.tokens = jwtManager
+	code := authorize(t, h, "verifier-0123456789", nil)
+	rec := postForm(h, TokenPath, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"client_id":     {"plugin"},
+		"code":          {code},
+		"redirect_uri":  {"https://plugin.example/cb"},
+		"code_verifier": {"verifier-0123456789"},
+	})
+	var resp TokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	revoke := url.Values{"client_id": {"plugin"}, "token": {resp.AccessToken}, "token_type_hint": {"access_token"}}
+	rec = postForm(h, RevokePath, revoke)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorUnsupportedTokenType)
+
+	s.tokens = token.NewRevocableManager(jwtManager, token.NewMemoryRevocationList())
+	rec = postForm(h, RevokePath, revoke)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	_, err = s.tokens.Verify(resp.AccessToken)
+	assert.ErrorIs(t, err, token.ErrTokenRevoked)
+
+	// unknown tokens are not an error.
+	rec = postForm(h, RevokePath, url.Values{"client_id": {"plugin"}, "token": {"unknown"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestGroupMapperLogin(t *testing.T) {
+	s, h := newTestServer(t)
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	roles := rbac.NewRoleOperator(enforcer)
+	assert.NoError(t, roles.CreateRole("tenant-1", "operator", rbac.Permission{Resource: "devices/*", Action: "*"}))
+	assert.NoError(t, roles.AssignRole("tenant-1", "admin", "operator"))
+	mapper, err := rbac.NewGroupMapper(roles, []rbac.GroupMappingRule{{Group: "iot-ops", TenantID: "tenant-1", Role: "operator"}})
+	assert.NoError(t, err)
+	s.SetGroupMapper(mapper)
+
+	// admin is in no group, the login removes the stale managed binding.
+	authorize(t, h, "verifier-0123456789", nil)
+	bound, err := roles.SubjectRoles("tenant-1", "admin")
+	assert.NoError(t, err)
+	assert.Empty(t, bound)
+}
+
+func TestRevokeRefreshTokenCascade(t *testing.T) {
+	s, h := newTestServer(t)
+	s.clients.(*MemoryClientStore).SetClient(&Client{ID: "other", GrantTypes: []string{GrantTypeRefreshToken}})
+	code := authorize(t, h, "verifier-0123456789", nil)
+	rec := postForm(h, TokenPath, url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"client_id":     {"plugin"},
+		"code":          {code},
+		"redirect_uri":  {"https://plugin.example/cb"},
+		"code_verifier": {"verifier-0123456789"},
+	})
+	var resp TokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	rec = postForm(h, TokenPath, url.Values{"grant_type": {GrantTypeRefreshToken}, "client_id": {"plugin"}, "refresh_token": {resp.RefreshToken}})
+	var refreshed TokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &refreshed))
+
+	// other clients can not revoke the token.
+	rec = postForm(h, RevokePath, url.Values{"client_id": {"other"}, "token": {refreshed.RefreshToken}, "token_type_hint": {"refresh_token"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorUnauthorizedClient)
+
+	// the refresh token takes the access tokens of its whole rotation with it.
+	rec = postForm(h, RevokePath, url.Values{"client_id": {"plugin"}, "token": {refreshed.RefreshToken}, "token_type_hint": {"unknown"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	for _, accessToken := range []string{resp.AccessToken, refreshed.AccessToken} {
+		_, err := s.tokens.Verify(accessToken)
+		assert.Error(t, err)
+	}
+}
+
+func TestDeviceAuthorizationGrant(t *testing.T) {
+	s, h := newTestServer(t)
+	s.EnableDeviceGrant(DeviceConfig{}, NewMemoryDeviceStore())
+	client, _ := s.clients.GetClient("plugin")
+	client.GrantTypes = append(client.GrantTypes, GrantTypeDeviceCode)
+
+	rec := postForm(h, DeviceAuthorizationPath, url.Values{"client_id": {"plugin"}, "scope": {"read"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var device DeviceAuthorizationResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &device))
+	assert.Regexp(t, `^[A-Z]{4}-[A-Z]{4}$`, device.UserCode)
+	assert.Equal(t, int64(5), device.Interval)
+
+	poll := url.Values{"grant_type": {GrantTypeDeviceCode}, "client_id": {"plugin"}, "device_code": {device.DeviceCode}}
+	rec = postForm(h, TokenPath, poll)
+	assert.Contains(t, rec.Body.String(), ErrorAuthorizationPending)
+	rec = postForm(h, TokenPath, poll)
+	assert.Contains(t, rec.Body.String(), ErrorSlowDown)
+
+	rec = postForm(h, DeviceVerificationPath, url.Values{
+		"user_code": {strings.ToLower(device.UserCode)},
+		"username":  {"admin"},
+		"password":  {"secret"},
+		"decision":  {"allow"},
+	})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Device connected")
+
+	// approved devices are not throttled.
+	rec = postForm(h, TokenPath, poll)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp TokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	claims, err := s.tokens.Verify(resp.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", claims.Subject)
+	assert.Equal(t, "read", claims.Scope)
+
+	rec = postForm(h, TokenPath, poll)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+}
+
+func TestDevicePollConcurrent(t *testing.T) {
+	s, h := newTestServer(t)
+	s.EnableDeviceGrant(DeviceConfig{}, NewMemoryDeviceStore())
+	client, _ := s.clients.GetClient("plugin")
+	client.GrantTypes = append(client.GrantTypes, GrantTypeDeviceCode)
+	rec := postForm(h, DeviceAuthorizationPath, url.Values{"client_id": {"plugin"}, "scope": {"read"}})
+	var device DeviceAuthorizationResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &device))
+	poll := url.Values{"grant_type": {GrantTypeDeviceCode}, "client_id": {"plugin"}, "device_code": {device.DeviceCode}}
+
+	// concurrent polls can not dodge the interval, all but one are told to slow down.
+	race := func(pending bool) map[int]int {
+		var (
+			wg    sync.WaitGroup
+			lock  sync.Mutex
+			codes = map[int]int{}
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rec := postForm(h, TokenPath, poll)
+				lock.Lock()
+				codes[rec.Code]++
+				lock.Unlock()
+				if pending {
+					assert.Regexp(t, ErrorAuthorizationPending+"|"+ErrorSlowDown, rec.Body.String())
+				}
+			}()
+		}
+		wg.Wait()
+		return codes
+	}
+	race(true)
+	auth, err := s.devices.GetDeviceAuthorization(token.HashToken(device.DeviceCode))
+	assert.NoError(t, err)
+	assert.Equal(t, _defaultDeviceInterval+7*_slowDownIncrement, auth.Interval)
+
+	rec = postForm(h, DeviceVerificationPath, url.Values{
+		"user_code": {device.UserCode},
+		"username":  {"admin"},
+		"password":  {"secret"},
+		"decision":  {"allow"},
+	})
+	assert.Contains(t, rec.Body.String(), "Device connected")
+	// the approval is redeemed once.
+	assert.Equal(t, 1, race(false)[http.StatusOK])
+}
+
+type fakeSecondFactor struct{ enrolled string }
+
+func (f fakeSecondFactor) Enrolled(subject string) (bool, error) { return subject == f.enrolled, nil }
+func (f fakeSecondFactor) Verify(subject, code string) error {
+	if code != "123456" {
+		return errors.New("bad code")
+	}
+	return nil
+}
+
+func TestUMAGrant(t *testing.T) {
+	s, h := newTestServer(t)
+	s.EnableUMA(uma.New(uma.Config{}, uma.NewMemoryStore()))
+	client, _ := s.clients.GetClient("plugin")
+	client.GrantTypes = append(client.GrantTypes, GrantTypeUMATicket)
+	bearer := func(subject, scope string) string {
+		accessToken, err := s.tokens.Issue(&token.Claims{Subject: subject, Scope: scope})
+		assert.NoError(t, err)
+		return "Bearer " + accessToken
+	}
+	call := func(method, path, authorization, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", authorization)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	alice := bearer("alice", ScopeUMAProtection)
+
+	rec := call(http.MethodPost, UMASharesPath, bearer("alice", "read"), `{"resource_id":"any","requester":"bob","scopes":["read"]}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code, "plain access tokens are not PATs")
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "insufficient_scope")
+	rec = call(http.MethodPost, UMAResourceSetPath, alice, `{"name":"thermostat","resource_scopes":["read","write"]}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var registered struct {
+		ID string `json:"_id"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &registered))
+	rec = call(http.MethodGet, UMAResourceSetPath+"/"+registered.ID, bearer("bob", ScopeUMAProtection), "")
+	assert.Equal(t, http.StatusNotFound, rec.Code, "resources of others are hidden")
+	rec = call(http.MethodPost, UMASharesPath, alice, `{"resource_id":"`+registered.ID+`","requester":"bob","scopes":["read"]}`)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	claimToken := func(claims *token.Claims) string {
+		raw, err := s.tokens.Issue(claims)
+		assert.NoError(t, err)
+		return raw
+	}
+	bob := claimToken(&token.Claims{Subject: "bob", Audience: "plugin"})
+	exchange := func(scopes, claimToken string) *httptest.ResponseRecorder {
+		rec := call(http.MethodPost, UMAPermissionPath, alice, `{"resource_id":"`+registered.ID+`","resource_scopes":`+scopes+`}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		var ticket struct {
+			Ticket string `json:"ticket"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ticket))
+		return postForm(h, TokenPath, url.Values{
+			"grant_type":  {GrantTypeUMATicket},
+			"client_id":   {"plugin"},
+			"ticket":      {ticket.Ticket},
+			"claim_token": {claimToken},
+		})
+	}
+	// claim tokens of other clients and dpop bound ones are refused.
+	rec = exchange(`["read"]`, claimToken(&token.Claims{Subject: "bob", Audience: "other"}))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+	bound := &token.Claims{Subject: "bob", Audience: "plugin", Confirmation: &token.Confirmation{JKT: "thumbprint"}}
+	rec = exchange(`["read"]`, claimToken(bound))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorInvalidGrant)
+
+	rec = exchange(`["read"]`, bob)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp TokenResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Empty(t, resp.RefreshToken, "rpts are not refreshed")
+	claims, err := uma.ValidateRPT(s.tokens, resp.AccessToken, registered.ID, "read")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", claims.Subject)
+
+	rec = exchange(`["write"]`, bob)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorRequestDenied)
+}
+
+func TestSecondFactor(t *testing.T) {
+	s, h := newTestServer(t)
+	s.SetSecondFactor(fakeSecondFactor{enrolled: "admin"})
+	events := audit.NewMemorySink(10)
+	s.SetEventSink(events)
+	// login returns the id of the request waiting for the second factor.
+	login := func() string {
+		q := url.Values{"response_type": {"code"}, "client_id": {"plugin"}, "scope": {"read"}, "state": {"xyz"},
+			"code_challenge": {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"}, "code_challenge_method": {"S256"}}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?"+q.Encode(), nil))
+		match := _requestIDPattern.FindStringSubmatch(rec.Body.String())
+		assert.Len(t, match, 2)
+		rec = postForm(h, AuthorizePath, url.Values{"request_id": {match[1]}, "username": {"admin"}, "password": {"secret"}})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `name="otp"`)
+		match = _requestIDPattern.FindStringSubmatch(rec.Body.String())
+		assert.Len(t, match, 2)
+		return match[1]
+	}
+
+	rec := postForm(h, AuthorizePath, url.Values{"request_id": {login()}, "otp": {"000000"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Invalid verification code.")
+	match := _requestIDPattern.FindStringSubmatch(rec.Body.String())
+	assert.Len(t, match, 2)
+	rec = postForm(h, AuthorizePath, url.Values{"request_id": {match[1]}, "otp": {"123456"}})
+	assert.Equal(t, http.StatusFound, rec.Code)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, location.Query().Get("code"))
+	// the login is recorded with the second factor, once per attempt.
+	recorded := events.Events()
+	assert.Len(t, recorded, 2)
+	assert.Equal(t, audit.OutcomeFailure, recorded[0].Outcome)
+	assert.Equal(t, audit.OutcomeSuccess, recorded[1].Outcome)
+	assert.Equal(t, true, recorded[1].Detail["mfa"])
+
+	// the pending request can not skip the factor through the consent endpoint.
+	rec = postForm(h, ConsentPath, url.Values{"request_id": {login()}, "decision": {"allow"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestRiskEngine(t *testing.T) {
+	s, h := newTestServer(t)
+	s.SetSecondFactor(fakeSecondFactor{enrolled: "admin"})
+	events := audit.NewMemorySink(10)
+	s.SetEventSink(events)
+	scores := map[string]int{"admin": 60, "bob": 60, "eve": 90}
+	s.SetRiskEngine(risk.New(risk.Config{}, nil, risk.EvaluatorFunc(func(_ context.Context, signals *risk.Signals) (*risk.Assessment, error) {
+		return &risk.Assessment{Score: scores[signals.Subject]}, nil
+	})))
+	login := func(username string) *httptest.ResponseRecorder {
+		q := url.Values{"response_type": {"code"}, "client_id": {"plugin"}, "scope": {"read"}, "state": {"xyz"},
+			"code_challenge": {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"}, "code_challenge_method": {"S256"}}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?"+q.Encode(), nil))
+		match := _requestIDPattern.FindStringSubmatch(rec.Body.String())
+		assert.Len(t, match, 2)
+		return postForm(h, AuthorizePath, url.Values{"request_id": {match[1]}, "username": {username}, "password": {"secret"}})
+	}
+
+	// a low risk login proceeds.
+	rec := login("carol")
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Contains(t, rec.Header().Get("Location"), "code=")
+
+	// step-up asks the enrolled end-user for the second factor.
+	rec = login("admin")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `name="otp"`)
+
+	// and denies the end-user without one, as high risk logins.
+	for _, username := range []string{"bob", "eve"} {
+		rec = login(username)
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Contains(t, rec.Header().Get("Location"), "error="+ErrorAccessDenied)
+	}
+	last := events.Events()[len(events.Events())-1]
+	assert.Equal(t, "eve", last.Subject)
+	assert.Equal(t, audit.OutcomeDenied, last.Outcome)
+}
+
+func TestRedisStateStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	states := NewRedisStateStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "")
+
+	// the login starts on one replica and the end-user comes back to another.
+	first, h1 := newTestServer(t)
+	second, h2 := newTestServer(t)
+	first.SetStateStore(states)
+	second.SetStateStore(states)
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"plugin"},
+		"redirect_uri":          {"https://plugin.example/cb"},
+		"state":                 {"xyz"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+	}
+	rec := httptest.NewRecorder()
+	h1.ServeHTTP(rec, httptest.NewRequest("GET", AuthorizePath+"?"+q.Encode(), nil))
+	match := _requestIDPattern.FindStringSubmatch(rec.Body.String())
+	assert.Len(t, match, 2)
+	form := url.Values{"request_id": {match[1]}, "username": {"admin"}, "password": {"secret"}}
+	rec = postForm(h2, AuthorizePath, form)
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Contains(t, rec.Header().Get("Location"), "code=")
+	rec = postForm(h1, AuthorizePath, form)
+	assert.NotEqual(t, http.StatusFound, rec.Code, "a state is consumed once")
+
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		skew      time.Duration
+		found     bool
+	}{
+		{"valid", time.Now().Add(time.Minute), 0, true},
+		{"expired", time.Now().Add(-time.Minute), 0, false},
+		{"within skew", time.Now().Add(-10 * time.Second), 30 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			states.ClockSkew = tt.skew
+			assert.NoError(t, states.SaveAuthorizeRequest(&AuthorizeRequest{ID: tt.name, ExpiresAt: tt.expiresAt}))
+			req, err := states.ConsumeAuthorizeRequest(tt.name)
+			if !tt.found {
+				assert.ErrorIs(t, err, ErrNotFound)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.name, req.ID)
+			_, err = states.ConsumeAuthorizeRequest(tt.name)
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
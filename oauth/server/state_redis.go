@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var _ StateStore = &RedisStateStore{}
+
+const (
+	_defaultStatePrefix = "oauth:state:"
+	_defaultClockSkew   = 30 * time.Second
+)
+
+// _consumeState gets and deletes KEYS[1] in one step, so only one replica consumes a state.
+var _consumeState = redis.NewScript(`
+local v = redis.call('GET', KEYS[1])
+if v then
+  redis.call('DEL', KEYS[1])
+end
+return v
+`)
+
+// RedisStateStore StateStore shared by all replicas through redis, so the callback may land on
+// another replica than the one which started the login. The expiry is set by the clock of the
+// saving replica and checked by the clock of the consuming one, ClockSkew tolerates their difference.
+type RedisStateStore struct {
+	client redis.UniversalClient
+	prefix string
+	// ClockSkew tolerated difference between the clocks of the replicas. Default to 30s.
+	ClockSkew time.Duration
+}
+
+// NewRedisStateStore returns a RedisStateStore keeping the requests under prefix, default to oauth:state:.
+func NewRedisStateStore(client redis.UniversalClient, prefix string) *RedisStateStore {
+	if prefix == "" {
+		prefix = _defaultStatePrefix
+	}
+	return &RedisStateStore{client: client, prefix: prefix, ClockSkew: _defaultClockSkew}
+}
+
+func (s *RedisStateStore) SaveAuthorizeRequest(req *AuthorizeRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal authorize request %w", err)
+	}
+	ttl := time.Until(req.ExpiresAt) + s.skew()
+	if ttl <= 0 {
+		return nil
+	}
+	if err = s.client.Set(context.Background(), s.prefix+req.ID, data, ttl).Err(); err != nil {
+		return fmt.Errorf("save authorize request %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStateStore) ConsumeAuthorizeRequest(id string) (*AuthorizeRequest, error) {
+	data, err := _consumeState.Run(context.Background(), s.client, []string{s.prefix + id}).Text()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume authorize request %w", err)
+	}
+	req := &AuthorizeRequest{}
+	if err = json.Unmarshal([]byte(data), req); err != nil {
+		return nil, fmt.Errorf("unmarshal authorize request %w", err)
+	}
+	if time.Now().After(req.ExpiresAt.Add(s.skew())) {
+		return nil, ErrNotFound
+	}
+	return req, nil
+}
+
+func (s *RedisStateStore) skew() time.Duration {
+	if s.ClockSkew < 0 {
+		return 0
+	}
+	return s.ClockSkew
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+)
+
+var (
+	_ Storage = &MemoryStorage{}
+
+	// ErrNotFound the record does not exist or expired.
+	ErrNotFound = errors.New("oauth record not found")
+)
+
+// AuthorizeRequest a validated authorization request waiting for the end-user.
+type AuthorizeRequest struct {
+	ID           string `json:"id"`
+	ClientID     string `json:"client_id"`
+	ResponseType string `json:"response_type"`
+	RedirectURI  string `json:"redirect_uri"`
+	// RedirectURIRequested the redirect uri was a parameter of the request rather than the
+	// only registered one, the token request must then repeat it.
+	RedirectURIRequested bool      `json:"redirect_uri_requested,omitempty"`
+	Scope                string    `json:"scope"`
+	State                string    `json:"state"`
+	Nonce                string    `json:"nonce"`
+	CodeChallenge        string    `json:"code_challenge"`
+	CodeChallengeMethod  string    `json:"code_challenge_method"`
+	Provider             string    `json:"provider"`
+	ExpiresAt            time.Time `json:"expires_at"`
+	// Claims of the authenticated end-user, set once the request waits for consent.
+	Claims *token.Claims `json:"claims,omitempty"`
+	// AuthTime when the end-user authenticated.
+	AuthTime time.Time `json:"auth_time,omitempty"`
+	// SecondFactorPending the end-user passed the password and must still present the second factor.
+	SecondFactorPending bool `json:"second_factor_pending,omitempty"`
+}
+
+// AuthorizationCode an issued code, stored under the hash of the code.
+type AuthorizationCode struct {
+	Signature string            `json:"signature"`
+	Request   *AuthorizeRequest `json:"request"`
+	Claims    *token.Claims     `json:"claims"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// RefreshToken an issued refresh token, stored under the hash of the token.
+type RefreshToken struct {
+	Signature string        `json:"signature"`
+	ClientID  string        `json:"client_id"`
+	Scope     string        `json:"scope"`
+	Claims    *token.Claims `json:"claims"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	// JKT thumbprint of the DPoP key refresh tokens of public clients are bound to.
+	JKT string `json:"jkt,omitempty"`
+	// AccessTokens issued with the refresh token and its rotated predecessors, they are revoked
+	// along with it.
+	AccessTokens []IssuedAccessToken `json:"access_tokens,omitempty"`
+}
+
+// IssuedAccessToken an access token issued with a refresh token.
+type IssuedAccessToken struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// StateStore keeps the pending authorization requests, whose ids are the state passed to the
+// identity providers, from the redirect until the end-user comes back. Replicas behind a load
+// balancer must share it, see RedisStateStore.
+type StateStore interface {
+	SaveAuthorizeRequest(req *AuthorizeRequest) error
+	// ConsumeAuthorizeRequest returns and deletes the pending request, a request is consumed at most once.
+	ConsumeAuthorizeRequest(id string) (*AuthorizeRequest, error)
+}
+
+// Storage persists the authorization server state.
+type Storage interface {
+	StateStore
+	SaveAuthorizationCode(code *AuthorizationCode) error
+	// ConsumeAuthorizationCode returns and deletes the code, codes are single use.
+	ConsumeAuthorizationCode(signature string) (*AuthorizationCode, error)
+	SaveRefreshToken(rt *RefreshToken) error
+	LoadRefreshToken(signature string) (*RefreshToken, error)
+	// ConsumeRefreshToken returns and deletes the refresh token, a refresh token is consumed at
+	// most once.
+	ConsumeRefreshToken(signature string) (*RefreshToken, error)
+	DeleteRefreshToken(signature string) error
+	// RevokeTenant deletes all refresh tokens of the tenant, e.g. when it is offboarded.
+	RevokeTenant(tenantID string) error
+}
+
+// MemoryStorage in-process Storage, suitable for a single replica or tests.
+type MemoryStorage struct {
+	lock     sync.Mutex
+	requests map[string]*AuthorizeRequest
+	codes    map[string]*AuthorizationCode
+	refresh  map[string]*RefreshToken
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		requests: make(map[string]*AuthorizeRequest),
+		codes:    make(map[string]*AuthorizationCode),
+		refresh:  make(map[string]*RefreshToken),
+	}
+}
+
+func (s *MemoryStorage) SaveAuthorizeRequest(req *AuthorizeRequest) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests[req.ID] = req
+	return nil
+}
+
+func (s *MemoryStorage) ConsumeAuthorizeRequest(id string) (*AuthorizeRequest, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	req, ok := s.requests[id]
+	delete(s.requests, id)
+	if !ok || time.Now().After(req.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return req, nil
+}
+
+func (s *MemoryStorage) SaveAuthorizationCode(code *AuthorizationCode) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.codes[code.Signature] = code
+	return nil
+}
+
+func (s *MemoryStorage) ConsumeAuthorizationCode(signature string) (*AuthorizationCode, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	code, ok := s.codes[signature]
+	delete(s.codes, signature)
+	if !ok || time.Now().After(code.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return code, nil
+}
+
+func (s *MemoryStorage) SaveRefreshToken(rt *RefreshToken) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.refresh[rt.Signature] = rt
+	return nil
+}
+
+func (s *MemoryStorage) LoadRefreshToken(signature string) (*RefreshToken, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rt, ok := s.refresh[signature]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		delete(s.refresh, signature)
+		return nil, ErrNotFound
+	}
+	return rt, nil
+}
+
+func (s *MemoryStorage) ConsumeRefreshToken(signature string) (*RefreshToken, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rt, ok := s.refresh[signature]
+	delete(s.refresh, signature)
+	if !ok || time.Now().After(rt.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return rt, nil
+}
+
+func (s *MemoryStorage) DeleteRefreshToken(signature string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.refresh, signature)
+	return nil
+}
+
+// ListRefreshTokens returns the unexpired refresh tokens of the tenant, of subject unless it is
+// empty, oldest expiry first. Empty tenantID lists all tenants.
+func (s *MemoryStorage) ListRefreshTokens(tenantID, subject string) ([]*RefreshToken, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	tokens := make([]*RefreshToken, 0)
+	for _, rt := range s.refresh {
+		c := rt.Claims
+		if c == nil || now.After(rt.ExpiresAt) ||
+			(tenantID != "" && c.TenantID != tenantID) || (subject != "" && c.Subject != subject) {
+			continue
+		}
+		tokens = append(tokens, rt)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ExpiresAt.Before(tokens[j].ExpiresAt) })
+	return tokens, nil
+}
+
+// RevokeSubject deletes all refresh tokens of subject in the tenant, e.g. after a password reset.
+func (s *MemoryStorage) RevokeSubject(tenantID, subject string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for signature, rt := range s.refresh {
+		if c := rt.Claims; c != nil && c.TenantID == tenantID && c.Subject == subject {
+			delete(s.refresh, signature)
+		}
+	}
+	return nil
+}
+
+// RevokeTenant deletes all refresh tokens of the tenant, e.g. when it is offboarded.
+func (s *MemoryStorage) RevokeTenant(tenantID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for signature, rt := range s.refresh {
+		if rt.Claims != nil && rt.Claims.TenantID == tenantID {
+			delete(s.refresh, signature)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authn/token/dpop"
+	"github.com/tkeel-io/security/utils"
+)
+
+// TokenResponse successful token endpoint response.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// HandleToken the token endpoint, see https://datatracker.ietf.org/doc/html/rfc6749#section-3.2
+func (s *Server) HandleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, errInvalidRequest("token requests must use POST"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, errInvalidRequest(err.Error()))
+		return
+	}
+	client, oerr := s.authenticateClient(r)
+	if oerr != nil {
+		writeError(w, oerr)
+		return
+	}
+	grantType := r.PostForm.Get("grant_type")
+	if !client.AllowsGrantType(grantType) {
+		writeError(w, newError(http.StatusBadRequest, ErrorUnauthorizedClient, "client may not use grant type "+grantType))
+		return
+	}
+
+	// jkt the DPoP key the issued tokens are bound to, empty for bearer tokens.
+	var jkt string
+	if s.dpop != nil {
+		if nonce := s.dpop.Nonce(); nonce != "" {
+			w.Header().Set(dpop.HeaderNonce, nonce)
+		}
+	}
+	if proof := r.Header.Get(dpop.HeaderDPoP); proof != "" && s.dpop != nil {
+		var err error
+		jkt, err = s.dpop.Validate(proof, r.Method, s.conf.Issuer+TokenPath, "")
+		switch {
+		case errors.Is(err, dpop.ErrUseNonce):
+			writeError(w, newError(http.StatusBadRequest, dpop.ErrorUseNonce, err.Error()))
+			return
+		case err != nil:
+			writeError(w, newError(http.StatusBadRequest, dpop.ErrorInvalidProof, err.Error()))
+			return
+		}
+	}
+
+	var resp *TokenResponse
+	switch grantType {
+	case GrantTypeAuthorizationCode:
+		resp, oerr = s.exchangeCode(r, client, jkt)
+	case GrantTypeRefreshToken:
+		resp, oerr = s.refresh(r, client, jkt)
+	case GrantTypeDeviceCode:
+		resp, oerr = s.exchangeDeviceCode(r, client, jkt)
+	case GrantTypeUMATicket:
+		resp, oerr = s.exchangeUMATicket(r, client, jkt)
+	default:
+		oerr = newError(http.StatusBadRequest, ErrorUnsupportedGrantType, grantType)
+	}
+	if oerr != nil {
+		writeError(w, oerr)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) exchangeCode(r *http.Request, client *Client, jkt string) (*TokenResponse, *Error) {
+	code, err := s.storage.ConsumeAuthorizationCode(token.HashToken(r.PostForm.Get("code")))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, errInvalidGrant("invalid authorization code")
+		}
+		return nil, errServer(err)
+	}
+	req := code.Request
+	if req.ClientID != client.ID {
+		return nil, errInvalidGrant("authorization code was issued to another client")
+	}
+	// the redirect uri is required when the authorization request had one, see
+	// https://www.rfc-editor.org/rfc/rfc6749#section-4.1.3.
+	if redirectURI := r.PostForm.Get("redirect_uri"); (redirectURI != "" || req.RedirectURIRequested) && redirectURI != req.RedirectURI {
+		return nil, errInvalidGrant("redirect_uri mismatch")
+	}
+	if !verifyCodeChallenge(req.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		return nil, errInvalidGrant("code_verifier mismatch")
+	}
+	resp, oerr := s.issue(client, code.Claims, req.Scope, jkt)
+	if oerr != nil {
+		return nil, oerr
+	}
+	if s.openid != nil && utils.StringsInclude(strings.Fields(req.Scope), ScopeOpenID) {
+		if resp.IDToken, err = s.issueIDToken(client, req, code.Claims); err != nil {
+			return nil, errServer(err)
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) refresh(r *http.Request, client *Client, jkt string) (*TokenResponse, *Error) {
+	signature := token.HashToken(r.PostForm.Get("refresh_token"))
+	rt, err := s.storage.LoadRefreshToken(signature)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, errInvalidGrant("invalid refresh token")
+		}
+		return nil, errServer(err)
+	}
+	if rt.ClientID != client.ID {
+		return nil, errInvalidGrant("refresh token was issued to another client")
+	}
+	if rt.JKT != "" && rt.JKT != jkt {
+		return nil, errInvalidGrant("refresh token is bound to another dpop key")
+	}
+	if s.tenants != nil && rt.Claims != nil && rt.Claims.TenantID != "" {
+		exists, err := s.tenants.Exists(r.Context(), rt.Claims.TenantID)
+		if err != nil {
+			return nil, errServer(err)
+		}
+		if !exists {
+			return nil, errInvalidGrant("tenant of the refresh token no longer exists")
+		}
+	}
+	scope := rt.Scope
+	if requested := r.PostForm.Get("scope"); requested != "" {
+		granted := strings.Fields(rt.Scope)
+		for _, sc := range strings.Fields(requested) {
+			if !utils.StringsInclude(granted, sc) {
+				return nil, newError(http.StatusBadRequest, ErrorInvalidScope, "scope exceeds the original grant")
+			}
+		}
+		scope = requested
+	}
+	// refresh tokens rotate, the presented one is spent. Of concurrent refreshes with the same
+	// token only the one consuming it succeeds.
+	if _, err = s.storage.ConsumeRefreshToken(signature); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, errInvalidGrant("invalid refresh token")
+		}
+		return nil, errServer(err)
+	}
+	return s.issueRefreshed(client, rt.Claims, scope, jkt, rt.AccessTokens)
+}
+
+// issue returns a new access token and, when the client may refresh, a refresh token.
+// The tokens are bound to the DPoP key jkt unless it is empty.
+func (s *Server) issue(client *Client, subject *token.Claims, scope, jkt string) (*TokenResponse, *Error) {
+	return s.issueRefreshed(client, subject, scope, jkt, nil)
+}
+
+// issueRefreshed is issue for a refresh, the refresh token inherits the unexpired access tokens of
+// the one it rotates so revoking it revokes them all.
+func (s *Server) issueRefreshed(client *Client, subject *token.Claims, scope, jkt string, previous []IssuedAccessToken) (*TokenResponse, *Error) {
+	resp, claims, oerr := s.issueAccessToken(client, subject, scope, jkt)
+	if oerr != nil {
+		return nil, oerr
+	}
+	if client.AllowsGrantType(GrantTypeRefreshToken) {
+		now := time.Now()
+		issued := make([]IssuedAccessToken, 0, len(previous)+1)
+		for _, at := range previous {
+			if now.Before(at.ExpiresAt) {
+				issued = append(issued, at)
+			}
+		}
+		if claims.ID != "" {
+			issued = append(issued, IssuedAccessToken{ID: claims.ID, ExpiresAt: time.Unix(claims.ExpiresAt, 0)})
+		}
+		refreshToken, signature, err := newSecret()
+		if err != nil {
+			return nil, errServer(err)
+		}
+		rt := &RefreshToken{
+			Signature:    signature,
+			ClientID:     client.ID,
+			Scope:        scope,
+			Claims:       subject,
+			ExpiresAt:    now.Add(s.conf.RefreshTokenTTL),
+			AccessTokens: issued,
+		}
+		// confidential clients authenticate, only public clients need sender constrained refresh tokens.
+		if client.Public() {
+			rt.JKT = jkt
+		}
+		err = s.storage.SaveRefreshToken(rt)
+		if err != nil {
+			return nil, errServer(err)
+		}
+		resp.RefreshToken = refreshToken
+	}
+	return resp, nil
+}
+
+// issueAccessToken returns a new access token and its claims, bound to the DPoP key jkt unless it is empty.
+func (s *Server) issueAccessToken(client *Client, subject *token.Claims, scope, jkt string) (*TokenResponse, *token.Claims, *Error) {
+	claims := *subject
+	claims.ID, claims.IssuedAt, claims.ExpiresAt = "", 0, 0
+	claims.Issuer = s.conf.Issuer
+	claims.Audience = client.ID
+	claims.Scope = scope
+	claims.Confirmation = nil
+	tokenType := "Bearer"
+	if jkt != "" {
+		claims.Confirmation = &token.Confirmation{JKT: jkt}
+		tokenType = dpop.SchemeDPoP
+	}
+	accessToken, err := s.tokens.Issue(&claims)
+	if err != nil {
+		return nil, nil, errServer(err)
+	}
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   tokenType,
+		ExpiresIn:   claims.ExpiresAt - time.Now().Unix(),
+		Scope:       scope,
+	}, &claims, nil
+}
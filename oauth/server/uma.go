@@ -0,0 +1,269 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/uma"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	// UMAResourceSetPath resource registration endpoint, a resource at UMAResourceSetPath/{id}.
+	// See also, https://docs.kantarainitiative.org/uma/wg/rec-oauth-uma-federated-authz-2.0.html#resource-registration-endpoint
+	UMAResourceSetPath = "/uma/resource_set"
+	// UMAPermissionPath permission endpoint, resource servers ask for permission tickets here.
+	UMAPermissionPath = "/uma/permission"
+	// UMASharesPath owners share their resources with requesting parties here.
+	UMASharesPath = "/uma/shares"
+
+	// ScopeUMAProtection the scope of a protection API token (PAT), the access token a resource
+	// owner grants its resource server to call the protection API.
+	ScopeUMAProtection = "uma_protection"
+
+	// GrantTypeUMATicket the UMA grant trading a permission ticket for an RPT.
+	GrantTypeUMATicket = "urn:ietf:params:oauth:grant-type:uma-ticket"
+
+	// ErrorRequestDenied the owners did not share the permissions of the ticket.
+	ErrorRequestDenied = "request_denied"
+)
+
+// EnableUMA turns on the UMA grant and the protection API of authz. The protection API is
+// authenticated with PATs, access tokens of the resource owners carrying the uma_protection scope.
+func (s *Server) EnableUMA(authz *uma.Service) {
+	s.uma = authz
+}
+
+// HandleUMAResourceSet registers, lists, reads, updates and deletes the resources of the bearer.
+func (s *Server) HandleUMAResourceSet(w http.ResponseWriter, r *http.Request) {
+	if s.uma == nil {
+		writeError(w, newError(http.StatusNotFound, ErrorInvalidRequest, "uma is disabled"))
+		return
+	}
+	claims, ok := s.authenticatePAT(w, r)
+	if !ok {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, UMAResourceSetPath), "/")
+	ctx := r.Context()
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		resources, err := s.uma.ListResources(ctx, claims)
+		if err != nil {
+			writeError(w, errServer(err))
+			return
+		}
+		ids := make([]string, 0, len(resources))
+		for _, resource := range resources {
+			ids = append(ids, resource.ID)
+		}
+		writeJSON(w, http.StatusOK, ids)
+	case id == "" && r.Method == http.MethodPost:
+		var resource uma.Resource
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			writeError(w, errInvalidRequest(err.Error()))
+			return
+		}
+		resource.Owner, resource.TenantID = claims.Subject, claims.TenantID
+		registered, err := s.uma.RegisterResource(ctx, &resource)
+		if err != nil {
+			writeError(w, umaError(err))
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"_id": registered.ID})
+	case id != "" && r.Method == http.MethodGet:
+		resource, err := s.uma.GetResource(ctx, claims, id)
+		if err != nil {
+			writeError(w, umaError(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, resource)
+	case id != "" && r.Method == http.MethodPut:
+		var resource uma.Resource
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			writeError(w, errInvalidRequest(err.Error()))
+			return
+		}
+		resource.ID = id
+		if err := s.uma.UpdateResource(ctx, claims, &resource); err != nil {
+			writeError(w, umaError(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"_id": id})
+	case id != "" && r.Method == http.MethodDelete:
+		if err := s.uma.DeleteResource(ctx, claims, id); err != nil {
+			writeError(w, umaError(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleUMAPermission issues a permission ticket for one permission or an array of them.
+func (s *Server) HandleUMAPermission(w http.ResponseWriter, r *http.Request) {
+	if s.uma == nil {
+		writeError(w, newError(http.StatusNotFound, ErrorInvalidRequest, "uma is disabled"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, errInvalidRequest("permission requests must use POST"))
+		return
+	}
+	claims, ok := s.authenticatePAT(w, r)
+	if !ok {
+		return
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeError(w, errInvalidRequest(err.Error()))
+		return
+	}
+	var permissions []uma.Permission
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "{") {
+		permissions = make([]uma.Permission, 1)
+		if err := json.Unmarshal(raw, &permissions[0]); err != nil {
+			writeError(w, errInvalidRequest(err.Error()))
+			return
+		}
+	} else if err := json.Unmarshal(raw, &permissions); err != nil {
+		writeError(w, errInvalidRequest(err.Error()))
+		return
+	}
+	ticket, err := s.uma.CreateTicket(r.Context(), claims, permissions)
+	if err != nil {
+		writeError(w, umaError(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"ticket": ticket})
+}
+
+type shareRequest struct {
+	ResourceID string   `json:"resource_id"`
+	Requester  string   `json:"requester"`
+	Scopes     []string `json:"scopes"`
+}
+
+// HandleUMAShares lets the bearer list, add and remove the shares of its resources.
+func (s *Server) HandleUMAShares(w http.ResponseWriter, r *http.Request) {
+	if s.uma == nil {
+		writeError(w, newError(http.StatusNotFound, ErrorInvalidRequest, "uma is disabled"))
+		return
+	}
+	claims, ok := s.authenticatePAT(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		shares, err := s.uma.ListShares(ctx, claims, q.Get("resource_id"))
+		if err != nil {
+			writeError(w, umaError(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, shares)
+	case http.MethodPost:
+		var req shareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, errInvalidRequest(err.Error()))
+			return
+		}
+		if err := s.uma.Share(ctx, claims, req.ResourceID, req.Requester, req.Scopes); err != nil {
+			writeError(w, umaError(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := s.uma.Unshare(ctx, claims, q.Get("resource_id"), q.Get("requester")); err != nil {
+			writeError(w, umaError(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// authenticatePAT verifies the bearer is a PAT, an access token granted the uma_protection scope,
+// so ordinary access tokens held by third party clients can not manage the resources of their user.
+func (s *Server) authenticatePAT(w http.ResponseWriter, r *http.Request) (*token.Claims, bool) {
+	claims, ok := s.authenticateBearer(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !utils.StringsInclude(strings.Fields(claims.Scope), ScopeUMAProtection) {
+		insufficientScope(w, ScopeUMAProtection)
+		return nil, false
+	}
+	return claims, true
+}
+
+// exchangeUMATicket issues an RPT to the requesting party of claim_token, a bearer access token
+// this server issued to the client, for the permissions of the ticket. RPTs are not refreshed, so a share removed
+// by its owner ends with the RPT.
+func (s *Server) exchangeUMATicket(r *http.Request, client *Client, jkt string) (*TokenResponse, *Error) {
+	if s.uma == nil {
+		return nil, newError(http.StatusBadRequest, ErrorUnsupportedGrantType, GrantTypeUMATicket)
+	}
+	ticket := r.PostForm.Get("ticket")
+	if ticket == "" {
+		return nil, errInvalidRequest("ticket required")
+	}
+	claimToken := r.PostForm.Get("claim_token")
+	if claimToken == "" {
+		return nil, errInvalidRequest("claim_token required")
+	}
+	requester, err := s.tokens.Verify(claimToken)
+	if err != nil {
+		return nil, errInvalidGrant("invalid claim_token")
+	}
+	// the claim token must have been issued to the client, and a DPoP bound one can't be proven
+	// here, so neither another client's token nor a stolen bound token buys an RPT.
+	if requester.Audience != client.ID {
+		return nil, errInvalidGrant("claim_token was not issued to the client")
+	}
+	if requester.Confirmation != nil && requester.Confirmation.JKT != "" {
+		return nil, errInvalidGrant("dpop bound claim_token")
+	}
+	permissions, err := s.uma.Authorize(r.Context(), ticket, requester)
+	if err != nil {
+		return nil, umaError(err)
+	}
+	resp, _, oerr := s.issueAccessToken(client, uma.RPTClaims(requester, permissions), "", jkt)
+	return resp, oerr
+}
+
+func umaError(err error) *Error {
+	switch {
+	case errors.Is(err, uma.ErrNotFound):
+		return newError(http.StatusNotFound, "not_found", "")
+	case errors.Is(err, uma.ErrInvalidResource):
+		return errInvalidRequest(err.Error())
+	case errors.Is(err, uma.ErrInvalidScope):
+		return newError(http.StatusBadRequest, ErrorInvalidScope, err.Error())
+	case errors.Is(err, uma.ErrInvalidTicket):
+		return errInvalidGrant(err.Error())
+	case errors.Is(err, uma.ErrRequestDenied):
+		return newError(http.StatusForbidden, ErrorRequestDenied, err.Error())
+	}
+	return errServer(err)
+}
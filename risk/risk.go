@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package risk scores logins from their request metadata, so anomalous ones can be forced
+// through step-up authentication or denied. Integrators plug their signals, e.g. impossible
+// travel or device fingerprints, in as Evaluators.
+package risk
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/tkeel-io/security/log"
+)
+
+// Actions decided from the score of a login.
+const (
+	ActionAllow  = "allow"
+	ActionStepUp = "step_up"
+	ActionDeny   = "deny"
+
+	// MaxScore the score of a login certainly not made by its subject.
+	MaxScore = 100
+
+	_defaultStepUpScore = 50
+	_defaultDenyScore   = 80
+)
+
+// Signals the metadata of a login.
+type Signals struct {
+	Time     time.Time
+	Subject  string
+	TenantID string
+	// Provider key of the identity provider the subject authenticated with.
+	Provider  string
+	IP        net.IP
+	UserAgent string
+	// Country ISO code of IP, empty when unknown or without a Locator.
+	Country string
+	// ASN and ASOrg the autonomous system of IP, 0 and empty when unknown or without an ASNLocator.
+	ASN   uint32
+	ASOrg string
+}
+
+// Assessment the score an Evaluator gives a login, from 0 to MaxScore.
+type Assessment struct {
+	Score int
+	// Reasons short machine readable causes, e.g. impossible_travel.
+	Reasons []string
+}
+
+// Evaluator scores logins. Evaluators keeping history, e.g. the last location of subjects,
+// record the login while evaluating it.
+type Evaluator interface {
+	Evaluate(ctx context.Context, s *Signals) (*Assessment, error)
+}
+
+// EvaluatorFunc adapts a func to an Evaluator.
+type EvaluatorFunc func(ctx context.Context, s *Signals) (*Assessment, error)
+
+func (f EvaluatorFunc) Evaluate(ctx context.Context, s *Signals) (*Assessment, error) {
+	return f(ctx, s)
+}
+
+// Locator returns the ISO country code of ip, empty when unknown.
+type Locator interface {
+	Country(ip net.IP) string
+}
+
+// ASNLocator a Locator also returning the number and organization of the autonomous system of
+// ip, e.g. a geoip.Resolver, to score logins from hosting providers or anonymizing networks.
+type ASNLocator interface {
+	Locator
+	ASN(ip net.IP) (uint32, string)
+}
+
+// Config of the risk Engine.
+type Config struct {
+	// StepUpScore score from which the login needs a second factor. Default to 50.
+	StepUpScore int `mapstructure:"step_up_score" json:"step_up_score" yaml:"stepUpScore"`
+	// DenyScore score from which the login is denied. Default to 80.
+	DenyScore int `mapstructure:"deny_score" json:"deny_score" yaml:"denyScore"`
+	// FailOpen ignores evaluators returning errors, by default the login is denied.
+	FailOpen bool `mapstructure:"fail_open" json:"fail_open" yaml:"failOpen"`
+}
+
+// Decision the action taken for a login.
+type Decision struct {
+	Action string
+	// Score the highest score of the evaluators.
+	Score   int
+	Reasons []string
+}
+
+// Engine runs the evaluators on logins and decides their action.
+type Engine struct {
+	conf       Config
+	locator    Locator
+	evaluators []Evaluator
+	clientIP   func(r *http.Request) net.IP
+	logger     *log.Helper
+}
+
+// New returns an Engine taking the highest score of evaluators, locator may be nil.
+func New(conf Config, locator Locator, evaluators ...Evaluator) *Engine {
+	if conf.StepUpScore <= 0 {
+		conf.StepUpScore = _defaultStepUpScore
+	}
+	if conf.DenyScore <= 0 {
+		conf.DenyScore = _defaultDenyScore
+	}
+	return &Engine{conf: conf, locator: locator, evaluators: evaluators, clientIP: peerIP}
+}
+
+// SetLogger writes the entries of the assessments to l instead of the global logger.
+func (e *Engine) SetLogger(l log.Logger) {
+	e.logger = log.NewHelper(l)
+}
+
+// SetClientIP takes the ip of logins from resolve instead of the peer address, e.g. the
+// ClientIP of ipfilter.Proxies when the service runs behind proxies.
+func (e *Engine) SetClientIP(resolve func(r *http.Request) net.IP) {
+	e.clientIP = resolve
+}
+
+// Signals returns the signals of the login of subject made with r.
+func (e *Engine) Signals(r *http.Request, provider, subject, tenantID string) *Signals {
+	s := &Signals{
+		Time:      time.Now(),
+		Subject:   subject,
+		TenantID:  tenantID,
+		Provider:  provider,
+		IP:        e.clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if e.locator != nil && s.IP != nil {
+		s.Country = e.locator.Country(s.IP)
+		if asn, ok := e.locator.(ASNLocator); ok {
+			s.ASN, s.ASOrg = asn.ASN(s.IP)
+		}
+	}
+	return s
+}
+
+// Assess runs all evaluators on s and decides the action from the highest score.
+// Failing evaluators deny the login unless Config.FailOpen is set.
+func (e *Engine) Assess(ctx context.Context, s *Signals) *Decision {
+	d := &Decision{Action: ActionAllow}
+	for _, evaluator := range e.evaluators {
+		a, err := evaluator.Evaluate(ctx, s)
+		if err != nil {
+			e.logger.Errorf("risk evaluate login of %s: %s", s.Subject, err)
+			if e.conf.FailOpen {
+				continue
+			}
+			a = &Assessment{Score: MaxScore, Reasons: []string{"evaluation_failed"}}
+		}
+		if a == nil {
+			continue
+		}
+		if a.Score > d.Score {
+			d.Score = a.Score
+		}
+		d.Reasons = append(d.Reasons, a.Reasons...)
+	}
+	switch {
+	case d.Score >= e.conf.DenyScore:
+		d.Action = ActionDeny
+	case d.Score >= e.conf.StepUpScore:
+		d.Action = ActionStepUp
+	}
+	return d
+}
+
+func peerIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package risk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/middleware/ipfilter"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countryFunc func(ip net.IP) string
+
+func (f countryFunc) Country(ip net.IP) string { return f(ip) }
+
+func score(n int, reason string) Evaluator {
+	return EvaluatorFunc(func(context.Context, *Signals) (*Assessment, error) {
+		return &Assessment{Score: n, Reasons: []string{reason}}, nil
+	})
+}
+
+func TestAssess(t *testing.T) {
+	failing := EvaluatorFunc(func(context.Context, *Signals) (*Assessment, error) {
+		return nil, errors.New("geo service down")
+	})
+	tests := []struct {
+		name       string
+		conf       Config
+		evaluators []Evaluator
+		action     string
+		score      int
+	}{
+		{"no evaluators", Config{}, nil, ActionAllow, 0},
+		{"low", Config{}, []Evaluator{score(10, "new_device")}, ActionAllow, 10},
+		{"highest wins", Config{}, []Evaluator{score(10, "new_device"), score(55, "new_country")}, ActionStepUp, 55},
+		{"deny", Config{}, []Evaluator{score(85, "impossible_travel")}, ActionDeny, 85},
+		{"custom thresholds", Config{StepUpScore: 20, DenyScore: 40}, []Evaluator{score(30, "new_country")}, ActionStepUp, 30},
+		{"fail closed", Config{}, []Evaluator{failing}, ActionDeny, MaxScore},
+		{"fail open", Config{FailOpen: true}, []Evaluator{failing, score(10, "new_device")}, ActionAllow, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(tt.conf, nil, tt.evaluators...).Assess(context.Background(), &Signals{Subject: "alice"})
+			assert.Equal(t, tt.action, d.Action)
+			assert.Equal(t, tt.score, d.Score)
+		})
+	}
+}
+
+func TestSignals(t *testing.T) {
+	r := httptest.NewRequest("POST", "/oauth/authorize", nil)
+	r.RemoteAddr = "203.0.113.9:4711"
+	r.Header.Set("User-Agent", "curl/7.79")
+	e := New(Config{}, countryFunc(func(net.IP) string { return "FR" }))
+	s := e.Signals(r, "ldap", "alice", "t1")
+	assert.Equal(t, "203.0.113.9", s.IP.String())
+	assert.Equal(t, "curl/7.79", s.UserAgent)
+	assert.Equal(t, "FR", s.Country)
+	assert.Equal(t, "ldap", s.Provider)
+
+	// behind a trusted proxy the client is the forwarded address.
+	proxies, err := ipfilter.NewProxies([]string{"10.0.0.0/8"})
+	assert.NoError(t, err)
+	e.SetClientIP(proxies.ClientIP)
+	r.RemoteAddr = "10.0.0.2:4711"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	assert.Equal(t, "198.51.100.7", e.Signals(r, "ldap", "alice", "t1").IP.String())
+}
+
+func TestVelocity(t *testing.T) {
+	v := NewVelocity(2, time.Minute, 60)
+	now := time.Now()
+	scores := make([]int, 0)
+	for _, offset := range []time.Duration{0, time.Second, 2 * time.Second, 2 * time.Minute} {
+		a, err := v.Evaluate(context.Background(), &Signals{Time: now.Add(offset), Subject: "alice", TenantID: "t1"})
+		assert.NoError(t, err)
+		scores = append(scores, a.Score)
+	}
+	assert.Equal(t, []int{0, 0, 60, 0}, scores)
+
+	a, err := v.Evaluate(context.Background(), &Signals{Time: now, Subject: "alice", TenantID: "t2"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, a.Score)
+}
+
+func TestVelocitySweep(t *testing.T) {
+	v := NewVelocity(2, time.Minute, 60)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		_, err := v.Evaluate(context.Background(), &Signals{Time: now.Add(time.Duration(i) * time.Millisecond), Subject: "alice", TenantID: "t1"})
+		assert.NoError(t, err)
+	}
+	_, err := v.Evaluate(context.Background(), &Signals{Time: now.Add(time.Second), Subject: "bob", TenantID: "t1"})
+	assert.NoError(t, err)
+	assert.Len(t, v.logins, 2, "no sweep within the window")
+	_, err = v.Evaluate(context.Background(), &Signals{Time: now.Add(2 * time.Minute), Subject: "carol", TenantID: "t1"})
+	assert.NoError(t, err)
+	assert.Len(t, v.logins, 1)
+}
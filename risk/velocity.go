@@ -0,0 +1,71 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package risk
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var _ Evaluator = &Velocity{}
+
+// Velocity scores subjects logging in more often than limit times in window, e.g. a session
+// farm or a script replaying stolen credentials.
+type Velocity struct {
+	limit     int
+	window    time.Duration
+	score     int
+	lock      sync.Mutex
+	logins    map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewVelocity returns a Velocity giving score to logins over limit in window.
+func NewVelocity(limit int, window time.Duration, score int) *Velocity {
+	return &Velocity{limit: limit, window: window, score: score, logins: make(map[string][]time.Time)}
+}
+
+func (v *Velocity) Evaluate(_ context.Context, s *Signals) (*Assessment, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	key := s.TenantID + "/" + s.Subject
+	since := s.Time.Add(-v.window)
+	recent := v.logins[key][:0]
+	for _, t := range v.logins[key] {
+		if t.After(since) {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, s.Time)
+	v.logins[key] = recent
+	v.sweep(s.Time, since)
+	if len(recent) > v.limit {
+		return &Assessment{Score: v.score, Reasons: []string{"velocity"}}, nil
+	}
+	return &Assessment{}, nil
+}
+
+// sweep forgets the subjects idle since, at most once per window.
+func (v *Velocity) sweep(now, since time.Time) {
+	if now.Sub(v.lastSweep) < v.window {
+		return
+	}
+	v.lastSweep = now
+	for k, logins := range v.logins {
+		if !logins[len(logins)-1].After(since) {
+			delete(v.logins, k)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+var _ Provider = &Kubernetes{}
+
+// KubernetesConfig of the Kubernetes Secret provider, the defaults fit a pod.
+type KubernetesConfig struct {
+	// Host API server URL. Default to the in-cluster service.
+	Host string `mapstructure:"host" json:"host" yaml:"host"`
+	// Namespace of references naming only the secret. Default to the namespace of the pod.
+	Namespace string `mapstructure:"namespace" json:"namespace" yaml:"namespace"`
+	// TokenFile and CAFile default to the service account credentials of the pod.
+	TokenFile string `mapstructure:"token_file" json:"token_file" yaml:"tokenFile"`
+	CAFile    string `mapstructure:"ca_file" json:"ca_file" yaml:"caFile"`
+}
+
+// Kubernetes reads Secrets, k8s://namespace/name#key or k8s://name#key in the default namespace.
+// The service account needs get on the secrets.
+type Kubernetes struct {
+	conf   KubernetesConfig
+	client *http.Client
+}
+
+// NewKubernetes returns a Kubernetes provider.
+func NewKubernetes(conf KubernetesConfig) (*Kubernetes, error) {
+	if conf.Host == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" {
+			return nil, fmt.Errorf("kubernetes host not configured and not running in a pod")
+		}
+		conf.Host = "https://" + net.JoinHostPort(host, port)
+	}
+	if conf.TokenFile == "" {
+		conf.TokenFile = _serviceAccountDir + "/token"
+	}
+	if conf.CAFile == "" {
+		conf.CAFile = _serviceAccountDir + "/ca.crt"
+	}
+	if conf.Namespace == "" {
+		ns, err := os.ReadFile(_serviceAccountDir + "/namespace")
+		if err != nil {
+			ns = []byte("default")
+		}
+		conf.Namespace = strings.TrimSpace(string(ns))
+	}
+	ca, err := os.ReadFile(conf.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes ca %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("kubernetes ca %s holds no certificate", conf.CAFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &Kubernetes{conf: conf, client: &http.Client{Transport: transport, Timeout: utils.DefaultHTTPTimeout}}, nil
+}
+
+func (k *Kubernetes) Get(ctx context.Context, path string) (map[string]string, error) {
+	namespace, name := k.conf.Namespace, path
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		namespace, name = path[:i], path[i+1:]
+	}
+	// the token is read per request, kubernetes rotates projected tokens.
+	token, err := os.ReadFile(k.conf.TokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("service account token %w", err)
+	}
+	endpoint := strings.TrimSuffix(k.conf.Host, "/") + "/api/v1/namespaces/" + url.PathEscape(namespace) + "/secrets/" + url.PathEscape(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes request %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("secret %s/%s: %w", namespace, name, ErrSecretNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes get secret %s/%s: status %d", namespace, name, resp.StatusCode)
+	}
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("kubernetes response %w", err)
+	}
+	values := make(map[string]string, len(secret.Data))
+	for key, encoded := range secret.Data {
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes secret %s/%s key %s %w", namespace, name, key, err)
+		}
+		values[key] = string(value)
+	}
+	return values, nil
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secrets resolves references such as vault://secret/data/tkeel/ldap#password in
+// configuration to the secret they point at, so secrets stay out of config files and rotate
+// without code changes.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const _defaultTTL = 5 * time.Minute
+
+var (
+	// ErrSecretNotFound the secret or its key does not exist.
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrKeyRequired the secret has several keys and the reference names none.
+	ErrKeyRequired = errors.New("secret key required")
+)
+
+// Provider reads secrets of a backend.
+type Provider interface {
+	// Get returns the key values of the secret at path, or ErrSecretNotFound.
+	Get(ctx context.Context, path string) (map[string]string, error)
+}
+
+// ProviderFunc adapts a function to a Provider.
+type ProviderFunc func(ctx context.Context, path string) (map[string]string, error)
+
+func (f ProviderFunc) Get(ctx context.Context, path string) (map[string]string, error) {
+	return f(ctx, path)
+}
+
+type cachedSecret struct {
+	values    map[string]string
+	fetchedAt time.Time
+}
+
+// Resolver resolves references with the provider registered for their scheme, values without
+// a registered scheme are literals. Secrets are cached for a TTL, so rotated values are picked
+// up by the next resolution after it.
+type Resolver struct {
+	ttl       time.Duration
+	lock      sync.Mutex
+	providers map[string]Provider
+	cache     map[string]cachedSecret
+}
+
+// NewResolver returns a Resolver caching for ttl, default 5m, with the env:// and file://
+// providers registered.
+func NewResolver(ttl time.Duration) *Resolver {
+	if ttl <= 0 {
+		ttl = _defaultTTL
+	}
+	r := &Resolver{ttl: ttl, providers: make(map[string]Provider), cache: make(map[string]cachedSecret)}
+	r.Register("env", ProviderFunc(envProvider))
+	r.Register("file", ProviderFunc(fileProvider))
+	return r
+}
+
+// Register resolves references of scheme with p.
+func (r *Resolver) Register(scheme string, p Provider) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.providers[scheme] = p
+}
+
+// IsReference reports whether value refers to a secret of a registered provider.
+func (r *Resolver) IsReference(value string) bool {
+	_, _, _, ok := r.parse(value)
+	return ok
+}
+
+// Resolve returns the secret value refers to, or value itself when it is a literal.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	p, path, key, ok := r.parse(value)
+	if !ok {
+		return value, nil
+	}
+	values, err := r.get(ctx, p, strings.SplitN(value, "#", 2)[0], path)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		if len(values) != 1 {
+			return "", fmt.Errorf("%s: %w", value, ErrKeyRequired)
+		}
+		for _, v := range values {
+			return v, nil
+		}
+	}
+	v, ok := values[key]
+	if !ok {
+		return "", fmt.Errorf("%s: %w", value, ErrSecretNotFound)
+	}
+	return v, nil
+}
+
+// ResolveOptions returns a copy of options with the references among its string values, also
+// in nested maps and slices, resolved. Use it on provider options before creating the provider.
+func (r *Resolver) ResolveOptions(ctx context.Context, options map[string]interface{}) (map[string]interface{}, error) {
+	resolved, err := r.resolveValue(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	out, _ := resolved.(map[string]interface{})
+	return out, nil
+}
+
+func (r *Resolver) resolveValue(ctx context.Context, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return r.Resolve(ctx, v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			resolved, err := r.resolveValue(ctx, item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			out[k] = resolved
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := r.resolveValue(ctx, item)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = resolved
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// parse splits scheme://path#key, ok is false for literals.
+func (r *Resolver) parse(value string) (p Provider, path, key string, ok bool) {
+	i := strings.Index(value, "://")
+	if i <= 0 {
+		return nil, "", "", false
+	}
+	r.lock.Lock()
+	p, ok = r.providers[value[:i]]
+	r.lock.Unlock()
+	if !ok {
+		return nil, "", "", false
+	}
+	path = value[i+3:]
+	if j := strings.LastIndexByte(path, '#'); j >= 0 {
+		path, key = path[:j], path[j+1:]
+	}
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+	return p, path, key, true
+}
+
+func (r *Resolver) get(ctx context.Context, p Provider, ref, path string) (map[string]string, error) {
+	r.lock.Lock()
+	c, ok := r.cache[ref]
+	r.lock.Unlock()
+	if ok && time.Since(c.fetchedAt) < r.ttl {
+		return c.values, nil
+	}
+	values, err := p.Get(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s %w", ref, err)
+	}
+	r.lock.Lock()
+	r.cache[ref] = cachedSecret{values: values, fetchedAt: time.Now()}
+	r.lock.Unlock()
+	return values, nil
+}
+
+// envProvider env://NAME the environment variable NAME.
+func envProvider(_ context.Context, name string) (map[string]string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("env %s: %w", name, ErrSecretNotFound)
+	}
+	return map[string]string{name: v}, nil
+}
+
+// fileProvider file:///path the content of a file, e.g. a mounted secret, without the trailing newline.
+func fileProvider(_ context.Context, path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("file %s: %w", path, ErrSecretNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read secret file %w", err)
+	}
+	return map[string]string{path: strings.TrimRight(string(data), "\r\n")}, nil
+}
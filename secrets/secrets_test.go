@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolver(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "bind_password")
+	assert.NoError(t, os.WriteFile(file, []byte("s3cret\n"), 0o600))
+	assert.NoError(t, os.Setenv("TKEEL_TEST_SECRET", "from-env"))
+	defer os.Unsetenv("TKEEL_TEST_SECRET")
+
+	calls := 0
+	r := NewResolver(time.Hour)
+	r.Register("mem", ProviderFunc(func(ctx context.Context, path string) (map[string]string, error) {
+		if path != "app" {
+			return nil, ErrSecretNotFound
+		}
+		calls++
+		return map[string]string{"user": "admin", "password": "pw"}, nil
+	}))
+
+	tests := []struct {
+		value, want string
+		err         error
+	}{
+		{"literal", "literal", nil},
+		{"https://not.a/secret#x", "https://not.a/secret#x", nil},
+		{"env://TKEEL_TEST_SECRET", "from-env", nil},
+		{"env://TKEEL_TEST_MISSING", "", ErrSecretNotFound},
+		{"file://" + file, "s3cret", nil},
+		{"mem://app#password", "pw", nil},
+		{"mem://app#missing", "", ErrSecretNotFound},
+		{"mem://app", "", ErrKeyRequired},
+		{"mem://other#x", "", ErrSecretNotFound},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(context.Background(), tt.value)
+		assert.ErrorIs(t, err, tt.err, tt.value)
+		assert.Equal(t, tt.want, got, tt.value)
+	}
+	// app was fetched once and served from the cache after.
+	assert.Equal(t, 1, calls)
+
+	options, err := r.ResolveOptions(context.Background(), map[string]interface{}{
+		"host":     "ldap:389",
+		"port":     389,
+		"password": "mem://app#password",
+		"nested":   map[string]interface{}{"list": []interface{}{"mem://app#user"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"host":     "ldap:389",
+		"port":     389,
+		"password": "pw",
+		"nested":   map[string]interface{}{"list": []interface{}{"admin"}},
+	}, options)
+}
+
+func TestVault(t *testing.T) {
+	jwt := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(jwt, []byte("sa-jwt"), 0o600))
+	logins := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var body map[string]string
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, map[string]string{"role": "tkeel", "jwt": "sa-jwt"}, body)
+			logins++
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"vault-token","lease_duration":3600}}`))
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/tkeel/ldap":
+			_, _ = w.Write([]byte(`{"data":{"data":{"password":"bind-pw","port":389},"metadata":{"version":3}}}`))
+		case "/v1/kv/tkeel":
+			_, _ = w.Write([]byte(`{"data":{"client_secret":"cs"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	for name, conf := range map[string]VaultConfig{
+		"token":      {Address: srv.URL, Token: "vault-token"},
+		"kubernetes": {Address: srv.URL, KubernetesRole: "tkeel", ServiceAccountTokenFile: jwt},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := NewResolver(0)
+			r.Register("vault", NewVault(conf, nil))
+			for value, want := range map[string]string{
+				"vault://secret/data/tkeel/ldap#password": "bind-pw",
+				"vault://secret/data/tkeel/ldap#port":     "389",
+				"vault://kv/tkeel":                        "cs",
+			} {
+				got, err := r.Resolve(context.Background(), value)
+				assert.NoError(t, err, value)
+				assert.Equal(t, want, got, value)
+			}
+			_, err := r.Resolve(context.Background(), "vault://secret/data/missing#x")
+			assert.ErrorIs(t, err, ErrSecretNotFound)
+		})
+	}
+	assert.Equal(t, 1, logins)
+}
+
+func TestKubernetes(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer sa-jwt", r.Header.Get("Authorization"))
+		if r.URL.Path != "/api/v1/namespaces/keel-system/secrets/oauth" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"client_secret":"Y2xpZW50LXNlY3JldA=="}}`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "ca.crt"), ca, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte("sa-jwt\n"), 0o600))
+
+	k, err := NewKubernetes(KubernetesConfig{
+		Host:      srv.URL,
+		Namespace: "keel-system",
+		TokenFile: filepath.Join(dir, "token"),
+		CAFile:    filepath.Join(dir, "ca.crt"),
+	})
+	assert.NoError(t, err)
+	r := NewResolver(0)
+	r.Register("k8s", k)
+	for _, ref := range []string{"k8s://oauth#client_secret", "k8s://keel-system/oauth#client_secret"} {
+		got, err := r.Resolve(context.Background(), ref)
+		assert.NoError(t, err)
+		assert.Equal(t, "client-secret", got)
+	}
+	_, err = r.Resolve(context.Background(), "k8s://default/oauth#client_secret")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	_defaultVaultAuthPath = "kubernetes"
+	// _serviceAccountDir where kubernetes mounts the credentials of the pod.
+	_serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+var _ Provider = &Vault{}
+
+// VaultConfig of the Vault provider.
+type VaultConfig struct {
+	// Address of the Vault server, e.g. https://vault:8200.
+	Address string `mapstructure:"address" json:"address" yaml:"address"`
+	// Namespace Vault enterprise namespace.
+	Namespace string `mapstructure:"namespace" json:"namespace" yaml:"namespace"`
+	// Token static Vault token, or TokenFile holding one.
+	Token     string `mapstructure:"token" json:"-" yaml:"token"`
+	TokenFile string `mapstructure:"token_file" json:"token_file" yaml:"tokenFile"`
+	// KubernetesRole logs in with the kubernetes auth method as this role when no token is set.
+	KubernetesRole string `mapstructure:"kubernetes_role" json:"kubernetes_role" yaml:"kubernetesRole"`
+	// KubernetesAuthPath mount of the kubernetes auth method. Default to kubernetes.
+	KubernetesAuthPath string `mapstructure:"kubernetes_auth_path" json:"kubernetes_auth_path" yaml:"kubernetesAuthPath"`
+	// ServiceAccountTokenFile default to the token kubernetes mounts into the pod.
+	ServiceAccountTokenFile string `mapstructure:"service_account_token_file" json:"service_account_token_file" yaml:"serviceAccountTokenFile"`
+}
+
+// Vault reads secrets of KV engines, vault://secret/data/app#key for version 2 mounted at secret
+// and vault://kv/app#key for version 1 mounted at kv.
+type Vault struct {
+	conf   VaultConfig
+	client *http.Client
+
+	lock      sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewVault returns a Vault provider, client defaults to utils.DefaultHTTPClient.
+func NewVault(conf VaultConfig, client *http.Client) *Vault {
+	if conf.KubernetesAuthPath == "" {
+		conf.KubernetesAuthPath = _defaultVaultAuthPath
+	}
+	if conf.ServiceAccountTokenFile == "" {
+		conf.ServiceAccountTokenFile = _serviceAccountDir + "/token"
+	}
+	if client == nil {
+		client = utils.DefaultHTTPClient
+	}
+	return &Vault{conf: conf, client: client}
+}
+
+func (v *Vault) Get(ctx context.Context, path string) (map[string]string, error) {
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	status, err := v.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), nil, &resp)
+	if status == http.StatusForbidden && v.conf.KubernetesRole != "" {
+		// the login expired early, e.g. revoked, log in again once.
+		v.lock.Lock()
+		v.token = ""
+		v.lock.Unlock()
+		status, err = v.do(ctx, http.MethodGet, "/v1/"+strings.TrimPrefix(path, "/"), nil, &resp)
+	}
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("vault %s: %w", path, ErrSecretNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := resp.Data
+	// kv version 2 nests the secret next to its metadata.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok = data["metadata"]; ok {
+			data = nested
+		}
+	}
+	values := make(map[string]string, len(data))
+	for k, val := range data {
+		if s, ok := val.(string); ok {
+			values[k] = s
+		} else {
+			encoded, _ := json.Marshal(val)
+			values[k] = string(encoded)
+		}
+	}
+	return values, nil
+}
+
+func (v *Vault) do(ctx context.Context, method, path string, body, out interface{}) (int, error) {
+	token, err := v.authToken(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return v.request(ctx, method, path, token, body, out)
+}
+
+func (v *Vault) request(ctx context.Context, method, path, token string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("vault request %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(v.conf.Address, "/")+path, reader)
+	if err != nil {
+		return 0, fmt.Errorf("vault request %w", err)
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.conf.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.conf.Namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("vault %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("vault %s %s: status %d", method, path, resp.StatusCode)
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("vault response %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// authToken returns the static token or logs in with the service account of the pod.
+func (v *Vault) authToken(ctx context.Context) (string, error) {
+	if v.conf.Token != "" {
+		return v.conf.Token, nil
+	}
+	if v.conf.TokenFile != "" {
+		data, err := os.ReadFile(v.conf.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("vault token file %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if v.conf.KubernetesRole == "" {
+		return "", nil
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.token != "" && time.Now().Before(v.expiresAt) {
+		return v.token, nil
+	}
+	jwt, err := os.ReadFile(v.conf.ServiceAccountTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("service account token %w", err)
+	}
+	var resp struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	login := map[string]string{"role": v.conf.KubernetesRole, "jwt": strings.TrimSpace(string(jwt))}
+	if _, err = v.request(ctx, http.MethodPost, "/v1/auth/"+v.conf.KubernetesAuthPath+"/login", "", login, &resp); err != nil {
+		return "", fmt.Errorf("vault login %w", err)
+	}
+	// renew at two thirds of the lease, well before vault expires the token.
+	v.token = resp.Auth.ClientToken
+	v.expiresAt = time.Now().Add(time.Duration(resp.Auth.LeaseDuration) * time.Second * 2 / 3)
+	return v.token, nil
+}
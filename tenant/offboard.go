@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/middleware"
+)
+
+// Lifecycle events written to the event sink while a tenant is offboarded.
+const (
+	EventOffboardingStarted = "tenant.offboarding_started"
+	EventOffboardingFailed  = "tenant.offboarding_failed"
+	EventOffboarded         = "tenant.offboarded"
+)
+
+// TenantRevoker ends or removes what a tenant owns outside the tenant store. It is satisfied
+// by token.MemoryStore, session.MemoryStore, apikey.Manager, entity.Manager, idpconfig.Manager,
+// rbac.RoleOperator, rbac.TenantPluginOperator and the oauth server.Storage holding the refresh
+// tokens. It must be idempotent, a failed
+// offboarding is retried from the first step.
+type TenantRevoker interface {
+	RevokeTenant(tenantID string) error
+}
+
+// TenantRevokerFunc adapts a function to a TenantRevoker.
+type TenantRevokerFunc func(tenantID string) error
+
+func (f TenantRevokerFunc) RevokeTenant(tenantID string) error {
+	return f(tenantID)
+}
+
+type offboardStep struct {
+	name    string
+	revoker TenantRevoker
+}
+
+// AddOffboarding appends a step run by DeleteTenant, name identifies it in the events.
+// Steps run in the order they are added, add credentials (tokens, sessions, keys) first.
+func (m *Manager) AddOffboarding(name string, revoker TenantRevoker) {
+	m.offboarding = append(m.offboarding, offboardStep{name: name, revoker: revoker})
+}
+
+// SetEventSink writes the lifecycle events of DeleteTenant to sink.
+func (m *Manager) SetEventSink(sink audit.EventSink) {
+	m.events = sink
+}
+
+// DeleteTenant offboards the tenant: it runs every offboarding step and deletes the tenant
+// only when all of them succeeded. A failing step stops the pipeline and leaves the tenant in
+// place, so the call can be retried and never leaves the tenant deleted with live credentials.
+// Stateless tokens (e.g. JWTs) cannot be recalled and stay valid until they expire.
+func (m *Manager) DeleteTenant(ctx context.Context, id string) error {
+	t, err := m.store.Get(id)
+	if err != nil {
+		return err
+	}
+	m.emit(ctx, EventOffboardingStarted, t, nil)
+	done := make([]string, 0, len(m.offboarding))
+	for _, step := range m.offboarding {
+		if err = step.revoker.RevokeTenant(id); err != nil {
+			m.emit(ctx, EventOffboardingFailed, t, map[string]interface{}{
+				"step": step.name, "done": done, "error": err.Error(),
+			})
+			return fmt.Errorf("offboard tenant %s: %s %w", id, step.name, err)
+		}
+		done = append(done, step.name)
+	}
+	if err = m.store.Delete(id); err != nil {
+		m.emit(ctx, EventOffboardingFailed, t, map[string]interface{}{
+			"step": "tenant", "done": done, "error": err.Error(),
+		})
+		return fmt.Errorf("delete tenant %s %w", id, err)
+	}
+	m.emit(ctx, EventOffboarded, t, map[string]interface{}{"done": done})
+	m.logger.Infof("offboarded tenant %s(%s)", t.Title, t.ID)
+	return nil
+}
+
+func (m *Manager) emit(ctx context.Context, typ string, t *Tenant, detail map[string]interface{}) {
+	if m.events == nil {
+		return
+	}
+	if detail == nil {
+		detail = make(map[string]interface{})
+	}
+	detail["title"] = t.Title
+	m.events.WriteEvent(&audit.Event{
+		Time:     time.Now(),
+		Type:     typ,
+		Actor:    middleware.SubjectFromContext(ctx),
+		TenantID: t.ID,
+		Detail:   detail,
+	})
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const _defaultRedisPrefix = "quota:"
+
+var (
+	_ Counter = &MemoryCounter{}
+	_ Counter = &RedisCounter{}
+)
+
+// MemoryCounter in-process Counter, each replica counts on its own.
+type MemoryCounter struct {
+	lock sync.Mutex
+	// leases expiry by id by key, the zero time for leases without expiry.
+	leases map[string]map[string]time.Time
+}
+
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{leases: make(map[string]map[string]time.Time)}
+}
+
+func (c *MemoryCounter) Acquire(ctx context.Context, key, id string, limit int, ttl time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	leases := c.active(key, time.Now())
+	if leases == nil {
+		leases = make(map[string]time.Time)
+		c.leases[key] = leases
+	}
+	if _, held := leases[id]; !held && limit > 0 && len(leases) >= limit {
+		return false, nil
+	}
+	var expiry time.Time
+	if ttl > 0 {
+		expiry = time.Now().Add(ttl)
+	}
+	leases[id] = expiry
+	return true, nil
+}
+
+func (c *MemoryCounter) Release(ctx context.Context, key, id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.leases[key], id)
+	return nil
+}
+
+func (c *MemoryCounter) Count(ctx context.Context, key string) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.active(key, time.Now())), nil
+}
+
+// active drops the expired leases of key and returns the others.
+func (c *MemoryCounter) active(key string, now time.Time) map[string]time.Time {
+	leases := c.leases[key]
+	for id, expiry := range leases {
+		if !expiry.IsZero() && !now.Before(expiry) {
+			delete(leases, id)
+		}
+	}
+	return leases
+}
+
+// _acquire leases ARGV[1] in the sorted set KEYS[1] scored by expiry in milliseconds.
+// ARGV: id, limit, now in milliseconds, expiry in milliseconds or inf.
+var _acquire = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+local limit = tonumber(ARGV[2])
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) or limit <= 0 or redis.call('ZCARD', KEYS[1]) < limit then
+  redis.call('ZADD', KEYS[1], ARGV[4], ARGV[1])
+  return 1
+end
+return 0
+`)
+
+// RedisCounter Counter shared by all replicas through redis, the leases of a key are a sorted
+// set scored by their expiry.
+type RedisCounter struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisCounter returns a RedisCounter keeping the leases under prefix, default to quota:.
+func NewRedisCounter(client redis.UniversalClient, prefix string) *RedisCounter {
+	if prefix == "" {
+		prefix = _defaultRedisPrefix
+	}
+	return &RedisCounter{client: client, prefix: prefix}
+}
+
+func (c *RedisCounter) Acquire(ctx context.Context, key, id string, limit int, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	expiry := "+inf"
+	if ttl > 0 {
+		expiry = strconv.FormatInt(milliseconds(now.Add(ttl)), 10)
+	}
+	n, err := _acquire.Run(ctx, c.client, []string{c.prefix + key}, id, limit, milliseconds(now), expiry).Int()
+	if err != nil {
+		return false, fmt.Errorf("run acquire %w", err)
+	}
+	return n == 1, nil
+}
+
+func (c *RedisCounter) Release(ctx context.Context, key, id string) error {
+	return c.client.ZRem(ctx, c.prefix+key, id).Err()
+}
+
+func (c *RedisCounter) Count(ctx context.Context, key string) (int, error) {
+	n, err := c.client.ZCount(ctx, c.prefix+key, "("+strconv.FormatInt(milliseconds(time.Now()), 10), "+inf").Result()
+	return int(n), err
+}
+
+func milliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package quota enforces per-tenant limits on the shared infrastructure: active sessions,
+// API keys, the token issuance rate and the request rate, so one noisy tenant can not exhaust
+// them for the others.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+	"github.com/tkeel-io/security/middleware/ratelimit"
+)
+
+const (
+	// ResourceSessions the active sessions of a tenant.
+	ResourceSessions = "sessions"
+	// ResourceAPIKeys the active API keys of a tenant.
+	ResourceAPIKeys = "api_keys"
+)
+
+var (
+	_ ratelimit.Limiter = &requestLimiter{}
+
+	// ErrQuotaExceeded the tenant reached a limit.
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+)
+
+// Limits of a tenant, zero is unlimited.
+type Limits struct {
+	// Sessions active sessions.
+	Sessions int `mapstructure:"sessions" json:"sessions" yaml:"sessions"`
+	// APIKeys active API keys.
+	APIKeys int `mapstructure:"api_keys" json:"api_keys" yaml:"apiKeys"`
+	// TokensPerMinute tokens issued per minute.
+	TokensPerMinute int `mapstructure:"tokens_per_minute" json:"tokens_per_minute" yaml:"tokensPerMinute"`
+	// RequestsPerSecond requests served per second, see Middleware.
+	RequestsPerSecond int `mapstructure:"requests_per_second" json:"requests_per_second" yaml:"requestsPerSecond"`
+}
+
+// Config of the quotas.
+type Config struct {
+	// Default limits of tenants without their own.
+	Default Limits `mapstructure:"default" json:"default" yaml:"default"`
+	// Tenants limits by tenant id.
+	Tenants map[string]Limits `mapstructure:"tenants" json:"tenants" yaml:"tenants"`
+}
+
+// Counter tracks the active resources of a tenant as leases, so resources expiring without
+// an explicit release (e.g. abandoned sessions) stop counting on their own.
+type Counter interface {
+	// Acquire leases id under key for ttl, zero for no expiry, unless key already holds limit
+	// other leases. Acquiring a held lease renews it.
+	Acquire(ctx context.Context, key, id string, limit int, ttl time.Duration) (bool, error)
+	// Release ends the lease of id, releasing an unknown lease is not an error.
+	Release(ctx context.Context, key, id string) error
+	// Count returns the active leases under key.
+	Count(ctx context.Context, key string) (int, error)
+}
+
+// LimiterFactory returns a rate limiter of conf, e.g. a ratelimit.RedisLimiter shared by the replicas.
+type LimiterFactory func(conf ratelimit.Config) (ratelimit.Limiter, error)
+
+// Quota enforces the limits.
+type Quota struct {
+	counter    Counter
+	newLimiter LimiterFactory
+
+	lock     sync.RWMutex
+	conf     Config
+	limiters map[ratelimit.Config]ratelimit.Limiter
+}
+
+// New returns a Quota, newLimiter defaults to in-process limiters.
+func New(conf Config, counter Counter, newLimiter LimiterFactory) *Quota {
+	if newLimiter == nil {
+		newLimiter = func(conf ratelimit.Config) (ratelimit.Limiter, error) { return ratelimit.NewMemoryLimiter(conf) }
+	}
+	if conf.Tenants == nil {
+		conf.Tenants = make(map[string]Limits)
+	}
+	return &Quota{conf: conf, counter: counter, newLimiter: newLimiter, limiters: make(map[ratelimit.Config]ratelimit.Limiter)}
+}
+
+// Limits returns the limits of tenantID.
+func (q *Quota) Limits(tenantID string) Limits {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	if l, ok := q.conf.Tenants[tenantID]; ok {
+		return l
+	}
+	return q.conf.Default
+}
+
+// SetLimits changes the limits of tenantID, leases above a lowered limit are kept until they end.
+func (q *Quota) SetLimits(tenantID string, limits Limits) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.conf.Tenants[tenantID] = limits
+}
+
+// Acquire leases the resource id of tenantID for ttl, zero for no expiry, or returns
+// ErrQuotaExceeded when the tenant holds its limit of resource.
+func (q *Quota) Acquire(ctx context.Context, tenantID, resource, id string, ttl time.Duration) error {
+	limit := q.limit(tenantID, resource)
+	ok, err := q.counter.Acquire(ctx, key(tenantID, resource), id, limit, ttl)
+	if err != nil {
+		return fmt.Errorf("acquire %s quota %w", resource, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %d %s of tenant %s", ErrQuotaExceeded, limit, resource, tenantID)
+	}
+	return nil
+}
+
+// Release ends the lease of the resource id of tenantID.
+func (q *Quota) Release(ctx context.Context, tenantID, resource, id string) error {
+	return q.counter.Release(ctx, key(tenantID, resource), id)
+}
+
+// Usage returns the active resources of tenantID.
+func (q *Quota) Usage(ctx context.Context, tenantID, resource string) (int, error) {
+	return q.counter.Count(ctx, key(tenantID, resource))
+}
+
+// AllowIssue returns ErrQuotaExceeded when tenantID issued its tokens of the minute.
+func (q *Quota) AllowIssue(ctx context.Context, tenantID string) error {
+	limit := q.Limits(tenantID).TokensPerMinute
+	if limit <= 0 {
+		return nil
+	}
+	l, err := q.limiter(ratelimit.Config{Limit: limit, Period: time.Minute})
+	if err != nil {
+		return fmt.Errorf("token issuance quota %w", err)
+	}
+	res, err := l.Allow(ctx, "tokens:"+tenantID)
+	if err != nil {
+		return fmt.Errorf("token issuance quota %w", err)
+	}
+	if !res.Allowed {
+		return fmt.Errorf("%w: %d tokens per minute of tenant %s", ErrQuotaExceeded, limit, tenantID)
+	}
+	return nil
+}
+
+// IssueHook returns a token.ClaimEnricher enforcing the token issuance rate, register it on a
+// token.HookedManager. Tokens without tenant are not limited.
+func (q *Quota) IssueHook() token.ClaimEnricher {
+	return func(claims *token.Claims) error {
+		if claims.TenantID == "" {
+			return nil
+		}
+		return q.AllowIssue(context.Background(), claims.TenantID)
+	}
+}
+
+// Middleware rejects the requests of tenants over their request rate with 429, it must run
+// after authentication.
+func (q *Quota) Middleware() func(http.Handler) http.Handler {
+	return ratelimit.Middleware(&requestLimiter{quota: q}, func(r *http.Request) string {
+		tenantID := middleware.TenantFromContext(r.Context())
+		if tenantID == "" || q.Limits(tenantID).RequestsPerSecond <= 0 {
+			return ""
+		}
+		return "requests:" + tenantID
+	})
+}
+
+func (q *Quota) limit(tenantID, resource string) int {
+	limits := q.Limits(tenantID)
+	switch resource {
+	case ResourceSessions:
+		return limits.Sessions
+	case ResourceAPIKeys:
+		return limits.APIKeys
+	}
+	return 0
+}
+
+// limiter returns the shared limiter of conf, tenants with the same limit share a limiter
+// and are told apart by the key.
+func (q *Quota) limiter(conf ratelimit.Config) (ratelimit.Limiter, error) {
+	q.lock.RLock()
+	l, ok := q.limiters[conf]
+	q.lock.RUnlock()
+	if ok {
+		return l, nil
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if l, ok = q.limiters[conf]; !ok {
+		var err error
+		if l, err = q.newLimiter(conf); err != nil {
+			return nil, err
+		}
+		q.limiters[conf] = l
+	}
+	return l, nil
+}
+
+// requestLimiter the ratelimit.Limiter applying the request rate of the tenant of the context.
+type requestLimiter struct {
+	quota *Quota
+}
+
+func (l *requestLimiter) Allow(ctx context.Context, key string) (ratelimit.Result, error) {
+	limit := l.quota.Limits(middleware.TenantFromContext(ctx)).RequestsPerSecond
+	limiter, err := l.quota.limiter(ratelimit.Config{Limit: limit})
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	return limiter.Allow(ctx, key)
+}
+
+func key(tenantID, resource string) string {
+	return resource + ":" + tenantID
+}
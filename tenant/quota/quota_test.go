@@ -0,0 +1,136 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/middleware"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounters(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	for name, c := range map[string]Counter{"memory": NewMemoryCounter(), "redis": NewRedisCounter(client, "")} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for _, id := range []string{"a", "b"} {
+				ok, err := c.Acquire(ctx, "k", id, 2, 0)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+			}
+			ok, err := c.Acquire(ctx, "k", "c", 2, 0)
+			assert.NoError(t, err)
+			assert.False(t, ok)
+			ok, err = c.Acquire(ctx, "k", "a", 2, time.Hour)
+			assert.NoError(t, err)
+			assert.True(t, ok, "renewing a held lease")
+			assert.NoError(t, c.Release(ctx, "k", "b"))
+			ok, err = c.Acquire(ctx, "k", "c", 2, 50*time.Millisecond)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			n, err := c.Count(ctx, "k")
+			assert.NoError(t, err)
+			assert.Equal(t, 2, n)
+
+			time.Sleep(60 * time.Millisecond)
+			n, err = c.Count(ctx, "k")
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+			ok, err = c.Acquire(ctx, "k", "d", 2, 0)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestQuota(t *testing.T) {
+	q := New(Config{
+		Default: Limits{Sessions: 1, APIKeys: 1, TokensPerMinute: 2},
+		Tenants: map[string]Limits{"big": {}},
+	}, NewMemoryCounter(), nil)
+
+	sessions := q.SessionStore(session.NewMemoryStore())
+	s1 := &session.Session{ID: "s1", Claims: &token.Claims{TenantID: "t1"}}
+	assert.NoError(t, sessions.Save(context.Background(), s1, time.Hour))
+	assert.NoError(t, sessions.Save(context.Background(), s1, time.Hour))
+	assert.ErrorIs(t, sessions.Save(context.Background(), &session.Session{ID: "s2", Claims: &token.Claims{TenantID: "t1"}}, time.Hour), ErrQuotaExceeded)
+	assert.NoError(t, sessions.Save(context.Background(), &session.Session{ID: "s3", Claims: &token.Claims{TenantID: "big"}}, time.Hour))
+	assert.NoError(t, sessions.Save(context.Background(), &session.Session{ID: "s4", Claims: &token.Claims{TenantID: "big"}}, time.Hour))
+	assert.NoError(t, sessions.Delete(context.Background(), "s1"))
+	assert.NoError(t, sessions.Save(context.Background(), &session.Session{ID: "s2", Claims: &token.Claims{TenantID: "t1"}}, time.Hour))
+
+	keys := apikey.NewManager(apikey.Config{}, q.APIKeyStore(apikey.NewMemoryStore()))
+	_, k, err := keys.Create(apikey.CreateOptions{TenantID: "t1", Owner: "svc"})
+	assert.NoError(t, err)
+	_, _, err = keys.Create(apikey.CreateOptions{TenantID: "t1", Owner: "svc"})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.NoError(t, keys.Revoke(k.ID))
+	_, _, err = keys.Create(apikey.CreateOptions{TenantID: "t1", Owner: "svc"})
+	assert.NoError(t, err)
+	n, err := q.Usage(context.Background(), "t1", ResourceAPIKeys)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	jwts, err := token.NewJWTManager(&token.Config{SigningKey: "secret"})
+	assert.NoError(t, err)
+	issuer := token.NewHookedManager(jwts)
+	issuer.RegisterClaimEnricher(q.IssueHook())
+	for i := 0; i < 2; i++ {
+		_, err = issuer.Issue(&token.Claims{Subject: "usr-1", TenantID: "t1"})
+		assert.NoError(t, err)
+	}
+	_, err = issuer.Issue(&token.Claims{Subject: "usr-1", TenantID: "t1"})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	_, err = issuer.Issue(&token.Claims{Subject: "usr-1", TenantID: "t2"})
+	assert.NoError(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	q := New(Config{Default: Limits{RequestsPerSecond: 1}, Tenants: map[string]Limits{"big": {}}}, NewMemoryCounter(), nil)
+	h := q.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(tenantID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r = r.WithContext(middleware.WithClaims(r.Context(), &token.Claims{Subject: "usr-1", TenantID: tenantID}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	assert.Equal(t, http.StatusNoContent, serve("t1").Code)
+	w := serve("t1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusNoContent, serve("t2").Code)
+	for i := 0; i < 3; i++ {
+		w = serve("big")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+}
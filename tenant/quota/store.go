@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quota
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/log"
+)
+
+var (
+	_ session.Store = &sessionStore{}
+	_ apikey.Store  = &apiKeyStore{}
+)
+
+// SessionStore returns store limiting the active sessions of each tenant, saving a session over
+// the limit fails with ErrQuotaExceeded. Sessions without tenant are not limited.
+func (q *Quota) SessionStore(store session.Store) session.Store {
+	return &sessionStore{Store: store, quota: q}
+}
+
+type sessionStore struct {
+	session.Store
+	quota *Quota
+}
+
+func (s *sessionStore) Save(ctx context.Context, sess *session.Session, ttl time.Duration) error {
+	tenantID := sessionTenant(sess)
+	if tenantID != "" {
+		if err := s.quota.Acquire(ctx, tenantID, ResourceSessions, sess.ID, ttl); err != nil {
+			return err
+		}
+	}
+	return s.Store.Save(ctx, sess, ttl)
+}
+
+func (s *sessionStore) Delete(ctx context.Context, id string) error {
+	sess, err := s.Store.Load(ctx, id)
+	switch {
+	case err == nil:
+		if tenantID := sessionTenant(sess); tenantID != "" {
+			if err = s.quota.Release(ctx, tenantID, ResourceSessions, id); err != nil {
+				log.Warnf("release session quota of %s: %s", tenantID, err)
+			}
+		}
+	case !errors.Is(err, session.ErrSessionNotFound):
+		return err
+	}
+	return s.Store.Delete(ctx, id)
+}
+
+func sessionTenant(s *session.Session) string {
+	if s.Claims == nil {
+		return ""
+	}
+	return s.Claims.TenantID
+}
+
+// APIKeyStore returns store limiting the active API keys of each tenant, creating a key over the
+// limit fails with ErrQuotaExceeded. A rotated key counts until its grace period ends.
+func (q *Quota) APIKeyStore(store apikey.Store) apikey.Store {
+	return &apiKeyStore{Store: store, quota: q}
+}
+
+type apiKeyStore struct {
+	apikey.Store
+	quota *Quota
+}
+
+func (s *apiKeyStore) Create(ctx context.Context, k *apikey.Key) error {
+	var ttl time.Duration
+	if !k.ExpiresAt.IsZero() {
+		ttl = time.Until(k.ExpiresAt)
+	}
+	if err := s.quota.Acquire(ctx, k.TenantID, ResourceAPIKeys, k.ID, ttl); err != nil {
+		return err
+	}
+	if err := s.Store.Create(ctx, k); err != nil {
+		if rerr := s.quota.Release(ctx, k.TenantID, ResourceAPIKeys, k.ID); rerr != nil {
+			log.Warnf("release api key quota of %s: %s", k.TenantID, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *apiKeyStore) Update(ctx context.Context, k *apikey.Key) error {
+	if err := s.Store.Update(ctx, k); err != nil {
+		return err
+	}
+	var err error
+	switch {
+	case !k.RevokedAt.IsZero():
+		err = s.quota.Release(ctx, k.TenantID, ResourceAPIKeys, k.ID)
+	case !k.ExpiresAt.IsZero():
+		// a rotation shortened the key to its grace period.
+		if ttl := time.Until(k.ExpiresAt); ttl > 0 {
+			_, err = s.quota.counter.Acquire(ctx, key(k.TenantID, ResourceAPIKeys), k.ID, 0, ttl)
+		}
+	}
+	if err != nil {
+		log.Warnf("update api key quota of %s: %s", k.TenantID, err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tkeel-io/security/model"
+
+	"gorm.io/gorm"
+)
+
+var (
+	_ Store  = &MemoryStore{}
+	_ Store  = &GormStore{}
+	_ Admins = &GormAdmins{}
+)
+
+// MemoryStore in-process Store, suitable for a single replica or tests.
+type MemoryStore struct {
+	lock    sync.RWMutex
+	tenants map[string]Tenant
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tenants: make(map[string]Tenant)}
+}
+
+func (s *MemoryStore) Create(t *Tenant) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.tenants[t.ID]; ok {
+		return fmt.Errorf("duplicate tenant id %s", t.ID)
+	}
+	s.tenants[t.ID] = *t
+	return nil
+}
+
+func (s *MemoryStore) Get(id string) (*Tenant, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	t, ok := s.tenants[id]
+	if !ok {
+		return nil, ErrTenantNotFound
+	}
+	return &t, nil
+}
+
+func (s *MemoryStore) FindByTitle(title string) (*Tenant, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, t := range s.tenants {
+		if t.Title == title {
+			return &t, nil
+		}
+	}
+	return nil, ErrTenantNotFound
+}
+
+func (s *MemoryStore) List(page *model.Page, keywords string) (int64, []*Tenant, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	tenants := make([]*Tenant, 0)
+	for _, t := range s.tenants {
+		if keywords == "" || strings.Contains(t.ID+"\n"+t.Title+"\n"+t.Remark, keywords) {
+			t := t
+			tenants = append(tenants, &t)
+		}
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].CreatedAt.Before(tenants[j].CreatedAt) })
+	total := int64(len(tenants))
+	if page != nil && page.PageSize > 0 {
+		if page.PageNum <= 0 {
+			page.PageNum = 1
+		}
+		start := (page.PageNum - 1) * page.PageSize
+		if start > len(tenants) {
+			start = len(tenants)
+		}
+		end := start + page.PageSize
+		if end > len(tenants) {
+			end = len(tenants)
+		}
+		tenants = tenants[start:end]
+	}
+	return total, tenants, nil
+}
+
+func (s *MemoryStore) Update(t *Tenant) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.tenants[t.ID]; !ok {
+		return ErrTenantNotFound
+	}
+	s.tenants[t.ID] = *t
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.tenants, id)
+	return nil
+}
+
+// GormStore keeps the tenants next to their users, deleting a tenant deletes its users.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore migrates the table and returns a GormStore.
+func NewGormStore(db *gorm.DB) (*GormStore, error) {
+	if err := db.AutoMigrate(&model.Tenant{}); err != nil {
+		return nil, fmt.Errorf("migrate tenants %w", err)
+	}
+	return &GormStore{db: db}, nil
+}
+
+func (s *GormStore) Create(t *Tenant) error {
+	return toModel(t).Create(s.db)
+}
+
+func (s *GormStore) Get(id string) (*Tenant, error) {
+	row := &model.Tenant{ID: id}
+	found, err := row.Get(s.db)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrTenantNotFound
+	}
+	return fromModel(row), nil
+}
+
+func (s *GormStore) FindByTitle(title string) (*Tenant, error) {
+	row := &model.Tenant{}
+	err := s.db.Where("title = ?", title).First(row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromModel(row), nil
+}
+
+func (s *GormStore) List(page *model.Page, keywords string) (int64, []*Tenant, error) {
+	total, rows, err := model.ListTenants(s.db, page, keywords)
+	if err != nil {
+		return 0, nil, err
+	}
+	tenants := make([]*Tenant, 0, len(rows))
+	for _, row := range rows {
+		tenants = append(tenants, fromModel(row))
+	}
+	return total, tenants, nil
+}
+
+func (s *GormStore) Update(t *Tenant) error {
+	row := toModel(t)
+	_, err := row.Update(s.db, map[string]interface{}{"id": t.ID}, map[string]interface{}{
+		"title":       t.Title,
+		"remark":      t.Remark,
+		"auth_config": t.AuthConfig,
+		"updated_at":  t.UpdatedAt,
+	})
+	return err
+}
+
+func (s *GormStore) Delete(id string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := (&model.User{}).DeleteAllInTenant(tx, id); err != nil {
+			return fmt.Errorf("delete tenant users %w", err)
+		}
+		return (&model.Tenant{ID: id}).Delete(tx)
+	})
+}
+
+func toModel(t *Tenant) *model.Tenant {
+	return &model.Tenant{
+		ID:         t.ID,
+		Title:      t.Title,
+		Remark:     t.Remark,
+		AuthConfig: t.AuthConfig,
+		CreatedAt:  t.CreatedAt,
+		UpdatedAt:  t.UpdatedAt,
+	}
+}
+
+func fromModel(row *model.Tenant) *Tenant {
+	return &Tenant{
+		ID:         row.ID,
+		Title:      row.Title,
+		Remark:     row.Remark,
+		AuthConfig: row.AuthConfig,
+		CreatedAt:  row.CreatedAt,
+		UpdatedAt:  row.UpdatedAt,
+	}
+}
+
+// GormAdmins creates the administrators as users of the model package.
+type GormAdmins struct {
+	db *gorm.DB
+}
+
+func NewGormAdmins(db *gorm.DB) *GormAdmins {
+	return &GormAdmins{db: db}
+}
+
+func (a *GormAdmins) CreateAdmin(ctx context.Context, tenantID string, admin *Admin) (string, error) {
+	user := &model.User{
+		TenantID: tenantID,
+		UserName: admin.Username,
+		Password: admin.Password,
+		NickName: admin.NickName,
+		Email:    admin.Email,
+	}
+	if err := user.Create(a.db.WithContext(ctx)); err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
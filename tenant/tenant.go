@@ -0,0 +1,247 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/log"
+	"github.com/tkeel-io/security/model"
+	"github.com/tkeel-io/security/utils"
+)
+
+const (
+	_defaultAdminRole = "admin"
+	_idPrefix         = "tnt"
+	_idLength         = 8
+)
+
+var (
+	// ErrTenantNotFound no tenant of the id.
+	ErrTenantNotFound = errors.New("tenant not found")
+	// ErrTitleRequired tenant without title.
+	ErrTitleRequired = errors.New("tenant title required")
+	// ErrDuplicateTitle another tenant has the title.
+	ErrDuplicateTitle = errors.New("tenant title already exists")
+	// ErrAdminRequired bootstrap without admin username or password.
+	ErrAdminRequired = errors.New("tenant admin username and password required")
+)
+
+// Tenant the anchor of users, roles, clients and every other tenant scoped resource.
+type Tenant struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Remark string `json:"remark"`
+	// AuthConfig references the authentication config of the tenant, e.g. the id of its
+	// identity provider or a secret reference (see the secrets package).
+	AuthConfig string    `json:"auth_config"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Store persists tenants.
+type Store interface {
+	Create(t *Tenant) error
+	Get(id string) (*Tenant, error)
+	// FindByTitle returns ErrTenantNotFound when no tenant has the title.
+	FindByTitle(title string) (*Tenant, error)
+	List(page *model.Page, keywords string) (int64, []*Tenant, error)
+	Update(t *Tenant) error
+	Delete(id string) error
+}
+
+// Admin the first administrator of a tenant.
+type Admin struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	NickName string `json:"nick_name"`
+	Email    string `json:"email"`
+}
+
+// Admins creates the administrator user of a tenant.
+type Admins interface {
+	CreateAdmin(ctx context.Context, tenantID string, admin *Admin) (userID string, err error)
+}
+
+// RoleAssigner binds the administrator to its role, satisfied by rbac.RoleMgr.
+type RoleAssigner interface {
+	AssignRole(tenantID, subject, role string) error
+}
+
+type Config struct {
+	// AdminRole the role of the bootstrapped administrator, defaults to admin.
+	AdminRole string `mapstructure:"admin_role" json:"admin_role" yaml:"adminRole"`
+}
+
+// Manager manages the tenants and bootstraps their administrators.
+type Manager struct {
+	conf   Config
+	store  Store
+	admins Admins
+	roles  RoleAssigner
+
+	offboarding []offboardStep
+	events      audit.EventSink
+	logger      *log.Helper
+}
+
+// NewManager returns a Manager, admins and roles may be nil when tenants are never
+// bootstrapped with an administrator or roles are not managed here.
+func NewManager(conf Config, store Store, admins Admins, roles RoleAssigner) *Manager {
+	if conf.AdminRole == "" {
+		conf.AdminRole = _defaultAdminRole
+	}
+	return &Manager{conf: conf, store: store, admins: admins, roles: roles}
+}
+
+// SetLogger writes the entries of the tenant lifecycle to l instead of the global logger.
+func (m *Manager) SetLogger(l log.Logger) {
+	m.logger = log.NewHelper(l)
+}
+
+// Create creates t, generating its id if empty. Titles are unique.
+func (m *Manager) Create(ctx context.Context, t *Tenant) error {
+	t.Title = strings.TrimSpace(t.Title)
+	if t.Title == "" {
+		return ErrTitleRequired
+	}
+	if err := m.checkTitle(t); err != nil {
+		return err
+	}
+	if t.ID == "" {
+		id, err := utils.RandStringWithPrefix(_idPrefix, _idLength)
+		if err != nil {
+			return fmt.Errorf("generate tenant id %w", err)
+		}
+		t.ID = id
+	}
+	now := time.Now()
+	t.CreatedAt, t.UpdatedAt = now, now
+	if err := m.store.Create(t); err != nil {
+		return fmt.Errorf("create tenant %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) Get(ctx context.Context, id string) (*Tenant, error) {
+	return m.store.Get(id)
+}
+
+// Exists reports whether the tenant id exists, e.g. to stop refreshing the tokens of an
+// offboarded tenant.
+func (m *Manager) Exists(ctx context.Context, id string) (bool, error) {
+	_, err := m.store.Get(id)
+	if errors.Is(err, ErrTenantNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List lists the tenants whose id, title or remark contains keywords, page may be nil.
+func (m *Manager) List(ctx context.Context, page *model.Page, keywords string) (int64, []*Tenant, error) {
+	return m.store.List(page, keywords)
+}
+
+// Update updates the title, remark and auth config of the tenant.
+func (m *Manager) Update(ctx context.Context, t *Tenant) error {
+	t.Title = strings.TrimSpace(t.Title)
+	if t.Title == "" {
+		return ErrTitleRequired
+	}
+	old, err := m.store.Get(t.ID)
+	if err != nil {
+		return err
+	}
+	if err = m.checkTitle(t); err != nil {
+		return err
+	}
+	t.CreatedAt, t.UpdatedAt = old.CreatedAt, time.Now()
+	if err = m.store.Update(t); err != nil {
+		return fmt.Errorf("update tenant %w", err)
+	}
+	return nil
+}
+
+// Delete deletes the tenant, see the Store for what is deleted along with it. Use DeleteTenant
+// to also revoke what the tenant owns elsewhere.
+func (m *Manager) Delete(ctx context.Context, id string) error {
+	if _, err := m.store.Get(id); err != nil {
+		return err
+	}
+	return m.store.Delete(id)
+}
+
+// CreateWithAdmin creates t and its administrator, bound to the admin role. The tenant is
+// deleted again when the administrator cannot be created.
+func (m *Manager) CreateWithAdmin(ctx context.Context, t *Tenant, admin *Admin) (userID string, err error) {
+	if m.admins == nil || admin == nil || admin.Username == "" || admin.Password == "" {
+		return "", ErrAdminRequired
+	}
+	if err = m.Create(ctx, t); err != nil {
+		return "", err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if derr := m.store.Delete(t.ID); derr != nil {
+			m.logger.Errorf("roll back tenant %s: %s", t.ID, derr)
+		}
+	}()
+	if userID, err = m.admins.CreateAdmin(ctx, t.ID, admin); err != nil {
+		return "", fmt.Errorf("create tenant admin %w", err)
+	}
+	if m.roles != nil {
+		if err = m.roles.AssignRole(t.ID, userID, m.conf.AdminRole); err != nil {
+			return "", fmt.Errorf("assign tenant admin role %w", err)
+		}
+	}
+	return userID, nil
+}
+
+// Bootstrap creates the first tenant and its administrator on a fresh install and does
+// nothing once any tenant exists, so it is safe to call on every start.
+func (m *Manager) Bootstrap(ctx context.Context, t *Tenant, admin *Admin) (created bool, err error) {
+	total, _, err := m.store.List(&model.Page{PageSize: 1}, "")
+	if err != nil {
+		return false, fmt.Errorf("list tenants %w", err)
+	}
+	if total > 0 {
+		return false, nil
+	}
+	if _, err = m.CreateWithAdmin(ctx, t, admin); err != nil {
+		return false, err
+	}
+	m.logger.Infof("bootstrapped tenant %s(%s) with admin %s", t.Title, t.ID, admin.Username)
+	return true, nil
+}
+
+func (m *Manager) checkTitle(t *Tenant) error {
+	other, err := m.store.FindByTitle(t.Title)
+	if errors.Is(err, ErrTenantNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("find tenant by title %w", err)
+	}
+	if other.ID != t.ID {
+		return ErrDuplicateTitle
+	}
+	return nil
+}
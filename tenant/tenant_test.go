@@ -0,0 +1,206 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tenant
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tkeel-io/security/authn/apikey"
+	"github.com/tkeel-io/security/authn/session"
+	"github.com/tkeel-io/security/authn/token"
+	"github.com/tkeel-io/security/authz/audit"
+	"github.com/tkeel-io/security/authz/casbin"
+	"github.com/tkeel-io/security/authz/rbac"
+	"github.com/tkeel-io/security/model"
+	"github.com/tkeel-io/security/oauth/server"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type fakeRoles map[string]string
+
+func (f fakeRoles) AssignRole(tenantID, subject, role string) error {
+	if subject == "" {
+		return errors.New("empty subject")
+	}
+	f[tenantID+"/"+subject] = role
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&model.User{}))
+	gormStore, err := NewGormStore(db)
+	assert.NoError(t, err)
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "gorm": gormStore} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			roles := fakeRoles{}
+			m := NewManager(Config{}, store, NewGormAdmins(db), roles)
+
+			_, err := m.Bootstrap(ctx, &Tenant{Title: "default"}, &Admin{Username: "admin"})
+			assert.ErrorIs(t, err, ErrAdminRequired)
+			first := &Tenant{Title: " default ", AuthConfig: "idp-1"}
+			created, err := m.Bootstrap(ctx, first, &Admin{Username: "admin", Password: "changeme"})
+			assert.NoError(t, err)
+			assert.True(t, created)
+			assert.Equal(t, "default", first.Title)
+			assert.Len(t, roles, 1)
+			user, err := model.AuthenticateUser(db, first.ID, "admin", "changeme")
+			assert.NoError(t, err)
+			assert.Equal(t, "admin", roles[first.ID+"/"+user.ID])
+			created, err = m.Bootstrap(ctx, &Tenant{Title: "again"}, &Admin{Username: "admin", Password: "changeme"})
+			assert.NoError(t, err)
+			assert.False(t, created)
+
+			assert.ErrorIs(t, m.Create(ctx, &Tenant{Title: " "}), ErrTitleRequired)
+			assert.ErrorIs(t, m.Create(ctx, &Tenant{Title: "default"}), ErrDuplicateTitle)
+			second := &Tenant{Title: "factory", Remark: "plant 2"}
+			assert.NoError(t, m.Create(ctx, second))
+
+			got, err := m.Get(ctx, first.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "idp-1", got.AuthConfig)
+			total, tenants, err := m.List(ctx, nil, "plant")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), total)
+			assert.Equal(t, second.ID, tenants[0].ID)
+			total, tenants, err = m.List(ctx, &model.Page{PageNum: 2, PageSize: 1}, "")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), total)
+			assert.Len(t, tenants, 1)
+
+			second.Title = "default"
+			assert.ErrorIs(t, m.Update(ctx, second), ErrDuplicateTitle)
+			second.Title, second.AuthConfig = "factory", "idp-2"
+			assert.NoError(t, m.Update(ctx, second))
+			got, err = m.Get(ctx, second.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "idp-2", got.AuthConfig)
+			assert.ErrorIs(t, m.Update(ctx, &Tenant{ID: "tnt-none", Title: "x"}), ErrTenantNotFound)
+
+			assert.NoError(t, m.Delete(ctx, first.ID))
+			_, err = m.Get(ctx, first.ID)
+			assert.ErrorIs(t, err, ErrTenantNotFound)
+			assert.ErrorIs(t, m.Delete(ctx, first.ID), ErrTenantNotFound)
+			assert.NoError(t, m.Delete(ctx, second.ID))
+		})
+	}
+}
+
+func TestCreateWithAdminRollback(t *testing.T) {
+	store := NewMemoryStore()
+	m := NewManager(Config{}, store, adminsFunc(func() (string, error) { return "", errors.New("boom") }), nil)
+	_, err := m.CreateWithAdmin(context.Background(), &Tenant{Title: "t"}, &Admin{Username: "a", Password: "p"})
+	assert.Error(t, err)
+	total, _, err := store.List(nil, "")
+	assert.NoError(t, err)
+	assert.Zero(t, total)
+}
+
+type adminsFunc func() (string, error)
+
+func (f adminsFunc) CreateAdmin(context.Context, string, *Admin) (string, error) {
+	return f()
+}
+
+func TestDeleteTenant(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(Config{}, NewMemoryStore(), nil, nil)
+	sink := audit.NewMemorySink(10)
+	m.SetEventSink(sink)
+	gone, kept := &Tenant{Title: "gone"}, &Tenant{Title: "kept"}
+	assert.NoError(t, m.Create(ctx, gone))
+	assert.NoError(t, m.Create(ctx, kept))
+
+	tokens := token.NewMemoryStore()
+	assert.NoError(t, tokens.Save(ctx, "t1", &token.Claims{Subject: "alice", TenantID: gone.ID}, time.Hour))
+	assert.NoError(t, tokens.Save(ctx, "t2", &token.Claims{Subject: "bob", TenantID: kept.ID}, time.Hour))
+	sessions := session.NewMemoryStore()
+	assert.NoError(t, sessions.Save(context.Background(), &session.Session{ID: "s1", Claims: &token.Claims{TenantID: gone.ID}}, time.Hour))
+	keys := apikey.NewManager(apikey.Config{}, apikey.NewMemoryStore())
+	_, key, err := keys.Create(apikey.CreateOptions{Name: "ci", TenantID: gone.ID, Owner: "alice"})
+	assert.NoError(t, err)
+	enforcer, err := casbin.NewEnforcer(nil)
+	assert.NoError(t, err)
+	roles := rbac.NewRoleOperator(enforcer)
+	assert.NoError(t, roles.CreateRole(gone.ID, "admin", rbac.Permission{Resource: "*", Action: "*"}))
+	assert.NoError(t, roles.AssignRole(gone.ID, "alice", "admin"))
+	assert.NoError(t, roles.CreateRole(kept.ID, "admin", rbac.Permission{Resource: "*", Action: "*"}))
+	assert.NoError(t, roles.AssignRole(kept.ID, "bob", "admin"))
+
+	failing := true
+	m.AddOffboarding("tokens", tokens)
+	m.AddOffboarding("sessions", sessions)
+	m.AddOffboarding("api_keys", keys)
+	refreshTokens := server.NewMemoryStorage()
+	assert.NoError(t, refreshTokens.SaveRefreshToken(&server.RefreshToken{Signature: "r1",
+		Claims: &token.Claims{Subject: "alice", TenantID: gone.ID}, ExpiresAt: time.Now().Add(time.Hour)}))
+	m.AddOffboarding("refresh_tokens", refreshTokens)
+	m.AddOffboarding("flaky", TenantRevokerFunc(func(string) error {
+		if failing {
+			return errors.New("unavailable")
+		}
+		return nil
+	}))
+	m.AddOffboarding("roles", roles.(*rbac.RoleOperator))
+
+	err = m.DeleteTenant(ctx, gone.ID)
+	assert.Error(t, err)
+	_, err = m.Get(ctx, gone.ID)
+	assert.NoError(t, err, "a failed offboarding keeps the tenant")
+	events := sink.Events()
+	assert.Equal(t, EventOffboardingFailed, events[len(events)-1].Type)
+	assert.Equal(t, "flaky", events[len(events)-1].Detail["step"])
+
+	failing = false
+	assert.NoError(t, m.DeleteTenant(ctx, gone.ID))
+	_, err = m.Get(ctx, gone.ID)
+	assert.ErrorIs(t, err, ErrTenantNotFound)
+	assert.ErrorIs(t, m.DeleteTenant(ctx, gone.ID), ErrTenantNotFound)
+	exists, err := m.Exists(ctx, gone.ID)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = m.Exists(ctx, kept.ID)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	events = sink.Events()
+	assert.Equal(t, EventOffboarded, events[len(events)-1].Type)
+
+	_, err = tokens.Load(ctx, "t1")
+	assert.ErrorIs(t, err, token.ErrTokenNotFound)
+	_, err = tokens.Load(ctx, "t2")
+	assert.NoError(t, err)
+	_, err = sessions.Load(context.Background(), "s1")
+	assert.ErrorIs(t, err, session.ErrSessionNotFound)
+	_, err = refreshTokens.LoadRefreshToken("r1")
+	assert.ErrorIs(t, err, server.ErrNotFound)
+	revoked, err := keys.List(gone.ID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, key.ID, revoked[0].ID)
+	assert.False(t, revoked[0].Active())
+	ok, err := roles.Check("alice", gone.ID, "device", "read")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = roles.Check("bob", kept.ID, "device", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
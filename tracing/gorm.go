@@ -0,0 +1,82 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+const _gormSpanKey = "tracing:span"
+
+var _ gorm.Plugin = &GormPlugin{}
+
+// GormPlugin starts a span for each query of a gorm.DB, install it with db.Use. The spans are
+// children of the context given with db.WithContext.
+type GormPlugin struct{}
+
+func (p *GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (p *GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	hooks := []struct {
+		op            string
+		before, after func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	}
+	for _, h := range hooks {
+		if err := h.before("tracing:before_"+h.op, startQuery(h.op)); err != nil {
+			return fmt.Errorf("register tracing callback %w", err)
+		}
+		if err := h.after("tracing:after_"+h.op, endQuery); err != nil {
+			return fmt.Errorf("register tracing callback %w", err)
+		}
+	}
+	return nil
+}
+
+func startQuery(op string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, span := Start(db.Statement.Context, "gorm."+op, String("db.table", db.Statement.Table))
+		db.Statement.Context = ctx
+		db.InstanceSet(_gormSpanKey, span)
+	}
+}
+
+func endQuery(db *gorm.DB) {
+	v, ok := db.InstanceGet(_gormSpanKey)
+	if !ok {
+		return
+	}
+	span, ok := v.(Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(String("db.statement", db.Statement.SQL.String()), Int64("db.rows_affected", db.RowsAffected))
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	End(span, err)
+}
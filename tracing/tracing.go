@@ -0,0 +1,111 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing starts the spans of the security components: identity provider discovery,
+// token exchange, userinfo calls, LDAP binds, policy checks and store queries. It does nothing
+// until a Tracer is set, the interfaces follow the OpenTelemetry API so an adapter is short:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+//		kvs := make([]attribute.KeyValue, 0, len(attrs))
+//		for _, a := range attrs {
+//			kvs = append(kvs, attribute.String(a.Key, fmt.Sprint(a.Value)))
+//		}
+//		ctx, span := t.Tracer.Start(ctx, name, trace.WithAttributes(kvs...))
+//		return ctx, otelSpan{span}
+//	}
+//
+//	tracing.SetTracer(otelTracer{otel.Tracer("github.com/tkeel-io/security")})
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+// Attribute a key value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer starts spans as children of the span in ctx.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span an operation of a trace.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	// RecordError records err and marks the span failed.
+	RecordError(err error)
+	End()
+}
+
+var (
+	_lock   sync.RWMutex
+	_tracer Tracer = noopTracer{}
+)
+
+// SetTracer makes t the tracer of all components, nil disables tracing.
+func SetTracer(t Tracer) {
+	_lock.Lock()
+	defer _lock.Unlock()
+	if t == nil {
+		t = noopTracer{}
+	}
+	_tracer = t
+}
+
+// Start starts a span with the tracer set by SetTracer.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	_lock.RLock()
+	t := _tracer
+	_lock.RUnlock()
+	return t.Start(ctx, name, attrs...)
+}
+
+// End records err, if any, and ends span. It suits a deferred call with a named error:
+//
+//	defer func() { tracing.End(span, err) }()
+func End(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
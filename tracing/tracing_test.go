@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type spanKey struct{}
+
+type recordedSpan struct {
+	name   string
+	parent string
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+type recorder struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *recorder) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	s := &recordedSpan{name: name, attrs: make(map[string]interface{})}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		s.parent = parent.name
+	}
+	s.SetAttributes(attrs...)
+	r.lock.Lock()
+	r.spans = append(r.spans, s)
+	r.lock.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+func (s *recordedSpan) End()                  { s.ended = true }
+
+type row struct {
+	ID   int
+	Name string
+}
+
+func TestTracing(t *testing.T) {
+	// without a tracer spans are no-ops.
+	_, span := Start(context.Background(), "noop")
+	End(span, errors.New("ignored"))
+
+	r := &recorder{}
+	SetTracer(r)
+	defer SetTracer(nil)
+
+	ctx, parent := Start(context.Background(), "login", String("provider", "ldap"))
+	_, child := Start(ctx, "ldap.bind")
+	End(child, errors.New("invalid credentials"))
+	End(parent, nil)
+	assert.Len(t, r.spans, 2)
+	assert.Equal(t, "ldap", r.spans[0].attrs["provider"])
+	assert.True(t, r.spans[0].ended)
+	assert.Nil(t, r.spans[0].err)
+	assert.Equal(t, "login", r.spans[1].parent)
+	assert.EqualError(t, r.spans[1].err, "invalid credentials")
+}
+
+func TestGormPlugin(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&row{}))
+	assert.NoError(t, db.Use(&GormPlugin{}))
+
+	r := &recorder{}
+	SetTracer(r)
+	defer SetTracer(nil)
+
+	ctx, parent := Start(context.Background(), "request")
+	db = db.WithContext(ctx)
+	assert.NoError(t, db.Create(&row{ID: 1, Name: "alice"}).Error)
+	assert.ErrorIs(t, db.First(&row{}, 2).Error, gorm.ErrRecordNotFound)
+	parent.End()
+
+	assert.Len(t, r.spans, 3)
+	create, query := r.spans[1], r.spans[2]
+	assert.Equal(t, "gorm.create", create.name)
+	assert.Equal(t, "request", create.parent)
+	assert.Equal(t, "rows", create.attrs["db.table"])
+	assert.Equal(t, int64(1), create.attrs["db.rows_affected"])
+	assert.Contains(t, create.attrs["db.statement"], "INSERT")
+	assert.Equal(t, "gorm.query", query.name)
+	assert.True(t, query.ended)
+	assert.Nil(t, query.err, "not found is not a failure")
+}
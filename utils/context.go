@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a call to a store or a provider made without a deadline.
+	DefaultTimeout = 10 * time.Second
+	// DefaultHTTPTimeout bounds a request of DefaultHTTPClient.
+	DefaultHTTPTimeout = 30 * time.Second
+)
+
+// DefaultHTTPClient the client of the package when none is given, unlike http.DefaultClient
+// a stalled server fails its requests after DefaultHTTPTimeout.
+var DefaultHTTPClient = &http.Client{Timeout: DefaultHTTPTimeout}
+
+// WithTimeout returns ctx bounded by timeout, default to DefaultTimeout, unless ctx already
+// has an earlier deadline. Call cancel once the operation is done.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Timeout returns timeout shortened to the deadline of ctx when that is earlier.
+func Timeout(ctx context.Context, timeout time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		return time.Until(deadline)
+	}
+	return timeout
+}
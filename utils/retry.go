@@ -0,0 +1,70 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Retry calls fn up to attempts times until it succeeds, sleeping a jittered backoff doubling from
+// base between the calls. It returns the last error, or the error of ctx once it is done.
+func Retry(ctx context.Context, attempts int, base time.Duration, fn func() error) error {
+	var err error
+	backoff := base
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i == attempts-1 {
+			break
+		}
+		// full jitter, so replicas restarted together do not retry in lockstep.
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1)) // nolint
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// Once runs an initialization until it succeeds. Unlike sync.Once a failed call is retried by
+// the next Do, so a dependency unavailable at startup is picked up once it recovers.
+type Once struct {
+	lock sync.Mutex
+	done uint32
+}
+
+// Do calls fn unless a previous call succeeded, concurrent calls wait for the running one.
+func (o *Once) Do(fn func() error) error {
+	if atomic.LoadUint32(&o.done) == 1 {
+		return nil
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.done == 1 {
+		return nil
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&o.done, 1)
+	return nil
+}
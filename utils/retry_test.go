@@ -0,0 +1,73 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	tests := []struct {
+		name     string
+		failures int
+		attempts int
+		wantErr  error
+		calls    int
+	}{
+		{"first", 0, 3, nil, 1},
+		{"recovers", 2, 3, nil, 3},
+		{"exhausted", 5, 3, errFlaky, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := Retry(context.Background(), tt.attempts, time.Millisecond, func() error {
+				calls++
+				if calls <= tt.failures {
+					return errFlaky
+				}
+				return nil
+			})
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.calls, calls)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := Retry(ctx, 3, time.Hour, func() error { return errFlaky })
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestOnce(t *testing.T) {
+	var once Once
+	calls := 0
+	fn := func() error {
+		calls++
+		if calls == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+	assert.Error(t, once.Do(fn))
+	assert.NoError(t, once.Do(fn))
+	assert.NoError(t, once.Do(fn))
+	assert.Equal(t, 2, calls)
+}
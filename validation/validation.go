@@ -0,0 +1,168 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package validation checks a configuration as a whole and reports all its problems at once,
+// each with the path of the setting and a hint to fix it, instead of failing at first use, e.g.
+//
+//	r := validation.New()
+//	r.Check("token", &tokenConf)
+//	r.Check("providers.oidc", oidcProvider)
+//	r.Check("database", &dbConf)
+//	if err := r.Err(); err != nil {
+//		log.Fatal(err)
+//	}
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/tkeel-io/security/errs"
+)
+
+// Validator a configuration section checking itself, Validate returns an *Error listing the
+// problems of the section, or nil.
+type Validator interface {
+	Validate() error
+}
+
+// Problem a setting of the configuration that is missing, malformed or inconsistent.
+type Problem struct {
+	// Field the dotted path of the setting, e.g. providers.oidc.endpoint.tokenURL.
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// Hint how to fix it.
+	Hint string `json:"hint,omitempty"`
+}
+
+func (p Problem) String() string {
+	s := p.Field + ": " + p.Message
+	if p.Hint != "" {
+		s += " (" + p.Hint + ")"
+	}
+	return s
+}
+
+// Error the problems of a configuration, it matches errs.ErrInvalidConfig with errors.Is.
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid config: " + e.Problems[0].String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid config, %d problems:", len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+func (e *Error) Is(target error) bool {
+	return target == errs.ErrInvalidConfig
+}
+
+// Report collects the problems of a configuration under a field path prefix.
+type Report struct {
+	prefix   string
+	problems *[]Problem
+}
+
+func New() *Report {
+	return &Report{problems: new([]Problem)}
+}
+
+// At returns a Report adding its problems to r under field.
+func (r *Report) At(field string) *Report {
+	return &Report{prefix: r.path(field), problems: r.problems}
+}
+
+// Add records a problem of field.
+func (r *Report) Add(field, message, hint string) {
+	*r.problems = append(*r.problems, Problem{Field: r.path(field), Message: message, Hint: hint})
+}
+
+// Required records a problem when value of field is empty.
+func (r *Report) Required(field, value, hint string) {
+	if value == "" {
+		r.Add(field, "required", hint)
+	}
+}
+
+// URL records a problem when value of field is set and not an absolute http(s) URL.
+func (r *Report) URL(field, value string) {
+	if value == "" {
+		return
+	}
+	if u, err := url.Parse(value); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		r.Add(field, fmt.Sprintf("%q is not an absolute http(s) URL", value), "use the full URL, e.g. https://idp.example.com/path")
+	}
+}
+
+// Check validates v and records its problems under field. Errors other than *Error are
+// recorded as a single problem of field.
+func (r *Report) Check(field string, v Validator) {
+	if v == nil {
+		return
+	}
+	r.Merge(field, v.Validate())
+}
+
+// Merge records the problems of err under field.
+func (r *Report) Merge(field string, err error) {
+	if err == nil {
+		return
+	}
+	var verr *Error
+	if !errors.As(err, &verr) {
+		r.Add(field, err.Error(), "")
+		return
+	}
+	for _, p := range verr.Problems {
+		p.Field = r.path(joinField(field, p.Field))
+		*r.problems = append(*r.problems, p)
+	}
+}
+
+// Problems returns the recorded problems.
+func (r *Report) Problems() []Problem {
+	return *r.problems
+}
+
+// Err returns an *Error of the recorded problems, nil when there is none.
+func (r *Report) Err() error {
+	if len(*r.problems) == 0 {
+		return nil
+	}
+	return &Error{Problems: append([]Problem(nil), *r.problems...)}
+}
+
+func (r *Report) path(field string) string {
+	return joinField(r.prefix, field)
+}
+
+func joinField(prefix, field string) string {
+	switch {
+	case prefix == "":
+		return field
+	case field == "":
+		return prefix
+	default:
+		return prefix + "." + field
+	}
+}
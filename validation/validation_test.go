@@ -0,0 +1,67 @@
+/*
+Copyright 2021 The tKeel Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tkeel-io/security/errs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type section struct {
+	host string
+	err  error
+}
+
+func (s section) Validate() error {
+	if s.err != nil {
+		return s.err
+	}
+	r := New()
+	r.Required("host", s.host, "set the host")
+	r.At("endpoint").URL("tokenURL", "/token")
+	return r.Err()
+}
+
+func TestReport(t *testing.T) {
+	r := New()
+	assert.NoError(t, r.Err())
+
+	r.Check("token", section{})
+	r.Check("cache", section{err: errors.New("redis unreachable")})
+	r.Check("store", section{host: "db"})
+	r.Required("issuer", "https://idp.example.com", "")
+	r.URL("redirectURL", "https://rp.example.com/callback")
+
+	err := r.Err()
+	assert.ErrorIs(t, err, errs.ErrInvalidConfig)
+	var verr *Error
+	assert.True(t, errors.As(err, &verr))
+	fields := make([]string, 0, len(verr.Problems))
+	for _, p := range verr.Problems {
+		fields = append(fields, p.Field)
+	}
+	assert.Equal(t, []string{"token.host", "token.endpoint.tokenURL", "cache", "store.endpoint.tokenURL"}, fields)
+	assert.Equal(t, "set the host", verr.Problems[0].Hint)
+	assert.Contains(t, err.Error(), "4 problems")
+	assert.Contains(t, err.Error(), "token.host: required (set the host)")
+}
+
+func TestErrorSingleProblem(t *testing.T) {
+	err := (&Error{Problems: []Problem{{Field: "port", Message: "invalid port"}}}).Error()
+	assert.Equal(t, "invalid config: port: invalid port", err)
+}